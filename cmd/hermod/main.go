@@ -2,20 +2,49 @@ package main
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
 	"syscall"
 	"time"
 
+	"github.com/marcgeld/hermod/internal/admin"
+	"github.com/marcgeld/hermod/internal/alert"
+	"github.com/marcgeld/hermod/internal/archive"
+	"github.com/marcgeld/hermod/internal/audit"
+	"github.com/marcgeld/hermod/internal/capability"
+	"github.com/marcgeld/hermod/internal/chaos"
 	"github.com/marcgeld/hermod/internal/config"
+	"github.com/marcgeld/hermod/internal/decoder"
+	"github.com/marcgeld/hermod/internal/dedup"
+	"github.com/marcgeld/hermod/internal/device"
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/expiry"
+	"github.com/marcgeld/hermod/internal/forward"
+	"github.com/marcgeld/hermod/internal/gap"
+	"github.com/marcgeld/hermod/internal/golden"
+	"github.com/marcgeld/hermod/internal/latest"
 	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/lookup"
 	"github.com/marcgeld/hermod/internal/mqtt"
+	"github.com/marcgeld/hermod/internal/outage"
+	"github.com/marcgeld/hermod/internal/quota"
 	"github.com/marcgeld/hermod/internal/router"
 	"github.com/marcgeld/hermod/internal/schema"
+	"github.com/marcgeld/hermod/internal/seal"
+	"github.com/marcgeld/hermod/internal/selftest"
+	"github.com/marcgeld/hermod/internal/sink"
+	"github.com/marcgeld/hermod/internal/source"
 	"github.com/marcgeld/hermod/internal/storage"
+	"github.com/marcgeld/hermod/internal/watch"
 )
 
 var (
@@ -27,17 +56,56 @@ var (
 func main() {
 	dryRun := false
 	sqlFlag := false
+	migrateFlag := false
 	configPath := flag.String("config", "config.toml", "Path to configuration file")
 	versionFlag := flag.Bool("version", false, "Print version information")
 	flag.BoolVar(&dryRun, "dry-run", false, "Don't execute SQL statements, just log them")
 	flag.BoolVar(&sqlFlag, "sql", false, "Generate SQL schema from Lua scripts and exit")
-	logLvl := flag.String("log", "", "Log level DEBUG, INFO, or ERROR (overrides config file)")
-	flag.Parse()
+	scriptFlag := flag.String("script", "", "With -sql: generate SQL only for the tables of this route script")
+	dropFlag := flag.Bool("drop", false, "With -sql: emit DROP TABLE IF EXISTS before each table")
+	noIfNotExists := flag.Bool("no-if-not-exists", false, "With -sql: use plain CREATE TABLE for every table")
+	outputFlag := flag.String("output", "", "With -sql: write the SQL to this file instead of stdout")
+	flag.BoolVar(&migrateFlag, "migrate", false, "Apply SQL schema generated from Lua scripts using the DDL role and exit")
+	logLvl := flag.String("log", "", "Log level DEBUG, INFO, WARN, or ERROR (overrides config file)")
+	backfill := flag.Bool("backfill", false, "With replay: treat messages as historical, skipping alerts and the latest-value cache")
+	updateGolden := flag.Bool("update-golden", false, "With test: write golden files instead of comparing with them")
+
+	// "hermod replay [flags] files..." re-ingests archive files instead of starting sources;
+	// "hermod capabilities" prints what this binary supports;
+	// "hermod test [flags] dir" checks route output for sample payloads against golden files;
+	// "hermod selftest" checks the broker, database and scripts before a rollout
+	args := os.Args[1:]
+	command := ""
+	if len(args) > 0 && (args[0] == "replay" || args[0] == "capabilities" || args[0] == "test" || args[0] == "selftest") {
+		command, args = args[0], args[1:]
+	}
+	replayMode := command == "replay"
+	flag.CommandLine.Parse(args)
 
 	if *versionFlag {
 		log.Printf("hermod version %s (commit: %s, built: %s)\n", version, commit, date)
 		os.Exit(0)
 	}
+	if command == "capabilities" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(capability.Discover(version)); err != nil {
+			log.Fatalf("Failed to write capabilities: %v", err)
+		}
+		return
+	}
+	if *backfill && !replayMode {
+		log.Fatal("-backfill requires the replay command")
+	}
+	if replayMode && flag.NArg() == 0 {
+		log.Fatal("Usage: hermod replay [-backfill] [-config file] archive-file...")
+	}
+	if *updateGolden && command != "test" {
+		log.Fatal("-update-golden requires the test command")
+	}
+	if command == "test" && flag.NArg() != 1 {
+		log.Fatal("Usage: hermod test [-update-golden] [-config file] samples-dir")
+	}
 
 	// Load configuration
 	cfg, err := config.Load(*configPath)
@@ -45,9 +113,30 @@ func main() {
 		log.Fatalf("Failed to load configuration: %v", err)
 	}
 
+	// Run the route samples without sources or a database
+	if command == "test" {
+		ok, err := runGolden(cfg, flag.Arg(0), *updateGolden)
+		if err != nil {
+			log.Fatalf("Test failed: %v", err)
+		}
+		if !ok {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Check everything a deployment depends on and exit
+	if command == "selftest" {
+		if !runSelftest(cfg) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	// Handle -sql flag: generate schema and exit
 	if sqlFlag {
-		if err := generateSQL(cfg); err != nil {
+		opts := sqlOptions{script: *scriptFlag, drop: *dropFlag, noIfNotExists: *noIfNotExists, output: *outputFlag}
+		if err := generateSQL(cfg, opts); err != nil {
 			log.Fatalf("Failed to generate SQL: %v", err)
 		}
 		return
@@ -67,11 +156,34 @@ func main() {
 
 	ctx := context.Background()
 
+	// Apply the generated schema with the DDL role before ingesting
+	if migrateFlag || cfg.Database.AutoMigrate {
+		if err := migrate(ctx, cfg, appLogger, dryRun); err != nil {
+			log.Fatalf("Failed to apply schema: %v", err)
+		}
+		if migrateFlag {
+			return
+		}
+	}
+
+	// Key for the files telemetry is buffered in on disk
+	diskKey, err := seal.LoadKey(cfg.Encryption.KeyFile, cfg.Encryption.KeyEnv)
+	if err != nil {
+		log.Fatalf("Failed to load encryption key: %v", err)
+	}
+
 	// Initialize storage
+	// An edge forwarding its records needs no database of its own
+	forwarding := cfg.Forward.URL != ""
+	if forwarding && cfg.Database.Host == "" {
+		if err := checkDatabaseLessEdge(cfg); err != nil {
+			log.Fatalf("Invalid configuration: %v", err)
+		}
+	}
 	storageCfg := storage.Config{
 		ConnectionString: cfg.Database.ConnectionString(),
 		TableName:        cfg.Pipeline.TableName,
-		DryRun:           dryRun,
+		DryRun:           dryRun || (forwarding && cfg.Database.Host == ""),
 		Logger:           appLogger,
 	}
 	store, err := storage.New(ctx, storageCfg)
@@ -86,67 +198,607 @@ func main() {
 	}
 
 	// Build routes from configuration
-	routes := buildRoutes(cfg)
+	routes, err := buildRoutes(cfg)
+	if err != nil {
+		log.Fatalf("Invalid route configuration: %v", err)
+	}
+
+	// Delete expired rows from plain tables whose schema declares a retention
+	if !replayMode {
+		jobs, err := expiryJobs(cfg)
+		if err != nil {
+			log.Fatalf("Failed to load table retention: %v", err)
+		}
+		if len(jobs) > 0 {
+			interval := expiry.DefaultInterval
+			if cfg.Database.ExpiryInterval != "" {
+				if interval, err = time.ParseDuration(cfg.Database.ExpiryInterval); err != nil || interval <= 0 {
+					log.Fatalf("Invalid expiry_interval %q: use a positive duration", cfg.Database.ExpiryInterval)
+				}
+			}
+			expirer := expiry.New(jobs, store, interval, appLogger)
+			expirer.Start(ctx)
+			defer expirer.Close()
+			appLogger.Infof("Deleting expired rows from %d tables every %s", len(jobs), interval)
+		}
+	}
+
+	// Track database and MQTT outages and report their impact once they end
+	outages := outage.New(outage.Config{Logger: appLogger})
+	defer outages.Close()
+
+	// Send records to a central Hermod instead of the database
+	var base router.Storage = store
+	ping := store.Ping
+	if forwarding {
+		forwardCfg := forward.ClientConfig{URL: cfg.Forward.URL, Token: cfg.Forward.Token}
+		if cfg.Forward.Timeout != "" {
+			if forwardCfg.Timeout, err = time.ParseDuration(cfg.Forward.Timeout); err != nil {
+				log.Fatalf("Invalid forward timeout: %v", err)
+			}
+		}
+		client, err := forward.NewClient(forwardCfg)
+		if err != nil {
+			log.Fatalf("Invalid forward configuration: %v", err)
+		}
+		base, ping = client, client.Ping
+		appLogger.Infof("Forwarding records to %s", cfg.Forward.URL)
+	}
+
+	// Stream stored records to admin /tail clients
+	var hub *watch.Hub
+	if cfg.Admin.Address != "" && cfg.Admin.TailToken != "" {
+		hub = watch.New()
+		base = hub.Storage(base)
+	}
+
+	// Limit rows per table and day, and stop filling a nearly full disk
+	var guard *quota.Guard
+	var alerts *alert.Engine
+	if cfg.Quota.Enabled() {
+		quotaCfg := quota.Config{
+			RowsPerDay:     cfg.Quota.RowsPerDay,
+			DiskPath:       cfg.Quota.DiskPath,
+			MinFreePercent: cfg.Quota.MinFreePercent,
+			Action:         cfg.Quota.Action,
+			SampleEvery:    cfg.Quota.SampleEvery,
+			OverflowTable:  cfg.Quota.OverflowTable,
+			Logger:         appLogger,
+			OnBreach: func(b quota.Breach) {
+				if alerts == nil {
+					return
+				}
+				key := b.Table
+				if key == "" {
+					key = cfg.Quota.DiskPath
+				}
+				alerts.Notify(alert.Event{Rule: "quota_exceeded", Key: key, Table: b.Table, Message: b.Reason},
+					cfg.Quota.Topic, cfg.Quota.Webhook)
+			},
+		}
+		if cfg.Quota.CheckInterval != "" {
+			if quotaCfg.CheckInterval, err = time.ParseDuration(cfg.Quota.CheckInterval); err != nil {
+				log.Fatalf("Invalid quota check_interval: %v", err)
+			}
+		}
+		guard, err = quota.New(quotaCfg, base)
+		if err != nil {
+			log.Fatalf("Invalid quota configuration: %v", err)
+		}
+		defer guard.Close()
+		base = guard
+	}
+
+	// Inject failures for staging tests (hidden; set HERMOD_CHAOS)
+	var injector *chaos.Injector
+	if spec := os.Getenv(chaos.EnvVar); spec != "" && !*backfill {
+		chaosCfg, err := chaos.Parse(spec)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", chaos.EnvVar, err)
+		}
+		injector = chaos.New(chaosCfg, appLogger)
+		appLogger.Warnf("CHAOS MODE ENABLED (%s): failures are injected on purpose; never use in production", chaosCfg)
+	}
+
+	// Wrap storage with the alert engine when rules are configured.
+	// Backfills skip it so historical data doesn't fire alerts.
+	var sink router.Storage = outages.Storage(base)
+	if injector != nil {
+		sink = outages.Storage(injector.Storage(base))
+	}
+	if !*backfill && (len(cfg.Alerts) > 0 || cfg.Quarantine.Topic != "" || cfg.Quarantine.Webhook != "" ||
+		cfg.Quota.Topic != "" || cfg.Quota.Webhook != "" || cfg.Gaps.Topic != "" || cfg.Gaps.Webhook != "" ||
+		cfg.ErrorBudget.Topic != "" || cfg.ErrorBudget.Webhook != "") {
+		rules, err := buildAlertRules(cfg)
+		if err != nil {
+			log.Fatalf("Invalid alert configuration: %v", err)
+		}
+		alerts, err = alert.New(rules, sink, appLogger)
+		if err != nil {
+			log.Fatalf("Failed to initialize alerts: %v", err)
+		}
+		defer alerts.Close()
+		sink = alerts
+		appLogger.Infof("Alert engine initialized with %d rules", len(rules))
+	}
+	if guard != nil {
+		guard.Start()
+	}
+
+	// Cache the latest record per device for the admin API
+	var latestCache *latest.Cache
+	if !*backfill && (cfg.Latest.Key != "" || len(cfg.Latest.Tables) > 0) {
+		latestCache = latest.New(latest.Config{Key: cfg.Latest.Key, Tables: cfg.Latest.Tables}, sink)
+		sink = latestCache
+		if cfg.Admin.Address == "" {
+			appLogger.Error("Latest-value cache is enabled but [admin] address is not set")
+		}
+	}
+
+	// Drop records the broker redelivered before they reach alerts or the database
+	if cfg.Dedup.Window != "" {
+		window, err := time.ParseDuration(cfg.Dedup.Window)
+		if err != nil {
+			log.Fatalf("Invalid dedup window: %v", err)
+		}
+		filter, err := dedup.New(dedup.Config{Window: window, Ignore: cfg.Dedup.Ignore}, sink)
+		if err != nil {
+			log.Fatalf("Invalid dedup configuration: %v", err)
+		}
+		sink = filter
+		appLogger.Infof("Dropping duplicate records within %s", window)
+	}
+
+	// Accept records forwarded by edge instances into the same chain
+	if cfg.Forward.Listen != "" && !replayMode && !*backfill {
+		receiver, err := forward.NewReceiver(forward.ReceiverConfig{
+			Address: cfg.Forward.Listen,
+			Token:   cfg.Forward.Token,
+			TLSCert: cfg.Forward.TLSCert,
+			TLSKey:  cfg.Forward.TLSKey,
+			Storage: sink,
+			Logger:  appLogger,
+		})
+		if err != nil {
+			log.Fatalf("Invalid forward configuration: %v", err)
+		}
+		if err := receiver.Start(); err != nil {
+			log.Fatalf("Failed to start forward receiver: %v", err)
+		}
+		defer receiver.Close()
+	}
+
+	// Load enrichment lookup tables
+	var routerOpts []router.Option
+	if len(cfg.Lookups) > 0 {
+		lookups := lookup.New(appLogger)
+		for _, lc := range cfg.Lookups {
+			lcfg := lookup.Config{
+				Name:    lc.Name,
+				CSV:     lc.CSV,
+				Query:   lc.Query,
+				Key:     lc.Key,
+				Querier: store,
+			}
+			if lc.Refresh != "" {
+				if lcfg.Refresh, err = time.ParseDuration(lc.Refresh); err != nil {
+					log.Fatalf("Invalid refresh for lookup %s: %v", lc.Name, err)
+				}
+			}
+			if err := lookups.Add(ctx, lcfg); err != nil {
+				log.Fatalf("Failed to load lookup: %v", err)
+			}
+		}
+		lookups.Start(ctx)
+		defer lookups.Close()
+		routerOpts = append(routerOpts, router.WithLookups(lookups))
+	}
+	if len(cfg.ModbusMaps) > 0 {
+		maps, err := modbusMaps(cfg)
+		if err != nil {
+			log.Fatalf("Invalid modbus_maps: %v", err)
+		}
+		routerOpts = append(routerOpts, router.WithModbusMaps(maps))
+	}
+
+	// Track devices when any route has a device-id expression
+	if usesDeviceRegistry(routes) {
+		var interval time.Duration
+		if cfg.Devices.FlushInterval != "" {
+			if interval, err = time.ParseDuration(cfg.Devices.FlushInterval); err != nil {
+				log.Fatalf("Invalid devices flush_interval: %v", err)
+			}
+		}
+		devices := device.New(store, interval, appLogger)
+		if !dryRun {
+			if err := devices.Load(ctx); err != nil {
+				appLogger.Errorf("Device registry starts empty: %v", err)
+			}
+		}
+		devices.Start(ctx)
+		defer devices.Close()
+		routerOpts = append(routerOpts, router.WithDeviceRegistry(devices))
+	}
+
+	// Report devices that stop reporting on routes with gap_after.
+	// Backfills skip it since archived messages arrive all at once.
+	if !*backfill && usesGapDetection(routes) {
+		gapCfg := gap.Config{
+			Table:  cfg.Gaps.Table,
+			Logger: appLogger,
+			OnEvent: func(e gap.Event) {
+				if alerts == nil || (cfg.Gaps.Topic == "" && cfg.Gaps.Webhook == "") {
+					return
+				}
+				rule, message := "device_gap", fmt.Sprintf("device %s on route %s silent for %s (expected every %s)",
+					e.Device, e.Route, e.Silent.Round(time.Second), e.Expected)
+				if e.Kind == gap.KindResumed {
+					rule, message = "device_resumed", fmt.Sprintf("device %s on route %s reported again after %s",
+						e.Device, e.Route, e.Silent.Round(time.Second))
+				}
+				alerts.Notify(alert.Event{
+					Rule:      rule,
+					Key:       e.Device,
+					Value:     e.Silent.Seconds(),
+					Condition: fmt.Sprintf("> %g", e.Expected.Seconds()),
+					Since:     e.LastSeen,
+					Message:   message,
+				}, cfg.Gaps.Topic, cfg.Gaps.Webhook)
+			},
+		}
+		if cfg.Gaps.Table != "" {
+			gapCfg.Storage = store
+		}
+		if cfg.Gaps.CheckInterval != "" {
+			if gapCfg.CheckInterval, err = time.ParseDuration(cfg.Gaps.CheckInterval); err != nil {
+				log.Fatalf("Invalid gaps check_interval: %v", err)
+			}
+		}
+		gaps, err := gap.New(gapCfg)
+		if err != nil {
+			log.Fatalf("Invalid gaps configuration: %v", err)
+		}
+		gaps.Start(ctx)
+		defer gaps.Close()
+		routerOpts = append(routerOpts, router.WithGapDetector(gaps))
+	}
+	routerOpts = append(routerOpts, router.WithLatestWriter(store))
+
+	// Create period tables of routes with a table suffix over the DDL role's
+	// connection, audited like -migrate; edges forwarding records can't, so
+	// the central database must already have them
+	if !forwarding && usesTableSuffix(routes) {
+		ddl, err := storage.New(ctx, storage.Config{
+			ConnectionString: cfg.Database.DDLConnectionString(),
+			TableName:        cfg.Pipeline.TableName,
+			DryRun:           dryRun,
+			Logger:           appLogger,
+		})
+		if err != nil {
+			log.Fatalf("Failed to connect with DDL role for period tables: %v", err)
+		}
+		defer ddl.Close()
+		routerOpts = append(routerOpts, router.WithTableCreator(auditedTableCreator{audit.New(ddl, appLogger)}))
+	}
+
+	// Resume routes paused for schema drift once their table is fixed
+	if !storageCfg.DryRun && !forwarding {
+		routerOpts = append(routerOpts, router.WithSchemaInspector(store, 0))
+	}
+
+	// Alert once when a route is quarantined
+	routerOpts = append(routerOpts, router.WithQuarantineHandler(func(filter string, errors int, lastErr error) {
+		if alerts == nil {
+			return
+		}
+		alerts.Notify(alert.Event{
+			Rule:    "route_quarantine",
+			Key:     filter,
+			Value:   float64(errors),
+			Message: fmt.Sprintf("route %s quarantined after %d consecutive script errors: %v", filter, errors, lastErr),
+		}, cfg.Quarantine.Topic, cfg.Quarantine.Webhook)
+	}))
+
+	// Alert when a route fails a larger share of its messages than its error budget
+	budget := router.ErrorBudget{Percent: cfg.ErrorBudget.Percent, MinMessages: cfg.ErrorBudget.MinMessages}
+	if cfg.ErrorBudget.Window != "" {
+		if budget.Window, err = time.ParseDuration(cfg.ErrorBudget.Window); err != nil {
+			log.Fatalf("Invalid error_budget window: %v", err)
+		}
+	}
+	routerOpts = append(routerOpts, router.WithErrorBudget(budget, func(b router.BudgetBreach) {
+		if alerts == nil {
+			return
+		}
+		alerts.Notify(alert.Event{
+			Rule:      "error_budget",
+			Key:       b.Filter,
+			Value:     b.Rate(),
+			Condition: fmt.Sprintf("> %g%%", b.Percent),
+			Message: fmt.Sprintf("route %s failed %d of %d messages in the last %s (%.1f%%, budget %g%%)",
+				b.Filter, b.Failed, b.Total, b.Window, b.Rate(), b.Percent),
+		}, cfg.ErrorBudget.Topic, cfg.ErrorBudget.Webhook)
+	}))
+
+	// Warn before route queues overflow
+	if cfg.Queues.WarnPercent > 0 {
+		routerOpts = append(routerOpts, router.WithQueueWatermarks(router.QueueWatermarks{
+			Warn:  cfg.Queues.WarnPercent,
+			Clear: cfg.Queues.ClearPercent,
+		}))
+	}
+
+	// Keep queued messages across restarts (the spool belongs to the live service)
+	if cfg.Queues.SpoolDir != "" && !replayMode {
+		routerOpts = append(routerOpts, router.WithQueueSpool(cfg.Queues.SpoolDir), router.WithSpoolKey(diskKey))
+	}
+
+	// Cap payload sizes
+	if cfg.Limits.MaxPayload > 0 {
+		routerOpts = append(routerOpts, router.WithPayloadLimit(router.PayloadLimit{
+			MaxBytes: cfg.Limits.MaxPayload,
+			Action:   cfg.Limits.Oversize,
+		}))
+	}
+
+	// Drop unwanted topics before routing
+	if f := cfg.Filters; len(f.Deny) > 0 || len(f.DenyRegex) > 0 || f.MinQoS > 0 {
+		routerOpts = append(routerOpts, router.WithTopicFilter(router.TopicFilter{
+			Deny:      f.Deny,
+			DenyRegex: f.DenyRegex,
+			MinQoS:    f.MinQoS,
+		}))
+	}
+
+	// Open named sinks for records with sink = "name", tracking their
+	// outages like the database's
+	pings := map[string]func(context.Context) error{outage.Database: ping}
+	if len(cfg.Sinks) > 0 {
+		sinks, closeSinks, err := buildSinks(ctx, cfg, appLogger, dryRun)
+		if err != nil {
+			log.Fatalf("Invalid sink configuration: %v", err)
+		}
+		defer closeSinks()
+		for name, s := range sinks {
+			component := outage.NamedSink(name)
+			if p, ok := s.(interface{ Ping(context.Context) error }); ok {
+				pings[component] = p.Ping
+			}
+			sinks[name] = outages.ComponentStorage(component, s)
+		}
+		routerOpts = append(routerOpts, router.WithSinks(sinks))
+	}
+
+	// Normalize topics before routing
+	rewrites := make([]router.TopicRewrite, 0, len(cfg.Rewrites))
+	for _, rc := range cfg.Rewrites {
+		rewrites = append(rewrites, router.TopicRewrite{StripPrefix: rc.StripPrefix, Match: rc.Match, Replace: rc.Replace})
+	}
+	if len(rewrites) > 0 {
+		routerOpts = append(routerOpts, router.WithTopicRewrites(rewrites))
+	}
+
+	// Decode JSON numbers
+	switch cfg.JSON.Numbers {
+	case "", "exact":
+	case "float":
+		routerOpts = append(routerOpts, router.WithFloatNumbers())
+	default:
+		log.Fatalf("Invalid json numbers %q: use exact or float", cfg.JSON.Numbers)
+	}
+	if cfg.Columns.Case != "" {
+		routerOpts = append(routerOpts, router.WithColumnCase(cfg.Columns.Case))
+	}
+	if cfg.Provenance.Enabled {
+		instance := cfg.Provenance.Instance
+		if instance == "" {
+			instance, _ = os.Hostname()
+		}
+		routerOpts = append(routerOpts, router.WithProvenance(router.Provenance{Instance: instance}))
+	}
+	if cfg.Passthrough.Checksum {
+		routerOpts = append(routerOpts, router.WithPayloadChecksum())
+	}
+
+	// Reload route scripts when their files change
+	if cfg.Scripts.WatchInterval != "" {
+		interval, err := time.ParseDuration(cfg.Scripts.WatchInterval)
+		if err != nil {
+			log.Fatalf("Invalid scripts watch_interval: %v", err)
+		}
+		routerOpts = append(routerOpts, router.WithScriptWatch(interval))
+	}
+	if cfg.Scripts.SlowThreshold != "" {
+		threshold, err := time.ParseDuration(cfg.Scripts.SlowThreshold)
+		if err != nil || threshold <= 0 {
+			log.Fatalf("Invalid scripts slow_threshold %q: use a positive duration", cfg.Scripts.SlowThreshold)
+		}
+		routerOpts = append(routerOpts, router.WithSlowTransform(threshold))
+	}
+	if cfg.Scripts.Timeout != "" {
+		timeout, err := time.ParseDuration(cfg.Scripts.Timeout)
+		if err != nil || timeout <= 0 {
+			log.Fatalf("Invalid scripts timeout %q: use a positive duration", cfg.Scripts.Timeout)
+		}
+		routerOpts = append(routerOpts, router.WithScriptTimeout(timeout))
+	}
 
 	// Initialize router
-	r, err := router.New(ctx, routes, store, appLogger)
+	if injector != nil {
+		routerOpts = append(routerOpts, router.WithBeforeTransform(injector.Delay))
+	}
+
+	r, err := router.New(ctx, routes, sink, appLogger, routerOpts...)
 	if err != nil {
 		log.Fatalf("Failed to initialize router: %v", err)
 	}
 	defer r.Close()
 	appLogger.Info("Router initialized successfully")
+	outages.SetBacklog(func() int {
+		queued := 0
+		for _, st := range r.RouteStatus() {
+			queued += st.QueueLength
+		}
+		return queued
+	})
 
-	// Initialize MQTT client
-	mqttCfg := mqtt.Config{
-		Broker:   cfg.MQTT.Broker,
-		ClientID: cfg.MQTT.ClientID,
-		Username: cfg.MQTT.Username,
-		Password: cfg.MQTT.Password,
-		QoS:      cfg.MQTT.QoS,
-		Logger:   appLogger,
+	// Re-ingest archived payloads and exit
+	if replayMode {
+		if err := replay(r, flag.Args(), diskKey, appLogger); err != nil {
+			appLogger.Errorf("Replay failed: %v", err)
+		}
+		return
 	}
-	client, err := mqtt.New(mqttCfg)
-	if err != nil {
-		log.Fatalf("Failed to initialize MQTT client: %v", err)
+
+	// Start the admin API
+	if cfg.Admin.Address != "" {
+		adminSrv, err := admin.New(admin.Config{Address: cfg.Admin.Address, Logger: appLogger})
+		if err != nil {
+			log.Fatalf("Failed to initialize admin API: %v", err)
+		}
+		admin.RegisterRoutes(adminSrv, r)
+		admin.RegisterTap(adminSrv, r, cfg.Admin.TailToken)
+		admin.RegisterCapabilities(adminSrv, capability.Discover(version))
+		if latestCache != nil {
+			admin.RegisterLatest(adminSrv, latestCache)
+		}
+		admin.RegisterOutages(adminSrv, outages)
+		if hub != nil {
+			admin.RegisterTail(adminSrv, hub, cfg.Admin.TailToken)
+		}
+		if err := adminSrv.Start(); err != nil {
+			log.Fatalf("Failed to start admin API: %v", err)
+		}
+		defer adminSrv.Close()
 	}
-	defer client.Disconnect()
 
-	// Subscribe to topics using router
-	// If routes are configured, subscribe to each route's filter
-	// Otherwise, fall back to legacy topics from config
+	// Subscribe sources to each route's filter
+	// Fall back to legacy topics from config when no routes are configured
+	// Routes naming a [[mqtt.brokers]] entry are subscribed on that broker only
+	filters := cfg.MQTT.Topics
+	var brokerFilters map[string][]string
 	if len(routes) > 0 {
-		for _, route := range routes {
-			err := client.Subscribe(route.Filter, cfg.MQTT.QoS, func(topic string, payload []byte) error {
-				msg := router.Message{
-					Topic:   topic,
-					Payload: payload,
-					QoS:     cfg.MQTT.QoS,
-					Retain:  false, // MQTT callback doesn't provide retain flag easily
-					Time:    time.Now().UTC(),
-				}
-				return r.Dispatch(msg)
-			})
-			if err != nil {
-				log.Fatalf("Failed to subscribe to topic %s: %v", route.Filter, err)
+		filters = make([]string, 0, len(routes))
+		brokerFilters = make(map[string][]string)
+		for i, route := range routes {
+			// Configured routes are index-aligned with cfg.Routes
+			if i < len(cfg.Routes) && cfg.Routes[i].Broker != "" {
+				broker := cfg.Routes[i].Broker
+				brokerFilters[broker] = append(brokerFilters[broker], route.Filter)
+			} else {
+				filters = append(filters, route.Filter)
 			}
 		}
-	} else {
-		// Legacy mode: subscribe to topics from config
-		for _, topic := range cfg.MQTT.Topics {
-			err := client.Subscribe(topic, cfg.MQTT.QoS, func(topic string, payload []byte) error {
-				msg := router.Message{
-					Topic:   topic,
-					Payload: payload,
-					QoS:     cfg.MQTT.QoS,
-					Retain:  false,
-					Time:    time.Now().UTC(),
-				}
-				return r.Dispatch(msg)
-			})
-			if err != nil {
-				log.Fatalf("Failed to subscribe to topic %s: %v", topic, err)
+	}
+	// Rewritten topics arrive under their original names, on [mqtt] and on
+	// every named broker routes read from
+	for _, f := range router.RewriteSources(rewrites) {
+		if !slices.Contains(filters, f) {
+			filters = append(filters, f)
+		}
+		for broker, bf := range brokerFilters {
+			if !slices.Contains(bf, f) {
+				brokerFilters[broker] = append(bf, f)
+			}
+		}
+	}
+
+	// Lazy routes subscribe only while the storage they write to (the
+	// database or their sink) is reachable
+	var lazy []string
+	lazyHealth := make(map[string]string)
+	for _, rc := range cfg.Routes {
+		if !rc.LazySubscribe {
+			continue
+		}
+		component := outage.Database
+		if rc.Sink != "" {
+			component = outage.NamedSink(rc.Sink)
+		}
+		if pings[component] == nil {
+			appLogger.Warnf("Route %s: sink %s can't be probed, so lazy_subscribe is ignored", rc.Filter, rc.Sink)
+			continue
+		}
+		lazy = append(lazy, rc.Filter)
+		lazyHealth[rc.Filter] = component
+	}
+
+	// Initialize all configured sources (MQTT, NATS, listeners, tail, ...)
+	sources, err := source.Build(source.Params{
+		Config:  cfg,
+		Filters: filters,
+		Lazy:    lazy,
+		Logger:  appLogger,
+		Outages: outages,
+
+		BrokerFilters: brokerFilters,
+	})
+	if err != nil {
+		log.Fatalf("Failed to initialize sources: %v", err)
+	}
+
+	// Tee raw payloads into the archive before routing
+	var payloads *archive.Archiver
+	if cfg.Archive.Dir != "" {
+		archiveCfg := archive.Config{Dir: cfg.Archive.Dir, Key: diskKey, Logger: appLogger}
+		if cfg.Archive.FlushInterval != "" {
+			if archiveCfg.FlushInterval, err = time.ParseDuration(cfg.Archive.FlushInterval); err != nil {
+				log.Fatalf("Invalid archive flush_interval: %v", err)
+			}
+		}
+		payloads, err = archive.New(archiveCfg)
+		if err != nil {
+			log.Fatalf("Failed to initialize payload archive: %v", err)
+		}
+		defer payloads.Close()
+		appLogger.Infof("Archiving raw payloads to %s", cfg.Archive.Dir)
+	}
+
+	dispatch := func(msg router.Message) error {
+		if payloads != nil {
+			if err := payloads.Write(msg.Topic, msg.Payload, msg.Time); err != nil {
+				appLogger.Errorf("Failed to archive message from topic %s: %v", msg.Topic, err)
+			}
+		}
+		err := r.Dispatch(msg)
+		switch {
+		case err == nil:
+			outages.Accepted()
+		case errors.Is(err, errs.ErrQueueFull):
+			outages.Dropped(1)
+		}
+		if err != nil {
+			appLogger.Errorf("Error processing message from topic %s: %v", msg.Topic, err)
+		}
+		return err
+	}
+	for _, src := range sources {
+		defer src.Close()
+		if err := src.Start(ctx, dispatch); err != nil {
+			log.Fatalf("Failed to start source: %v", err)
+		}
+		if p, ok := src.(alert.Publisher); ok && alerts != nil {
+			alerts.SetPublisher(p)
+		}
+		if p, ok := src.(router.TapPublisher); ok {
+			r.SetTapPublisher(p)
+		}
+		if p, ok := src.(router.ScriptPublisher); ok {
+			r.SetScriptPublisher(p)
+		}
+	}
+	if len(lazy) > 0 {
+		gateLazyRoutes(ctx, sources, outages, lazyHealth, pings, appLogger)
+	}
+	redeliverWithheld(ctx, sources, outages, r, lazyHealth, pings)
+	if injector != nil {
+		var targets []chaos.Disconnecter
+		for _, src := range sources {
+			if d, ok := src.(chaos.Disconnecter); ok {
+				targets = append(targets, d)
 			}
 		}
+		go injector.Disconnect(ctx, targets)
 	}
 
 	appLogger.Info("hermod is running. Press Ctrl+C to exit.")
@@ -159,79 +811,989 @@ func main() {
 	appLogger.Info("Shutting down hermod...")
 }
 
-// buildRoutes creates router.Route from config
-func buildRoutes(cfg *config.Config) []router.Route {
-	if len(cfg.Routes) > 0 {
-		// Use new routes configuration
-		routes := make([]router.Route, len(cfg.Routes))
-		for i, rc := range cfg.Routes {
-			routes[i] = router.Route{
-				Filter:    rc.Filter,
-				Script:    rc.Script,
-				Workers:   rc.Workers,
-				QueueSize: rc.QueueSize,
-				Table:     rc.Table,
-			}
+// lazyProbeInterval is how often the database is pinged while lazy routes
+// are unsubscribed because of an outage
+const lazyProbeInterval = 5 * time.Second
+
+// gateLazyRoutes subscribes each lazy filter of the sources while the
+// storage its route writes to is up, and unsubscribes it during that
+// storage's outages, so its messages don't fill a queue that can't drain.
+// health maps each filter to its route's outage component: the database (or
+// the central Hermod records are forwarded to) or a named sink. Components
+// down are pinged until they recover.
+func gateLazyRoutes(ctx context.Context, sources []source.Source, outages *outage.Tracker, health map[string]string,
+	pings map[string]func(context.Context) error, appLogger *logger.Logger) {
+	var pausers []source.Pauser
+	for _, src := range sources {
+		if p, ok := src.(source.Pauser); ok {
+			pausers = append(pausers, p)
 		}
-		return routes
+	}
+	if len(pausers) == 0 {
+		return
 	}
 
-	// Backward compatibility: create a single route from legacy config
-	if cfg.Pipeline.LuaScript != "" || len(cfg.MQTT.Topics) > 0 {
-		// If only one topic, use it as filter
-		filter := "#" // Default: match all
-		if len(cfg.MQTT.Topics) == 1 {
-			filter = cfg.MQTT.Topics[0]
+	gated := make(map[string]bool)
+	for _, component := range health {
+		gated[component] = true
+	}
+	changed := make(chan struct{}, 1)
+	outages.OnChange(func(component string, up bool) {
+		if !gated[component] {
+			return
 		}
-		return []router.Route{
-			{
-				Filter:    filter,
-				Script:    cfg.Pipeline.LuaScript,
-				Workers:   1,
-				QueueSize: 100,
-				Table:     cfg.Pipeline.TableName,
-			},
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	for component := range gated {
+		if err := pings[component](ctx); err != nil {
+			outages.Down(component, err)
 		}
+		outages.Probe(component, pings[component], lazyProbeInterval)
 	}
 
-	// No routes configured, return empty (all messages go to passthrough)
-	return []router.Route{}
+	go func() {
+		for {
+			var retry <-chan time.Time
+			for filter, component := range health {
+				for _, p := range pausers {
+					if outages.Ongoing(component) {
+						p.Pause(filter)
+					} else if err := p.Resume(filter); err != nil {
+						appLogger.Errorf("Failed to subscribe lazy route %s: %v", filter, err)
+						retry = time.After(lazyProbeInterval)
+					}
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-changed:
+			case <-retry:
+			}
+		}
+	}()
 }
 
-// generateSQL loads all Lua scripts and generates SQL schema
-func generateSQL(cfg *config.Config) error {
-	var schemas []*schema.Schema
-
-	// Load schema from each route's Lua script
-	for _, route := range cfg.Routes {
-		if route.Script != "" {
-			s, err := schema.LoadFromLuaScript(route.Script)
-			if err != nil {
-				return fmt.Errorf("failed to load schema from %s: %w", route.Script, err)
-			}
-			schemas = append(schemas, s)
+// redeliverWithheld has MQTT sessions holding messages the router left
+// unacknowledged (storage unavailable, schema drift, a full queue) resume
+// once they can be stored: no storage outage, no paused route and no queue
+// more than half full. Brokers redeliver them only when the session resumes,
+// and until then they fill its in-flight window, stalling every route on the
+// connection. Storage that isn't probed for lazy routes already is probed
+// here, since nothing else proves it healthy while every message is withheld.
+func redeliverWithheld(ctx context.Context, sources []source.Source, outages *outage.Tracker, r *router.Router,
+	lazyHealth map[string]string, pings map[string]func(context.Context) error) {
+	var redeliverers []source.Redeliverer
+	for _, src := range sources {
+		if rd, ok := src.(source.Redeliverer); ok {
+			redeliverers = append(redeliverers, rd)
 		}
 	}
+	if len(redeliverers) == 0 {
+		return
+	}
 
-	// Legacy: also check pipeline.lua_script
-	if cfg.Pipeline.LuaScript != "" {
-		s, err := schema.LoadFromLuaScript(cfg.Pipeline.LuaScript)
-		if err != nil {
-			return fmt.Errorf("failed to load schema from %s: %w", cfg.Pipeline.LuaScript, err)
+	probed := make(map[string]bool)
+	for _, component := range lazyHealth {
+		probed[component] = true
+	}
+	for component, ping := range pings {
+		if !probed[component] {
+			outages.Probe(component, ping, lazyProbeInterval)
 		}
-		schemas = append(schemas, s)
+	}
+	ready := func() bool {
+		for component := range pings {
+			if outages.Ongoing(component) {
+				return false
+			}
+		}
+		for _, st := range r.RouteStatus() {
+			if st.Paused || st.QueueLength*2 > st.QueueCapacity {
+				return false
+			}
+		}
+		return true
 	}
 
-	// Merge all schemas
-	merged := schema.Merge(schemas...)
+	go func() {
+		ticker := time.NewTicker(lazyProbeInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for _, rd := range redeliverers {
+				if rd.Withheld() > 0 && ready() {
+					rd.Redeliver()
+				}
+			}
+		}
+	}()
+}
+
+// replay dispatches the messages in archive files in order, waiting while
+// route queues are full, and returns once every message has been processed
+func replay(r *router.Router, paths []string, key []byte, appLogger *logger.Logger) error {
+	total := 0
+	for _, path := range paths {
+		n := 0
+		err := archive.ReadFile(path, key, func(e archive.Entry) error {
+			payload, err := e.Data()
+			if err != nil {
+				return fmt.Errorf("invalid payload from %s at %s: %w", e.Topic, e.Time, err)
+			}
+			msg := router.Message{Topic: e.Topic, Payload: payload, Time: e.Time}
+			for {
+				err := r.Dispatch(msg)
+				if errors.Is(err, errs.ErrQueueFull) {
+					time.Sleep(10 * time.Millisecond)
+					continue
+				}
+				if err != nil {
+					appLogger.Errorf("Error processing message from topic %s: %v", msg.Topic, err)
+				}
+				break
+			}
+			n++
+			return nil
+		})
+		total += n
+		if err != nil {
+			r.Drain()
+			return err
+		}
+		appLogger.Infof("Replayed %d messages from %s", n, path)
+	}
+	r.Drain()
+	appLogger.Infof("Replayed %d messages from %d files", total, len(paths))
+	return nil
+}
+
+// discardStorage accepts and drops everything; routes under "hermod test"
+// need storage to be constructed but never write to it
+type discardStorage struct{}
+
+func (discardStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	return nil
+}
+
+func (discardStorage) UpsertLatest(ctx context.Context, table, key string, data map[string]interface{}) error {
+	return nil
+}
+
+func (discardStorage) CreateTableLike(ctx context.Context, table, like string) error {
+	return nil
+}
+
+func (discardStorage) Exec(ctx context.Context, query string, args ...interface{}) error {
+	return nil
+}
+
+func (discardStorage) QueryRows(ctx context.Context, query string) ([]string, []map[string]interface{}, error) {
+	return nil, nil, nil
+}
+
+// runGolden runs the samples in dir through the configured routes and
+// compares their records with the golden files (or writes them with update).
+// It reports whether every sample passed.
+func runGolden(cfg *config.Config, dir string, update bool) (bool, error) {
+	r, err := sampleRouter(context.Background(), cfg, logger.New(logger.WARN))
+	if err != nil {
+		return false, err
+	}
+	defer r.Close()
+
+	outcomes, err := golden.Run(dir, update, r.TransformSample)
+	if err != nil {
+		return false, err
+	}
+	failed := 0
+	for _, o := range outcomes {
+		switch o.Status {
+		case golden.Failed:
+			failed++
+			fmt.Printf("FAIL %s\n--- want\n%s--- got\n%s", o.Sample, o.Want, o.Got)
+		case golden.Missing:
+			failed++
+			fmt.Printf("MISSING %s (run with -update-golden to record it)\n", o.Sample)
+		default:
+			fmt.Printf("%s %s\n", strings.ToUpper(o.Status), o.Sample)
+		}
+	}
+	fmt.Printf("%d samples, %d failed\n", len(outcomes), failed)
+	return failed == 0, nil
+}
+
+// selftestTopic is the topic the broker check publishes its probe to
+const selftestTopic = "hermod/selftest"
+
+// selftestTimeout bounds each self-test check
+const selftestTimeout = 15 * time.Second
+
+// runSelftest connects to the broker and the database, loads every script
+// and runs each route's transform on a synthetic message, then prints a
+// pass/fail report. It reports whether every check passed.
+func runSelftest(cfg *config.Config) bool {
+	appLogger := logger.New(logger.ERROR)
+	ctx := context.Background()
+	var r *router.Router
+
+	checks := []selftest.Check{
+		{Name: "mqtt broker", Run: func(ctx context.Context) (string, error) {
+			mc := cfg.MQTT
+			if mc.Broker == "" {
+				return "", selftest.Skipped("no broker configured")
+			}
+			// A separate client ID, so a running instance keeps its session
+			client, err := mqtt.New(mqtt.Config{
+				Broker:   mc.Broker,
+				ClientID: mc.ClientID + "-selftest",
+				Suffix:   mqtt.SuffixRandom,
+				Username: mc.Username,
+				Password: mc.Password,
+				QoS:      mc.QoS,
+				Logger:   appLogger,
+
+				CACert:             mc.CACert,
+				ClientCert:         mc.ClientCert,
+				ClientKey:          mc.ClientKey,
+				ClientKeyPassword:  mc.ClientKeyPassword,
+				InsecureSkipVerify: mc.InsecureSkipVerify,
+				ServerName:         mc.ServerName,
+
+				Headers: mc.Headers,
+				Token:   mc.Token,
+			})
+			if err != nil {
+				return "", err
+			}
+			defer client.Close()
+			if err := client.Loopback(ctx, selftestTopic); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%s, loopback on %s", mc.Broker, selftestTopic), nil
+		}},
+		{Name: "database", Run: func(ctx context.Context) (string, error) {
+			store, err := storage.New(ctx, storage.Config{
+				ConnectionString: cfg.Database.ConnectionString(),
+				TableName:        cfg.Pipeline.TableName,
+				Logger:           appLogger,
+			})
+			if err != nil {
+				return "", err
+			}
+			store.Close()
+			return "ping ok", nil
+		}},
+		{Name: "scripts", Run: func(ctx context.Context) (string, error) {
+			var err error
+			if r, err = sampleRouter(ctx, cfg, appLogger); err != nil {
+				return "", err
+			}
+			return fmt.Sprintf("%d routes loaded", len(cfg.Routes)), nil
+		}},
+	}
+	for _, route := range cfg.Routes {
+		filter := route.Filter
+		checks = append(checks, selftest.Check{Name: "transform " + filter, Run: func(ctx context.Context) (string, error) {
+			if r == nil {
+				return "", selftest.Skipped("scripts failed to load")
+			}
+			msg := router.Message{Topic: syntheticTopic(filter), Payload: []byte(`{}`), Time: time.Now().UTC()}
+			handler, records, err := r.TransformSample(msg)
+			if err != nil {
+				return "", fmt.Errorf("topic %s: %w", msg.Topic, err)
+			}
+			if handler != filter {
+				return "", selftest.Skipped(fmt.Sprintf("topic %s is handled by route %s", msg.Topic, handler))
+			}
+			return fmt.Sprintf("topic %s, %d records", msg.Topic, len(records)), nil
+		}})
+	}
+
+	results := selftest.Run(ctx, checks, selftestTimeout)
+	if r != nil {
+		r.Close()
+	}
+	return selftest.Report(os.Stdout, results)
+}
+
+// syntheticTopic returns a topic matching filter, with "selftest" for wildcards
+func syntheticTopic(filter string) string {
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		if level == "+" || level == "#" {
+			levels[i] = "selftest"
+		}
+	}
+	return strings.Join(levels, "/")
+}
+
+// sampleRouter builds the configured routes for transforming samples, with
+// no sources and no database
+func sampleRouter(ctx context.Context, cfg *config.Config, appLogger *logger.Logger) (*router.Router, error) {
+	routes, err := buildRoutes(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid route configuration: %w", err)
+	}
 
-	// Generate SQL
-	sql := merged.GenerateSQL()
+	// Options that change what transforms see; sinks, lookups and the device
+	// registry are stand-ins so scripts using them load without a database
+	var opts []router.Option
+	if cfg.JSON.Numbers == "float" {
+		opts = append(opts, router.WithFloatNumbers())
+	}
+	if cfg.Passthrough.Checksum {
+		opts = append(opts, router.WithPayloadChecksum())
+	}
+	if cfg.Columns.Case != "" {
+		opts = append(opts, router.WithColumnCase(cfg.Columns.Case))
+	}
+	if cfg.Scripts.Timeout != "" {
+		timeout, err := time.ParseDuration(cfg.Scripts.Timeout)
+		if err != nil || timeout <= 0 {
+			return nil, fmt.Errorf("invalid scripts timeout %q: use a positive duration", cfg.Scripts.Timeout)
+		}
+		opts = append(opts, router.WithScriptTimeout(timeout))
+	}
+	if len(cfg.Rewrites) > 0 {
+		rewrites := make([]router.TopicRewrite, 0, len(cfg.Rewrites))
+		for _, rc := range cfg.Rewrites {
+			rewrites = append(rewrites, router.TopicRewrite{StripPrefix: rc.StripPrefix, Match: rc.Match, Replace: rc.Replace})
+		}
+		opts = append(opts, router.WithTopicRewrites(rewrites))
+	}
+	if len(cfg.Sinks) > 0 {
+		sinks := make(map[string]router.Storage, len(cfg.Sinks))
+		for _, sc := range cfg.Sinks {
+			sinks[sc.Name] = discardStorage{}
+		}
+		opts = append(opts, router.WithSinks(sinks))
+	}
+	if len(cfg.Lookups) > 0 {
+		lookups := lookup.New(appLogger)
+		for _, lc := range cfg.Lookups {
+			if lc.CSV == "" {
+				appLogger.Warnf("Lookup %s reads the database and is empty in tests", lc.Name)
+				continue
+			}
+			if err := lookups.Add(ctx, lookup.Config{Name: lc.Name, CSV: lc.CSV, Key: lc.Key}); err != nil {
+				return nil, fmt.Errorf("failed to load lookup: %w", err)
+			}
+		}
+		opts = append(opts, router.WithLookups(lookups))
+	}
+	if len(cfg.ModbusMaps) > 0 {
+		maps, err := modbusMaps(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modbus_maps: %w", err)
+		}
+		opts = append(opts, router.WithModbusMaps(maps))
+	}
+	if usesDeviceRegistry(routes) {
+		opts = append(opts, router.WithDeviceRegistry(device.New(discardStorage{}, 0, appLogger)))
+	}
+	opts = append(opts, router.WithLatestWriter(discardStorage{}), router.WithTableCreator(discardStorage{}))
+
+	r, err := router.New(ctx, routes, discardStorage{}, appLogger, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize router: %w", err)
+	}
+	return r, nil
+}
+
+// buildSinks opens the configured named sinks; the returned function closes them
+func buildSinks(ctx context.Context, cfg *config.Config, appLogger *logger.Logger, dryRun bool) (map[string]router.Storage, func(), error) {
+	sinks := make(map[string]router.Storage, len(cfg.Sinks))
+	var closers []func()
+	closeAll := func() {
+		for _, c := range closers {
+			c()
+		}
+	}
+
+	for _, sc := range cfg.Sinks {
+		if sc.Name == "" {
+			closeAll()
+			return nil, nil, fmt.Errorf("sink name is required")
+		}
+		if _, ok := sinks[sc.Name]; ok {
+			closeAll()
+			return nil, nil, fmt.Errorf("duplicate sink %s", sc.Name)
+		}
+		switch sc.Type {
+		case sink.TypePostgres:
+			db := sc.SinkDatabase(cfg.Database)
+			s, err := storage.New(ctx, storage.Config{
+				ConnectionString: db.ConnectionString(),
+				TableName:        cfg.Pipeline.TableName,
+				DryRun:           dryRun,
+				Logger:           appLogger,
+			})
+			if err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("sink %s: %w", sc.Name, err)
+			}
+			closers = append(closers, s.Close)
+			sinks[sc.Name] = s
+		case sink.TypeJSONL:
+			s, err := sink.NewJSONLines(sc.Path)
+			if err != nil {
+				closeAll()
+				return nil, nil, fmt.Errorf("sink %s: %w", sc.Name, err)
+			}
+			closers = append(closers, func() { s.Close() })
+			sinks[sc.Name] = s
+		default:
+			closeAll()
+			return nil, nil, fmt.Errorf("sink %s: invalid type %q: use postgres or jsonl", sc.Name, sc.Type)
+		}
+		appLogger.Infof("Sink %s (%s) initialized", sc.Name, sc.Type)
+	}
+	return sinks, closeAll, nil
+}
+
+// buildRoutes creates router.Route from config
+func buildRoutes(cfg *config.Config) ([]router.Route, error) {
+	if len(cfg.Routes) > 0 {
+		// Use new routes configuration
+		routes := make([]router.Route, len(cfg.Routes))
+		for i, rc := range cfg.Routes {
+			routes[i] = router.Route{
+				Filter:    rc.Filter,
+				Script:    rc.Script,
+				Workers:   rc.Workers,
+				QueueSize: rc.QueueSize,
+				Table:     rc.Table,
+				DeviceID:  rc.DeviceID,
+
+				Retained:   rc.Retained,
+				StateTable: rc.StateTable,
+
+				QuarantineAfter: rc.QuarantineAfter,
+				LuaRecycle:      rc.LuaRecycle,
+
+				PayloadFormat:   rc.PayloadFormat,
+				PayloadEncoding: rc.PayloadEncoding,
+				PayloadSchema:   rc.PayloadSchema,
+				RejectTable:     rc.RejectTable,
+				MaxRecords:      rc.MaxRecords,
+
+				PassthroughTable: rc.PassthroughTable,
+
+				LatestKey: rc.LatestKey,
+				Tags:      rc.Tags,
+
+				Priority: rc.PriorityClass,
+
+				UnknownColumns: rc.UnknownColumns,
+
+				TableSuffix: rc.TableSuffix,
+
+				Sink: rc.Sink,
+
+				ErrorBudget: rc.ErrorBudget,
+			}
+			if rc.Downsample != nil {
+				interval, err := time.ParseDuration(rc.Downsample.Interval)
+				if err != nil {
+					return nil, fmt.Errorf("route %s: invalid downsample interval: %w", rc.Filter, err)
+				}
+				routes[i].Downsample = &router.Downsample{
+					Interval: interval,
+					Agg:      rc.Downsample.Agg,
+				}
+			}
+			if rc.Reorder != "" {
+				window, err := time.ParseDuration(rc.Reorder)
+				if err != nil {
+					return nil, fmt.Errorf("route %s: invalid reorder window: %w", rc.Filter, err)
+				}
+				routes[i].Reorder = window
+			}
+			if rc.GapAfter != "" {
+				after, err := time.ParseDuration(rc.GapAfter)
+				if err != nil {
+					return nil, fmt.Errorf("route %s: invalid gap_after: %w", rc.Filter, err)
+				}
+				routes[i].GapAfter = after
+			}
+			if rc.ProcessingTimeout != "" {
+				timeout, err := time.ParseDuration(rc.ProcessingTimeout)
+				if err != nil {
+					return nil, fmt.Errorf("route %s: invalid processing_timeout: %w", rc.Filter, err)
+				}
+				routes[i].ProcessingTimeout = timeout
+			}
+			if rc.Batch != nil {
+				routes[i].Batch = &router.Batch{Size: rc.Batch.Size}
+				if rc.Batch.Linger != "" {
+					linger, err := time.ParseDuration(rc.Batch.Linger)
+					if err != nil {
+						return nil, fmt.Errorf("route %s: invalid batch linger: %w", rc.Filter, err)
+					}
+					routes[i].Batch.Linger = linger
+				}
+				if rc.Batch.Chunk != "" {
+					chunk, err := time.ParseDuration(rc.Batch.Chunk)
+					if err != nil {
+						return nil, fmt.Errorf("route %s: invalid batch chunk: %w", rc.Filter, err)
+					}
+					routes[i].Batch.Chunk = chunk
+				}
+				routes[i].Batch.Partition = rc.Batch.Partition
+			}
+			if rc.MaxPayload > 0 || rc.Oversize != "" {
+				limit := router.PayloadLimit{MaxBytes: cfg.Limits.MaxPayload, Action: cfg.Limits.Oversize}
+				if rc.MaxPayload > 0 {
+					limit.MaxBytes = rc.MaxPayload
+				}
+				if rc.Oversize != "" {
+					limit.Action = rc.Oversize
+				}
+				routes[i].PayloadLimit = &limit
+			}
+			if len(rc.Deny) > 0 || len(rc.DenyRegex) > 0 || rc.MinQoS > 0 {
+				routes[i].TopicFilter = &router.TopicFilter{
+					Deny:      rc.Deny,
+					DenyRegex: rc.DenyRegex,
+					MinQoS:    rc.MinQoS,
+				}
+			}
+			if rc.Timestamp != nil {
+				routes[i].Timestamp = &router.Timestamp{Field: rc.Timestamp.Field, Unit: rc.Timestamp.Unit}
+				if rc.Timestamp.Timezone != "" {
+					loc, err := time.LoadLocation(rc.Timestamp.Timezone)
+					if err != nil {
+						return nil, fmt.Errorf("route %s: invalid timestamp timezone: %w", rc.Filter, err)
+					}
+					routes[i].Timestamp.Location = loc
+				}
+			}
+			if rc.Mask != nil {
+				routes[i].Mask = &router.Mask{
+					Columns:  rc.Mask.Columns,
+					Strategy: rc.Mask.Strategy,
+					Salt:     rc.Mask.Salt,
+					Keep:     rc.Mask.Keep,
+				}
+			}
+		}
+		return routes, nil
+	}
+
+	// Backward compatibility: create a single route from legacy config
+	if cfg.Pipeline.LuaScript != "" || len(cfg.MQTT.Topics) > 0 {
+		// If only one topic, use it as filter
+		filter := "#" // Default: match all
+		if len(cfg.MQTT.Topics) == 1 {
+			filter = cfg.MQTT.Topics[0]
+		}
+		return []router.Route{
+			{
+				Filter:    filter,
+				Script:    cfg.Pipeline.LuaScript,
+				Workers:   1,
+				QueueSize: 100,
+				Table:     cfg.Pipeline.TableName,
+			},
+		}, nil
+	}
+
+	// No routes configured, return empty (all messages go to passthrough)
+	return []router.Route{}, nil
+}
+
+// modbusMaps converts and validates the configured Modbus register maps
+func modbusMaps(cfg *config.Config) (map[string]decoder.ModbusMap, error) {
+	maps := make(map[string]decoder.ModbusMap, len(cfg.ModbusMaps))
+	for _, mc := range cfg.ModbusMaps {
+		if mc.Name == "" {
+			return nil, fmt.Errorf("modbus map without a name")
+		}
+		if _, dup := maps[mc.Name]; dup {
+			return nil, fmt.Errorf("duplicate modbus map %s", mc.Name)
+		}
+		m := decoder.ModbusMap{Start: mc.Start}
+		for _, fc := range mc.Fields {
+			m.Fields = append(m.Fields, decoder.ModbusField{
+				Name:     fc.Name,
+				Register: fc.Register,
+				Type:     fc.Type,
+				Order:    fc.Order,
+				Scale:    fc.Scale,
+				Offset:   fc.Offset,
+			})
+		}
+		if err := m.Validate(); err != nil {
+			return nil, fmt.Errorf("modbus map %s: %w", mc.Name, err)
+		}
+		maps[mc.Name] = m
+	}
+	return maps, nil
+}
+
+// usesDeviceRegistry reports whether any route tracks devices
+func usesDeviceRegistry(routes []router.Route) bool {
+	for _, route := range routes {
+		if route.DeviceID != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// usesGapDetection reports whether any route reports device gaps
+func usesGapDetection(routes []router.Route) bool {
+	for _, route := range routes {
+		if route.GapAfter > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDatabaseLessEdge rejects settings that write to the database directly
+// rather than through the forwarded record stream, since an edge without a
+// database of its own has nowhere to put them
+func checkDatabaseLessEdge(cfg *config.Config) error {
+	for _, rc := range cfg.Routes {
+		switch {
+		case rc.LatestKey != "":
+			return fmt.Errorf("route %s: latest_key needs a database for <table>_latest; give the edge a [database] host", rc.Filter)
+		case rc.DeviceID != "":
+			return fmt.Errorf("route %s: device_id needs a database for hermod_devices; give the edge a [database] host", rc.Filter)
+		}
+	}
+	if cfg.Gaps.Table != "" {
+		return fmt.Errorf("gaps: table needs a database; give the edge a [database] host")
+	}
+	return nil
+}
+
+// usesTableSuffix reports whether any route writes into period tables
+func usesTableSuffix(routes []router.Route) bool {
+	for _, route := range routes {
+		if route.TableSuffix != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// auditedTableCreator creates period tables over the DDL role's connection,
+// recording each statement in hermod_ddl_audit, so the ingest role needs no
+// CREATE privilege
+type auditedTableCreator struct {
+	log *audit.Log
+}
+
+func (c auditedTableCreator) CreateTableLike(ctx context.Context, table, like string) error {
+	query, err := storage.CreateTableLikeSQL(table, like)
+	if err != nil {
+		return err
+	}
+	return c.log.Apply(ctx, []audit.Statement{{SQL: query}})
+}
+
+// buildAlertRules creates alert.Rule from config
+func buildAlertRules(cfg *config.Config) ([]alert.Rule, error) {
+	rules := make([]alert.Rule, 0, len(cfg.Alerts))
+	for _, ac := range cfg.Alerts {
+		rule := alert.Rule{
+			Name:      ac.Name,
+			Table:     ac.Table,
+			Column:    ac.Column,
+			Condition: ac.Condition,
+			Key:       ac.Key,
+			Topic:     ac.Topic,
+			Webhook:   ac.Webhook,
+		}
+		var err error
+		if ac.For != "" {
+			if rule.For, err = time.ParseDuration(ac.For); err != nil {
+				return nil, fmt.Errorf("alert %s: invalid for: %w", ac.Name, err)
+			}
+		}
+		if ac.Debounce != "" {
+			if rule.Debounce, err = time.ParseDuration(ac.Debounce); err != nil {
+				return nil, fmt.Errorf("alert %s: invalid debounce: %w", ac.Name, err)
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// generateSQL loads all Lua scripts, or only opts.script when set, and
+// prints the SQL schema or writes it to opts.output
+func generateSQL(cfg *config.Config, opts sqlOptions) error {
+	sql, err := schemaSQL(cfg, opts)
+	if err != nil {
+		return err
+	}
 	if sql == "" {
-		fmt.Println("-- No schemas defined in Lua scripts")
+		sql = "-- No schemas defined in Lua scripts"
+	}
+
+	if opts.output == "" {
+		fmt.Println(sql)
+		return nil
+	}
+	if err := os.WriteFile(opts.output, []byte(sql+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write SQL: %w", err)
+	}
+	log.Printf("Wrote SQL schema to %s", opts.output)
+	return nil
+}
+
+// migrate applies the SQL schema over a separate connection using the DDL role,
+// so the ingest connection pool only needs insert privileges. Every statement
+// is recorded in the hermod_ddl_audit table.
+func migrate(ctx context.Context, cfg *config.Config, appLogger *logger.Logger, dryRun bool) error {
+	stmts, err := schemaStatements(cfg, sqlOptions{})
+	if err != nil {
+		return err
+	}
+	if len(stmts) == 0 {
+		appLogger.Info("No schemas defined in Lua scripts; nothing to migrate")
 		return nil
 	}
 
-	fmt.Println(sql)
+	ddl, err := storage.New(ctx, storage.Config{
+		ConnectionString: cfg.Database.DDLConnectionString(),
+		TableName:        cfg.Pipeline.TableName,
+		DryRun:           dryRun,
+		Logger:           appLogger,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to connect with DDL role: %w", err)
+	}
+	defer ddl.Close()
+
+	if err := audit.New(ddl, appLogger).Apply(ctx, stmts); err != nil {
+		return err
+	}
+	appLogger.Info("Schema applied")
 	return nil
 }
+
+// schemaSQL loads all Lua scripts, or only opts.script when set, and
+// generates the SQL schema ("" when none is declared)
+func schemaSQL(cfg *config.Config, opts sqlOptions) (string, error) {
+	stmts, err := schemaStatements(cfg, opts)
+	if err != nil || len(stmts) == 0 {
+		return "", err
+	}
+	var parts []string
+	if opts.drop {
+		parts = append(parts, (&schema.TableSchema{Name: audit.TableName}).GenerateDropTable())
+	}
+	parts = append(parts, audit.CreateTableSQL(!opts.noIfNotExists))
+	for _, stmt := range stmts {
+		parts = append(parts, stmt.SQL)
+	}
+	return strings.Join(parts, "\n\n"), nil
+}
+
+// sqlOptions shapes the generated schema (the zero value generates all
+// tables with CREATE TABLE IF NOT EXISTS)
+type sqlOptions struct {
+	script        string // Only the tables of this route script (empty = all)
+	drop          bool   // DROP TABLE IF EXISTS before every table is created
+	noIfNotExists bool   // Plain CREATE TABLE for every table
+	output        string // File the SQL is written to (empty = stdout)
+}
+
+// schemaStatements loads all Lua scripts, or only opts.script when set, and
+// returns one CREATE TABLE statement per table, followed by its hypertable
+// statements when declared, attributed to the routes and scripts declaring
+// it. Scripts are loaded concurrently.
+func schemaStatements(cfg *config.Config, opts sqlOptions) ([]audit.Statement, error) {
+	script := opts.script
+	type declaration struct{ filter, script, latestKey string }
+	var decls []declaration
+	for _, route := range cfg.Routes {
+		if route.Script != "" {
+			decls = append(decls, declaration{route.Filter, route.Script, route.LatestKey})
+		}
+	}
+	// Legacy: also check pipeline.lua_script
+	if cfg.Pipeline.LuaScript != "" {
+		decls = append(decls, declaration{"", cfg.Pipeline.LuaScript, ""})
+	}
+	if script != "" {
+		var selected []declaration
+		for _, d := range decls {
+			if filepath.Clean(d.script) == filepath.Clean(script) {
+				selected = append(selected, d)
+			}
+		}
+		if len(selected) == 0 {
+			return nil, fmt.Errorf("no route uses script %s", script)
+		}
+		decls = selected
+	}
+
+	// Load each script once, however many routes share it
+	var paths []string
+	for _, d := range decls {
+		paths = appendUnique(paths, d.script)
+	}
+	loaded, err := schema.LoadFromLuaScripts(paths)
+	if err != nil {
+		return nil, err
+	}
+	byScript := make(map[string]*schema.Schema, len(paths))
+	for i, path := range paths {
+		byScript[path] = loaded[i]
+	}
+
+	schemas := make([]*schema.Schema, 0, len(decls))
+	routes := make(map[string][]string)
+	scripts := make(map[string][]string)
+	latestKeys := make(map[string]string) // table -> key of its <table>_latest companion
+	for _, d := range decls {
+		s := byScript[d.script]
+		schemas = append(schemas, s)
+		for table := range s.Tables {
+			if d.filter != "" {
+				routes[table] = append(routes[table], d.filter)
+			}
+			scripts[table] = appendUnique(scripts[table], d.script)
+			if d.latestKey != "" {
+				if key, ok := latestKeys[table]; ok && key != d.latestKey {
+					return nil, fmt.Errorf("table %s has conflicting latest keys %s and %s", table, key, d.latestKey)
+				}
+				latestKeys[table] = d.latestKey
+			}
+		}
+	}
+
+	// Merge all schemas; tables are emitted in name order
+	merged := schema.Merge(schemas...)
+	tables := make([]string, 0, len(merged.Tables))
+	for name, table := range merged.Tables {
+		tables = append(tables, name)
+		if cfg.Provenance.Enabled {
+			for _, col := range router.ProvenanceColumns {
+				if _, ok := table.Columns[col]; !ok {
+					table.Columns[col] = "TEXT"
+				}
+			}
+		}
+	}
+	sort.Strings(tables)
+
+	ifNotExists := !opts.noIfNotExists
+	var stmts []audit.Statement
+	// create emits the DDL of a table, preceded by its DROP with opts.drop
+	create := func(table, sql, route, script string) {
+		if opts.drop {
+			stmts = append(stmts, audit.Statement{
+				SQL:    (&schema.TableSchema{Name: table}).GenerateDropTable(),
+				Route:  route,
+				Script: script,
+			})
+		}
+		stmts = append(stmts, audit.Statement{SQL: sql, Route: route, Script: script})
+	}
+	for _, table := range tables {
+		route := strings.Join(routes[table], ", ")
+		script := strings.Join(scripts[table], ", ")
+		emit := func(sql string) {
+			stmts = append(stmts, audit.Statement{SQL: sql, Route: route, Script: script})
+		}
+
+		create(table, merged.Tables[table].GenerateCreateTable(ifNotExists), route, script)
+		if hypertable := merged.Tables[table].GenerateHypertable(); hypertable != "" {
+			emit(hypertable)
+		}
+		if policy := merged.Tables[table].GenerateRetentionPolicy(); policy != "" {
+			emit(policy)
+		}
+		if key, ok := latestKeys[table]; ok {
+			sql, err := merged.Tables[table].GenerateLatestTable(key, ifNotExists)
+			if err != nil {
+				return nil, err
+			}
+			create(table+"_latest", sql, route, script)
+		}
+	}
+
+	var deviceRoutes []string
+	for _, route := range cfg.Routes {
+		if route.DeviceID != "" && (script == "" || filepath.Clean(route.Script) == filepath.Clean(script)) {
+			deviceRoutes = append(deviceRoutes, route.Filter)
+		}
+	}
+	if len(deviceRoutes) > 0 {
+		create(device.TableName, device.CreateTableSQL(ifNotExists), strings.Join(deviceRoutes, ", "), "")
+	}
+	var gapRoutes []string
+	for _, route := range cfg.Routes {
+		if route.GapAfter != "" && (script == "" || filepath.Clean(route.Script) == filepath.Clean(script)) {
+			gapRoutes = append(gapRoutes, route.Filter)
+		}
+	}
+	if len(gapRoutes) > 0 && cfg.Gaps.Table != "" {
+		create(cfg.Gaps.Table, gap.CreateTableSQL(cfg.Gaps.Table, ifNotExists), strings.Join(gapRoutes, ", "), "")
+	}
+	if cfg.Quota.Enabled() && cfg.Quota.Action == quota.ActionDLQ && script == "" {
+		table := cfg.Quota.OverflowTable
+		if table == "" {
+			table = quota.DefaultOverflowTable
+		}
+		create(table, quota.CreateTableSQL(table, ifNotExists), "", "")
+	}
+	return stmts, nil
+}
+
+// expiryJobs loads all Lua scripts and returns a job deleting expired rows
+// for every plain table with a retention, in table name order
+func expiryJobs(cfg *config.Config) ([]expiry.Job, error) {
+	var paths []string
+	for _, route := range cfg.Routes {
+		if route.Script != "" {
+			paths = appendUnique(paths, route.Script)
+		}
+	}
+	if cfg.Pipeline.LuaScript != "" {
+		paths = appendUnique(paths, cfg.Pipeline.LuaScript)
+	}
+	schemas, err := schema.LoadFromLuaScripts(paths)
+	if err != nil {
+		return nil, err
+	}
+
+	merged := schema.Merge(schemas...)
+	tables := make([]string, 0, len(merged.Tables))
+	for name := range merged.Tables {
+		tables = append(tables, name)
+	}
+	sort.Strings(tables)
+
+	var jobs []expiry.Job
+	for _, table := range tables {
+		if sql := merged.Tables[table].GenerateExpiry(); sql != "" {
+			jobs = append(jobs, expiry.Job{Table: table, SQL: sql})
+		}
+	}
+	return jobs, nil
+}
+
+// appendUnique appends s unless list already contains it
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
@@ -0,0 +1,42 @@
+-- Decode raw DSMR/P1 telegrams with the built-in dsmr_decode helper.
+-- The MQTT payload is the complete telegram ("/" header ... "!" CRC).
+
+schema = {
+  tables = {
+    energy_readings = {
+      time = "timestamptz",
+      meter_id = "text",
+      energy_delivered_tariff1 = "double precision",
+      energy_delivered_tariff2 = "double precision",
+      energy_returned_tariff1 = "double precision",
+      energy_returned_tariff2 = "double precision",
+      power_delivered = "double precision",
+      power_returned = "double precision",
+      gas_delivered = "double precision"
+    }
+  }
+}
+
+function transform(msg)
+  local t, err = dsmr_decode(msg.payload)
+  if not t then
+    error("dsmr_decode: " .. err)
+  end
+
+  return {
+    {
+      table = "energy_readings",
+      columns = {
+        time = t.timestamp or msg.ts,
+        meter_id = t.equipment_id or msg.topic,
+        energy_delivered_tariff1 = t.energy_delivered_tariff1,
+        energy_delivered_tariff2 = t.energy_delivered_tariff2,
+        energy_returned_tariff1 = t.energy_returned_tariff1,
+        energy_returned_tariff2 = t.energy_returned_tariff2,
+        power_delivered = t.power_delivered,
+        power_returned = t.power_returned,
+        gas_delivered = t.gas_delivered
+      }
+    }
+  }
+end
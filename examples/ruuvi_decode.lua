@@ -0,0 +1,42 @@
+-- Decode Ruuvi Gateway messages with the built-in ruuvi_decode helper.
+-- Gateway payload: {"data": "0201061BFF990405...", "rssi": -65, ...}
+
+schema = {
+  tables = {
+    ruuvi_data = {
+      time = "timestamptz",
+      sensor_id = "text",
+      temperature = "double precision",
+      humidity = "double precision",
+      pressure = "double precision",
+      battery = "double precision",
+      rssi = "double precision"
+    }
+  }
+}
+
+function transform(msg)
+  if not msg.json or not msg.json.data then
+    return {}
+  end
+
+  local r, err = ruuvi_decode(msg.json.data)
+  if not r then
+    error("ruuvi_decode: " .. err)
+  end
+
+  return {
+    {
+      table = "ruuvi_data",
+      columns = {
+        time = msg.ts,
+        sensor_id = r.mac or msg.topic_levels[2],
+        temperature = r.temperature,
+        humidity = r.humidity,
+        pressure = r.pressure,
+        battery = r.battery,
+        rssi = msg.json.rssi
+      }
+    }
+  }
+end
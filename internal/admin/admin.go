@@ -0,0 +1,316 @@
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/marcgeld/hermod/internal/capability"
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/outage"
+	"github.com/marcgeld/hermod/internal/router"
+	"github.com/marcgeld/hermod/internal/watch"
+)
+
+// Config holds admin API configuration
+type Config struct {
+	Address string // Listen address (e.g., "127.0.0.1:8080")
+	Logger  *logger.Logger
+}
+
+// Server is the HTTP admin API
+type Server struct {
+	address  string
+	mux      *http.ServeMux
+	srv      *http.Server
+	listener net.Listener
+	logger   *logger.Logger
+}
+
+// RouteController exposes route health, quarantine control and script reloads
+type RouteController interface {
+	RouteStatus() []router.RouteStatus
+	Release(filter string) error
+	Reload(filter string) error
+}
+
+// LatestReader serves the most recent record per device
+type LatestReader interface {
+	Get(table, key string) (map[string]interface{}, bool)
+	All(table string) map[string]map[string]interface{}
+}
+
+// New creates an admin server; handlers are added before Start
+func New(cfg Config) (*Server, error) {
+	if cfg.Address == "" {
+		return nil, fmt.Errorf("admin address is required")
+	}
+	log := cfg.Logger
+	if log == nil {
+		log = logger.New(logger.INFO)
+	}
+
+	mux := http.NewServeMux()
+	return &Server{
+		address: cfg.Address,
+		mux:     mux,
+		srv:     &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second},
+		logger:  log,
+	}, nil
+}
+
+// HandleFunc registers a handler for a pattern (e.g. "GET /routes")
+func (s *Server) HandleFunc(pattern string, handler http.HandlerFunc) {
+	s.mux.HandleFunc(pattern, handler)
+}
+
+// Start begins serving requests in the background
+func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.address, err)
+	}
+	s.listener = ln
+	s.logger.Infof("Admin API listening on %s", ln.Addr())
+
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Errorf("Admin API stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Addr returns the bound address (useful with port 0)
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return s.address
+	}
+	return s.listener.Addr().String()
+}
+
+// Close shuts the server down
+func (s *Server) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.srv.Shutdown(ctx)
+}
+
+// RegisterRoutes adds the route endpoints:
+//
+//	GET  /routes                       route status (including quarantine)
+//	POST /routes/release?filter=<f>    re-enable a quarantined or paused route
+//	POST /routes/reload?filter=<f>     validate and swap in the route's edited script
+func RegisterRoutes(s *Server, rc RouteController) {
+	s.HandleFunc("GET /routes", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, rc.RouteStatus())
+	})
+	s.HandleFunc("POST /routes/release", func(w http.ResponseWriter, req *http.Request) {
+		filter := req.FormValue("filter")
+		if filter == "" {
+			writeError(w, http.StatusBadRequest, "filter is required")
+			return
+		}
+		if err := rc.Release(filter); err != nil {
+			writeError(w, http.StatusNotFound, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "released", "filter": filter})
+	})
+	s.HandleFunc("POST /routes/reload", func(w http.ResponseWriter, req *http.Request) {
+		filter := req.FormValue("filter")
+		if filter == "" {
+			writeError(w, http.StatusBadRequest, "filter is required")
+			return
+		}
+		if err := rc.Reload(filter); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, map[string]string{"status": "reloaded", "filter": filter})
+	})
+}
+
+// RegisterLatest adds the latest-value endpoint:
+//
+//	GET /latest?table=<t>               latest record of every device in table (device -> record)
+//	GET /latest?table=<t>&device=<d>    latest record of one device
+func RegisterLatest(s *Server, lr LatestReader) {
+	s.HandleFunc("GET /latest", func(w http.ResponseWriter, req *http.Request) {
+		table := req.URL.Query().Get("table")
+		if table == "" {
+			writeError(w, http.StatusBadRequest, "table is required")
+			return
+		}
+		device := req.URL.Query().Get("device")
+		if device == "" {
+			writeJSON(w, http.StatusOK, lr.All(table))
+			return
+		}
+		row, ok := lr.Get(table, device)
+		if !ok {
+			writeError(w, http.StatusNotFound, fmt.Sprintf("no record for device %s in %s", device, table))
+			return
+		}
+		writeJSON(w, http.StatusOK, row)
+	})
+}
+
+// Tapper mirrors a route's next messages for inspection
+type Tapper interface {
+	Tap(filter string, n int, topic string) error
+}
+
+// defaultTapMessages is how many messages a tap mirrors when n isn't given
+const defaultTapMessages = 10
+
+// RegisterTap adds the tap endpoint. Mirroring to a topic publishes to the
+// broker, so it needs token like /tail does:
+//
+//	POST /routes/tap?filter=<f>[&n=<count>][&topic=<t>]    mirror the route's next messages
+//	                                                      (default 10) to stdout or a debug topic
+func RegisterTap(s *Server, t Tapper, token string) {
+	s.HandleFunc("POST /routes/tap", func(w http.ResponseWriter, req *http.Request) {
+		filter := req.FormValue("filter")
+		if filter == "" {
+			writeError(w, http.StatusBadRequest, "filter is required")
+			return
+		}
+		n := defaultTapMessages
+		if v := req.FormValue("n"); v != "" {
+			var err error
+			if n, err = strconv.Atoi(v); err != nil {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid n %q", v))
+				return
+			}
+		}
+		topic := req.FormValue("topic")
+		if topic != "" && !authorized(req, token) {
+			writeError(w, http.StatusUnauthorized, "tapping to a topic requires a valid token")
+			return
+		}
+		if err := t.Tap(filter, n, topic); err != nil {
+			writeError(w, http.StatusUnprocessableEntity, err.Error())
+			return
+		}
+		dest := topic
+		if dest == "" {
+			dest = "stdout"
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"status": "tapping", "filter": filter, "n": n, "output": dest})
+	})
+}
+
+// RecordStream streams stored records of a table (watch.Hub)
+type RecordStream interface {
+	Subscribe(table string, every int) (<-chan watch.Record, func())
+}
+
+// tailWriteTimeout bounds sending one record to a /tail client
+const tailWriteTimeout = 10 * time.Second
+
+// RegisterTail adds the live-tail WebSocket, authenticated with token
+// (as "Authorization: Bearer <token>" or ?token=<token>):
+//
+//	GET /tail?table=<t>[&every=<n>]    stream records as they are stored in table,
+//	                                   one in every n (default 1), as JSON messages
+func RegisterTail(s *Server, rs RecordStream, token string) {
+	upgrader := websocket.Upgrader{}
+	s.HandleFunc("GET /tail", func(w http.ResponseWriter, req *http.Request) {
+		if !authorized(req, token) {
+			writeError(w, http.StatusUnauthorized, "invalid or missing token")
+			return
+		}
+		table := req.URL.Query().Get("table")
+		if table == "" {
+			writeError(w, http.StatusBadRequest, "table is required")
+			return
+		}
+		every := 1
+		if v := req.URL.Query().Get("every"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				writeError(w, http.StatusBadRequest, fmt.Sprintf("invalid every %q", v))
+				return
+			}
+			every = n
+		}
+
+		conn, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return // The upgrader has replied
+		}
+		defer conn.Close()
+		records, stop := rs.Subscribe(table, every)
+		defer stop()
+		s.logger.Infof("Admin API: %s tailing %s", req.RemoteAddr, table)
+
+		// Reading detects the client going away; messages from it are ignored
+		go func() {
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					stop()
+					return
+				}
+			}
+		}()
+		for rec := range records {
+			conn.SetWriteDeadline(time.Now().Add(tailWriteTimeout))
+			if err := conn.WriteJSON(rec); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// authorized reports whether req carries token
+func authorized(req *http.Request, token string) bool {
+	got := req.FormValue("token")
+	if h := req.Header.Get("Authorization"); strings.HasPrefix(h, "Bearer ") {
+		got = strings.TrimPrefix(h, "Bearer ")
+	}
+	return token != "" && subtle.ConstantTimeCompare([]byte(got), []byte(token)) == 1
+}
+
+// OutageReader lists ongoing and recent outages
+type OutageReader interface {
+	Reports() []outage.Report
+}
+
+// RegisterOutages adds the outage endpoint:
+//
+//	GET /outages    ongoing and recent MQTT and database outages with their catch-up reports
+func RegisterOutages(s *Server, or OutageReader) {
+	s.HandleFunc("GET /outages", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, or.Reports())
+	})
+}
+
+// RegisterCapabilities adds the capability endpoint:
+//
+//	GET /capabilities    sources, sinks and Lua helpers compiled into the binary
+func RegisterCapabilities(s *Server, caps capability.Set) {
+	s.HandleFunc("GET /capabilities", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, caps)
+	})
+}
+
+// writeJSON writes v as a JSON response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes a JSON error response
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,351 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/marcgeld/hermod/internal/capability"
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/outage"
+	"github.com/marcgeld/hermod/internal/router"
+	"github.com/marcgeld/hermod/internal/watch"
+)
+
+// mockRoutes is an in-memory RouteController
+type mockRoutes struct {
+	status []router.RouteStatus
+}
+
+func (m *mockRoutes) RouteStatus() []router.RouteStatus {
+	return m.status
+}
+
+func (m *mockRoutes) Release(filter string) error {
+	for i := range m.status {
+		if m.status[i].Filter == filter {
+			m.status[i].Quarantined = false
+			return nil
+		}
+	}
+	return fmt.Errorf("route %s not found", filter)
+}
+
+func (m *mockRoutes) Reload(filter string) error {
+	for _, st := range m.status {
+		if st.Filter == filter {
+			if st.Script == "broken.lua" {
+				return fmt.Errorf("script %s rejected", st.Script)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("route %s not found", filter)
+}
+
+func TestRouteEndpoints(t *testing.T) {
+	s, err := New(Config{Address: "127.0.0.1:0", Logger: logger.New(logger.ERROR)})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	routes := &mockRoutes{status: []router.RouteStatus{{Filter: "ruuvi/+", Quarantined: true, ConsecutiveErrors: 5}}}
+	RegisterRoutes(s, routes)
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Close()
+	base := "http://" + s.Addr()
+
+	resp, err := http.Get(base + "/routes")
+	if err != nil {
+		t.Fatalf("GET /routes failed: %v", err)
+	}
+	var status []router.RouteStatus
+	json.NewDecoder(resp.Body).Decode(&status)
+	resp.Body.Close()
+	if len(status) != 1 || !status[0].Quarantined || status[0].ConsecutiveErrors != 5 {
+		t.Errorf("Unexpected status: %+v", status)
+	}
+
+	tests := []struct {
+		filter string
+		want   int
+	}{
+		{"ruuvi/+", http.StatusOK},
+		{"missing/#", http.StatusNotFound},
+		{"", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp, err := http.PostForm(base+"/routes/release", url.Values{"filter": {tt.filter}})
+		if err != nil {
+			t.Fatalf("POST /routes/release failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("release %q: status = %d, want %d", tt.filter, resp.StatusCode, tt.want)
+		}
+	}
+	if routes.status[0].Quarantined {
+		t.Error("Expected route to be released")
+	}
+
+	routes.status = append(routes.status, router.RouteStatus{Filter: "p1/#", Script: "broken.lua"})
+	reloads := []struct {
+		filter string
+		want   int
+	}{
+		{"ruuvi/+", http.StatusOK},
+		{"p1/#", http.StatusUnprocessableEntity},
+		{"", http.StatusBadRequest},
+	}
+	for _, tt := range reloads {
+		resp, err := http.PostForm(base+"/routes/reload", url.Values{"filter": {tt.filter}})
+		if err != nil {
+			t.Fatalf("POST /routes/reload failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("reload %q: status = %d, want %d", tt.filter, resp.StatusCode, tt.want)
+		}
+	}
+
+	// Only GET is allowed on /routes
+	resp, err = http.Post(base+"/routes", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("POST /routes failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("POST /routes status = %d, want 405", resp.StatusCode)
+	}
+}
+
+// mockLatest is an in-memory LatestReader
+type mockLatest map[string]map[string]map[string]interface{}
+
+func (m mockLatest) Get(table, key string) (map[string]interface{}, bool) {
+	row, ok := m[table][key]
+	return row, ok
+}
+
+func (m mockLatest) All(table string) map[string]map[string]interface{} {
+	return m[table]
+}
+
+func TestLatestEndpoint(t *testing.T) {
+	s, err := New(Config{Address: "127.0.0.1:0", Logger: logger.New(logger.ERROR)})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	RegisterLatest(s, mockLatest{"readings": {"x": {"device": "x", "temperature": 21.5}}})
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Close()
+	base := "http://" + s.Addr()
+
+	tests := []struct {
+		query string
+		want  int
+	}{
+		{"/latest?table=readings&device=x", http.StatusOK},
+		{"/latest?table=readings", http.StatusOK},
+		{"/latest?table=readings&device=y", http.StatusNotFound},
+		{"/latest", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp, err := http.Get(base + tt.query)
+		if err != nil {
+			t.Fatalf("GET %s failed: %v", tt.query, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("GET %s status = %d, want %d", tt.query, resp.StatusCode, tt.want)
+		}
+	}
+
+	resp, err := http.Get(base + "/latest?table=readings&device=x")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var row map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&row); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if row["temperature"] != 21.5 {
+		t.Errorf("temperature = %v, want 21.5", row["temperature"])
+	}
+}
+
+func TestCapabilitiesEndpoint(t *testing.T) {
+	s, err := New(Config{Address: "127.0.0.1:0", Logger: logger.New(logger.ERROR)})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	RegisterCapabilities(s, capability.Set{Version: "1.0", Sources: []string{"mqtt"}, Sinks: []string{"postgres"}})
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Close()
+
+	resp, err := http.Get("http://" + s.Addr() + "/capabilities")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var caps capability.Set
+	if err := json.NewDecoder(resp.Body).Decode(&caps); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if caps.Version != "1.0" || len(caps.Sources) != 1 || caps.Sources[0] != "mqtt" {
+		t.Errorf("Unexpected capabilities: %+v", caps)
+	}
+}
+
+// mockOutages is a fixed OutageReader
+type mockOutages []outage.Report
+
+func (m mockOutages) Reports() []outage.Report {
+	return m
+}
+
+func TestOutagesEndpoint(t *testing.T) {
+	s, err := New(Config{Address: "127.0.0.1:0", Logger: logger.New(logger.ERROR)})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	RegisterOutages(s, mockOutages{{Component: outage.Database, Duration: "2m0s", Dropped: 4, Replay: "3s"}})
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Close()
+
+	resp, err := http.Get("http://" + s.Addr() + "/outages")
+	if err != nil {
+		t.Fatalf("GET failed: %v", err)
+	}
+	defer resp.Body.Close()
+	var reports []outage.Report
+	if err := json.NewDecoder(resp.Body).Decode(&reports); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if len(reports) != 1 || reports[0].Component != "database" || reports[0].Dropped != 4 {
+		t.Errorf("Unexpected outages: %+v", reports)
+	}
+}
+
+// mockTapper records the last tap request
+type mockTapper struct {
+	filter string
+	n      int
+	topic  string
+}
+
+func (m *mockTapper) Tap(filter string, n int, topic string) error {
+	if filter != "ruuvi/+" {
+		return fmt.Errorf("route %s not found", filter)
+	}
+	m.filter, m.n, m.topic = filter, n, topic
+	return nil
+}
+
+func TestTapEndpoint(t *testing.T) {
+	s, err := New(Config{Address: "127.0.0.1:0", Logger: logger.New(logger.ERROR)})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	tapper := &mockTapper{}
+	RegisterTap(s, tapper, "secret")
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Close()
+	base := "http://" + s.Addr()
+
+	tests := []struct {
+		form url.Values
+		want int
+	}{
+		{url.Values{"filter": {"ruuvi/+"}}, http.StatusOK},
+		{url.Values{"filter": {"ruuvi/+"}, "n": {"3"}, "topic": {"debug/ruuvi"}, "token": {"secret"}}, http.StatusOK},
+		{url.Values{"filter": {"ruuvi/+"}, "topic": {"debug/other"}}, http.StatusUnauthorized},
+		{url.Values{"filter": {"ruuvi/+"}, "topic": {"debug/other"}, "token": {"wrong"}}, http.StatusUnauthorized},
+		{url.Values{"filter": {"ruuvi/+"}, "n": {"many"}}, http.StatusBadRequest},
+		{url.Values{"filter": {"missing/#"}}, http.StatusUnprocessableEntity},
+		{url.Values{}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		resp, err := http.PostForm(base+"/routes/tap", tt.form)
+		if err != nil {
+			t.Fatalf("POST /routes/tap failed: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.want {
+			t.Errorf("tap %v: status %d, want %d", tt.form, resp.StatusCode, tt.want)
+		}
+	}
+	if tapper.n != 3 || tapper.topic != "debug/ruuvi" {
+		t.Errorf("Unexpected tap: %+v", tapper)
+	}
+}
+
+func TestTailEndpoint(t *testing.T) {
+	s, err := New(Config{Address: "127.0.0.1:0", Logger: logger.New(logger.ERROR)})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	hub := watch.New()
+	RegisterTail(s, hub, "secret")
+	if err := s.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer s.Close()
+	base := "ws://" + s.Addr() + "/tail"
+
+	for _, target := range []string{base + "?table=readings", base + "?table=readings&token=wrong"} {
+		if _, resp, err := websocket.DefaultDialer.Dial(target, nil); err == nil || resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s: expected 401, got %v", target, err)
+		}
+	}
+	header := http.Header{"Authorization": {"Bearer secret"}}
+	if _, resp, err := websocket.DefaultDialer.Dial(base+"?every=0&table=readings", header); err == nil || resp.StatusCode != http.StatusBadRequest {
+		t.Errorf("Expected 400 for every=0, got %v", err)
+	}
+
+	conn, _, err := websocket.DefaultDialer.Dial(base+"?table=readings&token=secret", nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	// The subscription starts once the handler runs
+	stage := hub.Storage(nopStorage{})
+	deadline := time.Now().Add(time.Second)
+	var rec watch.Record
+	conn.SetReadDeadline(deadline)
+	go func() {
+		for time.Now().Before(deadline) {
+			stage.InsertIntoTable(context.Background(), "readings", map[string]interface{}{"v": 1.5})
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	if err := conn.ReadJSON(&rec); err != nil {
+		t.Fatalf("ReadJSON failed: %v", err)
+	}
+	if rec.Table != "readings" || rec.Columns["v"] != 1.5 {
+		t.Errorf("Unexpected record: %+v", rec)
+	}
+}
+
+// nopStorage accepts every record
+type nopStorage struct{}
+
+func (nopStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	return nil
+}
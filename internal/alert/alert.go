@@ -0,0 +1,346 @@
+package alert
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/sink"
+)
+
+// Publisher sends alert payloads to an MQTT topic
+type Publisher interface {
+	Publish(topic string, payload []byte) error
+}
+
+// Rule describes a threshold condition evaluated on stored records.
+// Example: temperature > 80 for 5 minutes on table "ruuvi_data".
+type Rule struct {
+	Name      string
+	Table     string        // Table the rule applies to
+	Column    string        // Numeric column to test
+	Condition string        // e.g. "> 80", "<= 3.0"
+	For       time.Duration // How long the condition must hold, by record time, before firing (0 = immediately)
+	Debounce  time.Duration // Minimum record time between repeated alerts while breached (0 = once per breach)
+	Key       string        // Optional column identifying the device (e.g. "sensor_id")
+	Topic     string        // MQTT topic to publish alerts to
+	Webhook   string        // URL to POST alerts to
+
+	op        string
+	threshold float64
+}
+
+// Event is the JSON payload sent when a rule fires
+type Event struct {
+	Rule      string    `json:"rule"`
+	Table     string    `json:"table"`
+	Column    string    `json:"column"`
+	Key       string    `json:"key,omitempty"`
+	Value     float64   `json:"value"`
+	Condition string    `json:"condition"`
+	Since     time.Time `json:"since"`
+	Time      time.Time `json:"time"`
+	Message   string    `json:"message,omitempty"` // Free-form detail for non-threshold alerts
+}
+
+// stateIdle is how long a breach is kept for a key that stops reporting
+// (at least the rule's For and Debounce), and stateSweep how often idle
+// breaches are looked for
+const (
+	stateIdle  = 24 * time.Hour
+	stateSweep = time.Minute
+)
+
+// state tracks a rule's breach for one key; keys without a breach have none
+type state struct {
+	since    time.Time // Record time the breach started
+	firedAt  time.Time // Record time of the last alert; zero when not fired during the breach
+	lastSeen time.Time // Wall-clock time of the last record, for expiry
+	idle     time.Duration
+}
+
+// Engine evaluates alert rules on records as they pass through to storage
+type Engine struct {
+	rules     []*Rule
+	next      sink.Storage
+	publisher Publisher
+	client    *http.Client
+	logger    *logger.Logger
+	mu        sync.Mutex
+	states    map[string]*state
+	swept     time.Time
+	now       func() time.Time
+	wg        sync.WaitGroup
+}
+
+// validOps lists supported comparison operators, longest first for parsing
+var validOps = []string{">=", "<=", "==", "!=", ">", "<"}
+
+// ParseCondition splits a condition like "> 80" into operator and threshold
+func ParseCondition(cond string) (string, float64, error) {
+	cond = strings.TrimSpace(cond)
+	for _, op := range validOps {
+		if strings.HasPrefix(cond, op) {
+			v, err := strconv.ParseFloat(strings.TrimSpace(cond[len(op):]), 64)
+			if err != nil {
+				return "", 0, fmt.Errorf("invalid threshold in condition %q: %w", cond, err)
+			}
+			return op, v, nil
+		}
+	}
+	return "", 0, fmt.Errorf("invalid condition %q: must start with one of %v", cond, validOps)
+}
+
+// New creates an alert engine in front of next
+func New(rules []Rule, next sink.Storage, log *logger.Logger) (*Engine, error) {
+	if log == nil {
+		log = logger.New(logger.INFO)
+	}
+
+	e := &Engine{
+		next:   next,
+		client: &http.Client{Timeout: 10 * time.Second},
+		logger: log,
+		states: make(map[string]*state),
+		now:    time.Now,
+	}
+
+	for i := range rules {
+		r := rules[i]
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("%s.%s", r.Table, r.Column)
+		}
+		if r.Table == "" || r.Column == "" {
+			return nil, fmt.Errorf("alert %s: table and column are required", r.Name)
+		}
+		if r.Topic == "" && r.Webhook == "" {
+			return nil, fmt.Errorf("alert %s: topic or webhook is required", r.Name)
+		}
+		op, threshold, err := ParseCondition(r.Condition)
+		if err != nil {
+			return nil, fmt.Errorf("alert %s: %w", r.Name, err)
+		}
+		r.op = op
+		r.threshold = threshold
+		e.rules = append(e.rules, &r)
+	}
+
+	return e, nil
+}
+
+// SetPublisher sets the MQTT publisher used for topic targets
+func (e *Engine) SetPublisher(p Publisher) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.publisher = p
+}
+
+// InsertIntoTable stores the record and evaluates matching rules on success
+func (e *Engine) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	if err := e.next.InsertIntoTable(ctx, table, data); err != nil {
+		return err
+	}
+
+	for _, r := range e.rules {
+		if r.Table == table {
+			e.evaluate(r, data)
+		}
+	}
+	return nil
+}
+
+// InsertBatch stores the rows and evaluates matching rules on success
+func (e *Engine) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error {
+	bs, ok := e.next.(sink.BatchStorage)
+	if !ok {
+		return sink.InsertEach(ctx, e, table, rows)
+	}
+
+	if err := bs.InsertBatch(ctx, table, rows); err != nil {
+		return err
+	}
+	for _, r := range e.rules {
+		if r.Table != table {
+			continue
+		}
+		for _, data := range rows {
+			e.evaluate(r, data)
+		}
+	}
+	return nil
+}
+
+// evaluate updates breach state for a rule and fires when due. For and
+// Debounce are measured by the records' "time" column, so a batch of late
+// records fires like live ones; records without one use the current time.
+func (e *Engine) evaluate(r *Rule, data map[string]interface{}) {
+	value, ok := toFloat(data[r.Column])
+	if !ok {
+		return
+	}
+
+	key := ""
+	if r.Key != "" {
+		key = fmt.Sprintf("%v", data[r.Key])
+	}
+	now := e.now()
+	at := sink.RecordTime(data, now)
+
+	e.mu.Lock()
+	e.expire(now)
+	stKey := r.Name + "|" + key
+	st, ok := e.states[stKey]
+
+	if !compare(r.op, value, r.threshold) {
+		// Condition cleared: forget the breach so the next one fires again
+		delete(e.states, stKey)
+		e.mu.Unlock()
+		return
+	}
+
+	if !ok {
+		st = &state{since: at, idle: max(stateIdle, r.For, r.Debounce)}
+		e.states[stKey] = st
+	}
+	st.lastSeen = now
+	if at.Before(st.since) {
+		st.since = at
+	}
+	due := at.Sub(st.since) >= r.For
+	if !st.firedAt.IsZero() {
+		due = due && r.Debounce > 0 && at.Sub(st.firedAt) >= r.Debounce
+	}
+	if !due {
+		e.mu.Unlock()
+		return
+	}
+	st.firedAt = at
+	publisher := e.publisher
+	event := Event{
+		Rule:      r.Name,
+		Table:     r.Table,
+		Column:    r.Column,
+		Key:       key,
+		Value:     value,
+		Condition: r.Condition,
+		Since:     st.since,
+		Time:      at,
+	}
+	e.mu.Unlock()
+
+	e.logger.Infof("Alert %s fired: %s %s (value=%v, key=%s)", r.Name, r.Column, r.Condition, event.Value, event.Key)
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.fire(event, r.Topic, r.Webhook, publisher)
+	}()
+}
+
+// expire drops breaches of keys that stopped reporting; e.mu must be held
+func (e *Engine) expire(now time.Time) {
+	if now.Sub(e.swept) < stateSweep {
+		return
+	}
+	e.swept = now
+	for k, st := range e.states {
+		if now.Sub(st.lastSeen) > st.idle {
+			delete(e.states, k)
+		}
+	}
+}
+
+// Notify sends an event that isn't tied to a rule (e.g. a route quarantine)
+// to the given MQTT topic and/or webhook
+func (e *Engine) Notify(event Event, topic, webhook string) {
+	if event.Time.IsZero() {
+		event.Time = e.now()
+	}
+	e.mu.Lock()
+	publisher := e.publisher
+	e.mu.Unlock()
+
+	e.logger.Infof("Alert %s fired: %s", event.Rule, event.Message)
+	e.wg.Add(1)
+	go func() {
+		defer e.wg.Done()
+		e.fire(event, topic, webhook, publisher)
+	}()
+}
+
+// fire delivers an event to its targets
+func (e *Engine) fire(event Event, topic, webhook string, publisher Publisher) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		e.logger.Errorf("Alert %s: failed to encode event: %v", event.Rule, err)
+		return
+	}
+
+	if topic != "" {
+		if publisher == nil {
+			e.logger.Errorf("Alert %s: no MQTT publisher available for topic %s", event.Rule, topic)
+		} else if err := publisher.Publish(topic, payload); err != nil {
+			e.logger.Errorf("Alert %s: failed to publish to %s: %v", event.Rule, topic, err)
+		}
+	}
+
+	if webhook != "" {
+		resp, err := e.client.Post(webhook, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			e.logger.Errorf("Alert %s: webhook failed: %v", event.Rule, err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			e.logger.Errorf("Alert %s: webhook returned status %d", event.Rule, resp.StatusCode)
+		}
+	}
+}
+
+// Close waits for in-flight alert deliveries
+func (e *Engine) Close() {
+	e.wg.Wait()
+}
+
+// compare applies op to value and threshold
+func compare(op string, value, threshold float64) bool {
+	switch op {
+	case ">":
+		return value > threshold
+	case ">=":
+		return value >= threshold
+	case "<":
+		return value < threshold
+	case "<=":
+		return value <= threshold
+	case "==":
+		return value == threshold
+	case "!=":
+		return value != threshold
+	}
+	return false
+}
+
+// toFloat converts numeric values to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
@@ -0,0 +1,301 @@
+package alert
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// mockStorage records inserts
+type mockStorage struct {
+	inserts int
+}
+
+func (m *mockStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	m.inserts++
+	return nil
+}
+
+// mockPublisher records published alerts
+type mockPublisher struct {
+	mu       sync.Mutex
+	topics   []string
+	payloads [][]byte
+}
+
+func (m *mockPublisher) Publish(topic string, payload []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.topics = append(m.topics, topic)
+	m.payloads = append(m.payloads, payload)
+	return nil
+}
+
+func (m *mockPublisher) count() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.topics)
+}
+
+func TestParseCondition(t *testing.T) {
+	tests := []struct {
+		cond      string
+		op        string
+		threshold float64
+		wantErr   bool
+	}{
+		{"> 80", ">", 80, false},
+		{">=80.5", ">=", 80.5, false},
+		{"  < -3", "<", -3, false},
+		{"!= 0", "!=", 0, false},
+		{"== 1", "==", 1, false},
+		{"80", "", 0, true},
+		{"> hot", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.cond, func(t *testing.T) {
+			op, threshold, err := ParseCondition(tt.cond)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseCondition(%q) error = %v, wantErr %v", tt.cond, err, tt.wantErr)
+			}
+			if !tt.wantErr && (op != tt.op || threshold != tt.threshold) {
+				t.Errorf("ParseCondition(%q) = %s %v, want %s %v", tt.cond, op, threshold, tt.op, tt.threshold)
+			}
+		})
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		rule Rule
+	}{
+		{"missing column", Rule{Table: "t", Condition: "> 1", Topic: "alerts"}},
+		{"missing target", Rule{Table: "t", Column: "c", Condition: "> 1"}},
+		{"bad condition", Rule{Table: "t", Column: "c", Condition: "~ 1", Topic: "alerts"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New([]Rule{tt.rule}, &mockStorage{}, nil); err == nil {
+				t.Error("Expected validation error")
+			}
+		})
+	}
+}
+
+func TestEngineFiresAfterDuration(t *testing.T) {
+	storage := &mockStorage{}
+	pub := &mockPublisher{}
+	e, err := New([]Rule{{
+		Table:     "ruuvi",
+		Column:    "temperature",
+		Condition: "> 80",
+		For:       5 * time.Minute,
+		Key:       "sensor",
+		Topic:     "alerts/temp",
+	}}, storage, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	e.SetPublisher(pub)
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	insert := func(temp float64) {
+		if err := e.InsertIntoTable(ctx, "ruuvi", map[string]interface{}{"sensor": "a", "temperature": temp}); err != nil {
+			t.Fatalf("InsertIntoTable failed: %v", err)
+		}
+	}
+
+	insert(85)
+	now = now.Add(4 * time.Minute)
+	insert(86)
+	e.Close()
+	if pub.count() != 0 {
+		t.Fatal("Alert fired before condition held for 5 minutes")
+	}
+
+	now = now.Add(time.Minute)
+	insert(87)
+	e.Close()
+	if pub.count() != 1 {
+		t.Fatalf("Expected 1 alert, got %d", pub.count())
+	}
+
+	var ev Event
+	if err := json.Unmarshal(pub.payloads[0], &ev); err != nil {
+		t.Fatalf("invalid alert payload: %v", err)
+	}
+	if ev.Key != "a" || ev.Value != 87 || pub.topics[0] != "alerts/temp" {
+		t.Errorf("Unexpected alert: %+v on %s", ev, pub.topics[0])
+	}
+
+	// No debounce: fires once per breach
+	now = now.Add(time.Hour)
+	insert(90)
+	e.Close()
+	if pub.count() != 1 {
+		t.Errorf("Expected no repeat alert without debounce, got %d", pub.count())
+	}
+
+	// Clearing the condition resets the breach; a new breach fires after For again
+	insert(20)
+	insert(95)
+	now = now.Add(5 * time.Minute)
+	insert(95)
+	e.Close()
+	if pub.count() != 2 {
+		t.Errorf("Expected alert after new breach, got %d", pub.count())
+	}
+
+	if storage.inserts != 7 {
+		t.Errorf("Expected all records forwarded to storage, got %d", storage.inserts)
+	}
+}
+
+func TestEngineUsesRecordTime(t *testing.T) {
+	pub := &mockPublisher{}
+	e, err := New([]Rule{{
+		Table:     "ruuvi",
+		Column:    "temperature",
+		Condition: "> 80",
+		For:       5 * time.Minute,
+		Topic:     "alerts/temp",
+	}}, &mockStorage{}, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	e.SetPublisher(pub)
+
+	// A late batch covering six minutes arrives all at once
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	var rows []map[string]interface{}
+	for i := 0; i <= 6; i++ {
+		rows = append(rows, map[string]interface{}{"time": start.Add(time.Duration(i) * time.Minute), "temperature": 85.0})
+	}
+	if err := e.InsertBatch(context.Background(), "ruuvi", rows); err != nil {
+		t.Fatalf("InsertBatch failed: %v", err)
+	}
+	e.Close()
+	if pub.count() != 1 {
+		t.Fatalf("Expected 1 alert from record times, got %d", pub.count())
+	}
+
+	var ev Event
+	if err := json.Unmarshal(pub.payloads[0], &ev); err != nil {
+		t.Fatalf("invalid alert payload: %v", err)
+	}
+	if !ev.Since.Equal(start) || !ev.Time.Equal(start.Add(5*time.Minute)) {
+		t.Errorf("Alert since %v at %v, want %v and 5 minutes later", ev.Since, ev.Time, start)
+	}
+}
+
+func TestEngineExpiresIdleStates(t *testing.T) {
+	e, err := New([]Rule{{
+		Table:     "ruuvi",
+		Column:    "temperature",
+		Condition: "> 80",
+		For:       time.Hour,
+		Key:       "sensor",
+		Topic:     "alerts/temp",
+	}}, &mockStorage{}, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	// Keys whose condition cleared are not kept
+	e.InsertIntoTable(ctx, "ruuvi", map[string]interface{}{"sensor": "a", "temperature": 20.0})
+	e.InsertIntoTable(ctx, "ruuvi", map[string]interface{}{"sensor": "b", "temperature": 90.0})
+	if len(e.states) != 1 {
+		t.Fatalf("Expected only the breached key to be tracked, got %d", len(e.states))
+	}
+
+	// A breached key that stops reporting is dropped after a day
+	now = now.Add(25 * time.Hour)
+	e.InsertIntoTable(ctx, "ruuvi", map[string]interface{}{"sensor": "c", "temperature": 90.0})
+	if _, ok := e.states["ruuvi.temperature|b"]; ok || len(e.states) != 1 {
+		t.Errorf("Expected the idle breach to expire, got %d states", len(e.states))
+	}
+}
+
+func TestEngineDebounceAndWebhook(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls int
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.Copy(io.Discard, r.Body)
+		mu.Lock()
+		calls++
+		mu.Unlock()
+	}))
+	defer srv.Close()
+
+	e, err := New([]Rule{{
+		Name:      "low_battery",
+		Table:     "ruuvi",
+		Column:    "battery",
+		Condition: "< 2.5",
+		Debounce:  10 * time.Minute,
+		Webhook:   srv.URL,
+	}}, &mockStorage{}, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	e.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for _, step := range []time.Duration{0, 5 * time.Minute, 5 * time.Minute} {
+		now = now.Add(step)
+		e.InsertIntoTable(ctx, "ruuvi", map[string]interface{}{"battery": 2.1})
+	}
+	// Other tables and non-numeric values are ignored
+	e.InsertIntoTable(ctx, "other", map[string]interface{}{"battery": 0.0})
+	e.InsertIntoTable(ctx, "ruuvi", map[string]interface{}{"battery": "low"})
+	e.Close()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if calls != 2 {
+		t.Errorf("Expected 2 webhook calls (initial + after debounce), got %d", calls)
+	}
+}
+
+func TestEngineNotify(t *testing.T) {
+	pub := &mockPublisher{}
+	e, err := New(nil, &mockStorage{}, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	e.SetPublisher(pub)
+
+	e.Notify(Event{Rule: "quarantine", Key: "ruuvi/+", Message: "route quarantined"}, "alerts/hermod", "")
+	e.Close()
+
+	if pub.count() != 1 || pub.topics[0] != "alerts/hermod" {
+		t.Fatalf("Expected one alert on alerts/hermod, got %v", pub.topics)
+	}
+	var event Event
+	if err := json.Unmarshal(pub.payloads[0], &event); err != nil {
+		t.Fatalf("Invalid alert payload: %v", err)
+	}
+	if event.Rule != "quarantine" || event.Message != "route quarantined" || event.Time.IsZero() {
+		t.Errorf("Unexpected event: %+v", event)
+	}
+}
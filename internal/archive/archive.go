@@ -0,0 +1,202 @@
+package archive
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/seal"
+)
+
+// defaultFlushInterval is how often buffered archive data is flushed to disk
+const defaultFlushInterval = 5 * time.Second
+
+// Config holds payload archive configuration
+type Config struct {
+	Dir           string        // Directory for archive files
+	FlushInterval time.Duration // How often compressed data is flushed (default: 5s)
+	Key           []byte        // Encrypt files with this AES-256 key (see package seal; nil = plain gzip)
+	Logger        *logger.Logger
+}
+
+// Entry is a single archived message (one NDJSON line)
+type Entry struct {
+	Time     time.Time `json:"time"`
+	Topic    string    `json:"topic"`
+	Payload  string    `json:"payload"`
+	Encoding string    `json:"encoding,omitempty"` // "base64" for non-UTF-8 payloads
+}
+
+// Archiver writes raw messages to hourly gzip'd NDJSON files named
+// hermod-YYYYMMDDHH.ndjson.gz (UTC). Files are appended to as additional
+// gzip members after a restart, which standard tools read transparently.
+// With a key the files are sealed and named hermod-YYYYMMDDHH.ndjson.gz.enc;
+// ReadFile opens them.
+type Archiver struct {
+	cfg    Config
+	logger *logger.Logger
+	mu     sync.Mutex
+	hour   time.Time
+	file   *os.File
+	sealed *seal.Writer // Between gz and file when encrypting
+	gz     *gzip.Writer
+	now    func() time.Time
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// New creates an archiver writing into cfg.Dir
+func New(cfg Config) (*Archiver, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("archive directory is required")
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = defaultFlushInterval
+	}
+	log := cfg.Logger
+	if log == nil {
+		log = logger.New(logger.INFO)
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+
+	a := &Archiver{
+		cfg:    cfg,
+		logger: log,
+		now:    time.Now,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go a.flushLoop()
+	return a, nil
+}
+
+// Write appends a raw message to the current hour's archive file
+func (a *Archiver) Write(topic string, payload []byte, at time.Time) error {
+	entry := Entry{Time: at.UTC(), Topic: topic}
+	if utf8.Valid(payload) {
+		entry.Payload = string(payload)
+	} else {
+		entry.Payload = base64.StdEncoding.EncodeToString(payload)
+		entry.Encoding = "base64"
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to encode archive entry: %w", err)
+	}
+	line = append(line, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if err := a.rotate(a.now().UTC().Truncate(time.Hour)); err != nil {
+		return err
+	}
+	if _, err := a.gz.Write(line); err != nil {
+		return fmt.Errorf("failed to write archive entry: %w", err)
+	}
+	return nil
+}
+
+// Path returns the archive file path for the hour containing t
+func (a *Archiver) Path(t time.Time) string {
+	name := "hermod-" + t.UTC().Format("2006010215") + ".ndjson.gz"
+	if a.cfg.Key != nil {
+		name += ".enc"
+	}
+	return filepath.Join(a.cfg.Dir, name)
+}
+
+// rotate switches to the file for hour if needed; caller holds a.mu
+func (a *Archiver) rotate(hour time.Time) error {
+	if a.gz != nil && hour.Equal(a.hour) {
+		return nil
+	}
+	if err := a.closeFile(); err != nil {
+		a.logger.Errorf("Failed to close archive file: %v", err)
+	}
+
+	f, err := os.OpenFile(a.Path(hour), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open archive file: %w", err)
+	}
+	a.file = f
+	if a.cfg.Key != nil {
+		if a.sealed, err = seal.NewWriter(f, a.cfg.Key); err != nil {
+			f.Close()
+			a.file = nil
+			return err
+		}
+		a.gz = gzip.NewWriter(a.sealed)
+	} else {
+		a.gz = gzip.NewWriter(f)
+	}
+	a.hour = hour
+	a.logger.Debugf("Archiving to %s", f.Name())
+	return nil
+}
+
+// closeFile finishes the current gzip member and closes the file; caller holds a.mu
+func (a *Archiver) closeFile() error {
+	if a.gz == nil {
+		return nil
+	}
+	err := a.gz.Close()
+	if a.sealed != nil {
+		err = errors.Join(err, a.sealed.Close())
+	}
+	err = errors.Join(err, a.file.Close())
+	a.gz, a.sealed, a.file = nil, nil, nil
+	return err
+}
+
+// flushLoop periodically flushes compressed data so a crash loses little
+func (a *Archiver) flushLoop() {
+	defer close(a.done)
+	ticker := time.NewTicker(a.cfg.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-a.stop:
+			return
+		case <-ticker.C:
+			a.mu.Lock()
+			if a.gz != nil {
+				if err := a.flush(); err != nil {
+					a.logger.Errorf("Failed to flush archive: %v", err)
+				}
+			}
+			a.mu.Unlock()
+		}
+	}
+}
+
+// flush writes the compressed data buffered so far; caller holds a.mu
+func (a *Archiver) flush() error {
+	if err := a.gz.Flush(); err != nil {
+		return err
+	}
+	if a.sealed != nil {
+		return a.sealed.Flush()
+	}
+	return nil
+}
+
+// Close flushes and closes the current archive file
+func (a *Archiver) Close() error {
+	close(a.stop)
+	<-a.done
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.closeFile()
+}
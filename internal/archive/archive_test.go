@@ -0,0 +1,199 @@
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/seal"
+)
+
+// readEntries decodes all NDJSON entries from a (possibly multi-member) gzip file
+func readEntries(t *testing.T, path string) []Entry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open archive: %v", err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatalf("failed to read gzip: %v", err)
+	}
+
+	var entries []Entry
+	scanner := bufio.NewScanner(gz)
+	for scanner.Scan() {
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatalf("invalid NDJSON line %q: %v", scanner.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("failed to read archive: %v", err)
+	}
+	return entries
+}
+
+func TestArchiverHourlyFiles(t *testing.T) {
+	dir := t.TempDir()
+	a, err := New(Config{Dir: dir, Logger: logger.New(logger.ERROR)})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	now := time.Date(2024, 1, 1, 12, 59, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	if err := a.Write("sensors/a", []byte(`{"v":1}`), now); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if err := a.Write("sensors/bin", []byte{0xff, 0x00}, now); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	first := a.Path(now)
+
+	now = now.Add(2 * time.Minute)
+	if err := a.Write("sensors/a", []byte(`{"v":2}`), now); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	second := a.Path(now)
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	if first == second {
+		t.Fatalf("Expected a new file for the next hour, got %s", first)
+	}
+
+	entries := readEntries(t, first)
+	if len(entries) != 2 {
+		t.Fatalf("Expected 2 entries in %s, got %d", first, len(entries))
+	}
+	if entries[0].Topic != "sensors/a" || entries[0].Payload != `{"v":1}` || entries[0].Encoding != "" {
+		t.Errorf("Unexpected entry: %+v", entries[0])
+	}
+	if entries[1].Payload != "/wA=" || entries[1].Encoding != "base64" {
+		t.Errorf("Expected base64 payload, got %+v", entries[1])
+	}
+
+	if entries := readEntries(t, second); len(entries) != 1 || entries[0].Payload != `{"v":2}` {
+		t.Errorf("Unexpected entries in %s: %+v", second, entries)
+	}
+}
+
+func TestArchiverAppendsAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		a, err := New(Config{Dir: dir, Logger: logger.New(logger.ERROR)})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		a.now = func() time.Time { return now }
+		if err := a.Write("t", []byte("x"), now); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if err := a.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+	}
+
+	a := &Archiver{cfg: Config{Dir: dir}}
+	if entries := readEntries(t, a.Path(now)); len(entries) != 2 {
+		t.Errorf("Expected 2 entries across restarts, got %d", len(entries))
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	dir := t.TempDir()
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	// Two archiver runs leave a multi-member gzip file
+	for _, payload := range [][]byte{[]byte(`{"v":1}`), {0xff, 0x00}} {
+		a, err := New(Config{Dir: dir, Logger: logger.New(logger.ERROR)})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		a.now = func() time.Time { return at }
+		if err := a.Write("sensors/a", payload, at); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if err := a.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+	}
+
+	var payloads []string
+	err := ReadFile(filepath.Join(dir, "hermod-2024010112.ndjson.gz"), nil, func(e Entry) error {
+		data, err := e.Data()
+		if err != nil {
+			return err
+		}
+		if e.Topic != "sensors/a" || !e.Time.Equal(at) {
+			t.Errorf("Unexpected entry: %+v", e)
+		}
+		payloads = append(payloads, string(data))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ReadFile() error = %v", err)
+	}
+	if len(payloads) != 2 || payloads[0] != `{"v":1}` || payloads[1] != "\xff\x00" {
+		t.Errorf("payloads = %q, want both messages in order", payloads)
+	}
+
+	if err := ReadFile(filepath.Join(dir, "missing.ndjson.gz"), nil, func(Entry) error { return nil }); err == nil {
+		t.Error("Expected error for a missing file")
+	}
+}
+
+func TestArchiverEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{7}, seal.KeySize)
+	at := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	for range 2 {
+		a, err := New(Config{Dir: dir, Key: key, Logger: logger.New(logger.ERROR)})
+		if err != nil {
+			t.Fatalf("New() error = %v", err)
+		}
+		a.now = func() time.Time { return at }
+		if err := a.Write("sensors/a", []byte(`{"secret":1}`), at); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if err := a.Close(); err != nil {
+			t.Fatalf("Close() error = %v", err)
+		}
+	}
+
+	path := filepath.Join(dir, "hermod-2024010112.ndjson.gz.enc")
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Expected an encrypted archive file: %v", err)
+	}
+	if _, err := gzip.NewReader(bytes.NewReader(raw)); err == nil {
+		t.Error("Expected the file not to be readable as gzip")
+	}
+
+	n := 0
+	if err := ReadFile(path, key, func(Entry) error { n++; return nil }); err != nil || n != 2 {
+		t.Errorf("ReadFile() = %d entries, %v; want 2", n, err)
+	}
+	if err := ReadFile(path, nil, func(Entry) error { return nil }); !errors.Is(err, seal.ErrNoKey) {
+		t.Errorf("ReadFile() without key error = %v, want ErrNoKey", err)
+	}
+}
+
+func TestNewRequiresDir(t *testing.T) {
+	if _, err := New(Config{}); err == nil {
+		t.Error("Expected error for missing directory")
+	}
+}
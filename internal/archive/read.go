@@ -0,0 +1,57 @@
+package archive
+
+import (
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/marcgeld/hermod/internal/seal"
+)
+
+// Data returns the entry's raw payload
+func (e Entry) Data() ([]byte, error) {
+	if e.Encoding == "base64" {
+		return base64.StdEncoding.DecodeString(e.Payload)
+	}
+	return []byte(e.Payload), nil
+}
+
+// ReadFile calls fn for every entry of an archive file in order, stopping
+// at the first error. A file cut short by a crash returns the entries
+// written before the damage and an error. key opens encrypted archives
+// (nil for plain ones).
+func ReadFile(path string, key []byte, fn func(Entry) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open archive: %w", err)
+	}
+	defer f.Close()
+	r, err := seal.NewReader(f, key)
+	if err != nil {
+		return fmt.Errorf("failed to read archive %s: %w", path, err)
+	}
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to read archive %s: %w", path, err)
+	}
+	defer gz.Close()
+
+	dec := json.NewDecoder(gz)
+	for {
+		var e Entry
+		err := dec.Decode(&e)
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive %s: %w", path, err)
+		}
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+}
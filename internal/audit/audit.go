@@ -0,0 +1,109 @@
+package audit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// TableName is the table schema-affecting operations are recorded in
+const TableName = "hermod_ddl_audit"
+
+// CreateTableSQL returns the DDL for the audit table, with IF NOT EXISTS
+// when ifNotExists is set
+func CreateTableSQL(ifNotExists bool) string {
+	create := "CREATE TABLE"
+	if ifNotExists {
+		create += " IF NOT EXISTS"
+	}
+	return create + ` hermod_ddl_audit (
+  id bigserial PRIMARY KEY,
+  executed_at timestamptz NOT NULL,
+  executed_by text NOT NULL DEFAULT current_user,
+  statement text NOT NULL,
+  route text,
+  script text,
+  error text
+);`
+}
+
+// insertSQL records one executed statement
+const insertSQL = `INSERT INTO hermod_ddl_audit (executed_at, statement, route, script, error)
+VALUES ($1, $2, $3, $4, $5)`
+
+// Statement is a DDL statement and what initiated it
+type Statement struct {
+	SQL    string
+	Route  string // Route filter(s) whose scripts declared the change (empty = Hermod itself)
+	Script string // Script path(s) declaring the change
+}
+
+// Database executes statements
+type Database interface {
+	Exec(ctx context.Context, query string, args ...interface{}) error
+}
+
+// Log executes DDL statements and records each one in hermod_ddl_audit
+type Log struct {
+	db     Database
+	logger *logger.Logger
+	now    func() time.Time
+}
+
+// New creates an audit log writing to db
+func New(db Database, log *logger.Logger) *Log {
+	if log == nil {
+		log = logger.New(logger.INFO)
+	}
+	return &Log{db: db, logger: log, now: time.Now}
+}
+
+// Apply creates the audit table and executes statements in order, recording
+// each one (including failures). It stops at the first failing statement.
+func (l *Log) Apply(ctx context.Context, stmts []Statement) error {
+	if err := l.db.Exec(ctx, CreateTableSQL(true)); err != nil {
+		return fmt.Errorf("failed to create %s: %w", TableName, err)
+	}
+
+	for _, stmt := range stmts {
+		at := l.now().UTC()
+		execErr := l.db.Exec(ctx, stmt.SQL)
+
+		var errText interface{}
+		if execErr != nil {
+			errText = execErr.Error()
+		}
+		if err := l.db.Exec(ctx, insertSQL, at, stmt.SQL, nullable(stmt.Route), nullable(stmt.Script), errText); err != nil {
+			return fmt.Errorf("failed to record DDL in %s: %w", TableName, err)
+		}
+		if execErr != nil {
+			return fmt.Errorf("failed to execute DDL for %s: %w", describe(stmt), execErr)
+		}
+		l.logger.Infof("Applied DDL for %s", describe(stmt))
+	}
+	return nil
+}
+
+// nullable maps empty strings to NULL
+func nullable(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// describe names what initiated a statement for log messages
+func describe(stmt Statement) string {
+	switch {
+	case stmt.Route != "" && stmt.Script != "":
+		return fmt.Sprintf("route %s (%s)", stmt.Route, stmt.Script)
+	case stmt.Route != "":
+		return "route " + stmt.Route
+	case stmt.Script != "":
+		return stmt.Script
+	default:
+		return "hermod"
+	}
+}
@@ -0,0 +1,90 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// execCall is one statement passed to mockDB
+type execCall struct {
+	query string
+	args  []interface{}
+}
+
+// mockDB records executed statements and fails those containing failOn
+type mockDB struct {
+	calls  []execCall
+	failOn string
+}
+
+func (m *mockDB) Exec(ctx context.Context, query string, args ...interface{}) error {
+	m.calls = append(m.calls, execCall{query: query, args: args})
+	if m.failOn != "" && strings.Contains(query, m.failOn) {
+		return errors.New("permission denied")
+	}
+	return nil
+}
+
+func newTestLog(db Database) *Log {
+	log := logger.New(logger.ERROR)
+	log.SetOutput(&bytes.Buffer{})
+	l := New(db, log)
+	l.now = func() time.Time { return time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC) }
+	return l
+}
+
+func TestApplyRecordsStatements(t *testing.T) {
+	db := &mockDB{}
+	stmts := []Statement{
+		{SQL: "CREATE TABLE IF NOT EXISTS ruuvi (mac text);", Route: "ruuvi/+", Script: "scripts/ruuvi.lua"},
+		{SQL: "CREATE TABLE IF NOT EXISTS hermod_devices (id text);"},
+	}
+	if err := newTestLog(db).Apply(context.Background(), stmts); err != nil {
+		t.Fatalf("Apply failed: %v", err)
+	}
+
+	if len(db.calls) != 5 {
+		t.Fatalf("Expected 5 statements (audit table + 2 DDL + 2 audit rows), got %d", len(db.calls))
+	}
+	if db.calls[0].query != CreateTableSQL(true) {
+		t.Errorf("Expected the audit table to be created first, got %q", db.calls[0].query)
+	}
+
+	row := db.calls[2]
+	if !strings.HasPrefix(row.query, "INSERT INTO hermod_ddl_audit") {
+		t.Fatalf("Expected audit insert, got %q", row.query)
+	}
+	want := []interface{}{time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC), stmts[0].SQL, "ruuvi/+", "scripts/ruuvi.lua", nil}
+	for i, v := range want {
+		if row.args[i] != v {
+			t.Errorf("audit arg %d = %v, want %v", i, row.args[i], v)
+		}
+	}
+	if db.calls[4].args[2] != nil || db.calls[4].args[3] != nil {
+		t.Errorf("Expected NULL route and script for Hermod-initiated DDL, got %v", db.calls[4].args)
+	}
+}
+
+func TestApplyRecordsFailure(t *testing.T) {
+	db := &mockDB{failOn: "CREATE TABLE IF NOT EXISTS ruuvi"}
+	stmts := []Statement{
+		{SQL: "CREATE TABLE IF NOT EXISTS ruuvi (mac text);", Route: "ruuvi/+"},
+		{SQL: "CREATE TABLE IF NOT EXISTS other (v int);"},
+	}
+	err := newTestLog(db).Apply(context.Background(), stmts)
+	if err == nil || !strings.Contains(err.Error(), "route ruuvi/+") {
+		t.Fatalf("Expected error naming the route, got %v", err)
+	}
+	if len(db.calls) != 3 {
+		t.Fatalf("Expected to stop after recording the failure, got %d statements", len(db.calls))
+	}
+	if db.calls[2].args[4] != "permission denied" {
+		t.Errorf("Expected the error to be recorded, got %v", db.calls[2].args[4])
+	}
+}
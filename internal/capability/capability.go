@@ -0,0 +1,29 @@
+package capability
+
+import (
+	"github.com/marcgeld/hermod/internal/router"
+	"github.com/marcgeld/hermod/internal/sink"
+	"github.com/marcgeld/hermod/internal/source"
+)
+
+// Set lists what a hermod binary supports, so deployment tooling can check
+// a configuration against it before rollout
+type Set struct {
+	Version        string   `json:"version"`
+	Sources        []string `json:"sources"`         // Registered source kinds
+	Sinks          []string `json:"sinks"`           // Types accepted by [[sinks]]
+	LuaHelpers     []string `json:"lua_helpers"`     // Helper functions available to route scripts
+	PayloadFormats []string `json:"payload_formats"` // Values accepted by payload_format, including registered decoders
+}
+
+// Discover reports the capabilities compiled into this binary, including
+// sources, Lua helpers and decoders registered by forks during init
+func Discover(version string) Set {
+	return Set{
+		Version:        version,
+		Sources:        source.Names(),
+		Sinks:          sink.Types(),
+		LuaHelpers:     router.LuaHelpers(),
+		PayloadFormats: router.PayloadFormats(),
+	}
+}
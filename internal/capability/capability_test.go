@@ -0,0 +1,39 @@
+package capability
+
+import (
+	"slices"
+	"testing"
+
+	hermodlua "github.com/marcgeld/hermod/internal/lua"
+	lua "github.com/yuin/gopher-lua"
+)
+
+func TestDiscover(t *testing.T) {
+	hermodlua.RegisterLuaFunc("site_name", func(L *lua.LState) int { return 0 })
+
+	caps := Discover("1.2.3")
+	if caps.Version != "1.2.3" {
+		t.Errorf("Version = %q, want 1.2.3", caps.Version)
+	}
+	for _, want := range []string{"coap", "listener", "mqtt", "nats", "tail"} {
+		if !slices.Contains(caps.Sources, want) {
+			t.Errorf("Sources = %v, missing %s", caps.Sources, want)
+		}
+	}
+	if !slices.Equal(caps.Sinks, []string{"jsonl", "postgres"}) {
+		t.Errorf("Sinks = %v, want [jsonl postgres]", caps.Sinks)
+	}
+	for _, want := range []string{"ruuvi_decode", "dsmr_decode", "shared", "site_name"} {
+		if !slices.Contains(caps.LuaHelpers, want) {
+			t.Errorf("LuaHelpers = %v, missing %s", caps.LuaHelpers, want)
+		}
+	}
+	if !slices.IsSorted(caps.LuaHelpers) {
+		t.Errorf("LuaHelpers not sorted: %v", caps.LuaHelpers)
+	}
+	for _, want := range []string{"json", "cbor", "msgpack", "text", "binary"} {
+		if !slices.Contains(caps.PayloadFormats, want) {
+			t.Errorf("PayloadFormats = %v, missing %s", caps.PayloadFormats, want)
+		}
+	}
+}
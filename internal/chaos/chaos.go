@@ -0,0 +1,191 @@
+// Package chaos injects failures for staging tests: random transform
+// delays, failed inserts and broker disconnects, so retries, dead-letter
+// tables, quarantine and outage reports can be checked under realistic
+// failure conditions. It is enabled only through the HERMOD_CHAOS
+// environment variable and must never be set in production.
+package chaos
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/sink"
+)
+
+// EnvVar holds the failure injection spec (empty = disabled)
+const EnvVar = "HERMOD_CHAOS"
+
+// Config sets what is injected and how often
+type Config struct {
+	DelayRate       float64       // Share of transforms delayed (0-1)
+	MaxDelay        time.Duration // Longest injected delay; delays are uniform up to it
+	InsertErrorRate float64       // Share of inserts and batches failed as storage outages (0-1)
+	DisconnectEvery time.Duration // Interval between simulated broker disconnects (0 = never)
+}
+
+// Parse reads a spec such as "delay=0.1:500ms,insert_error=0.05,disconnect=5m"
+func Parse(spec string) (Config, error) {
+	var cfg Config
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return cfg, fmt.Errorf("invalid chaos setting %q: use name=value", part)
+		}
+		switch name {
+		case "delay":
+			rate, max, ok := strings.Cut(value, ":")
+			if !ok {
+				return cfg, fmt.Errorf("invalid chaos delay %q: use rate:max (e.g. 0.1:500ms)", value)
+			}
+			r, err := parseRate(rate)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid chaos delay: %w", err)
+			}
+			d, err := time.ParseDuration(max)
+			if err != nil || d <= 0 {
+				return cfg, fmt.Errorf("invalid chaos delay %q: max must be a positive duration", value)
+			}
+			cfg.DelayRate, cfg.MaxDelay = r, d
+		case "insert_error":
+			r, err := parseRate(value)
+			if err != nil {
+				return cfg, fmt.Errorf("invalid chaos insert_error: %w", err)
+			}
+			cfg.InsertErrorRate = r
+		case "disconnect":
+			d, err := time.ParseDuration(value)
+			if err != nil || d <= 0 {
+				return cfg, fmt.Errorf("invalid chaos disconnect %q: use a positive interval", value)
+			}
+			cfg.DisconnectEvery = d
+		default:
+			return cfg, fmt.Errorf("unknown chaos setting %q: use delay, insert_error or disconnect", name)
+		}
+	}
+	return cfg, nil
+}
+
+// parseRate parses a probability between 0 and 1
+func parseRate(s string) (float64, error) {
+	r, err := strconv.ParseFloat(s, 64)
+	if err != nil || r < 0 || r > 1 {
+		return 0, fmt.Errorf("rate %q must be between 0 and 1", s)
+	}
+	return r, nil
+}
+
+// String describes the configuration for the startup warning
+func (c Config) String() string {
+	return fmt.Sprintf("delay %.0f%% up to %s, insert errors %.0f%%, disconnect every %s",
+		c.DelayRate*100, c.MaxDelay, c.InsertErrorRate*100, c.DisconnectEvery)
+}
+
+// Disconnecter is implemented by sources that can simulate losing their broker
+type Disconnecter interface {
+	SimulateDisconnect()
+}
+
+// Injector injects the configured failures
+type Injector struct {
+	cfg    Config
+	logger *logger.Logger
+	mu     sync.Mutex
+	rng    *rand.Rand
+}
+
+// New creates an injector
+func New(cfg Config, log *logger.Logger) *Injector {
+	if log == nil {
+		log = logger.New(logger.INFO)
+	}
+	return &Injector{cfg: cfg, logger: log, rng: rand.New(rand.NewSource(time.Now().UnixNano()))}
+}
+
+// hit reports whether an event with probability rate happens
+func (i *Injector) hit(rate float64) bool {
+	if rate <= 0 {
+		return false
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.rng.Float64() < rate
+}
+
+// Delay sleeps before a transform for a random share of messages
+func (i *Injector) Delay() {
+	if !i.hit(i.cfg.DelayRate) {
+		return
+	}
+	i.mu.Lock()
+	d := time.Duration(i.rng.Int63n(int64(i.cfg.MaxDelay)) + 1)
+	i.mu.Unlock()
+	time.Sleep(d)
+}
+
+// Storage returns a stage in front of next that fails a random share of
+// writes with errs.ErrStorageUnavailable, like an unreachable database
+func (i *Injector) Storage(next sink.Storage) *Sink {
+	return &Sink{injector: i, next: next}
+}
+
+// Disconnect simulates a broker disconnect on a random target every
+// DisconnectEvery until ctx is done
+func (i *Injector) Disconnect(ctx context.Context, targets []Disconnecter) {
+	if i.cfg.DisconnectEvery <= 0 || len(targets) == 0 {
+		return
+	}
+	ticker := time.NewTicker(i.cfg.DisconnectEvery)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		i.mu.Lock()
+		target := targets[i.rng.Intn(len(targets))]
+		i.mu.Unlock()
+		i.logger.Warn("Chaos: simulating a broker disconnect")
+		target.SimulateDisconnect()
+	}
+}
+
+// Sink is the failing storage stage returned by Injector.Storage
+type Sink struct {
+	injector *Injector
+	next     sink.Storage
+}
+
+// errInjected is returned for failed writes
+var errInjected = fmt.Errorf("%w: chaos: injected insert failure", errs.ErrStorageUnavailable)
+
+// InsertIntoTable forwards the record unless a failure is injected
+func (s *Sink) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	if s.injector.hit(s.injector.cfg.InsertErrorRate) {
+		return errInjected
+	}
+	return s.next.InsertIntoTable(ctx, table, data)
+}
+
+// InsertBatch forwards the rows unless a failure is injected
+func (s *Sink) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error {
+	bs, ok := s.next.(sink.BatchStorage)
+	if !ok {
+		return sink.InsertEach(ctx, s, table, rows)
+	}
+	if s.injector.hit(s.injector.cfg.InsertErrorRate) {
+		return errInjected
+	}
+	return bs.InsertBatch(ctx, table, rows)
+}
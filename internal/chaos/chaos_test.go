@@ -0,0 +1,108 @@
+package chaos
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse("delay=0.1:500ms, insert_error=0.05,disconnect=5m")
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	want := Config{DelayRate: 0.1, MaxDelay: 500 * time.Millisecond, InsertErrorRate: 0.05, DisconnectEvery: 5 * time.Minute}
+	if cfg != want {
+		t.Errorf("Parse = %+v, want %+v", cfg, want)
+	}
+
+	for _, spec := range []string{
+		"delay=0.1",
+		"delay=2:1s",
+		"delay=0.1:0s",
+		"insert_error=-1",
+		"disconnect=never",
+		"latency=0.1",
+		"insert_error",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q): expected error", spec)
+		}
+	}
+}
+
+type countingStorage struct {
+	rows    int
+	batches int
+}
+
+func (s *countingStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	s.rows++
+	return nil
+}
+
+func (s *countingStorage) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error {
+	s.batches++
+	return nil
+}
+
+func TestStorageInjectsOutages(t *testing.T) {
+	next := &countingStorage{}
+	always := New(Config{InsertErrorRate: 1}, nil).Storage(next)
+	err := always.InsertIntoTable(context.Background(), "t", map[string]interface{}{"v": 1})
+	if !errors.Is(err, errs.ErrStorageUnavailable) {
+		t.Errorf("Expected an injected storage outage, got %v", err)
+	}
+	if err := always.InsertBatch(context.Background(), "t", []map[string]interface{}{{"v": 1}}); !errors.Is(err, errs.ErrStorageUnavailable) {
+		t.Errorf("Expected an injected batch failure, got %v", err)
+	}
+
+	never := New(Config{}, nil).Storage(next)
+	if err := never.InsertIntoTable(context.Background(), "t", map[string]interface{}{"v": 1}); err != nil {
+		t.Fatalf("InsertIntoTable failed: %v", err)
+	}
+	if err := never.InsertBatch(context.Background(), "t", []map[string]interface{}{{"v": 1}}); err != nil {
+		t.Fatalf("InsertBatch failed: %v", err)
+	}
+	if next.rows != 1 || next.batches != 1 {
+		t.Errorf("Expected writes to pass through, got rows=%d batches=%d", next.rows, next.batches)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	i := New(Config{DelayRate: 1, MaxDelay: 20 * time.Millisecond}, nil)
+	start := time.Now()
+	i.Delay()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Delay took %s, want at most 20ms", elapsed)
+	}
+}
+
+type bouncer struct{ n atomic.Int64 }
+
+func (b *bouncer) SimulateDisconnect() { b.n.Add(1) }
+
+func TestDisconnect(t *testing.T) {
+	i := New(Config{DisconnectEvery: 5 * time.Millisecond}, nil)
+	b := &bouncer{}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		i.Disconnect(ctx, []Disconnecter{b})
+		close(done)
+	}()
+
+	deadline := time.Now().Add(time.Second)
+	for b.n.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+	<-done
+	if b.n.Load() < 2 {
+		t.Errorf("Expected repeated disconnects, got %d", b.n.Load())
+	}
+}
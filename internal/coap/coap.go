@@ -0,0 +1,367 @@
+package coap
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/router"
+)
+
+// Message types (RFC 7252 section 3)
+const (
+	typeConfirmable    = 0
+	typeNonConfirmable = 1
+	typeAcknowledgment = 2
+	typeReset          = 3
+)
+
+// Method and response codes (class.detail packed as c<<5 | dd)
+const (
+	codeEmpty                 = 0x00
+	codePOST                  = 0x02
+	codePUT                   = 0x03
+	codeChanged               = 0x44 // 2.04
+	codeBadRequest            = 0x80 // 4.00
+	codeMethodNotAllowed      = 0x85 // 4.05
+	codeRequestEntityTooLarge = 0x8D // 4.13
+	codeServiceUnavailable    = 0xA3 // 5.03
+)
+
+// optionURIPath is the Uri-Path option number
+const optionURIPath = 11
+
+// payloadMarker separates options from the payload
+const payloadMarker = 0xFF
+
+// defaultMaxMessageSize bounds a single datagram when none is configured.
+const defaultMaxMessageSize = 1152
+
+// exchangeLifetime is how long a message ID identifies a retransmission of
+// the same request (EXCHANGE_LIFETIME, RFC 7252 section 4.8.2)
+const exchangeLifetime = 247 * time.Second
+
+// maxExchanges bounds the message IDs remembered for deduplication; the
+// oldest are forgotten first when a flood of requests exceeds it
+const maxExchanges = 10000
+
+// Config holds CoAP server configuration.
+type Config struct {
+	Address        string // Listen address (e.g. ":5683")
+	TopicPrefix    string // Prefix prepended to the URI path (e.g. "coap" -> "coap/sensors/1")
+	MaxMessageSize int    // Maximum datagram size in bytes (default: 1152)
+	Logger         *logger.Logger
+}
+
+// Server accepts CoAP POST/PUT requests and turns them into messages.
+// The URI path of each request becomes the message topic. Requests
+// retransmitted with the same message ID are answered again without being
+// dispatched twice (RFC 7252 section 4.5).
+type Server struct {
+	cfg    Config
+	conn   net.PacketConn
+	wg     sync.WaitGroup
+	once   sync.Once
+	logger *logger.Logger
+
+	exchanges map[string]exchange // Recent requests by sender and message ID
+	order     []string            // Keys of exchanges, oldest first
+	now       func() time.Time
+}
+
+// exchange is a request already handled, answered again on retransmission
+type exchange struct {
+	code byte
+	at   time.Time
+}
+
+// request is a parsed CoAP message
+type request struct {
+	msgType   byte
+	code      byte
+	messageID uint16
+	token     []byte
+	path      []string
+	payload   []byte
+}
+
+// New creates a new CoAP server bound to the configured UDP address.
+func New(cfg Config) (*Server, error) {
+	log := cfg.Logger
+	if log == nil {
+		log = logger.New(logger.INFO)
+	}
+	if cfg.MaxMessageSize <= 0 {
+		cfg.MaxMessageSize = defaultMaxMessageSize
+	}
+
+	conn, err := net.ListenPacket("udp", cfg.Address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on udp %s: %w", cfg.Address, err)
+	}
+
+	return &Server{
+		cfg:       cfg,
+		conn:      conn,
+		logger:    log,
+		exchanges: make(map[string]exchange),
+		now:       time.Now,
+	}, nil
+}
+
+// Addr returns the address the server is bound to.
+func (s *Server) Addr() net.Addr {
+	return s.conn.LocalAddr()
+}
+
+// Start begins serving requests in the background, dispatching each
+// POST/PUT as a message. The server is closed when ctx is cancelled.
+func (s *Server) Start(ctx context.Context, dispatch func(router.Message) error) error {
+	s.wg.Add(1)
+	go s.serve(dispatch)
+	go func() {
+		<-ctx.Done()
+		s.shutdown()
+	}()
+	s.logger.Infof("Listening for CoAP on %s (topic_prefix=%s)", s.Addr(), s.cfg.TopicPrefix)
+	return nil
+}
+
+// Close stops the server and waits for it to finish.
+func (s *Server) Close() {
+	s.shutdown()
+	s.wg.Wait()
+}
+
+// shutdown closes the socket exactly once.
+func (s *Server) shutdown() {
+	s.once.Do(func() {
+		s.conn.Close()
+		s.logger.Infof("CoAP server on %s closed", s.Addr())
+	})
+}
+
+// serve reads datagrams until the socket is closed.
+func (s *Server) serve(dispatch func(router.Message) error) {
+	defer s.wg.Done()
+	// One byte more than allowed tells oversize datagrams from full ones
+	buf := make([]byte, s.cfg.MaxMessageSize+1)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			s.logger.Errorf("Failed to read CoAP datagram on %s: %v", s.Addr(), err)
+			continue
+		}
+
+		oversize := n > s.cfg.MaxMessageSize
+		if oversize {
+			n = s.cfg.MaxMessageSize
+		}
+		req, err := parseMessage(buf[:n])
+		if err != nil {
+			s.logger.Debugf("Ignoring malformed CoAP message from %s: %v", addr, err)
+			continue
+		}
+
+		// An empty confirmable message is a ping, answered with a reset
+		if req.code == codeEmpty {
+			if req.msgType == typeConfirmable {
+				s.reply(addr, req, typeReset, codeEmpty)
+			}
+			continue
+		}
+
+		key := addr.String() + "/" + strconv.Itoa(int(req.messageID))
+		code, seen := s.seen(key)
+		if !seen {
+			if oversize {
+				s.logger.Warnf("Rejecting CoAP request from %s: datagram larger than max_message_size (%d bytes)",
+					addr, s.cfg.MaxMessageSize)
+				code = codeRequestEntityTooLarge
+			} else {
+				code = s.handle(req, dispatch)
+			}
+			s.remember(key, code)
+		} else {
+			s.logger.Debugf("CoAP request %d from %s is a retransmission; answering it again", req.messageID, addr)
+		}
+		if req.msgType == typeConfirmable {
+			s.reply(addr, req, typeAcknowledgment, code)
+		}
+	}
+}
+
+// seen returns the response to a request handled within the exchange lifetime
+func (s *Server) seen(key string) (byte, bool) {
+	e, ok := s.exchanges[key]
+	if !ok || s.now().Sub(e.at) > exchangeLifetime {
+		return 0, false
+	}
+	return e.code, true
+}
+
+// remember records a handled request, forgetting expired ones (and the
+// oldest beyond maxExchanges). Only serve calls it, so no lock is needed.
+func (s *Server) remember(key string, code byte) {
+	now := s.now()
+	for len(s.order) > 0 {
+		oldest := s.order[0]
+		if len(s.order) < maxExchanges && now.Sub(s.exchanges[oldest].at) <= exchangeLifetime {
+			break
+		}
+		delete(s.exchanges, oldest)
+		s.order = s.order[1:]
+	}
+	s.order = append(s.order, key)
+	s.exchanges[key] = exchange{code: code, at: now}
+}
+
+// handle dispatches a request and returns the response code: 2.04 once the
+// router accepted the message, 5.03 when it couldn't (e.g. its route's queue
+// is full), so the device can retry later.
+func (s *Server) handle(req *request, dispatch func(router.Message) error) byte {
+	switch req.code {
+	case codePOST, codePUT:
+	default:
+		return codeMethodNotAllowed
+	}
+
+	topic := s.topic(req.path)
+	if topic == "" {
+		return codeBadRequest
+	}
+
+	err := dispatch(router.Message{
+		Topic:   topic,
+		Payload: req.payload,
+		Time:    time.Now().UTC(),
+		Source:  "coap://" + s.cfg.Address,
+	})
+	if err != nil {
+		return codeServiceUnavailable
+	}
+	s.logger.Debugf("CoAP request dispatched to topic %s", topic)
+	return codeChanged
+}
+
+// topic maps URI path segments onto a topic, honoring the configured prefix.
+func (s *Server) topic(path []string) string {
+	segments := make([]string, 0, len(path)+1)
+	if s.cfg.TopicPrefix != "" {
+		segments = append(segments, strings.Trim(s.cfg.TopicPrefix, "/"))
+	}
+	for _, p := range path {
+		if p != "" {
+			segments = append(segments, p)
+		}
+	}
+	return strings.Join(segments, "/")
+}
+
+// reply answers a confirmable request: an ACK piggybacking the response
+// code, or an empty reset.
+func (s *Server) reply(addr net.Addr, req *request, msgType, code byte) {
+	token := req.token
+	if code == codeEmpty {
+		// Empty messages must not carry a token
+		token = nil
+	}
+
+	resp := make([]byte, 4, 4+len(token))
+	resp[0] = 1<<6 | msgType<<4 | byte(len(token))
+	resp[1] = code
+	binary.BigEndian.PutUint16(resp[2:], req.messageID)
+	resp = append(resp, token...)
+	if _, err := s.conn.WriteTo(resp, addr); err != nil {
+		s.logger.Errorf("Failed to send CoAP response to %s: %v", addr, err)
+	}
+}
+
+// parseMessage decodes a CoAP message (header, token, options, payload).
+func parseMessage(b []byte) (*request, error) {
+	if len(b) < 4 {
+		return nil, fmt.Errorf("message too short")
+	}
+	if version := b[0] >> 6; version != 1 {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+
+	req := &request{
+		msgType:   (b[0] >> 4) & 0x03,
+		code:      b[1],
+		messageID: binary.BigEndian.Uint16(b[2:4]),
+	}
+	if req.msgType == typeAcknowledgment || req.msgType == typeReset {
+		return nil, fmt.Errorf("unexpected message type %d", req.msgType)
+	}
+
+	tkl := int(b[0] & 0x0F)
+	if tkl > 8 || len(b) < 4+tkl {
+		return nil, fmt.Errorf("invalid token length %d", tkl)
+	}
+	req.token = b[4 : 4+tkl]
+
+	rest := b[4+tkl:]
+	number := 0
+	for len(rest) > 0 {
+		if rest[0] == payloadMarker {
+			if len(rest) == 1 {
+				return nil, fmt.Errorf("payload marker without payload")
+			}
+			req.payload = append([]byte(nil), rest[1:]...)
+			break
+		}
+
+		delta, length := int(rest[0]>>4), int(rest[0]&0x0F)
+		rest = rest[1:]
+
+		var err error
+		if delta, rest, err = extendOption(delta, rest); err != nil {
+			return nil, err
+		}
+		if length, rest, err = extendOption(length, rest); err != nil {
+			return nil, err
+		}
+		if len(rest) < length {
+			return nil, fmt.Errorf("option value truncated")
+		}
+
+		number += delta
+		if number == optionURIPath {
+			req.path = append(req.path, string(rest[:length]))
+		}
+		rest = rest[length:]
+	}
+
+	return req, nil
+}
+
+// extendOption decodes the extended option delta/length encoding.
+func extendOption(v int, b []byte) (int, []byte, error) {
+	switch v {
+	case 13:
+		if len(b) < 1 {
+			return 0, nil, fmt.Errorf("option truncated")
+		}
+		return int(b[0]) + 13, b[1:], nil
+	case 14:
+		if len(b) < 2 {
+			return 0, nil, fmt.Errorf("option truncated")
+		}
+		return int(binary.BigEndian.Uint16(b)) + 269, b[2:], nil
+	case 15:
+		return 0, nil, fmt.Errorf("reserved option nibble")
+	default:
+		return v, b, nil
+	}
+}
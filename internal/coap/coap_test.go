@@ -0,0 +1,281 @@
+package coap
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/router"
+)
+
+// buildRequest encodes a CoAP request with Uri-Path options and a payload
+func buildRequest(msgType, code byte, id uint16, token []byte, path []string, payload []byte) []byte {
+	b := []byte{1<<6 | msgType<<4 | byte(len(token)), code, byte(id >> 8), byte(id)}
+	b = append(b, token...)
+	prev := 0
+	for _, p := range path {
+		delta := optionURIPath - prev
+		prev = optionURIPath
+		if len(p) < 13 {
+			b = append(b, byte(delta<<4|len(p)))
+		} else {
+			b = append(b, byte(delta<<4|13), byte(len(p)-13))
+		}
+		b = append(b, p...)
+	}
+	if len(payload) > 0 {
+		b = append(b, payloadMarker)
+		b = append(b, payload...)
+	}
+	return b
+}
+
+func TestParseMessage(t *testing.T) {
+	raw := buildRequest(typeConfirmable, codePOST, 0x1234, []byte{0xAB}, []string{"sensors", "a-very-long-device-name"}, []byte(`{"t":21}`))
+
+	req, err := parseMessage(raw)
+	if err != nil {
+		t.Fatalf("parseMessage failed: %v", err)
+	}
+	if req.msgType != typeConfirmable || req.code != codePOST || req.messageID != 0x1234 {
+		t.Errorf("Unexpected header: %+v", req)
+	}
+	if len(req.token) != 1 || req.token[0] != 0xAB {
+		t.Errorf("Unexpected token: %v", req.token)
+	}
+	if len(req.path) != 2 || req.path[0] != "sensors" || req.path[1] != "a-very-long-device-name" {
+		t.Errorf("Unexpected path: %v", req.path)
+	}
+	if string(req.payload) != `{"t":21}` {
+		t.Errorf("Unexpected payload: %q", req.payload)
+	}
+}
+
+func TestParseMessageInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		raw  []byte
+	}{
+		{"too short", []byte{0x40, 0x02}},
+		{"wrong version", []byte{0x80, 0x02, 0x00, 0x01}},
+		{"token too long", []byte{0x49, 0x02, 0x00, 0x01}},
+		{"truncated option", []byte{0x40, 0x02, 0x00, 0x01, 0xB5, 'a'}},
+		{"marker without payload", []byte{0x40, 0x02, 0x00, 0x01, 0xFF}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseMessage(tt.raw); err == nil {
+				t.Error("Expected parse error")
+			}
+		})
+	}
+}
+
+func TestTopic(t *testing.T) {
+	s := &Server{cfg: Config{TopicPrefix: "coap/"}}
+	if got := s.topic([]string{"sensors", "1"}); got != "coap/sensors/1" {
+		t.Errorf("Expected 'coap/sensors/1', got %q", got)
+	}
+
+	s = &Server{}
+	if got := s.topic([]string{"sensors", "", "1"}); got != "sensors/1" {
+		t.Errorf("Expected 'sensors/1', got %q", got)
+	}
+}
+
+func TestServerDispatchAndAck(t *testing.T) {
+	s, err := New(Config{Address: "127.0.0.1:0", TopicPrefix: "coap"})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer s.Close()
+
+	var (
+		mu   sync.Mutex
+		msgs []router.Message
+	)
+	s.Start(context.Background(), func(msg router.Message) error {
+		mu.Lock()
+		msgs = append(msgs, msg)
+		mu.Unlock()
+		return nil
+	})
+
+	conn, err := net.Dial("udp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+
+	conn.Write(buildRequest(typeConfirmable, codePOST, 7, []byte{1, 2}, []string{"room", "kitchen"}, []byte("22.5")))
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp := make([]byte, 64)
+	n, err := conn.Read(resp)
+	if err != nil {
+		t.Fatalf("Failed to read ACK: %v", err)
+	}
+	resp = resp[:n]
+	if resp[0]>>4&0x03 != typeAcknowledgment || resp[1] != codeChanged || resp[3] != 7 {
+		t.Errorf("Unexpected ACK: %x", resp)
+	}
+	if n != 6 || resp[4] != 1 || resp[5] != 2 {
+		t.Errorf("Expected token echoed in ACK, got %x", resp)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(msgs) != 1 {
+		t.Fatalf("Expected 1 message, got %d", len(msgs))
+	}
+	if msgs[0].Topic != "coap/room/kitchen" || string(msgs[0].Payload) != "22.5" {
+		t.Errorf("Unexpected message: %s %q", msgs[0].Topic, msgs[0].Payload)
+	}
+}
+
+func TestServerRejectsGet(t *testing.T) {
+	s, err := New(Config{Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	defer s.Close()
+
+	dispatched := false
+	s.Start(context.Background(), func(router.Message) error {
+		dispatched = true
+		return nil
+	})
+
+	conn, _ := net.Dial("udp", s.Addr().String())
+	defer conn.Close()
+	conn.Write(buildRequest(typeConfirmable, 0x01, 9, nil, []string{"x"}, nil))
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp := make([]byte, 64)
+	n, err := conn.Read(resp)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	if n < 2 || resp[1] != codeMethodNotAllowed {
+		t.Errorf("Expected 4.05 response, got %x", resp[:n])
+	}
+	if dispatched {
+		t.Error("GET request must not be dispatched")
+	}
+}
+
+// exchangeWith sends a request and returns the server's response
+func exchangeWith(t *testing.T, conn net.Conn, raw []byte) []byte {
+	t.Helper()
+	if _, err := conn.Write(raw); err != nil {
+		t.Fatalf("Failed to send request: %v", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	resp := make([]byte, 64)
+	n, err := conn.Read(resp)
+	if err != nil {
+		t.Fatalf("Failed to read response: %v", err)
+	}
+	return resp[:n]
+}
+
+// startServer starts a server dispatching to fn and dials it
+func startServer(t *testing.T, cfg Config, fn func(router.Message) error) net.Conn {
+	t.Helper()
+	cfg.Address = "127.0.0.1:0"
+	s, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create server: %v", err)
+	}
+	t.Cleanup(s.Close)
+	s.Start(context.Background(), fn)
+
+	conn, err := net.Dial("udp", s.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+func TestServerReportsDispatchErrors(t *testing.T) {
+	conn := startServer(t, Config{}, func(router.Message) error {
+		return fmt.Errorf("route sensors/+: %w", errs.ErrQueueFull)
+	})
+	resp := exchangeWith(t, conn, buildRequest(typeConfirmable, codePOST, 3, nil, []string{"sensors", "1"}, []byte("1")))
+	if resp[1] != codeServiceUnavailable {
+		t.Errorf("Expected 5.03 when the message isn't accepted, got %x", resp)
+	}
+}
+
+func TestServerAnswersPingWithReset(t *testing.T) {
+	conn := startServer(t, Config{}, func(router.Message) error { return nil })
+	resp := exchangeWith(t, conn, buildRequest(typeConfirmable, codeEmpty, 11, nil, nil, nil))
+	if len(resp) != 4 || resp[0]>>4&0x03 != typeReset || resp[1] != codeEmpty || resp[3] != 11 {
+		t.Errorf("Expected an empty RST for a ping, got %x", resp)
+	}
+}
+
+func TestServerDeduplicatesRetransmissions(t *testing.T) {
+	var mu sync.Mutex
+	dispatched := 0
+	conn := startServer(t, Config{}, func(router.Message) error {
+		mu.Lock()
+		dispatched++
+		mu.Unlock()
+		return nil
+	})
+
+	// The same message ID is a retransmission; a new one is a new request
+	req := buildRequest(typeConfirmable, codePOST, 42, []byte{9}, []string{"sensors", "1"}, []byte("1"))
+	for i := 0; i < 3; i++ {
+		if resp := exchangeWith(t, conn, req); resp[1] != codeChanged || resp[3] != 42 {
+			t.Fatalf("Expected each retransmission acknowledged with 2.04, got %x", resp)
+		}
+	}
+	exchangeWith(t, conn, buildRequest(typeConfirmable, codePOST, 43, nil, []string{"sensors", "1"}, []byte("2")))
+
+	mu.Lock()
+	defer mu.Unlock()
+	if dispatched != 2 {
+		t.Errorf("Expected 2 messages dispatched, got %d", dispatched)
+	}
+}
+
+func TestServerExpiresExchanges(t *testing.T) {
+	now := time.Now()
+	s := &Server{exchanges: make(map[string]exchange), now: func() time.Time { return now }}
+	s.remember("a/1", codeChanged)
+	if code, ok := s.seen("a/1"); !ok || code != codeChanged {
+		t.Fatal("Expected the exchange remembered")
+	}
+	now = now.Add(exchangeLifetime + time.Second)
+	if _, ok := s.seen("a/1"); ok {
+		t.Error("Expected the exchange expired")
+	}
+	s.remember("a/2", codeChanged)
+	if len(s.exchanges) != 1 || len(s.order) != 1 {
+		t.Errorf("Expected expired exchanges forgotten, got %d", len(s.exchanges))
+	}
+}
+
+func TestServerRejectsOversizeDatagrams(t *testing.T) {
+	dispatched := false
+	conn := startServer(t, Config{MaxMessageSize: 64}, func(router.Message) error {
+		dispatched = true
+		return nil
+	})
+	payload := make([]byte, 100)
+	resp := exchangeWith(t, conn, buildRequest(typeConfirmable, codePOST, 5, nil, []string{"sensors"}, payload))
+	if resp[1] != codeRequestEntityTooLarge {
+		t.Errorf("Expected 4.13 for an oversize datagram, got %x", resp)
+	}
+	if dispatched {
+		t.Error("Oversize datagram must not be dispatched")
+	}
+}
@@ -3,27 +3,171 @@ package config
 import (
 	"fmt"
 	"os"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 )
 
 // Config represents the application configuration
 type Config struct {
-	MQTT     MQTTConfig     `toml:"mqtt"`
-	Database DatabaseConfig `toml:"database"`
-	Pipeline PipelineConfig `toml:"pipeline"`
-	Logging  LoggingConfig  `toml:"logging"`
-	Routes   []RouteConfig  `toml:"routes"` // New routing configuration
+	MQTT       MQTTConfig       `toml:"mqtt"`
+	NATS       NATSConfig       `toml:"nats"`
+	Listeners  []ListenerConfig `toml:"listeners"` // Raw UDP/TCP listener sources
+	Tail       []TailConfig     `toml:"tail"`      // File tail sources
+	CoAP       CoAPConfig       `toml:"coap"`      // CoAP listener source
+	Database   DatabaseConfig   `toml:"database"`
+	Pipeline   PipelineConfig   `toml:"pipeline"`
+	Logging    LoggingConfig    `toml:"logging"`
+	Routes     []RouteConfig    `toml:"routes"`     // New routing configuration
+	Alerts     []AlertConfig    `toml:"alerts"`     // Threshold alert rules
+	Lookups    []LookupConfig   `toml:"lookups"`    // Enrichment lookup tables
+	Devices    DevicesConfig    `toml:"devices"`    // Device registry settings
+	Gaps       GapsConfig       `toml:"gaps"`       // Device reporting gap events
+	Archive    ArchiveConfig    `toml:"archive"`    // Raw payload archive
+	Admin      AdminConfig      `toml:"admin"`      // Admin HTTP API
+	Quarantine QuarantineConfig `toml:"quarantine"` // Route quarantine alerts
+	Latest     LatestConfig     `toml:"latest"`     // Latest-value cache served by the admin API
+	Dedup      DedupConfig      `toml:"dedup"`      // Duplicate record suppression
+	Quota      QuotaConfig      `toml:"quota"`      // Per-table write quotas and disk space guard
+	Queues     QueuesConfig     `toml:"queues"`     // Route queue monitoring
+	Scripts    ScriptsConfig    `toml:"scripts"`    // Route script reloading
+	Limits     LimitsConfig     `toml:"limits"`     // Payload size limits
+	Filters    FiltersConfig    `toml:"filters"`    // Messages dropped before routing
+	JSON       JSONConfig       `toml:"json"`       // Payload JSON decoding
+	Columns    ColumnsConfig    `toml:"columns"`    // Record column naming
+	Provenance ProvenanceConfig `toml:"provenance"` // Provenance columns on script records
+	Sinks      []SinkConfig     `toml:"sinks"`      // Named sinks Lua records can target
+	Rewrites   []RewriteConfig  `toml:"rewrites"`   // Topic normalization before routing
+	Encryption EncryptionConfig `toml:"encryption"` // At-rest encryption of the queue spool and archive
+	Forward    ForwardConfig    `toml:"forward"`    // Record forwarding between edge and central instances
+
+	Passthrough PassthroughConfig `toml:"passthrough"` // Passthrough record format
+	ModbusMaps  []ModbusConfig    `toml:"modbus_maps"` // Register maps for modbus_decode
+
+	ErrorBudget ErrorBudgetConfig `toml:"error_budget"` // Route error budget alerts
+
+	Defaults RouteSettings `toml:"route_defaults"` // Settings every route inherits unless it sets them
+	Groups   RouteGroups   `toml:"route_groups"`   // Named settings routes opt into with group
 }
 
 // MQTTConfig holds MQTT broker configuration
 type MQTTConfig struct {
 	Broker   string   `toml:"broker"`
 	ClientID string   `toml:"client_id"`
+	Suffix   string   `toml:"client_id_suffix"` // Appended to client_id: "hostname" or "random" (empty = none)
 	Username string   `toml:"username"`
 	Password string   `toml:"password"`
 	Topics   []string `toml:"topics"`
 	QoS      byte     `toml:"qos"`
+
+	Standby     []string `toml:"standby_brokers"` // Broker URLs tried when broker can't be reached (e.g., ["ssl://standby:8883"])
+	BrokerOrder string   `toml:"broker_order"`    // "failover" (start with broker on every connect) or "round-robin" (default: "failover")
+
+	ManualAck    bool  `toml:"manual_ack"`    // Acknowledge QoS 1/2 messages once their records are written (default: false, on receipt)
+	CleanSession *bool `toml:"clean_session"` // false keeps the session, and messages queued in it, across restarts (default: true unless manual_ack)
+
+	CACert             string `toml:"ca_cert"`              // PEM CA file for TLS brokers (default: system roots)
+	ClientCert         string `toml:"client_cert"`          // PEM client certificate for mutual TLS
+	ClientKey          string `toml:"client_key"`           // PEM key of client_cert
+	ClientKeyPassword  string `toml:"client_key_password"`  // Passphrase of an encrypted client_key
+	InsecureSkipVerify bool   `toml:"insecure_skip_verify"` // Don't verify the broker certificate (testing only)
+	ServerName         string `toml:"server_name"`          // Name the broker certificate is verified for (default: broker host)
+
+	Headers map[string]string `toml:"headers"` // HTTP headers sent with the WebSocket handshake of ws:// and wss:// brokers
+	Token   string            `toml:"token"`   // Bearer token sent with the WebSocket handshake (Authorization header)
+
+	WillTopic   string `toml:"will_topic"`   // Last Will topic the broker publishes to when Hermod drops off (empty = none)
+	WillPayload string `toml:"will_payload"` // Last Will payload (e.g., "offline")
+	WillQoS     byte   `toml:"will_qos"`     // Last Will QoS (default: 0)
+	WillRetain  bool   `toml:"will_retain"`  // Retain the Last Will so dashboards see it after subscribing
+
+	ReconnectInitial string  `toml:"reconnect_initial"` // Delay before the first reconnect attempt (default: 1s)
+	ReconnectMax     string  `toml:"reconnect_max"`     // Longest delay between attempts, reached by doubling (default: 10m)
+	ReconnectJitter  float64 `toml:"reconnect_jitter"`  // Random spread of each delay as a fraction of it (0-1, default: 0)
+
+	Brokers []MQTTBroker `toml:"brokers"` // Further brokers routes read from with broker = "<name>"
+}
+
+// MQTTBroker is a named broker of [[mqtt.brokers]]. It takes the settings
+// of [mqtt] (topics and brokers aside); client_id_suffix, qos, broker_order
+// and the reconnect settings left unset are taken from [mqtt].
+type MQTTBroker struct {
+	Name string `toml:"name"`
+	MQTTConfig
+}
+
+// NamedBroker returns the settings of the [[mqtt.brokers]] entry called name
+func (m *MQTTConfig) NamedBroker(name string) (MQTTConfig, bool) {
+	for _, b := range m.Brokers {
+		if b.Name != name {
+			continue
+		}
+		mc := b.MQTTConfig
+		mc.Topics, mc.Brokers = nil, nil
+		if mc.Suffix == "" {
+			mc.Suffix = m.Suffix
+		}
+		if mc.QoS == 0 {
+			mc.QoS = m.QoS
+		}
+		if mc.BrokerOrder == "" {
+			mc.BrokerOrder = m.BrokerOrder
+		}
+		if mc.ReconnectInitial == "" {
+			mc.ReconnectInitial = m.ReconnectInitial
+		}
+		if mc.ReconnectMax == "" {
+			mc.ReconnectMax = m.ReconnectMax
+		}
+		if mc.ReconnectJitter == 0 {
+			mc.ReconnectJitter = m.ReconnectJitter
+		}
+		return mc, true
+	}
+	return MQTTConfig{}, false
+}
+
+// PersistentSession reports whether the broker should keep Hermod's session
+// across reconnects and restarts
+func (m *MQTTConfig) PersistentSession() bool {
+	if m.CleanSession != nil {
+		return !*m.CleanSession
+	}
+	return m.ManualAck
+}
+
+// NATSConfig holds NATS server configuration (optional source)
+type NATSConfig struct {
+	URL      string `toml:"url"`  // NATS server URL (empty = disabled)
+	Name     string `toml:"name"` // Connection name reported to the server
+	Username string `toml:"username"`
+	Password string `toml:"password"`
+	Token    string `toml:"token"`
+}
+
+// ListenerConfig holds a raw UDP/TCP listener source configuration
+type ListenerConfig struct {
+	Protocol     string `toml:"protocol"`       // "tcp" or "udp"
+	Address      string `toml:"address"`        // Listen address (e.g., ":5140")
+	Framing      string `toml:"framing"`        // "newline" (default) or "length" (TCP only)
+	Topic        string `toml:"topic"`          // Synthetic topic (default: "<protocol>/<port>")
+	MaxFrameSize int    `toml:"max_frame_size"` // Maximum frame size in bytes (default: 65536)
+}
+
+// TailConfig holds a file tail source configuration
+type TailConfig struct {
+	Path         string `toml:"path"`          // File to follow
+	Topic        string `toml:"topic"`         // Synthetic topic (default: "file/<basename>")
+	FromStart    bool   `toml:"from_start"`    // Read existing content on startup
+	PollInterval string `toml:"poll_interval"` // Poll interval (e.g., "500ms", default: 500ms)
+	MaxLineSize  int    `toml:"max_line_size"` // Longer lines are dropped (default: 1 MiB)
+}
+
+// CoAPConfig holds CoAP server configuration (optional source)
+type CoAPConfig struct {
+	Address        string `toml:"address"`          // UDP listen address (empty = disabled, e.g., ":5683")
+	TopicPrefix    string `toml:"topic_prefix"`     // Prefix prepended to URI paths (e.g., "coap")
+	MaxMessageSize int    `toml:"max_message_size"` // Maximum datagram size in bytes (default: 1152)
 }
 
 // DatabaseConfig holds PostgreSQL/TimescaleDB configuration
@@ -33,8 +177,18 @@ type DatabaseConfig struct {
 	User     string `toml:"user"`
 	Password string `toml:"password"`
 	Database string `toml:"database"`
-	SSLMode  string `toml:"sslmode"`
+	SSLMode  string `toml:"sslmode"` // disable, require, verify-ca or verify-full
 	PoolSize int    `toml:"pool_size"`
+
+	SSLRootCert string `toml:"sslrootcert"` // CA certificate used by verify-ca/verify-full
+	SSLCert     string `toml:"sslcert"`     // Client certificate (optional)
+	SSLKey      string `toml:"sslkey"`      // Client certificate key (optional)
+
+	DDLUser     string `toml:"ddl_user"`     // Role for schema operations (default: user)
+	DDLPassword string `toml:"ddl_password"` // Password for ddl_user
+	AutoMigrate bool   `toml:"auto_migrate"` // Apply the schema generated from Lua scripts at startup
+
+	ExpiryInterval string `toml:"expiry_interval"` // How often expired rows are deleted from tables with a schema retention (default: "1h")
 }
 
 // PipelineConfig holds pipeline configuration
@@ -45,7 +199,7 @@ type PipelineConfig struct {
 
 // LoggingConfig holds logging configuration
 type LoggingConfig struct {
-	Level string `toml:"level"` // DEBUG, INFO, or ERROR
+	Level string `toml:"level"` // DEBUG, INFO, WARN, or ERROR
 }
 
 // RouteConfig holds a single route configuration
@@ -54,7 +208,444 @@ type RouteConfig struct {
 	Script    string `toml:"script"`     // Path to Lua script (empty = passthrough)
 	Workers   int    `toml:"workers"`    // Number of worker goroutines (default: 1)
 	QueueSize int    `toml:"queue_size"` // Buffered channel size (default: 100)
-	Table     string `toml:"table"`      // Default table name (default: iot_data)
+	Table     string `toml:"table"`      // Default table name (default: iot_data; iot_raw without a script)
+	Group     string `toml:"group"`      // Inherit unset settings from [route_groups.<group>] (empty = none)
+	Broker    string `toml:"broker"`     // Read from this [[mqtt.brokers]] entry (empty = [mqtt] and other sources)
+
+	Downsample *DownsampleConfig `toml:"downsample"` // Optional per-route aggregation
+	DeviceID   string            `toml:"device_id"`  // Device-id expression (e.g., "topic[2]", "json.mac")
+	GapAfter   string            `toml:"gap_after"`  // Report devices silent for longer than this (e.g., "15m"; needs device_id)
+	Mask       *MaskConfig       `toml:"mask"`       // Optional column anonymization
+	Reorder    string            `toml:"reorder"`    // Hold records this long and write them sorted by time (e.g., "30s")
+	Batch      *BatchConfig      `toml:"batch"`      // Optional insert batching off the worker path
+	Timestamp  *TimestampConfig  `toml:"timestamp"`  // Optional device timestamp parsing
+
+	QuarantineAfter int `toml:"quarantine_after"` // Divert to passthrough after N consecutive script errors (0 = never)
+	LuaRecycle      int `toml:"lua_recycle"`      // Give each worker a fresh Lua state after N messages (0 = never)
+
+	MaxPayload int    `toml:"max_payload"` // Overrides limits.max_payload for this route (0 = global limit)
+	Oversize   string `toml:"oversize"`    // Overrides limits.oversize for this route
+
+	PayloadFormat   string `toml:"payload_format"`   // json, cbor, msgpack, text, binary or a registered decoder (default: try JSON)
+	PayloadEncoding string `toml:"payload_encoding"` // Character encoding of payloads: utf-8, latin-1 or utf-16le (default: utf-8)
+	PayloadSchema   string `toml:"payload_schema"`   // JSON Schema file payloads must match before the script runs
+	RejectTable     string `toml:"reject_table"`     // Table rejected messages are stored in (default: dropped)
+	MaxRecords      int    `toml:"max_records"`      // Reject messages transformed into more records than this (0 = unlimited)
+
+	PassthroughTable string `toml:"passthrough_table"` // Table a route without a script stores passthrough records in (default: table, else iot_raw)
+
+	ErrorBudget float64 `toml:"error_budget"` // Overrides error_budget.percent for this route (0 = global budget)
+
+	ProcessingTimeout string `toml:"processing_timeout"` // Longest transform + insert of one message (e.g., "5s"; empty = no limit)
+
+	LatestKey string            `toml:"latest_key"` // Also upsert records into <table>_latest keyed by this column (e.g., "device_id")
+	Tags      map[string]string `toml:"tags"`       // Static columns added to every script record (e.g., tags = {site="plant-3", line="A"})
+
+	Retained   string `toml:"retained"`    // Retained message policy: process, skip or state (default: process)
+	StateTable string `toml:"state_table"` // Table for retained messages under the state policy (default: iot_state)
+
+	Deny      []string `toml:"deny"`       // Topic filters dropped in addition to [filters]
+	DenyRegex []string `toml:"deny_regex"` // Topic regexes dropped in addition to [filters]
+	MinQoS    byte     `toml:"min_qos"`    // Drop messages delivered below this QoS (default: 0)
+
+	PriorityClass string `toml:"priority_class"` // "high" processes this route ahead of normal ones while it has messages queued (default: "normal")
+	LazySubscribe bool   `toml:"lazy_subscribe"` // Subscribe to filter (MQTT) only while the route's storage (its sink, else the database) is reachable (default: false)
+
+	UnknownColumns string `toml:"unknown_columns"` // Columns the Lua schema doesn't declare: "reject" the record or "drop" them (default: "reject")
+
+	TableSuffix string `toml:"table_suffix"` // Write records into per-period tables by their time: daily, monthly or yearly (default: none)
+
+	Sink string `toml:"sink"` // Named [[sinks]] entry records that don't set a sink are written to (default: the database)
+}
+
+// RouteSettings holds route settings shared by [route_defaults] and
+// [route_groups.<name>]. A route inherits each one it leaves unset, from
+// its group first and then from the defaults; tags are merged by key.
+type RouteSettings struct {
+	Workers           int               `toml:"workers"`
+	QueueSize         int               `toml:"queue_size"`
+	Batch             *BatchConfig      `toml:"batch"`
+	Timestamp         *TimestampConfig  `toml:"timestamp"`
+	QuarantineAfter   int               `toml:"quarantine_after"`
+	LuaRecycle        int               `toml:"lua_recycle"`
+	MaxPayload        int               `toml:"max_payload"`
+	Oversize          string            `toml:"oversize"`
+	PayloadFormat     string            `toml:"payload_format"`
+	PayloadEncoding   string            `toml:"payload_encoding"`
+	RejectTable       string            `toml:"reject_table"`
+	PassthroughTable  string            `toml:"passthrough_table"`
+	ErrorBudget       float64           `toml:"error_budget"`
+	MaxRecords        int               `toml:"max_records"`
+	ProcessingTimeout string            `toml:"processing_timeout"`
+	Retained          string            `toml:"retained"`
+	StateTable        string            `toml:"state_table"`
+	MinQoS            byte              `toml:"min_qos"`
+	Tags              map[string]string `toml:"tags"`
+	PriorityClass     string            `toml:"priority_class"`
+	Broker            string            `toml:"broker"`
+	UnknownColumns    string            `toml:"unknown_columns"`
+	TableSuffix       string            `toml:"table_suffix"`
+	Sink              string            `toml:"sink"`
+}
+
+// RouteGroups maps group names to their shared route settings
+type RouteGroups map[string]RouteSettings
+
+// apply fills the settings rc hasn't set from s. defined holds the keys s
+// sets in the file and set the keys rc already has; both are matched by
+// key rather than by value, so an explicit 0 or "" on a route still wins.
+func (s *RouteSettings) apply(rc *RouteConfig, defined, set map[string]bool) {
+	for _, f := range []struct {
+		key  string
+		copy func()
+	}{
+		{"workers", func() { rc.Workers = s.Workers }},
+		{"queue_size", func() { rc.QueueSize = s.QueueSize }},
+		{"quarantine_after", func() { rc.QuarantineAfter = s.QuarantineAfter }},
+		{"lua_recycle", func() { rc.LuaRecycle = s.LuaRecycle }},
+		{"max_payload", func() { rc.MaxPayload = s.MaxPayload }},
+		{"max_records", func() { rc.MaxRecords = s.MaxRecords }},
+		{"oversize", func() { rc.Oversize = s.Oversize }},
+		{"payload_format", func() { rc.PayloadFormat = s.PayloadFormat }},
+		{"payload_encoding", func() { rc.PayloadEncoding = s.PayloadEncoding }},
+		{"reject_table", func() { rc.RejectTable = s.RejectTable }},
+		{"passthrough_table", func() { rc.PassthroughTable = s.PassthroughTable }},
+		{"processing_timeout", func() { rc.ProcessingTimeout = s.ProcessingTimeout }},
+		{"retained", func() { rc.Retained = s.Retained }},
+		{"state_table", func() { rc.StateTable = s.StateTable }},
+		{"priority_class", func() { rc.PriorityClass = s.PriorityClass }},
+		{"broker", func() { rc.Broker = s.Broker }},
+		{"unknown_columns", func() { rc.UnknownColumns = s.UnknownColumns }},
+		{"table_suffix", func() { rc.TableSuffix = s.TableSuffix }},
+		{"sink", func() { rc.Sink = s.Sink }},
+		{"min_qos", func() { rc.MinQoS = s.MinQoS }},
+		{"error_budget", func() { rc.ErrorBudget = s.ErrorBudget }},
+		{"batch", func() {
+			rc.Batch = nil
+			if s.Batch != nil {
+				b := *s.Batch
+				rc.Batch = &b
+			}
+		}},
+		{"timestamp", func() {
+			rc.Timestamp = nil
+			if s.Timestamp != nil {
+				ts := *s.Timestamp
+				rc.Timestamp = &ts
+			}
+		}},
+	} {
+		if defined[f.key] && !set[f.key] {
+			f.copy()
+			set[f.key] = true
+		}
+	}
+	if len(s.Tags) > 0 {
+		tags := make(map[string]string, len(s.Tags)+len(rc.Tags))
+		for k, v := range s.Tags {
+			tags[k] = v
+		}
+		for k, v := range rc.Tags {
+			tags[k] = v
+		}
+		rc.Tags = tags
+	}
+}
+
+// routeKeys lists the keys each route, group and the defaults set in the
+// file, which the decoded structs can't tell apart from zero values
+type routeKeys struct {
+	Routes   []map[string]any          `toml:"routes"`
+	Defaults map[string]any            `toml:"route_defaults"`
+	Groups   map[string]map[string]any `toml:"route_groups"`
+}
+
+// keySet returns the keys of m as a set
+func keySet(m map[string]any) map[string]bool {
+	set := make(map[string]bool, len(m))
+	for k := range m {
+		set[k] = true
+	}
+	return set
+}
+
+// applyRouteSettings fills every route's unset settings from its group and
+// the route defaults
+func (c *Config) applyRouteSettings(keys routeKeys) error {
+	defaults := keySet(keys.Defaults)
+	for i := range c.Routes {
+		rc := &c.Routes[i]
+		var route map[string]any
+		if i < len(keys.Routes) {
+			route = keys.Routes[i]
+		}
+		set := keySet(route)
+		if rc.Group != "" {
+			group, ok := c.Groups[rc.Group]
+			if !ok {
+				return fmt.Errorf("route %s: unknown group %q", rc.Filter, rc.Group)
+			}
+			group.apply(rc, keySet(keys.Groups[rc.Group]), set)
+		}
+		c.Defaults.apply(rc, defaults, set)
+	}
+	return nil
+}
+
+// validateBrokers checks the [[mqtt.brokers]] entries and that routes only
+// name existing ones
+func (c *Config) validateBrokers() error {
+	if c.MQTT.ManualAck && !c.MQTT.PersistentSession() {
+		return fmt.Errorf("mqtt: manual_ack needs a persistent session; remove clean_session = true")
+	}
+	if c.MQTT.ManualAck && c.MQTT.QoS == 0 {
+		return fmt.Errorf("mqtt: manual_ack needs qos 1 or 2; QoS 0 messages are never redelivered")
+	}
+	names := make(map[string]bool, len(c.MQTT.Brokers))
+	for _, b := range c.MQTT.Brokers {
+		switch {
+		case b.ManualAck && !b.PersistentSession():
+			return fmt.Errorf("mqtt broker %q: manual_ack needs a persistent session; remove clean_session = true", b.Name)
+		case b.ManualAck && b.QoS == 0 && c.MQTT.QoS == 0:
+			return fmt.Errorf("mqtt broker %q: manual_ack needs qos 1 or 2; QoS 0 messages are never redelivered", b.Name)
+		case b.Name == "":
+			return fmt.Errorf("mqtt broker %s: name is required", b.Broker)
+		case names[b.Name]:
+			return fmt.Errorf("mqtt broker %q: duplicate name", b.Name)
+		case b.Broker == "":
+			return fmt.Errorf("mqtt broker %q: broker is required", b.Name)
+		}
+		names[b.Name] = true
+	}
+	for _, rc := range c.Routes {
+		if rc.Broker != "" && !names[rc.Broker] {
+			return fmt.Errorf("route %s: unknown broker %q", rc.Filter, rc.Broker)
+		}
+	}
+	return nil
+}
+
+// MaskConfig holds per-route column masking settings
+// (e.g., mask = {columns=["mac"], strategy="hash", salt="..."})
+type MaskConfig struct {
+	Columns  []string `toml:"columns"`  // Columns to mask
+	Strategy string   `toml:"strategy"` // hash, truncate or drop
+	Salt     string   `toml:"salt"`     // HMAC key for hash (recommended)
+	Keep     int      `toml:"keep"`     // Characters kept by truncate (default: 8)
+}
+
+// BatchConfig holds per-route insert batching settings
+// (e.g., batch = {size=500, linger="200ms"})
+type BatchConfig struct {
+	Size      int    `toml:"size"`      // Rows per table written together (default: 100)
+	Linger    string `toml:"linger"`    // Longest a row waits for its batch to fill (default: "100ms")
+	Chunk     string `toml:"chunk"`     // Hypertable chunk interval rows are grouped by (e.g., "24h"; empty = arrival order)
+	Partition string `toml:"partition"` // Space partition column rows are grouped by within a chunk (e.g., "sensor_id")
+}
+
+// TimestampConfig holds per-route device timestamp settings
+// (e.g., timestamp = {field="ts", unit="ms", timezone="Europe/Stockholm"})
+type TimestampConfig struct {
+	Field    string `toml:"field"`    // Dotted JSON path of the device timestamp (default: arrival time)
+	Unit     string `toml:"unit"`     // Unit of numeric epoch timestamps: s, ms or us (default: s)
+	Timezone string `toml:"timezone"` // IANA zone of timestamps without an offset (default: UTC)
+}
+
+// DownsampleConfig holds per-route aggregation settings
+// (e.g., downsample = {interval="60s", agg={value="avg", battery="last"}})
+type DownsampleConfig struct {
+	Interval string            `toml:"interval"` // Bucket width (e.g., "60s")
+	Agg      map[string]string `toml:"agg"`      // Column -> avg, min, max, sum, count, first, last
+}
+
+// AlertConfig holds a threshold alert rule
+type AlertConfig struct {
+	Name      string `toml:"name"`      // Rule name (default: "<table>.<column>")
+	Table     string `toml:"table"`     // Table the rule applies to
+	Column    string `toml:"column"`    // Numeric column to test
+	Condition string `toml:"condition"` // Comparison, e.g., "> 80"
+	For       string `toml:"for"`       // How long the condition must hold (e.g., "5m")
+	Debounce  string `toml:"debounce"`  // Minimum time between repeated alerts (e.g., "15m")
+	Key       string `toml:"key"`       // Optional device column (e.g., "sensor_id")
+	Topic     string `toml:"topic"`     // MQTT topic to publish alerts to
+	Webhook   string `toml:"webhook"`   // URL to POST alerts to
+}
+
+// LookupConfig holds an enrichment lookup table loaded from CSV or SQL
+type LookupConfig struct {
+	Name    string `toml:"name"`    // Name used from Lua: lookup("devices", key)
+	CSV     string `toml:"csv"`     // Path to CSV file with header row
+	Query   string `toml:"query"`   // SQL query (alternative to csv)
+	Key     string `toml:"key"`     // Key column (default: first column)
+	Refresh string `toml:"refresh"` // Reload interval (e.g., "5m", empty = load once)
+}
+
+// ModbusConfig holds a named register map for Modbus register dumps
+type ModbusConfig struct {
+	Name   string              `toml:"name"`   // Name used from Lua: modbus_decode(payload, "meter")
+	Start  int                 `toml:"start"`  // Address of the first register in the dump (default: 0)
+	Fields []ModbusFieldConfig `toml:"fields"` // Values in the dump
+}
+
+// ModbusFieldConfig names a value in a register dump
+type ModbusFieldConfig struct {
+	Name     string  `toml:"name"`     // Field name in the decoded table
+	Register int     `toml:"register"` // Address of the value's first register
+	Type     string  `toml:"type"`     // uint16, int16, uint32, int32, float32, uint64, int64 or float64 (default: uint16)
+	Order    string  `toml:"order"`    // Byte order of multi-register values: ABCD, CDAB, BADC or DCBA (default: ABCD)
+	Scale    float64 `toml:"scale"`    // Multiplier applied to the raw value (default: 1)
+	Offset   float64 `toml:"offset"`   // Added after scaling (default: 0)
+}
+
+// DevicesConfig holds device registry settings
+type DevicesConfig struct {
+	FlushInterval string `toml:"flush_interval"` // How often hermod_devices is updated (default: "10s")
+}
+
+// GapsConfig holds where device gap events go (routes opt in with gap_after)
+type GapsConfig struct {
+	Table         string `toml:"table"`          // Table gap events are stored in (empty = not stored)
+	Topic         string `toml:"topic"`          // MQTT topic gap events are published to
+	Webhook       string `toml:"webhook"`        // URL gap events are POSTed to
+	CheckInterval string `toml:"check_interval"` // How often devices are checked for gaps (default: "10s")
+}
+
+// ArchiveConfig holds raw payload archive settings (optional)
+type ArchiveConfig struct {
+	Dir           string `toml:"dir"`            // Directory for hourly gzip'd NDJSON files (empty = disabled)
+	FlushInterval string `toml:"flush_interval"` // How often archive data is flushed to disk (default: "5s")
+}
+
+// EncryptionConfig holds the key files Hermod buffers telemetry in are
+// encrypted with (optional)
+type EncryptionConfig struct {
+	KeyFile string `toml:"key_file"` // File holding a 256-bit key as 64 hex digits (empty = unencrypted)
+	KeyEnv  string `toml:"key_env"`  // Environment variable holding the key instead of key_file
+}
+
+// ForwardConfig connects an edge Hermod, which sends its records to a central
+// one instead of writing them itself, to the central Hermod receiving them
+type ForwardConfig struct {
+	URL     string `toml:"url"`      // Edge: central receiver to send records to (e.g., "https://central:7400")
+	Timeout string `toml:"timeout"`  // Edge: longest a forwarding request may take (default: 10s)
+	Listen  string `toml:"listen"`   // Central: address to accept forwarded records on (e.g., ":7400")
+	Token   string `toml:"token"`    // Bearer token the central receiver requires
+	TLSCert string `toml:"tls_cert"` // Central: PEM certificate to serve HTTPS with (optional)
+	TLSKey  string `toml:"tls_key"`  // Central: PEM key of tls_cert
+}
+
+// AdminConfig holds admin HTTP API settings (optional)
+type AdminConfig struct {
+	Address   string `toml:"address"`    // Listen address (empty = disabled, e.g., "127.0.0.1:8080")
+	TailToken string `toml:"tail_token"` // Token required by the /tail WebSocket (empty = disabled)
+}
+
+// QuarantineConfig holds where route quarantine alerts are sent
+type QuarantineConfig struct {
+	Topic   string `toml:"topic"`   // MQTT topic for quarantine alerts
+	Webhook string `toml:"webhook"` // URL to POST quarantine alerts to
+}
+
+// ErrorBudgetConfig holds route error budget alert settings (optional)
+type ErrorBudgetConfig struct {
+	Percent     float64 `toml:"percent"`      // Largest share of a route's messages that may fail, in percent (0 = disabled)
+	Window      string  `toml:"window"`       // Rolling window the error rate is measured over (default: "5m")
+	MinMessages int     `toml:"min_messages"` // Messages within the window before the rate counts (default: 20)
+	Topic       string  `toml:"topic"`        // MQTT topic for error budget alerts
+	Webhook     string  `toml:"webhook"`      // URL to POST error budget alerts to
+}
+
+// LatestConfig holds latest-value cache settings (optional)
+type LatestConfig struct {
+	Key    string            `toml:"key"`    // Default device column (e.g., "sensor_id"; empty = only listed tables)
+	Tables map[string]string `toml:"tables"` // Per-table device column (e.g., {p1_readings = "meter_id"})
+}
+
+// DedupConfig holds duplicate record suppression settings (optional)
+type DedupConfig struct {
+	Window string   `toml:"window"` // Drop records identical to one stored this recently (e.g., "10m"; empty = disabled)
+	Ignore []string `toml:"ignore"` // Columns left out of the comparison (e.g., ["time"] for arrival times)
+}
+
+// QuotaConfig holds per-table write quotas and the free-disk-space guard (optional)
+type QuotaConfig struct {
+	RowsPerDay     map[string]int64 `toml:"rows_per_day"`     // Rows stored per table and UTC day (e.g., {readings = 1000000})
+	DiskPath       string           `toml:"disk_path"`        // Filesystem whose free space is checked (empty = no check)
+	MinFreePercent float64          `toml:"min_free_percent"` // Free space below which every table is limited (e.g., 10)
+	CheckInterval  string           `toml:"check_interval"`   // How often free space is checked (default: "1m")
+	Action         string           `toml:"action"`           // "sample" or "dlq" (default: "sample")
+	SampleEvery    int              `toml:"sample_every"`     // With sample: store one row in this many (default: 10)
+	OverflowTable  string           `toml:"overflow_table"`   // With dlq: table rows are diverted to (default: "hermod_quota_overflow")
+	Topic          string           `toml:"topic"`            // MQTT topic for quota alerts
+	Webhook        string           `toml:"webhook"`          // URL to POST quota alerts to
+}
+
+// Enabled reports whether a quota or the disk space guard is configured
+func (q *QuotaConfig) Enabled() bool {
+	return len(q.RowsPerDay) > 0 || q.DiskPath != ""
+}
+
+// QueuesConfig holds route queue monitoring settings (optional)
+type QueuesConfig struct {
+	WarnPercent  int    `toml:"warn_percent"`  // Log a warning when a route queue is this full (0 = disabled)
+	ClearPercent int    `toml:"clear_percent"` // Warn again only after draining to this level (default: warn_percent/2)
+	SpoolDir     string `toml:"spool_dir"`     // Save queued messages here on shutdown and restore them on startup
+}
+
+// ScriptsConfig holds route script reload settings (optional)
+type ScriptsConfig struct {
+	WatchInterval string `toml:"watch_interval"` // How often script files are checked for changes (e.g., "2s", empty = disabled)
+	SlowThreshold string `toml:"slow_threshold"` // Log transforms slower than this (e.g., "50ms", empty = disabled)
+	Timeout       string `toml:"timeout"`        // Longest single run of a script: loading it, or one transform or hook call (default: "10s")
+}
+
+// LimitsConfig holds payload size limits (optional)
+type LimitsConfig struct {
+	MaxPayload int    `toml:"max_payload"` // Largest accepted payload in bytes (0 = unlimited)
+	Oversize   string `toml:"oversize"`    // "drop" (default) or "truncate"
+}
+
+// FiltersConfig holds dispatch-time message filters (optional)
+type FiltersConfig struct {
+	Deny      []string `toml:"deny"`       // MQTT topic filters to drop (e.g., "$SYS/#")
+	DenyRegex []string `toml:"deny_regex"` // Regular expressions matched against the topic
+	MinQoS    byte     `toml:"min_qos"`    // Drop messages delivered below this QoS (default: 0)
+}
+
+// JSONConfig holds payload JSON decoding settings (optional)
+type JSONConfig struct {
+	Numbers string `toml:"numbers"` // "exact" (default: integers kept as int64) or "float" (all float64)
+}
+
+// ColumnsConfig holds record column name settings (optional)
+type ColumnsConfig struct {
+	Case string `toml:"case"` // "preserve" (default), "lowercase" or "reject-mixed"
+}
+
+// ProvenanceConfig holds the provenance column settings (optional)
+type ProvenanceConfig struct {
+	Enabled  bool   `toml:"enabled"`  // Append hermod_* provenance columns to script records
+	Instance string `toml:"instance"` // Value of hermod_instance (default: host name)
+}
+
+// PassthroughConfig holds passthrough record settings (optional)
+type PassthroughConfig struct {
+	Checksum bool `toml:"checksum"` // Add raw_sha256, the hex SHA-256 of the raw payload (default: false)
+}
+
+// SinkConfig holds a named sink that Lua records can target with sink = "name"
+type SinkConfig struct {
+	Name     string          `toml:"name"`     // Name used in Lua records
+	Type     string          `toml:"type"`     // postgres or jsonl
+	Database *DatabaseConfig `toml:"database"` // Connection for postgres sinks; empty fields inherit [database]
+	Path     string          `toml:"path"`     // File for jsonl sinks
+}
+
+// RewriteConfig holds a topic rewrite rule, applied in order before routing
+type RewriteConfig struct {
+	StripPrefix string `toml:"strip_prefix"` // Leading topic levels to remove (e.g., "legacy/")
+	Match       string `toml:"match"`        // Topic filter selecting topics to rewrite (e.g., "plant/+/t")
+	Replace     string `toml:"replace"`      // New topic; {N} = Nth level, {#} = levels matched by "#"
 }
 
 // Load reads and parses the TOML configuration file
@@ -68,14 +659,89 @@ func Load(path string) (*Config, error) {
 	if err := toml.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
+	var keys routeKeys
+	if err := toml.Unmarshal(data, &keys); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	if err := cfg.applyRouteSettings(keys); err != nil {
+		return nil, err
+	}
+	if err := cfg.validateBrokers(); err != nil {
+		return nil, err
+	}
 
 	return &cfg, nil
 }
 
 // ConnectionString returns the PostgreSQL connection string
 func (d *DatabaseConfig) ConnectionString() string {
-	return fmt.Sprintf(
+	conn := fmt.Sprintf(
 		"host=%s port=%d user=%s password=%s dbname=%s sslmode=%s pool_max_conns=%d",
-		d.Host, d.Port, d.User, d.Password, d.Database, d.SSLMode, d.PoolSize,
+		connValue(d.Host), d.Port, connValue(d.User), connValue(d.Password), connValue(d.Database),
+		connValue(d.SSLMode), d.PoolSize,
 	)
+	for _, opt := range []struct{ key, value string }{
+		{"sslrootcert", d.SSLRootCert},
+		{"sslcert", d.SSLCert},
+		{"sslkey", d.SSLKey},
+	} {
+		if opt.value != "" {
+			conn += " " + opt.key + "=" + connValue(opt.value)
+		}
+	}
+	return conn
+}
+
+// SinkDatabase returns the connection settings of a postgres sink: fields
+// the sink leaves empty are taken from base
+func (s *SinkConfig) SinkDatabase(base DatabaseConfig) DatabaseConfig {
+	if s.Database == nil {
+		return base
+	}
+	d := *s.Database
+	for _, f := range []struct {
+		dst *string
+		src string
+	}{
+		{&d.Host, base.Host},
+		{&d.User, base.User},
+		{&d.Password, base.Password},
+		{&d.Database, base.Database},
+		{&d.SSLMode, base.SSLMode},
+		{&d.SSLRootCert, base.SSLRootCert},
+		{&d.SSLCert, base.SSLCert},
+		{&d.SSLKey, base.SSLKey},
+	} {
+		if *f.dst == "" {
+			*f.dst = f.src
+		}
+	}
+	if d.Port == 0 {
+		d.Port = base.Port
+	}
+	if d.PoolSize == 0 {
+		d.PoolSize = base.PoolSize
+	}
+	return d
+}
+
+// DDLConnectionString returns the connection string used for schema
+// operations: the DDL role when configured, with a single connection
+func (d *DatabaseConfig) DDLConnectionString() string {
+	ddl := *d
+	if d.DDLUser != "" {
+		ddl.User = d.DDLUser
+		ddl.Password = d.DDLPassword
+	}
+	ddl.PoolSize = 1
+	return ddl.ConnectionString()
+}
+
+// connValue quotes a connection string value when it is empty or contains
+// spaces, quotes or backslashes
+func connValue(v string) string {
+	if v != "" && !strings.ContainsAny(v, " '\\") {
+		return v
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(v) + "'"
 }
@@ -3,6 +3,7 @@ package config
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 )
 
@@ -127,6 +128,34 @@ func TestDatabaseConfigConnectionString(t *testing.T) {
 			},
 			want: "host=db.example.com port=5433 user=admin password=secret123 dbname=proddb sslmode=require pool_max_conns=20",
 		},
+		{
+			name: "connection string with certificate verification",
+			config: DatabaseConfig{
+				Host:        "db.example.com",
+				Port:        5432,
+				User:        "hermod",
+				Password:    "it's secret",
+				Database:    "iot",
+				SSLMode:     "verify-full",
+				PoolSize:    5,
+				SSLRootCert: "/etc/hermod/ca.pem",
+				SSLCert:     "/etc/hermod/client.pem",
+				SSLKey:      "/etc/hermod/client key.pem",
+			},
+			want: "host=db.example.com port=5432 user=hermod password='it\\'s secret' dbname=iot sslmode=verify-full pool_max_conns=5" +
+				" sslrootcert=/etc/hermod/ca.pem sslcert=/etc/hermod/client.pem sslkey='/etc/hermod/client key.pem'",
+		},
+		{
+			name: "empty values are quoted",
+			config: DatabaseConfig{
+				Host:     "localhost",
+				Port:     5432,
+				User:     "hermod",
+				Database: "iot",
+				PoolSize: 1,
+			},
+			want: "host=localhost port=5432 user=hermod password='' dbname=iot sslmode='' pool_max_conns=1",
+		},
 	}
 
 	for _, tt := range tests {
@@ -138,3 +167,646 @@ func TestDatabaseConfigConnectionString(t *testing.T) {
 		})
 	}
 }
+
+func TestLoadRouteDownsample(t *testing.T) {
+	content := `
+[[routes]]
+filter = "ruuvi/+"
+script = "ruuvi.lua"
+downsample = {interval="60s", agg={value="avg", battery="last"}}
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	ds := cfg.Routes[0].Downsample
+	if ds == nil {
+		t.Fatal("Routes[0].Downsample = nil, want parsed downsample")
+	}
+	if ds.Interval != "60s" {
+		t.Errorf("Downsample.Interval = %v, want 60s", ds.Interval)
+	}
+	if ds.Agg["value"] != "avg" || ds.Agg["battery"] != "last" {
+		t.Errorf("Downsample.Agg = %v, want value=avg battery=last", ds.Agg)
+	}
+}
+
+func TestLoadRouteDeviceID(t *testing.T) {
+	content := `
+[devices]
+flush_interval = "30s"
+
+[[routes]]
+filter = "ruuvi/+"
+device_id = "topic[2]"
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.Routes[0].DeviceID != "topic[2]" {
+		t.Errorf("Routes[0].DeviceID = %v, want topic[2]", cfg.Routes[0].DeviceID)
+	}
+	if cfg.Devices.FlushInterval != "30s" {
+		t.Errorf("Devices.FlushInterval = %v, want 30s", cfg.Devices.FlushInterval)
+	}
+}
+
+func TestLoadRouteMask(t *testing.T) {
+	content := `
+[[routes]]
+filter = "ruuvi/+"
+mask = {columns=["mac", "plate"], strategy="truncate", keep=4}
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	m := cfg.Routes[0].Mask
+	if m == nil {
+		t.Fatal("Routes[0].Mask = nil, want parsed mask")
+	}
+	if len(m.Columns) != 2 || m.Strategy != "truncate" || m.Keep != 4 {
+		t.Errorf("Mask = %+v, want columns=[mac plate] strategy=truncate keep=4", m)
+	}
+}
+
+func TestLoadRouteBatch(t *testing.T) {
+	content := `
+[[routes]]
+filter = "ruuvi/+"
+batch = {size=500, linger="200ms"}
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	b := cfg.Routes[0].Batch
+	if b == nil {
+		t.Fatal("Routes[0].Batch = nil, want parsed batch")
+	}
+	if b.Size != 500 || b.Linger != "200ms" {
+		t.Errorf("Batch = %+v, want size=500 linger=200ms", b)
+	}
+}
+
+func TestLoadRouteTimestamp(t *testing.T) {
+	content := `
+[[routes]]
+filter = "sensors/+"
+timestamp = {field="meta.ts", unit="ms", timezone="Europe/Stockholm"}
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	ts := cfg.Routes[0].Timestamp
+	if ts == nil {
+		t.Fatal("Routes[0].Timestamp = nil, want parsed timestamp")
+	}
+	if ts.Field != "meta.ts" || ts.Unit != "ms" || ts.Timezone != "Europe/Stockholm" {
+		t.Errorf("Timestamp = %+v, want field=meta.ts unit=ms timezone=Europe/Stockholm", ts)
+	}
+}
+
+func TestLoadRouteRetained(t *testing.T) {
+	content := `
+[[routes]]
+filter = "sensors/+"
+retained = "state"
+state_table = "sensor_state"
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if rc := cfg.Routes[0]; rc.Retained != "state" || rc.StateTable != "sensor_state" {
+		t.Errorf("Retained = %q, StateTable = %q; want state, sensor_state", rc.Retained, rc.StateTable)
+	}
+}
+
+func TestLoadRouteLuaRecycle(t *testing.T) {
+	content := `
+[[routes]]
+filter = "sensors/+"
+script = "sensors.lua"
+lua_recycle = 50000
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.Routes[0].LuaRecycle != 50000 {
+		t.Errorf("LuaRecycle = %d, want 50000", cfg.Routes[0].LuaRecycle)
+	}
+}
+
+func TestLoadRoutePayloadSchema(t *testing.T) {
+	content := `
+[[routes]]
+filter = "ruuvi/+"
+script = "ruuvi.lua"
+payload_format = "cbor"
+payload_schema = "schemas/ruuvi.json"
+reject_table = "ruuvi_rejected"
+max_records = 10
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	rc := cfg.Routes[0]
+	if rc.PayloadFormat != "cbor" || rc.PayloadSchema != "schemas/ruuvi.json" || rc.RejectTable != "ruuvi_rejected" {
+		t.Errorf("PayloadFormat = %q, PayloadSchema = %q, RejectTable = %q", rc.PayloadFormat, rc.PayloadSchema, rc.RejectTable)
+	}
+	if rc.MaxRecords != 10 {
+		t.Errorf("MaxRecords = %d, want 10", rc.MaxRecords)
+	}
+}
+
+func TestLoadFilters(t *testing.T) {
+	content := `
+[filters]
+deny = ["$SYS/#"]
+deny_regex = ["/debug$"]
+min_qos = 1
+
+[[routes]]
+filter = "#"
+deny = ["+/heartbeat"]
+min_qos = 2
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	f := cfg.Filters
+	if len(f.Deny) != 1 || f.Deny[0] != "$SYS/#" || len(f.DenyRegex) != 1 || f.MinQoS != 1 {
+		t.Errorf("Filters = %+v, want deny=[$SYS/#] deny_regex=[/debug$] min_qos=1", f)
+	}
+	if rc := cfg.Routes[0]; len(rc.Deny) != 1 || rc.Deny[0] != "+/heartbeat" || rc.MinQoS != 2 {
+		t.Errorf("Route deny = %v, min_qos = %d; want [+/heartbeat], 2", rc.Deny, rc.MinQoS)
+	}
+}
+
+func TestLoadSinks(t *testing.T) {
+	content := `
+[database]
+host = "db"
+port = 5432
+user = "hermod"
+database = "hermod"
+
+[[sinks]]
+name = "archive"
+type = "postgres"
+database = {database = "archive", user = "archiver"}
+
+[[sinks]]
+name = "events"
+type = "jsonl"
+path = "/var/lib/hermod/events.jsonl"
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if len(cfg.Sinks) != 2 || cfg.Sinks[1].Path != "/var/lib/hermod/events.jsonl" {
+		t.Fatalf("Sinks = %+v, want archive and events", cfg.Sinks)
+	}
+	db := cfg.Sinks[0].SinkDatabase(cfg.Database)
+	if db.Host != "db" || db.Port != 5432 || db.User != "archiver" || db.Database != "archive" {
+		t.Errorf("SinkDatabase = %+v, want inherited host/port with sink user and database", db)
+	}
+}
+
+func TestLoadRewrites(t *testing.T) {
+	content := `
+[[rewrites]]
+strip_prefix = "legacy/"
+
+[[rewrites]]
+match = "plant/+/t"
+replace = "sensors/{2}/temperature"
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if len(cfg.Rewrites) != 2 || cfg.Rewrites[0].StripPrefix != "legacy/" || cfg.Rewrites[1].Replace != "sensors/{2}/temperature" {
+		t.Errorf("Rewrites = %+v, want strip legacy/ and plant rewrite", cfg.Rewrites)
+	}
+}
+
+func TestLoadQueues(t *testing.T) {
+	content := `
+[queues]
+warn_percent = 80
+clear_percent = 40
+spool_dir = "/var/lib/hermod/spool"
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.Queues.WarnPercent != 80 || cfg.Queues.ClearPercent != 40 || cfg.Queues.SpoolDir != "/var/lib/hermod/spool" {
+		t.Errorf("Queues = %+v, want warn=80 clear=40 spool_dir=/var/lib/hermod/spool", cfg.Queues)
+	}
+}
+
+func TestLoadDedup(t *testing.T) {
+	content := `
+[dedup]
+window = "10m"
+ignore = ["time"]
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.Dedup.Window != "10m" || len(cfg.Dedup.Ignore) != 1 || cfg.Dedup.Ignore[0] != "time" {
+		t.Errorf("Dedup = %+v, want window=10m ignore=[time]", cfg.Dedup)
+	}
+}
+
+func TestLoadLimits(t *testing.T) {
+	content := `
+[limits]
+max_payload = 1024
+
+[[routes]]
+filter = "cam/+"
+max_payload = 1048576
+oversize = "truncate"
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.Limits.MaxPayload != 1024 || cfg.Limits.Oversize != "" {
+		t.Errorf("Limits = %+v, want max_payload=1024", cfg.Limits)
+	}
+	if cfg.Routes[0].MaxPayload != 1048576 || cfg.Routes[0].Oversize != "truncate" {
+		t.Errorf("Routes[0] max_payload=%d oversize=%q, want 1048576 truncate", cfg.Routes[0].MaxPayload, cfg.Routes[0].Oversize)
+	}
+}
+
+func TestLoadColumns(t *testing.T) {
+	content := `
+[columns]
+case = "lowercase"
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if cfg.Columns.Case != "lowercase" {
+		t.Errorf("Columns.Case = %q, want lowercase", cfg.Columns.Case)
+	}
+}
+
+func TestDatabaseConfigDDLConnectionString(t *testing.T) {
+	d := DatabaseConfig{
+		Host:     "localhost",
+		Port:     5432,
+		User:     "hermod_ingest",
+		Password: "ingest",
+		Database: "iot",
+		SSLMode:  "disable",
+		PoolSize: 10,
+	}
+
+	want := "host=localhost port=5432 user=hermod_ingest password=ingest dbname=iot sslmode=disable pool_max_conns=1"
+	if got := d.DDLConnectionString(); got != want {
+		t.Errorf("DDLConnectionString() without ddl_user = %v, want %v", got, want)
+	}
+
+	d.DDLUser = "hermod_owner"
+	d.DDLPassword = "owner"
+	want = "host=localhost port=5432 user=hermod_owner password=owner dbname=iot sslmode=disable pool_max_conns=1"
+	if got := d.DDLConnectionString(); got != want {
+		t.Errorf("DDLConnectionString() = %v, want %v", got, want)
+	}
+	if !strings.Contains(d.ConnectionString(), "user=hermod_ingest") {
+		t.Errorf("ConnectionString() should keep the runtime role, got %v", d.ConnectionString())
+	}
+}
+
+func TestLoadRouteGroups(t *testing.T) {
+	content := `
+[route_defaults]
+workers = 2
+queue_size = 500
+processing_timeout = "5s"
+tags = {site = "plant-3"}
+sink = "events"
+
+[route_groups.ruuvi]
+workers = 4
+batch = {size = 200}
+tags = {line = "A"}
+table_suffix = "monthly"
+
+[[routes]]
+filter = "ruuvi/+"
+group = "ruuvi"
+
+[[routes]]
+filter = "p1ib/#"
+queue_size = 50
+tags = {site = "plant-4"}
+sink = "archive"
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	ruuvi := cfg.Routes[0]
+	if ruuvi.Workers != 4 || ruuvi.QueueSize != 500 || ruuvi.ProcessingTimeout != "5s" {
+		t.Errorf("ruuvi route = workers %d, queue_size %d, processing_timeout %q; want 4, 500, 5s",
+			ruuvi.Workers, ruuvi.QueueSize, ruuvi.ProcessingTimeout)
+	}
+	if ruuvi.Batch == nil || ruuvi.Batch.Size != 200 {
+		t.Errorf("ruuvi route Batch = %+v, want size 200", ruuvi.Batch)
+	}
+	if ruuvi.Tags["site"] != "plant-3" || ruuvi.Tags["line"] != "A" {
+		t.Errorf("ruuvi route Tags = %v, want site and line", ruuvi.Tags)
+	}
+	if ruuvi.TableSuffix != "monthly" {
+		t.Errorf("ruuvi route TableSuffix = %q, want monthly", ruuvi.TableSuffix)
+	}
+	if ruuvi.Sink != "events" {
+		t.Errorf("ruuvi route Sink = %q, want events", ruuvi.Sink)
+	}
+
+	p1 := cfg.Routes[1]
+	if p1.Workers != 2 || p1.QueueSize != 50 || p1.Batch != nil {
+		t.Errorf("p1ib route = workers %d, queue_size %d, batch %+v; want 2, 50, nil", p1.Workers, p1.QueueSize, p1.Batch)
+	}
+	if p1.Tags["site"] != "plant-4" || p1.Sink != "archive" {
+		t.Errorf("p1ib route Tags = %v, Sink = %q; want its own site and sink", p1.Tags, p1.Sink)
+	}
+}
+
+func TestLoadRouteOverridesWithZero(t *testing.T) {
+	content := `
+[route_defaults]
+min_qos = 1
+error_budget = 5.0
+sink = "events"
+batch = {size = 100}
+
+[route_groups.archive]
+table_suffix = "monthly"
+passthrough_table = "raw_archive"
+
+[[routes]]
+filter = "ruuvi/+"
+group = "archive"
+min_qos = 0
+error_budget = 0.0
+sink = ""
+table_suffix = ""
+
+[[routes]]
+filter = "p1ib/#"
+group = "archive"
+passthrough_table = ""
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	ruuvi := cfg.Routes[0]
+	if ruuvi.MinQoS != 0 || ruuvi.ErrorBudget != 0 || ruuvi.Sink != "" || ruuvi.TableSuffix != "" {
+		t.Errorf("ruuvi route = min_qos %d, error_budget %v, sink %q, table_suffix %q; want explicit zero values",
+			ruuvi.MinQoS, ruuvi.ErrorBudget, ruuvi.Sink, ruuvi.TableSuffix)
+	}
+	if ruuvi.PassthroughTable != "raw_archive" || ruuvi.Batch == nil {
+		t.Errorf("ruuvi route = passthrough_table %q, batch %+v; want inherited values", ruuvi.PassthroughTable, ruuvi.Batch)
+	}
+
+	p1 := cfg.Routes[1]
+	if p1.PassthroughTable != "" || p1.TableSuffix != "monthly" || p1.MinQoS != 1 || p1.Sink != "events" {
+		t.Errorf("p1ib route = passthrough_table %q, table_suffix %q, min_qos %d, sink %q; want \"\", monthly, 1, events",
+			p1.PassthroughTable, p1.TableSuffix, p1.MinQoS, p1.Sink)
+	}
+}
+
+func TestLoadRouteUnknownGroup(t *testing.T) {
+	content := `
+[[routes]]
+filter = "ruuvi/+"
+group = "missing"
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	if _, err := Load(configPath); err == nil || !strings.Contains(err.Error(), `unknown group "missing"`) {
+		t.Errorf("Load() error = %v, want unknown group", err)
+	}
+}
+
+func TestLoadMQTTBrokers(t *testing.T) {
+	content := `
+[mqtt]
+broker = "tcp://site-a:1883"
+client_id = "hermod"
+qos = 1
+standby_brokers = ["tcp://site-a-standby:1883"]
+broker_order = "round-robin"
+
+[[mqtt.brokers]]
+name = "site-b"
+broker = "ssl://site-b:8883"
+client_id = "hermod-b"
+ca_cert = "/etc/hermod/site-b-ca.pem"
+
+[route_groups.b]
+broker = "site-b"
+
+[[routes]]
+filter = "sensors/#"
+
+[[routes]]
+filter = "meters/#"
+group = "b"
+`
+	configPath := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(configPath, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write test config: %v", err)
+	}
+
+	cfg, err := Load(configPath)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Routes[0].Broker != "" || cfg.Routes[1].Broker != "site-b" {
+		t.Errorf("route brokers = %q, %q; want \"\", site-b", cfg.Routes[0].Broker, cfg.Routes[1].Broker)
+	}
+	mc, ok := cfg.MQTT.NamedBroker("site-b")
+	if !ok {
+		t.Fatal("NamedBroker(site-b) not found")
+	}
+	if mc.Broker != "ssl://site-b:8883" || mc.ClientID != "hermod-b" || mc.CACert != "/etc/hermod/site-b-ca.pem" || mc.QoS != 1 {
+		t.Errorf("NamedBroker(site-b) = %+v", mc)
+	}
+	if mc.BrokerOrder != "round-robin" || len(mc.Standby) != 0 {
+		t.Errorf("NamedBroker(site-b) should inherit broker_order but not standby_brokers, got %q, %v", mc.BrokerOrder, mc.Standby)
+	}
+	if len(cfg.MQTT.Standby) != 1 || cfg.MQTT.Standby[0] != "tcp://site-a-standby:1883" {
+		t.Errorf("Standby = %v", cfg.MQTT.Standby)
+	}
+	if _, ok := cfg.MQTT.NamedBroker("site-c"); ok {
+		t.Error("NamedBroker(site-c) found, want none")
+	}
+}
+
+func TestLoadMQTTBrokersInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    string
+	}{
+		{"unknown broker", "[[routes]]\nfilter = \"a/#\"\nbroker = \"b\"\n", `unknown broker "b"`},
+		{"missing name", "[[mqtt.brokers]]\nbroker = \"tcp://b:1883\"\n", "name is required"},
+		{"missing url", "[[mqtt.brokers]]\nname = \"b\"\n", "broker is required"},
+		{"duplicate", "[[mqtt.brokers]]\nname = \"b\"\nbroker = \"tcp://b:1883\"\n\n[[mqtt.brokers]]\nname = \"b\"\nbroker = \"tcp://c:1883\"\n", "duplicate name"},
+		{"manual ack clean session", "[mqtt]\nmanual_ack = true\nclean_session = true\n", "manual_ack needs a persistent session"},
+		{"manual ack qos 0", "[mqtt]\nmanual_ack = true\n", "manual_ack needs qos 1 or 2"},
+		{"broker manual ack qos 0", "[[mqtt.brokers]]\nname = \"b\"\nbroker = \"tcp://b:1883\"\nmanual_ack = true\n", "manual_ack needs qos 1 or 2"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			configPath := filepath.Join(t.TempDir(), "config.toml")
+			if err := os.WriteFile(configPath, []byte(tt.content), 0644); err != nil {
+				t.Fatalf("failed to write test config: %v", err)
+			}
+			if _, err := Load(configPath); err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Load() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestMQTTPersistentSession(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		mc   MQTTConfig
+		want bool
+	}{
+		{MQTTConfig{}, false},
+		{MQTTConfig{ManualAck: true}, true},
+		{MQTTConfig{CleanSession: &no}, true},
+		{MQTTConfig{CleanSession: &yes}, false},
+	}
+	for _, tt := range tests {
+		if got := tt.mc.PersistentSession(); got != tt.want {
+			t.Errorf("PersistentSession() for %+v = %v, want %v", tt.mc, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package decoder
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// maxNesting bounds how deeply binary payloads may nest arrays and maps
+const maxNesting = 256
+
+// errTruncated reports a payload that ends inside a value
+var errTruncated = errors.New("unexpected end of data")
+
+// CBOR decodes a single CBOR (RFC 8949) data item into the same shapes as
+// JSON: map[string]interface{}, []interface{}, string, bool, nil, int64 and
+// float64. Byte strings become strings, map keys that aren't strings are
+// formatted with %v, integers beyond int64 become float64 and tags are
+// dropped in favour of their content.
+func CBOR(data []byte) (interface{}, error) {
+	d := &cborDecoder{data: data}
+	v, err := d.value(0)
+	if _, ok := v.(cborBreak); ok {
+		err = errors.New("unexpected break")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("invalid CBOR at byte %d: %w", d.pos, err)
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("invalid CBOR: %d trailing bytes", len(data)-d.pos)
+	}
+	return v, nil
+}
+
+// cborBreak is returned for the "break" stop code of indefinite-length items
+type cborBreak struct{}
+
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// next reads n bytes
+func (d *cborDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errTruncated
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// head reads an initial byte and its argument
+func (d *cborDecoder) head() (major byte, info byte, arg uint64, err error) {
+	b, err := d.next(1)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	major, info = b[0]>>5, b[0]&0x1f
+	switch {
+	case info < 24:
+		arg = uint64(info)
+	case info <= 27:
+		var ext []byte
+		if ext, err = d.next(1 << (info - 24)); err != nil {
+			return 0, 0, 0, err
+		}
+		for _, c := range ext {
+			arg = arg<<8 | uint64(c)
+		}
+	case info == 31:
+		// Indefinite length (or break), handled by the caller
+	default:
+		return 0, 0, 0, fmt.Errorf("reserved additional information %d", info)
+	}
+	return major, info, arg, nil
+}
+
+func (d *cborDecoder) value(depth int) (interface{}, error) {
+	if depth > maxNesting {
+		return nil, errors.New("nesting too deep")
+	}
+	major, info, arg, err := d.head()
+	if err != nil {
+		return nil, err
+	}
+	if info == 31 && (major == 0 || major == 1 || major == 6) {
+		return nil, fmt.Errorf("indefinite length not allowed for major type %d", major)
+	}
+
+	switch major {
+	case 0: // Unsigned integer
+		if arg > math.MaxInt64 {
+			return float64(arg), nil
+		}
+		return int64(arg), nil
+	case 1: // Negative integer: -1 - arg
+		if arg > math.MaxInt64 {
+			return -1 - float64(arg), nil
+		}
+		return -1 - int64(arg), nil
+	case 2, 3: // Byte and text strings
+		if info != 31 {
+			b, err := d.next(arg)
+			if err != nil {
+				return nil, err
+			}
+			return string(b), nil
+		}
+		var s []byte
+		for {
+			chunk, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := chunk.(cborBreak); ok {
+				return string(s), nil
+			}
+			str, ok := chunk.(string)
+			if !ok {
+				return nil, errors.New("indefinite-length string chunk is not a string")
+			}
+			s = append(s, str...)
+		}
+	case 4: // Array
+		var list []interface{}
+		if info != 31 {
+			if arg > uint64(len(d.data)-d.pos) {
+				return nil, errTruncated
+			}
+			list = make([]interface{}, 0, arg)
+		}
+		for i := uint64(0); info == 31 || i < arg; i++ {
+			item, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := item.(cborBreak); ok {
+				if info != 31 {
+					return nil, errors.New("unexpected break")
+				}
+				break
+			}
+			list = append(list, item)
+		}
+		if list == nil {
+			list = []interface{}{}
+		}
+		return list, nil
+	case 5: // Map
+		m := make(map[string]interface{})
+		for i := uint64(0); info == 31 || i < arg; i++ {
+			key, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := key.(cborBreak); ok {
+				if info != 31 {
+					return nil, errors.New("unexpected break")
+				}
+				break
+			}
+			val, err := d.value(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := val.(cborBreak); ok {
+				return nil, errors.New("map key without a value")
+			}
+			m[mapKey(key)] = val
+		}
+		return m, nil
+	case 6: // Tag: keep the content
+		return d.value(depth + 1)
+	}
+
+	// Major type 7: simple values and floats
+	switch info {
+	case 20:
+		return false, nil
+	case 21:
+		return true, nil
+	case 22, 23: // null, undefined
+		return nil, nil
+	case 25:
+		return halfFloat(uint16(arg)), nil
+	case 26:
+		return float64(math.Float32frombits(uint32(arg))), nil
+	case 27:
+		return math.Float64frombits(arg), nil
+	case 31:
+		return cborBreak{}, nil
+	}
+	return nil, fmt.Errorf("unsupported simple value %d", arg)
+}
+
+// halfFloat converts an IEEE 754 half-precision float
+func halfFloat(h uint16) float64 {
+	exp := int(h>>10) & 0x1f
+	mant := float64(h & 0x3ff)
+	var f float64
+	switch exp {
+	case 0:
+		f = math.Ldexp(mant, -24)
+	case 31:
+		if mant == 0 {
+			f = math.Inf(1)
+		} else {
+			f = math.NaN()
+		}
+	default:
+		f = math.Ldexp(mant+1024, exp-25)
+	}
+	if h&0x8000 != 0 {
+		return -f
+	}
+	return f
+}
+
+// mapKey converts a decoded map key to a string
+func mapKey(key interface{}) string {
+	if s, ok := key.(string); ok {
+		return s
+	}
+	return fmt.Sprintf("%v", key)
+}
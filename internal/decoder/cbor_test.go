@@ -0,0 +1,65 @@
+package decoder
+
+import (
+	"encoding/hex"
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestCBOR(t *testing.T) {
+	tests := []struct {
+		hex  string
+		want interface{}
+	}{
+		{"00", int64(0)},
+		{"1903e8", int64(1000)},
+		{"3863", int64(-100)},
+		{"1bffffffffffffffff", float64(math.MaxUint64)},
+		{"f93e00", 1.5},
+		{"fa47c35000", 100000.0},
+		{"fb3ff199999999999a", 1.1},
+		{"f4", false},
+		{"f6", nil},
+		{"6449455446", "IETF"},
+		{"4401020304", "\x01\x02\x03\x04"},
+		{"7f657374726561646d696e67ff", "streaming"},
+		{"83010203", []interface{}{int64(1), int64(2), int64(3)}},
+		{"9fff", []interface{}{}},
+		{"a26161016162820203", map[string]interface{}{"a": int64(1), "b": []interface{}{int64(2), int64(3)}}},
+		{"bf01f5ff", map[string]interface{}{"1": true}},
+		{"c11a514b67b0", int64(1363896240)}, // Tag 1 (epoch time) keeps its content
+	}
+	for _, tt := range tests {
+		got, err := CBOR(mustHex(t, tt.hex))
+		if err != nil {
+			t.Errorf("CBOR(%s) error = %v", tt.hex, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("CBOR(%s) = %#v, want %#v", tt.hex, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "19 03", "62 61", "ff", "8201", "0000", "1c", "a1 01"} {
+		if _, err := CBOR(mustHex(t, bad)); err == nil {
+			t.Errorf("CBOR(%s) succeeded, want error", bad)
+		}
+	}
+}
+
+// mustHex decodes hex with optional spaces between bytes
+func mustHex(t *testing.T, s string) []byte {
+	t.Helper()
+	clean := make([]byte, 0, len(s))
+	for i := 0; i < len(s); i++ {
+		if s[i] != ' ' {
+			clean = append(clean, s[i])
+		}
+	}
+	b, err := hex.DecodeString(string(clean))
+	if err != nil {
+		t.Fatalf("invalid hex %q: %v", s, err)
+	}
+	return b
+}
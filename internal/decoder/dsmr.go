@@ -0,0 +1,191 @@
+package decoder
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// dsmrLine matches an OBIS line: code followed by one or more (value) groups
+var dsmrLine = regexp.MustCompile(`^(\d+-\d+:\d+\.\d+\.\d+)((?:\([^)]*\))+)\s*$`)
+
+// dsmrGroup matches a single (value) group
+var dsmrGroup = regexp.MustCompile(`\(([^)]*)\)`)
+
+// dsmrTimestamp matches a DSMR timestamp (YYMMDDhhmmss + W/S for winter/summer time)
+var dsmrTimestamp = regexp.MustCompile(`^\d{12}[WS]$`)
+
+// dsmrField names a well-known OBIS code; numeric fields are converted even without a unit
+type dsmrField struct {
+	name    string
+	numeric bool
+}
+
+// dsmrFields maps well-known OBIS codes to field names
+var dsmrFields = map[string]dsmrField{
+	"1-3:0.2.8":   {"version", false},
+	"0-0:1.0.0":   {"timestamp", false},
+	"0-0:96.1.1":  {"equipment_id", false},
+	"1-0:1.8.0":   {"energy_delivered_total", true},
+	"1-0:1.8.1":   {"energy_delivered_tariff1", true},
+	"1-0:1.8.2":   {"energy_delivered_tariff2", true},
+	"1-0:2.8.0":   {"energy_returned_total", true},
+	"1-0:2.8.1":   {"energy_returned_tariff1", true},
+	"1-0:2.8.2":   {"energy_returned_tariff2", true},
+	"0-0:96.14.0": {"tariff", true},
+	"1-0:1.7.0":   {"power_delivered", true},
+	"1-0:2.7.0":   {"power_returned", true},
+	"0-0:96.7.21": {"power_failures", true},
+	"0-0:96.7.9":  {"long_power_failures", true},
+	"1-0:32.32.0": {"voltage_sags_l1", true},
+	"1-0:52.32.0": {"voltage_sags_l2", true},
+	"1-0:72.32.0": {"voltage_sags_l3", true},
+	"1-0:32.36.0": {"voltage_swells_l1", true},
+	"1-0:52.36.0": {"voltage_swells_l2", true},
+	"1-0:72.36.0": {"voltage_swells_l3", true},
+	"1-0:32.7.0":  {"voltage_l1", true},
+	"1-0:52.7.0":  {"voltage_l2", true},
+	"1-0:72.7.0":  {"voltage_l3", true},
+	"1-0:31.7.0":  {"current_l1", true},
+	"1-0:51.7.0":  {"current_l2", true},
+	"1-0:71.7.0":  {"current_l3", true},
+	"1-0:21.7.0":  {"power_delivered_l1", true},
+	"1-0:41.7.0":  {"power_delivered_l2", true},
+	"1-0:61.7.0":  {"power_delivered_l3", true},
+	"1-0:22.7.0":  {"power_returned_l1", true},
+	"1-0:42.7.0":  {"power_returned_l2", true},
+	"1-0:62.7.0":  {"power_returned_l3", true},
+}
+
+// dsmrZone is the meter time zone (DSMR timestamps are Dutch/Belgian local time)
+var dsmrZone = map[byte]*time.Location{
+	'W': time.FixedZone("CET", 3600),
+	'S': time.FixedZone("CEST", 7200),
+}
+
+// DSMR parses a DSMR/P1 smart meter telegram.
+//
+// The result contains:
+//
+//	header        identification line without the leading "/"
+//	obis          OBIS code -> value for every data line
+//	<name>        well-known codes by name (e.g. energy_delivered_tariff1, power_delivered, voltage_l1)
+//	mbus_<n>_delivered, mbus_<n>_timestamp   M-Bus meter readings (0-n:24.2.1)
+//	gas_delivered, gas_timestamp             the M-Bus reading of a gas meter (device type 3)
+//
+// Values with a unit (e.g. "123.456*kWh") become numbers with the unit dropped,
+// timestamps become RFC 3339 strings, and everything else stays a string.
+// When the telegram carries a CRC it is verified.
+func DSMR(telegram string) (map[string]interface{}, error) {
+	start := strings.Index(telegram, "/")
+	end := strings.LastIndex(telegram, "!")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("invalid DSMR telegram: missing header or trailer")
+	}
+
+	if crc := strings.TrimSpace(telegram[end+1:]); crc != "" {
+		want, err := strconv.ParseUint(crc, 16, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid DSMR CRC %q", crc)
+		}
+		if got := crc16([]byte(telegram[start : end+1])); uint64(got) != want {
+			return nil, fmt.Errorf("DSMR CRC mismatch: got %04X, want %04X", got, want)
+		}
+	}
+
+	lines := strings.Split(strings.ReplaceAll(telegram[start:end], "\r\n", "\n"), "\n")
+	out := map[string]interface{}{
+		"header": strings.TrimSpace(strings.TrimPrefix(lines[0], "/")),
+	}
+	obis := make(map[string]interface{})
+	mbusType := make(map[string]string)
+
+	for _, line := range lines[1:] {
+		m := dsmrLine.FindStringSubmatch(strings.TrimSpace(line))
+		if m == nil {
+			continue
+		}
+		code := m[1]
+		var groups []string
+		for _, g := range dsmrGroup.FindAllStringSubmatch(m[2], -1) {
+			groups = append(groups, g[1])
+		}
+
+		field, known := dsmrFields[code]
+		value := dsmrValue(groups[len(groups)-1], field.numeric)
+		obis[code] = value
+		if known {
+			out[field.name] = value
+		}
+
+		// M-Bus channels: 0-n:24.1.0 is the device type, 0-n:24.2.1 is (timestamp)(reading)
+		if ch, ok := mbusChannel(code, "24.1.0"); ok {
+			mbusType[ch] = strings.TrimLeft(groups[0], "0")
+		}
+		if ch, ok := mbusChannel(code, "24.2.1"); ok {
+			out["mbus_"+ch+"_delivered"] = value
+			if len(groups) > 1 {
+				out["mbus_"+ch+"_timestamp"] = dsmrValue(groups[0], false)
+			}
+		}
+	}
+
+	for ch, typ := range mbusType {
+		if typ == "3" {
+			out["gas_delivered"] = out["mbus_"+ch+"_delivered"]
+			out["gas_timestamp"] = out["mbus_"+ch+"_timestamp"]
+		}
+	}
+
+	out["obis"] = obis
+	return out, nil
+}
+
+// mbusChannel returns n for an OBIS code of the form 0-n:<suffix>
+func mbusChannel(code, suffix string) (string, bool) {
+	if !strings.HasPrefix(code, "0-") || !strings.HasSuffix(code, ":"+suffix) {
+		return "", false
+	}
+	ch := strings.TrimSuffix(strings.TrimPrefix(code, "0-"), ":"+suffix)
+	return ch, ch != "0"
+}
+
+// dsmrValue converts a single value group
+func dsmrValue(s string, numeric bool) interface{} {
+	if i := strings.IndexByte(s, '*'); i >= 0 {
+		if f, err := strconv.ParseFloat(s[:i], 64); err == nil {
+			return f
+		}
+		return s
+	}
+	if dsmrTimestamp.MatchString(s) {
+		t, err := time.ParseInLocation("060102150405", s[:12], dsmrZone[s[12]])
+		if err == nil {
+			return t.Format(time.RFC3339)
+		}
+	}
+	if numeric {
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
+
+// crc16 computes CRC-16/ARC (polynomial 0xA001 reflected, initial value 0) as used by DSMR 4+
+func crc16(data []byte) uint16 {
+	var crc uint16
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
@@ -0,0 +1,108 @@
+package decoder
+
+import (
+	"strings"
+	"testing"
+)
+
+// testTelegram is a DSMR 5 example telegram (CRC 0CB4)
+var testTelegram = strings.Join([]string{
+	`/ISk5\2MT382-1000`,
+	``,
+	`1-3:0.2.8(50)`,
+	`0-0:1.0.0(101209113020W)`,
+	`0-0:96.1.1(4B384547303034303436333935353037)`,
+	`1-0:1.8.1(123456.789*kWh)`,
+	`1-0:1.8.2(123456.789*kWh)`,
+	`1-0:2.8.1(123456.789*kWh)`,
+	`1-0:2.8.2(123456.789*kWh)`,
+	`0-0:96.14.0(0002)`,
+	`1-0:1.7.0(01.193*kW)`,
+	`1-0:2.7.0(00.000*kW)`,
+	`0-0:96.7.21(00004)`,
+	`1-0:99.97.0(2)(0-0:96.7.19)(101208152415W)(0000000240*s)(101208151004W)(0000000301*s)`,
+	`1-0:32.7.0(220.1*V)`,
+	`0-1:24.1.0(003)`,
+	`0-1:96.1.0(3232323241424344313233343536373839)`,
+	`0-1:24.2.1(101209112500W)(12785.123*m3)`,
+	`!`,
+}, "\r\n") + "0CB4\r\n"
+
+func TestDSMR(t *testing.T) {
+	got, err := DSMR(testTelegram)
+	if err != nil {
+		t.Fatalf("DSMR() error = %v", err)
+	}
+
+	want := map[string]interface{}{
+		"header":                   `ISk5\2MT382-1000`,
+		"version":                  "50",
+		"timestamp":                "2010-12-09T11:30:20+01:00",
+		"equipment_id":             "4B384547303034303436333935353037",
+		"energy_delivered_tariff1": 123456.789,
+		"energy_returned_tariff2":  123456.789,
+		"tariff":                   2.0,
+		"power_delivered":          1.193,
+		"power_returned":           0.0,
+		"power_failures":           4.0,
+		"voltage_l1":               220.1,
+		"mbus_1_delivered":         12785.123,
+		"mbus_1_timestamp":         "2010-12-09T11:25:00+01:00",
+		"gas_delivered":            12785.123,
+		"gas_timestamp":            "2010-12-09T11:25:00+01:00",
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s = %v (%T), want %v", k, got[k], got[k], v)
+		}
+	}
+
+	obis, ok := got["obis"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("obis = %T, want map", got["obis"])
+	}
+	if obis["1-0:1.7.0"] != 1.193 {
+		t.Errorf("obis[1-0:1.7.0] = %v, want 1.193", obis["1-0:1.7.0"])
+	}
+	if obis["0-1:96.1.0"] != "3232323241424344313233343536373839" {
+		t.Errorf("obis[0-1:96.1.0] = %v", obis["0-1:96.1.0"])
+	}
+}
+
+func TestDSMRErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		telegram string
+	}{
+		{"missing header", "1-0:1.8.1(1*kWh)\r\n!"},
+		{"missing trailer", "/X\r\n1-0:1.8.1(1*kWh)\r\n"},
+		{"bad crc", strings.Replace(testTelegram, "0CB4", "0CB5", 1)},
+		{"corrupt body", strings.Replace(testTelegram, "01.193", "01.194", 1)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := DSMR(tt.telegram); err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+}
+
+func TestDSMRWithoutCRC(t *testing.T) {
+	// DSMR 2.2/3 telegrams have no CRC
+	got, err := DSMR("/KFM5KAIFA-METER\r\n\r\n1-0:1.8.1(000123.456*kWh)\r\n!\r\n")
+	if err != nil {
+		t.Fatalf("DSMR() error = %v", err)
+	}
+	if got["energy_delivered_tariff1"] != 123.456 {
+		t.Errorf("energy_delivered_tariff1 = %v, want 123.456", got["energy_delivered_tariff1"])
+	}
+}
+
+func TestCRC16(t *testing.T) {
+	// CRC-16/ARC check value
+	if got := crc16([]byte("123456789")); got != 0xBB3D {
+		t.Errorf("crc16 = %04X, want BB3D", got)
+	}
+}
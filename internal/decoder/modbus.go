@@ -0,0 +1,174 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// Modbus register value types and the number of 16-bit registers each spans
+var modbusTypes = map[string]int{
+	"uint16":  1,
+	"int16":   1,
+	"uint32":  2,
+	"int32":   2,
+	"float32": 2,
+	"uint64":  4,
+	"int64":   4,
+	"float64": 4,
+}
+
+// Word and byte orders of multi-register values, named after the byte
+// positions of the big-endian value (A = most significant byte)
+var modbusOrders = map[string]bool{
+	"ABCD": true, // Big-endian words, big-endian bytes (Modbus default)
+	"CDAB": true, // Little-endian words ("word swap")
+	"BADC": true, // Big-endian words, swapped bytes
+	"DCBA": true, // Little-endian
+}
+
+// ModbusField names a value in a register dump
+type ModbusField struct {
+	Name     string  // Result field name
+	Register int     // Address of the value's first register
+	Type     string  // uint16 (default), int16, uint32, int32, float32, uint64, int64 or float64
+	Order    string  // ABCD (default), CDAB, BADC or DCBA
+	Scale    float64 // Multiplier applied to the raw value (0 = 1)
+	Offset   float64 // Added after scaling
+}
+
+// ModbusMap describes the registers of a raw Modbus register dump
+type ModbusMap struct {
+	Start  int // Address of the first register in the dump
+	Fields []ModbusField
+}
+
+// Validate checks the map's fields
+func (m ModbusMap) Validate() error {
+	if m.Start < 0 {
+		return fmt.Errorf("invalid start register %d", m.Start)
+	}
+	seen := make(map[string]bool, len(m.Fields))
+	for _, f := range m.Fields {
+		if f.Name == "" {
+			return fmt.Errorf("register %d has no name", f.Register)
+		}
+		if seen[f.Name] {
+			return fmt.Errorf("duplicate field %s", f.Name)
+		}
+		seen[f.Name] = true
+		if f.Register < m.Start {
+			return fmt.Errorf("field %s: register %d is before the start register %d", f.Name, f.Register, m.Start)
+		}
+		if _, ok := modbusTypes[modbusType(f)]; !ok {
+			return fmt.Errorf("field %s: invalid type %q", f.Name, f.Type)
+		}
+		if !modbusOrders[modbusOrder(f)] {
+			return fmt.Errorf("field %s: invalid order %q: use ABCD, CDAB, BADC or DCBA", f.Name, f.Order)
+		}
+	}
+	return nil
+}
+
+// Modbus decodes a raw register dump (big-endian 16-bit registers, starting
+// at m.Start) into the map's fields. Values are float64, scaled as
+// raw*scale+offset and rounded to 12 significant digits. Fields whose
+// registers lie beyond the end of the dump are omitted, so one map can serve
+// gateways that forward shorter dumps.
+func Modbus(data []byte, m ModbusMap) (map[string]interface{}, error) {
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	if len(data)%2 != 0 {
+		return nil, fmt.Errorf("register dump has odd length %d", len(data))
+	}
+
+	out := make(map[string]interface{}, len(m.Fields))
+	for _, f := range m.Fields {
+		first := (f.Register - m.Start) * 2
+		end := first + modbusTypes[modbusType(f)]*2
+		if end > len(data) {
+			continue
+		}
+		out[f.Name] = scaleValue(modbusValue(modbusType(f), reorder(data[first:end], modbusOrder(f))), f)
+	}
+	return out, nil
+}
+
+// scaleValue applies the field's scale and offset, dropping the binary
+// floating point noise scaling adds (2301*0.1 = 230.10000000000002)
+func scaleValue(raw float64, f ModbusField) float64 {
+	if (f.Scale == 0 || f.Scale == 1) && f.Offset == 0 {
+		return raw
+	}
+	scale := f.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	v, _ := strconv.ParseFloat(strconv.FormatFloat(raw*scale+f.Offset, 'g', 12, 64), 64)
+	return v
+}
+
+// ModbusRegisters converts register values to a big-endian register dump
+func ModbusRegisters(regs []uint16) []byte {
+	data := make([]byte, len(regs)*2)
+	for i, r := range regs {
+		binary.BigEndian.PutUint16(data[i*2:], r)
+	}
+	return data
+}
+
+func modbusType(f ModbusField) string {
+	if f.Type == "" {
+		return "uint16"
+	}
+	return f.Type
+}
+
+func modbusOrder(f ModbusField) string {
+	if f.Order == "" {
+		return "ABCD"
+	}
+	return f.Order
+}
+
+// reorder returns the value's bytes in big-endian (ABCD) order
+func reorder(b []byte, order string) []byte {
+	out := make([]byte, len(b))
+	copy(out, b)
+	if order == "CDAB" || order == "DCBA" {
+		// Reverse the registers
+		for i, j := 0, len(out)-2; i < j; i, j = i+2, j-2 {
+			out[i], out[i+1], out[j], out[j+1] = out[j], out[j+1], out[i], out[i+1]
+		}
+	}
+	if order == "BADC" || order == "DCBA" {
+		// Swap the bytes of each register
+		for i := 0; i < len(out); i += 2 {
+			out[i], out[i+1] = out[i+1], out[i]
+		}
+	}
+	return out
+}
+
+// modbusValue converts big-endian bytes of the given type to a number
+func modbusValue(typ string, b []byte) float64 {
+	switch typ {
+	case "int16":
+		return float64(int16(binary.BigEndian.Uint16(b)))
+	case "uint32":
+		return float64(binary.BigEndian.Uint32(b))
+	case "int32":
+		return float64(int32(binary.BigEndian.Uint32(b)))
+	case "float32":
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(b)))
+	case "uint64":
+		return float64(binary.BigEndian.Uint64(b))
+	case "int64":
+		return float64(int64(binary.BigEndian.Uint64(b)))
+	case "float64":
+		return math.Float64frombits(binary.BigEndian.Uint64(b))
+	}
+	return float64(binary.BigEndian.Uint16(b))
+}
@@ -0,0 +1,76 @@
+package decoder
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestModbus(t *testing.T) {
+	m := ModbusMap{
+		Start: 100,
+		Fields: []ModbusField{
+			{Name: "voltage", Register: 100, Scale: 0.1},
+			{Name: "temperature", Register: 101, Type: "int16", Scale: 0.1, Offset: -0.5},
+			{Name: "energy", Register: 102, Type: "uint32"},
+			{Name: "energy_swapped", Register: 104, Type: "uint32", Order: "CDAB"},
+			{Name: "power", Register: 106, Type: "float32"},
+			{Name: "power_le", Register: 108, Type: "float32", Order: "DCBA"},
+			{Name: "beyond", Register: 120},
+		},
+	}
+	// 2301 -> 230.1 V, -125 -> -13.0 °C, 70000 twice, 1234.5 as float32 twice
+	data := ModbusRegisters([]uint16{
+		2301,
+		0xFF83,
+		0x0001, 0x1170,
+		0x1170, 0x0001,
+		0x449A, 0x5000,
+		0x0050, 0x9A44,
+	})
+
+	got, err := Modbus(data, m)
+	if err != nil {
+		t.Fatalf("Modbus failed: %v", err)
+	}
+	want := map[string]float64{
+		"voltage":        230.1,
+		"temperature":    -13.0,
+		"energy":         70000,
+		"energy_swapped": 70000,
+		"power":          1234.5,
+		"power_le":       1234.5,
+	}
+	for name, w := range want {
+		v, ok := got[name].(float64)
+		if !ok || v != w {
+			t.Errorf("%s = %v, want %v", name, got[name], w)
+		}
+	}
+	if _, ok := got["beyond"]; ok {
+		t.Errorf("Expected a field beyond the dump omitted, got %v", got["beyond"])
+	}
+}
+
+func TestModbusInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		m    ModbusMap
+		data []byte
+		want string
+	}{
+		{"odd length", ModbusMap{Fields: []ModbusField{{Name: "a"}}}, []byte{1, 2, 3}, "odd length"},
+		{"unnamed", ModbusMap{Fields: []ModbusField{{Register: 1}}}, nil, "no name"},
+		{"duplicate", ModbusMap{Fields: []ModbusField{{Name: "a"}, {Name: "a", Register: 1}}}, nil, "duplicate"},
+		{"before start", ModbusMap{Start: 10, Fields: []ModbusField{{Name: "a", Register: 9}}}, nil, "before the start"},
+		{"type", ModbusMap{Fields: []ModbusField{{Name: "a", Type: "int8"}}}, nil, "invalid type"},
+		{"order", ModbusMap{Fields: []ModbusField{{Name: "a", Order: "ACBD"}}}, nil, "invalid order"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Modbus(tt.data, tt.m)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected an error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,189 @@
+package decoder
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+// MsgPack decodes a single MessagePack value into the same shapes as CBOR.
+// Binary values become strings, map keys that aren't strings are formatted
+// with %v, integers beyond int64 become float64 and extension values are
+// returned as their raw data.
+func MsgPack(data []byte) (interface{}, error) {
+	d := &msgpackDecoder{data: data}
+	v, err := d.value(0)
+	if err != nil {
+		return nil, fmt.Errorf("invalid MessagePack at byte %d: %w", d.pos, err)
+	}
+	if d.pos != len(data) {
+		return nil, fmt.Errorf("invalid MessagePack: %d trailing bytes", len(data)-d.pos)
+	}
+	return v, nil
+}
+
+type msgpackDecoder struct {
+	data []byte
+	pos  int
+}
+
+// next reads n bytes
+func (d *msgpackDecoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errTruncated
+	}
+	b := d.data[d.pos : d.pos+int(n)]
+	d.pos += int(n)
+	return b, nil
+}
+
+// uint reads a big-endian unsigned integer of size bytes
+func (d *msgpackDecoder) uint(size uint64) (uint64, error) {
+	b, err := d.next(size)
+	if err != nil {
+		return 0, err
+	}
+	return be(b), nil
+}
+
+// bytes reads a length of lenSize bytes followed by that many bytes
+func (d *msgpackDecoder) bytes(lenSize uint64) (string, error) {
+	n, err := d.uint(lenSize)
+	if err != nil {
+		return "", err
+	}
+	b, err := d.next(n)
+	return string(b), err
+}
+
+func (d *msgpackDecoder) value(depth int) (interface{}, error) {
+	if depth > maxNesting {
+		return nil, errors.New("nesting too deep")
+	}
+	b, err := d.next(1)
+	if err != nil {
+		return nil, err
+	}
+	c := b[0]
+
+	switch {
+	case c <= 0x7f: // Positive fixint
+		return int64(c), nil
+	case c >= 0xe0: // Negative fixint
+		return int64(int8(c)), nil
+	case c >= 0xa0 && c <= 0xbf: // Fixstr
+		s, err := d.next(uint64(c & 0x1f))
+		return string(s), err
+	case c >= 0x90 && c <= 0x9f: // Fixarray
+		return d.array(uint64(c&0x0f), depth)
+	case c >= 0x80 && c <= 0x8f: // Fixmap
+		return d.object(uint64(c&0x0f), depth)
+	}
+
+	switch c {
+	case 0xc0:
+		return nil, nil
+	case 0xc2:
+		return false, nil
+	case 0xc3:
+		return true, nil
+	case 0xc4, 0xc5, 0xc6: // bin 8/16/32
+		return d.bytes(1 << (c - 0xc4))
+	case 0xd9, 0xda, 0xdb: // str 8/16/32
+		return d.bytes(1 << (c - 0xd9))
+	case 0xc7, 0xc8, 0xc9: // ext 8/16/32: length, type, data
+		n, err := d.uint(1 << (c - 0xc7))
+		if err != nil {
+			return nil, err
+		}
+		if _, err := d.next(1); err != nil {
+			return nil, err
+		}
+		ext, err := d.next(n)
+		return string(ext), err
+	case 0xd4, 0xd5, 0xd6, 0xd7, 0xd8: // fixext 1/2/4/8/16: type, data
+		if _, err := d.next(1); err != nil {
+			return nil, err
+		}
+		ext, err := d.next(1 << (c - 0xd4))
+		return string(ext), err
+	case 0xca:
+		n, err := d.uint(4)
+		return float64(math.Float32frombits(uint32(n))), err
+	case 0xcb:
+		n, err := d.uint(8)
+		return math.Float64frombits(n), err
+	case 0xcc, 0xcd, 0xce, 0xcf: // uint 8/16/32/64
+		n, err := d.uint(1 << (c - 0xcc))
+		if n > math.MaxInt64 {
+			return float64(n), err
+		}
+		return int64(n), err
+	case 0xd0, 0xd1, 0xd2, 0xd3: // int 8/16/32/64
+		size := uint64(1) << (c - 0xd0)
+		n, err := d.uint(size)
+		shift := 64 - 8*size // Sign-extend from the encoded width
+		return int64(n<<shift) >> shift, err
+	case 0xdc, 0xdd: // array 16/32
+		n, err := d.uint(2 << (c - 0xdc))
+		if err != nil {
+			return nil, err
+		}
+		return d.array(n, depth)
+	case 0xde, 0xdf: // map 16/32
+		n, err := d.uint(2 << (c - 0xde))
+		if err != nil {
+			return nil, err
+		}
+		return d.object(n, depth)
+	}
+	return nil, fmt.Errorf("unused type byte 0x%02x", c)
+}
+
+func (d *msgpackDecoder) array(n uint64, depth int) (interface{}, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errTruncated
+	}
+	list := make([]interface{}, n)
+	for i := range list {
+		v, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		list[i] = v
+	}
+	return list, nil
+}
+
+func (d *msgpackDecoder) object(n uint64, depth int) (interface{}, error) {
+	if n > uint64(len(d.data)-d.pos) {
+		return nil, errTruncated
+	}
+	m := make(map[string]interface{}, n)
+	for i := uint64(0); i < n; i++ {
+		key, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		val, err := d.value(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		m[mapKey(key)] = val
+	}
+	return m, nil
+}
+
+// be reads a big-endian unsigned integer of 1, 2, 4 or 8 bytes
+func be(b []byte) uint64 {
+	switch len(b) {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b))
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b))
+	}
+	return binary.BigEndian.Uint64(b)
+}
@@ -0,0 +1,48 @@
+package decoder
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestMsgPack(t *testing.T) {
+	tests := []struct {
+		hex  string
+		want interface{}
+	}{
+		{"7f", int64(127)},
+		{"e0", int64(-32)},
+		{"cd03e8", int64(1000)},
+		{"d0ff", int64(-1)},
+		{"d1fc18", int64(-1000)},
+		{"cfffffffffffffffff", float64(math.MaxUint64)},
+		{"ca3fc00000", 1.5},
+		{"cb3ff199999999999a", 1.1},
+		{"c0", nil},
+		{"c3", true},
+		{"a3616263", "abc"},
+		{"c4020102", "\x01\x02"},
+		{"d6ff00000001", "\x00\x00\x00\x01"}, // Timestamp extension keeps its raw data
+		{"92 01 a1 78", []interface{}{int64(1), "x"}},
+		{"82a16101a16292c2c0", map[string]interface{}{"a": int64(1), "b": []interface{}{false, nil}}},
+		{"81 07 a1 78", map[string]interface{}{"7": "x"}},
+	}
+	for _, tt := range tests {
+		data := mustHex(t, tt.hex)
+		got, err := MsgPack(data)
+		if err != nil {
+			t.Errorf("MsgPack(%s) error = %v", tt.hex, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("MsgPack(%s) = %#v, want %#v", tt.hex, got, tt.want)
+		}
+	}
+
+	for _, bad := range []string{"", "c1", "cd03", "a3 61", "92 01", "01 02", "dc ff ff"} {
+		if _, err := MsgPack(mustHex(t, bad)); err == nil {
+			t.Errorf("MsgPack(%s) succeeded, want error", bad)
+		}
+	}
+}
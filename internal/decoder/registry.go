@@ -0,0 +1,66 @@
+package decoder
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Decoder decodes a payload format compiled into the binary, such as a
+// proprietary device protocol, into a document routes select with
+// payload_format. Decode should return the same shapes as JSON decoding:
+// map[string]interface{}, []interface{}, string, bool, nil, int64 and
+// float64; other values reach scripts formatted as strings.
+type Decoder interface {
+	Name() string
+	Decode(payload []byte) (map[string]interface{}, error)
+}
+
+var (
+	registryMu sync.RWMutex
+	registry   = make(map[string]Decoder)
+)
+
+// Register makes d available as a route payload format under d.Name().
+// It panics if the name is empty or already registered. Call it during
+// startup (e.g. from an init function), before creating routers.
+func Register(d Decoder) {
+	name := d.Name()
+	if name == "" {
+		panic("decoder name is empty")
+	}
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	if _, exists := registry[name]; exists {
+		panic(fmt.Sprintf("decoder %q already registered", name))
+	}
+	registry[name] = d
+}
+
+// Unregister removes the decoder registered under name, so tests that
+// register one can clean up after themselves
+func Unregister(name string) {
+	registryMu.Lock()
+	defer registryMu.Unlock()
+	delete(registry, name)
+}
+
+// Lookup returns the decoder registered under name
+func Lookup(name string) (Decoder, bool) {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	d, ok := registry[name]
+	return d, ok
+}
+
+// Names returns the registered decoder names in sorted order
+func Names() []string {
+	registryMu.RLock()
+	defer registryMu.RUnlock()
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
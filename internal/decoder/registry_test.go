@@ -0,0 +1,51 @@
+package decoder
+
+import (
+	"slices"
+	"testing"
+)
+
+type constDecoder string
+
+func (d constDecoder) Name() string { return string(d) }
+
+func (d constDecoder) Decode(payload []byte) (map[string]interface{}, error) {
+	return map[string]interface{}{"n": int64(len(payload))}, nil
+}
+
+func TestRegister(t *testing.T) {
+	Register(constDecoder("test_b"))
+	Register(constDecoder("test_a"))
+	t.Cleanup(func() {
+		Unregister("test_a")
+		Unregister("test_b")
+	})
+
+	d, ok := Lookup("test_a")
+	if !ok || d.Name() != "test_a" {
+		t.Fatalf("Lookup(test_a) = %v, %v", d, ok)
+	}
+	if _, ok := Lookup("missing"); ok {
+		t.Error("Expected no decoder for missing")
+	}
+	names := Names()
+	if !slices.Contains(names, "test_a") || !slices.Contains(names, "test_b") || !slices.IsSorted(names) {
+		t.Errorf("Names() = %v", names)
+	}
+
+	for _, name := range []string{"test_a", ""} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("Expected panic registering %q", name)
+				}
+			}()
+			Register(constDecoder(name))
+		}()
+	}
+
+	Unregister("test_b")
+	if _, ok := Lookup("test_b"); ok {
+		t.Error("Expected test_b unregistered")
+	}
+}
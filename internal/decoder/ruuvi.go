@@ -0,0 +1,161 @@
+// Package decoder contains Go implementations of common device payload
+// formats, used by routes' payload_format and exposed to Lua scripts as
+// helper functions.
+package decoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// ruuviCompanyID is Ruuvi Innovations' Bluetooth manufacturer ID (0x0499, little-endian on air)
+var ruuviCompanyID = []byte{0x99, 0x04}
+
+// ruuviManufacturerData marks Ruuvi manufacturer data inside a BLE advertisement
+var ruuviManufacturerData = []byte{0xFF, 0x99, 0x04}
+
+// RuuviInput converts a hex string (optionally "0x"-prefixed) or raw bytes to bytes.
+// Strings that are not valid hex are treated as raw bytes.
+func RuuviInput(s string) []byte {
+	h := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(s), "0x"), "0X")
+	if b, err := hex.DecodeString(h); err == nil && len(b) > 0 {
+		return b
+	}
+	return []byte(s)
+}
+
+// Ruuvi decodes a Ruuvi data format 3 (RAWv1) or 5 (RAWv2) payload.
+// A leading manufacturer ID (0x99 0x04) is skipped, and full BLE advertisements
+// (as forwarded by the Ruuvi Gateway) are searched for the manufacturer data.
+//
+// Returned fields (all numbers are float64; invalid/unavailable values are omitted):
+//
+//	data_format                                  3 or 5
+//	temperature                                  °C
+//	humidity                                     %RH
+//	pressure                                     Pa
+//	acceleration_x, acceleration_y, acceleration_z  g
+//	battery                                      V
+//	tx_power                                     dBm (format 5)
+//	movement_counter, measurement_sequence       (format 5)
+//	mac                                          "AA:BB:CC:DD:EE:FF" (format 5)
+func Ruuvi(data []byte) (map[string]interface{}, error) {
+	switch {
+	case bytes.HasPrefix(data, ruuviCompanyID):
+		data = data[2:]
+	case len(data) > 0 && data[0] != 3 && data[0] != 5:
+		// AD structure: type 0xFF (manufacturer specific data) followed by the company ID
+		if i := bytes.Index(data, ruuviManufacturerData); i >= 0 {
+			data = data[i+len(ruuviManufacturerData):]
+		}
+	}
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty ruuvi payload")
+	}
+
+	switch data[0] {
+	case 3:
+		return ruuviV3(data)
+	case 5:
+		return ruuviV5(data)
+	}
+	return nil, fmt.Errorf("unsupported ruuvi data format %d", data[0])
+}
+
+// ruuviV3 decodes data format 3 (14 bytes)
+func ruuviV3(data []byte) (map[string]interface{}, error) {
+	if len(data) < 14 {
+		return nil, fmt.Errorf("ruuvi format 3 payload too short: %d bytes", len(data))
+	}
+
+	// Temperature: sign bit + integer part, then hundredths
+	temp := float64(data[2]&0x7F) + float64(data[3])/100
+	if data[2]&0x80 != 0 {
+		temp = -temp
+	}
+
+	return map[string]interface{}{
+		"data_format":    3.0,
+		"humidity":       float64(data[1]) * 0.5,
+		"temperature":    round(temp, 2),
+		"pressure":       float64(binary.BigEndian.Uint16(data[4:6])) + 50000,
+		"acceleration_x": float64(int16(binary.BigEndian.Uint16(data[6:8]))) / 1000,
+		"acceleration_y": float64(int16(binary.BigEndian.Uint16(data[8:10]))) / 1000,
+		"acceleration_z": float64(int16(binary.BigEndian.Uint16(data[10:12]))) / 1000,
+		"battery":        float64(binary.BigEndian.Uint16(data[12:14])) / 1000,
+	}, nil
+}
+
+// ruuviV5 decodes data format 5 (24 bytes)
+func ruuviV5(data []byte) (map[string]interface{}, error) {
+	if len(data) < 24 {
+		return nil, fmt.Errorf("ruuvi format 5 payload too short: %d bytes", len(data))
+	}
+
+	out := map[string]interface{}{"data_format": 5.0}
+	u16 := func(i int) uint16 { return binary.BigEndian.Uint16(data[i : i+2]) }
+
+	if v := int16(u16(1)); v != -0x8000 {
+		out["temperature"] = round(float64(v)*0.005, 3)
+	}
+	if v := u16(3); v != 0xFFFF {
+		out["humidity"] = round(float64(v)*0.0025, 4)
+	}
+	if v := u16(5); v != 0xFFFF {
+		out["pressure"] = float64(v) + 50000
+	}
+	for i, axis := range []string{"x", "y", "z"} {
+		if v := int16(u16(7 + 2*i)); v != -0x8000 {
+			out["acceleration_"+axis] = float64(v) / 1000
+		}
+	}
+
+	power := u16(13)
+	if v := power >> 5; v != 0x7FF {
+		out["battery"] = float64(v+1600) / 1000
+	}
+	if v := power & 0x1F; v != 0x1F {
+		out["tx_power"] = float64(-40 + 2*int(v))
+	}
+	if v := data[15]; v != 0xFF {
+		out["movement_counter"] = float64(v)
+	}
+	if v := u16(16); v != 0xFFFF {
+		out["measurement_sequence"] = float64(v)
+	}
+
+	mac := data[18:24]
+	if !allFF(mac) {
+		parts := make([]string, len(mac))
+		for i, b := range mac {
+			parts[i] = fmt.Sprintf("%02X", b)
+		}
+		out["mac"] = strings.Join(parts, ":")
+	}
+	return out, nil
+}
+
+// allFF reports whether every byte is 0xFF (Ruuvi's "not available" marker)
+func allFF(b []byte) bool {
+	for _, v := range b {
+		if v != 0xFF {
+			return false
+		}
+	}
+	return true
+}
+
+// round rounds f to n decimals to hide binary floating point noise
+func round(f float64, n int) float64 {
+	p := 1.0
+	for i := 0; i < n; i++ {
+		p *= 10
+	}
+	if f < 0 {
+		return -float64(int64(-f*p+0.5)) / p
+	}
+	return float64(int64(f*p+0.5)) / p
+}
@@ -0,0 +1,119 @@
+package decoder
+
+import (
+	"testing"
+)
+
+func TestRuuvi(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  map[string]interface{}
+	}{
+		{
+			name:  "format 5",
+			input: "0512FC5394C37C0004FFFC040CAC364200CDCBB8334C884F",
+			want: map[string]interface{}{
+				"data_format":          5.0,
+				"temperature":          24.3,
+				"humidity":             53.49,
+				"pressure":             100044.0,
+				"acceleration_x":       0.004,
+				"acceleration_y":       -0.004,
+				"acceleration_z":       1.036,
+				"battery":              2.977,
+				"tx_power":             4.0,
+				"movement_counter":     66.0,
+				"measurement_sequence": 205.0,
+				"mac":                  "CB:B8:33:4C:88:4F",
+			},
+		},
+		{
+			name:  "format 5 in gateway advertisement",
+			input: "0201061BFF99040512FC5394C37C0004FFFC040CAC364200CDCBB8334C884F",
+			want: map[string]interface{}{
+				"data_format":          5.0,
+				"temperature":          24.3,
+				"humidity":             53.49,
+				"pressure":             100044.0,
+				"acceleration_x":       0.004,
+				"acceleration_y":       -0.004,
+				"acceleration_z":       1.036,
+				"battery":              2.977,
+				"tx_power":             4.0,
+				"movement_counter":     66.0,
+				"measurement_sequence": 205.0,
+				"mac":                  "CB:B8:33:4C:88:4F",
+			},
+		},
+		{
+			name:  "format 5 with manufacturer id and invalid values",
+			input: "0x9904058000FFFFFFFF800080008000FFFFFFFFFFFFFFFFFFFFFF",
+			want:  map[string]interface{}{"data_format": 5.0},
+		},
+		{
+			name:  "format 3",
+			input: "03291A1ECE1EFC18F94202CA0B53",
+			want: map[string]interface{}{
+				"data_format":    3.0,
+				"humidity":       20.5,
+				"temperature":    26.3,
+				"pressure":       102766.0,
+				"acceleration_x": -1.0,
+				"acceleration_y": -1.726,
+				"acceleration_z": 0.714,
+				"battery":        2.899,
+			},
+		},
+		{
+			name:  "format 3 negative temperature",
+			input: "03299A1ECE1EFC18F94202CA0B53",
+			want: map[string]interface{}{
+				"data_format":    3.0,
+				"humidity":       20.5,
+				"temperature":    -26.3,
+				"pressure":       102766.0,
+				"acceleration_x": -1.0,
+				"acceleration_y": -1.726,
+				"acceleration_z": 0.714,
+				"battery":        2.899,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Ruuvi(RuuviInput(tt.input))
+			if err != nil {
+				t.Fatalf("Ruuvi() error = %v", err)
+			}
+			if len(got) != len(tt.want) {
+				t.Errorf("Ruuvi() = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%s = %v, want %v", k, got[k], v)
+				}
+			}
+		})
+	}
+}
+
+func TestRuuviErrors(t *testing.T) {
+	for _, input := range []string{"", "04AABB", "0512FC", "03291A"} {
+		if _, err := Ruuvi(RuuviInput(input)); err == nil {
+			t.Errorf("Ruuvi(%q) should fail", input)
+		}
+	}
+}
+
+func TestRuuviInputRawBytes(t *testing.T) {
+	raw := string([]byte{0x03, 0x29, 0x1A, 0x1E, 0xCE, 0x1E, 0xFC, 0x18, 0xF9, 0x42, 0x02, 0xCA, 0x0B, 0x53})
+	got, err := Ruuvi(RuuviInput(raw))
+	if err != nil {
+		t.Fatalf("Ruuvi() error = %v", err)
+	}
+	if got["temperature"] != 26.3 {
+		t.Errorf("temperature = %v, want 26.3", got["temperature"])
+	}
+}
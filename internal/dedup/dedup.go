@@ -0,0 +1,151 @@
+package dedup
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/sink"
+)
+
+// Config controls which records count as duplicates
+type Config struct {
+	Window time.Duration // How long a stored record suppresses identical ones
+	Ignore []string      // Columns left out of the hash (e.g. "time" when it is the arrival time)
+}
+
+// Filter drops records identical (same table and columns) to one stored
+// within the window, such as QoS 1 messages redelivered by the broker
+type Filter struct {
+	cfg     Config
+	next    sink.Storage
+	ignore  map[string]bool
+	now     func() time.Time
+	dropped atomic.Int64
+
+	mu        sync.Mutex
+	seen      map[[sha256.Size]byte]time.Time // Record hash -> expiry
+	nextPrune time.Time
+}
+
+// New creates a dedup filter in front of next
+func New(cfg Config, next sink.Storage) (*Filter, error) {
+	if cfg.Window <= 0 {
+		return nil, fmt.Errorf("dedup window must be positive")
+	}
+	f := &Filter{
+		cfg:    cfg,
+		next:   next,
+		ignore: make(map[string]bool, len(cfg.Ignore)),
+		now:    time.Now,
+		seen:   make(map[[sha256.Size]byte]time.Time),
+	}
+	for _, col := range cfg.Ignore {
+		f.ignore[col] = true
+	}
+	return f, nil
+}
+
+// InsertIntoTable forwards the record unless an identical one was stored
+// within the window
+func (f *Filter) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	sum := f.hash(table, data)
+	if !f.claim(sum) {
+		return nil
+	}
+	if err := f.next.InsertIntoTable(ctx, table, data); err != nil {
+		f.release(sum)
+		return err
+	}
+	return nil
+}
+
+// InsertBatch forwards the rows that aren't duplicates
+func (f *Filter) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error {
+	bs, ok := f.next.(sink.BatchStorage)
+	if !ok {
+		return sink.InsertEach(ctx, f, table, rows)
+	}
+
+	kept := make([]map[string]interface{}, 0, len(rows))
+	var sums [][sha256.Size]byte
+	for _, data := range rows {
+		sum := f.hash(table, data)
+		if f.claim(sum) {
+			kept = append(kept, data)
+			sums = append(sums, sum)
+		}
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	if err := bs.InsertBatch(ctx, table, kept); err != nil {
+		for _, sum := range sums {
+			f.release(sum)
+		}
+		return err
+	}
+	return nil
+}
+
+// Dropped returns the number of duplicate records dropped
+func (f *Filter) Dropped() int64 {
+	return f.dropped.Load()
+}
+
+// claim records sum as stored and reports whether it was new
+func (f *Filter) claim(sum [sha256.Size]byte) bool {
+	now := f.now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !now.Before(f.nextPrune) {
+		for k, exp := range f.seen {
+			if !now.Before(exp) {
+				delete(f.seen, k)
+			}
+		}
+		f.nextPrune = now.Add(f.cfg.Window)
+	}
+	if exp, ok := f.seen[sum]; ok && now.Before(exp) {
+		f.dropped.Add(1)
+		return false
+	}
+	f.seen[sum] = now.Add(f.cfg.Window)
+	return true
+}
+
+// release forgets a claimed record whose insert failed, so a retry is stored
+func (f *Filter) release(sum [sha256.Size]byte) {
+	f.mu.Lock()
+	delete(f.seen, sum)
+	f.mu.Unlock()
+}
+
+// hash returns the SHA-256 of the table and its columns in sorted order
+func (f *Filter) hash(table string, data map[string]interface{}) [sha256.Size]byte {
+	cols := make([]string, 0, len(data))
+	for k := range data {
+		if !f.ignore[k] {
+			cols = append(cols, k)
+		}
+	}
+	sort.Strings(cols)
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00", table)
+	for _, k := range cols {
+		v := data[k]
+		// Format times without the monotonic clock reading and zone
+		if t, ok := v.(time.Time); ok {
+			v = t.UTC().Format(time.RFC3339Nano)
+		}
+		fmt.Fprintf(h, "%s\x00%T\x00%v\x00", k, v, v)
+	}
+	var sum [sha256.Size]byte
+	h.Sum(sum[:0])
+	return sum
+}
@@ -0,0 +1,105 @@
+package dedup
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// mockStorage records inserts and optionally fails them
+type mockStorage struct {
+	fail bool
+	rows []map[string]interface{}
+}
+
+func (m *mockStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	if m.fail {
+		return errors.New("db down")
+	}
+	m.rows = append(m.rows, data)
+	return nil
+}
+
+// batchStorage records batch inserts
+type batchStorage struct {
+	mockStorage
+	batches int
+}
+
+func (b *batchStorage) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error {
+	b.batches++
+	b.rows = append(b.rows, rows...)
+	return nil
+}
+
+func TestFilterDropsDuplicates(t *testing.T) {
+	next := &mockStorage{}
+	f, err := New(Config{Window: time.Minute, Ignore: []string{"received"}}, next)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	f.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	at := time.Date(2024, 1, 1, 11, 59, 0, 0, time.UTC)
+	inserts := []struct {
+		table string
+		data  map[string]interface{}
+	}{
+		{"readings", map[string]interface{}{"time": at, "device": "a", "v": 1.0, "received": now}},
+		{"readings", map[string]interface{}{"time": at.In(time.FixedZone("CET", 3600)), "device": "a", "v": 1.0, "received": now.Add(time.Second)}}, // duplicate
+		{"readings", map[string]interface{}{"time": at, "device": "a", "v": 2.0}},
+		{"other", map[string]interface{}{"time": at, "device": "a", "v": 1.0}},
+		{"readings", map[string]interface{}{"time": at, "device": "a", "v": "1"}}, // different type
+	}
+	for _, in := range inserts {
+		if err := f.InsertIntoTable(ctx, in.table, in.data); err != nil {
+			t.Fatalf("InsertIntoTable failed: %v", err)
+		}
+	}
+	if len(next.rows) != 4 || f.Dropped() != 1 {
+		t.Errorf("stored %d rows, dropped %d; want 4 and 1", len(next.rows), f.Dropped())
+	}
+
+	// Failed inserts don't suppress the retry
+	next.fail = true
+	dup := map[string]interface{}{"time": at, "device": "b"}
+	if err := f.InsertIntoTable(ctx, "readings", dup); err == nil {
+		t.Fatal("Expected insert error")
+	}
+	next.fail = false
+	if err := f.InsertIntoTable(ctx, "readings", dup); err != nil || len(next.rows) != 5 {
+		t.Errorf("retry: err=%v rows=%d; want stored", err, len(next.rows))
+	}
+
+	// Records are accepted again once the window has passed
+	now = now.Add(time.Minute)
+	if err := f.InsertIntoTable(ctx, "readings", inserts[0].data); err != nil || len(next.rows) != 6 {
+		t.Errorf("after window: err=%v rows=%d; want stored", err, len(next.rows))
+	}
+}
+
+func TestFilterBatch(t *testing.T) {
+	next := &batchStorage{}
+	f, err := New(Config{Window: time.Minute}, next)
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	row := map[string]interface{}{"device": "a", "v": 1.0}
+	rows := []map[string]interface{}{row, row, {"device": "b", "v": 1.0}}
+	if err := f.InsertBatch(context.Background(), "readings", rows); err != nil {
+		t.Fatalf("InsertBatch failed: %v", err)
+	}
+	if err := f.InsertBatch(context.Background(), "readings", rows[:1]); err != nil {
+		t.Fatalf("InsertBatch failed: %v", err)
+	}
+	if len(next.rows) != 2 || next.batches != 1 || f.Dropped() != 2 {
+		t.Errorf("rows=%d batches=%d dropped=%d; want 2, 1, 2", len(next.rows), next.batches, f.Dropped())
+	}
+
+	if _, err := New(Config{}, next); err == nil {
+		t.Error("Expected error for a zero window")
+	}
+}
@@ -0,0 +1,297 @@
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// TableName is the table maintained by the registry
+const TableName = "hermod_devices"
+
+// CreateTableSQL returns the DDL for the registry table, with IF NOT EXISTS
+// when ifNotExists is set
+func CreateTableSQL(ifNotExists bool) string {
+	create := "CREATE TABLE"
+	if ifNotExists {
+		create += " IF NOT EXISTS"
+	}
+	return create + ` hermod_devices (
+  device_id text PRIMARY KEY,
+  first_seen timestamptz NOT NULL,
+  last_seen timestamptz NOT NULL,
+  message_count bigint NOT NULL DEFAULT 0,
+  last_topic text
+);`
+}
+
+// upsertSQL merges pending observations into the registry table
+const upsertSQL = `INSERT INTO hermod_devices (device_id, first_seen, last_seen, message_count, last_topic)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (device_id) DO UPDATE SET
+  first_seen = LEAST(hermod_devices.first_seen, EXCLUDED.first_seen),
+  last_seen = GREATEST(hermod_devices.last_seen, EXCLUDED.last_seen),
+  message_count = hermod_devices.message_count + EXCLUDED.message_count,
+  last_topic = EXCLUDED.last_topic`
+
+// defaultFlushInterval is how often pending observations are written
+const defaultFlushInterval = 10 * time.Second
+
+// Database is the storage used to persist and load the registry
+type Database interface {
+	Exec(ctx context.Context, query string, args ...interface{}) error
+	QueryRows(ctx context.Context, query string) ([]string, []map[string]interface{}, error)
+}
+
+// Device is a registry entry
+type Device struct {
+	ID           string
+	FirstSeen    time.Time
+	LastSeen     time.Time
+	MessageCount int64
+	LastTopic    string
+}
+
+// Registry tracks devices in memory and periodically upserts them to the database
+type Registry struct {
+	db       Database
+	interval time.Duration
+	logger   *logger.Logger
+	mu       sync.RWMutex
+	devices  map[string]*Device
+	pending  map[string]*Device // Observations not yet written (count is a delta)
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// New creates a device registry. A zero interval uses the default (10s).
+func New(db Database, interval time.Duration, log *logger.Logger) *Registry {
+	if log == nil {
+		log = logger.New(logger.INFO)
+	}
+	if interval <= 0 {
+		interval = defaultFlushInterval
+	}
+	return &Registry{
+		db:       db,
+		interval: interval,
+		logger:   log,
+		devices:  make(map[string]*Device),
+		pending:  make(map[string]*Device),
+	}
+}
+
+// Load reads existing devices from the database so reads cover devices seen before startup
+func (r *Registry) Load(ctx context.Context) error {
+	_, rows, err := r.db.QueryRows(ctx, "SELECT device_id, first_seen, last_seen, message_count, last_topic FROM "+TableName)
+	if err != nil {
+		return fmt.Errorf("failed to load devices: %w", err)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, row := range rows {
+		d := &Device{ID: fmt.Sprintf("%v", row["device_id"])}
+		d.FirstSeen, _ = row["first_seen"].(time.Time)
+		d.LastSeen, _ = row["last_seen"].(time.Time)
+		d.MessageCount, _ = row["message_count"].(int64)
+		d.LastTopic, _ = row["last_topic"].(string)
+		r.devices[d.ID] = d
+	}
+	r.logger.Infof("Device registry loaded %d devices", len(rows))
+	return nil
+}
+
+// Observe records a message from a device
+func (r *Registry) Observe(id, topic string, at time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	d, ok := r.devices[id]
+	if !ok {
+		d = &Device{ID: id, FirstSeen: at}
+		r.devices[id] = d
+	}
+	if at.After(d.LastSeen) {
+		d.LastSeen = at
+		d.LastTopic = topic
+	}
+	d.MessageCount++
+
+	p, ok := r.pending[id]
+	if !ok {
+		p = &Device{ID: id, FirstSeen: at}
+		r.pending[id] = p
+	}
+	if at.Before(p.FirstSeen) {
+		p.FirstSeen = at
+	}
+	if at.After(p.LastSeen) {
+		p.LastSeen = at
+		p.LastTopic = topic
+	}
+	p.MessageCount++
+}
+
+// Get returns a copy of a device entry
+func (r *Registry) Get(id string) (Device, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	d, ok := r.devices[id]
+	if !ok {
+		return Device{}, false
+	}
+	return *d, true
+}
+
+// Silent returns the IDs of devices not seen within the given duration, sorted
+func (r *Registry) Silent(within time.Duration, now time.Time) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var ids []string
+	for id, d := range r.devices {
+		if now.Sub(d.LastSeen) > within {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// Start begins periodic flushing of pending observations
+func (r *Registry) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				r.Flush(ctx)
+			}
+		}
+	}()
+}
+
+// Close stops flushing and writes remaining observations
+func (r *Registry) Close() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+	r.Flush(context.Background())
+}
+
+// Flush upserts pending observations; failed entries are retried on the next flush
+func (r *Registry) Flush(ctx context.Context) {
+	r.mu.Lock()
+	pending := r.pending
+	r.pending = make(map[string]*Device)
+	r.mu.Unlock()
+
+	for id, p := range pending {
+		err := r.db.Exec(ctx, upsertSQL, p.ID, p.FirstSeen, p.LastSeen, p.MessageCount, p.LastTopic)
+		if err == nil {
+			continue
+		}
+		r.logger.Errorf("Failed to update device %s: %v", id, err)
+
+		// Merge back for retry
+		r.mu.Lock()
+		if cur, ok := r.pending[id]; ok {
+			cur.MessageCount += p.MessageCount
+			if p.FirstSeen.Before(cur.FirstSeen) {
+				cur.FirstSeen = p.FirstSeen
+			}
+		} else {
+			r.pending[id] = p
+		}
+		r.mu.Unlock()
+	}
+}
+
+// Expr extracts a device ID from a message.
+//
+// Supported forms:
+//
+//	"topic"        the full topic
+//	"topic[2]"     the 2nd topic level (1-based), e.g. "abc" for "ruuvi/abc"
+//	"json.a.b"     a field of the JSON payload
+type Expr struct {
+	kind  string // "topic", "level" or "json"
+	level int
+	path  []string
+}
+
+// ParseExpr parses a device-id expression
+func ParseExpr(s string) (*Expr, error) {
+	s = strings.TrimSpace(s)
+	switch {
+	case s == "topic":
+		return &Expr{kind: "topic"}, nil
+	case strings.HasPrefix(s, "topic[") && strings.HasSuffix(s, "]"):
+		n, err := strconv.Atoi(s[len("topic[") : len(s)-1])
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid topic level in device_id %q", s)
+		}
+		return &Expr{kind: "level", level: n}, nil
+	case strings.HasPrefix(s, "json.") && len(s) > len("json."):
+		return &Expr{kind: "json", path: strings.Split(s[len("json."):], ".")}, nil
+	}
+	return nil, fmt.Errorf("invalid device_id expression %q: use topic, topic[N] or json.field", s)
+}
+
+// Eval returns the device ID for a message, or false when it can't be determined
+func (e *Expr) Eval(topic string, payload []byte) (string, bool) {
+	var doc interface{}
+	if e.kind == "json" {
+		if err := json.Unmarshal(payload, &doc); err != nil {
+			return "", false
+		}
+	}
+	return e.EvalParsed(topic, doc)
+}
+
+// EvalParsed is Eval for a payload that was already decoded as JSON (nil = not JSON)
+func (e *Expr) EvalParsed(topic string, doc interface{}) (string, bool) {
+	switch e.kind {
+	case "topic":
+		return topic, topic != ""
+	case "level":
+		levels := strings.Split(topic, "/")
+		if e.level > len(levels) || levels[e.level-1] == "" {
+			return "", false
+		}
+		return levels[e.level-1], true
+	case "json":
+		v := doc
+		for _, key := range e.path {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return "", false
+			}
+			if v, ok = m[key]; !ok {
+				return "", false
+			}
+		}
+		if v == nil {
+			return "", false
+		}
+		if f, ok := v.(float64); ok {
+			return strconv.FormatFloat(f, 'f', -1, 64), true
+		}
+		return fmt.Sprintf("%v", v), true
+	}
+	return "", false
+}
@@ -0,0 +1,134 @@
+package device
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// mockDB records executed statements
+type mockDB struct {
+	execs [][]interface{}
+	fail  bool
+	rows  []map[string]interface{}
+}
+
+func (m *mockDB) Exec(ctx context.Context, query string, args ...interface{}) error {
+	if m.fail {
+		return errors.New("db down")
+	}
+	m.execs = append(m.execs, args)
+	return nil
+}
+
+func (m *mockDB) QueryRows(ctx context.Context, query string) ([]string, []map[string]interface{}, error) {
+	return nil, m.rows, nil
+}
+
+func TestParseExprAndEval(t *testing.T) {
+	tests := []struct {
+		expr    string
+		topic   string
+		payload string
+		want    string
+		ok      bool
+	}{
+		{"topic", "ruuvi/abc", "", "ruuvi/abc", true},
+		{"topic[2]", "ruuvi/abc", "", "abc", true},
+		{"topic[3]", "ruuvi/abc", "", "", false},
+		{"json.mac", "x", `{"mac":"AA:BB"}`, "AA:BB", true},
+		{"json.meta.id", "x", `{"meta":{"id":42}}`, "42", true},
+		{"json.mac", "x", `not json`, "", false},
+		{"json.missing", "x", `{"mac":"AA"}`, "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.expr+"/"+tt.topic, func(t *testing.T) {
+			e, err := ParseExpr(tt.expr)
+			if err != nil {
+				t.Fatalf("ParseExpr(%q) failed: %v", tt.expr, err)
+			}
+			got, ok := e.Eval(tt.topic, []byte(tt.payload))
+			if ok != tt.ok || got != tt.want {
+				t.Errorf("Eval = %q, %v; want %q, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+
+	for _, bad := range []string{"", "topic[0]", "topic[x]", "json.", "payload"} {
+		if _, err := ParseExpr(bad); err == nil {
+			t.Errorf("ParseExpr(%q) should fail", bad)
+		}
+	}
+}
+
+func TestRegistryObserveAndFlush(t *testing.T) {
+	db := &mockDB{}
+	reg := New(db, time.Hour, nil)
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	reg.Observe("a", "ruuvi/a", base)
+	reg.Observe("a", "ruuvi/a/state", base.Add(time.Minute))
+	reg.Observe("b", "ruuvi/b", base.Add(2*time.Minute))
+
+	d, ok := reg.Get("a")
+	if !ok {
+		t.Fatal("Expected device a")
+	}
+	if d.MessageCount != 2 || !d.FirstSeen.Equal(base) || d.LastTopic != "ruuvi/a/state" {
+		t.Errorf("Unexpected device: %+v", d)
+	}
+
+	silent := reg.Silent(90*time.Second, base.Add(3*time.Minute))
+	if len(silent) != 1 || silent[0] != "a" {
+		t.Errorf("Expected [a] to be silent, got %v", silent)
+	}
+
+	reg.Flush(context.Background())
+	if len(db.execs) != 2 {
+		t.Fatalf("Expected 2 upserts, got %d", len(db.execs))
+	}
+
+	// Nothing pending after a successful flush
+	reg.Flush(context.Background())
+	if len(db.execs) != 2 {
+		t.Errorf("Expected no further upserts, got %d", len(db.execs))
+	}
+}
+
+func TestRegistryFlushRetry(t *testing.T) {
+	db := &mockDB{fail: true}
+	reg := New(db, time.Hour, nil)
+
+	now := time.Now()
+	reg.Observe("a", "t/a", now)
+	reg.Flush(context.Background())
+
+	reg.Observe("a", "t/a", now.Add(time.Second))
+	db.fail = false
+	reg.Flush(context.Background())
+
+	if len(db.execs) != 1 {
+		t.Fatalf("Expected 1 upsert after retry, got %d", len(db.execs))
+	}
+	if count := db.execs[0][3]; count != int64(2) {
+		t.Errorf("Expected merged message_count delta 2, got %v", count)
+	}
+}
+
+func TestRegistryLoad(t *testing.T) {
+	seen := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	db := &mockDB{rows: []map[string]interface{}{
+		{"device_id": "old", "first_seen": seen, "last_seen": seen, "message_count": int64(10), "last_topic": "x/old"},
+	}}
+	reg := New(db, 0, nil)
+	if err := reg.Load(context.Background()); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	d, ok := reg.Get("old")
+	if !ok || d.MessageCount != 10 || d.LastTopic != "x/old" {
+		t.Errorf("Unexpected loaded device: %+v", d)
+	}
+}
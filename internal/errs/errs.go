@@ -0,0 +1,37 @@
+package errs
+
+import "errors"
+
+// Error classes shared by the router, stages and storage. Errors are wrapped
+// with %w, so callers branch with errors.Is instead of matching messages.
+var (
+	ErrQueueFull          = errors.New("queue full")               // A route queue had no room; the message was not accepted
+	ErrTransform          = errors.New("transform failed")         // The route script failed or returned invalid records
+	ErrSchemaViolation    = errors.New("schema validation failed") // A record doesn't match the script's schema
+	ErrStorageUnavailable = errors.New("storage unavailable")      // The sink couldn't be reached; retrying later may succeed
+	ErrTimeout            = errors.New("processing timed out")     // A message took longer than the route's processing timeout
+	ErrSchemaDrift        = errors.New("schema drift")             // The database lacks the table or a column a record needs
+)
+
+// Class returns a short label for err's class, for metrics and logs:
+// "queue_full", "timeout", "transform", "schema", "schema_drift",
+// "storage_unavailable", "other", or "" for a nil error
+func Class(err error) string {
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, ErrQueueFull):
+		return "queue_full"
+	case errors.Is(err, ErrTimeout):
+		return "timeout"
+	case errors.Is(err, ErrTransform):
+		return "transform"
+	case errors.Is(err, ErrSchemaViolation):
+		return "schema"
+	case errors.Is(err, ErrSchemaDrift):
+		return "schema_drift"
+	case errors.Is(err, ErrStorageUnavailable):
+		return "storage_unavailable"
+	}
+	return "other"
+}
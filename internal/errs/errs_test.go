@@ -0,0 +1,28 @@
+package errs
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestClass(t *testing.T) {
+	tests := []struct {
+		err  error
+		want string
+	}{
+		{nil, ""},
+		{fmt.Errorf("route a/+: %w", ErrQueueFull), "queue_full"},
+		{fmt.Errorf("%w: Lua transform error", ErrTransform), "transform"},
+		{fmt.Errorf("%w for table t: missing column", ErrSchemaViolation), "schema"},
+		{fmt.Errorf("failed to insert into t: %w", fmt.Errorf("%w: column \"x\" does not exist", ErrSchemaDrift)), "schema_drift"},
+		{fmt.Errorf("failed to insert into t: %w", fmt.Errorf("%w: dial tcp", ErrStorageUnavailable)), "storage_unavailable"},
+		{fmt.Errorf("%w after 1s on a/b: %w", ErrTimeout, fmt.Errorf("%w: deadline", ErrTransform)), "timeout"},
+		{errors.New("boom"), "other"},
+	}
+	for _, tt := range tests {
+		if got := Class(tt.err); got != tt.want {
+			t.Errorf("Class(%v) = %q, want %q", tt.err, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Package expiry periodically deletes expired rows from plain tables whose
+// schema declares a retention (hypertables use TimescaleDB retention
+// policies instead).
+package expiry
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// DefaultInterval is how often expired rows are deleted when no interval is set
+const DefaultInterval = time.Hour
+
+// Job deletes the expired rows of one table
+type Job struct {
+	Table string
+	SQL   string // DELETE statement (schema.TableSchema.GenerateExpiry)
+}
+
+// Database executes statements
+type Database interface {
+	Exec(ctx context.Context, query string, args ...interface{}) error
+}
+
+// Scheduler runs the jobs every interval
+type Scheduler struct {
+	jobs     []Job
+	db       Database
+	interval time.Duration
+	logger   *logger.Logger
+	cancel   context.CancelFunc
+	wg       sync.WaitGroup
+}
+
+// New creates a scheduler; Start begins running the jobs
+func New(jobs []Job, db Database, interval time.Duration, log *logger.Logger) *Scheduler {
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	if log == nil {
+		log = logger.New(logger.INFO)
+	}
+	return &Scheduler{jobs: jobs, db: db, interval: interval, logger: log}
+}
+
+// Start runs the jobs now and then every interval until ctx is done or Close is called
+func (s *Scheduler) Start(ctx context.Context) {
+	ctx, s.cancel = context.WithCancel(ctx)
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			s.Run(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Run deletes the expired rows of every table once
+func (s *Scheduler) Run(ctx context.Context) {
+	for _, job := range s.jobs {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := s.db.Exec(ctx, job.SQL); err != nil {
+			s.logger.Errorf("Failed to delete expired rows from %s: %v", job.Table, err)
+			continue
+		}
+		s.logger.Debugf("Deleted expired rows from %s", job.Table)
+	}
+}
+
+// Close stops the scheduler and waits for a running job to finish
+func (s *Scheduler) Close() {
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.wg.Wait()
+}
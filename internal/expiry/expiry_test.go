@@ -0,0 +1,66 @@
+package expiry
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// recordingDB records executed statements and fails those in fail
+type recordingDB struct {
+	mu    sync.Mutex
+	execs []string
+	fail  map[string]bool
+}
+
+func (d *recordingDB) Exec(ctx context.Context, query string, args ...interface{}) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.execs = append(d.execs, query)
+	if d.fail[query] {
+		return errors.New("relation does not exist")
+	}
+	return nil
+}
+
+func (d *recordingDB) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.execs)
+}
+
+func TestRunContinuesAfterFailure(t *testing.T) {
+	jobs := []Job{
+		{Table: "a", SQL: "DELETE FROM a"},
+		{Table: "b", SQL: "DELETE FROM b"},
+	}
+	db := &recordingDB{fail: map[string]bool{"DELETE FROM a": true}}
+	New(jobs, db, 0, logger.New(logger.ERROR)).Run(context.Background())
+	if len(db.execs) != 2 || db.execs[1] != "DELETE FROM b" {
+		t.Errorf("Expected both jobs to run, got %v", db.execs)
+	}
+}
+
+func TestSchedulerRepeats(t *testing.T) {
+	db := &recordingDB{}
+	s := New([]Job{{Table: "a", SQL: "DELETE FROM a"}}, db, 5*time.Millisecond, logger.New(logger.ERROR))
+	s.Start(context.Background())
+
+	deadline := time.Now().Add(time.Second)
+	for db.count() < 3 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	s.Close()
+	n := db.count()
+	if n < 3 {
+		t.Errorf("Expected repeated runs, got %d", n)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if db.count() != n {
+		t.Error("Expected no runs after Close")
+	}
+}
@@ -0,0 +1,331 @@
+// Package forward moves transformed records from an edge Hermod to a central
+// one that owns the database writes. The edge's Client takes the place of its
+// database; the central Receiver writes what arrives into its own storage.
+//
+// Records travel in batches per table over HTTP(S) as gzip'd gob, which keeps
+// column types (times, integers, nested JSON) intact between Go processes.
+// Edge routes with batch settings send one request per batch.
+package forward
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/subtle"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/sink"
+)
+
+const (
+	recordsPath = "/v1/records"
+	healthPath  = "/v1/health"
+	contentType = "application/x-hermod-records"
+
+	// defaultTimeout bounds one forwarding request
+	defaultTimeout = 10 * time.Second
+
+	// maxBatchBytes bounds a request body, before and after decompression
+	maxBatchBytes = 64 << 20
+
+	// readTimeout bounds reading a whole request, idleTimeout how long an
+	// idle keep-alive connection is held open
+	readTimeout = time.Minute
+	idleTimeout = 2 * time.Minute
+)
+
+// ErrUnauthorized is returned when the receiver rejects the client's token.
+// Retrying won't help until the configuration is fixed, so it isn't
+// errs.ErrStorageUnavailable.
+var ErrUnauthorized = errors.New("forward receiver rejected the token")
+
+// batch is the body of one forwarding request
+type batch struct {
+	Table string
+	Rows  []map[string]interface{}
+}
+
+func init() {
+	// Concrete types records hold inside interface{} besides gob's basic types
+	gob.Register(time.Time{})
+	gob.Register(map[string]interface{}{})
+	gob.Register([]interface{}{})
+}
+
+// ClientConfig holds the edge side's settings
+type ClientConfig struct {
+	URL     string        // Central Hermod's forward listener (e.g., "https://central:7400")
+	Token   string        // Bearer token the receiver expects
+	Timeout time.Duration // Longest a request may take (default: 10s)
+}
+
+// Client forwards records to a central Hermod. It implements sink.Storage
+// and sink.BatchStorage, so it can replace the database on an edge.
+type Client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// NewClient creates a client for the receiver at cfg.URL
+func NewClient(cfg ClientConfig) (*Client, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("invalid forward url %q: use http(s)://host:port", cfg.URL)
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = defaultTimeout
+	}
+	return &Client{
+		base:  strings.TrimSuffix(cfg.URL, "/"),
+		token: cfg.Token,
+		http:  &http.Client{Timeout: cfg.Timeout},
+	}, nil
+}
+
+// InsertIntoTable forwards a single record
+func (c *Client) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	return c.InsertBatch(ctx, table, []map[string]interface{}{data})
+}
+
+// InsertBatch forwards rows in one request. The receiver stores all of them
+// or reports an error; failures to reach it or store them are
+// errs.ErrStorageUnavailable, so they count as outages and are redelivered.
+// A rejected token is ErrUnauthorized.
+func (c *Client) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error {
+	var body bytes.Buffer
+	gz := gzip.NewWriter(&body)
+	if err := gob.NewEncoder(gz).Encode(batch{Table: table, Rows: rows}); err != nil {
+		return fmt.Errorf("failed to encode records for %s: %w", table, err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to compress records for %s: %w", table, err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.base+recordsPath, &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Content-Encoding", "gzip")
+	return c.do(req, table)
+}
+
+// Ping checks that the receiver is reachable and accepts the token
+func (c *Client) Ping(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.base+healthPath, nil)
+	if err != nil {
+		return err
+	}
+	return c.do(req, "")
+}
+
+// do sends req and maps the receiver's answer to an error class
+func (c *Client) do(req *http.Request, table string) error {
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: failed to forward to %s: %w", errs.ErrStorageUnavailable, c.base, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	err = fmt.Errorf("receiver %s answered %s: %s", c.base, resp.Status, strings.TrimSpace(string(msg)))
+	if table != "" {
+		err = fmt.Errorf("failed to forward records for %s: %w", table, err)
+	}
+	switch resp.StatusCode {
+	case http.StatusUnauthorized, http.StatusForbidden:
+		// The token is wrong: retrying won't help until it's fixed
+		return fmt.Errorf("%w: %w", ErrUnauthorized, err)
+	case http.StatusConflict:
+		// The central database lacks the table or a column
+		return fmt.Errorf("%w: %w", errs.ErrSchemaDrift, err)
+	case http.StatusBadRequest, http.StatusUnprocessableEntity, http.StatusRequestEntityTooLarge:
+		// Sending these records again won't help
+		return err
+	}
+	// Unavailable or failing: retrying later may succeed
+	return fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err)
+}
+
+// ReceiverConfig holds the central side's settings
+type ReceiverConfig struct {
+	Address string       // Listen address (e.g., ":7400")
+	Token   string       // Bearer token clients must send
+	TLSCert string       // PEM certificate to serve HTTPS with (empty = plain HTTP)
+	TLSKey  string       // PEM key of TLSCert
+	Storage sink.Storage // Where forwarded records are written; must also be a sink.BatchStorage
+	Logger  *logger.Logger
+}
+
+// Receiver accepts records forwarded by edge Hermods
+type Receiver struct {
+	cfg      ReceiverConfig
+	srv      *http.Server
+	listener net.Listener
+	logger   *logger.Logger
+	maxBody  int64 // Longest request body, before and after decompression
+}
+
+// NewReceiver creates a receiver; it listens once Start is called
+func NewReceiver(cfg ReceiverConfig) (*Receiver, error) {
+	switch {
+	case cfg.Address == "":
+		return nil, errors.New("forward listen address is required")
+	case cfg.Token == "":
+		return nil, errors.New("forward token is required to accept records")
+	case cfg.Storage == nil:
+		return nil, errors.New("forward receiver needs storage")
+	case !isBatchStorage(cfg.Storage):
+		return nil, errors.New("forward receiver needs storage that writes batches in one transaction")
+	case (cfg.TLSCert == "") != (cfg.TLSKey == ""):
+		return nil, errors.New("forward tls_cert and tls_key must be set together")
+	}
+	log := cfg.Logger
+	if log == nil {
+		log = logger.New(logger.INFO)
+	}
+
+	r := &Receiver{cfg: cfg, logger: log, maxBody: maxBatchBytes}
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST "+recordsPath, r.authorized(r.handleRecords))
+	mux.HandleFunc("GET "+healthPath, r.authorized(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	r.srv = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+		ReadTimeout:       readTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+	return r, nil
+}
+
+// Start begins accepting records in the background
+func (r *Receiver) Start() error {
+	ln, err := net.Listen("tcp", r.cfg.Address)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", r.cfg.Address, err)
+	}
+	r.listener = ln
+	r.logger.Infof("Accepting forwarded records on %s", ln.Addr())
+
+	go func() {
+		var err error
+		if r.cfg.TLSCert != "" {
+			err = r.srv.ServeTLS(ln, r.cfg.TLSCert, r.cfg.TLSKey)
+		} else {
+			err = r.srv.Serve(ln)
+		}
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			r.logger.Errorf("Forward receiver stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Addr returns the bound address (useful with port 0)
+func (r *Receiver) Addr() string {
+	if r.listener == nil {
+		return r.cfg.Address
+	}
+	return r.listener.Addr().String()
+}
+
+// Close stops accepting records, waiting for requests in progress
+func (r *Receiver) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return r.srv.Shutdown(ctx)
+}
+
+// authorized rejects requests without the receiver's token
+func (r *Receiver) authorized(next http.HandlerFunc) http.HandlerFunc {
+	want := []byte("Bearer " + r.cfg.Token)
+	return func(w http.ResponseWriter, req *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(req.Header.Get("Authorization")), want) != 1 {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		next(w, req)
+	}
+}
+
+// handleRecords stores one forwarded batch
+func (r *Receiver) handleRecords(w http.ResponseWriter, req *http.Request) {
+	var body io.Reader = http.MaxBytesReader(w, req.Body, r.maxBody)
+	if req.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			http.Error(w, "invalid gzip body", bodyStatus(err))
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	var b batch
+	if err := gob.NewDecoder(io.LimitReader(body, r.maxBody)).Decode(&b); err != nil {
+		http.Error(w, fmt.Sprintf("invalid records: %v", err), bodyStatus(err))
+		return
+	}
+	if b.Table == "" {
+		http.Error(w, "records have no table", http.StatusBadRequest)
+		return
+	}
+
+	if err := r.store(req.Context(), b); err != nil {
+		r.logger.Errorf("Failed to store %d forwarded records for %s: %v", len(b.Rows), b.Table, err)
+		status := http.StatusUnprocessableEntity
+		switch {
+		case errors.Is(err, errs.ErrStorageUnavailable):
+			status = http.StatusServiceUnavailable
+		case errors.Is(err, errs.ErrSchemaDrift):
+			status = http.StatusConflict
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	r.logger.Debugf("Stored %d forwarded records for %s", len(b.Rows), b.Table)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// bodyStatus returns the status for a request body that couldn't be read
+func bodyStatus(err error) int {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	return http.StatusBadRequest
+}
+
+// isBatchStorage reports whether s can write several rows at once
+func isBatchStorage(s sink.Storage) bool {
+	_, ok := s.(sink.BatchStorage)
+	return ok
+}
+
+// store writes a batch in one transaction, so a failed batch leaves no rows
+// behind that the edge's retry would store again
+func (r *Receiver) store(ctx context.Context, b batch) error {
+	if len(b.Rows) == 1 {
+		return r.cfg.Storage.InsertIntoTable(ctx, b.Table, b.Rows[0])
+	}
+	return r.cfg.Storage.(sink.BatchStorage).InsertBatch(ctx, b.Table, b.Rows)
+}
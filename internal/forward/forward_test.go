@@ -0,0 +1,183 @@
+package forward
+
+import (
+	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/sink"
+)
+
+// memStorage records what the receiver writes, failing while err is set
+type memStorage struct {
+	mu      sync.Mutex
+	rows    map[string][]map[string]interface{}
+	batches int
+	err     error
+}
+
+func (m *memStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	return m.InsertBatch(ctx, table, []map[string]interface{}{data})
+}
+
+func (m *memStorage) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	if m.rows == nil {
+		m.rows = make(map[string][]map[string]interface{})
+	}
+	m.rows[table] = append(m.rows[table], rows...)
+	m.batches++
+	return nil
+}
+
+func startReceiver(t *testing.T, storage sink.Storage) *Receiver {
+	t.Helper()
+	r, err := NewReceiver(ReceiverConfig{Address: "127.0.0.1:0", Token: "secret", Storage: storage, Logger: logger.New(logger.ERROR)})
+	if err != nil {
+		t.Fatalf("NewReceiver() error = %v", err)
+	}
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	t.Cleanup(func() { r.Close() })
+	return r
+}
+
+func TestForwardRoundTrip(t *testing.T) {
+	storage := &memStorage{}
+	r := startReceiver(t, storage)
+	c, err := NewClient(ClientConfig{URL: "http://" + r.Addr(), Token: "secret"})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	ctx := context.Background()
+	if err := c.Ping(ctx); err != nil {
+		t.Fatalf("Ping() error = %v", err)
+	}
+
+	at := time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+	rows := []map[string]interface{}{
+		{"time": at, "sensor": "a", "count": int64(1 << 60), "value": 21.5, "meta": map[string]interface{}{"fw": "1.2"}, "gap": nil},
+		{"time": at, "sensor": "b", "count": int64(2), "value": 22.0, "tags": []interface{}{"x", 1.0}, "ok": true},
+	}
+	if err := c.InsertBatch(ctx, "readings", rows); err != nil {
+		t.Fatalf("InsertBatch() error = %v", err)
+	}
+	if err := c.InsertIntoTable(ctx, "events", map[string]interface{}{"kind": "boot"}); err != nil {
+		t.Fatalf("InsertIntoTable() error = %v", err)
+	}
+
+	got := storage.rows["readings"]
+	if len(got) != 2 || storage.batches != 2 {
+		t.Fatalf("Expected 2 readings in one batch, got %v (%d batches)", got, storage.batches)
+	}
+	if ts, ok := got[0]["time"].(time.Time); !ok || !ts.Equal(at) {
+		t.Errorf("Expected the time column kept as time.Time, got %#v", got[0]["time"])
+	}
+	if got[0]["count"] != int64(1<<60) || got[0]["gap"] != nil {
+		t.Errorf("Expected column types kept, got %#v", got[0])
+	}
+	if fmt.Sprint(got[0]["meta"]) != "map[fw:1.2]" || fmt.Sprint(got[1]["tags"]) != "[x 1]" {
+		t.Errorf("Expected nested values kept, got %v, %v", got[0]["meta"], got[1]["tags"])
+	}
+	if len(storage.rows["events"]) != 1 {
+		t.Errorf("Expected 1 event, got %v", storage.rows["events"])
+	}
+}
+
+func TestForwardErrors(t *testing.T) {
+	storage := &memStorage{}
+	r := startReceiver(t, storage)
+	ctx := context.Background()
+	row := map[string]interface{}{"v": 1.0}
+
+	bad, _ := NewClient(ClientConfig{URL: "http://" + r.Addr(), Token: "wrong"})
+	if err := bad.InsertIntoTable(ctx, "t", row); !errors.Is(err, ErrUnauthorized) || errors.Is(err, errs.ErrStorageUnavailable) {
+		t.Errorf("Expected a wrong token to be a permanent error, got %v", err)
+	}
+	if err := bad.Ping(ctx); !errors.Is(err, ErrUnauthorized) {
+		t.Errorf("Expected Ping with a wrong token to fail, got %v", err)
+	}
+
+	c, _ := NewClient(ClientConfig{URL: "http://" + r.Addr(), Token: "secret"})
+	storage.err = fmt.Errorf("%w: database down", errs.ErrStorageUnavailable)
+	if err := c.InsertIntoTable(ctx, "t", row); !errors.Is(err, errs.ErrStorageUnavailable) {
+		t.Errorf("Expected an unavailable database to be retryable, got %v", err)
+	}
+	storage.err = fmt.Errorf("%w: column \"x\" does not exist", errs.ErrSchemaDrift)
+	if err := c.InsertIntoTable(ctx, "t", row); !errors.Is(err, errs.ErrSchemaDrift) {
+		t.Errorf("Expected schema drift passed on, got %v", err)
+	}
+	storage.err = errors.New("value out of range")
+	if err := c.InsertIntoTable(ctx, "t", row); err == nil || errors.Is(err, errs.ErrStorageUnavailable) {
+		t.Errorf("Expected a rejected record not to be retryable, got %v", err)
+	}
+
+	r.Close()
+	if err := c.InsertIntoTable(ctx, "t", row); !errors.Is(err, errs.ErrStorageUnavailable) {
+		t.Errorf("Expected an unreachable receiver to be retryable, got %v", err)
+	}
+}
+
+func TestForwardBodyLimit(t *testing.T) {
+	r, err := NewReceiver(ReceiverConfig{Address: "127.0.0.1:0", Token: "secret", Storage: &memStorage{}, Logger: logger.New(logger.ERROR)})
+	if err != nil {
+		t.Fatalf("NewReceiver() error = %v", err)
+	}
+	r.maxBody = 1 << 10
+	if err := r.Start(); err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	defer r.Close()
+
+	c, _ := NewClient(ClientConfig{URL: "http://" + r.Addr(), Token: "secret"})
+	ctx := context.Background()
+	if err := c.InsertIntoTable(ctx, "t", map[string]interface{}{"v": 1.0}); err != nil {
+		t.Fatalf("Expected a small batch accepted, got %v", err)
+	}
+	// Random bytes don't compress, so the request body exceeds the limit
+	noise := make([]byte, 4<<10)
+	rand.Read(noise)
+	err = c.InsertIntoTable(ctx, "t", map[string]interface{}{"v": noise})
+	if err == nil || !strings.Contains(err.Error(), "413") || errors.Is(err, errs.ErrStorageUnavailable) {
+		t.Errorf("Expected an oversized batch rejected for good, got %v", err)
+	}
+}
+
+// rowStorage can only write one row at a time
+type rowStorage struct{}
+
+func (rowStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	return nil
+}
+
+func TestForwardConfigErrors(t *testing.T) {
+	for _, u := range []string{"", "central:7400", "ftp://central"} {
+		if _, err := NewClient(ClientConfig{URL: u}); err == nil {
+			t.Errorf("NewClient(%q) expected error", u)
+		}
+	}
+	storage := &memStorage{}
+	for _, cfg := range []ReceiverConfig{
+		{Token: "t", Storage: storage},
+		{Address: ":0", Storage: storage},
+		{Address: ":0", Token: "t"},
+		{Address: ":0", Token: "t", Storage: storage, TLSCert: "cert.pem"},
+		{Address: ":0", Token: "t", Storage: rowStorage{}},
+	} {
+		if _, err := NewReceiver(cfg); err == nil {
+			t.Errorf("NewReceiver(%+v) expected error", cfg)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package gap
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/sink"
+)
+
+// DefaultTable is the table gap events are stored in when storage is set
+const DefaultTable = "hermod_gaps"
+
+// defaultCheckInterval is how often devices are checked for gaps
+const defaultCheckInterval = 10 * time.Second
+
+// validTable ensures the gap table name is safe for SQL
+var validTable = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// CreateTableSQL returns the DDL for a gap event table, with IF NOT EXISTS
+// when ifNotExists is set
+func CreateTableSQL(table string, ifNotExists bool) string {
+	create := "CREATE TABLE"
+	if ifNotExists {
+		create += " IF NOT EXISTS"
+	}
+	return fmt.Sprintf(`%s %s (
+  time timestamptz NOT NULL,
+  event text NOT NULL,
+  route text NOT NULL,
+  device_id text NOT NULL,
+  last_seen timestamptz NOT NULL,
+  silent_seconds double precision NOT NULL,
+  expected_seconds double precision NOT NULL
+);`, create, table)
+}
+
+// Event kinds
+const (
+	KindGap     = "gap"     // A device went silent for longer than its route expects
+	KindResumed = "resumed" // A silent device reported again
+)
+
+// Event reports a device going silent or reporting again
+type Event struct {
+	Kind     string        // KindGap or KindResumed
+	Route    string        // Filter of the route the device reports on
+	Device   string        // Device ID
+	LastSeen time.Time     // Last message before the gap
+	Silent   time.Duration // How long the device was silent (so far, for KindGap)
+	Expected time.Duration // The route's gap_after
+	Time     time.Time     // When the gap was detected or ended
+}
+
+// Row returns the event as a row of the gap table
+func (e Event) Row() map[string]interface{} {
+	return map[string]interface{}{
+		"time":             e.Time,
+		"event":            e.Kind,
+		"route":            e.Route,
+		"device_id":        e.Device,
+		"last_seen":        e.LastSeen,
+		"silent_seconds":   e.Silent.Seconds(),
+		"expected_seconds": e.Expected.Seconds(),
+	}
+}
+
+// Config controls gap detection
+type Config struct {
+	CheckInterval time.Duration // How often devices are checked (default: 10s)
+	Storage       sink.Storage  // Stores every event in Table (nil = not stored)
+	Table         string        // Table events are stored in (default: DefaultTable)
+	OnEvent       func(Event)   // Called for every event (optional)
+	Logger        *logger.Logger
+}
+
+// key identifies a device on a route
+type key struct {
+	route, device string
+}
+
+// entry tracks when a device last reported on a route
+type entry struct {
+	expected time.Duration
+	lastSeen time.Time
+	silent   bool // A gap event was emitted and the device hasn't reported since
+}
+
+// Detector tracks when each device last reported on its route and emits
+// an event when one stays silent for longer than the route's gap_after,
+// and another once it reports again. Devices are tracked from their first
+// message after startup.
+type Detector struct {
+	cfg     Config
+	logger  *logger.Logger
+	now     func() time.Time
+	mu      sync.Mutex
+	devices map[key]*entry
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// New creates a gap detector
+func New(cfg Config) (*Detector, error) {
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = defaultCheckInterval
+	}
+	if cfg.Table == "" {
+		cfg.Table = DefaultTable
+	}
+	if !validTable.MatchString(cfg.Table) {
+		return nil, fmt.Errorf("invalid gap table name %q", cfg.Table)
+	}
+	log := cfg.Logger
+	if log == nil {
+		log = logger.New(logger.INFO)
+	}
+	return &Detector{
+		cfg:     cfg,
+		logger:  log,
+		now:     time.Now,
+		devices: make(map[key]*entry),
+	}, nil
+}
+
+// Observe records a message from device on route, which expects one at
+// least every expected. A device that was silent reports a resumed event.
+func (d *Detector) Observe(route, device string, expected time.Duration, at time.Time) {
+	d.mu.Lock()
+	e, ok := d.devices[key{route, device}]
+	if !ok {
+		e = &entry{lastSeen: at}
+		d.devices[key{route, device}] = e
+	}
+	e.expected = expected
+	var resumed *Event
+	if e.silent {
+		e.silent = false
+		resumed = &Event{Kind: KindResumed, Route: route, Device: device, LastSeen: e.lastSeen,
+			Silent: at.Sub(e.lastSeen), Expected: expected, Time: at}
+	}
+	if at.After(e.lastSeen) {
+		e.lastSeen = at
+	}
+	d.mu.Unlock()
+
+	if resumed != nil {
+		d.logger.Infof("Device %s on route %s reported again after %s", device, route, resumed.Silent.Round(time.Second))
+		d.emit(*resumed)
+	}
+}
+
+// Check emits a gap event for every device silent for longer than its
+// route expects; each gap is reported once
+func (d *Detector) Check() {
+	now := d.now()
+	var events []Event
+	d.mu.Lock()
+	for k, e := range d.devices {
+		if e.silent || now.Sub(e.lastSeen) <= e.expected {
+			continue
+		}
+		e.silent = true
+		events = append(events, Event{Kind: KindGap, Route: k.route, Device: k.device, LastSeen: e.lastSeen,
+			Silent: now.Sub(e.lastSeen), Expected: e.expected, Time: now})
+	}
+	d.mu.Unlock()
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Route != events[j].Route {
+			return events[i].Route < events[j].Route
+		}
+		return events[i].Device < events[j].Device
+	})
+	for _, ev := range events {
+		d.logger.Warnf("Device %s on route %s silent for %s (expected every %s)",
+			ev.Device, ev.Route, ev.Silent.Round(time.Second), ev.Expected)
+		d.emit(ev)
+	}
+}
+
+// emit stores ev and passes it to OnEvent
+func (d *Detector) emit(ev Event) {
+	if d.cfg.Storage != nil {
+		if err := d.cfg.Storage.InsertIntoTable(context.Background(), d.cfg.Table, ev.Row()); err != nil {
+			d.logger.Errorf("Failed to store %s event for device %s: %v", ev.Kind, ev.Device, err)
+		}
+	}
+	if d.cfg.OnEvent != nil {
+		d.cfg.OnEvent(ev)
+	}
+}
+
+// Start begins periodic gap checks
+func (d *Detector) Start(ctx context.Context) {
+	ctx, d.cancel = context.WithCancel(ctx)
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		ticker := time.NewTicker(d.cfg.CheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				d.Check()
+			}
+		}
+	}()
+}
+
+// Close stops the periodic checks
+func (d *Detector) Close() {
+	if d.cancel != nil {
+		d.cancel()
+	}
+	d.wg.Wait()
+}
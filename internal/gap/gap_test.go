@@ -0,0 +1,96 @@
+package gap
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// mockStorage records inserted rows
+type mockStorage struct {
+	rows []map[string]interface{}
+}
+
+func (m *mockStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	data["_table"] = table
+	m.rows = append(m.rows, data)
+	return nil
+}
+
+func TestDetector(t *testing.T) {
+	storage := &mockStorage{}
+	var events []Event
+	d, err := New(Config{Storage: storage, OnEvent: func(e Event) { events = append(events, e) }, Logger: logger.New(logger.ERROR)})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	start := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := start
+	d.now = func() time.Time { return now }
+
+	d.Observe("ruuvi/+", "a", time.Minute, start)
+	d.Observe("ruuvi/+", "b", time.Minute, start)
+
+	// Within the expected interval nothing is reported
+	now = start.Add(50 * time.Second)
+	d.Observe("ruuvi/+", "b", time.Minute, now)
+	d.Check()
+	if len(events) != 0 {
+		t.Fatalf("Expected no events yet, got %v", events)
+	}
+
+	// a goes silent; the gap is reported once
+	now = start.Add(90 * time.Second)
+	d.Check()
+	d.Check()
+	if len(events) != 1 || events[0].Kind != KindGap || events[0].Device != "a" || events[0].Silent != 90*time.Second {
+		t.Fatalf("Expected a single gap event for a, got %+v", events)
+	}
+
+	// b goes silent too, then a reports again
+	now = start.Add(5 * time.Minute)
+	d.Check()
+	d.Observe("ruuvi/+", "a", time.Minute, now)
+	if len(events) != 3 {
+		t.Fatalf("Expected a gap for b and a resumed event for a, got %+v", events)
+	}
+	if ev := events[1]; ev.Kind != KindGap || ev.Device != "b" {
+		t.Errorf("Unexpected gap event: %+v", ev)
+	}
+	if ev := events[2]; ev.Kind != KindResumed || ev.Device != "a" || ev.Silent != 5*time.Minute || !ev.LastSeen.Equal(start) {
+		t.Errorf("Unexpected resumed event: %+v", ev)
+	}
+
+	if len(storage.rows) != 3 {
+		t.Fatalf("Expected every event stored, got %d rows", len(storage.rows))
+	}
+	row := storage.rows[0]
+	if row["_table"] != DefaultTable || row["event"] != KindGap || row["route"] != "ruuvi/+" || row["expected_seconds"] != 60.0 {
+		t.Errorf("Unexpected gap row: %v", row)
+	}
+}
+
+func TestDetectorPerRoute(t *testing.T) {
+	var events []Event
+	d, _ := New(Config{OnEvent: func(e Event) { events = append(events, e) }, Logger: logger.New(logger.ERROR)})
+	start := time.Now()
+	now := start
+	d.now = func() time.Time { return now }
+
+	// The same device is tracked separately on each route
+	d.Observe("fast/+", "a", time.Minute, start)
+	d.Observe("slow/+", "a", time.Hour, start)
+	now = start.Add(10 * time.Minute)
+	d.Check()
+	if len(events) != 1 || events[0].Route != "fast/+" {
+		t.Errorf("Expected only the fast route to report a gap, got %+v", events)
+	}
+}
+
+func TestNewValidation(t *testing.T) {
+	if _, err := New(Config{Table: "gaps; DROP TABLE x"}); err == nil {
+		t.Error("Expected an invalid table name rejected")
+	}
+}
@@ -0,0 +1,179 @@
+// Package golden records what routes make of sample payloads into golden
+// JSON files and verifies later runs against them, so edits to shared Lua
+// decoders that change their output are caught.
+package golden
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/router"
+)
+
+// Suffix names the golden file of a sample: <sample><Suffix>
+const Suffix = ".golden.json"
+
+// Time is the arrival time of every sample message, so msg.ts is stable
+var Time = time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Transform runs a message through the routes without storing it and
+// returns the handling route's filter and its records (router.TransformSample)
+type Transform func(router.Message) (string, []router.Record, error)
+
+// Result is the content of a golden file
+type Result struct {
+	Topic   string   `json:"topic"`
+	Route   string   `json:"route"` // Empty when no route handles the topic
+	Records []Record `json:"records"`
+	Error   string   `json:"error,omitempty"` // Decode, schema or transform error
+}
+
+// Record is a record a sample produced
+type Record struct {
+	Table   string                 `json:"table"`
+	Sink    string                 `json:"sink,omitempty"`
+	Columns map[string]interface{} `json:"columns"`
+}
+
+// Sample outcomes
+const (
+	Passed  = "ok"       // Output matches the golden file
+	Updated = "updated"  // Golden file written
+	Failed  = "mismatch" // Output differs from the golden file
+	Missing = "missing"  // No golden file yet
+)
+
+// Outcome is the result of running one sample
+type Outcome struct {
+	Sample string // Sample path
+	Status string // Passed, Updated, Failed or Missing
+	Want   string // Golden file content (Failed only)
+	Got    string // Current output (Failed and Missing)
+}
+
+// Run runs every sample under dir through transform. A sample is any file
+// other than a golden file; its topic is its directory relative to dir
+// (samples/ruuvi/gw1/low_battery.json is published to "ruuvi/gw1") and its
+// content is the payload. With update set the golden files are written,
+// otherwise the output is compared with them.
+func Run(dir string, update bool, transform Transform) ([]Outcome, error) {
+	samples, err := findSamples(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return nil, fmt.Errorf("no samples found in %s", dir)
+	}
+
+	outcomes := make([]Outcome, 0, len(samples))
+	for _, path := range samples {
+		o, err := runSample(dir, path, update, transform)
+		if err != nil {
+			return outcomes, err
+		}
+		outcomes = append(outcomes, o)
+	}
+	return outcomes, nil
+}
+
+// findSamples lists the sample files under dir in lexical order, skipping
+// golden files and hidden files and directories
+func findSamples(dir string) ([]string, error) {
+	var samples []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != dir && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || strings.HasSuffix(path, Suffix) {
+			return nil
+		}
+		samples = append(samples, path)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read samples: %w", err)
+	}
+	sort.Strings(samples)
+	return samples, nil
+}
+
+// runSample runs one sample and writes or checks its golden file
+func runSample(dir, path string, update bool, transform Transform) (Outcome, error) {
+	rel, err := filepath.Rel(dir, path)
+	if err != nil {
+		return Outcome{}, err
+	}
+	topic := filepath.ToSlash(filepath.Dir(rel))
+	if topic == "." {
+		return Outcome{}, fmt.Errorf("sample %s must be in a directory named after its topic", path)
+	}
+	payload, err := os.ReadFile(path)
+	if err != nil {
+		return Outcome{}, fmt.Errorf("failed to read sample: %w", err)
+	}
+
+	result := Result{Topic: topic, Records: []Record{}}
+	route, records, err := transform(router.Message{Topic: topic, Payload: payload, Time: Time})
+	result.Route = route
+	if err != nil {
+		result.Error = err.Error()
+	}
+	for _, rec := range records {
+		result.Records = append(result.Records, Record{Table: rec.Table, Sink: rec.Sink, Columns: rec.Columns})
+	}
+	got, err := json.MarshalIndent(result, "", "  ")
+	if err != nil {
+		return Outcome{}, fmt.Errorf("sample %s: failed to encode output: %w", path, err)
+	}
+	got = append(got, '\n')
+
+	goldenPath := path + Suffix
+	if update {
+		if err := os.WriteFile(goldenPath, got, 0644); err != nil {
+			return Outcome{}, fmt.Errorf("failed to write golden file: %w", err)
+		}
+		return Outcome{Sample: path, Status: Updated}, nil
+	}
+
+	want, err := os.ReadFile(goldenPath)
+	if errors.Is(err, fs.ErrNotExist) {
+		return Outcome{Sample: path, Status: Missing, Got: string(got)}, nil
+	}
+	if err != nil {
+		return Outcome{}, fmt.Errorf("failed to read golden file: %w", err)
+	}
+	equal, err := sameJSON(want, got)
+	if err != nil {
+		return Outcome{}, fmt.Errorf("invalid golden file %s: %w", goldenPath, err)
+	}
+	if !equal {
+		return Outcome{Sample: path, Status: Failed, Want: string(want), Got: string(got)}, nil
+	}
+	return Outcome{Sample: path, Status: Passed}, nil
+}
+
+// sameJSON compares two JSON documents, ignoring formatting and key order
+func sameJSON(a, b []byte) (bool, error) {
+	var va, vb interface{}
+	if err := json.Unmarshal(a, &va); err != nil {
+		return false, err
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		return false, err
+	}
+	return reflect.DeepEqual(va, vb), nil
+}
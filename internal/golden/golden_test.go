@@ -0,0 +1,88 @@
+package golden
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/marcgeld/hermod/internal/router"
+)
+
+// scale stands in for a route, storing the payload length times factor
+func scale(factor float64) Transform {
+	return func(msg router.Message) (string, []router.Record, error) {
+		if string(msg.Payload) == "bad" {
+			return "sensors/+", nil, errors.New("transform failed")
+		}
+		return "sensors/+", []router.Record{{
+			Table:   "readings",
+			Columns: map[string]interface{}{"time": msg.Time.Format("2006-01-02"), "value": float64(len(msg.Payload)) * factor},
+		}}, nil
+	}
+}
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	for path, content := range map[string]string{
+		"sensors/a/one.json":  "12345",
+		"sensors/a/bad.json":  "bad",
+		".git/config":         "ignored",
+		"sensors/.hidden.txt": "ignored",
+	} {
+		full := filepath.Join(dir, path)
+		os.MkdirAll(filepath.Dir(full), 0755)
+		if err := os.WriteFile(full, []byte(content), 0644); err != nil {
+			t.Fatalf("failed to write sample: %v", err)
+		}
+	}
+
+	// Without golden files every sample is missing
+	outcomes, err := Run(dir, false, scale(2))
+	if err != nil {
+		t.Fatalf("Run failed: %v", err)
+	}
+	if len(outcomes) != 2 || outcomes[0].Status != Missing || outcomes[1].Status != Missing {
+		t.Fatalf("outcomes = %+v, want two missing", outcomes)
+	}
+
+	if _, err := Run(dir, true, scale(2)); err != nil {
+		t.Fatalf("Run(update) failed: %v", err)
+	}
+	golden, err := os.ReadFile(filepath.Join(dir, "sensors/a/one.json"+Suffix))
+	if err != nil {
+		t.Fatalf("golden file not written: %v", err)
+	}
+	for _, want := range []string{`"topic": "sensors/a"`, `"route": "sensors/+"`, `"value": 10`, `"time": "2024-01-01"`} {
+		if !strings.Contains(string(golden), want) {
+			t.Errorf("golden file lacks %s:\n%s", want, golden)
+		}
+	}
+	if bad, _ := os.ReadFile(filepath.Join(dir, "sensors/a/bad.json"+Suffix)); !strings.Contains(string(bad), `"error": "transform failed"`) {
+		t.Errorf("golden file of a failing sample lacks the error:\n%s", bad)
+	}
+
+	// Formatting differences don't matter, output changes do
+	compact := strings.Join(strings.Fields(string(golden)), "")
+	os.WriteFile(filepath.Join(dir, "sensors/a/one.json"+Suffix), []byte(compact), 0644)
+	outcomes, err = Run(dir, false, scale(2))
+	if err != nil || outcomes[0].Status != Passed || outcomes[1].Status != Passed {
+		t.Fatalf("outcomes = %+v, %v; want all passed", outcomes, err)
+	}
+	outcomes, err = Run(dir, false, scale(3))
+	if err != nil || outcomes[1].Status != Failed || !strings.Contains(outcomes[1].Got, `"value": 15`) {
+		t.Errorf("outcomes = %+v, %v; want one.json to fail", outcomes, err)
+	}
+}
+
+func TestRunErrors(t *testing.T) {
+	dir := t.TempDir()
+	if _, err := Run(dir, false, scale(1)); err == nil {
+		t.Error("Expected error for an empty directory")
+	}
+	os.WriteFile(filepath.Join(dir, "top.json"), []byte("{}"), 0644)
+	if _, err := Run(dir, false, scale(1)); err == nil {
+		t.Error("Expected error for a sample without a topic directory")
+	}
+}
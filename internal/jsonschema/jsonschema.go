@@ -0,0 +1,607 @@
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Schema is a compiled JSON Schema. It supports the validation keywords
+// payload schemas commonly use (type, enum, const, properties, required,
+// additionalProperties, items, numeric and length bounds, pattern, allOf,
+// anyOf, oneOf, not and local $ref); any other validation keyword is
+// rejected when the schema is compiled rather than silently ignored.
+// A Schema is safe for concurrent use.
+type Schema struct {
+	always *bool // Boolean schema: true accepts and false rejects everything
+
+	types    []string
+	enum     []interface{}
+	constVal *interface{}
+
+	properties           map[string]*Schema
+	required             []string
+	additionalProperties *Schema
+	minProperties        *int
+	maxProperties        *int
+
+	items    *Schema
+	minItems *int
+	maxItems *int
+
+	minimum          *float64
+	maximum          *float64
+	exclusiveMinimum *float64
+	exclusiveMaximum *float64
+	multipleOf       *float64
+
+	minLength *int
+	maxLength *int
+	pattern   *regexp.Regexp
+
+	allOf []*Schema
+	anyOf []*Schema
+	oneOf []*Schema
+	not   *Schema
+
+	ref *Schema // Target of $ref, applied in addition to sibling keywords
+}
+
+// annotations are keywords that don't affect validation
+var annotations = map[string]bool{
+	"$schema": true, "$id": true, "id": true, "$comment": true, "$defs": true, "definitions": true,
+	"title": true, "description": true, "default": true, "examples": true, "format": true,
+	"readOnly": true, "writeOnly": true, "deprecated": true,
+}
+
+// validTypes are the JSON Schema type names
+var validTypes = map[string]bool{
+	"null": true, "boolean": true, "object": true, "array": true, "number": true, "integer": true, "string": true,
+}
+
+// Load reads and compiles a schema file
+func Load(path string) (*Schema, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read JSON schema: %w", err)
+	}
+	s, err := Compile(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid JSON schema %s: %w", path, err)
+	}
+	return s, nil
+}
+
+// Compile parses and compiles a schema document
+func Compile(data []byte) (*Schema, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var root interface{}
+	if err := dec.Decode(&root); err != nil {
+		return nil, fmt.Errorf("failed to parse schema: %w", err)
+	}
+	c := &compiler{root: root, refs: make(map[string]*Schema)}
+	return c.compile(root, "#")
+}
+
+// compiler resolves local references while compiling a document
+type compiler struct {
+	root interface{}
+	refs map[string]*Schema // Compiled $ref targets by pointer (also breaks cycles)
+}
+
+// compile builds the schema for node, found at location (for errors)
+func (c *compiler) compile(node interface{}, location string) (*Schema, error) {
+	if b, ok := node.(bool); ok {
+		return &Schema{always: &b}, nil
+	}
+	obj, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object or boolean", location)
+	}
+
+	s := &Schema{}
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := c.keyword(s, key, obj[key], location+"/"+key); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// keyword compiles one keyword of a schema object into s
+func (c *compiler) keyword(s *Schema, key string, v interface{}, location string) error {
+	var err error
+	switch key {
+	case "type":
+		s.types, err = typeNames(v, location)
+	case "enum":
+		list, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be an array", location)
+		}
+		s.enum = list
+	case "const":
+		s.constVal = &v
+	case "properties":
+		props, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be an object", location)
+		}
+		s.properties = make(map[string]*Schema, len(props))
+		for name, sub := range props {
+			if s.properties[name], err = c.compile(sub, location+"/"+escape(name)); err != nil {
+				return err
+			}
+		}
+	case "required":
+		list, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("%s: must be an array of strings", location)
+		}
+		for _, item := range list {
+			name, ok := item.(string)
+			if !ok {
+				return fmt.Errorf("%s: must be an array of strings", location)
+			}
+			s.required = append(s.required, name)
+		}
+	case "additionalProperties":
+		s.additionalProperties, err = c.compile(v, location)
+	case "minProperties":
+		s.minProperties, err = count(v, location)
+	case "maxProperties":
+		s.maxProperties, err = count(v, location)
+	case "items":
+		s.items, err = c.compile(v, location)
+	case "minItems":
+		s.minItems, err = count(v, location)
+	case "maxItems":
+		s.maxItems, err = count(v, location)
+	case "minimum":
+		s.minimum, err = number(v, location)
+	case "maximum":
+		s.maximum, err = number(v, location)
+	case "exclusiveMinimum":
+		s.exclusiveMinimum, err = number(v, location)
+	case "exclusiveMaximum":
+		s.exclusiveMaximum, err = number(v, location)
+	case "multipleOf":
+		if s.multipleOf, err = number(v, location); err == nil && *s.multipleOf <= 0 {
+			err = fmt.Errorf("%s: must be greater than 0", location)
+		}
+	case "minLength":
+		s.minLength, err = count(v, location)
+	case "maxLength":
+		s.maxLength, err = count(v, location)
+	case "pattern":
+		expr, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: must be a string", location)
+		}
+		if s.pattern, err = regexp.Compile(expr); err != nil {
+			return fmt.Errorf("%s: %w", location, err)
+		}
+	case "allOf":
+		s.allOf, err = c.compileList(v, location)
+	case "anyOf":
+		s.anyOf, err = c.compileList(v, location)
+	case "oneOf":
+		s.oneOf, err = c.compileList(v, location)
+	case "not":
+		s.not, err = c.compile(v, location)
+	case "$ref":
+		ref, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("%s: must be a string", location)
+		}
+		s.ref, err = c.resolve(ref, location)
+	default:
+		if !annotations[key] {
+			return fmt.Errorf("%s: unsupported keyword", location)
+		}
+	}
+	return err
+}
+
+// compileList compiles an array of schemas
+func (c *compiler) compileList(v interface{}, location string) ([]*Schema, error) {
+	list, ok := v.([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("%s: must be a non-empty array of schemas", location)
+	}
+	schemas := make([]*Schema, len(list))
+	for i, item := range list {
+		sub, err := c.compile(item, location+"/"+strconv.Itoa(i))
+		if err != nil {
+			return nil, err
+		}
+		schemas[i] = sub
+	}
+	return schemas, nil
+}
+
+// resolve compiles the target of a local reference ("#" or "#/json/pointer")
+func (c *compiler) resolve(ref, location string) (*Schema, error) {
+	if s, ok := c.refs[ref]; ok {
+		return s, nil
+	}
+	if ref != "#" && !strings.HasPrefix(ref, "#/") {
+		return nil, fmt.Errorf("%s: only local references (#/...) are supported, got %q", location, ref)
+	}
+	node := c.root
+	if ref != "#" {
+		for _, token := range strings.Split(ref[2:], "/") {
+			token = strings.ReplaceAll(strings.ReplaceAll(token, "~1", "/"), "~0", "~")
+			switch n := node.(type) {
+			case map[string]interface{}:
+				next, ok := n[token]
+				if !ok {
+					return nil, fmt.Errorf("%s: unresolved reference %q", location, ref)
+				}
+				node = next
+			case []interface{}:
+				i, err := strconv.Atoi(token)
+				if err != nil || i < 0 || i >= len(n) {
+					return nil, fmt.Errorf("%s: unresolved reference %q", location, ref)
+				}
+				node = n[i]
+			default:
+				return nil, fmt.Errorf("%s: unresolved reference %q", location, ref)
+			}
+		}
+	}
+
+	// Register a placeholder first so recursive references terminate
+	s := &Schema{}
+	c.refs[ref] = s
+	compiled, err := c.compile(node, ref)
+	if err != nil {
+		return nil, err
+	}
+	*s = *compiled
+	return s, nil
+}
+
+// typeNames reads "type" as a name or a list of names
+func typeNames(v interface{}, location string) ([]string, error) {
+	var names []string
+	switch t := v.(type) {
+	case string:
+		names = []string{t}
+	case []interface{}:
+		for _, item := range t {
+			name, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: must be a string or an array of strings", location)
+			}
+			names = append(names, name)
+		}
+	default:
+		return nil, fmt.Errorf("%s: must be a string or an array of strings", location)
+	}
+	for _, name := range names {
+		if !validTypes[name] {
+			return nil, fmt.Errorf("%s: unknown type %q", location, name)
+		}
+	}
+	return names, nil
+}
+
+// number reads a numeric keyword value
+func number(v interface{}, location string) (*float64, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("%s: must be a number", location)
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", location, err)
+	}
+	return &f, nil
+}
+
+// count reads a non-negative integer keyword value
+func count(v interface{}, location string) (*int, error) {
+	n, ok := v.(json.Number)
+	if !ok {
+		return nil, fmt.Errorf("%s: must be a non-negative integer", location)
+	}
+	i, err := strconv.Atoi(n.String())
+	if err != nil || i < 0 {
+		return nil, fmt.Errorf("%s: must be a non-negative integer", location)
+	}
+	return &i, nil
+}
+
+// escape encodes a property name as a JSON pointer token
+func escape(name string) string {
+	return strings.ReplaceAll(strings.ReplaceAll(name, "~", "~0"), "/", "~1")
+}
+
+// ValidationError describes the first part of a value that violates a schema
+type ValidationError struct {
+	Path    string // JSON pointer to the offending value ("" = the whole document)
+	Message string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+	return e.Path + ": " + e.Message
+}
+
+// Validate checks a decoded JSON value (as produced by encoding/json, with
+// numbers as float64, int64 or json.Number) and returns a *ValidationError
+// for the first violation found
+func (s *Schema) Validate(v interface{}) error {
+	return s.validate(v, "")
+}
+
+func (s *Schema) validate(v interface{}, path string) error {
+	fail := func(format string, args ...interface{}) error {
+		return &ValidationError{Path: path, Message: fmt.Sprintf(format, args...)}
+	}
+	if s.always != nil {
+		if !*s.always {
+			return fail("no value is allowed here")
+		}
+		return nil
+	}
+	if s.ref != nil {
+		if err := s.ref.validate(v, path); err != nil {
+			return err
+		}
+	}
+
+	if len(s.types) > 0 && !s.matchesType(v) {
+		return fail("expected %s, got %s", strings.Join(s.types, " or "), typeOf(v))
+	}
+	if s.enum != nil {
+		found := false
+		for _, allowed := range s.enum {
+			if equal(v, allowed) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fail("value is not one of the allowed values")
+		}
+	}
+	if s.constVal != nil && !equal(v, *s.constVal) {
+		return fail("value must be %v", *s.constVal)
+	}
+
+	switch val := v.(type) {
+	case map[string]interface{}:
+		if err := s.validateObject(val, path, fail); err != nil {
+			return err
+		}
+	case []interface{}:
+		if s.minItems != nil && len(val) < *s.minItems {
+			return fail("expected at least %d items, got %d", *s.minItems, len(val))
+		}
+		if s.maxItems != nil && len(val) > *s.maxItems {
+			return fail("expected at most %d items, got %d", *s.maxItems, len(val))
+		}
+		if s.items != nil {
+			for i, item := range val {
+				if err := s.items.validate(item, path+"/"+strconv.Itoa(i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(val)
+		if s.minLength != nil && n < *s.minLength {
+			return fail("expected at least %d characters, got %d", *s.minLength, n)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			return fail("expected at most %d characters, got %d", *s.maxLength, n)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(val) {
+			return fail("does not match pattern %s", s.pattern)
+		}
+	default:
+		if f, ok := toFloat(v); ok {
+			if err := s.validateNumber(f, fail); err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, sub := range s.allOf {
+		if err := sub.validate(v, path); err != nil {
+			return err
+		}
+	}
+	if s.anyOf != nil {
+		var first error
+		for _, sub := range s.anyOf {
+			err := sub.validate(v, path)
+			if err == nil {
+				first = nil
+				break
+			}
+			if first == nil {
+				first = err
+			}
+		}
+		if first != nil {
+			return fail("matches none of anyOf (first: %v)", first)
+		}
+	}
+	if s.oneOf != nil {
+		matched := 0
+		for _, sub := range s.oneOf {
+			if sub.validate(v, path) == nil {
+				matched++
+			}
+		}
+		if matched != 1 {
+			return fail("must match exactly one of oneOf, matched %d", matched)
+		}
+	}
+	if s.not != nil && s.not.validate(v, path) == nil {
+		return fail("must not match the schema in not")
+	}
+	return nil
+}
+
+// validateObject applies the object keywords
+func (s *Schema) validateObject(obj map[string]interface{}, path string, fail func(string, ...interface{}) error) error {
+	if s.minProperties != nil && len(obj) < *s.minProperties {
+		return fail("expected at least %d properties, got %d", *s.minProperties, len(obj))
+	}
+	if s.maxProperties != nil && len(obj) > *s.maxProperties {
+		return fail("expected at most %d properties, got %d", *s.maxProperties, len(obj))
+	}
+	for _, name := range s.required {
+		if _, ok := obj[name]; !ok {
+			return fail("missing required property %q", name)
+		}
+	}
+
+	// Check properties in a stable order so the reported violation is deterministic
+	names := make([]string, 0, len(obj))
+	for name := range obj {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		sub, ok := s.properties[name]
+		if !ok {
+			sub = s.additionalProperties
+		}
+		if sub == nil {
+			continue
+		}
+		if err := sub.validate(obj[name], path+"/"+escape(name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateNumber applies the numeric keywords
+func (s *Schema) validateNumber(f float64, fail func(string, ...interface{}) error) error {
+	switch {
+	case s.minimum != nil && f < *s.minimum:
+		return fail("%v is less than the minimum %v", f, *s.minimum)
+	case s.maximum != nil && f > *s.maximum:
+		return fail("%v is greater than the maximum %v", f, *s.maximum)
+	case s.exclusiveMinimum != nil && f <= *s.exclusiveMinimum:
+		return fail("%v must be greater than %v", f, *s.exclusiveMinimum)
+	case s.exclusiveMaximum != nil && f >= *s.exclusiveMaximum:
+		return fail("%v must be less than %v", f, *s.exclusiveMaximum)
+	}
+	if s.multipleOf != nil {
+		q := f / *s.multipleOf
+		if math.Abs(q-math.Round(q)) > 1e-9 {
+			return fail("%v is not a multiple of %v", f, *s.multipleOf)
+		}
+	}
+	return nil
+}
+
+// matchesType reports whether v is one of the schema's types
+func (s *Schema) matchesType(v interface{}) bool {
+	actual := typeOf(v)
+	for _, t := range s.types {
+		if t == actual || (t == "number" && actual == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// typeOf returns the JSON Schema type of a decoded value; numbers with no
+// fractional part are integers
+func typeOf(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case int64, int:
+		return "integer"
+	}
+	if f, ok := toFloat(v); ok {
+		if f == math.Trunc(f) && !math.IsInf(f, 0) {
+			return "integer"
+		}
+		return "number"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// toFloat converts a decoded JSON number
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// equal compares decoded JSON values, treating equal numbers as equal
+// regardless of their Go type
+func equal(a, b interface{}) bool {
+	if fa, ok := toFloat(a); ok {
+		fb, ok := toFloat(b)
+		return ok && fa == fb
+	}
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, x := range av {
+			y, ok := bv[k]
+			if !ok || !equal(x, y) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !equal(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
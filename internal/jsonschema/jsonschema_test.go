@@ -0,0 +1,132 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const ruuviSchema = `{
+  "$schema": "https://json-schema.org/draft/2020-12/schema",
+  "title": "Ruuvi reading",
+  "type": "object",
+  "required": ["mac", "temperature"],
+  "properties": {
+    "mac": {"type": "string", "pattern": "^[0-9A-F]{12}$"},
+    "temperature": {"type": "number", "minimum": -40, "maximum": 85},
+    "battery": {"type": "integer", "exclusiveMinimum": 0},
+    "mode": {"enum": ["fast", "slow"]},
+    "tags": {"type": "array", "items": {"$ref": "#/$defs/tag"}, "maxItems": 2}
+  },
+  "additionalProperties": false,
+  "$defs": {
+    "tag": {"type": "string", "minLength": 1}
+  }
+}`
+
+func TestValidate(t *testing.T) {
+	s, err := Compile([]byte(ruuviSchema))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+
+	tests := []struct {
+		doc     string
+		wantErr string // Substring of the error ("" = valid)
+	}{
+		{`{"mac": "AABBCCDDEEFF", "temperature": 21.5}`, ""},
+		{`{"mac": "AABBCCDDEEFF", "temperature": 21, "battery": 3000, "mode": "fast", "tags": ["a"]}`, ""},
+		{`{"mac": "AABBCCDDEEFF"}`, `missing required property "temperature"`},
+		{`{"mac": "aa", "temperature": 1}`, "/mac: does not match pattern"},
+		{`{"mac": "AABBCCDDEEFF", "temperature": "21"}`, "/temperature: expected number, got string"},
+		{`{"mac": "AABBCCDDEEFF", "temperature": 90}`, "greater than the maximum"},
+		{`{"mac": "AABBCCDDEEFF", "temperature": 1, "battery": 2.5}`, "/battery: expected integer, got number"},
+		{`{"mac": "AABBCCDDEEFF", "temperature": 1, "battery": 0}`, "must be greater than 0"},
+		{`{"mac": "AABBCCDDEEFF", "temperature": 1, "mode": "off"}`, "/mode: value is not one of the allowed values"},
+		{`{"mac": "AABBCCDDEEFF", "temperature": 1, "tags": [""]}`, "/tags/0: expected at least 1 characters"},
+		{`{"mac": "AABBCCDDEEFF", "temperature": 1, "tags": ["a", "b", "c"]}`, "/tags: expected at most 2 items"},
+		{`{"mac": "AABBCCDDEEFF", "temperature": 1, "extra": true}`, "/extra: no value is allowed here"},
+		{`[1, 2]`, "expected object, got array"},
+	}
+	for _, tt := range tests {
+		var doc interface{}
+		if err := json.Unmarshal([]byte(tt.doc), &doc); err != nil {
+			t.Fatalf("invalid test document %s: %v", tt.doc, err)
+		}
+		err := s.Validate(doc)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("Validate(%s) = %v, want valid", tt.doc, err)
+			}
+			continue
+		}
+		var verr *ValidationError
+		if !errors.As(err, &verr) || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("Validate(%s) = %v, want error containing %q", tt.doc, err, tt.wantErr)
+		}
+	}
+
+	// Exact integers from the router's number decoding
+	if err := s.Validate(map[string]interface{}{"mac": "AABBCCDDEEFF", "temperature": int64(20), "battery": int64(1)}); err != nil {
+		t.Errorf("Validate with int64 values = %v, want valid", err)
+	}
+}
+
+func TestCombinators(t *testing.T) {
+	s, err := Compile([]byte(`{
+  "oneOf": [{"type": "integer"}, {"type": "string", "maxLength": 3}],
+  "not": {"const": 7},
+  "$comment": "either a code or a short name"
+}`))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	for doc, valid := range map[string]bool{`5`: true, `"abc"`: true, `"abcd"`: false, `7`: false, `1.5`: false, `null`: false} {
+		var v interface{}
+		json.Unmarshal([]byte(doc), &v)
+		if err := s.Validate(v); (err == nil) != valid {
+			t.Errorf("Validate(%s) = %v, want valid=%v", doc, err, valid)
+		}
+	}
+
+	// Recursive references terminate
+	tree, err := Compile([]byte(`{"type": "object", "properties": {"children": {"type": "array", "items": {"$ref": "#"}}}}`))
+	if err != nil {
+		t.Fatalf("Compile failed: %v", err)
+	}
+	var v interface{}
+	json.Unmarshal([]byte(`{"children": [{"children": [{"children": 1}]}]}`), &v)
+	if err := tree.Validate(v); err == nil || !strings.HasPrefix(err.Error(), "/children/0/children/0/children:") {
+		t.Errorf("Validate(tree) = %v, want error at the nested children", err)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, schema := range []string{
+		`{"patternProperties": {"^x": {}}}`,
+		`{"type": "float"}`,
+		`{"minimum": "1"}`,
+		`{"pattern": "("}`,
+		`{"$ref": "other.json#/a"}`,
+		`{"$ref": "#/$defs/missing"}`,
+		`{"anyOf": []}`,
+		`[]`,
+		`{`,
+	} {
+		if _, err := Compile([]byte(schema)); err == nil {
+			t.Errorf("Compile(%s) succeeded, want error", schema)
+		}
+	}
+
+	path := filepath.Join(t.TempDir(), "schema.json")
+	os.WriteFile(path, []byte(`{"type": "object"}`), 0644)
+	if _, err := Load(path); err != nil {
+		t.Errorf("Load failed: %v", err)
+	}
+	if _, err := Load(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("Expected error for a missing file")
+	}
+}
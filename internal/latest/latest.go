@@ -0,0 +1,135 @@
+package latest
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/sink"
+)
+
+// Config selects which column identifies a device per table
+type Config struct {
+	Key    string            // Default key column (e.g. "device")
+	Tables map[string]string // Per-table key column overrides
+}
+
+// Cache keeps the most recent record per table and key, updated as records are stored
+type Cache struct {
+	cfg  Config
+	next sink.Storage
+	mu   sync.RWMutex
+	rows map[string]map[string]entry // table -> key -> latest record
+}
+
+// entry is a cached record with its timestamp
+type entry struct {
+	at   time.Time
+	data map[string]interface{}
+}
+
+// New creates a latest-value cache in front of next
+func New(cfg Config, next sink.Storage) *Cache {
+	return &Cache{
+		cfg:  cfg,
+		next: next,
+		rows: make(map[string]map[string]entry),
+	}
+}
+
+// InsertIntoTable stores the record and caches it on success
+func (c *Cache) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	if err := c.next.InsertIntoTable(ctx, table, data); err != nil {
+		return err
+	}
+	c.store(table, data)
+	return nil
+}
+
+// InsertBatch stores the rows and caches them on success
+func (c *Cache) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error {
+	bs, ok := c.next.(sink.BatchStorage)
+	if !ok {
+		return sink.InsertEach(ctx, c, table, rows)
+	}
+
+	if err := bs.InsertBatch(ctx, table, rows); err != nil {
+		return err
+	}
+	for _, data := range rows {
+		c.store(table, data)
+	}
+	return nil
+}
+
+// store caches a stored record unless a newer one is already cached
+func (c *Cache) store(table string, data map[string]interface{}) {
+	col := c.keyColumn(table)
+	if col == "" {
+		return
+	}
+	val, ok := data[col]
+	if !ok || val == nil {
+		return
+	}
+	key := fmt.Sprintf("%v", val)
+	at := sink.RecordTime(data, time.Now())
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	byKey, ok := c.rows[table]
+	if !ok {
+		byKey = make(map[string]entry)
+		c.rows[table] = byKey
+	}
+	// Late (out-of-order) records don't replace newer ones
+	if cur, ok := byKey[key]; ok && at.Before(cur.at) {
+		return
+	}
+	copied := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		copied[k] = v
+	}
+	byKey[key] = entry{at: at, data: copied}
+}
+
+// Get returns the latest record for a key in table
+func (c *Cache) Get(table, key string) (map[string]interface{}, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	e, ok := c.rows[table][key]
+	return e.data, ok
+}
+
+// All returns the latest record of every key in table
+func (c *Cache) All(table string) map[string]map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	out := make(map[string]map[string]interface{}, len(c.rows[table]))
+	for k, e := range c.rows[table] {
+		out[k] = e.data
+	}
+	return out
+}
+
+// Tables returns the cached table names, sorted
+func (c *Cache) Tables() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := make([]string, 0, len(c.rows))
+	for t := range c.rows {
+		names = append(names, t)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// keyColumn returns the key column for a table ("" = not cached)
+func (c *Cache) keyColumn(table string) string {
+	if col, ok := c.cfg.Tables[table]; ok {
+		return col
+	}
+	return c.cfg.Key
+}
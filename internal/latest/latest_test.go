@@ -0,0 +1,67 @@
+package latest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// mockStorage optionally fails inserts
+type mockStorage struct {
+	fail bool
+}
+
+func (m *mockStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	if m.fail {
+		return errors.New("db down")
+	}
+	return nil
+}
+
+func TestCacheKeepsLatestPerKey(t *testing.T) {
+	next := &mockStorage{}
+	c := New(Config{Key: "device", Tables: map[string]string{"meters": "meter_id", "raw": ""}}, next)
+	ctx := context.Background()
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	inserts := []struct {
+		table string
+		data  map[string]interface{}
+	}{
+		{"readings", map[string]interface{}{"time": base, "device": "a", "v": 1.0}},
+		{"readings", map[string]interface{}{"time": base.Add(time.Minute), "device": "a", "v": 2.0}},
+		{"readings", map[string]interface{}{"time": base.Add(30 * time.Second), "device": "a", "v": 1.5}}, // late
+		{"readings", map[string]interface{}{"time": base.Format(time.RFC3339Nano), "device": "b", "v": 9.0}},
+		{"meters", map[string]interface{}{"time": base, "meter_id": 42.0, "kwh": 100.0}},
+		{"raw", map[string]interface{}{"time": base, "device": "a"}}, // caching disabled
+		{"readings", map[string]interface{}{"time": base, "v": 3.0}}, // no key
+	}
+	for _, in := range inserts {
+		if err := c.InsertIntoTable(ctx, in.table, in.data); err != nil {
+			t.Fatalf("InsertIntoTable failed: %v", err)
+		}
+	}
+
+	if row, ok := c.Get("readings", "a"); !ok || row["v"] != 2.0 {
+		t.Errorf("Get(readings, a) = %v, %v; want v=2", row, ok)
+	}
+	if row, ok := c.Get("meters", "42"); !ok || row["kwh"] != 100.0 {
+		t.Errorf("Get(meters, 42) = %v, %v; want kwh=100", row, ok)
+	}
+	if all := c.All("readings"); len(all) != 2 {
+		t.Errorf("All(readings) = %v, want 2 keys", all)
+	}
+	if tables := c.Tables(); len(tables) != 2 || tables[0] != "meters" || tables[1] != "readings" {
+		t.Errorf("Tables() = %v, want [meters readings]", tables)
+	}
+
+	// Failed inserts are not cached
+	next.fail = true
+	if err := c.InsertIntoTable(ctx, "readings", map[string]interface{}{"time": base.Add(time.Hour), "device": "c"}); err == nil {
+		t.Fatal("Expected insert error")
+	}
+	if _, ok := c.Get("readings", "c"); ok {
+		t.Error("Failed insert should not be cached")
+	}
+}
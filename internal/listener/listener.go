@@ -0,0 +1,308 @@
+package listener
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/router"
+)
+
+// Framing modes for stream (TCP) connections.
+const (
+	// FramingNewline splits the stream on '\n' (a trailing '\r' is stripped).
+	FramingNewline = "newline"
+	// FramingLength expects each frame to be prefixed by a 4-byte big-endian length.
+	FramingLength = "length"
+)
+
+// defaultMaxFrameSize bounds a single frame when none is configured.
+const defaultMaxFrameSize = 64 * 1024
+
+// maxAcceptDelay caps the backoff between failed Accept calls.
+const maxAcceptDelay = time.Second
+
+// Config holds raw listener configuration.
+type Config struct {
+	Protocol     string // "tcp" or "udp"
+	Address      string // Listen address (e.g. ":5140")
+	Framing      string // "newline" (default) or "length"; TCP only
+	Topic        string // Synthetic topic for produced messages (default: "<protocol>/<port>")
+	MaxFrameSize int    // Maximum frame size in bytes (default: 64 KiB)
+	Logger       *logger.Logger
+}
+
+// Listener accepts raw UDP datagrams or TCP streams and turns them into messages.
+type Listener struct {
+	cfg    Config
+	topic  string
+	tcp    net.Listener
+	udp    net.PacketConn
+	conns  map[net.Conn]struct{}
+	mu     sync.Mutex
+	wg     sync.WaitGroup
+	closed bool
+	once   sync.Once
+	logger *logger.Logger
+}
+
+// New creates a new listener bound to the configured address.
+func New(cfg Config) (*Listener, error) {
+	log := cfg.Logger
+	if log == nil {
+		log = logger.New(logger.INFO)
+	}
+
+	if cfg.Framing == "" {
+		cfg.Framing = FramingNewline
+	}
+	if cfg.Framing != FramingNewline && cfg.Framing != FramingLength {
+		return nil, fmt.Errorf("invalid framing: %s", cfg.Framing)
+	}
+	if cfg.Framing == FramingLength && strings.EqualFold(cfg.Protocol, "udp") {
+		return nil, fmt.Errorf("framing %s is only supported for tcp", FramingLength)
+	}
+	if cfg.MaxFrameSize <= 0 {
+		cfg.MaxFrameSize = defaultMaxFrameSize
+	}
+
+	l := &Listener{
+		cfg:    cfg,
+		conns:  make(map[net.Conn]struct{}),
+		logger: log,
+	}
+
+	switch strings.ToLower(cfg.Protocol) {
+	case "tcp":
+		ln, err := net.Listen("tcp", cfg.Address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on tcp %s: %w", cfg.Address, err)
+		}
+		l.tcp = ln
+		l.topic = defaultTopic(cfg.Topic, "tcp", ln.Addr())
+	case "udp":
+		pc, err := net.ListenPacket("udp", cfg.Address)
+		if err != nil {
+			return nil, fmt.Errorf("failed to listen on udp %s: %w", cfg.Address, err)
+		}
+		l.udp = pc
+		l.topic = defaultTopic(cfg.Topic, "udp", pc.LocalAddr())
+	default:
+		return nil, fmt.Errorf("invalid protocol: %s", cfg.Protocol)
+	}
+
+	return l, nil
+}
+
+// defaultTopic returns topic, or "<protocol>/<port>" when topic is empty.
+func defaultTopic(topic, protocol string, addr net.Addr) string {
+	if topic != "" {
+		return topic
+	}
+	_, port, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return protocol
+	}
+	return protocol + "/" + port
+}
+
+// Addr returns the address the listener is bound to.
+func (l *Listener) Addr() net.Addr {
+	if l.tcp != nil {
+		return l.tcp.Addr()
+	}
+	return l.udp.LocalAddr()
+}
+
+// Topic returns the synthetic topic used for produced messages.
+func (l *Listener) Topic() string {
+	return l.topic
+}
+
+// Start begins accepting data in the background, dispatching every frame as a message.
+// The listener is closed when ctx is cancelled.
+func (l *Listener) Start(ctx context.Context, dispatch func(router.Message) error) error {
+	l.wg.Add(1)
+	if l.tcp != nil {
+		go l.acceptLoop(dispatch)
+	} else {
+		go l.packetLoop(dispatch)
+	}
+	go func() {
+		<-ctx.Done()
+		l.shutdown()
+	}()
+	l.logger.Infof("Listening for raw %s on %s (topic=%s, framing=%s)",
+		strings.ToLower(l.cfg.Protocol), l.Addr(), l.topic, l.cfg.Framing)
+	return nil
+}
+
+// emit dispatches a single frame as a message
+func (l *Listener) emit(dispatch func(router.Message) error, frame []byte) {
+	dispatch(router.Message{
+		Topic:   l.topic,
+		Payload: frame,
+		Time:    time.Now().UTC(),
+		Source:  l.Addr().Network() + "://" + l.Addr().String(),
+	})
+}
+
+// acceptLoop accepts TCP connections until the listener is closed. Like
+// net/http, it backs off on Accept errors (e.g. running out of file
+// descriptors) instead of spinning.
+func (l *Listener) acceptLoop(dispatch func(router.Message) error) {
+	defer l.wg.Done()
+	var delay time.Duration
+	for {
+		conn, err := l.tcp.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			if delay == 0 {
+				delay = 5 * time.Millisecond
+			} else if delay *= 2; delay > maxAcceptDelay {
+				delay = maxAcceptDelay
+			}
+			l.logger.Errorf("Failed to accept connection on %s: %v; retrying in %v", l.Addr(), err, delay)
+			time.Sleep(delay)
+			continue
+		}
+		delay = 0
+
+		l.mu.Lock()
+		if l.closed {
+			l.mu.Unlock()
+			conn.Close()
+			return
+		}
+		l.conns[conn] = struct{}{}
+		l.mu.Unlock()
+
+		l.wg.Add(1)
+		go l.serveConn(conn, dispatch)
+	}
+}
+
+// serveConn reads frames from a single TCP connection.
+func (l *Listener) serveConn(conn net.Conn, dispatch func(router.Message) error) {
+	defer l.wg.Done()
+	defer func() {
+		l.mu.Lock()
+		delete(l.conns, conn)
+		l.mu.Unlock()
+		conn.Close()
+	}()
+
+	l.logger.Debugf("Accepted raw connection from %s", conn.RemoteAddr())
+
+	err := readFrames(conn, l.cfg.Framing, l.cfg.MaxFrameSize, func(frame []byte) {
+		l.emit(dispatch, frame)
+	})
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		l.logger.Errorf("Connection from %s closed: %v", conn.RemoteAddr(), err)
+	}
+}
+
+// packetLoop reads UDP datagrams; each datagram is treated as one frame.
+// The buffer has room for one byte over the limit, so a datagram the read
+// would truncate is detected and dropped instead of dispatched in part.
+func (l *Listener) packetLoop(dispatch func(router.Message) error) {
+	defer l.wg.Done()
+	buf := make([]byte, l.cfg.MaxFrameSize+1)
+	for {
+		n, from, err := l.udp.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			l.logger.Errorf("Failed to read datagram on %s: %v", l.Addr(), err)
+			continue
+		}
+		if n > l.cfg.MaxFrameSize {
+			l.logger.Warnf("Dropping datagram from %s on %s: exceeds max frame size %d", from, l.Addr(), l.cfg.MaxFrameSize)
+			continue
+		}
+
+		frame := make([]byte, n)
+		copy(frame, buf[:n])
+		l.emit(dispatch, frame)
+	}
+}
+
+// readFrames splits r into frames according to framing and calls fn for each.
+// It returns nil when r reaches EOF cleanly.
+func readFrames(r io.Reader, framing string, maxSize int, fn func([]byte)) error {
+	switch framing {
+	case FramingLength:
+		br := bufio.NewReader(r)
+		var hdr [4]byte
+		for {
+			if _, err := io.ReadFull(br, hdr[:]); err != nil {
+				if errors.Is(err, io.EOF) {
+					return nil
+				}
+				return err
+			}
+			size := binary.BigEndian.Uint32(hdr[:])
+			if int64(size) > int64(maxSize) {
+				return fmt.Errorf("frame size %d exceeds limit %d", size, maxSize)
+			}
+			frame := make([]byte, size)
+			if _, err := io.ReadFull(br, frame); err != nil {
+				return err
+			}
+			fn(frame)
+		}
+	default:
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 0, 4096), maxSize)
+		for scanner.Scan() {
+			line := scanner.Bytes()
+			if len(line) > 0 && line[len(line)-1] == '\r' {
+				line = line[:len(line)-1]
+			}
+			if len(line) == 0 {
+				continue
+			}
+			frame := make([]byte, len(line))
+			copy(frame, line)
+			fn(frame)
+		}
+		return scanner.Err()
+	}
+}
+
+// Close stops the listener and waits for active connections to finish.
+func (l *Listener) Close() {
+	l.shutdown()
+	l.wg.Wait()
+}
+
+// shutdown closes the sockets and active connections exactly once.
+func (l *Listener) shutdown() {
+	l.once.Do(func() {
+		l.mu.Lock()
+		l.closed = true
+		for conn := range l.conns {
+			conn.Close()
+		}
+		l.mu.Unlock()
+
+		if l.tcp != nil {
+			l.tcp.Close()
+		}
+		if l.udp != nil {
+			l.udp.Close()
+		}
+		l.logger.Infof("Raw listener on %s closed", l.Addr())
+	})
+}
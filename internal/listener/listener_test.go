@@ -0,0 +1,226 @@
+package listener
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/router"
+)
+
+func TestReadFramesNewline(t *testing.T) {
+	input := "first\r\nsecond\n\nthird"
+	var frames []string
+	err := readFrames(bytes.NewBufferString(input), FramingNewline, 1024, func(b []byte) {
+		frames = append(frames, string(b))
+	})
+	if err != nil {
+		t.Fatalf("readFrames failed: %v", err)
+	}
+
+	want := []string{"first", "second", "third"}
+	if len(frames) != len(want) {
+		t.Fatalf("Expected %d frames, got %d: %v", len(want), len(frames), frames)
+	}
+	for i := range want {
+		if frames[i] != want[i] {
+			t.Errorf("Frame %d = %q, want %q", i, frames[i], want[i])
+		}
+	}
+}
+
+func TestReadFramesLength(t *testing.T) {
+	var buf bytes.Buffer
+	for _, s := range []string{"hello", "", "world"} {
+		var hdr [4]byte
+		binary.BigEndian.PutUint32(hdr[:], uint32(len(s)))
+		buf.Write(hdr[:])
+		buf.WriteString(s)
+	}
+
+	var frames []string
+	err := readFrames(&buf, FramingLength, 1024, func(b []byte) {
+		frames = append(frames, string(b))
+	})
+	if err != nil {
+		t.Fatalf("readFrames failed: %v", err)
+	}
+	if len(frames) != 3 || frames[0] != "hello" || frames[1] != "" || frames[2] != "world" {
+		t.Errorf("Unexpected frames: %q", frames)
+	}
+}
+
+func TestReadFramesLengthTooLarge(t *testing.T) {
+	var buf bytes.Buffer
+	var hdr [4]byte
+	binary.BigEndian.PutUint32(hdr[:], 2048)
+	buf.Write(hdr[:])
+
+	err := readFrames(&buf, FramingLength, 1024, func([]byte) {})
+	if err == nil {
+		t.Error("Expected error for oversized frame")
+	}
+}
+
+func TestNewInvalidConfig(t *testing.T) {
+	if _, err := New(Config{Protocol: "sctp", Address: "127.0.0.1:0"}); err == nil {
+		t.Error("Expected error for invalid protocol")
+	}
+	if _, err := New(Config{Protocol: "tcp", Address: "127.0.0.1:0", Framing: "xml"}); err == nil {
+		t.Error("Expected error for invalid framing")
+	}
+	if _, err := New(Config{Protocol: "udp", Address: "127.0.0.1:0", Framing: FramingLength}); err == nil {
+		t.Error("Expected error for length framing on udp")
+	}
+}
+
+// failingListener fails Accept a fixed number of times before closing
+type failingListener struct {
+	net.Listener
+	failures int
+	calls    int
+}
+
+func (f *failingListener) Accept() (net.Conn, error) {
+	f.calls++
+	if f.calls > f.failures {
+		return nil, net.ErrClosed
+	}
+	return nil, errors.New("too many open files")
+}
+
+func TestAcceptLoopBacksOff(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer ln.Close()
+	fl := &failingListener{Listener: ln, failures: 3}
+	l := &Listener{tcp: fl, conns: make(map[net.Conn]struct{}), logger: logger.New(logger.ERROR)}
+
+	start := time.Now()
+	l.wg.Add(1)
+	l.acceptLoop(func(router.Message) error { return nil })
+
+	if fl.calls != 4 {
+		t.Errorf("Accept called %d times, want 4", fl.calls)
+	}
+	// 5ms + 10ms + 20ms between the failed attempts
+	if elapsed := time.Since(start); elapsed < 35*time.Millisecond {
+		t.Errorf("acceptLoop retried after %v, want at least 35ms of backoff", elapsed)
+	}
+}
+
+// collector gathers handler calls for assertions
+type collector struct {
+	mu     sync.Mutex
+	topics []string
+	frames []string
+}
+
+func (c *collector) dispatch(msg router.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.topics = append(c.topics, msg.Topic)
+	c.frames = append(c.frames, string(msg.Payload))
+	return nil
+}
+
+func (c *collector) waitFor(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		got := len(c.frames)
+		c.mu.Unlock()
+		if got >= n {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d frames", n)
+}
+
+func TestTCPListener(t *testing.T) {
+	l, err := New(Config{Protocol: "tcp", Address: "127.0.0.1:0", Topic: "raw/tcp"})
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	defer l.Close()
+
+	c := &collector{}
+	l.Start(context.Background(), c.dispatch)
+
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	conn.Write([]byte("a=1\nb=2\n"))
+	conn.Close()
+
+	c.waitFor(t, 2)
+	if c.frames[0] != "a=1" || c.frames[1] != "b=2" {
+		t.Errorf("Unexpected frames: %q", c.frames)
+	}
+	if c.topics[0] != "raw/tcp" {
+		t.Errorf("Expected topic 'raw/tcp', got %q", c.topics[0])
+	}
+}
+
+func TestUDPListenerDefaultTopic(t *testing.T) {
+	l, err := New(Config{Protocol: "udp", Address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	defer l.Close()
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	if l.Topic() != "udp/"+port {
+		t.Errorf("Expected default topic 'udp/%s', got %q", port, l.Topic())
+	}
+
+	c := &collector{}
+	l.Start(context.Background(), c.dispatch)
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("datagram"))
+
+	c.waitFor(t, 1)
+	if c.frames[0] != "datagram" {
+		t.Errorf("Expected 'datagram', got %q", c.frames[0])
+	}
+}
+
+func TestUDPListenerDropsOversizeDatagram(t *testing.T) {
+	l, err := New(Config{Protocol: "udp", Address: "127.0.0.1:0", MaxFrameSize: 4, Logger: logger.New(logger.ERROR)})
+	if err != nil {
+		t.Fatalf("Failed to create listener: %v", err)
+	}
+	defer l.Close()
+
+	c := &collector{}
+	l.Start(context.Background(), c.dispatch)
+
+	conn, err := net.Dial("udp", l.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("oversize"))
+	conn.Write([]byte("fits"))
+
+	c.waitFor(t, 1)
+	if c.frames[0] != "fits" {
+		t.Errorf("Expected the oversize datagram to be dropped, got %q", c.frames)
+	}
+}
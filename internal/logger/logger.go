@@ -15,6 +15,8 @@ const (
 	DEBUG Level = iota
 	// INFO level for general events
 	INFO
+	// WARN level for conditions that need attention before they become errors
+	WARN
 	// ERROR level for errors only
 	ERROR
 )
@@ -24,6 +26,7 @@ type Logger struct {
 	level       Level
 	debugLogger *log.Logger
 	infoLogger  *log.Logger
+	warnLogger  *log.Logger
 	errorLogger *log.Logger
 }
 
@@ -33,6 +36,7 @@ func New(level Level) *Logger {
 		level:       level,
 		debugLogger: log.New(os.Stdout, "DEBUG: ", log.LstdFlags),
 		infoLogger:  log.New(os.Stdout, "INFO: ", log.LstdFlags),
+		warnLogger:  log.New(os.Stderr, "WARN: ", log.LstdFlags),
 		errorLogger: log.New(os.Stderr, "ERROR: ", log.LstdFlags),
 	}
 }
@@ -41,9 +45,16 @@ func New(level Level) *Logger {
 func (l *Logger) SetOutput(w io.Writer) {
 	l.debugLogger.SetOutput(w)
 	l.infoLogger.SetOutput(w)
+	l.warnLogger.SetOutput(w)
 	l.errorLogger.SetOutput(w)
 }
 
+// Enabled reports whether messages at level are logged. Use it to skip
+// building expensive log arguments on hot paths.
+func (l *Logger) Enabled(level Level) bool {
+	return l.level <= level
+}
+
 // Debug logs a message at DEBUG level
 func (l *Logger) Debug(v ...interface{}) {
 	if l.level <= DEBUG {
@@ -72,6 +83,20 @@ func (l *Logger) Infof(format string, v ...interface{}) {
 	}
 }
 
+// Warn logs a message at WARN level
+func (l *Logger) Warn(v ...interface{}) {
+	if l.level <= WARN {
+		l.warnLogger.Println(v...)
+	}
+}
+
+// Warnf logs a formatted message at WARN level
+func (l *Logger) Warnf(format string, v ...interface{}) {
+	if l.level <= WARN {
+		l.warnLogger.Printf(format, v...)
+	}
+}
+
 // Error logs a message at ERROR level
 func (l *Logger) Error(v ...interface{}) {
 	if l.level <= ERROR {
@@ -93,6 +118,8 @@ func ParseLevel(s string) Level {
 		return DEBUG
 	case "INFO":
 		return INFO
+	case "WARN", "WARNING":
+		return WARN
 	case "ERROR":
 		return ERROR
 	default:
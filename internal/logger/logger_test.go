@@ -25,6 +25,8 @@ func TestParseLevel(t *testing.T) {
 		{"debug", DEBUG},
 		{"INFO", INFO},
 		{"info", INFO},
+		{"WARN", WARN},
+		{"warning", WARN},
 		{"ERROR", ERROR},
 		{"error", ERROR},
 		{"unknown", INFO}, // defaults to INFO
@@ -84,6 +86,24 @@ func TestLoggerLevels(t *testing.T) {
 			},
 			shouldContain: "info message",
 		},
+		{
+			name:  "warn logger logs at WARN level",
+			level: WARN,
+			logFunc: func(l *Logger, buf *bytes.Buffer) {
+				l.SetOutput(buf)
+				l.Warn("warn message")
+			},
+			shouldContain: "warn message",
+		},
+		{
+			name:  "warn logger does not log at ERROR level",
+			level: ERROR,
+			logFunc: func(l *Logger, buf *bytes.Buffer) {
+				l.SetOutput(buf)
+				l.Warn("warn message")
+			},
+			shouldContain: "",
+		},
 		{
 			name:  "error logger logs at all levels",
 			level: ERROR,
@@ -126,3 +146,13 @@ func TestLoggerFormatted(t *testing.T) {
 		t.Errorf("Expected formatted output, got: %s", output)
 	}
 }
+
+func TestLoggerEnabled(t *testing.T) {
+	l := New(INFO)
+	if l.Enabled(DEBUG) {
+		t.Error("DEBUG should be disabled at INFO level")
+	}
+	if !l.Enabled(INFO) || !l.Enabled(ERROR) {
+		t.Error("INFO and ERROR should be enabled at INFO level")
+	}
+}
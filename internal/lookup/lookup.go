@@ -0,0 +1,226 @@
+package lookup
+
+import (
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// Querier runs a SQL query and returns the column names (in order) and rows
+type Querier interface {
+	QueryRows(ctx context.Context, query string) ([]string, []map[string]interface{}, error)
+}
+
+// Config holds a single lookup table configuration.
+// Exactly one of CSV or Query must be set.
+type Config struct {
+	Name    string        // Lookup name used from Lua (e.g. "devices")
+	CSV     string        // Path to a CSV file with a header row
+	Query   string        // SQL query returning the key column and attributes
+	Key     string        // Key column (default: first column)
+	Refresh time.Duration // Reload interval (0 = load once)
+	Querier Querier       // Database used for Query
+}
+
+// table is a loaded key -> attributes map
+type table struct {
+	cfg  Config
+	mu   sync.RWMutex
+	rows map[string]map[string]interface{}
+}
+
+// Registry holds all lookup tables and refreshes them periodically
+type Registry struct {
+	tables map[string]*table
+	logger *logger.Logger
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// New creates an empty lookup registry
+func New(log *logger.Logger) *Registry {
+	if log == nil {
+		log = logger.New(logger.INFO)
+	}
+	return &Registry{
+		tables: make(map[string]*table),
+		logger: log,
+	}
+}
+
+// Add registers a lookup table and performs the initial load
+func (r *Registry) Add(ctx context.Context, cfg Config) error {
+	if cfg.Name == "" {
+		return fmt.Errorf("lookup name is required")
+	}
+	if _, exists := r.tables[cfg.Name]; exists {
+		return fmt.Errorf("lookup %s defined more than once", cfg.Name)
+	}
+	if (cfg.CSV == "") == (cfg.Query == "") {
+		return fmt.Errorf("lookup %s: exactly one of csv or query must be set", cfg.Name)
+	}
+	if cfg.Query != "" && cfg.Querier == nil {
+		return fmt.Errorf("lookup %s: query requires a database connection", cfg.Name)
+	}
+
+	t := &table{cfg: cfg}
+	if err := t.load(ctx); err != nil {
+		return fmt.Errorf("lookup %s: %w", cfg.Name, err)
+	}
+	r.tables[cfg.Name] = t
+	r.logger.Infof("Lookup %s loaded with %d entries", cfg.Name, len(t.rows))
+	return nil
+}
+
+// Start begins periodic refresh of tables that have a refresh interval
+func (r *Registry) Start(ctx context.Context) {
+	ctx, r.cancel = context.WithCancel(ctx)
+	for _, t := range r.tables {
+		if t.cfg.Refresh <= 0 {
+			continue
+		}
+		r.wg.Add(1)
+		go r.refreshLoop(ctx, t)
+	}
+}
+
+// Close stops refreshing
+func (r *Registry) Close() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+}
+
+// Get returns the attributes for key in the named lookup
+func (r *Registry) Get(name, key string) (map[string]interface{}, bool) {
+	t, ok := r.tables[name]
+	if !ok {
+		return nil, false
+	}
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	row, ok := t.rows[key]
+	return row, ok
+}
+
+// refreshLoop reloads a table until ctx is cancelled; failed reloads keep the old data
+func (r *Registry) refreshLoop(ctx context.Context, t *table) {
+	defer r.wg.Done()
+	ticker := time.NewTicker(t.cfg.Refresh)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := t.load(ctx); err != nil {
+				r.logger.Errorf("Failed to refresh lookup %s: %v", t.cfg.Name, err)
+				continue
+			}
+			r.logger.Debugf("Lookup %s refreshed", t.cfg.Name)
+		}
+	}
+}
+
+// load reads the table from its source and swaps it in
+func (t *table) load(ctx context.Context) error {
+	var (
+		rows map[string]map[string]interface{}
+		err  error
+	)
+	if t.cfg.CSV != "" {
+		rows, err = loadCSV(t.cfg.CSV, t.cfg.Key)
+	} else {
+		rows, err = loadQuery(ctx, t.cfg.Querier, t.cfg.Query, t.cfg.Key)
+	}
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	t.rows = rows
+	t.mu.Unlock()
+	return nil
+}
+
+// loadCSV reads a CSV file with a header row. Numeric cells become float64.
+func loadCSV(path, key string) (map[string]map[string]interface{}, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open CSV: %w", err)
+	}
+	defer f.Close()
+
+	reader := csv.NewReader(f)
+	reader.TrimLeadingSpace = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %w", err)
+	}
+
+	keyIdx := 0
+	if key != "" {
+		keyIdx = -1
+		for i, h := range header {
+			if h == key {
+				keyIdx = i
+			}
+		}
+		if keyIdx < 0 {
+			return nil, fmt.Errorf("key column %s not found in CSV header", key)
+		}
+	}
+
+	rows := make(map[string]map[string]interface{})
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV: %w", err)
+		}
+		row := make(map[string]interface{}, len(header))
+		for i, h := range header {
+			if i >= len(record) {
+				break
+			}
+			if f, err := strconv.ParseFloat(record[i], 64); err == nil {
+				row[h] = f
+			} else {
+				row[h] = record[i]
+			}
+		}
+		rows[record[keyIdx]] = row
+	}
+	return rows, nil
+}
+
+// loadQuery runs a SQL query and keys rows by the key column (default: first column)
+func loadQuery(ctx context.Context, q Querier, query, key string) (map[string]map[string]interface{}, error) {
+	columns, result, err := q.QueryRows(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	if key == "" && len(columns) > 0 {
+		key = columns[0]
+	}
+
+	rows := make(map[string]map[string]interface{}, len(result))
+	for _, row := range result {
+		k, ok := row[key]
+		if !ok {
+			return nil, fmt.Errorf("key column %s not found in query result", key)
+		}
+		rows[fmt.Sprintf("%v", k)] = row
+	}
+	return rows, nil
+}
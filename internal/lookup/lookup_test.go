@@ -0,0 +1,134 @@
+package lookup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mockQuerier returns fixed rows
+type mockQuerier struct {
+	columns []string
+	rows    []map[string]interface{}
+}
+
+func (m *mockQuerier) QueryRows(ctx context.Context, query string) ([]string, []map[string]interface{}, error) {
+	return m.columns, m.rows, nil
+}
+
+func writeCSV(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "devices.csv")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("failed to write CSV: %v", err)
+	}
+	return path
+}
+
+func TestCSVLookup(t *testing.T) {
+	path := writeCSV(t, "id,name,offset\nF0:34,kitchen,-0.5\nAA:BB,garage,1\n")
+
+	reg := New(nil)
+	if err := reg.Add(context.Background(), Config{Name: "devices", CSV: path}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	row, ok := reg.Get("devices", "F0:34")
+	if !ok {
+		t.Fatal("Expected key F0:34 to be found")
+	}
+	if row["name"] != "kitchen" {
+		t.Errorf("Expected name 'kitchen', got %v", row["name"])
+	}
+	if row["offset"] != -0.5 {
+		t.Errorf("Expected numeric offset -0.5, got %v (%T)", row["offset"], row["offset"])
+	}
+
+	if _, ok := reg.Get("devices", "missing"); ok {
+		t.Error("Expected missing key to be absent")
+	}
+	if _, ok := reg.Get("unknown", "F0:34"); ok {
+		t.Error("Expected unknown lookup to be absent")
+	}
+}
+
+func TestCSVLookupKeyColumn(t *testing.T) {
+	path := writeCSV(t, "name,mac\nkitchen,F0:34\n")
+
+	reg := New(nil)
+	if err := reg.Add(context.Background(), Config{Name: "devices", CSV: path, Key: "mac"}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if row, ok := reg.Get("devices", "F0:34"); !ok || row["name"] != "kitchen" {
+		t.Errorf("Expected lookup by mac column, got %v", row)
+	}
+
+	if err := reg.Add(context.Background(), Config{Name: "other", CSV: path, Key: "nope"}); err == nil {
+		t.Error("Expected error for missing key column")
+	}
+}
+
+func TestQueryLookup(t *testing.T) {
+	q := &mockQuerier{
+		columns: []string{"device_id", "location"},
+		rows: []map[string]interface{}{
+			{"device_id": int32(7), "location": "attic"},
+		},
+	}
+
+	reg := New(nil)
+	if err := reg.Add(context.Background(), Config{Name: "locations", Query: "SELECT ...", Querier: q}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	if row, ok := reg.Get("locations", "7"); !ok || row["location"] != "attic" {
+		t.Errorf("Expected row keyed by first column, got %v", row)
+	}
+}
+
+func TestAddValidation(t *testing.T) {
+	reg := New(nil)
+	tests := []struct {
+		name string
+		cfg  Config
+	}{
+		{"missing name", Config{CSV: "x.csv"}},
+		{"no source", Config{Name: "a"}},
+		{"both sources", Config{Name: "a", CSV: "x.csv", Query: "SELECT 1"}},
+		{"query without db", Config{Name: "a", Query: "SELECT 1"}},
+		{"missing file", Config{Name: "a", CSV: "/nonexistent.csv"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := reg.Add(context.Background(), tt.cfg); err == nil {
+				t.Error("Expected error")
+			}
+		})
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	path := writeCSV(t, "id,name\n1,old\n")
+
+	reg := New(nil)
+	if err := reg.Add(context.Background(), Config{Name: "devices", CSV: path, Refresh: 10 * time.Millisecond}); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+	reg.Start(context.Background())
+	defer reg.Close()
+
+	if err := os.WriteFile(path, []byte("id,name\n1,new\n"), 0644); err != nil {
+		t.Fatalf("failed to rewrite CSV: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if row, _ := reg.Get("devices", "1"); row["name"] == "new" {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Error("Expected lookup to be refreshed")
+}
@@ -10,6 +10,7 @@ import (
 	"log"
 	"sync"
 
+	"github.com/marcgeld/hermod/internal/decoder"
 	lua "github.com/yuin/gopher-lua"
 )
 
@@ -49,11 +50,21 @@ Available Go-backed functions (registered in the global Lua state):
     Parses a JSON string and returns the corresponding Lua value (table/primitive)
     on success, or (nil, error_message) on failure.
 
+- ruuvi_decode(hex_or_bytes) -> (table | nil, error | nil)
+    Decodes a Ruuvi data format 3 or 5 payload given as a hex string or raw
+    bytes. See decoder.Ruuvi for the returned fields.
+
+- dsmr_decode(telegram) -> (table | nil, error | nil)
+    Parses a DSMR/P1 smart meter telegram into OBIS codes and named readings,
+    verifying the CRC when present. See decoder.DSMR for the returned fields.
+
 Notes:
 - json_encode/json_decode convert between Lua tables and Go maps/arrays using
   reasonable heuristics: numeric sequential keys -> arrays, string keys -> maps.
 - All encode/decode helpers operate on UTF-8 strings. Binary data returned by
   decode functions are returned as Lua strings (may contain arbitrary bytes).
+- Site-specific helpers can be added without patching this package with
+  RegisterLuaFunc; they are available in transformers and router workers.
 */
 
 // Transformer handles Lua script execution for message transformation
@@ -69,6 +80,7 @@ func New(scriptPath string) (*Transformer, error) {
 
 	// Register Go-backed functions for Lua scripts
 	registerFunctions(L)
+	ApplyRegistered(L)
 
 	// Load the Lua script
 	if err := L.DoFile(scriptPath); err != nil {
@@ -173,6 +185,32 @@ func registerFunctions(L *lua.LState) {
 		L.Push(lua.LNil)
 		return 2
 	}))
+
+	// ruuvi_decode(hex_or_bytes) -> (table, err)
+	L.SetGlobal("ruuvi_decode", L.NewFunction(func(L *lua.LState) int {
+		fields, err := decoder.Ruuvi(decoder.RuuviInput(L.CheckString(1)))
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(goValueToLua(L, fields))
+		L.Push(lua.LNil)
+		return 2
+	}))
+
+	// dsmr_decode(telegram) -> (table, err)
+	L.SetGlobal("dsmr_decode", L.NewFunction(func(L *lua.LState) int {
+		fields, err := decoder.DSMR(L.CheckString(1))
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(goValueToLua(L, fields))
+		L.Push(lua.LNil)
+		return 2
+	}))
 }
 
 // luaValueToGo converts a lua.LValue into Go native types (recursively)
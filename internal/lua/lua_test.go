@@ -4,6 +4,8 @@ import (
 	"os"
 	"path/filepath"
 	"testing"
+
+	lua "github.com/yuin/gopher-lua"
 )
 
 func TestNew(t *testing.T) {
@@ -399,3 +401,75 @@ end
 		t.Errorf("expected decode_err to be nil, got: %v", got["decode_err"])
 	}
 }
+
+func TestRuuviDecodeFunction(t *testing.T) {
+	scriptCode := `
+function transform(data)
+    local r, err = ruuvi_decode(data.raw)
+    local _, bad = ruuvi_decode("0701")
+    return { temperature = r.temperature, mac = r.mac, err = err, bad = bad }
+end
+`
+	tmpDir := t.TempDir()
+	scriptPath := filepath.Join(tmpDir, "test_ruuvi.lua")
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	transformer, err := New(scriptPath)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer transformer.Close()
+
+	got, err := transformer.Transform(map[string]interface{}{"raw": "0512FC5394C37C0004FFFC040CAC364200CDCBB8334C884F"})
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+
+	if got["temperature"] != 24.3 || got["mac"] != "CB:B8:33:4C:88:4F" {
+		t.Errorf("unexpected decode result: %v", got)
+	}
+	if got["err"] != nil {
+		t.Errorf("expected err to be nil, got: %v", got["err"])
+	}
+	if got["bad"] == nil {
+		t.Error("expected error for unsupported format")
+	}
+}
+
+func TestRegisterLuaFunc(t *testing.T) {
+	RegisterLuaFunc("site_scale", func(L *lua.LState) int {
+		L.Push(L.CheckNumber(1) * 10)
+		return 1
+	})
+	t.Cleanup(func() {
+		customMu.Lock()
+		delete(customFuncs, "site_scale")
+		customMu.Unlock()
+	})
+
+	scriptCode := `
+function transform(data)
+    return { scaled = site_scale(data.value) }
+end
+`
+	scriptPath := filepath.Join(t.TempDir(), "test_custom.lua")
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	transformer, err := New(scriptPath)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer transformer.Close()
+
+	got, err := transformer.Transform(map[string]interface{}{"value": 2.5})
+	if err != nil {
+		t.Fatalf("Transform() error = %v", err)
+	}
+	if got["scaled"] != 25.0 {
+		t.Errorf("unexpected scaled value: %v", got["scaled"])
+	}
+}
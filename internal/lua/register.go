@@ -0,0 +1,50 @@
+package lua
+
+import (
+	"sort"
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+var (
+	customMu    sync.RWMutex
+	customFuncs = make(map[string]lua.LGFunction)
+)
+
+// RegisterLuaFunc makes fn available to Lua scripts as the global name. It
+// applies to every Lua state created afterwards: pipeline transformers and
+// router workers alike. Registering an existing name (including a built-in
+// helper) replaces it. Call it during startup, before creating transformers
+// or routers.
+func RegisterLuaFunc(name string, fn lua.LGFunction) {
+	customMu.Lock()
+	defer customMu.Unlock()
+	customFuncs[name] = fn
+}
+
+// RegisteredNames returns the names added with RegisterLuaFunc in sorted order
+func RegisteredNames() []string {
+	customMu.RLock()
+	defer customMu.RUnlock()
+	names := make([]string, 0, len(customFuncs))
+	for name := range customFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ApplyRegistered sets the functions added with RegisterLuaFunc as globals in L
+func ApplyRegistered(L *lua.LState) {
+	customMu.RLock()
+	defer customMu.RUnlock()
+	names := make([]string, 0, len(customFuncs))
+	for name := range customFuncs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		L.SetGlobal(name, L.NewFunction(customFuncs[name]))
+	}
+}
@@ -0,0 +1,90 @@
+package mqtt
+
+import (
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// Backoff controls the delays between reconnect attempts after the broker
+// connection drops. Each failed attempt doubles the delay up to Max.
+type Backoff struct {
+	Initial time.Duration // Delay before the first attempt (default: 1s)
+	Max     time.Duration // Longest delay (default: 10m)
+	Jitter  float64       // Random spread of each delay, as a fraction of it (0-1, default: 0 = none)
+}
+
+// Reconnect backoff defaults, matching the client library's own reconnects
+const (
+	defaultBackoffInitial = time.Second
+	defaultBackoffMax     = 10 * time.Minute
+)
+
+// withDefaults returns b with unset delays defaulted
+func (b Backoff) withDefaults() Backoff {
+	if b.Initial == 0 {
+		b.Initial = defaultBackoffInitial
+	}
+	if b.Max == 0 {
+		b.Max = max(defaultBackoffMax, b.Initial)
+	}
+	return b
+}
+
+// validate checks the backoff settings
+func (b Backoff) validate() error {
+	switch {
+	case b.Initial < 0 || b.Max < 0:
+		return fmt.Errorf("reconnect delays must not be negative")
+	case b.Max != 0 && b.Initial > b.Max:
+		return fmt.Errorf("reconnect_initial %s exceeds reconnect_max %s", b.Initial, b.Max)
+	case b.Jitter < 0 || b.Jitter > 1:
+		return fmt.Errorf("invalid reconnect_jitter %g: use a fraction between 0 and 1", b.Jitter)
+	}
+	return nil
+}
+
+// delay returns how long to wait before reconnect attempt n (0-based);
+// random returns a number in [0, 1)
+func (b Backoff) delay(n int, random func() float64) time.Duration {
+	d := b.Initial
+	for i := 0; i < n && d < b.Max; i++ {
+		d *= 2
+	}
+	d = min(d, b.Max)
+	if b.Jitter > 0 {
+		// Spread evenly over d ± jitter, so a fleet doesn't reconnect in lockstep
+		d += time.Duration(float64(d) * b.Jitter * (2*random() - 1))
+	}
+	return d
+}
+
+// reconnect connects to the broker again after the connection dropped,
+// waiting longer after every failed attempt, until it succeeds or the
+// client is closed. Every filter is subscribed again once connected.
+func (c *Client) reconnect() {
+	if !c.reconnecting.CompareAndSwap(false, true) {
+		return
+	}
+	defer c.reconnecting.Store(false)
+
+	for n := 0; ; n++ {
+		select {
+		case <-c.done:
+			return
+		case <-time.After(c.backoff.delay(n, rand.Float64)):
+		}
+		token := c.connect()
+		token.Wait()
+		if token.Error() == nil {
+			select {
+			case <-c.done:
+				// Closed while connecting
+				c.client.Disconnect(0)
+			default:
+			}
+			return
+		}
+		c.logger.Warnf("Failed to reconnect to MQTT broker %s (attempt %d): %v", c.brokers(), n+1, token.Error())
+	}
+}
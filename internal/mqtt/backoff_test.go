@@ -0,0 +1,108 @@
+package mqtt
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 10 * time.Second}.withDefaults()
+	want := []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+	for n, w := range want {
+		if got := b.delay(n, nil); got != w {
+			t.Errorf("delay(%d) = %s, want %s", n, got, w)
+		}
+	}
+	if got := b.delay(1000, nil); got != 10*time.Second {
+		t.Errorf("delay(1000) = %s, want the maximum", got)
+	}
+
+	b.Jitter = 0.5
+	if low, high := b.delay(0, func() float64 { return 0 }), b.delay(0, func() float64 { return 0.999 }); low != 500*time.Millisecond || high < 1499*time.Millisecond {
+		t.Errorf("jittered delay spans %s-%s, want 500ms-1.5s", low, high)
+	}
+
+	if d := (Backoff{}).withDefaults(); d.Initial != time.Second || d.Max != 10*time.Minute {
+		t.Errorf("defaults = %+v", d)
+	}
+	if d := (Backoff{Initial: time.Hour}).withDefaults(); d.Max != time.Hour {
+		t.Errorf("Max below a long Initial: %+v", d)
+	}
+}
+
+func TestBackoffValidate(t *testing.T) {
+	for _, b := range []Backoff{
+		{Initial: -time.Second},
+		{Initial: time.Minute, Max: time.Second},
+		{Jitter: 1.5},
+		{Jitter: -0.1},
+	} {
+		if err := b.validate(); err == nil {
+			t.Errorf("validate(%+v) expected error", b)
+		}
+	}
+	if err := (Backoff{Initial: time.Second, Max: time.Minute, Jitter: 0.2}).validate(); err != nil {
+		t.Errorf("validate() error = %v", err)
+	}
+}
+
+// doneToken is a completed token
+type doneToken struct {
+	mqtt.Token
+	err error
+}
+
+func (t doneToken) Wait() bool   { return true }
+func (t doneToken) Error() error { return t.err }
+
+// flakyClient fails to connect a number of times
+type flakyClient struct {
+	mqtt.Client
+	failures int32
+	attempts atomic.Int32
+}
+
+func (f *flakyClient) Connect() mqtt.Token {
+	if f.attempts.Add(1) <= f.failures {
+		return doneToken{err: errors.New("connection refused")}
+	}
+	return doneToken{}
+}
+
+func TestReconnect(t *testing.T) {
+	stub := &flakyClient{failures: 2}
+	c := &Client{
+		client:  stub,
+		logger:  logger.New(logger.ERROR),
+		backoff: Backoff{Initial: time.Millisecond, Max: 4 * time.Millisecond},
+		done:    make(chan struct{}),
+	}
+	c.reconnect()
+	if n := stub.attempts.Load(); n != 3 {
+		t.Errorf("Expected 3 attempts, got %d", n)
+	}
+
+	// Closing stops a reconnect that keeps failing
+	stub = &flakyClient{failures: 1 << 30}
+	c.client = stub
+	finished := make(chan struct{})
+	go func() {
+		c.reconnect()
+		close(finished)
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(c.done)
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("reconnect kept running after close")
+	}
+	if stub.attempts.Load() < 2 {
+		t.Errorf("Expected repeated attempts before close, got %d", stub.attempts.Load())
+	}
+}
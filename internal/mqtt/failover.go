@@ -0,0 +1,73 @@
+package mqtt
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Orders in which a client with standby brokers tries its brokers
+const (
+	OrderFailover   = "failover"    // Every connect starts with Broker, then the standbys in order (default)
+	OrderRoundRobin = "round-robin" // Every reconnect starts with the broker after the one the previous attempt started with
+)
+
+// validateOrder checks a broker order ("" = failover)
+func validateOrder(order string) error {
+	switch order {
+	case "", OrderFailover, OrderRoundRobin:
+		return nil
+	}
+	return fmt.Errorf("invalid MQTT broker order %q: use failover or round-robin", order)
+}
+
+// brokerURLs returns Broker followed by the standby brokers
+func brokerURLs(cfg Config) []string {
+	return append([]string{cfg.Broker}, cfg.Standby...)
+}
+
+// brokerRing rotates the brokers the client library tries on connect.
+// The library's client shares the slice of servers with the options it was
+// created from and tries them in order on every Connect, so rotating that
+// slice between connects changes which broker is tried first.
+type brokerRing struct {
+	mu      sync.Mutex
+	servers []*url.URL // The options' servers, shared with the client
+	rotate  bool       // Round-robin: rotate before every reconnect
+}
+
+// next rotates the servers by one when connecting round-robin
+func (r *brokerRing) next() {
+	if r == nil || !r.rotate || len(r.servers) < 2 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	first := r.servers[0]
+	copy(r.servers, r.servers[1:])
+	r.servers[len(r.servers)-1] = first
+}
+
+// connect (re)connects to the broker, starting with the next broker when
+// connecting round-robin
+func (c *Client) connect() mqtt.Token {
+	c.ring.next()
+	return c.client.Connect()
+}
+
+// brokers lists the brokers the client connects to, for logs
+func (c *Client) brokers() string {
+	if c.ring == nil || len(c.ring.servers) < 2 {
+		return c.broker
+	}
+	c.ring.mu.Lock()
+	defer c.ring.mu.Unlock()
+	names := make([]string, len(c.ring.servers))
+	for i, u := range c.ring.servers {
+		names[i] = u.String()
+	}
+	return strings.Join(names, ", ")
+}
@@ -0,0 +1,66 @@
+package mqtt
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+)
+
+// closedPort returns a local address nothing listens on
+func closedPort(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return "tcp://" + addr
+}
+
+func TestBrokerOrder(t *testing.T) {
+	primary, standby := closedPort(t), closedPort(t)
+	for order, want := range map[string]string{
+		OrderFailover:   fmt.Sprint([]string{primary, standby, primary, standby, primary, standby}),
+		OrderRoundRobin: fmt.Sprint([]string{standby, primary, primary, standby, standby, primary}),
+	} {
+		var mu sync.Mutex
+		var attempts []string
+		opts := mqtt.NewClientOptions().AddBroker(primary).AddBroker(standby).
+			SetAutoReconnect(false).
+			SetConnectTimeout(time.Second).
+			SetConnectionAttemptHandler(func(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+				mu.Lock()
+				attempts = append(attempts, broker.String())
+				mu.Unlock()
+				return tlsCfg
+			})
+		c := &Client{
+			client: mqtt.NewClient(opts),
+			ring:   &brokerRing{servers: opts.Servers, rotate: order == OrderRoundRobin},
+		}
+		for i := 0; i < 3; i++ {
+			if token := c.connect(); token.Wait() && token.Error() == nil {
+				t.Fatalf("%s: expected connecting to closed ports to fail", order)
+			}
+		}
+		if got := fmt.Sprint(attempts); got != want {
+			t.Errorf("%s: attempts = %s, want %s", order, got, want)
+		}
+	}
+}
+
+func TestBrokerOrderConfig(t *testing.T) {
+	if _, err := New(Config{Broker: "tcp://localhost:1", Standby: []string{"tcp://localhost:2"}, Order: "random"}); err == nil {
+		t.Error("Expected an invalid broker order to be rejected")
+	}
+	if _, err := handshakeHeaders(Config{Broker: "wss://a/mqtt", Standby: []string{"tcp://b:1883"}, Token: "t0k"}); err == nil {
+		t.Error("Expected a token with a non-WebSocket standby broker to be rejected")
+	}
+}
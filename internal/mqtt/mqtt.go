@@ -1,35 +1,268 @@
 package mqtt
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/router"
 )
 
 // Client represents an MQTT client wrapper.
 type Client struct {
 	client   mqtt.Client
-	handlers map[string]MessageHandler
+	handlers map[string]deliveryHandler
+	filters  []string
+	qos      byte
+	broker   string
 	mu       sync.RWMutex
 	logger   *logger.Logger
+	onLost   func(err error) // Connection-lost handling, shared with SimulateDisconnect
+	manual   bool            // Messages are acknowledged by the router, not on receipt
+	lazy     []string        // Filters Start leaves to Resume
+	deliver  deliveryHandler // Dispatches messages of the filters subscribed by Start and Resume
+	paused   map[string]bool // Lazy filters currently not subscribed
+	early    []mqtt.Message  // Session messages that arrived before Start
+	withheld atomic.Int64    // Messages the router left unacknowledged since the session last resumed
+
+	backoff      Backoff       // Delays between reconnect attempts
+	ring         *brokerRing   // Order brokers are tried in
+	reconnecting atomic.Bool   // Set while reconnect runs
+	done         chan struct{} // Closed by Disconnect to stop reconnecting
+	closeOnce    sync.Once
 }
 
 // MessageHandler is a function that processes incoming MQTT messages.
 // topic is the concrete topic (e.g. "ruuvi/F0:34:..."), not the subscription filter.
 type MessageHandler func(topic string, payload []byte) error
 
+// deliveryHandler is a MessageHandler that is also told the QoS the message
+// was delivered with, whether the broker delivered a retained message
+// (sent on subscribe, not a fresh publish), and how to acknowledge it
+// (nil = acknowledged automatically).
+type deliveryHandler func(topic string, payload []byte, qos byte, retained bool, ack func()) error
+
 // Config holds MQTT client configuration.
 type Config struct {
 	Broker   string
 	ClientID string
+	Suffix   string // Appended to ClientID: "hostname", "random" or empty for none
 	Username string
 	Password string
 	QoS      byte
+	Filters  []string // Topic filters subscribed to by Start
+	Lazy     []string // Filters among Filters subscribed only once Resume is called
 	Logger   *logger.Logger
+
+	// TLS for ssl://, tls://, mqtts:// and wss:// brokers (all optional)
+	CACert             string // PEM file of CAs the broker certificate is verified against (default: system roots)
+	ClientCert         string // PEM client certificate for mutual TLS
+	ClientKey          string // PEM key of ClientCert
+	ClientKeyPassword  string // Passphrase of an encrypted ClientKey
+	InsecureSkipVerify bool   // Don't verify the broker certificate (testing only)
+	ServerName         string // Name the broker certificate is verified for (default: broker host)
+
+	// HTTP headers sent with the WebSocket handshake of ws:// and wss://
+	// brokers, e.g. for an authenticating proxy in front of the broker
+	Headers map[string]string
+	Token   string // Sent as "Authorization: Bearer <token>" with the handshake
+
+	// ManualAck acknowledges QoS 1/2 messages only once the router has written
+	// their records, in a persistent session, so the broker redelivers messages
+	// lost in a crash. Requires a stable client ID (no random suffix).
+	ManualAck bool
+
+	// PersistentSession connects with clean session off, so the broker keeps
+	// Hermod's subscriptions and queues QoS 1/2 messages published while it
+	// is offline or restarting. Implied by ManualAck. Requires a stable client
+	// ID (no random suffix).
+	PersistentSession bool
+
+	// Last Will and Testament, published by the broker when the connection
+	// drops without a clean disconnect (empty WillTopic = none)
+	WillTopic   string
+	WillPayload string
+	WillQoS     byte
+	WillRetain  bool
+
+	Reconnect Backoff // Delays between reconnect attempts after the connection drops
+
+	// Standby brokers tried when Broker can't be reached, in the given
+	// Order: "failover" (default) or "round-robin"
+	Standby []string
+	Order   string
+
+	OnConnectionLost func(err error) // Called when the broker connection drops (optional)
+	OnConnect        func()          // Called on every (re)connect (optional)
+}
+
+// Client ID suffixes
+const (
+	SuffixHostname = "hostname" // "<client_id>-<host name>"
+	SuffixRandom   = "random"   // "<client_id>-<8 random hex digits>", new on every start
+)
+
+// churnWindow is how soon after connecting a dropped connection suggests
+// another client with the same ID took over the session
+const churnWindow = 10 * time.Second
+
+// validateWill checks the Last Will settings
+func validateWill(cfg Config) error {
+	if cfg.WillTopic == "" {
+		if cfg.WillPayload != "" || cfg.WillQoS != 0 || cfg.WillRetain {
+			return errors.New("will_payload, will_qos and will_retain need will_topic")
+		}
+		return nil
+	}
+	if strings.ContainsAny(cfg.WillTopic, "+#") {
+		return fmt.Errorf("invalid will_topic %q: wildcards are not allowed", cfg.WillTopic)
+	}
+	if cfg.WillQoS > 2 {
+		return fmt.Errorf("invalid will_qos %d: use 0, 1 or 2", cfg.WillQoS)
+	}
+	return nil
+}
+
+// handshakeHeaders builds the WebSocket handshake headers of cfg, or returns
+// nil when it sets none
+func handshakeHeaders(cfg Config) (http.Header, error) {
+	if len(cfg.Headers) == 0 && cfg.Token == "" {
+		return nil, nil
+	}
+	for _, broker := range brokerURLs(cfg) {
+		if !strings.HasPrefix(broker, "ws://") && !strings.HasPrefix(broker, "wss://") {
+			return nil, fmt.Errorf("MQTT headers and token need a ws:// or wss:// broker, not %s", broker)
+		}
+	}
+	h := make(http.Header, len(cfg.Headers)+1)
+	for name, value := range cfg.Headers {
+		if name == "" || strings.ContainsAny(name, " \t\r\n:") || strings.ContainsAny(value, "\r\n") {
+			return nil, fmt.Errorf("invalid MQTT header %q", name)
+		}
+		h.Set(name, value)
+	}
+	if cfg.Token != "" {
+		if h.Get("Authorization") != "" {
+			return nil, errors.New("MQTT token and an Authorization header can't be combined")
+		}
+		h.Set("Authorization", "Bearer "+cfg.Token)
+	}
+	return h, nil
+}
+
+// EffectiveClientID returns clientID with suffix applied, so instances
+// started from the same templated config don't take over each other's
+// broker session
+func EffectiveClientID(clientID, suffix string) (string, error) {
+	var tail string
+	switch suffix {
+	case "":
+		return clientID, nil
+	case SuffixHostname:
+		host, err := os.Hostname()
+		if err != nil {
+			return "", fmt.Errorf("client ID suffix: %w", err)
+		}
+		tail = host
+	case SuffixRandom:
+		b := make([]byte, 4)
+		if _, err := rand.Read(b); err != nil {
+			return "", fmt.Errorf("client ID suffix: %w", err)
+		}
+		tail = hex.EncodeToString(b)
+	default:
+		return "", fmt.Errorf("invalid client ID suffix %q: use hostname or random", suffix)
+	}
+	if clientID == "" {
+		return tail, nil
+	}
+	return clientID + "-" + tail, nil
+}
+
+// TLSConfig builds the TLS settings of cfg, or returns nil when cfg sets
+// none so the client library's defaults apply
+func TLSConfig(cfg Config) (*tls.Config, error) {
+	if cfg.CACert == "" && cfg.ClientCert == "" && cfg.ClientKey == "" && cfg.ClientKeyPassword == "" && !cfg.InsecureSkipVerify && cfg.ServerName == "" {
+		return nil, nil
+	}
+	tc := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         cfg.ServerName,
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if cfg.CACert != "" {
+		pem, err := os.ReadFile(cfg.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read MQTT CA certificate: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in MQTT CA file %s", cfg.CACert)
+		}
+		tc.RootCAs = pool
+	}
+	if (cfg.ClientCert == "") != (cfg.ClientKey == "") {
+		return nil, errors.New("MQTT client_cert and client_key must be set together")
+	}
+	if cfg.ClientKeyPassword != "" && cfg.ClientKey == "" {
+		return nil, errors.New("MQTT client_key_password requires client_cert and client_key")
+	}
+	if cfg.ClientCert != "" {
+		cert, err := loadClientCert(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load MQTT client certificate: %w", err)
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+	return tc, nil
+}
+
+// loadClientCert loads the client certificate and its key, decrypting the
+// key with ClientKeyPassword when one is set. Encrypted keys must be PKCS#8
+// ("ENCRYPTED PRIVATE KEY"); OpenSSL's legacy PEM encryption (Proc-Type:
+// 4,ENCRYPTED) is rejected, since it derives the key with a single MD5 round
+// and can't be authenticated.
+func loadClientCert(cfg Config) (tls.Certificate, error) {
+	if cfg.ClientKeyPassword == "" {
+		return tls.LoadX509KeyPair(cfg.ClientCert, cfg.ClientKey)
+	}
+	certPEM, err := os.ReadFile(cfg.ClientCert)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := os.ReadFile(cfg.ClientKey)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	switch {
+	case block == nil:
+		return tls.Certificate{}, fmt.Errorf("no PEM key found in %s", cfg.ClientKey)
+	case block.Headers["Proc-Type"] == "4,ENCRYPTED":
+		return tls.Certificate{}, fmt.Errorf("%s uses legacy PEM encryption, which isn't supported: convert it with openssl pkcs8 -topk8 -v2 aes-256-cbc", cfg.ClientKey)
+	case block.Type != "ENCRYPTED PRIVATE KEY":
+		return tls.Certificate{}, fmt.Errorf("client_key_password is set but %s isn't encrypted", cfg.ClientKey)
+	}
+	der, err := decryptPKCS8(block.Bytes, cfg.ClientKeyPassword)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to decrypt %s: %w", cfg.ClientKey, err)
+	}
+	return tls.X509KeyPair(certPEM, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
 }
 
 // New creates a new MQTT client.
@@ -38,62 +271,242 @@ func New(cfg Config) (*Client, error) {
 	if log == nil {
 		log = logger.New(logger.INFO)
 	}
+	clientID, err := EffectiveClientID(cfg.ClientID, cfg.Suffix)
+	if err != nil {
+		return nil, err
+	}
+	persistent := cfg.PersistentSession || cfg.ManualAck
+	if persistent && (cfg.ClientID == "" || cfg.Suffix == SuffixRandom) {
+		if cfg.ManualAck {
+			return nil, errors.New("manual acknowledgment needs a persistent session: set client_id and don't use a random suffix")
+		}
+		return nil, errors.New("a persistent session needs a stable client ID: set client_id and don't use a random suffix")
+	}
 
-	opts := mqtt.NewClientOptions().
-		AddBroker(cfg.Broker).
-		SetClientID(cfg.ClientID).
+	tlsCfg, err := TLSConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateWill(cfg); err != nil {
+		return nil, err
+	}
+	headers, err := handshakeHeaders(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Reconnect.validate(); err != nil {
+		return nil, err
+	}
+	if err := validateOrder(cfg.Order); err != nil {
+		return nil, err
+	}
+
+	opts := mqtt.NewClientOptions()
+	for _, broker := range brokerURLs(cfg) {
+		opts.AddBroker(broker)
+	}
+	opts.SetClientID(clientID).
 		SetUsername(cfg.Username).
 		SetPassword(cfg.Password).
-		SetCleanSession(true).
-		SetAutoReconnect(true).
+		SetCleanSession(!persistent).
+		SetAutoAckDisabled(cfg.ManualAck).
+		SetAutoReconnect(false). // Reconnects follow cfg.Reconnect; see reconnect
 		SetConnectTimeout(10 * time.Second).
 		SetKeepAlive(60 * time.Second)
+	if tlsCfg != nil {
+		opts.SetTLSConfig(tlsCfg)
+	}
+	if headers != nil {
+		opts.SetHTTPHeaders(headers)
+	}
+	if cfg.WillTopic != "" {
+		opts.SetWill(cfg.WillTopic, cfg.WillPayload, cfg.WillQoS, cfg.WillRetain)
+	}
+
+	c := &Client{
+		handlers: make(map[string]deliveryHandler),
+		filters:  cfg.Filters,
+		qos:      cfg.QoS,
+		broker:   cfg.Broker,
+		logger:   log,
+		manual:   cfg.ManualAck,
+		lazy:     cfg.Lazy,
+		backoff:  cfg.Reconnect.withDefaults(),
+		ring:     &brokerRing{servers: opts.Servers, rotate: cfg.Order == OrderRoundRobin},
+		done:     make(chan struct{}),
+	}
 
+	// A persistent session's queued messages arrive right after connecting,
+	// before Start has subscribed; they are held until Start
+	opts.SetDefaultPublishHandler(func(_ mqtt.Client, msg mqtt.Message) {
+		c.route(msg)
+	})
+
+	// Brokers are tried one at a time, so the last one tried is the one connected to
+	var attempted atomic.Pointer[string]
+	opts.SetConnectionAttemptHandler(func(broker *url.URL, tlsCfg *tls.Config) *tls.Config {
+		s := broker.String()
+		attempted.Store(&s)
+		return tlsCfg
+	})
+
+	var connectedAt atomic.Int64
 	opts.OnConnect = func(_ mqtt.Client) {
-		log.Info("Connected to MQTT broker")
+		reconnect := connectedAt.Swap(time.Now().UnixNano()) != 0
+		if broker := attempted.Load(); broker != nil {
+			log.Infof("Connected to MQTT broker %s", *broker)
+		} else {
+			log.Info("Connected to MQTT broker")
+		}
+		if reconnect {
+			// A clean session (or an expired persistent one) starts without subscriptions
+			c.resubscribe()
+		}
+		if cfg.OnConnect != nil {
+			cfg.OnConnect()
+		}
 	}
-	opts.OnConnectionLost = func(_ mqtt.Client, err error) {
+	lost := func(err error) {
 		log.Errorf("MQTT connection lost: %v", err)
+		if since := time.Since(time.Unix(0, connectedAt.Load())); since < churnWindow {
+			log.Warnf("MQTT connection dropped %s after connecting; another client may be using ID %q (see client_id_suffix)",
+				since.Round(time.Millisecond), clientID)
+		}
+		if cfg.OnConnectionLost != nil {
+			cfg.OnConnectionLost(err)
+		}
+	}
+	opts.OnConnectionLost = func(_ mqtt.Client, err error) {
+		lost(err)
+		go c.reconnect()
 	}
 
-	cl := mqtt.NewClient(opts)
-	if token := cl.Connect(); token.Wait() && token.Error() != nil {
+	log.Infof("Connecting to MQTT broker %s as client %s", strings.Join(brokerURLs(cfg), ", "), clientID)
+	c.client = mqtt.NewClient(opts)
+	c.onLost = lost
+	if token := c.client.Connect(); token.Wait() && token.Error() != nil {
 		return nil, fmt.Errorf("failed to connect to MQTT broker: %w", token.Error())
 	}
+	return c, nil
+}
 
-	return &Client{
-		client:   cl,
-		handlers: make(map[string]MessageHandler),
-		logger:   log,
-	}, nil
+// Start subscribes to the configured filters and delivers messages to dispatch.
+// Lazy filters wait for Resume.
+func (c *Client) Start(ctx context.Context, dispatch func(router.Message) error) error {
+	deliver := func(topic string, payload []byte, qos byte, retained bool, ack func()) error {
+		msg := router.Message{
+			Topic:   topic,
+			Payload: payload,
+			QoS:     qos,
+			Retain:  retained,
+			Time:    time.Now().UTC(),
+			Source:  c.broker,
+			Ack:     ack,
+		}
+		if ack != nil {
+			msg.Nack = func() { c.withheld.Add(1) }
+		}
+		dispatch(msg)
+		return nil
+	}
+	c.mu.Lock()
+	c.deliver = deliver
+	c.paused = make(map[string]bool, len(c.lazy))
+	for _, filter := range c.lazy {
+		c.paused[filter] = true
+	}
+	// Session messages may keep arriving while subscribing
+	for _, filter := range c.filters {
+		if !c.paused[filter] {
+			c.handlers[filter] = deliver
+		}
+	}
+	early := c.early
+	c.early = nil
+	c.mu.Unlock()
+
+	for _, filter := range c.filters {
+		if slices.Contains(c.lazy, filter) {
+			continue
+		}
+		if err := c.subscribe(filter, c.qos, deliver); err != nil {
+			return err
+		}
+	}
+	if len(early) > 0 {
+		c.logger.Infof("Delivering %d messages queued in the MQTT session", len(early))
+	}
+	for _, msg := range early {
+		c.route(msg)
+	}
+	return nil
+}
+
+// Pause unsubscribes from the lazy filter, so the broker stops delivering
+// messages Hermod couldn't store. Messages on the filter that still arrive
+// are left unacknowledged (manual_ack) for Redeliver. Filters that aren't
+// lazy are left alone.
+// Start must have been called.
+func (c *Client) Pause(filter string) {
+	if !slices.Contains(c.lazy, filter) {
+		return
+	}
+	c.mu.Lock()
+	paused := c.paused[filter]
+	c.paused[filter] = true
+	delete(c.handlers, filter)
+	c.mu.Unlock()
+	if !paused {
+		if err := c.unsubscribe(filter); err != nil {
+			c.logger.Errorf("Failed to pause topic filter %s: %v", filter, err)
+			return
+		}
+		c.logger.Infof("Paused topic filter: %s", filter)
+	}
+}
+
+// Resume subscribes to the lazy filter unless it is subscribed already.
+// Start must have been called.
+func (c *Client) Resume(filter string) error {
+	if !slices.Contains(c.lazy, filter) {
+		return nil
+	}
+	c.mu.Lock()
+	paused := c.paused[filter]
+	c.paused[filter] = false
+	deliver := c.deliver
+	c.mu.Unlock()
+	if !paused {
+		return nil
+	}
+	if err := c.subscribe(filter, c.qos, deliver); err != nil {
+		c.mu.Lock()
+		c.paused[filter] = true
+		c.mu.Unlock()
+		return err
+	}
+	return nil
 }
 
 // Subscribe subscribes to an MQTT topic filter (supports + and #) with a handler.
 // Example filters: "ruuvi/+", "ruuvi/#", "#".
 func (c *Client) Subscribe(filter string, qos byte, handler MessageHandler) error {
+	return c.subscribe(filter, qos, func(topic string, payload []byte, _ byte, _ bool, ack func()) error {
+		if ack != nil {
+			defer ack()
+		}
+		return handler(topic, payload)
+	})
+}
+
+// subscribe is Subscribe with the delivery QoS and retained flag passed to the handler
+func (c *Client) subscribe(filter string, qos byte, handler deliveryHandler) error {
 	c.mu.Lock()
 	c.handlers[filter] = handler
 	c.mu.Unlock()
 
 	token := c.client.Subscribe(filter, qos, func(_ mqtt.Client, msg mqtt.Message) {
-		topic := msg.Topic()
-
-		// Dispatch based on filter matching, NOT exact topic equality.
-		c.mu.RLock()
-		defer c.mu.RUnlock()
-
-		// Call the first matching handler (common pattern).
-		for f, h := range c.handlers {
-			if topicMatches(f, topic) {
-				if err := h(topic, msg.Payload()); err != nil {
-					c.logger.Errorf("Error processing message from topic %s: %v", topic, err)
-				}
-				return
-			}
-		}
-
-		// see "unhandled" topics during debug.
-		c.logger.Debugf("No handler matched topic=%s", topic)
+		c.route(msg)
 	})
 
 	token.Wait()
@@ -105,14 +518,197 @@ func (c *Client) Subscribe(filter string, qos byte, handler MessageHandler) erro
 	return nil
 }
 
+// route passes msg to the handler of the first filter matching its topic.
+// Messages arriving before Start are held for it.
+func (c *Client) route(msg mqtt.Message) {
+	topic := msg.Topic()
+
+	// Dispatch based on filter matching, NOT exact topic equality.
+	c.mu.Lock()
+	if c.deliver == nil {
+		c.early = append(c.early, msg)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	// The handler acknowledges QoS 1/2 messages itself in manual mode
+	var ack func()
+	if c.manual && msg.Qos() > 0 {
+		ack = msg.Ack
+	}
+
+	// Call the first matching handler (common pattern).
+	for f, h := range c.handlers {
+		if topicMatches(f, topic) {
+			if err := h(topic, msg.Payload(), msg.Qos(), msg.Retained(), ack); err != nil {
+				c.logger.Errorf("Error processing message from topic %s: %v", topic, err)
+			}
+			return
+		}
+	}
+
+	// A paused lazy filter's messages can still arrive while it is being
+	// unsubscribed (or if unsubscribing failed). The database is down then,
+	// so leave them unacknowledged for Redeliver instead of dropping them.
+	for f, paused := range c.paused {
+		if paused && topicMatches(f, topic) {
+			c.logger.Debugf("Withholding message on paused filter %s: topic=%s", f, topic)
+			if ack != nil {
+				c.withheld.Add(1)
+			}
+			return
+		}
+	}
+
+	// see "unhandled" topics during debug, e.g. from filters a persistent
+	// session still holds after they were removed from the configuration.
+	c.logger.Debugf("No handler matched topic=%s", topic)
+	if ack != nil {
+		ack()
+	}
+}
+
+// resubscribe subscribes every filter with a handler again after a reconnect
+func (c *Client) resubscribe() {
+	c.mu.RLock()
+	handlers := make(map[string]deliveryHandler, len(c.handlers))
+	for filter, h := range c.handlers {
+		handlers[filter] = h
+	}
+	c.mu.RUnlock()
+	for filter, h := range handlers {
+		if err := c.subscribe(filter, c.qos, h); err != nil {
+			c.logger.Errorf("Failed to resubscribe after reconnecting: %v", err)
+		}
+	}
+}
+
+// Loopback subscribes to topic, publishes a random probe to it and waits
+// until the broker delivers the probe back, proving both directions work.
+func (c *Client) Loopback(ctx context.Context, topic string) error {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Errorf("loopback probe: %w", err)
+	}
+	probe := hex.EncodeToString(b)
+
+	delivered := make(chan struct{}, 1)
+	err := c.Subscribe(topic, c.qos, func(_ string, payload []byte) error {
+		if string(payload) == probe {
+			select {
+			case delivered <- struct{}{}:
+			default:
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	defer c.unsubscribe(topic)
+
+	if err := c.Publish(topic, []byte(probe)); err != nil {
+		return err
+	}
+	select {
+	case <-delivered:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("probe published to %s was not delivered back: %w", topic, ctx.Err())
+	}
+}
+
+// unsubscribe removes a topic filter and its handler
+func (c *Client) unsubscribe(filter string) error {
+	token := c.client.Unsubscribe(filter)
+	token.Wait()
+	c.mu.Lock()
+	delete(c.handlers, filter)
+	c.mu.Unlock()
+	if err := token.Error(); err != nil {
+		return fmt.Errorf("failed to unsubscribe from topic %s: %w", filter, err)
+	}
+	return nil
+}
+
+// Publish publishes a payload to a topic using the configured QoS.
+func (c *Client) Publish(topic string, payload []byte) error {
+	return c.PublishMessage(topic, payload, c.qos, false)
+}
+
+// PublishMessage publishes a payload to a topic with the given QoS and
+// retain flag, waiting until the broker has it (QoS 1 and 2).
+func (c *Client) PublishMessage(topic string, payload []byte, qos byte, retain bool) error {
+	token := c.client.Publish(topic, qos, retain, payload)
+	token.Wait()
+	if token.Error() != nil {
+		return fmt.Errorf("failed to publish to topic %s: %w", topic, token.Error())
+	}
+	return nil
+}
+
 // Disconnect disconnects from the MQTT broker.
 func (c *Client) Disconnect() {
+	c.closeOnce.Do(func() { close(c.done) })
 	if c.client != nil && c.client.IsConnected() {
 		c.client.Disconnect(250)
 	}
 	c.logger.Info("Disconnected from the MQTT broker")
 }
 
+// SimulateDisconnect drops the broker connection as an outage would, then
+// reconnects, resubscribing every filter (used by chaos testing).
+func (c *Client) SimulateDisconnect() {
+	c.client.Disconnect(0)
+	c.onLost(errors.New("chaos: simulated broker disconnect"))
+
+	if token := c.connect(); token.Wait() && token.Error() != nil {
+		c.logger.Errorf("Failed to reconnect to MQTT broker: %v", token.Error())
+	}
+}
+
+// Withheld reports how many messages the router has left unacknowledged
+// since the session last resumed (manual_ack). They hold places in the
+// broker's in-flight window until Redeliver.
+func (c *Client) Withheld() int {
+	return int(c.withheld.Load())
+}
+
+// Redeliver reconnects when messages were left unacknowledged, since an
+// MQTT 3.1.1 broker redelivers them only when the session resumes, not on
+// the live connection. Call it once whatever kept them from being stored
+// (a database outage, schema drift, a full queue) is over.
+func (c *Client) Redeliver() {
+	n := c.withheld.Swap(0)
+	if n == 0 {
+		return
+	}
+	select {
+	case <-c.done:
+		return
+	default:
+	}
+	if !c.client.IsConnected() {
+		// Reconnecting already resumes the session
+		return
+	}
+	c.logger.Infof("Reconnecting so the MQTT broker redelivers %d unacknowledged messages", n)
+	c.client.Disconnect(250)
+	if token := c.connect(); token.Wait() && token.Error() != nil {
+		c.logger.Errorf("Failed to reconnect to MQTT broker: %v", token.Error())
+		c.onLost(token.Error())
+		go c.reconnect()
+	}
+}
+
+// Close disconnects from the MQTT broker.
+func (c *Client) Close() {
+	c.Disconnect()
+}
+
 // topicMatches returns true if a subscription filter matches a concrete topic.
 // Supports MQTT wildcards: '+' (single level) and '#' (multi level, only last).
 //
@@ -1,7 +1,32 @@
 package mqtt
 
 import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/router"
 )
 
 func TestConfig(t *testing.T) {
@@ -93,3 +118,451 @@ func TestMessageHandlerReturnsNil(t *testing.T) {
 // Note: Testing New(), Subscribe(), and Disconnect() would require a real MQTT broker
 // or a mock MQTT client, which is beyond the scope of unit tests.
 // These should be tested with integration tests that have access to a test broker.
+
+func TestEffectiveClientID(t *testing.T) {
+	if id, err := EffectiveClientID("hermod", ""); err != nil || id != "hermod" {
+		t.Errorf("no suffix = %q, %v; want hermod", id, err)
+	}
+
+	host, _ := os.Hostname()
+	if id, err := EffectiveClientID("hermod", SuffixHostname); err != nil || id != "hermod-"+host {
+		t.Errorf("hostname suffix = %q, %v; want hermod-%s", id, err, host)
+	}
+
+	a, err := EffectiveClientID("hermod", SuffixRandom)
+	if err != nil || !strings.HasPrefix(a, "hermod-") || len(a) != len("hermod-")+8 {
+		t.Errorf("random suffix = %q, %v", a, err)
+	}
+	if b, _ := EffectiveClientID("hermod", SuffixRandom); a == b {
+		t.Errorf("random suffix repeated: %q", a)
+	}
+	if id, _ := EffectiveClientID("", SuffixRandom); len(id) != 8 {
+		t.Errorf("random suffix without client ID = %q, want 8 hex digits", id)
+	}
+
+	if _, err := EffectiveClientID("hermod", "pid"); err == nil {
+		t.Error("Expected error for unknown suffix")
+	}
+}
+
+func TestManualAckNeedsStableClientID(t *testing.T) {
+	for _, cfg := range []Config{
+		{Broker: "tcp://127.0.0.1:1", ManualAck: true},
+		{Broker: "tcp://127.0.0.1:1", ClientID: "hermod", Suffix: SuffixRandom, ManualAck: true},
+	} {
+		_, err := New(cfg)
+		if err == nil || !strings.Contains(err.Error(), "persistent session") {
+			t.Errorf("New(%+v) error = %v, want persistent session error", cfg, err)
+		}
+	}
+}
+
+func TestPersistentSessionNeedsStableClientID(t *testing.T) {
+	cfg := Config{Broker: "tcp://127.0.0.1:1", PersistentSession: true}
+	if _, err := New(cfg); err == nil || !strings.Contains(err.Error(), "stable client ID") {
+		t.Errorf("New(%+v) error = %v, want stable client ID error", cfg, err)
+	}
+}
+
+// stubClient accepts subscriptions without a broker
+type stubClient struct {
+	mqtt.Client
+	subscribed []string
+}
+
+func (s *stubClient) Subscribe(topic string, qos byte, callback mqtt.MessageHandler) mqtt.Token {
+	s.subscribed = append(s.subscribed, topic)
+	return &mqtt.DummyToken{}
+}
+
+// stubMessage is a message delivered by the broker
+type stubMessage struct {
+	mqtt.Message
+	topic string
+	qos   byte
+}
+
+func (m stubMessage) Topic() string   { return m.topic }
+func (m stubMessage) Qos() byte       { return m.qos }
+func (m stubMessage) Retained() bool  { return false }
+func (m stubMessage) Payload() []byte { return []byte(`{}`) }
+func (m stubMessage) Ack()            {}
+
+func TestSessionMessagesBeforeStart(t *testing.T) {
+	stub := &stubClient{}
+	c := &Client{
+		client:   stub,
+		handlers: make(map[string]deliveryHandler),
+		filters:  []string{"sensors/+"},
+		qos:      1,
+		logger:   logger.New(logger.ERROR),
+	}
+
+	// Queued session messages arrive as soon as the client connects
+	c.route(stubMessage{topic: "sensors/a", qos: 1})
+	c.route(stubMessage{topic: "sensors/b", qos: 1})
+
+	var got []string
+	err := c.Start(context.Background(), func(msg router.Message) error {
+		got = append(got, msg.Topic)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	c.route(stubMessage{topic: "sensors/c", qos: 1})
+
+	if strings.Join(got, ",") != "sensors/a,sensors/b,sensors/c" {
+		t.Errorf("Expected session messages delivered after Start, got %v", got)
+	}
+
+	// A reconnect subscribes again
+	c.resubscribe()
+	if strings.Join(stub.subscribed, ",") != "sensors/+,sensors/+" {
+		t.Errorf("Expected the filter subscribed on start and reconnect, got %v", stub.subscribed)
+	}
+}
+
+// writeTestCert writes a self-signed certificate and its key as PEM files
+func writeTestCert(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "broker.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestTLSConfig(t *testing.T) {
+	if tc, err := TLSConfig(Config{Broker: "tcp://localhost:1883"}); tc != nil || err != nil {
+		t.Errorf("TLSConfig() without TLS settings = %v, %v; want nil, nil", tc, err)
+	}
+
+	certFile, keyFile := writeTestCert(t)
+	tc, err := TLSConfig(Config{CACert: certFile, ClientCert: certFile, ClientKey: keyFile, ServerName: "broker.test"})
+	if err != nil {
+		t.Fatalf("TLSConfig() error = %v", err)
+	}
+	if tc.RootCAs == nil || len(tc.Certificates) != 1 || tc.ServerName != "broker.test" || tc.InsecureSkipVerify {
+		t.Errorf("Unexpected TLS config: %+v", tc)
+	}
+	if tc, err := TLSConfig(Config{InsecureSkipVerify: true}); err != nil || !tc.InsecureSkipVerify {
+		t.Errorf("TLSConfig(insecure) = %v, %v", tc, err)
+	}
+
+	for name, cfg := range map[string]Config{
+		"missing CA":       {CACert: filepath.Join(t.TempDir(), "missing.pem")},
+		"CA without PEM":   {CACert: keyFile},
+		"cert without key": {ClientCert: certFile},
+		"key without cert": {ClientKey: keyFile},
+		"mismatched pair":  {ClientCert: certFile, ClientKey: certFile},
+	} {
+		if _, err := TLSConfig(cfg); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+// encryptPKCS8 encrypts a PKCS#8 key the way openssl pkcs8 -topk8 -v2
+// aes-256-cbc does (PBES2, PBKDF2 with HMAC-SHA256)
+func encryptPKCS8(t *testing.T, der []byte, password string) []byte {
+	t.Helper()
+	salt, iv := make([]byte, 8), make([]byte, aes.BlockSize)
+	rand.Read(salt)
+	rand.Read(iv)
+	key, err := pbkdf2.Key(sha256.New, password, salt, 2048, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, _ := aes.NewCipher(key)
+	pad := aes.BlockSize - len(der)%aes.BlockSize
+	data := append(bytes.Clone(der), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	marshal := func(v interface{}) asn1.RawValue {
+		b, err := asn1.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return asn1.RawValue{FullBytes: b}
+	}
+	kdf := pbkdf2Params{Salt: salt, Iterations: 2048, PRF: pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue}}
+	params := pbes2Params{
+		KeyDerivation: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: marshal(kdf)},
+		Encryption:    pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: marshal(iv)},
+	}
+	out, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: marshal(params)},
+		Data:      data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return out
+}
+
+func TestTLSConfigEncryptedKey(t *testing.T) {
+	certFile, keyFile := writeTestCert(t)
+	keyPEM, err := os.ReadFile(keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plain, _ := pem.Decode(keyPEM)
+	key, err := x509.ParseECPrivateKey(plain.Bytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	encryptedFile := filepath.Join(dir, "encrypted.pem")
+	os.WriteFile(encryptedFile, pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encryptPKCS8(t, der, "s3cret")}), 0o600)
+
+	tc, err := TLSConfig(Config{ClientCert: certFile, ClientKey: encryptedFile, ClientKeyPassword: "s3cret"})
+	if err != nil {
+		t.Fatalf("TLSConfig() error = %v", err)
+	}
+	if len(tc.Certificates) != 1 {
+		t.Errorf("Expected the client certificate loaded, got %+v", tc)
+	}
+
+	legacyFile := filepath.Join(dir, "legacy.pem")
+	legacy := &pem.Block{Type: "EC PRIVATE KEY", Headers: map[string]string{"Proc-Type": "4,ENCRYPTED", "DEK-Info": "AES-256-CBC,00112233445566778899AABBCCDDEEFF"}, Bytes: plain.Bytes}
+	os.WriteFile(legacyFile, pem.EncodeToMemory(legacy), 0o600)
+	damagedFile := filepath.Join(dir, "damaged.pem")
+	os.WriteFile(damagedFile, pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: []byte{0}}), 0o600)
+	for name, tt := range map[string]struct {
+		cfg  Config
+		want string
+	}{
+		"without password":       {Config{ClientCert: certFile, ClientKey: encryptedFile}, "failed to load"},
+		"wrong password":         {Config{ClientCert: certFile, ClientKey: encryptedFile, ClientKeyPassword: "wrong"}, "wrong password"},
+		"unencrypted key":        {Config{ClientCert: certFile, ClientKey: keyFile, ClientKeyPassword: "s3cret"}, "isn't encrypted"},
+		"legacy encryption":      {Config{ClientCert: certFile, ClientKey: legacyFile, ClientKeyPassword: "s3cret"}, "legacy PEM encryption"},
+		"damaged PKCS#8":         {Config{ClientCert: certFile, ClientKey: damagedFile, ClientKeyPassword: "s3cret"}, "invalid encrypted PKCS#8"},
+		"password without a key": {Config{ClientKeyPassword: "s3cret"}, "requires client_cert"},
+	} {
+		if _, err := TLSConfig(tt.cfg); err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: expected an error containing %q, got %v", name, tt.want, err)
+		}
+	}
+}
+
+func TestValidateWill(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr bool
+	}{
+		{"none", Config{}, false},
+		{"topic only", Config{WillTopic: "hermod/edge01/status"}, false},
+		{"full", Config{WillTopic: "hermod/edge01/status", WillPayload: "offline", WillQoS: 1, WillRetain: true}, false},
+		{"payload without topic", Config{WillPayload: "offline"}, true},
+		{"retain without topic", Config{WillRetain: true}, true},
+		{"wildcard", Config{WillTopic: "hermod/+/status"}, true},
+		{"qos", Config{WillTopic: "hermod/status", WillQoS: 3}, true},
+	}
+	for _, tt := range tests {
+		if err := validateWill(tt.cfg); (err != nil) != tt.wantErr {
+			t.Errorf("%s: validateWill() error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHandshakeHeaders(t *testing.T) {
+	h, err := handshakeHeaders(Config{Broker: "wss://broker:443/mqtt", Headers: map[string]string{"x-api-key": "k1"}, Token: "t0k"})
+	if err != nil {
+		t.Fatalf("handshakeHeaders() error = %v", err)
+	}
+	if h.Get("X-Api-Key") != "k1" || h.Get("Authorization") != "Bearer t0k" {
+		t.Errorf("headers = %v", h)
+	}
+	if h, err := handshakeHeaders(Config{Broker: "wss://broker:443/mqtt"}); h != nil || err != nil {
+		t.Errorf("Expected no headers by default, got %v, %v", h, err)
+	}
+
+	for _, cfg := range []Config{
+		{Broker: "tcp://broker:1883", Token: "t0k"},
+		{Broker: "ssl://broker:8883", Headers: map[string]string{"X-Api-Key": "k1"}},
+		{Broker: "ws://broker:80", Headers: map[string]string{"Bad Header": "v"}},
+		{Broker: "ws://broker:80", Headers: map[string]string{"X-Api-Key": "v\r\nX-Evil: 1"}},
+		{Broker: "ws://broker:80", Headers: map[string]string{"authorization": "Basic abc"}, Token: "t0k"},
+	} {
+		if _, err := handshakeHeaders(cfg); err == nil {
+			t.Errorf("handshakeHeaders(%+v) expected error", cfg)
+		}
+	}
+}
+
+func TestWebSocketHandshakeSendsHeaders(t *testing.T) {
+	got := make(chan http.Header, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got <- r.Header.Clone()
+		http.Error(w, "denied", http.StatusUnauthorized)
+	}))
+	defer srv.Close()
+
+	_, err := New(Config{
+		Broker:  "ws://" + srv.Listener.Addr().String() + "/mqtt",
+		Headers: map[string]string{"X-Api-Key": "k1"},
+		Token:   "t0k",
+		Logger:  logger.New(logger.ERROR),
+	})
+	if err == nil {
+		t.Fatal("Expected the rejected handshake to fail the connection")
+	}
+	select {
+	case h := <-got:
+		if h.Get("X-Api-Key") != "k1" || h.Get("Authorization") != "Bearer t0k" {
+			t.Errorf("handshake headers = %v", h)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("No WebSocket handshake received")
+	}
+}
+
+// sessionClient is a connected client that counts session resumes
+type sessionClient struct {
+	stubClient
+	disconnects int
+	connects    int
+}
+
+func (s *sessionClient) IsConnected() bool       { return true }
+func (s *sessionClient) Disconnect(quiesce uint) { s.disconnects++ }
+func (s *sessionClient) Connect() mqtt.Token {
+	s.connects++
+	return &mqtt.DummyToken{}
+}
+
+func TestRedeliverResumesSession(t *testing.T) {
+	stub := &sessionClient{}
+	c := &Client{
+		client:   stub,
+		handlers: make(map[string]deliveryHandler),
+		filters:  []string{"sensors/+"},
+		qos:      1,
+		manual:   true,
+		logger:   logger.New(logger.ERROR),
+		ring:     &brokerRing{},
+		done:     make(chan struct{}),
+	}
+	var msgs []router.Message
+	err := c.Start(context.Background(), func(msg router.Message) error {
+		msgs = append(msgs, msg)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+
+	// Nothing withheld, nothing to resume
+	c.Redeliver()
+	if stub.connects != 0 {
+		t.Fatal("Expected no reconnect without withheld messages")
+	}
+
+	c.route(stubMessage{topic: "sensors/a", qos: 1})
+	c.route(stubMessage{topic: "sensors/b", qos: 1})
+	msgs[0].Nack()
+	msgs[1].Ack()
+	if c.Withheld() != 1 {
+		t.Fatalf("Expected 1 withheld message, got %d", c.Withheld())
+	}
+	c.Redeliver()
+	if stub.disconnects != 1 || stub.connects != 1 || c.Withheld() != 0 {
+		t.Errorf("Expected one session resume, got %d disconnects, %d connects, %d withheld",
+			stub.disconnects, stub.connects, c.Withheld())
+	}
+}
+
+// failingUnsubscribeClient can't unsubscribe
+type failingUnsubscribeClient struct {
+	sessionClient
+}
+
+func (s *failingUnsubscribeClient) Unsubscribe(topics ...string) mqtt.Token {
+	return &failedToken{err: errors.New("not connected")}
+}
+
+// failedToken is a completed token carrying an error
+type failedToken struct {
+	mqtt.DummyToken
+	err error
+}
+
+func (t *failedToken) Error() error { return t.err }
+
+// ackedMessage counts its acknowledgements
+type ackedMessage struct {
+	stubMessage
+	acks *int
+}
+
+func (m ackedMessage) Ack() { *m.acks++ }
+
+func TestPausedFilterWithholdsMessages(t *testing.T) {
+	stub := &failingUnsubscribeClient{}
+	c := &Client{
+		client:   stub,
+		handlers: make(map[string]deliveryHandler),
+		filters:  []string{"sensors/+"},
+		lazy:     []string{"sensors/+"},
+		qos:      1,
+		manual:   true,
+		logger:   logger.New(logger.ERROR),
+		ring:     &brokerRing{},
+		done:     make(chan struct{}),
+	}
+	var delivered int
+	err := c.Start(context.Background(), func(msg router.Message) error {
+		delivered++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Start() error = %v", err)
+	}
+	if err := c.Resume("sensors/+"); err != nil {
+		t.Fatalf("Resume() error = %v", err)
+	}
+
+	// Unsubscribing fails, so the broker keeps delivering while paused
+	c.Pause("sensors/+")
+	acks := 0
+	c.route(ackedMessage{stubMessage: stubMessage{topic: "sensors/a", qos: 1}, acks: &acks})
+	if delivered != 0 || acks != 0 || c.Withheld() != 1 {
+		t.Errorf("Expected the message withheld unacknowledged, got %d delivered, %d acks, %d withheld",
+			delivered, acks, c.Withheld())
+	}
+
+	// Topics no filter wants are still acknowledged
+	c.route(ackedMessage{stubMessage: stubMessage{topic: "other/a", qos: 1}, acks: &acks})
+	if acks != 1 {
+		t.Errorf("Expected the unmatched message acknowledged, got %d acks", acks)
+	}
+}
@@ -0,0 +1,145 @@
+package mqtt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"hash"
+)
+
+// OIDs of the PKCS#5 v2 (PBES2) schemes encrypted PKCS#8 keys use, as
+// written by openssl pkcs8 -topk8 and openssl genpkey -aes256
+var (
+	oidPBES2  = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+
+	oidAES128CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// encryptedPrivateKeyInfo is the "ENCRYPTED PRIVATE KEY" PEM block (RFC 5208)
+type encryptedPrivateKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Data      []byte
+}
+
+// pbes2Params are the parameters of the PBES2 scheme (RFC 8018)
+type pbes2Params struct {
+	KeyDerivation pkix.AlgorithmIdentifier
+	Encryption    pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params are the parameters of PBKDF2 (RFC 8018)
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	KeyLength  int                      `asn1:"optional"`
+	PRF        pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// pkcs8Hash returns the hash of a PBKDF2 pseudo-random function (HMAC-SHA1
+// when none is given)
+func pkcs8Hash(prf asn1.ObjectIdentifier) (func() hash.Hash, error) {
+	switch {
+	case len(prf) == 0 || prf.Equal(oidHMACWithSHA1):
+		return sha1.New, nil
+	case prf.Equal(oidHMACWithSHA256):
+		return sha256.New, nil
+	case prf.Equal(oidHMACWithSHA384):
+		return sha512.New384, nil
+	case prf.Equal(oidHMACWithSHA512):
+		return sha512.New, nil
+	}
+	return nil, fmt.Errorf("unsupported PBKDF2 function %s", prf)
+}
+
+// pkcs8KeySize returns the key size of a PBES2 cipher
+func pkcs8KeySize(alg asn1.ObjectIdentifier) (int, error) {
+	switch {
+	case alg.Equal(oidAES128CBC):
+		return 16, nil
+	case alg.Equal(oidAES192CBC):
+		return 24, nil
+	case alg.Equal(oidAES256CBC):
+		return 32, nil
+	}
+	return 0, fmt.Errorf("unsupported cipher %s (use AES-CBC)", alg)
+}
+
+// decryptPKCS8 decrypts an encrypted PKCS#8 key (PBES2 with PBKDF2 and
+// AES-CBC) and returns the plain PKCS#8 DER
+func decryptPKCS8(der []byte, password string) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if _, err := asn1.Unmarshal(der, &info); err != nil {
+		return nil, fmt.Errorf("invalid encrypted PKCS#8 key: %w", err)
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("unsupported PKCS#8 encryption %s (use PBES2, e.g. openssl pkcs8 -topk8 -v2 aes-256-cbc)", info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if _, err := asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, fmt.Errorf("invalid PBES2 parameters: %w", err)
+	}
+	if !params.KeyDerivation.Algorithm.Equal(oidPBKDF2) {
+		return nil, fmt.Errorf("unsupported key derivation %s (use PBKDF2)", params.KeyDerivation.Algorithm)
+	}
+	var kdf pbkdf2Params
+	if _, err := asn1.Unmarshal(params.KeyDerivation.Parameters.FullBytes, &kdf); err != nil {
+		return nil, fmt.Errorf("invalid PBKDF2 parameters: %w", err)
+	}
+	h, err := pkcs8Hash(kdf.PRF.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	size, err := pkcs8KeySize(params.Encryption.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	if kdf.KeyLength != 0 && kdf.KeyLength != size {
+		return nil, fmt.Errorf("invalid PBKDF2 key length %d for a %d-byte cipher key", kdf.KeyLength, size)
+	}
+	var iv []byte
+	if _, err := asn1.Unmarshal(params.Encryption.Parameters.FullBytes, &iv); err != nil || len(iv) != aes.BlockSize {
+		return nil, errors.New("invalid AES-CBC IV")
+	}
+	if len(info.Data) == 0 || len(info.Data)%aes.BlockSize != 0 {
+		return nil, errors.New("invalid encrypted key length")
+	}
+
+	key, err := pbkdf2.Key(h, password, kdf.Salt, kdf.Iterations, size)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plain := make([]byte, len(info.Data))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plain, info.Data)
+
+	// A wrong password shows up as bad padding or a key that doesn't parse
+	pad := int(plain[len(plain)-1])
+	if pad == 0 || pad > aes.BlockSize || !bytes.Equal(plain[len(plain)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+		return nil, errors.New("wrong password or damaged key")
+	}
+	plain = plain[:len(plain)-pad]
+	if _, err := x509.ParsePKCS8PrivateKey(plain); err != nil {
+		return nil, errors.New("wrong password or damaged key")
+	}
+	return plain, nil
+}
@@ -0,0 +1,229 @@
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/router"
+	natsgo "github.com/nats-io/nats.go"
+)
+
+// Client represents a NATS client wrapper.
+type Client struct {
+	conn    *natsgo.Conn
+	subs    []*natsgo.Subscription
+	filters []string
+	url     string
+	logger  *logger.Logger
+}
+
+// MessageHandler is a function that processes incoming NATS messages.
+// topic is the subject translated to MQTT-style topic form (e.g. "ruuvi/F0:34:..."),
+// so that it can be matched by the router's topic filters.
+type MessageHandler func(topic string, payload []byte) error
+
+// Config holds NATS client configuration.
+type Config struct {
+	URL      string
+	Name     string
+	Username string
+	Password string
+	Token    string
+	Filters  []string // MQTT-style topic filters subscribed to by Start
+	Logger   *logger.Logger
+}
+
+// New creates a new NATS client.
+func New(cfg Config) (*Client, error) {
+	log := cfg.Logger
+	if log == nil {
+		log = logger.New(logger.INFO)
+	}
+
+	opts := []natsgo.Option{
+		natsgo.Name(cfg.Name),
+		natsgo.Timeout(10 * time.Second),
+		natsgo.MaxReconnects(-1),
+		natsgo.ReconnectHandler(func(_ *natsgo.Conn) {
+			log.Info("Reconnected to NATS server")
+		}),
+		natsgo.DisconnectErrHandler(func(_ *natsgo.Conn, err error) {
+			if err != nil {
+				log.Errorf("NATS connection lost: %v", err)
+			}
+		}),
+	}
+	if cfg.Username != "" {
+		opts = append(opts, natsgo.UserInfo(cfg.Username, cfg.Password))
+	}
+	if cfg.Token != "" {
+		opts = append(opts, natsgo.Token(cfg.Token))
+	}
+
+	conn, err := natsgo.Connect(cfg.URL, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS server: %w", err)
+	}
+	log.Info("Connected to NATS server")
+
+	return &Client{
+		conn:    conn,
+		filters: cfg.Filters,
+		url:     cfg.URL,
+		logger:  log,
+	}, nil
+}
+
+// Start subscribes to the configured filters and delivers messages to
+// dispatch. The subscriptions are drained when ctx is done.
+func (c *Client) Start(ctx context.Context, dispatch func(router.Message) error) error {
+	first := len(c.subs)
+	for _, filter := range c.filters {
+		err := c.subscribe(filter, func(msg *natsgo.Msg) error {
+			return dispatch(router.Message{
+				Topic:      SubjectToTopic(msg.Subject),
+				Payload:    msg.Data,
+				Time:       time.Now().UTC(),
+				Source:     c.url,
+				Properties: Properties(msg.Header),
+			})
+		})
+		if err != nil {
+			return err
+		}
+	}
+	subs := c.subs[first:]
+	go func() {
+		<-ctx.Done()
+		for _, sub := range subs {
+			// Disconnect may have drained or closed the connection already
+			err := sub.Drain()
+			if err != nil && !errors.Is(err, natsgo.ErrConnectionClosed) &&
+				!errors.Is(err, natsgo.ErrConnectionDraining) && !errors.Is(err, natsgo.ErrBadSubscription) {
+				c.logger.Warnf("Failed to drain subscription to subject %s: %v", sub.Subject, err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Subscribe subscribes to the NATS subjects equivalent of an MQTT topic filter.
+// Example: filter "ruuvi/+" subscribes to subject "ruuvi.*".
+func (c *Client) Subscribe(filter string, handler MessageHandler) error {
+	return c.subscribe(filter, func(msg *natsgo.Msg) error {
+		return handler(SubjectToTopic(msg.Subject), msg.Data)
+	})
+}
+
+// subscribe subscribes to the NATS subjects equivalent of filter, passing
+// handler the whole message
+func (c *Client) subscribe(filter string, handler func(*natsgo.Msg) error) error {
+	subjects, err := FilterToSubjects(filter)
+	if err != nil {
+		return err
+	}
+
+	for _, subject := range subjects {
+		sub, err := c.conn.Subscribe(subject, func(msg *natsgo.Msg) {
+			if err := handler(msg); err != nil {
+				c.logger.Errorf("Error processing message from topic %s (subject %s): %v",
+					SubjectToTopic(msg.Subject), msg.Subject, err)
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("failed to subscribe to subject %s: %w", subject, err)
+		}
+		c.subs = append(c.subs, sub)
+
+		c.logger.Infof("Subscribed to NATS subject: %s (filter=%s)", subject, filter)
+	}
+	return nil
+}
+
+// Disconnect drains subscriptions and closes the NATS connection.
+func (c *Client) Disconnect() {
+	if c.conn != nil && !c.conn.IsClosed() {
+		if err := c.conn.Drain(); err != nil {
+			c.conn.Close()
+		}
+	}
+	c.logger.Info("Disconnected from the NATS server")
+}
+
+// Close drains subscriptions and closes the NATS connection.
+func (c *Client) Close() {
+	c.Disconnect()
+}
+
+// Properties converts NATS message headers to message properties, which
+// scripts read as msg.properties: Content-Type becomes content_type and
+// every other header a user property (a header with several values keeps
+// its last one). Messages without headers have no properties.
+func Properties(h natsgo.Header) *router.Properties {
+	if len(h) == 0 {
+		return nil
+	}
+	p := &router.Properties{User: make(map[string]string, len(h))}
+	for key, values := range h {
+		if len(values) == 0 {
+			continue
+		}
+		value := values[len(values)-1]
+		if strings.EqualFold(key, "Content-Type") {
+			p.ContentType = value
+			continue
+		}
+		p.User[key] = value
+	}
+	return p
+}
+
+// FilterToSubjects converts an MQTT topic filter into the NATS subjects
+// that cover it. Level separators '/' become '.', '+' becomes '*' and '#'
+// becomes '>'. Since MQTT's "a/#" also matches "a" itself but NATS's "a.>"
+// doesn't, a trailing '#' after other levels subscribes to the parent
+// subject too. A level containing '.' is rejected: no NATS token can hold
+// one, so the filter could never match.
+//
+// Examples:
+//
+//	filter "ruuvi/+"  becomes subject "ruuvi.*"
+//	filter "p1ib/#"   becomes subjects "p1ib" and "p1ib.>"
+//	filter "#"        becomes subject ">"
+func FilterToSubjects(filter string) ([]string, error) {
+	levels := strings.Split(filter, "/")
+	for i, l := range levels {
+		switch {
+		case l == "+":
+			levels[i] = "*"
+		case l == "#":
+			levels[i] = ">"
+		case strings.Contains(l, "."):
+			return nil, fmt.Errorf("filter %q: level %q contains '.', which NATS subjects use as separator", filter, l)
+		}
+	}
+	subject := strings.Join(levels, ".")
+	if n := len(levels); n > 1 && levels[n-1] == ">" {
+		return []string{strings.Join(levels[:n-1], "."), subject}, nil
+	}
+	return []string{subject}, nil
+}
+
+// SubjectToTopic converts a concrete NATS subject into an MQTT-style topic
+// by replacing the '.' token separator with '/'. A '/' inside a token is
+// escaped as "%2F" (and '%' as "%25") so that each token stays one topic
+// level: "a.b/c" becomes "a/b%2Fc", not "a/b/c".
+func SubjectToTopic(subject string) string {
+	tokens := strings.Split(subject, ".")
+	for i, t := range tokens {
+		if strings.ContainsAny(t, "/%") {
+			t = strings.ReplaceAll(t, "%", "%25")
+			tokens[i] = strings.ReplaceAll(t, "/", "%2F")
+		}
+	}
+	return strings.Join(tokens, "/")
+}
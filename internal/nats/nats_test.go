@@ -0,0 +1,80 @@
+package nats
+
+import (
+	"strings"
+	"testing"
+
+	natsgo "github.com/nats-io/nats.go"
+)
+
+func TestProperties(t *testing.T) {
+	if p := Properties(nil); p != nil {
+		t.Errorf("Expected no properties without headers, got %+v", p)
+	}
+
+	h := natsgo.Header{}
+	h.Set("Content-Type", "application/json")
+	h.Add("Tenant", "plant-1")
+	h.Add("Tenant", "plant-3")
+	h.Set("Nats-Msg-Id", "42")
+	p := Properties(h)
+	if p == nil || p.ContentType != "application/json" {
+		t.Fatalf("Expected the content type from the header, got %+v", p)
+	}
+	if p.User["Tenant"] != "plant-3" || p.User["Nats-Msg-Id"] != "42" || len(p.User) != 2 {
+		t.Errorf("Unexpected user properties: %v", p.User)
+	}
+}
+
+func TestFilterToSubjects(t *testing.T) {
+	tests := []struct {
+		name   string
+		filter string
+		want   []string
+	}{
+		{"exact", "ruuvi/sensor1", []string{"ruuvi.sensor1"}},
+		{"single level", "ruuvi/+", []string{"ruuvi.*"}},
+		{"multi level", "p1ib/#", []string{"p1ib", "p1ib.>"}},
+		{"match all", "#", []string{">"}},
+		{"mixed", "devices/+/telemetry/#", []string{"devices.*.telemetry", "devices.*.telemetry.>"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := FilterToSubjects(tt.filter)
+			if err != nil {
+				t.Fatalf("FilterToSubjects(%q) error = %v", tt.filter, err)
+			}
+			if strings.Join(got, " ") != strings.Join(tt.want, " ") {
+				t.Errorf("FilterToSubjects(%q) = %q, want %q", tt.filter, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFilterToSubjectsRejectsDot(t *testing.T) {
+	if _, err := FilterToSubjects("sensors/v1.2/+"); err == nil {
+		t.Error("Expected error for a filter level containing '.'")
+	}
+}
+
+func TestSubjectToTopic(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{"ruuvi.sensor1", "ruuvi/sensor1"},
+		{"p1ib.meter.power", "p1ib/meter/power"},
+		{"single", "single"},
+		{"files.a/b", "files/a%2Fb"},
+		{"files.50%", "files/50%25"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			if got := SubjectToTopic(tt.subject); got != tt.want {
+				t.Errorf("SubjectToTopic(%q) = %q, want %q", tt.subject, got, tt.want)
+			}
+		})
+	}
+}
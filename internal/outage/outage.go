@@ -0,0 +1,304 @@
+package outage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/sink"
+)
+
+// Components whose outages are tracked
+const (
+	MQTT     = "mqtt"
+	Database = "database"
+)
+
+// NamedSink returns the component of the named sink called name
+func NamedSink(name string) string {
+	return "sink:" + name
+}
+
+// Tracker defaults
+const (
+	defaultHistory      = 20
+	defaultPollInterval = 100 * time.Millisecond
+)
+
+// Report summarizes one outage of a component. Buffered counts messages
+// queued for routing while it lasted, Dropped the messages and rows lost
+// to it. Replay is how long the backlog took to drain once it ended.
+type Report struct {
+	Component string    `json:"component"`
+	Reason    string    `json:"reason"` // First error seen
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end,omitempty"` // Zero while ongoing
+	Duration  string    `json:"duration"`
+	Buffered  int64     `json:"buffered"`
+	Dropped   int64     `json:"dropped"`
+	Replay    string    `json:"replay,omitempty"` // Empty until the backlog has drained
+	Ongoing   bool      `json:"ongoing"`
+}
+
+// Config controls outage tracking
+type Config struct {
+	History      int           // Finished outages kept for Reports (default: 20)
+	PollInterval time.Duration // How often the backlog is checked after recovery (default: 100ms)
+	Logger       *logger.Logger
+}
+
+// Tracker follows outages of the MQTT connection and the database and
+// logs a catch-up report once each one ends and its backlog has drained
+type Tracker struct {
+	cfg     Config
+	logger  *logger.Logger
+	now     func() time.Time
+	mu      sync.Mutex
+	backlog func() int
+	changed []func(component string, up bool)
+	open    map[string]*Report
+	history []Report
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+// New creates an outage tracker
+func New(cfg Config) *Tracker {
+	if cfg.History <= 0 {
+		cfg.History = defaultHistory
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	log := cfg.Logger
+	if log == nil {
+		log = logger.New(logger.INFO)
+	}
+	return &Tracker{
+		cfg:    cfg,
+		logger: log,
+		now:    time.Now,
+		open:   make(map[string]*Report),
+		stop:   make(chan struct{}),
+	}
+}
+
+// SetBacklog sets the function reporting how many messages are still
+// waiting to be processed (e.g. the total length of the route queues)
+func (t *Tracker) SetBacklog(fn func() int) {
+	t.mu.Lock()
+	t.backlog = fn
+	t.mu.Unlock()
+}
+
+// Down marks component as unavailable; repeated calls extend the same outage
+func (t *Tracker) Down(component string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.open[component]; ok {
+		return
+	}
+	r := &Report{Component: component, Start: t.now(), Ongoing: true}
+	if err != nil {
+		r.Reason = err.Error()
+	}
+	t.open[component] = r
+	t.logger.Warnf("Outage started: %s unavailable: %s", component, r.Reason)
+	for _, fn := range t.changed {
+		fn(component, false)
+	}
+}
+
+// Up marks component as available again. The outage is reported once the
+// backlog has drained; Up without an ongoing outage does nothing.
+func (t *Tracker) Up(component string) {
+	t.mu.Lock()
+	r, ok := t.open[component]
+	if !ok {
+		t.mu.Unlock()
+		return
+	}
+	delete(t.open, component)
+	r.End = t.now()
+	r.Ongoing = false
+	r.Duration = r.End.Sub(r.Start).Round(time.Millisecond).String()
+	t.logger.Infof("Outage ended: %s available again after %s, waiting for the backlog to drain", component, r.Duration)
+	for _, fn := range t.changed {
+		fn(component, true)
+	}
+	t.wg.Add(1)
+	t.mu.Unlock()
+
+	go func() {
+		defer t.wg.Done()
+		t.awaitReplay(r)
+	}()
+}
+
+// OnChange registers fn to be called whenever a component goes down or
+// comes back up. fn runs with the tracker locked, so it must not block or
+// call back into the tracker.
+func (t *Tracker) OnChange(fn func(component string, up bool)) {
+	t.mu.Lock()
+	t.changed = append(t.changed, fn)
+	t.mu.Unlock()
+}
+
+// Ongoing reports whether component is currently down
+func (t *Tracker) Ongoing(component string) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	_, ok := t.open[component]
+	return ok
+}
+
+// Probe runs check every interval while component is down and ends the
+// outage once it succeeds, for components nothing else would prove
+// healthy again (e.g. a database no route is writing to)
+func (t *Tracker) Probe(component string, check func(ctx context.Context) error, interval time.Duration) {
+	t.wg.Add(1)
+	go func() {
+		defer t.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.stop:
+				return
+			case <-ticker.C:
+			}
+			if !t.Ongoing(component) {
+				continue
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), interval)
+			err := check(ctx)
+			cancel()
+			if err == nil {
+				t.Up(component)
+			}
+		}
+	}()
+}
+
+// Accepted counts a message queued for routing during every ongoing outage
+func (t *Tracker) Accepted() {
+	t.count(func(r *Report) { r.Buffered++ })
+}
+
+// Dropped counts n messages or rows lost during every ongoing outage
+func (t *Tracker) Dropped(n int) {
+	t.count(func(r *Report) { r.Dropped += int64(n) })
+}
+
+// count applies fn to every ongoing outage
+func (t *Tracker) count(fn func(r *Report)) {
+	t.mu.Lock()
+	for _, r := range t.open {
+		fn(r)
+	}
+	t.mu.Unlock()
+}
+
+// awaitReplay waits for the backlog to drain, then logs and records r
+func (t *Tracker) awaitReplay(r *Report) {
+	t.mu.Lock()
+	backlog := t.backlog
+	t.mu.Unlock()
+
+	if backlog != nil && backlog() > 0 {
+		ticker := time.NewTicker(t.cfg.PollInterval)
+		defer ticker.Stop()
+	wait:
+		for backlog() > 0 {
+			select {
+			case <-t.stop:
+				break wait
+			case <-ticker.C:
+			}
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	r.Replay = t.now().Sub(r.End).Round(time.Millisecond).String()
+	t.history = append(t.history, *r)
+	if len(t.history) > t.cfg.History {
+		t.history = t.history[len(t.history)-t.cfg.History:]
+	}
+	t.logger.Infof("Outage report: %s down for %s (%s), %d messages buffered, %d dropped, replayed in %s",
+		r.Component, r.Duration, r.Reason, r.Buffered, r.Dropped, r.Replay)
+}
+
+// Reports returns ongoing outages followed by finished ones, oldest first
+func (t *Tracker) Reports() []Report {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := t.now()
+	reports := make([]Report, 0, len(t.open)+len(t.history))
+	for _, r := range t.open {
+		current := *r
+		current.Duration = now.Sub(r.Start).Round(time.Millisecond).String()
+		reports = append(reports, current)
+	}
+	sort.Slice(reports, func(i, j int) bool { return reports[i].Start.Before(reports[j].Start) })
+	return append(reports, t.history...)
+}
+
+// Close stops waiting for backlogs to drain and records pending reports
+func (t *Tracker) Close() {
+	close(t.stop)
+	t.wg.Wait()
+}
+
+// Sink is a storage stage that reports outages of the database (or of a
+// named sink) to a Tracker: writes failing with errs.ErrStorageUnavailable start one and count their
+// rows as dropped, and the next successful write ends it
+type Sink struct {
+	tracker   *Tracker
+	component string
+	next      sink.Storage
+}
+
+// Storage returns a stage in front of next that reports database outages
+func (t *Tracker) Storage(next sink.Storage) *Sink {
+	return t.ComponentStorage(Database, next)
+}
+
+// ComponentStorage returns a stage in front of next that reports outages
+// of component (e.g. NamedSink("archive"))
+func (t *Tracker) ComponentStorage(component string, next sink.Storage) *Sink {
+	return &Sink{tracker: t, component: component, next: next}
+}
+
+// InsertIntoTable forwards the record and records the outcome
+func (s *Sink) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	err := s.next.InsertIntoTable(ctx, table, data)
+	s.observe(err, 1)
+	return err
+}
+
+// InsertBatch forwards the rows and records the outcome
+func (s *Sink) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error {
+	bs, ok := s.next.(sink.BatchStorage)
+	if !ok {
+		return sink.InsertEach(ctx, s, table, rows)
+	}
+	err := bs.InsertBatch(ctx, table, rows)
+	s.observe(err, len(rows))
+	return err
+}
+
+// observe starts or ends an outage of the component depending on err
+func (s *Sink) observe(err error, rows int) {
+	switch {
+	case err == nil:
+		s.tracker.Up(s.component)
+	case errors.Is(err, errs.ErrStorageUnavailable):
+		s.tracker.Down(s.component, err)
+		s.tracker.Dropped(rows)
+	}
+}
@@ -0,0 +1,233 @@
+package outage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// flakyStorage fails inserts as unreachable while down is set
+type flakyStorage struct {
+	down atomic.Bool
+	rows atomic.Int64
+}
+
+func (f *flakyStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	if f.down.Load() {
+		return fmt.Errorf("%w: connection refused", errs.ErrStorageUnavailable)
+	}
+	f.rows.Add(1)
+	return nil
+}
+
+// batchStorage is a flakyStorage that also inserts batches
+type batchStorage struct {
+	flakyStorage
+}
+
+func (b *batchStorage) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error {
+	if b.down.Load() {
+		return fmt.Errorf("%w: connection refused", errs.ErrStorageUnavailable)
+	}
+	b.rows.Add(int64(len(rows)))
+	return nil
+}
+
+func newTracker() *Tracker {
+	return New(Config{PollInterval: time.Millisecond, Logger: logger.New(logger.ERROR)})
+}
+
+// waitForReports waits until n outages have finished
+func waitForReports(t *testing.T, tr *Tracker, n int) []Report {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		tr.mu.Lock()
+		done := len(tr.history)
+		tr.mu.Unlock()
+		if done >= n {
+			return tr.Reports()
+		}
+		time.Sleep(time.Millisecond)
+	}
+	t.Fatalf("Expected %d finished outages", n)
+	return nil
+}
+
+func TestTrackerReportsOutage(t *testing.T) {
+	tr := newTracker()
+	defer tr.Close()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	tr.now = func() time.Time { return now }
+
+	tr.Accepted() // before the outage: not counted
+	tr.Down(MQTT, errors.New("connection reset"))
+	tr.Down(MQTT, errors.New("still down"))
+	tr.Accepted()
+	tr.Dropped(2)
+
+	reports := tr.Reports()
+	if len(reports) != 1 || !reports[0].Ongoing || reports[0].Reason != "connection reset" {
+		t.Fatalf("Expected one ongoing outage, got %+v", reports)
+	}
+
+	now = now.Add(90 * time.Second)
+	tr.Up(MQTT)
+	tr.Up(MQTT) // no outage: ignored
+
+	reports = waitForReports(t, tr, 1)
+	if len(reports) != 1 {
+		t.Fatalf("Expected one report, got %+v", reports)
+	}
+	r := reports[0]
+	if r.Ongoing || r.Duration != "1m30s" || r.Buffered != 1 || r.Dropped != 2 || r.Replay != "0s" {
+		t.Errorf("Unexpected report: %+v", r)
+	}
+}
+
+func TestTrackerWaitsForBacklog(t *testing.T) {
+	tr := newTracker()
+	defer tr.Close()
+	var backlog atomic.Int64
+	backlog.Store(3)
+	tr.SetBacklog(func() int { return int(backlog.Load()) })
+
+	tr.Down(Database, errors.New("db down"))
+	tr.Up(Database)
+	time.Sleep(20 * time.Millisecond)
+	if reports := tr.Reports(); len(reports) != 0 {
+		t.Fatalf("Expected no report while the backlog drains, got %+v", reports)
+	}
+
+	backlog.Store(0)
+	reports := waitForReports(t, tr, 1)
+	if reports[0].Component != Database || reports[0].Replay == "" {
+		t.Errorf("Unexpected report: %+v", reports[0])
+	}
+}
+
+func TestTrackerHistoryLimit(t *testing.T) {
+	tr := New(Config{History: 2, Logger: logger.New(logger.ERROR)})
+	defer tr.Close()
+	for i := 0; i < 3; i++ {
+		tr.Down(MQTT, fmt.Errorf("outage %d", i))
+		tr.Up(MQTT)
+		tr.wg.Wait()
+	}
+	reports := tr.Reports()
+	if len(reports) != 2 || reports[0].Reason != "outage 1" || reports[1].Reason != "outage 2" {
+		t.Errorf("Expected the 2 latest outages, got %+v", reports)
+	}
+}
+
+func TestTrackerOnChange(t *testing.T) {
+	tr := newTracker()
+	defer tr.Close()
+	var changes []string
+	tr.OnChange(func(component string, up bool) {
+		changes = append(changes, fmt.Sprintf("%s:%v", component, up))
+	})
+
+	tr.Down(Database, errors.New("db down"))
+	tr.Down(Database, errors.New("still down"))
+	if !tr.Ongoing(Database) || tr.Ongoing(MQTT) {
+		t.Error("Expected only the database outage ongoing")
+	}
+	tr.Up(Database)
+	if tr.Ongoing(Database) {
+		t.Error("Expected the database outage over")
+	}
+	if want := []string{"database:false", "database:true"}; fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Errorf("Expected changes %v, got %v", want, changes)
+	}
+}
+
+func TestTrackerProbe(t *testing.T) {
+	tr := newTracker()
+	defer tr.Close()
+	var healthy atomic.Bool
+	tr.Probe(Database, func(ctx context.Context) error {
+		if !healthy.Load() {
+			return errors.New("connection refused")
+		}
+		return nil
+	}, time.Millisecond)
+
+	tr.Down(Database, errors.New("db down"))
+	time.Sleep(20 * time.Millisecond)
+	if !tr.Ongoing(Database) {
+		t.Fatal("Expected the outage ongoing while the probe fails")
+	}
+	healthy.Store(true)
+	waitForReports(t, tr, 1)
+}
+
+func TestSinkTracksDatabaseOutage(t *testing.T) {
+	tr := newTracker()
+	defer tr.Close()
+	next := &flakyStorage{}
+	sink := tr.Storage(next)
+	ctx := context.Background()
+	row := map[string]interface{}{"v": 1.0}
+
+	next.down.Store(true)
+	if err := sink.InsertIntoTable(ctx, "metrics", row); !errors.Is(err, errs.ErrStorageUnavailable) {
+		t.Fatalf("Expected storage error, got %v", err)
+	}
+	if err := sink.InsertBatch(ctx, "metrics", []map[string]interface{}{row, row}); err == nil {
+		t.Fatal("Expected batch to fail")
+	}
+	next.down.Store(false)
+	if err := sink.InsertIntoTable(ctx, "metrics", row); err != nil {
+		t.Fatalf("InsertIntoTable failed: %v", err)
+	}
+
+	r := waitForReports(t, tr, 1)[0]
+	if r.Component != Database || r.Dropped != 2 {
+		t.Errorf("Unexpected report: %+v", r)
+	}
+}
+
+func TestComponentStorage(t *testing.T) {
+	tr := newTracker()
+	defer tr.Close()
+	next := &flakyStorage{}
+	sink := tr.ComponentStorage(NamedSink("archive"), next)
+
+	next.down.Store(true)
+	sink.InsertIntoTable(context.Background(), "events", map[string]interface{}{"v": 1.0})
+	if !tr.Ongoing(NamedSink("archive")) || tr.Ongoing(Database) {
+		t.Fatal("Expected only the named sink to be down")
+	}
+	next.down.Store(false)
+	sink.InsertIntoTable(context.Background(), "events", map[string]interface{}{"v": 1.0})
+	if r := waitForReports(t, tr, 1)[0]; r.Component != "sink:archive" {
+		t.Errorf("Unexpected report: %+v", r)
+	}
+}
+
+func TestSinkBatchDropsAllRows(t *testing.T) {
+	tr := newTracker()
+	defer tr.Close()
+	next := &batchStorage{}
+	sink := tr.Storage(next)
+	rows := []map[string]interface{}{{"v": 1.0}, {"v": 2.0}, {"v": 3.0}}
+
+	next.down.Store(true)
+	sink.InsertBatch(context.Background(), "metrics", rows)
+	next.down.Store(false)
+	if err := sink.InsertBatch(context.Background(), "metrics", rows); err != nil {
+		t.Fatalf("InsertBatch failed: %v", err)
+	}
+
+	r := waitForReports(t, tr, 1)[0]
+	if r.Dropped != 3 || next.rows.Load() != 3 {
+		t.Errorf("dropped=%d stored=%d, want 3 and 3", r.Dropped, next.rows.Load())
+	}
+}
@@ -31,7 +31,9 @@ func New(transformer *lua.Transformer, storage *storage.Storage, log *logger.Log
 
 // Process processes an incoming message
 func (p *Pipeline) Process(ctx context.Context, topic string, payload []byte) error {
-	p.logger.Debugf("Received message from topic '%s': %s", topic, string(payload))
+	if p.logger.Enabled(logger.DEBUG) {
+		p.logger.Debugf("Received message from topic '%s': %s", topic, payload)
+	}
 
 	// Try to decode as JSON
 	var data map[string]interface{}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package quota
+
+import "errors"
+
+// freePercent is not supported on this platform
+func freePercent(path string) (float64, error) {
+	return 0, errors.New("free space checks are not supported on this platform")
+}
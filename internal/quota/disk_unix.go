@@ -0,0 +1,18 @@
+//go:build unix
+
+package quota
+
+import "syscall"
+
+// freePercent returns the share of the filesystem at path available to
+// unprivileged users, in percent
+func freePercent(path string) (float64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	if st.Blocks == 0 {
+		return 0, nil
+	}
+	return float64(st.Bavail) / float64(st.Blocks) * 100, nil
+}
@@ -0,0 +1,329 @@
+// Package quota guards the database against unbounded growth: per-table
+// row quotas per UTC day and a free-disk-space check. While a table is over
+// its quota its rows are sampled or diverted to an overflow table instead of
+// being stored; while disk space is low they are always sampled, since the
+// overflow table would fill the same disk.
+package quota
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/sink"
+)
+
+// Actions taken on the rows of a table over its limits
+const (
+	ActionSample = "sample" // Store one row in SampleEvery and drop the rest
+	ActionDLQ    = "dlq"    // Store rows in the overflow table instead
+)
+
+// DefaultOverflowTable is the table diverted rows go to with ActionDLQ
+const DefaultOverflowTable = "hermod_quota_overflow"
+
+const (
+	defaultSampleEvery   = 10
+	defaultCheckInterval = time.Minute
+)
+
+// validTable ensures the overflow table name is safe for SQL
+var validTable = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// CreateTableSQL returns the DDL for an overflow table, with IF NOT EXISTS
+// when ifNotExists is set
+func CreateTableSQL(table string, ifNotExists bool) string {
+	create := "CREATE TABLE"
+	if ifNotExists {
+		create += " IF NOT EXISTS"
+	}
+	return fmt.Sprintf(`%s %s (
+  time timestamptz NOT NULL,
+  target_table text NOT NULL,
+  reason text NOT NULL,
+  data jsonb
+);`, create, table)
+}
+
+// Breach describes a table reaching its quota or disk space running low
+type Breach struct {
+	Table  string // Table over its quota (empty when disk space is low)
+	Reason string
+}
+
+// Config holds the quotas and what happens when they are exceeded
+type Config struct {
+	RowsPerDay     map[string]int64 // Table -> rows stored per UTC day
+	DiskPath       string           // Filesystem whose free space is checked (empty = no check)
+	MinFreePercent float64          // Free space below which every table is sampled
+	CheckInterval  time.Duration    // How often free space is checked (default: 1m)
+	Action         string           // ActionSample (default) or ActionDLQ
+	SampleEvery    int              // With ActionSample: store one row in this many (default: 10)
+	OverflowTable  string           // With ActionDLQ: table diverted rows go to (default: DefaultOverflowTable)
+	OnBreach       func(Breach)     // Called once per table and day, and when disk space runs low (optional)
+	Logger         *logger.Logger
+}
+
+// Guard enforces the quotas in front of the next sink
+type Guard struct {
+	cfg      Config
+	next     sink.Storage
+	logger   *logger.Logger
+	now      func() time.Time
+	free     func(path string) (float64, error) // Free space of a filesystem, in percent
+	diskLow  atomic.Bool
+	diverted atomic.Int64
+
+	mu       sync.Mutex
+	day      string           // UTC day the counts are for
+	counts   map[string]int64 // Rows stored today per table, counted once inserted
+	breached map[string]bool  // Tables reported over quota today
+	over     map[string]int64 // Rows seen while over the limits, for sampling
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// New creates a guard in front of next; Start begins the disk space check
+func New(cfg Config, next sink.Storage) (*Guard, error) {
+	for table, n := range cfg.RowsPerDay {
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid quota for table %s: rows_per_day must be positive", table)
+		}
+	}
+	if cfg.MinFreePercent < 0 || cfg.MinFreePercent >= 100 {
+		return nil, fmt.Errorf("invalid min_free_percent %v: use 0 to 100", cfg.MinFreePercent)
+	}
+	if (cfg.DiskPath == "") != (cfg.MinFreePercent == 0) {
+		return nil, fmt.Errorf("disk_path and min_free_percent must be set together")
+	}
+	switch cfg.Action {
+	case "":
+		cfg.Action = ActionSample
+	case ActionSample, ActionDLQ:
+	default:
+		return nil, fmt.Errorf("invalid quota action %q: use sample or dlq", cfg.Action)
+	}
+	if cfg.SampleEvery < 0 {
+		return nil, fmt.Errorf("invalid sample_every %d", cfg.SampleEvery)
+	}
+	if cfg.SampleEvery == 0 {
+		cfg.SampleEvery = defaultSampleEvery
+	}
+	if cfg.OverflowTable == "" {
+		cfg.OverflowTable = DefaultOverflowTable
+	}
+	if !validTable.MatchString(cfg.OverflowTable) {
+		return nil, fmt.Errorf("invalid overflow table name: %s", cfg.OverflowTable)
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = defaultCheckInterval
+	}
+	log := cfg.Logger
+	if log == nil {
+		log = logger.New(logger.INFO)
+	}
+
+	g := &Guard{
+		cfg:      cfg,
+		next:     next,
+		logger:   log,
+		now:      time.Now,
+		free:     freePercent,
+		counts:   make(map[string]int64),
+		breached: make(map[string]bool),
+		over:     make(map[string]int64),
+		stop:     make(chan struct{}),
+	}
+	if cfg.DiskPath != "" {
+		if _, err := g.free(cfg.DiskPath); err != nil {
+			return nil, fmt.Errorf("failed to check free space of %s: %w", cfg.DiskPath, err)
+		}
+	}
+	return g, nil
+}
+
+// Start checks free space now and then every CheckInterval until Close
+func (g *Guard) Start() {
+	if g.cfg.DiskPath == "" {
+		return
+	}
+	g.checkDisk()
+	g.wg.Add(1)
+	go g.watchDisk()
+}
+
+// Close stops the disk space check
+func (g *Guard) Close() {
+	close(g.stop)
+	g.wg.Wait()
+}
+
+// Diverted returns the number of rows sampled away or sent to the overflow table
+func (g *Guard) Diverted() int64 {
+	return g.diverted.Load()
+}
+
+// InsertIntoTable stores the record, or applies the action when table is
+// over its limits
+func (g *Guard) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	if action, reason := g.admit(table, 0); action != "" {
+		return g.divert(ctx, table, data, action, reason)
+	}
+	if err := g.next.InsertIntoTable(ctx, table, data); err != nil {
+		return err
+	}
+	g.count(table, 1)
+	return nil
+}
+
+// InsertBatch stores the rows within the limits and applies the action to
+// the rest
+func (g *Guard) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error {
+	bs, ok := g.next.(sink.BatchStorage)
+	if !ok {
+		return sink.InsertEach(ctx, g, table, rows)
+	}
+
+	kept := make([]map[string]interface{}, 0, len(rows))
+	for _, data := range rows {
+		if action, reason := g.admit(table, len(kept)); action != "" {
+			if err := g.divert(ctx, table, data, action, reason); err != nil {
+				return err
+			}
+			continue
+		}
+		kept = append(kept, data)
+	}
+	if len(kept) == 0 {
+		return nil
+	}
+	if err := bs.InsertBatch(ctx, table, kept); err != nil {
+		return err
+	}
+	g.count(table, len(kept))
+	return nil
+}
+
+// admit reports the action to apply to a row for table, with its reason,
+// or "" to store it. pending is the number of rows already admitted for the
+// same insert; rows only count against the quota once count is called after
+// they were stored.
+func (g *Guard) admit(table string, pending int) (string, string) {
+	var breach *Breach
+	defer func() {
+		if breach != nil {
+			g.breach(*breach)
+		}
+	}()
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.rollover()
+
+	action, reason := g.cfg.Action, ""
+	if g.diskLow.Load() {
+		// The overflow table would fill the same disk
+		action = ActionSample
+		reason = fmt.Sprintf("free space on %s below %v%%", g.cfg.DiskPath, g.cfg.MinFreePercent)
+	} else if limit, ok := g.cfg.RowsPerDay[table]; ok && g.counts[table]+int64(pending) >= limit {
+		reason = fmt.Sprintf("quota of %d rows per day reached", limit)
+		if !g.breached[table] {
+			g.breached[table] = true
+			breach = &Breach{Table: table, Reason: reason}
+		}
+	}
+	if reason == "" {
+		return "", ""
+	}
+	g.over[table]++
+	if action == ActionSample && (g.over[table]-1)%int64(g.cfg.SampleEvery) == 0 {
+		return "", ""
+	}
+	return action, reason
+}
+
+// count adds n rows stored in table to today's count
+func (g *Guard) count(table string, n int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.rollover()
+	g.counts[table] += int64(n)
+}
+
+// rollover resets the counts at midnight UTC; g.mu must be held
+func (g *Guard) rollover() {
+	if day := g.now().UTC().Format(time.DateOnly); day != g.day {
+		g.day = day
+		clear(g.counts)
+		clear(g.breached)
+		clear(g.over)
+	}
+}
+
+// divert applies action to a row that wasn't admitted
+func (g *Guard) divert(ctx context.Context, table string, data map[string]interface{}, action, reason string) error {
+	g.diverted.Add(1)
+	if action == ActionSample {
+		return nil
+	}
+	row := map[string]interface{}{
+		"time":         g.now().UTC(),
+		"target_table": table,
+		"reason":       reason,
+		"data":         data,
+	}
+	if err := g.next.InsertIntoTable(ctx, g.cfg.OverflowTable, row); err != nil {
+		return fmt.Errorf("failed to store %s row in %s: %w", table, g.cfg.OverflowTable, err)
+	}
+	return nil
+}
+
+// breach logs and reports a breach
+func (g *Guard) breach(b Breach) {
+	if b.Table != "" {
+		g.logger.Warnf("Table %s: %s; applying %s until midnight UTC", b.Table, b.Reason, g.cfg.Action)
+	} else {
+		g.logger.Warnf("%s; sampling every table", b.Reason)
+	}
+	if g.cfg.OnBreach != nil {
+		g.cfg.OnBreach(b)
+	}
+}
+
+// watchDisk checks free space every CheckInterval until Close
+func (g *Guard) watchDisk() {
+	defer g.wg.Done()
+	ticker := time.NewTicker(g.cfg.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-g.stop:
+			return
+		case <-ticker.C:
+			g.checkDisk()
+		}
+	}
+}
+
+// checkDisk updates the low-disk state, reporting when it is entered
+func (g *Guard) checkDisk() {
+	free, err := g.free(g.cfg.DiskPath)
+	if err != nil {
+		g.logger.Errorf("Failed to check free space of %s: %v", g.cfg.DiskPath, err)
+		return
+	}
+	low := free < g.cfg.MinFreePercent
+	if g.diskLow.Swap(low) == low {
+		return
+	}
+	if low {
+		g.breach(Breach{Reason: fmt.Sprintf("free space on %s is %.1f%%, below %v%%", g.cfg.DiskPath, free, g.cfg.MinFreePercent)})
+	} else {
+		g.logger.Infof("Free space on %s recovered to %.1f%%; storing every row again", g.cfg.DiskPath, free)
+	}
+}
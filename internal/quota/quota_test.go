@@ -0,0 +1,210 @@
+package quota
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/sink"
+)
+
+// mockStorage records inserted rows per table
+type mockStorage struct {
+	mu      sync.Mutex
+	rows    map[string][]map[string]interface{}
+	batches int
+	err     error // Returned by every insert when set
+}
+
+func newMockStorage() *mockStorage {
+	return &mockStorage{rows: make(map[string][]map[string]interface{})}
+}
+
+func (m *mockStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.err != nil {
+		return m.err
+	}
+	m.rows[table] = append(m.rows[table], data)
+	return nil
+}
+
+func (m *mockStorage) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error {
+	m.mu.Lock()
+	m.batches++
+	err := m.err
+	m.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	for _, data := range rows {
+		m.InsertIntoTable(ctx, table, data)
+	}
+	return nil
+}
+
+func newTestGuard(t *testing.T, cfg Config, next sink.Storage) *Guard {
+	t.Helper()
+	cfg.Logger = logger.New(logger.ERROR)
+	g, err := New(cfg, next)
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	t.Cleanup(g.Close)
+	return g
+}
+
+func TestNewValidation(t *testing.T) {
+	tests := []Config{
+		{RowsPerDay: map[string]int64{"t": 0}},
+		{MinFreePercent: 10},
+		{DiskPath: "/"},
+		{MinFreePercent: 100, DiskPath: "/"},
+		{Action: "drop"},
+		{SampleEvery: -1},
+		{Action: ActionDLQ, OverflowTable: "bad table"},
+		{DiskPath: "/does/not/exist", MinFreePercent: 10},
+	}
+	for _, cfg := range tests {
+		if _, err := New(cfg, newMockStorage()); err == nil {
+			t.Errorf("New(%+v): expected error", cfg)
+		}
+	}
+}
+
+func TestQuotaSample(t *testing.T) {
+	next := newMockStorage()
+	var breaches []Breach
+	g := newTestGuard(t, Config{
+		RowsPerDay:  map[string]int64{"readings": 2},
+		SampleEvery: 3,
+		OnBreach:    func(b Breach) { breaches = append(breaches, b) },
+	}, next)
+	now := time.Date(2025, 1, 1, 23, 0, 0, 0, time.UTC)
+	g.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for i := 0; i < 8; i++ {
+		if err := g.InsertIntoTable(ctx, "readings", map[string]interface{}{"i": i}); err != nil {
+			t.Fatalf("InsertIntoTable failed: %v", err)
+		}
+		g.InsertIntoTable(ctx, "other", map[string]interface{}{"i": i})
+	}
+	// 2 within the quota, then rows 3 and 6 of the 6 over it (1 in 3)
+	if n := len(next.rows["readings"]); n != 4 {
+		t.Errorf("stored %d readings, want 4", n)
+	}
+	if n := len(next.rows["other"]); n != 8 {
+		t.Errorf("stored %d rows of a table without quota, want 8", n)
+	}
+	if len(breaches) != 1 || breaches[0].Table != "readings" || g.Diverted() != 4 {
+		t.Errorf("breaches=%+v diverted=%d", breaches, g.Diverted())
+	}
+
+	// The quota resets at midnight UTC
+	now = now.Add(2 * time.Hour)
+	g.InsertIntoTable(ctx, "readings", map[string]interface{}{"i": 9})
+	if n := len(next.rows["readings"]); n != 5 {
+		t.Errorf("stored %d readings after midnight, want 5", n)
+	}
+}
+
+func TestQuotaDLQ(t *testing.T) {
+	next := newMockStorage()
+	g := newTestGuard(t, Config{RowsPerDay: map[string]int64{"readings": 1}, Action: ActionDLQ}, next)
+	rows := []map[string]interface{}{{"v": 1}, {"v": 2}, {"v": 3}}
+	if err := g.InsertBatch(context.Background(), "readings", rows); err != nil {
+		t.Fatalf("InsertBatch failed: %v", err)
+	}
+	if len(next.rows["readings"]) != 1 || next.batches != 1 {
+		t.Errorf("Expected one stored row in one batch, got %d rows in %d batches", len(next.rows["readings"]), next.batches)
+	}
+	overflow := next.rows[DefaultOverflowTable]
+	if len(overflow) != 2 || overflow[0]["target_table"] != "readings" || overflow[1]["data"].(map[string]interface{})["v"] != 3 {
+		t.Errorf("Unexpected overflow rows: %v", overflow)
+	}
+}
+
+func TestQuotaCountsStoredRowsOnly(t *testing.T) {
+	next := newMockStorage()
+	g := newTestGuard(t, Config{RowsPerDay: map[string]int64{"readings": 2}, Action: ActionDLQ}, next)
+	ctx := context.Background()
+
+	next.err = errors.New("database unavailable")
+	for i := 0; i < 3; i++ {
+		if err := g.InsertIntoTable(ctx, "readings", map[string]interface{}{"i": i}); err == nil {
+			t.Fatal("Expected the insert error")
+		}
+	}
+	if err := g.InsertBatch(ctx, "readings", []map[string]interface{}{{"i": 3}, {"i": 4}}); err == nil {
+		t.Fatal("Expected the batch error")
+	}
+
+	// Failed inserts didn't use up the quota
+	next.err = nil
+	if err := g.InsertBatch(ctx, "readings", []map[string]interface{}{{"i": 5}, {"i": 6}, {"i": 7}}); err != nil {
+		t.Fatalf("InsertBatch failed: %v", err)
+	}
+	if len(next.rows["readings"]) != 2 || len(next.rows[DefaultOverflowTable]) != 1 {
+		t.Errorf("stored=%d overflow=%d, want 2 and 1", len(next.rows["readings"]), len(next.rows[DefaultOverflowTable]))
+	}
+}
+
+func TestDiskGuard(t *testing.T) {
+	next := newMockStorage()
+	free := 50.0
+	var mu sync.Mutex
+	var breaches []Breach
+	g := newTestGuard(t, Config{
+		DiskPath:       t.TempDir(),
+		MinFreePercent: 10,
+		Action:         ActionDLQ,
+		OnBreach: func(b Breach) {
+			mu.Lock()
+			breaches = append(breaches, b)
+			mu.Unlock()
+		},
+	}, next)
+	g.free = func(string) (float64, error) { return free, nil }
+	g.Start()
+	ctx := context.Background()
+
+	g.InsertIntoTable(ctx, "readings", map[string]interface{}{"v": 1})
+	free = 5
+	g.checkDisk()
+	g.checkDisk() // Reported once
+	// Sampled one in ten even with the dlq action
+	for v := 2; v < 5; v++ {
+		g.InsertIntoTable(ctx, "readings", map[string]interface{}{"v": v})
+	}
+	free = 20
+	g.checkDisk()
+	g.InsertIntoTable(ctx, "readings", map[string]interface{}{"v": 5})
+
+	if len(next.rows["readings"]) != 3 || len(next.rows[DefaultOverflowTable]) != 0 || g.Diverted() != 2 {
+		t.Errorf("stored=%d overflow=%d diverted=%d, want 3, 0 and 2",
+			len(next.rows["readings"]), len(next.rows[DefaultOverflowTable]), g.Diverted())
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(breaches) != 1 || breaches[0].Table != "" {
+		t.Errorf("Unexpected breaches: %+v", breaches)
+	}
+}
+
+func TestFreePercent(t *testing.T) {
+	free, err := freePercent(t.TempDir())
+	if err != nil {
+		t.Skipf("free space check unsupported: %v", err)
+	}
+	if free < 0 || free > 100 {
+		t.Errorf("freePercent = %v", free)
+	}
+	if _, err := freePercent("/does/not/exist"); err == nil {
+		t.Error("Expected an error for a missing path")
+	}
+}
@@ -0,0 +1,97 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+
+	"github.com/marcgeld/hermod/internal/errs"
+)
+
+// ackState tracks what a message waits on before its source is acknowledged:
+// the worker processing it, plus every record it handed to a batch. The
+// message is acknowledged when the last of them completes, unless one failed
+// in a way redelivery can fix (storage unreachable, queue full, router
+// shutting down), so the broker delivers it again instead of it being lost.
+// Such a message is handed to nack instead, so its source knows to have it
+// redelivered.
+type ackState struct {
+	ack     func()
+	nack    func() // nil = nothing to do
+	pending atomic.Int32
+	failed  atomic.Bool
+}
+
+// newAckState returns the state of a message held by its worker
+func newAckState(ack, nack func()) *ackState {
+	a := &ackState{ack: ack, nack: nack}
+	a.pending.Store(1)
+	return a
+}
+
+// hold adds a write the message waits on
+func (a *ackState) hold() {
+	a.pending.Add(1)
+}
+
+// release completes one write, acknowledging the message after the last one
+func (a *ackState) release(err error) {
+	if redeliverable(err) {
+		a.failed.Store(true)
+	}
+	if a.pending.Add(-1) != 0 {
+		return
+	}
+	if !a.failed.Load() {
+		a.ack()
+	} else if a.nack != nil {
+		a.nack()
+	}
+}
+
+// releaseAll completes one write of each message, skipping nil entries
+// (rows without a message to acknowledge)
+func releaseAll(acks []*ackState, err error) {
+	for _, a := range acks {
+		if a != nil {
+			a.release(err)
+		}
+	}
+}
+
+// redeliverable reports whether err left a message unstored only because
+// storage, its schema or the router was unavailable, or its route's queue had
+// no room, so a redelivery would succeed
+func redeliverable(err error) bool {
+	return errors.Is(err, errs.ErrStorageUnavailable) || errors.Is(err, errs.ErrSchemaDrift) ||
+		errors.Is(err, errs.ErrQueueFull) || errors.Is(err, context.Canceled)
+}
+
+// ackKey is the context key of a message's ackState
+type ackKey struct{}
+
+// withAck returns ctx carrying a
+func withAck(ctx context.Context, a *ackState) context.Context {
+	return context.WithValue(ctx, ackKey{}, a)
+}
+
+// ackFrom returns the ackState carried by ctx, or nil
+func ackFrom(ctx context.Context) *ackState {
+	a, _ := ctx.Value(ackKey{}).(*ackState)
+	return a
+}
+
+// processAcked processes msg and acknowledges it to its source once its
+// records are written (see ackState)
+func (w *worker) processAcked(msg Message) error {
+	if msg.Ack == nil {
+		return w.processWithin(msg)
+	}
+	a := newAckState(msg.Ack, msg.Nack)
+	parent := w.ctx
+	w.ctx = withAck(parent, a)
+	err := w.processWithin(msg)
+	w.ctx = parent
+	a.release(err)
+	return err
+}
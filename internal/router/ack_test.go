@@ -0,0 +1,160 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// unavailableStorage fails every write as unreachable
+type unavailableStorage struct{}
+
+func (unavailableStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	return fmt.Errorf("connection refused: %w", errs.ErrStorageUnavailable)
+}
+
+// waitAcks waits until acks reaches want
+func waitAcks(t *testing.T, acks *atomic.Int32, want int32) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for acks.Load() < want && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if got := acks.Load(); got != want {
+		t.Fatalf("Expected %d acks, got %d", want, got)
+	}
+}
+
+func TestAckAfterInsert(t *testing.T) {
+	storage := newMockStorage()
+	routes := []Route{{Filter: "sensors/+", Workers: 1, QueueSize: 10, Table: "sensor_data"}}
+	r, err := New(context.Background(), routes, storage, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	defer r.Close()
+
+	var acks atomic.Int32
+	ack := func() { acks.Add(1) }
+	for _, topic := range []string{"sensors/t1", "other/t1"} {
+		if err := r.Dispatch(Message{Topic: topic, Payload: []byte(`{}`), QoS: 1, Time: time.Now(), Ack: ack}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	waitAcks(t, &acks, 2)
+	if storage.count("sensor_data") != 1 || storage.count("iot_raw") != 1 {
+		t.Errorf("Expected a routed and a passthrough row, got %d and %d", storage.count("sensor_data"), storage.count("iot_raw"))
+	}
+}
+
+func TestAckWithheldWhenStorageUnavailable(t *testing.T) {
+	routes := []Route{{Filter: "sensors/+", Workers: 1, QueueSize: 10}}
+	r, err := New(context.Background(), routes, unavailableStorage{}, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	var acks, nacks atomic.Int32
+	ack := func() { acks.Add(1) }
+	nack := func() { nacks.Add(1) }
+	r.Dispatch(Message{Topic: "sensors/t1", Payload: []byte(`{}`), QoS: 1, Time: time.Now(), Ack: ack, Nack: nack})
+	r.Dispatch(Message{Topic: "other/t1", Payload: []byte(`{}`), QoS: 1, Time: time.Now(), Ack: ack, Nack: nack})
+	r.Drain()
+	r.Close()
+
+	if acks.Load() != 0 {
+		t.Errorf("Expected no acks while storage is unavailable, got %d", acks.Load())
+	}
+	if nacks.Load() != 2 {
+		t.Errorf("Expected both messages handed back for redelivery, got %d", nacks.Load())
+	}
+}
+
+func TestAckWithheldWhenQueueFull(t *testing.T) {
+	routes := []Route{{Filter: "sensors/+", Workers: 1, QueueSize: 1}}
+	r, err := New(context.Background(), routes, blockingStorage{}, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	// The worker blocks on its first insert, so the queue fills up
+	var rejected []*atomic.Bool
+	var nacks atomic.Int32
+	for i := 0; i < 10; i++ {
+		acked := new(atomic.Bool)
+		err := r.Dispatch(Message{Topic: "sensors/t1", Payload: []byte(`{}`), QoS: 1, Time: time.Now(),
+			Ack: func() { acked.Store(true) }, Nack: func() { nacks.Add(1) }})
+		if errors.Is(err, errs.ErrQueueFull) {
+			rejected = append(rejected, acked)
+		}
+	}
+	if int(nacks.Load()) != len(rejected) {
+		t.Errorf("Expected %d messages handed back for redelivery, got %d", len(rejected), nacks.Load())
+	}
+	r.Close()
+	if len(rejected) == 0 {
+		t.Fatal("Expected the queue to fill up")
+	}
+	for _, acked := range rejected {
+		if acked.Load() {
+			t.Fatal("Expected messages rejected by a full queue left for redelivery")
+		}
+	}
+}
+
+func TestAckAfterBatchWritten(t *testing.T) {
+	storage := &batchStorage{mockStorage: newMockStorage()}
+	routes := []Route{{Filter: "sensors/+", Workers: 1, QueueSize: 10, Batch: &Batch{Size: 3, Linger: time.Hour}}}
+	r, err := New(context.Background(), routes, storage, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	var acks atomic.Int32
+	ack := func() { acks.Add(1) }
+	for i := 0; i < 4; i++ {
+		r.Dispatch(Message{Topic: "sensors/t1", Payload: []byte(`{}`), QoS: 1, Time: time.Now(), Ack: ack})
+	}
+	waitAcks(t, &acks, 3)
+
+	// The fourth row lingers until the router closes
+	time.Sleep(50 * time.Millisecond)
+	if acks.Load() != 3 {
+		t.Fatalf("Expected the lingering message unacknowledged, got %d acks", acks.Load())
+	}
+	r.Close()
+	if acks.Load() != 4 {
+		t.Errorf("Expected 4 acks after close, got %d", acks.Load())
+	}
+}
+
+func TestAckStateWaitsForEveryWrite(t *testing.T) {
+	var acks int
+	a := newAckState(func() { acks++ }, nil)
+	a.hold()
+	a.hold()
+	a.release(nil)
+	a.release(nil)
+	if acks != 0 {
+		t.Fatal("Expected no ack before the last write")
+	}
+	a.release(nil)
+	if acks != 1 {
+		t.Errorf("Expected 1 ack, got %d", acks)
+	}
+
+	var nacks int
+	a = newAckState(func() { acks++ }, func() { nacks++ })
+	a.hold()
+	a.release(fmt.Errorf("write: %w", errs.ErrStorageUnavailable))
+	a.release(nil)
+	if acks != 1 || nacks != 1 {
+		t.Errorf("Expected a nack and no ack after an unavailable write, got %d acks, %d nacks", acks-1, nacks)
+	}
+}
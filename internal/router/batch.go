@@ -0,0 +1,311 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/sink"
+)
+
+// Batch configures per-route insert batching. Workers hand records to a
+// batcher shared by the route and return to the next message; rows are
+// written per table once Size rows are pending or Linger has passed.
+//
+// When Chunk is set, rows of a batch are written grouped by the hypertable
+// chunk their "time" falls in, and by Partition within a chunk, so
+// TimescaleDB inserts into one chunk at a time instead of switching between
+// chunks row by row.
+type Batch struct {
+	Size      int           // Rows per table written together (default: 100)
+	Linger    time.Duration // Longest a row waits for its batch to fill (default: 100ms)
+	Chunk     time.Duration // Hypertable chunk interval (0 = rows keep arrival order)
+	Partition string        // Space partition column grouped within a chunk (empty = none)
+}
+
+// Batch defaults
+const (
+	defaultBatchSize   = 100
+	defaultBatchLinger = 100 * time.Millisecond
+)
+
+// batchWriteTimeout bounds each write of a batch (and of its rows when
+// retried one by one), so a hung insert can't block the writer, and with
+// it close, forever
+const batchWriteTimeout = 30 * time.Second
+
+// Validate checks the batch configuration
+func (b *Batch) Validate() error {
+	if b.Size < 0 {
+		return fmt.Errorf("batch size must not be negative")
+	}
+	if b.Linger < 0 {
+		return fmt.Errorf("batch linger must not be negative")
+	}
+	if b.Chunk < 0 {
+		return fmt.Errorf("batch chunk must not be negative")
+	}
+	if b.Partition != "" && b.Chunk == 0 {
+		return fmt.Errorf("batch partition requires a chunk interval")
+	}
+	return nil
+}
+
+// BatchAck is called for every batch a route writes. A rejected batch is
+// retried row by row and acknowledged twice: once with the stored rows and
+// err nil, and once with the rows that still failed and the last error.
+type BatchAck func(filter, table string, rows []map[string]interface{}, err error)
+
+// WithBatchAck sets the callback that acknowledges batched writes
+// (default: failed rows are logged)
+func WithBatchAck(fn BatchAck) Option {
+	return func(r *Router) {
+		r.onBatch = fn
+	}
+}
+
+// pendingBatch is a set of rows for one table waiting to be written
+type pendingBatch struct {
+	table string
+	rows  []map[string]interface{}
+	acks  []*ackState // Message of each row, acknowledged once its rows are written (nil = none)
+}
+
+// batcher is a Storage stage that collects records per table and writes
+// them from a single goroutine, so script throughput doesn't wait on the
+// database. Workers only block when a full batch is waiting to be written.
+type batcher struct {
+	cfg     Batch
+	filter  string
+	next    Storage
+	ack     BatchAck
+	logger  *logger.Logger
+	mu      sync.Mutex
+	pending map[string][]map[string]interface{}
+	acks    map[string][]*ackState // Index-aligned with pending
+	writes  chan pendingBatch
+	stop    chan struct{}
+	stopped sync.WaitGroup
+	written sync.WaitGroup
+	timeout time.Duration // Bounds each write (see batchWriteTimeout)
+
+	onDrift func(table string, row map[string]interface{}, err error) // Told about failed rows (nil = none)
+}
+
+// newBatcher creates a batching stage in front of next
+func newBatcher(cfg Batch, filter string, next Storage, ack BatchAck, log *logger.Logger) *batcher {
+	if cfg.Size == 0 {
+		cfg.Size = defaultBatchSize
+	}
+	if cfg.Linger == 0 {
+		cfg.Linger = defaultBatchLinger
+	}
+	return &batcher{
+		cfg:     cfg,
+		filter:  filter,
+		next:    next,
+		ack:     ack,
+		logger:  log,
+		pending: make(map[string][]map[string]interface{}),
+		acks:    make(map[string][]*ackState),
+		writes:  make(chan pendingBatch, 1),
+		stop:    make(chan struct{}),
+		timeout: batchWriteTimeout,
+	}
+}
+
+// start runs the writer and the periodic flush of lingering rows.
+// The writer outlives the router context so rows flushed on close are stored.
+func (b *batcher) start() {
+	b.written.Add(1)
+	go func() {
+		defer b.written.Done()
+		for batch := range b.writes {
+			b.write(batch)
+		}
+	}()
+
+	b.stopped.Add(1)
+	go func() {
+		defer b.stopped.Done()
+		ticker := time.NewTicker(b.cfg.Linger)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-b.stop:
+				return
+			case <-ticker.C:
+				b.flush()
+			}
+		}
+	}()
+}
+
+// close stops the flush loop and writes all pending rows
+func (b *batcher) close() {
+	close(b.stop)
+	b.stopped.Wait()
+	b.flush()
+	close(b.writes)
+	b.written.Wait()
+}
+
+// InsertIntoTable queues a record; it is written when its table's batch fills or lingers
+func (b *batcher) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	b.mu.Lock()
+	rows := append(b.pending[table], data)
+	a := ackFrom(ctx)
+	if a != nil {
+		a.hold()
+	}
+	acks := append(b.acks[table], a)
+	if len(rows) < b.cfg.Size {
+		b.pending[table] = rows
+		b.acks[table] = acks
+		b.mu.Unlock()
+		return nil
+	}
+	delete(b.pending, table)
+	delete(b.acks, table)
+	b.mu.Unlock()
+
+	select {
+	case b.writes <- pendingBatch{table: table, rows: rows, acks: acks}:
+		return nil
+	case <-ctx.Done():
+		releaseAll(acks, ctx.Err())
+		return fmt.Errorf("batch for %s not written: %w", table, ctx.Err())
+	}
+}
+
+// flush hands every pending batch to the writer
+func (b *batcher) flush() {
+	b.mu.Lock()
+	ready := make([]pendingBatch, 0, len(b.pending))
+	for table, rows := range b.pending {
+		ready = append(ready, pendingBatch{table: table, rows: rows, acks: b.acks[table]})
+	}
+	b.pending = make(map[string][]map[string]interface{})
+	b.acks = make(map[string][]*ackState)
+	b.mu.Unlock()
+
+	for _, batch := range ready {
+		b.writes <- batch
+	}
+}
+
+// write stores a batch, retrying row by row when the batch is rejected so
+// one bad row doesn't fail the others. Rows aren't retried when storage is
+// unreachable, since every one of them would fail the same way. Each message
+// is released with the outcome of its own rows, so a message whose rows were
+// stored isn't redelivered because another row failed.
+func (b *batcher) write(batch pendingBatch) {
+	b.groupByChunk(batch)
+	bs, ok := b.next.(sink.BatchStorage)
+	if ok {
+		ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+		err := bs.InsertBatch(ctx, batch.table, batch.rows)
+		cancel()
+		if err == nil {
+			b.acknowledge(batch.table, batch.rows, nil)
+			releaseAll(batch.acks, nil)
+			return
+		}
+		if errors.Is(err, errs.ErrStorageUnavailable) {
+			b.acknowledge(batch.table, batch.rows, err)
+			releaseAll(batch.acks, err)
+			return
+		}
+		b.logger.Errorf("Batch of %d rows into %s failed, retrying rows individually: %v", len(batch.rows), batch.table, err)
+	}
+
+	var stored, failed []map[string]interface{}
+	var lastErr error
+	for i, row := range batch.rows {
+		ctx, cancel := context.WithTimeout(context.Background(), b.timeout)
+		err := b.next.InsertIntoTable(ctx, batch.table, row)
+		cancel()
+		if a := batch.acks[i]; a != nil {
+			a.release(err)
+		}
+		if err != nil {
+			failed = append(failed, row)
+			lastErr = err
+			continue
+		}
+		stored = append(stored, row)
+	}
+	if len(stored) > 0 {
+		b.acknowledge(batch.table, stored, nil)
+	}
+	if len(failed) > 0 {
+		b.acknowledge(batch.table, failed, lastErr)
+		if b.onDrift != nil {
+			b.onDrift(batch.table, failed[len(failed)-1], lastErr)
+		}
+	}
+}
+
+// acknowledge reports a written batch to the ack callback, logging failures without one
+func (b *batcher) acknowledge(table string, rows []map[string]interface{}, err error) {
+	if b.ack != nil {
+		b.ack(b.filter, table, rows, err)
+		return
+	}
+	if err != nil {
+		b.logger.Errorf("Failed to write %d batched rows into %s: %v", len(rows), table, err)
+	}
+}
+
+// groupByChunk orders rows by the chunk their time falls in, then by
+// partition value, keeping arrival order within a group. Chunks are
+// aligned to the Unix epoch like TimescaleDB's.
+func (b *batcher) groupByChunk(batch pendingBatch) {
+	rows := batch.rows
+	if b.cfg.Chunk == 0 || len(rows) < 2 {
+		return
+	}
+	now := time.Now()
+	order := chunkOrder{rows: rows, acks: batch.acks, chunks: make([]int64, len(rows)), parts: make([]string, len(rows))}
+	for i, row := range rows {
+		ns := sink.RecordTime(row, now).UnixNano()
+		chunk := ns / int64(b.cfg.Chunk)
+		if ns < 0 && ns%int64(b.cfg.Chunk) != 0 {
+			chunk--
+		}
+		order.chunks[i] = chunk
+		if b.cfg.Partition != "" {
+			order.parts[i] = fmt.Sprint(row[b.cfg.Partition])
+		}
+	}
+	sort.Stable(order)
+}
+
+// chunkOrder sorts rows (and their messages) by chunk number and partition value
+type chunkOrder struct {
+	rows   []map[string]interface{}
+	acks   []*ackState
+	chunks []int64
+	parts  []string
+}
+
+func (o chunkOrder) Len() int { return len(o.rows) }
+
+func (o chunkOrder) Less(i, j int) bool {
+	if o.chunks[i] != o.chunks[j] {
+		return o.chunks[i] < o.chunks[j]
+	}
+	return o.parts[i] < o.parts[j]
+}
+
+func (o chunkOrder) Swap(i, j int) {
+	o.rows[i], o.rows[j] = o.rows[j], o.rows[i]
+	o.acks[i], o.acks[j] = o.acks[j], o.acks[i]
+	o.chunks[i], o.chunks[j] = o.chunks[j], o.chunks[i]
+	o.parts[i], o.parts[j] = o.parts[j], o.parts[i]
+}
@@ -0,0 +1,289 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// batchStorage records batches and rejects any batch containing a "bad" row
+type batchStorage struct {
+	*mockStorage
+	mu      sync.Mutex
+	batches []int
+}
+
+func (b *batchStorage) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error {
+	for _, row := range rows {
+		if row["bad"] != nil {
+			return errors.New("batch rejected")
+		}
+	}
+	b.mu.Lock()
+	b.batches = append(b.batches, len(rows))
+	b.mu.Unlock()
+	for _, row := range rows {
+		b.mockStorage.InsertIntoTable(ctx, table, row)
+	}
+	return nil
+}
+
+func (b *batchStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	if data["bad"] != nil {
+		return errors.New("bad row")
+	}
+	return b.mockStorage.InsertIntoTable(ctx, table, data)
+}
+
+func TestBatchValidate(t *testing.T) {
+	if err := (&Batch{Size: -1}).Validate(); err == nil {
+		t.Error("Expected error for negative size")
+	}
+	if err := (&Batch{Linger: -time.Second}).Validate(); err == nil {
+		t.Error("Expected error for negative linger")
+	}
+	if err := (&Batch{Partition: "sensor_id"}).Validate(); err == nil {
+		t.Error("Expected error for partition without chunk")
+	}
+	if err := (&Batch{}).Validate(); err != nil {
+		t.Errorf("Expected defaults to be valid, got %v", err)
+	}
+}
+
+func TestBatcherWritesFullBatches(t *testing.T) {
+	storage := &batchStorage{mockStorage: newMockStorage()}
+	b := newBatcher(Batch{Size: 3, Linger: time.Hour}, "a/#", storage, nil, logger.New(logger.ERROR))
+	b.start()
+	ctx := context.Background()
+
+	for i := 0; i < 7; i++ {
+		if err := b.InsertIntoTable(ctx, "metrics", map[string]interface{}{"seq": float64(i)}); err != nil {
+			t.Fatalf("InsertIntoTable failed: %v", err)
+		}
+	}
+	b.close()
+
+	if storage.count("metrics") != 7 {
+		t.Fatalf("Expected 7 rows, got %d", storage.count("metrics"))
+	}
+	want := []int{3, 3, 1}
+	if len(storage.batches) != len(want) {
+		t.Fatalf("Expected batches %v, got %v", want, storage.batches)
+	}
+	for i, n := range want {
+		if storage.batches[i] != n {
+			t.Errorf("batch %d has %d rows, want %d", i, storage.batches[i], n)
+		}
+	}
+}
+
+func TestBatcherLingerFlushes(t *testing.T) {
+	storage := &batchStorage{mockStorage: newMockStorage()}
+	b := newBatcher(Batch{Size: 100, Linger: 10 * time.Millisecond}, "a/#", storage, nil, logger.New(logger.ERROR))
+	b.start()
+	defer b.close()
+
+	b.InsertIntoTable(context.Background(), "metrics", map[string]interface{}{"seq": 1.0})
+	time.Sleep(100 * time.Millisecond)
+	if storage.count("metrics") != 1 {
+		t.Errorf("Expected lingering row to be written, got %d rows", storage.count("metrics"))
+	}
+}
+
+func TestBatcherRetriesRejectedBatch(t *testing.T) {
+	storage := &batchStorage{mockStorage: newMockStorage()}
+
+	var mu sync.Mutex
+	var stored, failed int
+	ack := func(filter, table string, rows []map[string]interface{}, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if filter != "a/#" || table != "metrics" {
+			t.Errorf("ack for %s/%s, want a/#/metrics", filter, table)
+		}
+		if err != nil {
+			failed += len(rows)
+		} else {
+			stored += len(rows)
+		}
+	}
+
+	b := newBatcher(Batch{Size: 3, Linger: time.Hour}, "a/#", storage, ack, logger.New(logger.ERROR))
+	b.start()
+	ctx := context.Background()
+	b.InsertIntoTable(ctx, "metrics", map[string]interface{}{"seq": 1.0})
+	b.InsertIntoTable(ctx, "metrics", map[string]interface{}{"seq": 2.0, "bad": true})
+	b.InsertIntoTable(ctx, "metrics", map[string]interface{}{"seq": 3.0})
+	b.close()
+
+	if storage.count("metrics") != 2 {
+		t.Errorf("Expected the 2 good rows to be stored, got %d", storage.count("metrics"))
+	}
+	if stored != 2 || failed != 1 {
+		t.Errorf("Acked stored=%d failed=%d, want 2 and 1", stored, failed)
+	}
+}
+
+func TestBatcherGroupsRowsByChunk(t *testing.T) {
+	storage := &batchStorage{mockStorage: newMockStorage()}
+	var order []string
+	ack := func(filter, table string, rows []map[string]interface{}, err error) {
+		for _, row := range rows {
+			order = append(order, row["seq"].(string))
+		}
+	}
+
+	b := newBatcher(Batch{Size: 5, Linger: time.Hour, Chunk: time.Hour, Partition: "sensor_id"}, "a/#", storage, ack, logger.New(logger.ERROR))
+	b.start()
+	base := time.Date(2025, 1, 1, 10, 0, 0, 0, time.UTC)
+	for _, r := range []struct {
+		seq, sensor string
+		at          time.Duration
+	}{
+		{"b1", "b", 90 * time.Minute},
+		{"a1", "a", 10 * time.Minute},
+		{"b2", "b", 20 * time.Minute},
+		{"a2", "a", 70 * time.Minute},
+		{"a3", "a", 30 * time.Minute},
+	} {
+		b.InsertIntoTable(context.Background(), "metrics", map[string]interface{}{
+			"seq": r.seq, "sensor_id": r.sensor, "time": base.Add(r.at),
+		})
+	}
+	b.close()
+
+	want := []string{"a1", "a3", "b2", "a2", "b1"}
+	if fmt.Sprint(order) != fmt.Sprint(want) {
+		t.Errorf("Rows written in order %v, want %v", order, want)
+	}
+}
+
+// downStorage fails every write as unreachable and counts single-row attempts
+type downStorage struct {
+	rows atomic.Int64
+}
+
+func (d *downStorage) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error {
+	return fmt.Errorf("%w: connection refused", errs.ErrStorageUnavailable)
+}
+
+func (d *downStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	d.rows.Add(1)
+	return fmt.Errorf("%w: connection refused", errs.ErrStorageUnavailable)
+}
+
+func TestBatcherSkipsRowRetryWhenUnavailable(t *testing.T) {
+	storage := &downStorage{}
+	var failed atomic.Int64
+	ack := func(filter, table string, rows []map[string]interface{}, err error) {
+		if errors.Is(err, errs.ErrStorageUnavailable) {
+			failed.Add(int64(len(rows)))
+		}
+	}
+
+	b := newBatcher(Batch{Size: 3, Linger: time.Hour}, "a/#", storage, ack, logger.New(logger.ERROR))
+	b.start()
+	for i := 0; i < 3; i++ {
+		b.InsertIntoTable(context.Background(), "metrics", map[string]interface{}{"seq": float64(i)})
+	}
+	b.close()
+
+	if storage.rows.Load() != 0 || failed.Load() != 3 {
+		t.Errorf("row retries=%d failed=%d, want 0 and 3", storage.rows.Load(), failed.Load())
+	}
+}
+
+func TestRouterWithBatch(t *testing.T) {
+	storage := &batchStorage{mockStorage: newMockStorage()}
+	routes := []Route{
+		{
+			Filter:  "sensors/+",
+			Workers: 2,
+			Table:   "sensor_data",
+			Batch:   &Batch{Size: 5, Linger: time.Hour},
+		},
+	}
+
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	for i := 0; i < 12; i++ {
+		if err := r.Dispatch(Message{Topic: "sensors/a", Payload: []byte("x"), Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	r.Close()
+
+	if storage.count("sensor_data") != 12 {
+		t.Errorf("Expected 12 rows after close, got %d", storage.count("sensor_data"))
+	}
+}
+
+// driftBatchStorage rejects batches holding a "bad" row, whose single-row
+// retry then fails as schema drift
+type driftBatchStorage struct {
+	*batchStorage
+}
+
+func (d driftBatchStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	if data["bad"] != nil {
+		return fmt.Errorf("%w: column \"bad\" does not exist", errs.ErrSchemaDrift)
+	}
+	return d.mockStorage.InsertIntoTable(ctx, table, data)
+}
+
+func TestBatcherReleasesMessagesPerRow(t *testing.T) {
+	storage := driftBatchStorage{&batchStorage{mockStorage: newMockStorage()}}
+	b := newBatcher(Batch{Size: 3, Linger: time.Hour}, "a/#", storage, nil, logger.New(logger.ERROR))
+	b.start()
+
+	var acks, nacks atomic.Int32
+	rows := []map[string]interface{}{{"seq": 1.0}, {"seq": 2.0, "bad": true}, {"seq": 3.0}}
+	for _, row := range rows {
+		a := newAckState(func() { acks.Add(1) }, func() { nacks.Add(1) })
+		b.InsertIntoTable(withAck(context.Background(), a), "metrics", row)
+		a.release(nil) // The worker is done with the message
+	}
+	b.close()
+
+	// Only the message whose row failed is left for redelivery
+	if acks.Load() != 2 || nacks.Load() != 1 || storage.count("metrics") != 2 {
+		t.Errorf("acks=%d nacks=%d rows=%d, want 2, 1 and 2", acks.Load(), nacks.Load(), storage.count("metrics"))
+	}
+}
+
+// hangingStorage never completes a write before its context ends
+type hangingStorage struct{}
+
+func (hangingStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestBatcherBoundsWrites(t *testing.T) {
+	b := newBatcher(Batch{Size: 1, Linger: time.Hour}, "a/#", hangingStorage{}, nil, logger.New(logger.ERROR))
+	b.timeout = 10 * time.Millisecond
+	b.start()
+	b.InsertIntoTable(context.Background(), "metrics", map[string]interface{}{"seq": 1.0})
+
+	closed := make(chan struct{})
+	go func() {
+		b.close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("close blocked on a hung insert")
+	}
+}
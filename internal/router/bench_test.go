@@ -0,0 +1,149 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// discardStorage drops all records
+type discardStorage struct{}
+
+func (discardStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	return nil
+}
+
+// newBenchWorker creates a worker for script (empty = passthrough)
+func newBenchWorker(b *testing.B, script string) *worker {
+	b.Helper()
+	scriptPath := ""
+	if script != "" {
+		scriptPath = filepath.Join(b.TempDir(), "bench.lua")
+		if err := os.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+			b.Fatalf("failed to write script: %v", err)
+		}
+	}
+	w, err := newWorker(0, scriptPath, "bench", nil, discardStorage{}, context.Background(), logger.New(logger.ERROR))
+	if err != nil {
+		b.Fatalf("failed to create worker: %v", err)
+	}
+	b.Cleanup(func() {
+		if w.state != nil {
+			w.state.Close()
+		}
+	})
+	return w
+}
+
+func BenchmarkWorkerProcessTransform(b *testing.B) {
+	w := newBenchWorker(b, `
+function transform(msg)
+  return { { columns = { device = msg.topic_levels[2], temperature = msg.json.temperature } } }
+end
+`)
+	msg := Message{Topic: "ruuvi/abc", Payload: []byte(`{"temperature":21.5,"humidity":40}`), Time: time.Now()}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := w.process(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWorkerProcessExampleScript(b *testing.B) {
+	script, err := os.ReadFile(filepath.Join("..", "..", "examples", "routing_transform.lua"))
+	if err != nil {
+		b.Fatalf("failed to read example script: %v", err)
+	}
+	w := newBenchWorker(b, string(script))
+	msg := Message{
+		Topic:   "ruuvi/c4:7c:8d:6a:11:22",
+		Payload: []byte(`{"temperature":21.5,"humidity":40.25,"pressure":1013.2,"battery":2.95,"rssi":-71,"tags":["kitchen","north"]}`),
+		Time:    time.Now(),
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := w.process(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkParseRecords(b *testing.B) {
+	w := newBenchWorker(b, `
+function transform(msg) return {} end
+result = {
+  { table = "a", columns = { time = "2024-01-01T00:00:00Z", device = "x", temperature = 21.5, humidity = 40, ok = true } },
+  { table = "b", columns = { time = "2024-01-01T00:00:00Z", device = "x", raw = { nested = { 1, 2, 3 } } } },
+}
+`)
+	tbl, ok := w.state.GetGlobal("result").(*lua.LTable)
+	if !ok {
+		b.Fatal("result table not found")
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := w.parseRecords(tbl); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWorkerProcessPassthrough(b *testing.B) {
+	w := newBenchWorker(b, "")
+	msg := Message{Topic: "legacy/abc", Payload: []byte(`{"temperature":21.5}`), Time: time.Now()}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := w.process(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkDispatch(b *testing.B) {
+	// Queue sized to b.N so the benchmark measures routing, not worker throughput
+	routes := []Route{{Filter: "ruuvi/+", QueueSize: b.N}}
+	r, err := New(context.Background(), routes, discardStorage{}, logger.New(logger.ERROR))
+	if err != nil {
+		b.Fatalf("failed to create router: %v", err)
+	}
+	defer r.Close()
+	msg := Message{Topic: "ruuvi/abc", Payload: []byte(`{"temperature":21.5}`), Time: time.Now()}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err := r.Dispatch(msg); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkNewScriptWorker(b *testing.B) {
+	scriptPath := filepath.Join(b.TempDir(), "bench.lua")
+	if err := os.WriteFile(scriptPath, []byte(`function transform(msg) return {} end`), 0644); err != nil {
+		b.Fatalf("failed to write script: %v", err)
+	}
+	proto, err := compileScript(scriptPath)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		w, err := newScriptWorker(0, proto, "bench", nil, discardStorage{}, context.Background(), logger.New(logger.ERROR))
+		if err != nil {
+			b.Fatal(err)
+		}
+		w.state.Close()
+	}
+}
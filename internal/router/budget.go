@@ -0,0 +1,183 @@
+package router
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// ErrorBudget configures error budget alerts: a route is over its budget
+// when more than Percent of the messages it processed within the last
+// Window failed. Failures are messages whose processing returned an error
+// (transform errors, failed inserts, timeouts), the same ones logged as
+// "failed to process".
+type ErrorBudget struct {
+	Percent     float64       // Largest acceptable share of failed messages (0 = disabled)
+	Window      time.Duration // Rolling window the rate is measured over (default: 5m)
+	MinMessages int           // Messages the window must hold before the rate counts (default: 20)
+}
+
+// Error budget defaults
+const (
+	DefaultBudgetWindow      = 5 * time.Minute
+	DefaultBudgetMinMessages = 20
+)
+
+// budgetSlots is the number of slots the window is split into; outcomes
+// expire one slot at a time
+const budgetSlots = 10
+
+// Validate checks the error budget
+func (b *ErrorBudget) Validate() error {
+	if b.Percent < 0 || b.Percent >= 100 {
+		return fmt.Errorf("error budget percent must be between 0 and 100")
+	}
+	if b.Window != 0 && b.Window < budgetSlots*time.Second {
+		return fmt.Errorf("error budget window must be at least %ds", budgetSlots)
+	}
+	if b.MinMessages < 0 {
+		return fmt.Errorf("error budget min_messages must not be negative")
+	}
+	return nil
+}
+
+// BudgetBreach describes a route going over its error budget
+type BudgetBreach struct {
+	Filter  string        // Route filter
+	Failed  int64         // Failed messages within the window
+	Total   int64         // Messages processed within the window
+	Window  time.Duration // Window the messages were counted over
+	Percent float64       // The route's budget
+}
+
+// Rate returns the share of failed messages in percent
+func (b BudgetBreach) Rate() float64 {
+	return 100 * float64(b.Failed) / float64(b.Total)
+}
+
+// ErrorBudgetHandler is called once when a route goes over its error budget;
+// it's called again only after the route's error rate has recovered
+type ErrorBudgetHandler func(BudgetBreach)
+
+// WithErrorBudget enables error budget alerts for every route. Routes can
+// set their own percent with Route.ErrorBudget.
+func WithErrorBudget(b ErrorBudget, fn ErrorBudgetHandler) Option {
+	return func(r *Router) {
+		r.budget = b
+		r.onBudget = fn
+	}
+}
+
+// budgetSlot counts the outcomes of one slot of the window
+type budgetSlot struct {
+	start         time.Time
+	total, failed int64
+}
+
+// errorBudget tracks a route's error rate over a rolling window
+type errorBudget struct {
+	percent  float64
+	window   time.Duration
+	min      int64
+	filter   string
+	logger   *logger.Logger
+	onBreach ErrorBudgetHandler
+	now      func() time.Time
+
+	mu       sync.Mutex
+	slots    [budgetSlots]budgetSlot
+	breached bool
+}
+
+// newErrorBudget creates the error budget of a route (nil when disabled)
+func newErrorBudget(b ErrorBudget, percent float64, filter string, log *logger.Logger, fn ErrorBudgetHandler) *errorBudget {
+	if percent == 0 {
+		percent = b.Percent
+	}
+	if percent == 0 {
+		return nil
+	}
+	if b.Window == 0 {
+		b.Window = DefaultBudgetWindow
+	}
+	if b.MinMessages == 0 {
+		b.MinMessages = DefaultBudgetMinMessages
+	}
+	return &errorBudget{
+		percent:  percent,
+		window:   b.Window,
+		min:      int64(b.MinMessages),
+		filter:   filter,
+		logger:   log,
+		onBreach: fn,
+		now:      time.Now,
+	}
+}
+
+// observe records the outcome of a message and reports a breach or recovery
+func (e *errorBudget) observe(failed bool) {
+	if e == nil {
+		return
+	}
+	e.mu.Lock()
+	now := e.now()
+	width := e.window / budgetSlots
+	start := now.Truncate(width)
+	slot := &e.slots[(start.UnixNano()/int64(width))%budgetSlots]
+	if !slot.start.Equal(start) {
+		*slot = budgetSlot{start: start}
+	}
+	slot.total++
+	if failed {
+		slot.failed++
+	}
+	breach := e.count(now)
+	over := breach.Total >= e.min && breach.Rate() > e.percent
+	fire, recovered := over && !e.breached, e.breached && breach.Rate() <= e.percent
+	if fire {
+		e.breached = true
+	} else if recovered {
+		e.breached = false
+	}
+	e.mu.Unlock()
+
+	if fire {
+		e.logger.Errorf("Route %s over its error budget: %d of %d messages failed in the last %s (%.1f%%, budget %g%%)",
+			e.filter, breach.Failed, breach.Total, e.window, breach.Rate(), e.percent)
+		if e.onBreach != nil {
+			e.onBreach(breach)
+		}
+	} else if recovered {
+		e.logger.Infof("Route %s back within its error budget (%.1f%% of %d messages failed in the last %s)",
+			e.filter, breach.Rate(), breach.Total, e.window)
+	}
+}
+
+// count sums the slots within the window. The caller holds e.mu.
+func (e *errorBudget) count(now time.Time) BudgetBreach {
+	b := BudgetBreach{Filter: e.filter, Window: e.window, Percent: e.percent}
+	for _, s := range e.slots {
+		if !s.start.IsZero() && now.Sub(s.start) < e.window {
+			b.Total += s.total
+			b.Failed += s.failed
+		}
+	}
+	return b
+}
+
+// status returns the error rate in percent over the window (0 when disabled
+// or idle) and whether the route is over its budget
+func (e *errorBudget) status() (float64, bool) {
+	if e == nil {
+		return 0, false
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	b := e.count(e.now())
+	if b.Total == 0 {
+		return 0, e.breached
+	}
+	return b.Rate(), e.breached
+}
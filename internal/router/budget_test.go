@@ -0,0 +1,123 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+func TestErrorBudget(t *testing.T) {
+	var breaches []BudgetBreach
+	e := newErrorBudget(ErrorBudget{Window: time.Minute, MinMessages: 10}, 20, "a/#", logger.New(logger.ERROR),
+		func(b BudgetBreach) { breaches = append(breaches, b) })
+	now := time.Unix(1_700_000_000, 0)
+	e.now = func() time.Time { return now }
+
+	// Below min_messages nothing fires, even at 100% failures
+	for i := 0; i < 5; i++ {
+		e.observe(true)
+	}
+	if len(breaches) != 0 {
+		t.Fatalf("Expected no breach below min_messages, got %v", breaches)
+	}
+	for i := 0; i < 5; i++ {
+		e.observe(false)
+	}
+	if len(breaches) != 1 || breaches[0].Failed != 5 || breaches[0].Total != 10 || breaches[0].Rate() != 50 {
+		t.Fatalf("Expected a single breach at 5 of 10, got %v", breaches)
+	}
+	e.observe(true)
+	if len(breaches) != 1 {
+		t.Fatalf("Expected the breach reported once, got %d", len(breaches))
+	}
+	if rate, over := e.status(); !over || rate <= 50 {
+		t.Errorf("Expected over budget, got %.1f%% %v", rate, over)
+	}
+
+	// Once the failures leave the window the route recovers and can breach again
+	now = now.Add(2 * time.Minute)
+	for i := 0; i < 10; i++ {
+		e.observe(false)
+	}
+	if rate, over := e.status(); over || rate != 0 {
+		t.Errorf("Expected recovery, got %.1f%% %v", rate, over)
+	}
+	for i := 0; i < 10; i++ {
+		e.observe(true)
+	}
+	if len(breaches) != 2 {
+		t.Errorf("Expected a second breach after recovery, got %d", len(breaches))
+	}
+}
+
+func TestErrorBudgetDisabled(t *testing.T) {
+	if e := newErrorBudget(ErrorBudget{}, 0, "a/#", nil, nil); e != nil {
+		t.Error("Expected no error budget without a percent")
+	}
+	if e := newErrorBudget(ErrorBudget{}, 5, "a/#", nil, nil); e == nil || e.window != DefaultBudgetWindow || e.min != DefaultBudgetMinMessages {
+		t.Errorf("Expected a route percent to enable the budget with defaults, got %+v", e)
+	}
+	for _, b := range []ErrorBudget{{Percent: 100}, {Percent: -1}, {Window: time.Second}, {MinMessages: -1}} {
+		if err := b.Validate(); err == nil {
+			t.Errorf("Expected %+v rejected", b)
+		}
+	}
+}
+
+func TestRouterErrorBudget(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "flaky.lua")
+	scriptCode := `
+function transform(msg)
+  if msg.json.ok then
+    return { { columns = { v = 1 } } }
+  end
+  error("boom")
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	var mu sync.Mutex
+	var breaches []BudgetBreach
+	onBudget := func(b BudgetBreach) {
+		mu.Lock()
+		defer mu.Unlock()
+		breaches = append(breaches, b)
+	}
+
+	storage := newMockStorage()
+	routes := []Route{
+		{Filter: "flaky/+", Script: scriptPath, Table: "flaky", ErrorBudget: 25},
+		{Filter: "steady/+", Script: scriptPath, Table: "steady"},
+	}
+	r, err := New(context.Background(), routes, storage, logger.New(logger.ERROR),
+		WithErrorBudget(ErrorBudget{MinMessages: 4}, onBudget))
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	for _, payload := range []string{`{"ok":true}`, `{"ok":false}`, `{"ok":true}`, `{"ok":false}`} {
+		for _, topic := range []string{"flaky/a", "steady/a"} {
+			if err := r.Dispatch(Message{Topic: topic, Payload: []byte(payload), Time: time.Now()}); err != nil {
+				t.Fatalf("Dispatch failed: %v", err)
+			}
+		}
+	}
+	r.Drain()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(breaches) != 1 || breaches[0].Filter != "flaky/+" || breaches[0].Failed != 2 || breaches[0].Total != 4 {
+		t.Fatalf("Expected a single breach of flaky/+, got %+v", breaches)
+	}
+	for _, st := range r.RouteStatus() {
+		if want := st.Filter == "flaky/+"; st.OverBudget != want || (want && st.ErrorRate != 50) {
+			t.Errorf("Unexpected budget status: %+v", st)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package router
+
+import (
+	"slices"
+
+	"github.com/marcgeld/hermod/internal/decoder"
+	hermodlua "github.com/marcgeld/hermod/internal/lua"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// builtinHelpers names the globals the router provides to route scripts.
+// lookup, device_info and silent_devices are set only when their feature is configured.
+var builtinHelpers = []string{"device_info", "dsmr_decode", "lookup", "modbus_decode", "mqtt_publish", "ruuvi_decode", "shared", "silent_devices"}
+
+// LuaHelpers returns the helper functions route scripts can call in this
+// binary: the router's built-ins and those added with lua.RegisterLuaFunc
+func LuaHelpers() []string {
+	names := append(slices.Clone(builtinHelpers), hermodlua.RegisteredNames()...)
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// registerBuiltins registers the Go-backed decoder helpers available to every route script:
+//
+//	ruuvi_decode(hex_or_bytes) -> (table | nil, error | nil)
+//	dsmr_decode(telegram)      -> (table | nil, error | nil)
+//	modbus_decode(payload, map) -> (table | nil, error | nil)
+//
+// modbus_decode only knows table maps here; WithModbusMaps adds named ones.
+func registerBuiltins(L *lua.LState) {
+	L.SetGlobal("modbus_decode", L.NewFunction(modbusDecode(nil)))
+	L.SetGlobal("ruuvi_decode", L.NewFunction(func(L *lua.LState) int {
+		fields, err := decoder.Ruuvi(decoder.RuuviInput(L.CheckString(1)))
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(jsonToLTable(L, fields))
+		L.Push(lua.LNil)
+		return 2
+	}))
+	L.SetGlobal("dsmr_decode", L.NewFunction(func(L *lua.LState) int {
+		fields, err := decoder.DSMR(L.CheckString(1))
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(jsonToLTable(L, fields))
+		L.Push(lua.LNil)
+		return 2
+	}))
+}
+
+// WithLuaFunc exposes fn to this router's scripts as the global name, set
+// after the built-in and globally registered helpers (see lua.RegisterLuaFunc)
+// so it can override them
+func WithLuaFunc(name string, fn lua.LGFunction) Option {
+	return func(r *Router) {
+		r.luaSetup = append(r.luaSetup, func(L *lua.LState) {
+			L.SetGlobal(name, L.NewFunction(fn))
+		})
+	}
+}
@@ -0,0 +1,136 @@
+package router
+
+import (
+	"sync"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// maxCacheEntries bounds a route's Lua cache; when it is full, expired
+// entries are dropped first, then the entry closest to expiring
+const maxCacheEntries = 10000
+
+// ttlCache holds the values a route's workers memoize through the Lua
+// "cache" table. Values are stored as Go copies, so tables can be cached
+// and every get returns a fresh table.
+type ttlCache struct {
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+	max     int
+	now     func() time.Time
+}
+
+// cacheEntry is a cached value and when it expires
+type cacheEntry struct {
+	value   interface{}
+	expires time.Time
+}
+
+func newTTLCache() *ttlCache {
+	return &ttlCache{entries: make(map[string]cacheEntry), max: maxCacheEntries, now: time.Now}
+}
+
+// register exposes the cache to a Lua state as the global table "cache":
+//
+//	cache.get(key)             -> value | nil (nil once the entry has expired)
+//	cache.put(key, value, ttl) -- ttl in seconds; a nil value removes the key
+func (c *ttlCache) register(L *lua.LState) {
+	L.SetGlobal("cache", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"get": func(L *lua.LState) int {
+			key := L.CheckString(1)
+			v, ok := c.get(key)
+			if !ok {
+				L.Push(lua.LNil)
+				return 1
+			}
+			L.Push(jsonToLTable(L, v))
+			return 1
+		},
+		"put": func(L *lua.LState) int {
+			key := L.CheckString(1)
+			v := L.Get(2)
+			if v == lua.LNil {
+				c.delete(key)
+				return 0
+			}
+			ttl := float64(L.CheckNumber(3))
+			if ttl <= 0 {
+				L.ArgError(3, "ttl must be a positive number of seconds")
+			}
+			if !cacheable(v) {
+				L.ArgError(2, "cached values must be strings, numbers, booleans or tables of them")
+			}
+			c.put(key, lvalueToInterface(v), time.Duration(ttl*float64(time.Second)))
+			return 0
+		},
+	}))
+}
+
+// cacheable reports whether v holds only values that survive a Go copy
+func cacheable(v lua.LValue) bool {
+	switch v.Type() {
+	case lua.LTString, lua.LTNumber, lua.LTBool:
+		return true
+	case lua.LTTable:
+		ok := true
+		v.(*lua.LTable).ForEach(func(_, value lua.LValue) {
+			if ok && !cacheable(value) {
+				ok = false
+			}
+		})
+		return ok
+	}
+	return false
+}
+
+// get returns the value for key unless it is missing or expired
+func (c *ttlCache) get(key string) (interface{}, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	e, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if !c.now().Before(e.expires) {
+		delete(c.entries, key)
+		return nil, false
+	}
+	return e.value, true
+}
+
+// put stores value for ttl, making room when the cache is full
+func (c *ttlCache) put(key string, value interface{}, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	now := c.now()
+	if _, ok := c.entries[key]; !ok && len(c.entries) >= c.max {
+		c.evict(now)
+	}
+	c.entries[key] = cacheEntry{value: value, expires: now.Add(ttl)}
+}
+
+// delete removes key
+func (c *ttlCache) delete(key string) {
+	c.mu.Lock()
+	delete(c.entries, key)
+	c.mu.Unlock()
+}
+
+// evict drops expired entries, or the entry closest to expiring when none has
+func (c *ttlCache) evict(now time.Time) {
+	var soonest string
+	var soonestAt time.Time
+	for key, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, key)
+			continue
+		}
+		if soonest == "" || e.expires.Before(soonestAt) {
+			soonest, soonestAt = key, e.expires
+		}
+	}
+	if len(c.entries) >= c.max {
+		delete(c.entries, soonest)
+	}
+}
@@ -0,0 +1,102 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTTLCacheExpiry(t *testing.T) {
+	c := newTTLCache()
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	c.put("a", "x", time.Minute)
+	if v, ok := c.get("a"); !ok || v != "x" {
+		t.Fatalf("get = %v, %v; want x", v, ok)
+	}
+	now = now.Add(time.Minute)
+	if _, ok := c.get("a"); ok {
+		t.Error("Expected the entry to expire after its ttl")
+	}
+	if len(c.entries) != 0 {
+		t.Errorf("Expired entry kept: %v", c.entries)
+	}
+}
+
+func TestTTLCacheEviction(t *testing.T) {
+	c := newTTLCache()
+	c.max = 2
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	c.now = func() time.Time { return now }
+
+	c.put("long", 1.0, time.Hour)
+	c.put("short", 2.0, time.Minute)
+	c.put("new", 3.0, time.Hour) // full: drops the entry closest to expiring
+	if _, ok := c.get("short"); ok {
+		t.Error("Expected the soonest-expiring entry to be evicted")
+	}
+	if _, ok := c.get("long"); !ok {
+		t.Error("Expected the long-lived entry to stay")
+	}
+
+	// Expired entries make room before live ones are evicted
+	now = now.Add(2 * time.Hour)
+	c.put("a", 1.0, time.Minute)
+	c.put("b", 2.0, time.Minute)
+	if _, ok := c.get("a"); !ok {
+		t.Error("Expected a to be cached after expired entries were dropped")
+	}
+}
+
+func TestScriptCache(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "cache.lua")
+	scriptCode := `
+function transform(msg)
+  local hit = cache.get("site") ~= nil
+  if not hit then
+    cache.put("site", {name = "plant-3", lines = {"A", "B"}}, 60)
+  end
+  local site = cache.get("site")
+  site.name = "changed" -- callers get a copy
+  local bad = pcall(cache.put, "f", function() end, 60)
+  local zero = pcall(cache.put, "z", 1, 0)
+  cache.put("gone", 1, 60)
+  cache.put("gone", nil)
+  return {{ columns = {
+    hit = hit, name = cache.get("site").name, line = site.lines[2],
+    function_rejected = not bad, zero_ttl_rejected = not zero, gone = cache.get("gone") == nil
+  } }}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	// Two workers of a route share one cache
+	c := newTTLCache()
+	storage := newMockStorage()
+	for i := 0; i < 2; i++ {
+		w, err := newWorker(i, scriptPath, "sites", nil, storage, context.Background(), nil, c.register)
+		if err != nil {
+			t.Fatalf("failed to create worker: %v", err)
+		}
+		if err := w.process(Message{Topic: "plant/3", Payload: []byte(`{}`), Time: time.Now()}); err != nil {
+			t.Fatalf("process failed: %v", err)
+		}
+		w.state.Close()
+	}
+
+	rows := storage.inserts["sites"]
+	if rows[0]["hit"] != false || rows[1]["hit"] != true {
+		t.Errorf("Expected a miss then a hit across workers, got %v and %v", rows[0]["hit"], rows[1]["hit"])
+	}
+	for _, row := range rows {
+		if row["name"] != "plant-3" || row["line"] != "B" || row["function_rejected"] != true ||
+			row["zero_ttl_rejected"] != true || row["gone"] != true {
+			t.Errorf("Unexpected row %v", row)
+		}
+	}
+}
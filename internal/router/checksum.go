@@ -0,0 +1,30 @@
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// ChecksumColumn holds the hex SHA-256 of the raw payload in passthrough
+// records when WithPayloadChecksum is set
+const ChecksumColumn = "raw_sha256"
+
+// WithPayloadChecksum adds the SHA-256 of the raw payload to every record
+// in the passthrough format (passthrough, reject and state tables), so
+// stored payloads can be verified and reconciled with other systems by hash
+func WithPayloadChecksum() Option {
+	return func(r *Router) {
+		r.passthrough.checksum = true
+	}
+}
+
+// record creates the passthrough record of msg, with its payload checksum
+// when enabled
+func (h *passthroughHandler) record(msg Message, doc parsedJSON) map[string]interface{} {
+	record := passthroughRecord(msg, doc)
+	if h != nil && h.checksum {
+		sum := sha256.Sum256(msg.Payload)
+		record[ChecksumColumn] = hex.EncodeToString(sum[:])
+	}
+	return record
+}
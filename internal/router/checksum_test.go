@@ -0,0 +1,45 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRouterPayloadChecksum(t *testing.T) {
+	storage := newMockStorage()
+	routes := []Route{{Filter: "raw/+", Table: "raw_data"}}
+	r, err := New(context.Background(), routes, storage, nil, WithPayloadChecksum())
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	for _, topic := range []string{"raw/a", "other/a"} {
+		if err := r.Dispatch(Message{Topic: topic, Payload: []byte("hello"), Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	r.Drain()
+
+	// sha256("hello")
+	const want = "2cf24dba5fb0a30e26e83b2ac5b9e29e1b161e5c1fa7425e73043362938b9824"
+	for _, table := range []string{"raw_data", "iot_raw"} {
+		rows := storage.inserts[table]
+		if len(rows) != 1 || rows[0][ChecksumColumn] != want {
+			t.Errorf("%s rows = %v, want the payload checksum", table, rows)
+		}
+	}
+}
+
+func TestRouterPayloadChecksumDisabled(t *testing.T) {
+	storage := newMockStorage()
+	r, err := New(context.Background(), nil, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	if err := r.Dispatch(Message{Topic: "other/a", Payload: []byte("hello"), Time: time.Now()}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	if _, ok := storage.inserts["iot_raw"][0][ChecksumColumn]; ok {
+		t.Error("Expected no checksum column by default")
+	}
+}
@@ -0,0 +1,124 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/schema"
+)
+
+// Column name case policies. PostgreSQL folds unquoted identifiers to
+// lowercase, so a Lua key like "deviceId" is stored as column deviceid.
+const (
+	ColumnCasePreserve    = "preserve"     // Use column names as the script returns them (default)
+	ColumnCaseLowercase   = "lowercase"    // Fold column names to lowercase, as PostgreSQL does
+	ColumnCaseRejectMixed = "reject-mixed" // Fail records with upper-case letters in column names
+)
+
+// Policies for record columns the table schema doesn't declare
+const (
+	UnknownColumnsReject = "reject" // Fail the record with errs.ErrSchemaViolation (default)
+	UnknownColumnsDrop   = "drop"   // Store the record without them, counting each dropped column
+)
+
+// WithColumnCase sets the policy applied to record column names before
+// validation and storage
+func WithColumnCase(policy string) Option {
+	return func(r *Router) {
+		r.columnCase = policy
+	}
+}
+
+// validateColumnCase checks a column case policy ("" = preserve)
+func validateColumnCase(policy string) error {
+	switch policy {
+	case "", ColumnCasePreserve, ColumnCaseLowercase, ColumnCaseRejectMixed:
+		return nil
+	}
+	return fmt.Errorf("invalid column case policy %q: use preserve, lowercase or reject-mixed", policy)
+}
+
+// validateUnknownColumns checks a route's unknown columns policy ("" = reject)
+func validateUnknownColumns(policy string) error {
+	switch policy {
+	case "", UnknownColumnsReject, UnknownColumnsDrop:
+		return nil
+	}
+	return fmt.Errorf("invalid unknown_columns %q: use reject or drop", policy)
+}
+
+// applyColumnCase applies the worker's column case policy to rec, which is
+// written to table
+func (w *worker) applyColumnCase(rec *Record, table string) error {
+	if w.columnCase == "" || w.columnCase == ColumnCasePreserve {
+		return nil
+	}
+	mixed := false
+	for col := range rec.Columns {
+		if strings.ToLower(col) != col {
+			mixed = true
+			break
+		}
+	}
+	if !mixed {
+		return nil
+	}
+
+	if w.columnCase == ColumnCaseRejectMixed {
+		for col := range rec.Columns {
+			if strings.ToLower(col) != col {
+				return fmt.Errorf("%w: column %q for table %s has upper-case letters, which PostgreSQL folds to %q",
+					errs.ErrSchemaViolation, col, table, strings.ToLower(col))
+			}
+		}
+	}
+
+	folded := make(map[string]interface{}, len(rec.Columns))
+	for col, v := range rec.Columns {
+		lower := strings.ToLower(col)
+		if _, dup := folded[lower]; dup {
+			return fmt.Errorf("%w: columns for table %s collide when folded to %q", errs.ErrSchemaViolation, table, lower)
+		}
+		folded[lower] = v
+	}
+	rec.Columns = folded
+	return nil
+}
+
+// declaredFolded reports whether the table schema declares every column,
+// ignoring case; used when column names are folded to lowercase
+func declaredFolded(t *schema.TableSchema, columns map[string]interface{}) bool {
+	declared := make(map[string]bool, len(t.Columns))
+	for col := range t.Columns {
+		declared[strings.ToLower(col)] = true
+	}
+	for col := range columns {
+		if !declared[col] {
+			return false
+		}
+	}
+	return true
+}
+
+// dropUndeclared removes the columns t doesn't declare, for routes whose
+// devices add experimental fields the schema doesn't know yet. Dropped
+// columns are counted on the route.
+func (w *worker) dropUndeclared(t *schema.TableSchema, columns map[string]interface{}) {
+	for col := range columns {
+		if _, ok := t.Columns[col]; ok {
+			continue
+		}
+		if w.columnCase == ColumnCaseLowercase && declaredFolded(t, map[string]interface{}{col: nil}) {
+			continue
+		}
+		delete(columns, col)
+		if w.handler != nil {
+			w.handler.droppedColumns.Add(1)
+		}
+		if w.logger.Enabled(logger.DEBUG) {
+			w.logger.Debugf("Dropped column %s not declared for table %s", col, t.Name)
+		}
+	}
+}
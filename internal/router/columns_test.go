@@ -0,0 +1,128 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+)
+
+func TestWorkerColumnCase(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "camel.lua")
+	scriptCode := `
+schema = {
+  tables = {
+    readings = { time = "timestamptz", deviceId = "text", batteryLevel = "double precision" }
+  }
+}
+
+function transform(msg)
+  return {{ table = "readings", columns = { time = msg.ts, deviceId = "a1", batteryLevel = 97 } }}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+	msg := Message{Topic: "sensors/a", Payload: []byte(`{}`), Time: time.Now()}
+
+	tests := []struct {
+		policy  string
+		columns []string // Stored column names (nil = rejected)
+	}{
+		{"", []string{"time", "deviceId", "batteryLevel"}},
+		{ColumnCasePreserve, []string{"time", "deviceId", "batteryLevel"}},
+		{ColumnCaseLowercase, []string{"time", "deviceid", "batterylevel"}},
+		{ColumnCaseRejectMixed, nil},
+	}
+	for _, tt := range tests {
+		storage := newMockStorage()
+		w, err := newWorker(1, scriptPath, "readings", nil, storage, context.Background(), nil)
+		if err != nil {
+			t.Fatalf("failed to create worker: %v", err)
+		}
+		w.columnCase = tt.policy
+
+		err = w.process(msg)
+		w.state.Close()
+		if tt.columns == nil {
+			if !errors.Is(err, errs.ErrSchemaViolation) || storage.count("readings") != 0 {
+				t.Errorf("%s: err = %v, rows = %d; want a schema violation and no rows", tt.policy, err, storage.count("readings"))
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: process failed: %v", tt.policy, err)
+		}
+		row := storage.inserts["readings"][0]
+		if len(row) != len(tt.columns) {
+			t.Errorf("%s: row = %v, want columns %v", tt.policy, row, tt.columns)
+		}
+		for _, col := range tt.columns {
+			if _, ok := row[col]; !ok {
+				t.Errorf("%s: row = %v, missing column %s", tt.policy, row, col)
+			}
+		}
+	}
+}
+
+func TestColumnCaseCollision(t *testing.T) {
+	w := &worker{columnCase: ColumnCaseLowercase}
+	rec := Record{Columns: map[string]interface{}{"Temp": 1.0, "temp": 2.0}}
+	if err := w.applyColumnCase(&rec, "readings"); !errors.Is(err, errs.ErrSchemaViolation) {
+		t.Errorf("Expected schema violation for colliding columns, got %v", err)
+	}
+
+	if _, err := New(context.Background(), nil, newMockStorage(), nil, WithColumnCase("upper")); err == nil {
+		t.Error("Expected error for an invalid policy")
+	}
+}
+
+func TestRouterUnknownColumnsDrop(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "firmware.lua")
+	scriptCode := `
+schema = {
+  tables = {
+    readings = { time = "timestamptz", temperature = "double precision" }
+  }
+}
+
+function transform(msg)
+  return {{ table = "readings", columns = { time = msg.ts, temperature = 21.5, exp_rssi = -70, exp_flags = 3 } }}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	storage := newMockStorage()
+	routes := []Route{{Filter: "sensors/+", Script: scriptPath, UnknownColumns: UnknownColumnsDrop}}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	if err := r.Dispatch(Message{Topic: "sensors/a", Payload: []byte(`{}`), Time: time.Now()}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	r.Drain()
+	status := r.RouteStatus()
+	r.Close()
+
+	if storage.count("readings") != 1 {
+		t.Fatalf("Expected the record stored, got %d rows", storage.count("readings"))
+	}
+	row := storage.inserts["readings"][0]
+	if _, ok := row["exp_rssi"]; ok || len(row) != 2 {
+		t.Errorf("Expected undeclared columns dropped, got %v", row)
+	}
+	if status[0].DroppedColumns != 2 {
+		t.Errorf("Expected 2 dropped columns counted, got %d", status[0].DroppedColumns)
+	}
+
+	if _, err := New(context.Background(), []Route{{Filter: "a/+", UnknownColumns: "ignore"}}, newMockStorage(), nil); err == nil {
+		t.Error("Expected error for an invalid unknown_columns policy")
+	}
+}
@@ -0,0 +1,45 @@
+package router
+
+import (
+	"time"
+
+	"github.com/marcgeld/hermod/internal/device"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// WithDeviceRegistry enables device tracking for routes with a DeviceID
+// expression and exposes registry reads to Lua:
+//
+//	device_info(id)          -> {first_seen, last_seen, message_count, last_topic} or nil
+//	silent_devices(seconds)  -> array of device IDs not seen within seconds
+func WithDeviceRegistry(reg *device.Registry) Option {
+	return func(r *Router) {
+		r.devices = reg
+		r.luaSetup = append(r.luaSetup, func(L *lua.LState) {
+			L.SetGlobal("device_info", L.NewFunction(func(L *lua.LState) int {
+				d, ok := reg.Get(L.CheckString(1))
+				if !ok {
+					L.Push(lua.LNil)
+					return 1
+				}
+				tbl := L.NewTable()
+				tbl.RawSetString("first_seen", lua.LString(d.FirstSeen.UTC().Format(time.RFC3339Nano)))
+				tbl.RawSetString("last_seen", lua.LString(d.LastSeen.UTC().Format(time.RFC3339Nano)))
+				tbl.RawSetString("message_count", lua.LNumber(d.MessageCount))
+				tbl.RawSetString("last_topic", lua.LString(d.LastTopic))
+				L.Push(tbl)
+				return 1
+			}))
+
+			L.SetGlobal("silent_devices", L.NewFunction(func(L *lua.LState) int {
+				within := time.Duration(float64(L.CheckNumber(1)) * float64(time.Second))
+				tbl := L.NewTable()
+				for i, id := range reg.Silent(within, time.Now()) {
+					tbl.RawSetInt(i+1, lua.LString(id))
+				}
+				L.Push(tbl)
+				return 1
+			}))
+		})
+	}
+}
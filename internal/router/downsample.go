@@ -0,0 +1,405 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/sink"
+)
+
+// Downsample configures per-route aggregation of records before storage.
+// Records are grouped per table, time bucket and the values of every column
+// not listed in Agg (except "time"); one row per group is written when the
+// bucket closes. Records arriving after their bucket's row was written are
+// dropped, so a bucket never gets a second row, for flushedIntervals
+// intervals after it; later ones open a new group.
+type Downsample struct {
+	Interval time.Duration     // Bucket width (e.g. 60s)
+	Agg      map[string]string // Column name -> aggregate function
+}
+
+// validAggregates lists the supported aggregate functions
+var validAggregates = map[string]bool{
+	"avg":   true,
+	"min":   true,
+	"max":   true,
+	"sum":   true,
+	"count": true,
+	"first": true,
+	"last":  true,
+}
+
+// Validate checks the downsample configuration
+func (d *Downsample) Validate() error {
+	if d.Interval <= 0 {
+		return fmt.Errorf("downsample interval must be positive")
+	}
+	for col, fn := range d.Agg {
+		if !validIdentifier.MatchString(col) {
+			return fmt.Errorf("invalid downsample column: %s", col)
+		}
+		if !validAggregates[fn] {
+			return fmt.Errorf("invalid aggregate %q for column %s", fn, col)
+		}
+	}
+	return nil
+}
+
+// downsampler is a Storage stage that aggregates records into time buckets
+type downsampler struct {
+	cfg     Downsample
+	limit   int // Most groups held; records opening more are refused
+	next    Storage
+	logger  *logger.Logger
+	mu      sync.Mutex
+	groups  map[string]*aggGroup
+	flushed map[string]writtenBucket // Newest bucket written per table and group-by values
+	now     func() time.Time
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// writtenBucket is the newest bucket written for a series, and when
+type writtenBucket struct {
+	bucket time.Time
+	at     time.Time
+}
+
+// flushedIntervals is how many intervals a series' written bucket is
+// remembered for dropping late records, so series that stop reporting
+// (e.g. with high-cardinality group-by columns) don't accumulate
+const flushedIntervals = 4
+
+// maxHeldGroups bounds the groups a downsampler holds, which grows without
+// one when storage is down and groups that failed to write are kept
+const maxHeldGroups = 100000
+
+// aggGroup accumulates all records of one table/bucket/key combination
+type aggGroup struct {
+	key     string
+	series  string // Table and group-by values, without the bucket
+	table   string
+	bucket  time.Time
+	keys    map[string]interface{}
+	cols    map[string]*aggState
+	records int // Records folded in
+}
+
+// aggState holds running aggregates for one column
+type aggState struct {
+	count    int
+	numeric  int
+	sum      float64
+	min      float64
+	max      float64
+	first    interface{}
+	last     interface{}
+	hasFirst bool
+}
+
+// newDownsampler creates an aggregation stage in front of next
+func newDownsampler(cfg Downsample, next Storage, log *logger.Logger) *downsampler {
+	return &downsampler{
+		cfg:     cfg,
+		limit:   maxHeldGroups,
+		next:    next,
+		logger:  log,
+		groups:  make(map[string]*aggGroup),
+		flushed: make(map[string]writtenBucket),
+		now:     time.Now,
+		stop:    make(chan struct{}),
+	}
+}
+
+// start runs the periodic flush of closed buckets
+func (d *downsampler) start(ctx context.Context) {
+	d.stopped.Add(1)
+	go func() {
+		defer d.stopped.Done()
+		ticker := time.NewTicker(d.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-d.stop:
+				return
+			case <-ticker.C:
+				d.flush(ctx, false)
+			}
+		}
+	}()
+}
+
+// close stops the flush loop and writes all pending groups
+func (d *downsampler) close(ctx context.Context) {
+	close(d.stop)
+	d.stopped.Wait()
+	d.flush(ctx, true)
+}
+
+// InsertIntoTable adds a record to its aggregation group instead of writing it.
+// A record that would open a group once the limit is reached is refused with
+// errs.ErrQueueFull, so its message is redelivered.
+func (d *downsampler) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	bucket := sink.RecordTime(data, d.now()).Truncate(d.cfg.Interval)
+
+	keys := make(map[string]interface{})
+	for col, val := range data {
+		if col == "time" {
+			continue
+		}
+		if _, ok := d.cfg.Agg[col]; !ok {
+			keys[col] = val
+		}
+	}
+	series := table + "|" + keyString(keys)
+	groupKey := series + "|" + bucket.Format(time.RFC3339Nano)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if last, ok := d.flushed[series]; ok && !bucket.After(last.bucket) {
+		d.logger.Debugf("Dropping late record for %s: the row of its bucket %s was already written",
+			table, bucket.Format(time.RFC3339))
+		return nil
+	}
+
+	g, ok := d.groups[groupKey]
+	if !ok {
+		if len(d.groups) >= d.limit {
+			return fmt.Errorf("%w: downsampler holds %d groups", errs.ErrQueueFull, d.limit)
+		}
+		g = &aggGroup{
+			key:    groupKey,
+			series: series,
+			table:  table,
+			bucket: bucket,
+			keys:   keys,
+			cols:   make(map[string]*aggState),
+		}
+		d.groups[groupKey] = g
+	}
+	g.records++
+	for col := range d.cfg.Agg {
+		val, ok := data[col]
+		if !ok || val == nil {
+			continue
+		}
+		st, ok := g.cols[col]
+		if !ok {
+			st = &aggState{}
+			g.cols[col] = st
+		}
+		st.add(val)
+	}
+	return nil
+}
+
+// flush writes groups whose bucket has closed (or all groups when force is set)
+func (d *downsampler) flush(ctx context.Context, force bool) {
+	now := d.now()
+
+	d.mu.Lock()
+	expired := now.Add(-flushedIntervals * d.cfg.Interval)
+	for series, w := range d.flushed {
+		if w.at.Before(expired) {
+			delete(d.flushed, series)
+		}
+	}
+	var ready []*aggGroup
+	for key, g := range d.groups {
+		if force || !g.bucket.Add(d.cfg.Interval).After(now) {
+			ready = append(ready, g)
+			delete(d.groups, key)
+		}
+	}
+	d.mu.Unlock()
+
+	// Write oldest buckets first for deterministic output
+	sort.Slice(ready, func(i, j int) bool {
+		return ready[i].bucket.Before(ready[j].bucket)
+	})
+
+	for i, g := range ready {
+		row := g.row(d.cfg.Agg)
+		err := d.next.InsertIntoTable(ctx, g.table, row)
+		if err == nil {
+			d.written(g)
+			continue
+		}
+		if force || !redeliverable(err) {
+			d.logger.Errorf("Failed to write downsampled row into %s, dropping it: %v", g.table, err)
+			continue
+		}
+		// Keep this and the remaining groups for the next flush
+		d.logger.Errorf("Failed to write downsampled row into %s, retrying at the next flush: %v", g.table, err)
+		d.restore(ready[i:])
+		return
+	}
+}
+
+// written marks g's bucket as written, dropping records of it that opened
+// a new group while the row was being written
+func (d *downsampler) written(g *aggGroup) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if last, ok := d.flushed[g.series]; !ok || g.bucket.After(last.bucket) {
+		d.flushed[g.series] = writtenBucket{bucket: g.bucket, at: d.now()}
+	}
+	if late, ok := d.groups[g.key]; ok {
+		delete(d.groups, g.key)
+		d.logger.Debugf("Dropping %d late records for %s: the row of their bucket %s was already written",
+			late.records, g.table, g.bucket.Format(time.RFC3339))
+	}
+}
+
+// restore puts groups that failed to write back, merging each into a group
+// opened for the same key since it was taken
+func (d *downsampler) restore(groups []*aggGroup) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, g := range groups {
+		if newer, ok := d.groups[g.key]; ok {
+			g.merge(newer)
+		}
+		d.groups[g.key] = g
+	}
+}
+
+// merge folds the aggregates of newer, a later group of the same key, into g
+func (g *aggGroup) merge(newer *aggGroup) {
+	g.records += newer.records
+	for col, st := range newer.cols {
+		if mine, ok := g.cols[col]; ok {
+			mine.merge(st)
+		} else {
+			g.cols[col] = st
+		}
+	}
+}
+
+// row builds the aggregated record for a group
+func (g *aggGroup) row(agg map[string]string) map[string]interface{} {
+	row := make(map[string]interface{}, len(g.keys)+len(g.cols)+1)
+	for k, v := range g.keys {
+		row[k] = v
+	}
+	row["time"] = g.bucket
+	for col, st := range g.cols {
+		if v, ok := st.result(agg[col]); ok {
+			row[col] = v
+		}
+	}
+	return row
+}
+
+// add folds a value into the running aggregates
+func (s *aggState) add(val interface{}) {
+	s.count++
+	if !s.hasFirst {
+		s.first = val
+		s.hasFirst = true
+	}
+	s.last = val
+
+	f, ok := toFloat(val)
+	if !ok {
+		return
+	}
+	if s.numeric == 0 || f < s.min {
+		s.min = f
+	}
+	if s.numeric == 0 || f > s.max {
+		s.max = f
+	}
+	s.sum += f
+	s.numeric++
+}
+
+// merge folds the aggregates of newer, holding later values, into s
+func (s *aggState) merge(newer *aggState) {
+	if newer.count == 0 {
+		return
+	}
+	if !s.hasFirst {
+		s.first = newer.first
+		s.hasFirst = newer.hasFirst
+	}
+	s.last = newer.last
+	s.count += newer.count
+	if newer.numeric > 0 {
+		if s.numeric == 0 || newer.min < s.min {
+			s.min = newer.min
+		}
+		if s.numeric == 0 || newer.max > s.max {
+			s.max = newer.max
+		}
+		s.sum += newer.sum
+		s.numeric += newer.numeric
+	}
+}
+
+// result returns the aggregate value for fn; numeric aggregates are
+// omitted when no numeric values were seen
+func (s *aggState) result(fn string) (interface{}, bool) {
+	switch fn {
+	case "count":
+		return s.count, true
+	case "first":
+		return s.first, s.hasFirst
+	case "last":
+		return s.last, s.hasFirst
+	}
+	if s.numeric == 0 {
+		return nil, false
+	}
+	switch fn {
+	case "avg":
+		return s.sum / float64(s.numeric), true
+	case "min":
+		return s.min, true
+	case "max":
+		return s.max, true
+	case "sum":
+		return s.sum, true
+	}
+	return nil, false
+}
+
+// keyString renders group-by columns deterministically
+func keyString(keys map[string]interface{}) string {
+	names := make([]string, 0, len(keys))
+	for k := range keys {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	for _, k := range names {
+		fmt.Fprintf(&sb, "%s=%v;", k, keys[k])
+	}
+	return sb.String()
+}
+
+// toFloat converts numeric values to float64
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	default:
+		return 0, false
+	}
+}
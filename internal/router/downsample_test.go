@@ -0,0 +1,276 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// outageStorage fails every write as unreachable while down is set
+type outageStorage struct {
+	*mockStorage
+	down atomic.Bool
+}
+
+func (o *outageStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	if o.down.Load() {
+		return fmt.Errorf("connection refused: %w", errs.ErrStorageUnavailable)
+	}
+	return o.mockStorage.InsertIntoTable(ctx, table, data)
+}
+
+func TestDownsampleValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		ds      Downsample
+		wantErr bool
+	}{
+		{"valid", Downsample{Interval: time.Minute, Agg: map[string]string{"value": "avg"}}, false},
+		{"zero interval", Downsample{Agg: map[string]string{"value": "avg"}}, true},
+		{"unknown aggregate", Downsample{Interval: time.Minute, Agg: map[string]string{"value": "median"}}, true},
+		{"invalid column", Downsample{Interval: time.Minute, Agg: map[string]string{"bad-col": "avg"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.ds.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDownsamplerAggregates(t *testing.T) {
+	storage := newMockStorage()
+	cfg := Downsample{
+		Interval: time.Minute,
+		Agg: map[string]string{
+			"value":   "avg",
+			"battery": "last",
+			"peak":    "max",
+			"samples": "count",
+		},
+	}
+	ds := newDownsampler(cfg, storage, logger.New(logger.ERROR))
+	ctx := context.Background()
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	inputs := []map[string]interface{}{
+		{"time": base.Add(5 * time.Second), "sensor": "a", "value": 10.0, "battery": 3.1, "peak": 1.0, "samples": 1},
+		{"time": base.Add(30 * time.Second), "sensor": "a", "value": 20.0, "battery": 3.0, "peak": 5.0, "samples": 1},
+		{"time": base.Add(40 * time.Second).Format(time.RFC3339Nano), "sensor": "b", "value": 7.0},
+		{"time": base.Add(70 * time.Second), "sensor": "a", "value": 99.0},
+	}
+	for _, in := range inputs {
+		if err := ds.InsertIntoTable(ctx, "metrics", in); err != nil {
+			t.Fatalf("InsertIntoTable failed: %v", err)
+		}
+	}
+
+	if len(storage.inserts["metrics"]) != 0 {
+		t.Fatal("Expected no rows before buckets close")
+	}
+
+	// Close the first bucket only
+	ds.now = func() time.Time { return base.Add(61 * time.Second) }
+	ds.flush(ctx, false)
+
+	rows := storage.inserts["metrics"]
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows for first bucket, got %d", len(rows))
+	}
+
+	var rowA map[string]interface{}
+	for _, row := range rows {
+		if row["sensor"] == "a" {
+			rowA = row
+		}
+	}
+	if rowA == nil {
+		t.Fatal("Expected row for sensor a")
+	}
+	if rowA["time"] != base {
+		t.Errorf("Expected bucket time %v, got %v", base, rowA["time"])
+	}
+	if rowA["value"] != 15.0 {
+		t.Errorf("Expected avg value 15, got %v", rowA["value"])
+	}
+	if rowA["battery"] != 3.0 {
+		t.Errorf("Expected last battery 3.0, got %v", rowA["battery"])
+	}
+	if rowA["peak"] != 5.0 {
+		t.Errorf("Expected max peak 5, got %v", rowA["peak"])
+	}
+	if rowA["samples"] != 2 {
+		t.Errorf("Expected count 2, got %v", rowA["samples"])
+	}
+
+	// Remaining bucket is written on close
+	ds.close(ctx)
+	if len(storage.inserts["metrics"]) != 3 {
+		t.Fatalf("Expected 3 rows after close, got %d", len(storage.inserts["metrics"]))
+	}
+	if storage.inserts["metrics"][2]["value"] != 99.0 {
+		t.Errorf("Expected second bucket value 99, got %v", storage.inserts["metrics"][2]["value"])
+	}
+}
+
+func TestRouterWithDownsample(t *testing.T) {
+	storage := newMockStorage()
+	routes := []Route{
+		{
+			Filter:  "sensors/+",
+			Workers: 1,
+			Table:   "sensor_data",
+			Downsample: &Downsample{
+				Interval: time.Hour,
+				Agg:      map[string]string{"qos": "max"},
+			},
+		},
+	}
+
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	now := time.Now().UTC()
+	for i := 0; i < 3; i++ {
+		if err := r.Dispatch(Message{Topic: "sensors/a", Payload: []byte("x"), QoS: byte(i), Time: now}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	r.Close()
+
+	rows := storage.inserts["sensor_data"]
+	if len(rows) != 1 {
+		t.Fatalf("Expected 1 downsampled row, got %d", len(rows))
+	}
+	if rows[0]["qos"] != 2.0 {
+		t.Errorf("Expected max qos 2, got %v", rows[0]["qos"])
+	}
+}
+
+func TestRouterRejectsInvalidDownsample(t *testing.T) {
+	routes := []Route{
+		{Filter: "a/#", Downsample: &Downsample{Interval: time.Minute, Agg: map[string]string{"v": "median"}}},
+	}
+	if _, err := New(context.Background(), routes, newMockStorage(), nil); err == nil {
+		t.Error("Expected error for invalid downsample config")
+	}
+}
+
+func TestDownsamplerRetriesDuringOutage(t *testing.T) {
+	storage := &outageStorage{mockStorage: newMockStorage()}
+	storage.down.Store(true)
+	ds := newDownsampler(Downsample{Interval: time.Minute, Agg: map[string]string{"value": "avg", "n": "count"}},
+		storage, logger.New(logger.ERROR))
+	ctx := context.Background()
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ds.InsertIntoTable(ctx, "metrics", map[string]interface{}{"time": base, "value": 10.0, "n": 1})
+	ds.now = func() time.Time { return base.Add(61 * time.Second) }
+	ds.flush(ctx, false)
+	if len(storage.inserts["metrics"]) != 0 {
+		t.Fatal("Expected no rows while storage is down")
+	}
+
+	// A record arriving meanwhile joins the kept group
+	ds.InsertIntoTable(ctx, "metrics", map[string]interface{}{"time": base.Add(10 * time.Second), "value": 20.0, "n": 1})
+	storage.down.Store(false)
+	ds.flush(ctx, false)
+	rows := storage.inserts["metrics"]
+	if len(rows) != 1 || rows[0]["value"] != 15.0 || rows[0]["n"] != 2 {
+		t.Errorf("Expected one row averaging both records once storage is back, got %v", rows)
+	}
+}
+
+func TestDownsamplerRefusesWhenFull(t *testing.T) {
+	storage := &outageStorage{mockStorage: newMockStorage()}
+	storage.down.Store(true)
+	ds := newDownsampler(Downsample{Interval: time.Minute, Agg: map[string]string{"value": "avg"}},
+		storage, logger.New(logger.ERROR))
+	ds.limit = 1
+	ctx := context.Background()
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ds.InsertIntoTable(ctx, "metrics", map[string]interface{}{"time": base, "device": "a", "value": 1.0})
+	ds.now = func() time.Time { return base.Add(61 * time.Second) }
+	ds.flush(ctx, false)
+
+	// The kept group still takes records, but a new one is refused
+	if err := ds.InsertIntoTable(ctx, "metrics", map[string]interface{}{"time": base, "device": "a", "value": 2.0}); err != nil {
+		t.Errorf("Expected a record of the held group to be accepted, got %v", err)
+	}
+	err := ds.InsertIntoTable(ctx, "metrics", map[string]interface{}{"time": base, "device": "b", "value": 3.0})
+	if !errors.Is(err, errs.ErrQueueFull) {
+		t.Errorf("Expected a queue-full error, got %v", err)
+	}
+}
+
+func TestDownsamplerDropsLateRecords(t *testing.T) {
+	storage := newMockStorage()
+	ds := newDownsampler(Downsample{Interval: time.Minute, Agg: map[string]string{"value": "avg"}},
+		storage, logger.New(logger.ERROR))
+	ctx := context.Background()
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ds.InsertIntoTable(ctx, "metrics", map[string]interface{}{"time": base, "sensor": "a", "value": 10.0})
+	ds.now = func() time.Time { return base.Add(61 * time.Second) }
+	ds.flush(ctx, false)
+
+	// A record for the written bucket is dropped; other sensors and later buckets aren't
+	ds.InsertIntoTable(ctx, "metrics", map[string]interface{}{"time": base.Add(30 * time.Second), "sensor": "a", "value": 50.0})
+	ds.InsertIntoTable(ctx, "metrics", map[string]interface{}{"time": base.Add(30 * time.Second), "sensor": "b", "value": 7.0})
+	ds.InsertIntoTable(ctx, "metrics", map[string]interface{}{"time": base.Add(90 * time.Second), "sensor": "a", "value": 20.0})
+	ds.close(ctx)
+
+	rows := storage.inserts["metrics"]
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows, got %d: %v", len(rows), rows)
+	}
+	for _, row := range rows {
+		if row["sensor"] == "a" && row["time"] == base && row["value"] != 10.0 {
+			t.Errorf("Expected the written bucket left alone, got %v", row)
+		}
+		if row["value"] == 50.0 {
+			t.Errorf("Expected the late record dropped, got %v", row)
+		}
+	}
+}
+
+func TestDownsamplerForgetsIdleSeries(t *testing.T) {
+	storage := newMockStorage()
+	ds := newDownsampler(Downsample{Interval: time.Minute, Agg: map[string]string{"value": "avg"}},
+		storage, logger.New(logger.ERROR))
+	ctx := context.Background()
+
+	base := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	now := base.Add(61 * time.Second)
+	ds.now = func() time.Time { return now }
+	for _, sensor := range []string{"a", "b"} {
+		ds.InsertIntoTable(ctx, "metrics", map[string]interface{}{"time": base, "sensor": sensor, "value": 1.0})
+	}
+	ds.flush(ctx, false)
+	if len(ds.flushed) != 2 {
+		t.Fatalf("Expected both series remembered, got %d", len(ds.flushed))
+	}
+
+	// Only sensor a keeps reporting
+	now = now.Add(flushedIntervals * time.Minute)
+	ds.InsertIntoTable(ctx, "metrics", map[string]interface{}{"time": now.Add(-time.Minute), "sensor": "a", "value": 2.0})
+	ds.flush(ctx, false)
+	now = now.Add(time.Second)
+	ds.flush(ctx, false)
+	if _, ok := ds.flushed["metrics|sensor=b;"]; ok || len(ds.flushed) != 1 {
+		t.Errorf("Expected only the reporting series remembered, got %v", ds.flushed)
+	}
+}
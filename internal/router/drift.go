@@ -0,0 +1,162 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+)
+
+// SchemaInspector reports the columns of a database table, so a route
+// paused for schema drift can tell when the table fits its records again
+type SchemaInspector interface {
+	// TableColumns returns nil without an error when the table doesn't exist
+	TableColumns(ctx context.Context, table string) ([]string, error)
+}
+
+// WithSchemaInspector re-validates the table of a route paused for schema
+// drift every interval (default: 30s), resuming the route once the table has
+// every column of the record that failed (without one, only Release resumes it)
+func WithSchemaInspector(inspector SchemaInspector, interval time.Duration) Option {
+	return func(r *Router) {
+		r.inspector = inspector
+		r.driftInterval = interval
+	}
+}
+
+// defaultDriftInterval is how often a paused route's table is re-validated
+const defaultDriftInterval = 30 * time.Second
+
+// schemaDrift is why a route is paused
+type schemaDrift struct {
+	table   string
+	columns []string               // Columns of the record that failed
+	since   time.Time              // When the route was paused
+	reason  atomic.Pointer[string] // Most precise description of the drift
+	resumed chan struct{}          // Closed when the route resumes
+}
+
+// status describes a paused route for RouteStatus
+func (d *schemaDrift) status() string {
+	if d == nil {
+		return ""
+	}
+	return fmt.Sprintf("%s (since %s)", *d.reason.Load(), d.since.UTC().Format(time.RFC3339))
+}
+
+// checkDrift pauses the route when err is schema drift on table
+func (h *routeHandler) checkDrift(table string, record map[string]interface{}, err error) {
+	if h == nil || !errors.Is(err, errs.ErrSchemaDrift) {
+		return
+	}
+	d := &schemaDrift{table: table, since: time.Now(), resumed: make(chan struct{})}
+	for col := range record {
+		d.columns = append(d.columns, col)
+	}
+	slices.Sort(d.columns)
+	reason := fmt.Sprintf("table %s: %v", table, err)
+	d.reason.Store(&reason)
+	if !h.drift.CompareAndSwap(nil, d) {
+		return
+	}
+	h.lane.pause()
+	h.logger.Errorf("Route %s paused: schema drift on %s; its messages wait in the queue until the table is fixed "+
+		"(or POST /routes/release)", h.route.Filter, reason)
+
+	if h.inspector == nil || h.ctx.Err() != nil {
+		return
+	}
+	h.bg.Add(1)
+	go func() {
+		defer h.bg.Done()
+		h.watchDrift(d)
+	}()
+}
+
+// watchDrift re-validates the drifted table until it has the failed
+// record's columns, then resumes the route
+func (h *routeHandler) watchDrift(d *schemaDrift) {
+	ticker := time.NewTicker(h.driftInterval)
+	defer ticker.Stop()
+	for {
+		missing, err := h.missingColumns(d)
+		switch {
+		case h.drift.Load() != d:
+			// Released meanwhile
+			return
+		case err != nil:
+			h.logger.Warnf("Route %s: failed to re-validate table %s: %v", h.route.Filter, d.table, err)
+		case missing == "":
+			h.resume(d, fmt.Sprintf("table %s has every column again", d.table))
+			return
+		case missing != *d.reason.Load():
+			d.reason.Store(&missing)
+			h.logger.Errorf("Route %s paused: schema drift: %s", h.route.Filter, missing)
+		}
+		select {
+		case <-h.ctx.Done():
+			return
+		case <-d.resumed:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// missingColumns describes what the drifted table lacks ("" = nothing)
+func (h *routeHandler) missingColumns(d *schemaDrift) (string, error) {
+	columns, err := h.inspector.TableColumns(h.ctx, d.table)
+	if err != nil {
+		return "", err
+	}
+	if columns == nil {
+		return fmt.Sprintf("table %s does not exist", d.table), nil
+	}
+	have := make(map[string]bool, len(columns))
+	for _, col := range columns {
+		have[strings.ToLower(col)] = true
+	}
+	var missing []string
+	for _, col := range d.columns {
+		// Unquoted identifiers fold to lower case
+		if !have[strings.ToLower(col)] {
+			missing = append(missing, col)
+		}
+	}
+	if len(missing) == 0 {
+		return "", nil
+	}
+	return fmt.Sprintf("table %s lacks columns: %s", d.table, strings.Join(missing, ", ")), nil
+}
+
+// resume lets the route's workers take messages again
+func (h *routeHandler) resume(d *schemaDrift, why string) {
+	if h.drift.CompareAndSwap(d, nil) {
+		h.lane.resume()
+		close(d.resumed)
+		h.logger.Infof("Route %s resumed after %s: %s", h.route.Filter, time.Since(d.since).Round(time.Second), why)
+	}
+}
+
+// waitDrift blocks while the route is paused for schema drift and reports
+// whether the worker may continue
+func (w *worker) waitDrift() bool {
+	if w.handler == nil {
+		return true
+	}
+	d := w.handler.drift.Load()
+	if d == nil {
+		return true
+	}
+	select {
+	case <-w.ctx.Done():
+		return false
+	case <-d.resumed:
+		return true
+	}
+}
@@ -0,0 +1,183 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// driftingStorage is a database whose readings table lacks the humidity
+// column until it is migrated
+type driftingStorage struct {
+	*mockStorage
+	migrated atomic.Bool
+}
+
+func (d *driftingStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	if _, ok := data["humidity"]; ok && !d.migrated.Load() {
+		return fmt.Errorf("%w: column \"humidity\" of relation \"%s\" does not exist", errs.ErrSchemaDrift, table)
+	}
+	return d.mockStorage.InsertIntoTable(ctx, table, data)
+}
+
+func (d *driftingStorage) TableColumns(ctx context.Context, table string) ([]string, error) {
+	if table != "readings" {
+		return nil, nil
+	}
+	if d.migrated.Load() {
+		return []string{"time", "temp", "humidity"}, nil
+	}
+	return []string{"time", "temp"}, nil
+}
+
+func writeDriftScript(t *testing.T) string {
+	t.Helper()
+	scriptPath := filepath.Join(t.TempDir(), "drift.lua")
+	scriptCode := `
+function transform(msg)
+  return {{ table = "readings", columns = { time = msg.ts, temp = 21.5, humidity = 40 } }}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+	return scriptPath
+}
+
+// waitFor polls cond for up to a second
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return false
+}
+
+func TestRouterSchemaDriftPause(t *testing.T) {
+	storage := &driftingStorage{mockStorage: newMockStorage()}
+	routes := []Route{{Filter: "sensors/+", Script: writeDriftScript(t), Workers: 1}}
+	r, err := New(context.Background(), routes, storage, logger.New(logger.ERROR), WithSchemaInspector(storage, 10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	defer r.Close()
+
+	msg := Message{Topic: "sensors/a", Payload: []byte(`{}`), Time: time.Now()}
+	if err := r.Dispatch(msg); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	if !waitFor(func() bool { return strings.Contains(r.RouteStatus()[0].SchemaDrift, "lacks columns: humidity") }) {
+		t.Fatalf("Expected the route paused with the missing column, got %+v", r.RouteStatus()[0])
+	}
+
+	// Messages wait while the route is paused
+	for i := 0; i < 2; i++ {
+		if err := r.Dispatch(msg); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	time.Sleep(30 * time.Millisecond)
+	if status := r.RouteStatus()[0]; !status.Paused || status.QueueLength != 2 || storage.count("readings") != 0 {
+		t.Errorf("Expected 2 messages queued on the paused route, got %+v, %d rows", status, storage.count("readings"))
+	}
+
+	// The route resumes once the table is migrated
+	storage.migrated.Store(true)
+	if !waitFor(func() bool { return storage.count("readings") == 2 }) {
+		t.Fatalf("Expected the queued messages stored after the migration, got %d rows", storage.count("readings"))
+	}
+	if status := r.RouteStatus()[0]; status.Paused || status.SchemaDrift != "" {
+		t.Errorf("Expected the route resumed, got %+v", status)
+	}
+}
+
+func TestRouterSchemaDriftRelease(t *testing.T) {
+	storage := &driftingStorage{mockStorage: newMockStorage()}
+	routes := []Route{{Filter: "sensors/+", Script: writeDriftScript(t), Workers: 1}}
+	r, err := New(context.Background(), routes, storage, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	defer r.Close()
+
+	msg := Message{Topic: "sensors/a", Payload: []byte(`{}`), Time: time.Now()}
+	if err := r.Dispatch(msg); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	if !waitFor(func() bool { return r.RouteStatus()[0].Paused }) {
+		t.Fatal("Expected the route paused")
+	}
+	if drift := r.RouteStatus()[0].SchemaDrift; !strings.Contains(drift, `column "humidity"`) {
+		t.Errorf("Expected the database error without an inspector, got %q", drift)
+	}
+	if err := r.Dispatch(msg); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+
+	storage.migrated.Store(true)
+	if err := r.Release("sensors/+"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if !waitFor(func() bool { return storage.count("readings") == 1 }) {
+		t.Errorf("Expected the queued message stored after release, got %d rows", storage.count("readings"))
+	}
+}
+
+func TestRouterSchemaDriftHighPriority(t *testing.T) {
+	storage := &driftingStorage{mockStorage: newMockStorage()}
+	routes := []Route{
+		{Filter: "alarm/+", Script: writeDriftScript(t), Workers: 1, Priority: PriorityHigh},
+		{Filter: "bulk/+", Workers: 1, QueueSize: 10},
+	}
+	r, err := New(context.Background(), routes, storage, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	defer r.Close()
+
+	alarm := Message{Topic: "alarm/a", Payload: []byte(`{}`), Time: time.Now()}
+	if err := r.Dispatch(alarm); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	if !waitFor(func() bool { return r.RouteStatus()[0].Paused }) {
+		t.Fatal("Expected the high-priority route paused")
+	}
+	if err := r.Dispatch(alarm); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+
+	// Messages waiting on the paused route don't hold normal routes back
+	for i := 0; i < 2; i++ {
+		if err := r.Dispatch(Message{Topic: "bulk/1", Payload: []byte(`{}`), Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	if !waitFor(func() bool { return storage.count("iot_raw") == 2 }) {
+		t.Fatalf("Expected the bulk messages stored while the alarm route is paused, got %+v", r.RouteStatus()[1])
+	}
+
+	// Once released, the alarm route holds normal routes back again
+	storage.migrated.Store(true)
+	if err := r.Release("alarm/+"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if !waitFor(func() bool { return storage.count("readings") == 1 }) {
+		t.Fatalf("Expected the queued alarm stored after release, got %d rows", storage.count("readings"))
+	}
+	if err := r.Dispatch(Message{Topic: "bulk/1", Payload: []byte(`{}`), Time: time.Now()}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	if !waitFor(func() bool { return storage.count("iot_raw") == 3 }) {
+		t.Errorf("Expected normal routes to run after the alarm route drained, got %+v", r.RouteStatus()[1])
+	}
+}
@@ -0,0 +1,62 @@
+package router
+
+import (
+	"fmt"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Payload character encodings a route can transcode to UTF-8
+const (
+	EncodingUTF8    = "utf-8"    // Payloads are used as they arrive (default)
+	EncodingLatin1  = "latin-1"  // ISO 8859-1: every byte is the code point of the same value
+	EncodingUTF16LE = "utf-16le" // UTF-16 little-endian, with or without a byte order mark
+)
+
+// validatePayloadEncoding checks a route's payload encoding ("" = UTF-8).
+// Transcoding only makes sense for text, so it can't be combined with a
+// binary payload format.
+func validatePayloadEncoding(encoding, format string) error {
+	switch encoding {
+	case "", EncodingUTF8:
+		return nil
+	case EncodingLatin1, EncodingUTF16LE:
+	default:
+		return fmt.Errorf("invalid payload_encoding %q: use utf-8, latin-1 or utf-16le", encoding)
+	}
+	switch format {
+	case FormatCBOR, FormatMsgPack, FormatBinary:
+		return fmt.Errorf("payload_encoding %s requires a text payload format, not %s", encoding, format)
+	}
+	return nil
+}
+
+// transcode converts a payload in encoding to UTF-8. UTF-16 code units that
+// don't form a character, and a trailing odd byte, become U+FFFD.
+func transcode(encoding string, payload []byte) []byte {
+	switch encoding {
+	case EncodingLatin1:
+		out := make([]byte, 0, len(payload)+len(payload)/4)
+		for _, b := range payload {
+			out = utf8.AppendRune(out, rune(b))
+		}
+		return out
+	case EncodingUTF16LE:
+		if len(payload) >= 2 && payload[0] == 0xFF && payload[1] == 0xFE {
+			payload = payload[2:]
+		}
+		units := make([]uint16, len(payload)/2)
+		for i := range units {
+			units[i] = uint16(payload[2*i]) | uint16(payload[2*i+1])<<8
+		}
+		out := make([]byte, 0, len(payload))
+		for _, r := range utf16.Decode(units) {
+			out = utf8.AppendRune(out, r)
+		}
+		if len(payload)%2 != 0 {
+			out = utf8.AppendRune(out, utf8.RuneError)
+		}
+		return out
+	}
+	return payload
+}
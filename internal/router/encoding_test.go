@@ -0,0 +1,61 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTranscode(t *testing.T) {
+	tests := []struct {
+		encoding string
+		payload  []byte
+		want     string
+	}{
+		{"", []byte("café"), "café"},
+		{EncodingUTF8, []byte("café"), "café"},
+		{EncodingLatin1, []byte("caf\xe9 25\xb0C"), "café 25°C"},
+		{EncodingUTF16LE, []byte{'h', 0, 0xe9, 0, 0x3d, 0xd8, 0x00, 0xde}, "hé😀"},
+		{EncodingUTF16LE, []byte{0xff, 0xfe, 'o', 0, 'k', 0}, "ok"},
+		{EncodingUTF16LE, []byte{'o', 0, 'k'}, "o�"},
+		{EncodingUTF16LE, []byte{0x3d, 0xd8, 'x', 0}, "�x"},
+	}
+	for _, tt := range tests {
+		if got := string(transcode(tt.encoding, tt.payload)); got != tt.want {
+			t.Errorf("transcode(%q, %q) = %q, want %q", tt.encoding, tt.payload, got, tt.want)
+		}
+	}
+}
+
+func TestValidatePayloadEncoding(t *testing.T) {
+	if err := validatePayloadEncoding("latin-1", FormatJSON); err != nil {
+		t.Errorf("Expected latin-1 JSON accepted, got %v", err)
+	}
+	if err := validatePayloadEncoding("cp1252", ""); err == nil {
+		t.Error("Expected an unknown encoding rejected")
+	}
+	if err := validatePayloadEncoding("utf-16le", FormatCBOR); err == nil {
+		t.Error("Expected transcoding a binary format rejected")
+	}
+}
+
+func TestRouterPayloadEncoding(t *testing.T) {
+	storage := newMockStorage()
+	routes := []Route{{Filter: "meters/+", PayloadEncoding: EncodingLatin1}}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	if err := r.Dispatch(Message{Topic: "meters/m1", Payload: []byte("{\"location\": \"K\xf6k\"}"), Time: time.Now()}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	r.Drain()
+
+	rec := storage.inserts["iot_raw"][0]
+	if rec["raw"] != `{"location": "Kök"}` {
+		t.Errorf("Expected the raw column transcoded, got %q", rec["raw"])
+	}
+	if doc, ok := rec["json"].(map[string]interface{}); !ok || doc["location"] != "Kök" {
+		t.Errorf("Expected the transcoded payload decoded as JSON, got %v", rec["json"])
+	}
+}
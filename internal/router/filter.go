@@ -0,0 +1,98 @@
+package router
+
+import (
+	"fmt"
+	"regexp"
+	"sync/atomic"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// TopicFilter excludes messages at dispatch time, before they are queued
+type TopicFilter struct {
+	Deny      []string // MQTT topic filters to drop (e.g. "$SYS/#", "+/debug/#")
+	DenyRegex []string // Regular expressions; topics matching any of them are dropped
+	MinQoS    byte     // Drop messages delivered below this QoS (0 = accept all)
+}
+
+// WithTopicFilter drops matching messages before routing, so they reach
+// neither a route nor passthrough
+func WithTopicFilter(f TopicFilter) Option {
+	return func(r *Router) {
+		r.topicFilter = f
+	}
+}
+
+// topicGuard applies a TopicFilter and counts the messages it drops
+type topicGuard struct {
+	deny    *topicTrie
+	regexes []*regexp.Regexp
+	minQoS  byte
+	name    string // Route filter, or "Router"
+	logger  *logger.Logger
+	denied  atomic.Int64
+}
+
+// newTopicGuard compiles f (nil when it excludes nothing)
+func newTopicGuard(f TopicFilter, name string, log *logger.Logger) (*topicGuard, error) {
+	if len(f.Deny) == 0 && len(f.DenyRegex) == 0 && f.MinQoS == 0 {
+		return nil, nil
+	}
+	if f.MinQoS > 2 {
+		return nil, fmt.Errorf("invalid minimum QoS %d: use 0, 1 or 2", f.MinQoS)
+	}
+	g := &topicGuard{minQoS: f.MinQoS, name: name, logger: log}
+	if len(f.Deny) > 0 {
+		for _, filter := range f.Deny {
+			if err := validateFilter(filter); err != nil {
+				return nil, fmt.Errorf("deny: %w", err)
+			}
+		}
+		g.deny = newTopicTrie(f.Deny)
+	}
+	for _, expr := range f.DenyRegex {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid deny regex %q: %w", expr, err)
+		}
+		g.regexes = append(g.regexes, re)
+	}
+	return g, nil
+}
+
+// allow reports whether msg passes the filter
+func (g *topicGuard) allow(msg Message) bool {
+	if g == nil {
+		return true
+	}
+	reason := ""
+	switch {
+	case msg.QoS < g.minQoS:
+		reason = fmt.Sprintf("QoS %d below minimum %d", msg.QoS, g.minQoS)
+	case g.deny != nil && g.deny.match(msg.Topic) >= 0:
+		reason = "topic denied"
+	default:
+		for _, re := range g.regexes {
+			if re.MatchString(msg.Topic) {
+				reason = "topic matches " + re.String()
+				break
+			}
+		}
+	}
+	if reason == "" {
+		return true
+	}
+	g.denied.Add(1)
+	if g.logger.Enabled(logger.DEBUG) {
+		g.logger.Debugf("%s: dropped message from %s (%s)", g.name, msg.Topic, reason)
+	}
+	return false
+}
+
+// count returns the number of messages dropped (0 for a nil guard)
+func (g *topicGuard) count() int64 {
+	if g == nil {
+		return 0
+	}
+	return g.denied.Load()
+}
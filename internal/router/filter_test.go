@@ -0,0 +1,79 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+func TestTopicGuard(t *testing.T) {
+	g, err := newTopicGuard(TopicFilter{
+		Deny:      []string{"$SYS/#", "+/debug/#"},
+		DenyRegex: []string{`/heartbeat$`},
+		MinQoS:    1,
+	}, "Router", logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("newTopicGuard failed: %v", err)
+	}
+
+	tests := []struct {
+		topic string
+		qos   byte
+		want  bool
+	}{
+		{"sensors/a", 1, true},
+		{"sensors/a", 0, false},
+		{"$SYS/broker/uptime", 1, false},
+		{"plant/debug/trace", 2, false},
+		{"plant/debugger", 1, true},
+		{"sensors/a/heartbeat", 1, false},
+	}
+	for _, tt := range tests {
+		if got := g.allow(Message{Topic: tt.topic, QoS: tt.qos}); got != tt.want {
+			t.Errorf("allow(%s, qos %d) = %v, want %v", tt.topic, tt.qos, got, tt.want)
+		}
+	}
+	if g.count() != 4 {
+		t.Errorf("count = %d, want 4", g.count())
+	}
+
+	if g, err := newTopicGuard(TopicFilter{}, "Router", nil); g != nil || err != nil {
+		t.Errorf("Expected nil guard for an empty filter, got %v, %v", g, err)
+	}
+	for _, bad := range []TopicFilter{{DenyRegex: []string{"("}}, {MinQoS: 3}, {Deny: []string{""}}} {
+		if _, err := newTopicGuard(bad, "Router", nil); err == nil {
+			t.Errorf("Expected error for %+v", bad)
+		}
+	}
+}
+
+func TestRouterTopicFilter(t *testing.T) {
+	storage := newMockStorage()
+	routes := []Route{
+		{Filter: "sensors/#", Table: "readings", TopicFilter: &TopicFilter{Deny: []string{"sensors/+/status"}}},
+	}
+	r, err := New(context.Background(), routes, storage, nil, WithTopicFilter(TopicFilter{Deny: []string{"$SYS/#"}}))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	for _, topic := range []string{"sensors/a/temp", "sensors/a/status", "$SYS/load", "other"} {
+		if err := r.Dispatch(Message{Topic: topic, Payload: []byte(`{}`), Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch(%s) failed: %v", topic, err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	r.Close()
+
+	if storage.count("readings") != 1 {
+		t.Errorf("readings rows = %d, want 1", storage.count("readings"))
+	}
+	if storage.count("iot_raw") != 1 {
+		t.Errorf("Expected only the undenied unmatched topic in passthrough, got %d rows", storage.count("iot_raw"))
+	}
+	if status := r.RouteStatus(); status[0].Denied != 1 {
+		t.Errorf("Denied = %d, want 1", status[0].Denied)
+	}
+}
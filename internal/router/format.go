@@ -0,0 +1,154 @@
+package router
+
+import (
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/marcgeld/hermod/internal/decoder"
+	"github.com/marcgeld/hermod/internal/logger"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Payload formats a route can decode into msg.data
+const (
+	FormatJSON    = "json"    // JSON document (msg.json is set as well)
+	FormatCBOR    = "cbor"    // CBOR data item
+	FormatMsgPack = "msgpack" // MessagePack value
+	FormatText    = "text"    // UTF-8 text, passed as a string
+	FormatBinary  = "binary"  // Raw bytes, passed as a string
+)
+
+// builtinFormats are the payload formats decoded without a registered decoder
+var builtinFormats = []string{FormatJSON, FormatCBOR, FormatMsgPack, FormatText, FormatBinary}
+
+// PayloadFormats returns the built-in payload formats and the names of
+// decoders registered with decoder.Register, in sorted order
+func PayloadFormats() []string {
+	formats := append(slices.Clone(builtinFormats), decoder.Names()...)
+	slices.Sort(formats)
+	return slices.Compact(formats)
+}
+
+// validatePayloadFormat checks a route's payload format ("" = lenient JSON)
+func validatePayloadFormat(format string) error {
+	if format == "" || slices.Contains(builtinFormats, format) {
+		return nil
+	}
+	if _, ok := decoder.Lookup(format); ok {
+		return nil
+	}
+	return fmt.Errorf("invalid payload format %q: use json, cbor, msgpack, text, binary or a registered decoder", format)
+}
+
+// payloadDecoder decodes a route's payloads and counts the ones that fail.
+// Without an explicit format payloads are tried as JSON and failures are
+// neither counted nor logged, as before payload formats existed.
+type payloadDecoder struct {
+	format string          // Route payload format ("" = lenient JSON)
+	custom decoder.Decoder // Registered decoder for the format (nil = built-in format)
+	floats bool            // Decode numbers as float64 only
+	name   string          // Route filter, for log messages
+	logger *logger.Logger
+	count  atomic.Int64 // Decode errors since startup
+	log    logThrottle
+}
+
+func newPayloadDecoder(format string, floats bool, name string, log *logger.Logger) *payloadDecoder {
+	d := &payloadDecoder{format: format, floats: floats, name: name, logger: log}
+	if !slices.Contains(builtinFormats, format) {
+		d.custom, _ = decoder.Lookup(format)
+	}
+	return d
+}
+
+// decode decodes msg's payload, counting and logging decode errors
+func (d *payloadDecoder) decode(msg Message) parsedJSON {
+	doc, err := d.parse(msg.Payload)
+	if err != nil && d.format != "" {
+		d.count.Add(1)
+		if n, ok := d.log.event(); ok {
+			d.logger.Errorf("%s: failed to decode %d %s payloads (last from %s: %v)", d.name, n, d.format, msg.Topic, err)
+		}
+	}
+	return doc
+}
+
+// parse decodes a payload. Structured formats fill the document; text and
+// binary payloads leave it empty, as does a payload that fails to decode.
+func (d *payloadDecoder) parse(payload []byte) (parsedJSON, error) {
+	var v interface{}
+	var err error
+	switch d.format {
+	case "", FormatJSON:
+		doc := parseJSON(payload, d.floats)
+		if !doc.ok {
+			return doc, fmt.Errorf("payload is not valid JSON")
+		}
+		return doc, nil
+	case FormatCBOR:
+		v, err = decoder.CBOR(payload)
+	case FormatMsgPack:
+		v, err = decoder.MsgPack(payload)
+	case FormatText:
+		if !utf8.Valid(payload) {
+			return parsedJSON{}, fmt.Errorf("payload is not valid UTF-8 text")
+		}
+		return parsedJSON{}, nil
+	default:
+		if d.custom == nil {
+			return parsedJSON{}, nil
+		}
+		var m map[string]interface{}
+		if m, err = d.custom.Decode(payload); err == nil {
+			v = m
+		}
+	}
+	if err != nil {
+		return parsedJSON{}, err
+	}
+	if d.floats {
+		v = floatsOnly(v)
+	}
+	return parsedJSON{value: v, ok: true}, nil
+}
+
+// data returns msg.data for a decoded payload: the decoded document for
+// structured formats, the payload string for text and binary, and nil when
+// the payload couldn't be decoded
+func (d *payloadDecoder) data(L *lua.LState, payload []byte, doc parsedJSON) lua.LValue {
+	switch {
+	case doc.ok:
+		return jsonToLTable(L, doc.value)
+	case d.format == FormatBinary, d.format == FormatText && utf8.Valid(payload):
+		return lua.LString(payload)
+	}
+	return lua.LNil
+}
+
+// failed returns the number of payloads that failed to decode
+func (d *payloadDecoder) failed() int64 {
+	if d == nil {
+		return 0
+	}
+	return d.count.Load()
+}
+
+// floatsOnly converts the integers of a decoded document to float64, as
+// JSON decoding does under WithFloatNumbers
+func floatsOnly(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			t[k] = floatsOnly(val)
+		}
+	case []interface{}:
+		for i, val := range t {
+			t[i] = floatsOnly(val)
+		}
+	case int64:
+		return float64(t)
+	}
+	return v
+}
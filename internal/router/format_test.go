@@ -0,0 +1,164 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/decoder"
+)
+
+func TestRouterPayloadFormat(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "data.lua")
+	scriptCode := `
+function transform(msg)
+  local value
+  if type(msg.data) == "table" then
+    value = msg.data.v
+  elseif msg.data ~= nil then
+    value = string.len(msg.data)
+  end
+  return {{ columns = { time = msg.ts, value = value, has_json = msg.json ~= nil } }}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	storage := newMockStorage()
+	var routes []Route
+	for _, format := range []string{"", FormatJSON, FormatCBOR, FormatMsgPack, FormatText, FormatBinary} {
+		table := format
+		if table == "" {
+			table = "lenient"
+		}
+		routes = append(routes, Route{Filter: table + "/+", Script: scriptPath, Table: table, PayloadFormat: format})
+	}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	messages := []struct {
+		topic   string
+		payload []byte
+	}{
+		{"lenient/a", []byte(`{"v": 1}`)},
+		{"lenient/a", []byte(`not json`)},
+		{"json/a", []byte(`{"v": 2}`)},
+		{"json/a", []byte(`not json`)},
+		{"cbor/a", []byte{0xa1, 0x61, 'v', 0x03}},    // {"v": 3}
+		{"cbor/a", []byte{0xa1, 0x61}},               // Truncated
+		{"msgpack/a", []byte{0x81, 0xa1, 'v', 0x04}}, // {"v": 4}
+		{"text/a", []byte("hello")},
+		{"text/a", []byte{0xff, 0xfe}},
+		{"binary/a", []byte{0x00, 0xff, 0x10}},
+	}
+	for _, m := range messages {
+		if err := r.Dispatch(Message{Topic: m.topic, Payload: m.payload, Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	r.Close()
+
+	want := map[string][]interface{}{
+		"lenient": {1.0, nil},
+		"json":    {2.0, nil},
+		"cbor":    {3.0, nil},
+		"msgpack": {4.0},
+		"text":    {5.0, nil},
+		"binary":  {3.0},
+	}
+	for table, values := range want {
+		rows := storage.inserts[table]
+		if len(rows) != len(values) {
+			t.Errorf("%s rows = %d, want %d", table, len(rows), len(values))
+			continue
+		}
+		for i, v := range values {
+			if got := rows[i]["value"]; got != v {
+				t.Errorf("%s row %d value = %v, want %v", table, i, got, v)
+			}
+		}
+	}
+	if rows := storage.inserts["cbor"]; len(rows) > 0 && rows[0]["has_json"] != false {
+		t.Errorf("Expected msg.json to be nil for CBOR payloads, got %v", rows[0]["has_json"])
+	}
+
+	wantErrors := map[string]int64{"lenient/+": 0, "json/+": 1, "cbor/+": 1, "msgpack/+": 0, "text/+": 1, "binary/+": 0}
+	for _, s := range r.RouteStatus() {
+		if s.DecodeErrors != wantErrors[s.Filter] {
+			t.Errorf("%s decode_errors = %d, want %d", s.Filter, s.DecodeErrors, wantErrors[s.Filter])
+		}
+	}
+}
+
+func TestRouterPayloadFormatValidation(t *testing.T) {
+	schemaPath := filepath.Join(t.TempDir(), "schema.json")
+	os.WriteFile(schemaPath, []byte(`{"type": "object"}`), 0644)
+
+	for _, route := range []Route{
+		{Filter: "a/+", PayloadFormat: "xml"},
+		{Filter: "a/+", PayloadFormat: FormatText, PayloadSchema: schemaPath},
+	} {
+		if _, err := New(context.Background(), []Route{route}, newMockStorage(), nil); err == nil {
+			t.Errorf("Expected error for %+v", route)
+		}
+	}
+}
+
+// pairDecoder decodes "key=value" payloads
+type pairDecoder struct{}
+
+func (pairDecoder) Name() string { return "test_pair" }
+
+func (pairDecoder) Decode(payload []byte) (map[string]interface{}, error) {
+	key, value, ok := strings.Cut(string(payload), "=")
+	if !ok {
+		return nil, fmt.Errorf("missing '='")
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]interface{}{key: n}, nil
+}
+
+func TestRouterRegisteredDecoder(t *testing.T) {
+	decoder.Register(pairDecoder{})
+	t.Cleanup(func() { decoder.Unregister("test_pair") })
+	if !slices.Contains(PayloadFormats(), "test_pair") {
+		t.Errorf("PayloadFormats() = %v, missing test_pair", PayloadFormats())
+	}
+
+	scriptPath := filepath.Join(t.TempDir(), "pair.lua")
+	script := `function transform(msg) return {{ columns = { time = msg.ts, v = msg.data and msg.data.v } }} end`
+	os.WriteFile(scriptPath, []byte(script), 0644)
+
+	storage := newMockStorage()
+	routes := []Route{{Filter: "pair/+", Script: scriptPath, Table: "pairs", PayloadFormat: "test_pair"}}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	for _, payload := range []string{"v=7", "garbage"} {
+		r.Dispatch(Message{Topic: "pair/a", Payload: []byte(payload), Time: time.Now()})
+	}
+	r.Drain()
+	r.Close()
+
+	rows := storage.inserts["pairs"]
+	if len(rows) != 2 || rows[0]["v"] != 7.0 || rows[1]["v"] != nil {
+		t.Errorf("Unexpected rows: %v", rows)
+	}
+	if s := r.RouteStatus()[0]; s.DecodeErrors != 1 {
+		t.Errorf("decode_errors = %d, want 1", s.DecodeErrors)
+	}
+}
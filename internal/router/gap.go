@@ -0,0 +1,11 @@
+package router
+
+import "github.com/marcgeld/hermod/internal/gap"
+
+// WithGapDetector reports devices of routes with GapAfter that stop
+// reporting. Without a detector GapAfter is ignored.
+func WithGapDetector(d *gap.Detector) Option {
+	return func(r *Router) {
+		r.gaps = d
+	}
+}
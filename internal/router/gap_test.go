@@ -0,0 +1,53 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/device"
+	"github.com/marcgeld/hermod/internal/gap"
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+func TestRouterGapDetection(t *testing.T) {
+	var events []gap.Event
+	gaps, err := gap.New(gap.Config{OnEvent: func(e gap.Event) { events = append(events, e) }, Logger: logger.New(logger.ERROR)})
+	if err != nil {
+		t.Fatalf("gap.New failed: %v", err)
+	}
+	reg := device.New(&noopDB{}, time.Hour, nil)
+	routes := []Route{{Filter: "meters/+", DeviceID: "topic[2]", GapAfter: time.Minute}}
+	r, err := New(context.Background(), routes, newMockStorage(), nil, WithDeviceRegistry(reg), WithGapDetector(gaps))
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+
+	// A message that arrived two minutes ago leaves m1 silent since
+	sent := time.Now().Add(-2 * time.Minute)
+	if err := r.Dispatch(Message{Topic: "meters/m1", Payload: []byte(`{}`), Time: sent}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	for deadline := time.Now().Add(time.Second); len(events) == 0 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+		gaps.Check()
+	}
+	if len(events) != 1 || events[0].Route != "meters/+" || events[0].Device != "m1" || events[0].Expected != time.Minute {
+		t.Fatalf("Expected a gap for m1, got %+v", events)
+	}
+
+	if err := r.Dispatch(Message{Topic: "meters/m1", Payload: []byte(`{}`), Time: time.Now()}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	r.Close()
+	if len(events) != 2 || events[1].Kind != gap.KindResumed {
+		t.Errorf("Expected m1 to resume, got %+v", events)
+	}
+}
+
+func TestRouterGapAfterNeedsDeviceID(t *testing.T) {
+	routes := []Route{{Filter: "meters/+", GapAfter: time.Minute}}
+	if _, err := New(context.Background(), routes, newMockStorage(), nil); err == nil {
+		t.Error("Expected gap_after without device_id rejected")
+	}
+}
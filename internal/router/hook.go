@@ -0,0 +1,10 @@
+package router
+
+// WithBeforeTransform calls fn in the worker before every script transform.
+// It runs inside the processing timeout, so a slow hook counts against it
+// (used to inject transform delays when testing failure handling).
+func WithBeforeTransform(fn func()) Option {
+	return func(r *Router) {
+		r.transformHook = fn
+	}
+}
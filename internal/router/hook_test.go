@@ -0,0 +1,36 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRouterBeforeTransform(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "route.lua")
+	if err := os.WriteFile(scriptPath, []byte(versionScript("1")), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	var calls atomic.Int64
+	storage := newMockStorage()
+	routes := []Route{{Filter: "sensors/+", Script: scriptPath, Workers: 1, Table: "versions"}}
+	r, err := New(context.Background(), routes, storage, nil, WithBeforeTransform(func() { calls.Add(1) }))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := r.Dispatch(Message{Topic: "sensors/a", Payload: []byte(`{}`), Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	r.Close()
+
+	if calls.Load() != 3 || storage.count("versions") != 3 {
+		t.Errorf("hook calls=%d stored=%d, want 3 and 3", calls.Load(), storage.count("versions"))
+	}
+}
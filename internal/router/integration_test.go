@@ -2,10 +2,18 @@ package router
 
 import (
 	"context"
+	"errors"
 	"os"
 	"path/filepath"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/marcgeld/hermod/internal/device"
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/lookup"
+	hermodlua "github.com/marcgeld/hermod/internal/lua"
+	lua "github.com/yuin/gopher-lua"
 )
 
 func TestWorkerWithLuaTransform(t *testing.T) {
@@ -138,8 +146,8 @@ end
 
 	// Should fail due to invalid column
 	err = worker.process(msg)
-	if err == nil {
-		t.Error("Expected error for undeclared column, got nil")
+	if !errors.Is(err, errs.ErrSchemaViolation) {
+		t.Errorf("Expected schema violation for undeclared column, got %v", err)
 	}
 
 	// No data should be inserted
@@ -371,3 +379,396 @@ end
 		t.Error("Expected no inserts for non-JSON payload")
 	}
 }
+
+func TestWorkerLookupAndTopicLevels(t *testing.T) {
+	tmpDir := t.TempDir()
+	csvPath := filepath.Join(tmpDir, "devices.csv")
+	if err := os.WriteFile(csvPath, []byte("id,name,offset\nabc,kitchen,0.5\n"), 0644); err != nil {
+		t.Fatalf("failed to write CSV: %v", err)
+	}
+	reg := lookup.New(nil)
+	if err := reg.Add(context.Background(), lookup.Config{Name: "devices", CSV: csvPath}); err != nil {
+		t.Fatalf("failed to load lookup: %v", err)
+	}
+
+	scriptPath := filepath.Join(tmpDir, "enrich.lua")
+	scriptCode := `
+function transform(msg)
+  local dev = lookup("devices", msg.topic_levels[2])
+  local missing = lookup("devices", "nope")
+  return {
+    {
+      table = "enriched",
+      columns = {
+        name = dev.name,
+        value = msg.json.value + dev.offset,
+        missing = missing == nil
+      }
+    }
+  }
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	storage := newMockStorage()
+	r, err := New(context.Background(), []Route{{Filter: "ruuvi/+", Script: scriptPath, Table: "enriched"}}, storage, nil, WithLookups(reg))
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+
+	if err := r.Dispatch(Message{Topic: "ruuvi/abc", Payload: []byte(`{"value": 20}`), Time: time.Now().UTC()}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	r.Close()
+
+	if len(storage.inserts["enriched"]) != 1 {
+		t.Fatalf("Expected 1 insert, got %d", len(storage.inserts["enriched"]))
+	}
+	rec := storage.inserts["enriched"][0]
+	if rec["name"] != "kitchen" || rec["value"] != 20.5 || rec["missing"] != true {
+		t.Errorf("Unexpected enriched record: %v", rec)
+	}
+}
+
+func TestWorkerLuaFunc(t *testing.T) {
+	hermodlua.RegisterLuaFunc("site_name", func(L *lua.LState) int {
+		L.Push(lua.LString("global"))
+		return 1
+	})
+	hermodlua.RegisterLuaFunc("site_zone", func(L *lua.LState) int {
+		L.Push(lua.LString("global"))
+		return 1
+	})
+
+	scriptPath := filepath.Join(t.TempDir(), "custom.lua")
+	scriptCode := `
+function transform(msg)
+  return {{ columns = { name = site_name(), zone = site_zone() } }}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	storage := newMockStorage()
+	zone := WithLuaFunc("site_zone", func(L *lua.LState) int {
+		L.Push(lua.LString("north"))
+		return 1
+	})
+	r, err := New(context.Background(), []Route{{Filter: "site/+", Script: scriptPath, Table: "custom"}}, storage, nil, zone)
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+
+	if err := r.Dispatch(Message{Topic: "site/a", Payload: []byte(`{}`), Time: time.Now().UTC()}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	r.Close()
+
+	rows := storage.inserts["custom"]
+	if len(rows) != 1 || rows[0]["name"] != "global" || rows[0]["zone"] != "north" {
+		t.Errorf("Expected global helper and router override, got %v", rows)
+	}
+}
+
+func TestWorkerSharedTable(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "shared.lua")
+	scriptCode := `
+function transform(msg)
+  local n = shared.incr("seen")
+  shared.set("last_topic", msg.topic)
+  local ok = pcall(shared.set, "bad", {})
+  return {{ columns = { n = n, last = shared.get("last_topic"), table_rejected = not ok, missing = shared.get("none") == nil } }}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	storage := newMockStorage()
+	r, err := New(context.Background(), []Route{{Filter: "site/+", Script: scriptPath, Table: "counts", Workers: 4}}, storage, nil)
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+
+	for i := 0; i < 20; i++ {
+		if err := r.Dispatch(Message{Topic: "site/a", Payload: []byte(`{}`), Time: time.Now().UTC()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	time.Sleep(200 * time.Millisecond)
+	r.Close()
+
+	rows := storage.inserts["counts"]
+	if len(rows) != 20 {
+		t.Fatalf("Expected 20 rows, got %d", len(rows))
+	}
+	seen := make(map[float64]bool)
+	for _, row := range rows {
+		seen[row["n"].(float64)] = true
+		if row["last"] != "site/a" || row["table_rejected"] != true || row["missing"] != true {
+			t.Errorf("Unexpected row: %v", row)
+		}
+	}
+	// Every increment is visible to all workers, so each count appears once
+	if len(seen) != 20 || !seen[20] {
+		t.Errorf("Expected counts 1..20 across workers, got %v", seen)
+	}
+}
+
+func TestWorkerLuaRecycle(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "recycle.lua")
+	scriptCode := `
+function transform(msg)
+  seen = (seen or 0) + 1
+  return {{ columns = { seen = seen, total = shared.incr("total") } }}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	storage := newMockStorage()
+	r, err := New(context.Background(), []Route{{Filter: "site/+", Script: scriptPath, Table: "counts", LuaRecycle: 3}}, storage, nil)
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	for i := 0; i < 7; i++ {
+		if err := r.Dispatch(Message{Topic: "site/a", Payload: []byte(`{}`), Time: time.Now().UTC()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	r.Close()
+
+	// Globals start over with every new state; the shared table is kept
+	rows := storage.inserts["counts"]
+	want := []float64{1, 2, 3, 1, 2, 3, 1}
+	if len(rows) != len(want) {
+		t.Fatalf("Expected %d rows, got %d", len(want), len(rows))
+	}
+	for i, row := range rows {
+		if row["seen"] != want[i] || row["total"] != float64(i+1) {
+			t.Errorf("row %d = %v, want seen=%v total=%d", i, row, want[i], i+1)
+		}
+	}
+
+	if _, err := New(context.Background(), []Route{{Filter: "x", Script: scriptPath, LuaRecycle: -1}}, storage, nil); err == nil {
+		t.Error("Expected error for negative lua_recycle")
+	}
+}
+
+func TestWorkerDeviceRegistry(t *testing.T) {
+	tmpDir := t.TempDir()
+	scriptPath := filepath.Join(tmpDir, "devices.lua")
+	scriptCode := `
+function transform(msg)
+  local info = device_info(msg.topic_levels[2])
+  return {
+    { table = "seen", columns = { count = info.message_count, silent = #silent_devices(3600) } }
+  }
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	reg := device.New(&noopDB{}, time.Hour, nil)
+	storage := newMockStorage()
+	routes := []Route{{Filter: "ruuvi/+", Script: scriptPath, Table: "seen", DeviceID: "topic[2]"}}
+	r, err := New(context.Background(), routes, storage, nil, WithDeviceRegistry(reg))
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := r.Dispatch(Message{Topic: "ruuvi/abc", Payload: []byte(`{}`), Time: time.Now().UTC()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	r.Close()
+
+	rows := storage.inserts["seen"]
+	if len(rows) != 2 || rows[1]["count"] != 2.0 || rows[1]["silent"] != 0.0 {
+		t.Errorf("Unexpected rows: %v", rows)
+	}
+
+	// device_id without a registry is rejected
+	if _, err := New(context.Background(), routes, storage, nil); err == nil {
+		t.Error("Expected error for device_id without registry")
+	}
+}
+
+// noopDB satisfies device.Database for tests
+type noopDB struct{}
+
+func (noopDB) Exec(ctx context.Context, query string, args ...interface{}) error { return nil }
+
+func (noopDB) QueryRows(ctx context.Context, query string) ([]string, []map[string]interface{}, error) {
+	return nil, nil, nil
+}
+
+func TestWorkerRuuviDecode(t *testing.T) {
+	scriptPath := filepath.Join("..", "..", "examples", "ruuvi_decode.lua")
+
+	storage := newMockStorage()
+	routes := []Route{{Filter: "ruuvi/gw/+", Script: scriptPath, Table: "ruuvi_data"}}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+
+	payload := `{"data":"0201061BFF99040512FC5394C37C0004FFFC040CAC364200CDCBB8334C884F","rssi":-65}`
+	if err := r.Dispatch(Message{Topic: "ruuvi/gw/abc", Payload: []byte(payload), Time: time.Now().UTC()}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	r.Close()
+
+	rows := storage.inserts["ruuvi_data"]
+	if len(rows) != 1 {
+		t.Fatalf("Expected 1 row, got %d", len(rows))
+	}
+	row := rows[0]
+	if row["sensor_id"] != "CB:B8:33:4C:88:4F" || row["temperature"] != 24.3 || row["battery"] != 2.977 || row["rssi"] != -65.0 {
+		t.Errorf("Unexpected row: %v", row)
+	}
+}
+
+func TestWorkerDSMRDecode(t *testing.T) {
+	scriptPath := filepath.Join("..", "..", "examples", "dsmr_decode.lua")
+
+	storage := newMockStorage()
+	routes := []Route{{Filter: "p1/telegram", Script: scriptPath, Table: "energy_readings"}}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+
+	telegram := "/KFM5KAIFA-METER\r\n\r\n0-0:96.1.1(4530303034303031353934373534343134)\r\n1-0:1.8.1(000123.456*kWh)\r\n1-0:1.7.0(00.540*kW)\r\n!\r\n"
+	if err := r.Dispatch(Message{Topic: "p1/telegram", Payload: []byte(telegram), Time: time.Now().UTC()}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	r.Close()
+
+	rows := storage.inserts["energy_readings"]
+	if len(rows) != 1 {
+		t.Fatalf("Expected 1 row, got %d", len(rows))
+	}
+	row := rows[0]
+	if row["meter_id"] != "4530303034303031353934373534343134" || row["energy_delivered_tariff1"] != 123.456 || row["power_delivered"] != 0.54 {
+		t.Errorf("Unexpected row: %v", row)
+	}
+}
+
+func TestRouteQuarantine(t *testing.T) {
+	tmpDir := t.TempDir()
+	scriptPath := filepath.Join(tmpDir, "broken.lua")
+	scriptCode := `
+function transform(msg)
+  if msg.json.ok then
+    return { { columns = { v = 1 } } }
+  end
+  error("boom")
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	var quarantined []string
+	var mu sync.Mutex
+	onQuarantine := func(filter string, errors int, lastErr error) {
+		mu.Lock()
+		defer mu.Unlock()
+		quarantined = append(quarantined, filter)
+	}
+
+	storage := newMockStorage()
+	routes := []Route{{Filter: "sensors/+", Script: scriptPath, Table: "ok_data", QuarantineAfter: 3}}
+	r, err := New(context.Background(), routes, storage, nil, WithQuarantineHandler(onQuarantine))
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	defer r.Close()
+
+	send := func(payload string) {
+		if err := r.Dispatch(Message{Topic: "sensors/a", Payload: []byte(payload), Time: time.Now().UTC()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	// A success in between resets the count
+	send(`{"ok":false}`)
+	send(`{"ok":false}`)
+	send(`{"ok":true}`)
+	if st := r.RouteStatus()[0]; st.Quarantined || st.ConsecutiveErrors != 0 {
+		t.Fatalf("Unexpected status after success: %+v", st)
+	}
+
+	for i := 0; i < 4; i++ {
+		send(`{"ok":false}`)
+	}
+	if !r.RouteStatus()[0].Quarantined {
+		t.Fatal("Expected route to be quarantined")
+	}
+	mu.Lock()
+	if len(quarantined) != 1 || quarantined[0] != "sensors/+" {
+		t.Errorf("Expected a single quarantine notification, got %v", quarantined)
+	}
+	mu.Unlock()
+
+	// The 4th failing message was diverted to passthrough
+	if n := storage.count("iot_raw"); n != 1 {
+		t.Errorf("Expected 1 diverted message, got %d", n)
+	}
+
+	if err := r.Release("sensors/+"); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	send(`{"ok":true}`)
+	if n := storage.count("ok_data"); n != 2 {
+		t.Errorf("Expected 2 transformed rows after release, got %d", n)
+	}
+	if err := r.Release("missing/#"); err == nil {
+		t.Error("Expected error releasing an unknown route")
+	}
+}
+
+func TestWorkerMessageQoSAndRetain(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "flags.lua")
+	scriptCode := `
+function transform(msg)
+  return { { columns = { qos = msg.qos, retain = msg.retain } } }
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	storage := newMockStorage()
+	r, err := New(context.Background(), []Route{{Filter: "state/+", Script: scriptPath, Table: "flags"}}, storage, nil)
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+	if err := r.Dispatch(Message{Topic: "state/a", Payload: []byte(`{}`), QoS: 1, Retain: true, Time: time.Now().UTC()}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	r.Drain()
+	r.Close()
+
+	rows := storage.inserts["flags"]
+	if len(rows) != 1 {
+		t.Fatalf("Expected 1 insert, got %d", len(rows))
+	}
+	if rows[0]["qos"] != float64(1) || rows[0]["retain"] != true {
+		t.Errorf("Expected qos 1 and retain true, got %v", rows[0])
+	}
+}
@@ -0,0 +1,81 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/sink"
+)
+
+// LatestWriter maintains the "<table>_latest" companion of a table: one row
+// per key holding the newest record, so current-state queries don't need
+// window functions over the full table
+type LatestWriter interface {
+	UpsertLatest(ctx context.Context, table, key string, data map[string]interface{}) error
+}
+
+// WithLatestWriter sets the writer routes with a latest_key upsert their
+// records through
+func WithLatestWriter(w LatestWriter) Option {
+	return func(r *Router) {
+		r.latestWriter = w
+	}
+}
+
+// latestTable is a Storage stage that writes every stored record through
+// to its table's "<table>_latest" companion, keyed by the key column.
+// Records without the key column are only stored.
+type latestTable struct {
+	key    string
+	writer LatestWriter
+	next   Storage
+}
+
+// InsertIntoTable stores the record, then upserts it into the latest table
+func (l *latestTable) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	if err := l.next.InsertIntoTable(ctx, table, data); err != nil {
+		return err
+	}
+	if _, ok := data[l.key]; !ok {
+		return nil
+	}
+	if err := l.writer.UpsertLatest(ctx, table, l.key, data); err != nil {
+		return fmt.Errorf("failed to update %s_latest: %w", table, err)
+	}
+	return nil
+}
+
+// InsertBatch stores the rows, then upserts the newest row of every key
+func (l *latestTable) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error {
+	bs, ok := l.next.(sink.BatchStorage)
+	if !ok {
+		return sink.InsertEach(ctx, l, table, rows)
+	}
+	if err := bs.InsertBatch(ctx, table, rows); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	newest := make(map[interface{}]map[string]interface{})
+	var order []interface{}
+	for _, data := range rows {
+		k, ok := data[l.key]
+		if !ok {
+			continue
+		}
+		cur, seen := newest[k]
+		if !seen {
+			order = append(order, k)
+		}
+		if !seen || !sink.RecordTime(data, now).Before(sink.RecordTime(cur, now)) {
+			newest[k] = data
+		}
+	}
+	for _, k := range order {
+		if err := l.writer.UpsertLatest(ctx, table, l.key, newest[k]); err != nil {
+			return fmt.Errorf("failed to update %s_latest: %w", table, err)
+		}
+	}
+	return nil
+}
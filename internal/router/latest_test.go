@@ -0,0 +1,80 @@
+package router
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingLatest records the upserts of a latestTable stage
+type recordingLatest struct {
+	mu   sync.Mutex
+	rows map[string]map[interface{}]map[string]interface{} // table -> key value -> row
+	n    int
+}
+
+func (l *recordingLatest) UpsertLatest(ctx context.Context, table, key string, data map[string]interface{}) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rows == nil {
+		l.rows = make(map[string]map[interface{}]map[string]interface{})
+	}
+	if l.rows[table] == nil {
+		l.rows[table] = make(map[interface{}]map[string]interface{})
+	}
+	l.rows[table][data[key]] = data
+	l.n++
+	return nil
+}
+
+func TestLatestTableWritesThrough(t *testing.T) {
+	next := newMockStorage()
+	writer := &recordingLatest{}
+	stage := &latestTable{key: "device", writer: writer, next: next}
+	ctx := context.Background()
+
+	if err := stage.InsertIntoTable(ctx, "readings", map[string]interface{}{"device": "a1", "v": 1.0}); err != nil {
+		t.Fatalf("InsertIntoTable failed: %v", err)
+	}
+	// Records without the key are only stored
+	if err := stage.InsertIntoTable(ctx, "readings", map[string]interface{}{"v": 2.0}); err != nil {
+		t.Fatalf("InsertIntoTable failed: %v", err)
+	}
+	if next.count("readings") != 2 || writer.n != 1 || writer.rows["readings"]["a1"]["v"] != 1.0 {
+		t.Errorf("stored=%d upserts=%d latest=%v", next.count("readings"), writer.n, writer.rows["readings"])
+	}
+}
+
+func TestLatestTableBatchUpsertsNewestPerKey(t *testing.T) {
+	next := &batchStorage{mockStorage: newMockStorage()}
+	writer := &recordingLatest{}
+	stage := &latestTable{key: "device", writer: writer, next: next}
+	t0 := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	rows := []map[string]interface{}{
+		{"device": "a1", "time": t0.Add(time.Minute), "v": 2.0},
+		{"device": "b2", "time": t0, "v": 10.0},
+		{"device": "a1", "time": t0, "v": 1.0}, // late arrival: older than the row above
+	}
+	if err := stage.InsertBatch(context.Background(), "readings", rows); err != nil {
+		t.Fatalf("InsertBatch failed: %v", err)
+	}
+	if len(next.batches) != 1 || writer.n != 2 {
+		t.Fatalf("batches=%v upserts=%d, want 1 batch and 2 upserts", next.batches, writer.n)
+	}
+	if v := writer.rows["readings"]["a1"]["v"]; v != 2.0 {
+		t.Errorf("latest a1 = %v, want the newest row (2)", v)
+	}
+}
+
+func TestRouterLatestKey(t *testing.T) {
+	routes := []Route{{Filter: "a/#", LatestKey: "device"}}
+	if _, err := New(context.Background(), routes, newMockStorage(), nil); err == nil {
+		t.Error("Expected latest_key without a writer to be rejected")
+	}
+	routes[0].LatestKey = "bad key"
+	if _, err := New(context.Background(), routes, newMockStorage(), nil, WithLatestWriter(&recordingLatest{})); err == nil {
+		t.Error("Expected an invalid latest_key to be rejected")
+	}
+}
@@ -0,0 +1,116 @@
+package router
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// PayloadLimit caps the size of message payloads
+type PayloadLimit struct {
+	MaxBytes int    // Largest accepted payload in bytes (0 = unlimited)
+	Action   string // "drop" (default) or "truncate" oversize payloads to MaxBytes
+}
+
+// oversizeLogInterval is how often oversize messages are reported per route
+const oversizeLogInterval = time.Minute
+
+// Validate checks the payload limit
+func (p *PayloadLimit) Validate() error {
+	if p.MaxBytes < 0 {
+		return fmt.Errorf("max payload size must not be negative")
+	}
+	switch p.Action {
+	case "", "drop", "truncate":
+	default:
+		return fmt.Errorf("invalid oversize action %q: use drop or truncate", p.Action)
+	}
+	return nil
+}
+
+// WithPayloadLimit sets the payload limit for unmatched messages and for
+// routes without their own PayloadLimit
+func WithPayloadLimit(limit PayloadLimit) Option {
+	return func(r *Router) {
+		r.payloadLimit = limit
+	}
+}
+
+// payloadGuard enforces a payload limit and counts oversize messages
+type payloadGuard struct {
+	limit  PayloadLimit
+	name   string // Route filter, or "passthrough"
+	logger *logger.Logger
+	count  atomic.Int64 // Oversize messages since startup
+
+	mu         sync.Mutex
+	lastLog    time.Time
+	suppressed int // Oversize messages not yet logged
+	largest    int // Largest suppressed payload
+	now        func() time.Time
+}
+
+// newPayloadGuard creates a guard for limit (nil when unlimited)
+func newPayloadGuard(limit PayloadLimit, name string, log *logger.Logger) *payloadGuard {
+	if limit.MaxBytes == 0 {
+		return nil
+	}
+	if limit.Action == "" {
+		limit.Action = "drop"
+	}
+	return &payloadGuard{limit: limit, name: name, logger: log, now: time.Now}
+}
+
+// check enforces the limit on msg. It returns false when the message must be
+// dropped; truncated payloads are copied so the oversize buffer can be freed.
+func (g *payloadGuard) check(msg *Message) bool {
+	if g == nil || len(msg.Payload) <= g.limit.MaxBytes {
+		return true
+	}
+	g.count.Add(1)
+	g.report(msg.Topic, len(msg.Payload))
+
+	if g.limit.Action == "drop" {
+		return false
+	}
+	msg.Payload = append([]byte(nil), msg.Payload[:g.limit.MaxBytes]...)
+	return true
+}
+
+// report logs oversize messages at most once per interval
+func (g *payloadGuard) report(topic string, size int) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.suppressed++
+	if size > g.largest {
+		g.largest = size
+	}
+	now := g.now()
+	if now.Sub(g.lastLog) < oversizeLogInterval {
+		return
+	}
+	g.logger.Errorf("%s: %s %d oversize messages (largest %d bytes, limit %d, last from %s)",
+		g.name, g.verb(), g.suppressed, g.largest, g.limit.MaxBytes, topic)
+	g.lastLog = now
+	g.suppressed = 0
+	g.largest = 0
+}
+
+// verb describes the action for log messages
+func (g *payloadGuard) verb() string {
+	if g.limit.Action == "truncate" {
+		return "truncated"
+	}
+	return "dropped"
+}
+
+// oversize returns the number of oversize messages seen (0 for an unlimited guard)
+func (g *payloadGuard) oversize() int64 {
+	if g == nil {
+		return 0
+	}
+	return g.count.Load()
+}
@@ -0,0 +1,96 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+func TestPayloadLimitValidate(t *testing.T) {
+	if err := (&PayloadLimit{MaxBytes: -1}).Validate(); err == nil {
+		t.Error("Expected error for negative size")
+	}
+	if err := (&PayloadLimit{MaxBytes: 10, Action: "compress"}).Validate(); err == nil {
+		t.Error("Expected error for unknown action")
+	}
+	if err := (&PayloadLimit{MaxBytes: 10, Action: "truncate"}).Validate(); err != nil {
+		t.Errorf("Expected valid limit, got %v", err)
+	}
+}
+
+func TestPayloadGuardLogsOncePerInterval(t *testing.T) {
+	log := logger.New(logger.ERROR)
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+
+	g := newPayloadGuard(PayloadLimit{MaxBytes: 4}, "Route a/#", log)
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	g.now = func() time.Time { return now }
+
+	for i := 0; i < 5; i++ {
+		msg := Message{Topic: "a/b", Payload: []byte("too large")}
+		if g.check(&msg) {
+			t.Fatal("Expected oversize message to be dropped")
+		}
+	}
+	if n := strings.Count(buf.String(), "oversize"); n != 1 {
+		t.Errorf("Expected 1 log line, got %d: %s", n, buf.String())
+	}
+
+	now = now.Add(oversizeLogInterval)
+	msg := Message{Topic: "a/b", Payload: []byte("too large")}
+	g.check(&msg)
+	if !strings.Contains(buf.String(), "dropped 5 oversize messages (largest 9 bytes, limit 4") {
+		t.Errorf("Expected summary of suppressed messages, got: %s", buf.String())
+	}
+	if g.oversize() != 6 {
+		t.Errorf("oversize = %d, want 6", g.oversize())
+	}
+
+	fits := Message{Topic: "a/b", Payload: []byte("ok")}
+	if !g.check(&fits) {
+		t.Error("Expected message within the limit to pass")
+	}
+}
+
+func TestRouterPayloadLimit(t *testing.T) {
+	storage := newMockStorage()
+	routes := []Route{
+		{Filter: "small/+", Table: "small"},
+		{Filter: "big/+", Table: "big", PayloadLimit: &PayloadLimit{MaxBytes: 4, Action: "truncate"}},
+	}
+	log := logger.New(logger.ERROR)
+	log.SetOutput(&bytes.Buffer{})
+	r, err := New(context.Background(), routes, storage, log, WithPayloadLimit(PayloadLimit{MaxBytes: 8}))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	payload := []byte("0123456789")
+	for _, topic := range []string{"small/a", "big/a", "other"} {
+		if err := r.Dispatch(Message{Topic: topic, Payload: payload, Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch(%s) failed: %v", topic, err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	r.Close()
+
+	if storage.count("small") != 0 {
+		t.Error("Expected oversize message to be dropped by the global limit")
+	}
+	if storage.count("iot_raw") != 0 {
+		t.Error("Expected oversize unmatched message to be dropped")
+	}
+	if rows := storage.inserts["big"]; len(rows) != 1 || rows[0]["raw"] != "0123" {
+		t.Errorf("Expected truncated payload in big, got %v", rows)
+	}
+
+	status := r.RouteStatus()
+	if status[0].Oversize != 1 || status[1].Oversize != 1 {
+		t.Errorf("Oversize counts = %d, %d; want 1, 1", status[0].Oversize, status[1].Oversize)
+	}
+}
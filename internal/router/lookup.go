@@ -0,0 +1,38 @@
+package router
+
+import (
+	"time"
+
+	"github.com/marcgeld/hermod/internal/lookup"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// WithLookups exposes lookup tables to Lua as lookup(name, key).
+// lookup returns a table of attributes, or nil when the key is unknown.
+func WithLookups(reg *lookup.Registry) Option {
+	return func(r *Router) {
+		r.luaSetup = append(r.luaSetup, func(L *lua.LState) {
+			L.SetGlobal("lookup", L.NewFunction(func(L *lua.LState) int {
+				name := L.CheckString(1)
+				key := L.ToString(2)
+				row, ok := reg.Get(name, key)
+				if !ok {
+					L.Push(lua.LNil)
+					return 1
+				}
+				attrs := make(map[string]interface{}, len(row))
+				for k, v := range row {
+					// Normalize database values to Lua-friendly types
+					if f, ok := toFloat(v); ok {
+						v = f
+					} else if t, ok := v.(time.Time); ok {
+						v = t.Format(time.RFC3339Nano)
+					}
+					attrs[k] = v
+				}
+				L.Push(jsonToLTable(L, attrs))
+				return 1
+			}))
+		})
+	}
+}
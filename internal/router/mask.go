@@ -0,0 +1,95 @@
+package router
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+)
+
+// Mask configures per-route anonymization of columns before storage
+type Mask struct {
+	Columns  []string // Columns to mask in every record of the route
+	Strategy string   // "hash", "truncate" or "drop"
+	Salt     string   // Key for "hash" (HMAC-SHA256); recommended for low-entropy IDs like MACs
+	Keep     int      // Characters kept by "truncate" (default: 8)
+}
+
+// defaultMaskKeep is the number of characters kept by "truncate"
+const defaultMaskKeep = 8
+
+// Validate checks the mask configuration
+func (m *Mask) Validate() error {
+	if len(m.Columns) == 0 {
+		return fmt.Errorf("mask requires at least one column")
+	}
+	for _, col := range m.Columns {
+		if !validIdentifier.MatchString(col) {
+			return fmt.Errorf("invalid mask column: %s", col)
+		}
+	}
+	switch m.Strategy {
+	case "hash", "truncate", "drop":
+	default:
+		return fmt.Errorf("invalid mask strategy %q: use hash, truncate or drop", m.Strategy)
+	}
+	if m.Keep < 0 {
+		return fmt.Errorf("mask keep must not be negative")
+	}
+	return nil
+}
+
+// masker is a Storage stage that anonymizes configured columns
+type masker struct {
+	cfg  Mask
+	next Storage
+}
+
+// newMasker creates a masking stage in front of next
+func newMasker(cfg Mask, next Storage) *masker {
+	if cfg.Keep == 0 {
+		cfg.Keep = defaultMaskKeep
+	}
+	return &masker{cfg: cfg, next: next}
+}
+
+// InsertIntoTable masks the record's columns and forwards it
+func (m *masker) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	masked := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		masked[k] = v
+	}
+
+	for _, col := range m.cfg.Columns {
+		val, ok := masked[col]
+		if !ok || val == nil {
+			continue
+		}
+		switch m.cfg.Strategy {
+		case "drop":
+			delete(masked, col)
+		case "hash":
+			masked[col] = m.hash(fmt.Sprintf("%v", val))
+		case "truncate":
+			s := []rune(fmt.Sprintf("%v", val))
+			if len(s) > m.cfg.Keep {
+				s = s[:m.cfg.Keep]
+			}
+			masked[col] = string(s)
+		}
+	}
+
+	return m.next.InsertIntoTable(ctx, table, masked)
+}
+
+// hash returns the hex SHA-256 (or HMAC-SHA256 when salted) of s
+func (m *masker) hash(s string) string {
+	if m.cfg.Salt == "" {
+		sum := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(sum[:])
+	}
+	mac := hmac.New(sha256.New, []byte(m.cfg.Salt))
+	mac.Write([]byte(s))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,74 @@
+package router
+
+import (
+	"context"
+	"testing"
+)
+
+func TestMaskValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		mask    Mask
+		wantErr bool
+	}{
+		{"valid hash", Mask{Columns: []string{"mac"}, Strategy: "hash"}, false},
+		{"valid truncate", Mask{Columns: []string{"plate"}, Strategy: "truncate", Keep: 3}, false},
+		{"no columns", Mask{Strategy: "drop"}, true},
+		{"unknown strategy", Mask{Columns: []string{"mac"}, Strategy: "encrypt"}, true},
+		{"invalid column", Mask{Columns: []string{"bad-col"}, Strategy: "drop"}, true},
+		{"negative keep", Mask{Columns: []string{"mac"}, Strategy: "truncate", Keep: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.mask.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMaskerStrategies(t *testing.T) {
+	tests := []struct {
+		name string
+		mask Mask
+		want interface{} // Expected "mac" value; nil = column removed
+	}{
+		{"hash", Mask{Columns: []string{"mac"}, Strategy: "hash"},
+			"261900fb1113aa4db748173e13abd88ae0faf7536891ee956fea94d58673cbdb"},
+		{"salted hash", Mask{Columns: []string{"mac"}, Strategy: "hash", Salt: "secret"},
+			"e33e0f8ece013f7358edde34c0f08ce9d55d14b8dfc6cf4ca59cf388c78838c5"},
+		{"truncate", Mask{Columns: []string{"mac"}, Strategy: "truncate"}, "AA:BB:CC"},
+		{"truncate keep", Mask{Columns: []string{"mac"}, Strategy: "truncate", Keep: 2}, "AA"},
+		{"drop", Mask{Columns: []string{"mac"}, Strategy: "drop"}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := newMockStorage()
+			m := newMasker(tt.mask, storage)
+
+			in := map[string]interface{}{"mac": "AA:BB:CC:DD:EE:FF", "value": 1.0}
+			if err := m.InsertIntoTable(context.Background(), "t", in); err != nil {
+				t.Fatalf("InsertIntoTable failed: %v", err)
+			}
+
+			if in["mac"] != "AA:BB:CC:DD:EE:FF" {
+				t.Error("input record was modified")
+			}
+			row := storage.inserts["t"][0]
+			got, ok := row["mac"]
+			if tt.want == nil {
+				if ok {
+					t.Errorf("mac = %v, want dropped", got)
+				}
+			} else if got != tt.want {
+				t.Errorf("mac = %v, want %v", got, tt.want)
+			}
+			if row["value"] != 1.0 {
+				t.Errorf("value = %v, want 1", row["value"])
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package router
+
+import (
+	"fmt"
+
+	"github.com/marcgeld/hermod/internal/decoder"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// WithModbusMaps makes the named register maps available to modbus_decode,
+// so scripts can pass a map's name instead of a table
+func WithModbusMaps(maps map[string]decoder.ModbusMap) Option {
+	return func(r *Router) {
+		r.luaSetup = append(r.luaSetup, func(L *lua.LState) {
+			L.SetGlobal("modbus_decode", L.NewFunction(modbusDecode(maps)))
+		})
+	}
+}
+
+// modbusDecode returns modbus_decode for the named register maps:
+//
+//	modbus_decode(payload, map) -> (table | nil, error | nil)
+//
+// payload is a register dump as a hex string, raw bytes or a table of
+// register values; map is the name of a configured map or a table
+// {start = 0, fields = {{name = "voltage", register = 0, type = "uint16", order = "ABCD", scale = 1, offset = 0}, ...}}
+func modbusDecode(maps map[string]decoder.ModbusMap) lua.LGFunction {
+	return func(L *lua.LState) int {
+		var data []byte
+		switch v := L.CheckAny(1).(type) {
+		case lua.LString:
+			data = decoder.RuuviInput(string(v))
+		case *lua.LTable:
+			regs, err := luaRegisters(v)
+			if err != nil {
+				L.ArgError(1, err.Error())
+			}
+			data = decoder.ModbusRegisters(regs)
+		default:
+			L.ArgError(1, "payload must be a string or a table of registers")
+		}
+
+		var m decoder.ModbusMap
+		switch v := L.CheckAny(2).(type) {
+		case lua.LString:
+			named, ok := maps[string(v)]
+			if !ok {
+				L.Push(lua.LNil)
+				L.Push(lua.LString(fmt.Sprintf("unknown modbus map %q", string(v))))
+				return 2
+			}
+			m = named
+		case *lua.LTable:
+			m = luaModbusMap(v)
+		default:
+			L.ArgError(2, "map must be a map name or a table")
+		}
+
+		fields, err := decoder.Modbus(data, m)
+		if err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(jsonToLTable(L, fields))
+		L.Push(lua.LNil)
+		return 2
+	}
+}
+
+// luaRegisters converts an array of register values
+func luaRegisters(t *lua.LTable) ([]uint16, error) {
+	regs := make([]uint16, 0, t.Len())
+	for i := 1; i <= t.Len(); i++ {
+		n, ok := t.RawGetInt(i).(lua.LNumber)
+		if !ok || n < 0 || n > 0xFFFF || n != lua.LNumber(int(n)) {
+			return nil, fmt.Errorf("register %d is not a value between 0 and 65535", i)
+		}
+		regs = append(regs, uint16(n))
+	}
+	return regs, nil
+}
+
+// luaModbusMap converts a register map table; Modbus validates the result
+func luaModbusMap(t *lua.LTable) decoder.ModbusMap {
+	m := decoder.ModbusMap{Start: int(lua.LVAsNumber(t.RawGetString("start")))}
+	fields, _ := t.RawGetString("fields").(*lua.LTable)
+	if fields == nil {
+		return m
+	}
+	for i := 1; i <= fields.Len(); i++ {
+		ft, ok := fields.RawGetInt(i).(*lua.LTable)
+		if !ok {
+			continue
+		}
+		m.Fields = append(m.Fields, decoder.ModbusField{
+			Name:     lua.LVAsString(ft.RawGetString("name")),
+			Register: int(lua.LVAsNumber(ft.RawGetString("register"))),
+			Type:     lua.LVAsString(ft.RawGetString("type")),
+			Order:    lua.LVAsString(ft.RawGetString("order")),
+			Scale:    float64(lua.LVAsNumber(ft.RawGetString("scale"))),
+			Offset:   float64(lua.LVAsNumber(ft.RawGetString("offset"))),
+		})
+	}
+	return m
+}
@@ -0,0 +1,68 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/decoder"
+)
+
+func TestRouterModbusDecode(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "modbus.lua")
+	scriptCode := `
+function transform(msg)
+  local named, err = modbus_decode(msg.json.hex, "meter")
+  local inline = modbus_decode(msg.json.registers, {
+    start = 10,
+    fields = { { name = "temp", register = 11, type = "int16", scale = 0.1 } },
+  })
+  local _, unknown = modbus_decode(msg.json.hex, "missing")
+  return {{ columns = {
+    voltage = named.voltage, energy = named.energy, err = err or "",
+    temp = inline.temp, unknown = unknown or "",
+  } }}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	maps := map[string]decoder.ModbusMap{
+		"meter": {Fields: []decoder.ModbusField{
+			{Name: "voltage", Register: 0, Scale: 0.1},
+			{Name: "energy", Register: 1, Type: "uint32", Order: "CDAB"},
+		}},
+	}
+	storage := newMockStorage()
+	routes := []Route{{Filter: "modbus/+", Script: scriptPath, Table: "meters"}}
+	r, err := New(context.Background(), routes, storage, nil, WithModbusMaps(maps))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	// 2301 -> 230.1 V; 70000 word-swapped; -215 -> -21.5 °C
+	payload := `{"hex": "08FD11700001", "registers": [0, 65321]}`
+	if err := r.Dispatch(Message{Topic: "modbus/gw1", Payload: []byte(payload), Time: time.Now()}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	r.Drain()
+
+	rows := storage.inserts["meters"]
+	if len(rows) != 1 {
+		t.Fatalf("Expected 1 row, got %v", storage.inserts)
+	}
+	row := rows[0]
+	if row["voltage"] != 230.1 || row["energy"] != 70000.0 || row["err"] != "" {
+		t.Errorf("Unexpected named map values: %v", row)
+	}
+	if row["temp"] != -21.5 {
+		t.Errorf("Expected the inline map decoded, got %v", row)
+	}
+	if !strings.Contains(row["unknown"].(string), "unknown modbus map") {
+		t.Errorf("Expected an unknown map reported, got %v", row)
+	}
+}
@@ -0,0 +1,74 @@
+package router
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// maxExactLuaInt is the largest integer a Lua number (float64) holds exactly
+const maxExactLuaInt = 1 << 53
+
+// WithFloatNumbers decodes every JSON number as float64, as before integers
+// were kept exact. Large IDs and counters lose precision in this mode.
+func WithFloatNumbers() Option {
+	return func(r *Router) {
+		r.floatNumbers = true
+	}
+}
+
+// parseJSON decodes a payload as JSON. Integers that fit in int64 are kept
+// as int64 unless floats is set; other numbers become float64.
+func parseJSON(payload []byte, floats bool) parsedJSON {
+	var v interface{}
+	if floats {
+		if err := json.Unmarshal(payload, &v); err != nil {
+			return parsedJSON{}
+		}
+		return parsedJSON{value: v, ok: true}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	dec.UseNumber()
+	if err := dec.Decode(&v); err != nil {
+		return parsedJSON{}
+	}
+	// Reject trailing data like json.Unmarshal does
+	if _, err := dec.Token(); err != io.EOF {
+		return parsedJSON{}
+	}
+	return parsedJSON{value: exactNumbers(v), ok: true}
+}
+
+// exactNumbers replaces json.Number values with int64 where lossless and float64 otherwise
+func exactNumbers(v interface{}) interface{} {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			t[k] = exactNumbers(val)
+		}
+	case []interface{}:
+		for i, val := range t {
+			t[i] = exactNumbers(val)
+		}
+	case json.Number:
+		if n, err := t.Int64(); err == nil {
+			return n
+		}
+		f, _ := t.Float64()
+		return f
+	}
+	return v
+}
+
+// int64ToLua converts an integer to a Lua number, or to a decimal string
+// when a Lua number can't hold it exactly
+func int64ToLua(n int64) lua.LValue {
+	if n > maxExactLuaInt || n < -maxExactLuaInt {
+		return lua.LString(strconv.FormatInt(n, 10))
+	}
+	return lua.LNumber(n)
+}
@@ -0,0 +1,71 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseJSONNumbers(t *testing.T) {
+	payload := []byte(`{"id": 9007199254740993, "count": 42, "temp": 21.5, "big": 1e30, "list": [1, 2.5]}`)
+
+	doc := parseJSON(payload, false)
+	if !doc.ok {
+		t.Fatal("Expected valid JSON")
+	}
+	m := doc.value.(map[string]interface{})
+	if m["id"] != int64(9007199254740993) || m["count"] != int64(42) {
+		t.Errorf("Expected exact integers, got id=%v (%T) count=%v", m["id"], m["id"], m["count"])
+	}
+	if m["temp"] != 21.5 || m["big"] != 1e30 {
+		t.Errorf("Expected floats for fractional and out-of-range numbers, got %v, %v", m["temp"], m["big"])
+	}
+	if list := m["list"].([]interface{}); list[0] != int64(1) || list[1] != 2.5 {
+		t.Errorf("Expected nested numbers to be converted, got %v", list)
+	}
+
+	if m := parseJSON(payload, true).value.(map[string]interface{}); m["count"] != 42.0 {
+		t.Errorf("Expected float64 in float mode, got %T", m["count"])
+	}
+	if parseJSON([]byte(`{"a": 1} trailing`), false).ok {
+		t.Error("Expected trailing data to be rejected")
+	}
+}
+
+func TestWorkerLargeIntegers(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "ids.lua")
+	scriptCode := `
+function transform(msg)
+  return {{ columns = { id = msg.json.id, id_type = type(msg.json.id), small = msg.json.small } }}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	storage := newMockStorage()
+	routes := []Route{{Filter: "ids/+", Script: scriptPath, Table: "ids"}, {Filter: "raw/+", Table: "raw"}}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	payload := []byte(`{"id": 9007199254740993, "small": 7}`)
+	for _, topic := range []string{"ids/a", "raw/a"} {
+		if err := r.Dispatch(Message{Topic: topic, Payload: payload, Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	r.Close()
+
+	rows := storage.inserts["ids"]
+	if len(rows) != 1 || rows[0]["id"] != "9007199254740993" || rows[0]["id_type"] != "string" || rows[0]["small"] != 7.0 {
+		t.Errorf("Expected large integer as exact string in Lua, got %v", rows)
+	}
+	raw := storage.inserts["raw"]
+	if len(raw) != 1 || raw[0]["json"].(map[string]interface{})["id"] != int64(9007199254740993) {
+		t.Errorf("Expected exact integer in passthrough json, got %v", raw)
+	}
+}
@@ -0,0 +1,123 @@
+package router
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Priority classes a route can be given
+const (
+	PriorityNormal = "normal" // Default
+	PriorityHigh   = "high"   // Processed ahead of normal routes while it has messages queued
+)
+
+// validatePriority checks a route's priority class ("" = normal)
+func validatePriority(class string) error {
+	switch class {
+	case "", PriorityNormal, PriorityHigh:
+		return nil
+	}
+	return fmt.Errorf("invalid priority_class %q: use normal or high", class)
+}
+
+// priorityLane holds the workers of normal routes back while high-priority
+// routes have messages queued, so low-volume but critical messages (alarms,
+// commands) get the CPU and database ahead of bulk telemetry when queues are
+// deep. A normal worker finishes the message it holds and waits before
+// taking its next one. Each high-priority route counts its messages through
+// its own laneShare, so a route paused for schema drift stops holding the
+// others back.
+type priorityLane struct {
+	mu      sync.Mutex
+	pending int           // Messages queued or in progress on unpaused high-priority routes
+	clear   chan struct{} // Closed while pending is zero
+}
+
+func newPriorityLane() *priorityLane {
+	l := &priorityLane{clear: make(chan struct{})}
+	close(l.clear)
+	return l
+}
+
+// add changes the pending count; the caller holds mu
+func (l *priorityLane) add(n int) {
+	if n == 0 {
+		return
+	}
+	if l.pending == 0 {
+		l.clear = make(chan struct{})
+	}
+	l.pending += n
+	if l.pending == 0 {
+		close(l.clear)
+	}
+}
+
+// cleared returns a channel closed once no high-priority messages are pending
+func (l *priorityLane) cleared() <-chan struct{} {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.clear
+}
+
+// laneShare is one high-priority route's part of the lane. Its messages
+// count on the lane except while the route is paused. Every method is a
+// no-op on a nil share (normal routes).
+type laneShare struct {
+	lane    *priorityLane
+	pending int  // Messages queued or in progress on the route; guarded by lane.mu
+	paused  bool // Pending messages aren't counted on the lane; guarded by lane.mu
+}
+
+func newLaneShare(lane *priorityLane) *laneShare {
+	return &laneShare{lane: lane}
+}
+
+// queued counts a message about to be queued on the route
+func (s *laneShare) queued() {
+	s.change(1)
+}
+
+// done counts a message processed, or one that couldn't be queued after all
+func (s *laneShare) done() {
+	s.change(-1)
+}
+
+func (s *laneShare) change(n int) {
+	if s == nil {
+		return
+	}
+	s.lane.mu.Lock()
+	defer s.lane.mu.Unlock()
+	s.pending += n
+	if !s.paused {
+		s.lane.add(n)
+	}
+}
+
+// pause stops counting the route's messages on the lane, so normal routes
+// don't wait on messages that won't be processed until the route resumes
+func (s *laneShare) pause() {
+	if s == nil {
+		return
+	}
+	s.lane.mu.Lock()
+	defer s.lane.mu.Unlock()
+	if !s.paused {
+		s.paused = true
+		s.lane.add(-s.pending)
+	}
+}
+
+// resume counts the route's messages on the lane again
+func (s *laneShare) resume() {
+	if s == nil {
+		return
+	}
+	s.lane.mu.Lock()
+	defer s.lane.mu.Unlock()
+	if s.paused {
+		s.paused = false
+		s.lane.add(s.pending)
+	}
+}
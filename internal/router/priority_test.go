@@ -0,0 +1,143 @@
+package router
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// orderStorage records the topics it stores in order, holding "block"
+// payloads until release is closed
+type orderStorage struct {
+	mu      sync.Mutex
+	topics  []string
+	blocked atomic.Int32
+	release chan struct{}
+}
+
+func (s *orderStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	if data["raw"] == "block" {
+		s.blocked.Add(1)
+		<-s.release
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.topics = append(s.topics, data["topic"].(string))
+	return nil
+}
+
+// waitBlocked waits until n inserts are held
+func waitBlocked(t *testing.T, s *orderStorage, n int32) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.blocked.Load() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d blocked inserts, got %d", n, s.blocked.Load())
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestRouterPriorityLane(t *testing.T) {
+	storage := &orderStorage{release: make(chan struct{})}
+	routes := []Route{
+		{Filter: "bulk/+", Workers: 1, QueueSize: 10},
+		{Filter: "alarm/+", Workers: 1, QueueSize: 10, Priority: PriorityHigh},
+	}
+	r, err := New(context.Background(), routes, storage, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	// Occupy both workers, then queue more on each route
+	r.Dispatch(Message{Topic: "bulk/1", Payload: []byte("block"), Time: time.Now()})
+	waitBlocked(t, storage, 1)
+	r.Dispatch(Message{Topic: "alarm/1", Payload: []byte("block"), Time: time.Now()})
+	waitBlocked(t, storage, 2)
+	r.Dispatch(Message{Topic: "bulk/2", Payload: []byte("x"), Time: time.Now()})
+	r.Dispatch(Message{Topic: "alarm/2", Payload: []byte("x"), Time: time.Now()})
+	close(storage.release)
+	r.Drain()
+	r.Close()
+
+	// The bulk worker waits for the queued alarm before taking bulk/2
+	if i, j := slices.Index(storage.topics, "alarm/2"), slices.Index(storage.topics, "bulk/2"); i < 0 || j < 0 || i > j {
+		t.Errorf("Expected alarm/2 stored before bulk/2, got %v", storage.topics)
+	}
+}
+
+func TestRouterPriorityValidation(t *testing.T) {
+	routes := []Route{{Filter: "a/+", Priority: "urgent"}}
+	if _, err := New(context.Background(), routes, newMockStorage(), logger.New(logger.ERROR)); err == nil {
+		t.Error("Expected error for invalid priority class")
+	}
+}
+
+func TestPriorityLane(t *testing.T) {
+	l := newPriorityLane()
+	select {
+	case <-l.cleared():
+	default:
+		t.Fatal("Expected a new lane to be clear")
+	}
+	s := newLaneShare(l)
+	s.queued()
+	s.queued()
+	cleared := l.cleared()
+	s.done()
+	select {
+	case <-cleared:
+		t.Fatal("Expected the lane held while a message is pending")
+	default:
+	}
+	s.done()
+	select {
+	case <-cleared:
+	default:
+		t.Error("Expected the lane clear once every message is done")
+	}
+
+	var nilShare *laneShare
+	nilShare.queued()
+	nilShare.done()
+	nilShare.pause()
+	nilShare.resume()
+}
+
+func TestPriorityLanePause(t *testing.T) {
+	l := newPriorityLane()
+	s := newLaneShare(l)
+	s.queued()
+	s.queued()
+
+	// A paused route's messages don't hold the lane
+	s.pause()
+	select {
+	case <-l.cleared():
+	default:
+		t.Fatal("Expected the lane clear while the only high-priority route is paused")
+	}
+	s.queued()
+	s.done()
+
+	// They count again once it resumes, until processed
+	s.resume()
+	cleared := l.cleared()
+	select {
+	case <-cleared:
+		t.Fatal("Expected the lane held by the resumed route's messages")
+	default:
+	}
+	s.done()
+	s.done()
+	select {
+	case <-cleared:
+	default:
+		t.Error("Expected the lane clear once the resumed route's messages are done")
+	}
+}
@@ -0,0 +1,37 @@
+package router
+
+import lua "github.com/yuin/gopher-lua"
+
+// Properties are the MQTT 5 properties of a message, which scripts read as
+// msg.properties. The built-in MQTT client speaks 3.1.1 and leaves them
+// unset; the NATS source fills them in from message headers, and so do
+// sources that receive MQTT 5 messages.
+type Properties struct {
+	ContentType     string            `json:"content_type,omitempty"`     // MIME type of the payload
+	CorrelationData []byte            `json:"correlation_data,omitempty"` // Request/response correlation
+	User            map[string]string `json:"user,omitempty"`             // User properties (a key sent more than once keeps its last value)
+}
+
+// luaProperties converts a message's properties to msg.properties:
+//
+//	{ content_type = "...", correlation_data = "...", user = { key = value, ... } }
+//
+// Unset properties are nil; messages without properties get nil.
+func luaProperties(L *lua.LState, p *Properties) lua.LValue {
+	if p == nil {
+		return lua.LNil
+	}
+	t := L.CreateTable(0, 3)
+	if p.ContentType != "" {
+		t.RawSetString("content_type", lua.LString(p.ContentType))
+	}
+	if p.CorrelationData != nil {
+		t.RawSetString("correlation_data", lua.LString(string(p.CorrelationData)))
+	}
+	user := L.CreateTable(0, len(p.User))
+	for k, v := range p.User {
+		user.RawSetString(k, lua.LString(v))
+	}
+	t.RawSetString("user", user)
+	return t
+}
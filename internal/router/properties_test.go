@@ -0,0 +1,56 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRouterMessageProperties(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "properties.lua")
+	scriptCode := `
+function transform(msg)
+  local p = msg.properties
+  if p == nil then
+    return {{ columns = { has_properties = false } }}
+  end
+  return {{ table = "readings_" .. p.user.tenant, columns = {
+    content_type = p.content_type, correlation = p.correlation_data,
+  } }}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	storage := newMockStorage()
+	routes := []Route{{Filter: "sensors/+", Script: scriptPath, Table: "readings"}}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	props := &Properties{
+		ContentType:     "application/json",
+		CorrelationData: []byte("req-42"),
+		User:            map[string]string{"tenant": "acme"},
+	}
+	for _, msg := range []Message{
+		{Topic: "sensors/a", Payload: []byte(`{}`), Time: time.Now(), Properties: props},
+		{Topic: "sensors/b", Payload: []byte(`{}`), Time: time.Now()},
+	} {
+		if err := r.Dispatch(msg); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	r.Drain()
+
+	rows := storage.inserts["readings_acme"]
+	if len(rows) != 1 || rows[0]["content_type"] != "application/json" || rows[0]["correlation"] != "req-42" {
+		t.Errorf("Expected the properties in msg.properties, got %v", storage.inserts)
+	}
+	if rows := storage.inserts["readings"]; len(rows) != 1 || rows[0]["has_properties"] != false {
+		t.Errorf("Expected msg.properties nil without properties, got %v", storage.inserts)
+	}
+}
@@ -0,0 +1,71 @@
+package router
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+)
+
+// Provenance columns appended to script records so rows in shared tables
+// can be traced back to the transform that produced them
+const (
+	ProvenanceSource     = "hermod_source"      // Source the message came from (e.g. "tcp://broker:1883")
+	ProvenanceTopic      = "hermod_topic"       // Topic the message was routed on
+	ProvenanceScript     = "hermod_script"      // Path of the route script
+	ProvenanceScriptHash = "hermod_script_hash" // SHA-256 of the script file the worker runs
+	ProvenanceInstance   = "hermod_instance"    // Instance id of the Hermod process
+)
+
+// ProvenanceColumns lists the provenance columns in the order they are documented
+var ProvenanceColumns = []string{ProvenanceSource, ProvenanceTopic, ProvenanceScript, ProvenanceScriptHash, ProvenanceInstance}
+
+// Provenance configures the provenance columns
+type Provenance struct {
+	Instance string // Instance id written to hermod_instance (e.g. the host name)
+}
+
+// WithProvenance appends provenance columns to every record a route script
+// returns, overwriting columns of the same name. Passthrough records keep
+// their canonical format.
+func WithProvenance(p Provenance) Option {
+	return func(r *Router) {
+		r.provenance = &p
+	}
+}
+
+// isProvenanceColumn reports whether col is one of ProvenanceColumns
+func isProvenanceColumn(col string) bool {
+	for _, c := range ProvenanceColumns {
+		if c == col {
+			return true
+		}
+	}
+	return false
+}
+
+// hashScript returns the hex SHA-256 of the script file at path
+// (empty when it can't be read)
+func hashScript(path string) string {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(src)
+	return hex.EncodeToString(sum[:])
+}
+
+// addProvenance sets the provenance columns of a record produced from msg
+func (w *worker) addProvenance(columns map[string]interface{}, msg Message) {
+	if w.provenance == nil {
+		return
+	}
+	var source interface{}
+	if msg.Source != "" {
+		source = msg.Source
+	}
+	columns[ProvenanceSource] = source
+	columns[ProvenanceTopic] = msg.Topic
+	columns[ProvenanceScript] = w.script
+	columns[ProvenanceScriptHash] = w.scriptHash
+	columns[ProvenanceInstance] = w.provenance.Instance
+}
@@ -0,0 +1,96 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRouterProvenance(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "route.lua")
+	if err := os.WriteFile(scriptPath, []byte(versionScript("1")), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	storage := newMockStorage()
+	routes := []Route{{Filter: "sensors/+", Script: scriptPath, Workers: 1, Table: "versions"}}
+	r, err := New(context.Background(), routes, storage, nil, WithProvenance(Provenance{Instance: "edge-1"}))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	defer r.Close()
+
+	send := func(source string) map[string]interface{} {
+		t.Helper()
+		msg := Message{Topic: "sensors/a", Payload: []byte(`{}`), Time: time.Now(), Source: source}
+		if err := r.Dispatch(msg); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+		storage.mu.Lock()
+		defer storage.mu.Unlock()
+		rows := storage.inserts["versions"]
+		return rows[len(rows)-1]
+	}
+
+	row := send("tcp://broker:1883")
+	want := map[string]interface{}{
+		ProvenanceSource:     "tcp://broker:1883",
+		ProvenanceTopic:      "sensors/a",
+		ProvenanceScript:     scriptPath,
+		ProvenanceScriptHash: hashScript(scriptPath),
+		ProvenanceInstance:   "edge-1",
+	}
+	for col, v := range want {
+		if row[col] != v {
+			t.Errorf("%s = %v, want %v", col, row[col], v)
+		}
+	}
+	if len(row[ProvenanceScriptHash].(string)) != 64 {
+		t.Errorf("Expected a SHA-256 script hash, got %q", row[ProvenanceScriptHash])
+	}
+
+	// An unknown source is stored as NULL
+	if row := send(""); row[ProvenanceSource] != nil {
+		t.Errorf("%s = %v, want nil", ProvenanceSource, row[ProvenanceSource])
+	}
+
+	// Reloading the script changes the hash written by every worker
+	oldHash := row[ProvenanceScriptHash]
+	os.WriteFile(scriptPath, []byte(versionScript("2")), 0644)
+	if err := r.Reload("sensors/+"); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	row = send("tcp://broker:1883")
+	if row["version"] != 2.0 || row[ProvenanceScriptHash] == oldHash || row[ProvenanceScriptHash] != hashScript(scriptPath) {
+		t.Errorf("Expected the reloaded script's hash, got %v (version %v)", row[ProvenanceScriptHash], row["version"])
+	}
+}
+
+func TestRouterWithoutProvenance(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "route.lua")
+	if err := os.WriteFile(scriptPath, []byte(versionScript("1")), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	storage := newMockStorage()
+	routes := []Route{{Filter: "sensors/+", Script: scriptPath, Workers: 1, Table: "versions"}}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	if err := r.Dispatch(Message{Topic: "sensors/a", Payload: []byte(`{}`), Time: time.Now(), Source: "tcp://broker:1883"}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	r.Close()
+
+	row := storage.inserts["versions"][0]
+	for _, col := range ProvenanceColumns {
+		if _, ok := row[col]; ok {
+			t.Errorf("Unexpected column %s without provenance", col)
+		}
+	}
+}
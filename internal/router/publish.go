@@ -0,0 +1,86 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// ScriptPublisher publishes the messages scripts send with mqtt_publish
+type ScriptPublisher interface {
+	PublishMessage(topic string, payload []byte, qos byte, retain bool) error
+}
+
+// SetScriptPublisher sets the publisher scripts' mqtt_publish calls go
+// through; until one is set they fail
+func (r *Router) SetScriptPublisher(p ScriptPublisher) {
+	r.publisher.Store(&p)
+}
+
+// registerPublish exposes the route's publisher to a Lua state:
+//
+//	mqtt_publish(topic, payload [, qos [, retain]]) -> (true | nil, error | nil)
+//
+// payload is a string or a table, which is sent as JSON; qos defaults to 0
+// and retain to false. A script can't publish to a topic its own route
+// subscribes to, which would feed its output back into it. States without
+// a publisher (scratch states validating reloads and samples) accept calls
+// without publishing.
+func (h *routeHandler) registerPublish(L *lua.LState) {
+	L.SetGlobal("mqtt_publish", L.NewFunction(func(L *lua.LState) int {
+		topic := L.CheckString(1)
+		var payload []byte
+		switch v := L.CheckAny(2).(type) {
+		case lua.LString:
+			payload = []byte(v)
+		case *lua.LTable:
+			data, err := json.Marshal(lvalueToInterface(v))
+			if err != nil {
+				L.ArgError(2, fmt.Sprintf("payload can't be encoded as JSON: %v", err))
+			}
+			payload = data
+		case lua.LNumber, lua.LBool:
+			payload = []byte(v.String())
+		default:
+			L.ArgError(2, "payload must be a string, number, boolean or table")
+		}
+		qos := L.OptInt(3, 0)
+		if qos < 0 || qos > 2 {
+			L.ArgError(3, "qos must be 0, 1 or 2")
+		}
+		retain := L.OptBool(4, false)
+
+		if err := h.publish(topic, payload, byte(qos), retain); err != nil {
+			L.Push(lua.LNil)
+			L.Push(lua.LString(err.Error()))
+			return 2
+		}
+		L.Push(lua.LTrue)
+		L.Push(lua.LNil)
+		return 2
+	}))
+}
+
+// publish sends a message for the route's script
+func (h *routeHandler) publish(topic string, payload []byte, qos byte, retain bool) error {
+	if topic == "" || strings.ContainsAny(topic, "+#") {
+		return fmt.Errorf("invalid topic %q: publish to a topic without wildcards", topic)
+	}
+	if topicMatches(h.route.Filter, topic) {
+		return fmt.Errorf("topic %s matches the route's own filter %s", topic, h.route.Filter)
+	}
+	if h.publisher == nil {
+		return nil
+	}
+	p := h.publisher.Load()
+	if p == nil {
+		return fmt.Errorf("no MQTT connection to publish to %s", topic)
+	}
+	if err := (*p).PublishMessage(topic, payload, qos, retain); err != nil {
+		return err
+	}
+	h.published.Add(1)
+	return nil
+}
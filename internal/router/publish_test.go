@@ -0,0 +1,104 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// publishedMessage is one message a scriptRecorder received
+type publishedMessage struct {
+	topic   string
+	payload string
+	qos     byte
+	retain  bool
+}
+
+// scriptRecorder records what scripts publish
+type scriptRecorder struct {
+	mu       sync.Mutex
+	messages []publishedMessage
+}
+
+func (p *scriptRecorder) PublishMessage(topic string, payload []byte, qos byte, retain bool) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messages = append(p.messages, publishedMessage{topic, string(payload), qos, retain})
+	return nil
+}
+
+func writePublishScript(t *testing.T) string {
+	t.Helper()
+	scriptPath := filepath.Join(t.TempDir(), "publish.lua")
+	scriptCode := `
+function transform(msg)
+  local ok, err = mqtt_publish("alerts/" .. msg.data.id, { temp = msg.data.temp, high = true }, 1, true)
+  local looped, loopErr = mqtt_publish("sensors/" .. msg.data.id, "again")
+  local bad = pcall(mqtt_publish, "alerts/x", "v", 3)
+  return {{ columns = { ok = ok == true, err = err or "", looped = looped == nil, loop_err = loopErr or "", bad_qos = not bad } }}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+	return scriptPath
+}
+
+func TestRouterMQTTPublish(t *testing.T) {
+	storage := newMockStorage()
+	routes := []Route{{Filter: "sensors/+", Script: writePublishScript(t), Table: "readings"}}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	publisher := &scriptRecorder{}
+	r.SetScriptPublisher(publisher)
+
+	if err := r.Dispatch(Message{Topic: "sensors/a1", Payload: []byte(`{"id": "a1", "temp": 31.5}`), Time: time.Now()}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	r.Drain()
+
+	if len(publisher.messages) != 1 {
+		t.Fatalf("Expected 1 published message, got %+v", publisher.messages)
+	}
+	got := publisher.messages[0]
+	if got.topic != "alerts/a1" || got.payload != `{"high":true,"temp":31.5}` || got.qos != 1 || !got.retain {
+		t.Errorf("Unexpected published message %+v", got)
+	}
+	rec := storage.inserts["readings"][0]
+	if rec["ok"] != true || rec["err"] != "" {
+		t.Errorf("Expected mqtt_publish to return true, got %v", rec)
+	}
+	if rec["looped"] != true || !strings.Contains(rec["loop_err"].(string), "own filter") {
+		t.Errorf("Expected publishing into the route's own filter refused, got %v", rec)
+	}
+	if rec["bad_qos"] != true {
+		t.Errorf("Expected an invalid qos to raise an error, got %v", rec)
+	}
+	if n := r.RouteStatus()[0].Published; n != 1 {
+		t.Errorf("Expected 1 published message counted, got %d", n)
+	}
+}
+
+func TestRouterMQTTPublishWithoutPublisher(t *testing.T) {
+	storage := newMockStorage()
+	routes := []Route{{Filter: "sensors/+", Script: writePublishScript(t), Table: "readings"}}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	if err := r.Dispatch(Message{Topic: "sensors/a1", Payload: []byte(`{"id": "a1"}`), Time: time.Now()}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	r.Drain()
+
+	rec := storage.inserts["readings"][0]
+	if rec["ok"] != false || !strings.Contains(rec["err"].(string), "no MQTT connection") {
+		t.Errorf("Expected mqtt_publish to fail without a publisher, got %v", rec)
+	}
+}
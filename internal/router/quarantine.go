@@ -0,0 +1,129 @@
+package router
+
+import (
+	"fmt"
+)
+
+// QuarantineHandler is called once when a route is quarantined after
+// too many consecutive script errors
+type QuarantineHandler func(filter string, errors int, lastErr error)
+
+// RouteStatus describes the health of a route
+type RouteStatus struct {
+	Filter            string `json:"filter"`
+	Script            string `json:"script"`
+	Quarantined       bool   `json:"quarantined"`
+	ConsecutiveErrors int64  `json:"consecutive_errors"`
+	Processed         int64  `json:"processed"` // Successful transforms
+	Errors            int64  `json:"errors"`    // Failed transforms
+	QueueLength       int    `json:"queue_length"`
+	QueueCapacity     int    `json:"queue_capacity"`
+	QueueHigh         bool   `json:"queue_high"`      // Above the high-water mark
+	QueueWarnings     int64  `json:"queue_warnings"`  // Times the high-water mark was crossed
+	Oversize          int64  `json:"oversize"`        // Messages over the payload limit
+	Retained          int64  `json:"retained"`        // Retained messages skipped or stored as state
+	Denied            int64  `json:"denied"`          // Messages dropped by the route's topic filter
+	Invalid           int64  `json:"invalid"`         // Payloads rejected by the route's JSON Schema
+	DecodeErrors      int64  `json:"decode_errors"`   // Payloads that failed to decode in the route's format
+	RecordOverflow    int64  `json:"record_overflow"` // Messages rejected for exceeding max_records
+	Timeouts          int64  `json:"timeouts"`        // Messages over the processing timeout
+	DroppedColumns    int64  `json:"dropped_columns"` // Undeclared columns dropped under unknown_columns = "drop"
+	Published         int64  `json:"published"`       // Messages the script published with mqtt_publish
+	StoredErrors      int64  `json:"stored_errors"`   // Failed calls of the script's on_stored hook
+
+	ErrorRate  float64 `json:"error_rate"`  // Percent of messages that failed within the error budget window
+	OverBudget bool    `json:"over_budget"` // Error rate above the route's error budget
+
+	Transform TransformTiming `json:"transform"` // Transform durations
+
+	Paused      bool   `json:"paused"`                 // Stopped taking messages because of schema drift
+	SchemaDrift string `json:"schema_drift,omitempty"` // What the table lacks while paused
+}
+
+// WithQuarantineHandler sets the callback invoked when a route is quarantined
+func WithQuarantineHandler(fn QuarantineHandler) Option {
+	return func(r *Router) {
+		r.onQuarantine = fn
+	}
+}
+
+// RouteStatus returns the status of every route, in configuration order
+func (r *Router) RouteStatus() []RouteStatus {
+	status := make([]RouteStatus, 0, len(r.routes))
+	for _, h := range r.routes {
+		rate, over := h.budget.status()
+		status = append(status, RouteStatus{
+			Filter:            h.route.Filter,
+			Script:            h.route.Script,
+			Quarantined:       h.quarantined.Load(),
+			ConsecutiveErrors: h.consecutiveErrors.Load(),
+			Processed:         h.processed.Load(),
+			Errors:            h.failed.Load(),
+			QueueLength:       len(h.msgChan),
+			QueueCapacity:     cap(h.msgChan),
+			QueueHigh:         h.queueHigh.Load(),
+			QueueWarnings:     h.queueWarnings.Load(),
+			Oversize:          h.payload.oversize(),
+			Retained:          h.retained.Load(),
+			Denied:            h.filter.count(),
+			Invalid:           h.schema.invalid(),
+			DecodeErrors:      h.decoder.failed(),
+			RecordOverflow:    h.records.overflow(),
+			Timeouts:          h.timeouts.Load(),
+			DroppedColumns:    h.droppedColumns.Load(),
+			Published:         h.published.Load(),
+			StoredErrors:      h.storedErrors.Load(),
+			ErrorRate:         rate,
+			OverBudget:        over,
+			Transform:         h.timing.snapshot(),
+			Paused:            h.drift.Load() != nil,
+			SchemaDrift:       h.drift.Load().status(),
+		})
+	}
+	return status
+}
+
+// Release re-enables a quarantined route, or one paused for schema drift
+func (r *Router) Release(filter string) error {
+	for _, h := range r.routes {
+		if h.route.Filter != filter {
+			continue
+		}
+		if d := h.drift.Load(); d != nil {
+			h.resume(d, "released")
+		}
+		h.consecutiveErrors.Store(0)
+		if h.quarantined.CompareAndSwap(true, false) {
+			r.logger.Infof("Route %s released from quarantine", filter)
+		}
+		return nil
+	}
+	return fmt.Errorf("route %s not found", filter)
+}
+
+// transformFailed counts a script error and quarantines the route when the
+// limit is reached
+func (h *routeHandler) transformFailed(err error) {
+	h.failed.Add(1)
+	n := h.consecutiveErrors.Add(1)
+	if h.route.QuarantineAfter <= 0 || n < int64(h.route.QuarantineAfter) {
+		return
+	}
+	if !h.quarantined.CompareAndSwap(false, true) {
+		return
+	}
+
+	h.logger.Errorf("Route %s quarantined after %d consecutive script errors (last: %v); messages are diverted to passthrough",
+		h.route.Filter, n, err)
+	if h.onQuarantine != nil {
+		h.onQuarantine(h.route.Filter, int(n), err)
+	}
+}
+
+// transformSucceeded counts a transform and resets the consecutive error count
+func (h *routeHandler) transformSucceeded() {
+	h.processed.Add(1)
+	if h.consecutiveErrors.Load() != 0 {
+		h.consecutiveErrors.Store(0)
+	}
+}
@@ -0,0 +1,67 @@
+package router
+
+import (
+	"fmt"
+)
+
+// QueueWatermarks configures route queue warnings. A WARN is logged when a
+// route queue fills to Warn percent of its capacity; the route is not
+// warned about again until the queue has drained to Clear percent.
+type QueueWatermarks struct {
+	Warn  int // High-water mark in percent of queue capacity (0 = disabled)
+	Clear int // Percent the queue must drain to before warning again (default: Warn/2)
+}
+
+// Validate checks the watermark configuration
+func (q *QueueWatermarks) Validate() error {
+	if q.Warn < 0 || q.Warn > 100 {
+		return fmt.Errorf("queue warn percent must be between 0 and 100")
+	}
+	if q.Clear < 0 || (q.Warn > 0 && q.Clear >= q.Warn) {
+		return fmt.Errorf("queue clear percent must be below the warn percent")
+	}
+	return nil
+}
+
+// WithQueueWatermarks enables high-water-mark warnings for every route queue
+func WithQueueWatermarks(q QueueWatermarks) Option {
+	return func(r *Router) {
+		r.watermarks = q
+	}
+}
+
+// setWatermarks converts the percentages to queue depths for this route
+func (h *routeHandler) setWatermarks(q QueueWatermarks) {
+	if q.Warn == 0 {
+		return
+	}
+	if q.Clear == 0 {
+		q.Clear = q.Warn / 2
+	}
+	capacity := cap(h.msgChan)
+	h.warnDepth = (capacity*q.Warn + 99) / 100
+	if h.warnDepth < 1 {
+		h.warnDepth = 1
+	}
+	h.clearDepth = capacity * q.Clear / 100
+}
+
+// checkQueue logs when the queue crosses its high-water mark and when it
+// has drained again
+func (h *routeHandler) checkQueue() {
+	if h.warnDepth == 0 {
+		return
+	}
+	depth := len(h.msgChan)
+	if depth >= h.warnDepth {
+		if h.queueHigh.CompareAndSwap(false, true) {
+			h.queueWarnings.Add(1)
+			h.logger.Warnf("Route %s queue is %d/%d full; workers are not keeping up",
+				h.route.Filter, depth, cap(h.msgChan))
+		}
+		return
+	}
+	if depth <= h.clearDepth && h.queueHigh.CompareAndSwap(true, false) {
+		h.logger.Infof("Route %s queue drained to %d/%d", h.route.Filter, depth, cap(h.msgChan))
+	}
+}
@@ -0,0 +1,115 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+func TestQueueWatermarksValidate(t *testing.T) {
+	tests := []struct {
+		q       QueueWatermarks
+		wantErr bool
+	}{
+		{QueueWatermarks{}, false},
+		{QueueWatermarks{Warn: 80}, false},
+		{QueueWatermarks{Warn: 80, Clear: 50}, false},
+		{QueueWatermarks{Warn: 120}, true},
+		{QueueWatermarks{Warn: 80, Clear: 80}, true},
+		{QueueWatermarks{Warn: 80, Clear: -1}, true},
+	}
+	for _, tt := range tests {
+		if err := tt.q.Validate(); (err != nil) != tt.wantErr {
+			t.Errorf("Validate(%+v) error = %v, wantErr %v", tt.q, err, tt.wantErr)
+		}
+	}
+}
+
+func TestQueueHighWaterHysteresis(t *testing.T) {
+	log := logger.New(logger.INFO)
+	buf := &bytes.Buffer{}
+	log.SetOutput(buf)
+
+	h := &routeHandler{
+		route:   Route{Filter: "ruuvi/+"},
+		msgChan: make(chan Message, 10),
+		logger:  log,
+	}
+	h.setWatermarks(QueueWatermarks{Warn: 80, Clear: 30})
+
+	fill := func(n int) {
+		for len(h.msgChan) < n {
+			h.msgChan <- Message{}
+			h.checkQueue()
+		}
+		for len(h.msgChan) > n {
+			<-h.msgChan
+			h.checkQueue()
+		}
+	}
+
+	fill(8)
+	if !h.queueHigh.Load() || h.queueWarnings.Load() != 1 {
+		t.Fatalf("Expected one warning at 8/10, got high=%v warnings=%d", h.queueHigh.Load(), h.queueWarnings.Load())
+	}
+	if out := buf.String(); !strings.HasPrefix(out, "WARN: ") || !strings.Contains(out, "Route ruuvi/+ queue is 8/10 full") {
+		t.Errorf("Expected WARN log, got: %s", buf.String())
+	}
+
+	// Hovering around the mark doesn't warn again until the queue drains to 3
+	fill(5)
+	fill(9)
+	if h.queueWarnings.Load() != 1 {
+		t.Errorf("Expected no repeated warning above the clear mark, got %d", h.queueWarnings.Load())
+	}
+	fill(3)
+	if h.queueHigh.Load() {
+		t.Error("Expected warning to clear at 3/10")
+	}
+	fill(8)
+	if h.queueWarnings.Load() != 2 {
+		t.Errorf("Expected a second warning after clearing, got %d", h.queueWarnings.Load())
+	}
+}
+
+func TestRouterRejectsInvalidWatermarks(t *testing.T) {
+	_, err := New(context.Background(), nil, newMockStorage(), nil, WithQueueWatermarks(QueueWatermarks{Warn: 50, Clear: 60}))
+	if err == nil {
+		t.Error("Expected error for clear percent above warn percent")
+	}
+}
+
+func TestRouterDrain(t *testing.T) {
+	storage := newMockStorage()
+	r, err := New(context.Background(), []Route{{Filter: "a/+", Table: "a", QueueSize: 2}}, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	full := 0
+	for i := 0; i < 50; i++ {
+		for {
+			err := r.Dispatch(Message{Topic: "a/1", Payload: []byte(`{}`), Time: time.Now()})
+			if !errors.Is(err, errs.ErrQueueFull) {
+				if err != nil {
+					t.Fatalf("Dispatch failed: %v", err)
+				}
+				break
+			}
+			full++
+			time.Sleep(time.Millisecond)
+		}
+	}
+	r.Drain()
+	r.Close()
+
+	if storage.count("a") != 50 {
+		t.Errorf("Expected every queued message processed, got %d (queue was full %d times)", storage.count("a"), full)
+	}
+}
@@ -0,0 +1,111 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// deadLetter stores the messages a route rejects (payloads failing its
+// schema, transforms returning too many records) in its reject table
+type deadLetter struct {
+	table       string              // Reject table (empty = rejected messages are dropped)
+	passthrough *passthroughHandler // Builds records and stores them, bypassing the route's stages
+}
+
+// newDeadLetter validates the route's reject table
+func newDeadLetter(route Route, passthrough *passthroughHandler) (*deadLetter, error) {
+	if route.RejectTable != "" && route.PayloadSchema == "" && route.MaxRecords == 0 {
+		return nil, fmt.Errorf("reject_table requires payload_schema or max_records")
+	}
+	if route.RejectTable != "" && !validIdentifier.MatchString(route.RejectTable) {
+		return nil, fmt.Errorf("invalid reject table name: %s", route.RejectTable)
+	}
+	return &deadLetter{table: route.RejectTable, passthrough: passthrough}, nil
+}
+
+// store writes msg in the passthrough record format plus an "error" column
+// with the reason, when the route has a reject table
+func (d *deadLetter) store(msg Message, doc parsedJSON, reason error) error {
+	if d.table == "" {
+		return nil
+	}
+	record := d.passthrough.record(msg, doc)
+	record["error"] = reason.Error()
+	if err := d.passthrough.storage.InsertIntoTable(context.Background(), d.table, record); err != nil {
+		return fmt.Errorf("failed to store rejected message in %s: %w", d.table, err)
+	}
+	return nil
+}
+
+// logThrottle limits a recurring log message to once per oversizeLogInterval
+type logThrottle struct {
+	mu         sync.Mutex
+	lastLog    time.Time
+	suppressed int // Events not yet logged
+}
+
+// event records an occurrence and reports whether to log now, with the
+// number of occurrences the log line covers
+func (t *logThrottle) event() (int, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.suppressed++
+	now := time.Now()
+	if now.Sub(t.lastLog) < oversizeLogInterval {
+		return 0, false
+	}
+	n := t.suppressed
+	t.lastLog = now
+	t.suppressed = 0
+	return n, true
+}
+
+// recordGuard enforces a route's cap on records per message, so a buggy
+// transform can't stall storage with thousands of inserts for one message
+type recordGuard struct {
+	max    int
+	name   string // Route filter, for log messages
+	dlq    *deadLetter
+	logger *logger.Logger
+	count  atomic.Int64 // Messages over the cap since startup
+	log    logThrottle
+}
+
+// newRecordGuard creates a guard for the route's max_records (nil when unlimited)
+func newRecordGuard(route Route, dlq *deadLetter, log *logger.Logger) (*recordGuard, error) {
+	if route.MaxRecords < 0 {
+		return nil, fmt.Errorf("max_records must not be negative")
+	}
+	if route.MaxRecords == 0 {
+		return nil, nil
+	}
+	return &recordGuard{max: route.MaxRecords, name: "Route " + route.Filter, dlq: dlq, logger: log}, nil
+}
+
+// check returns false when the transform returned more than the cap of
+// records for msg, in which case none of them are stored and the message is
+// sent to the reject table. The error reports a failure to store it there.
+func (g *recordGuard) check(msg Message, doc parsedJSON, n int) (bool, error) {
+	if g == nil || n <= g.max {
+		return true, nil
+	}
+	g.count.Add(1)
+	reason := fmt.Errorf("transform returned %d records, limit %d", n, g.max)
+	if count, ok := g.log.event(); ok {
+		g.logger.Errorf("%s: rejected %d messages over max_records (last from %s: %v)", g.name, count, msg.Topic, reason)
+	}
+	return false, g.dlq.store(msg, doc, reason)
+}
+
+// overflow returns the number of messages over the cap (0 when unlimited)
+func (g *recordGuard) overflow() int64 {
+	if g == nil {
+		return 0
+	}
+	return g.count.Load()
+}
@@ -0,0 +1,68 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRouterMaxRecords(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "fanout.lua")
+	scriptCode := `
+function transform(msg)
+  local records = {}
+  for i = 1, msg.json.n do
+    records[i] = { columns = { time = msg.ts, i = i } }
+  end
+  return records
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	storage := newMockStorage()
+	routes := []Route{
+		{Filter: "capped/+", Script: scriptPath, Table: "capped", MaxRecords: 3, RejectTable: "rejected"},
+		{Filter: "open/+", Script: scriptPath, Table: "open"},
+	}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	for _, topic := range []string{"capped/a", "open/a"} {
+		for _, payload := range []string{`{"n": 3}`, `{"n": 1000}`} {
+			if err := r.Dispatch(Message{Topic: topic, Payload: []byte(payload), Time: time.Now()}); err != nil {
+				t.Fatalf("Dispatch failed: %v", err)
+			}
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	r.Close()
+
+	if got := storage.count("capped"); got != 3 {
+		t.Errorf("capped rows = %d, want 3 (none from the oversized message)", got)
+	}
+	if got := storage.count("open"); got != 1003 {
+		t.Errorf("open rows = %d, want 1003", got)
+	}
+	rows := storage.inserts["rejected"]
+	if len(rows) != 1 || rows[0]["raw"] != `{"n": 1000}` || rows[0]["error"] != "transform returned 1000 records, limit 3" {
+		t.Errorf("rejected rows = %v, want the oversized message", rows)
+	}
+	if status := r.RouteStatus(); status[0].RecordOverflow != 1 || status[1].RecordOverflow != 0 {
+		t.Errorf("record_overflow = %d, %d; want 1, 0", status[0].RecordOverflow, status[1].RecordOverflow)
+	}
+
+	for _, route := range []Route{
+		{Filter: "a/+", MaxRecords: -1},
+		{Filter: "a/+", RejectTable: "rejected"},
+	} {
+		if _, err := New(context.Background(), []Route{route}, newMockStorage(), nil); err == nil {
+			t.Errorf("Expected error for %+v", route)
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package router
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// sampleSize is the number of recent messages kept per route to validate
+// reloaded scripts against
+const sampleSize = 16
+
+// scriptVersion is a compiled route script. Workers compare its version with
+// the one their Lua state runs and rebuild the state when it changes.
+type scriptVersion struct {
+	proto   *lua.FunctionProto
+	version int64
+	modTime time.Time // Script file modification time when compiled
+	hash    string    // SHA-256 of the script file when compiled
+}
+
+// sampleRing keeps the most recent messages a route's script handled
+type sampleRing struct {
+	mu   sync.Mutex
+	msgs []Message
+	next int
+}
+
+// add records a message, replacing the oldest once the ring is full
+func (s *sampleRing) add(msg Message) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if len(s.msgs) < sampleSize {
+		s.msgs = append(s.msgs, msg)
+		return
+	}
+	s.msgs[s.next] = msg
+	s.next = (s.next + 1) % sampleSize
+}
+
+// list returns a copy of the recorded messages
+func (s *sampleRing) list() []Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Message(nil), s.msgs...)
+}
+
+// WithScriptWatch reloads a route when its script file changes, checking
+// modification times every interval
+func WithScriptWatch(interval time.Duration) Option {
+	return func(r *Router) {
+		r.watchInterval = interval
+	}
+}
+
+// Reload compiles the route's script again, runs it against the route's
+// recent messages and, when every message transforms cleanly, swaps it into
+// the route's workers. Each worker switches before its next message; the
+// route keeps running the old script if the new one is rejected.
+// A successful reload also releases a quarantined route.
+func (r *Router) Reload(filter string) error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	var h *routeHandler
+	for _, rh := range r.routes {
+		if rh.route.Filter == filter {
+			h = rh
+			break
+		}
+	}
+	if h == nil {
+		return fmt.Errorf("route %s not found", filter)
+	}
+	cur := h.script.Load()
+	if cur == nil {
+		return fmt.Errorf("route %s has no script", filter)
+	}
+
+	info, err := os.Stat(h.route.Script)
+	if err != nil {
+		return fmt.Errorf("failed to load Lua script: %w", err)
+	}
+	proto, err := compileScript(h.route.Script)
+	if err != nil {
+		return err
+	}
+	samples := h.samples.list()
+	if err := r.validateScript(proto, h.route.Table, h.decoder, samples); err != nil {
+		return fmt.Errorf("script %s rejected: %w", h.route.Script, err)
+	}
+
+	h.script.Store(&scriptVersion{proto: proto, version: cur.version + 1, modTime: info.ModTime(), hash: hashScript(h.route.Script)})
+	h.consecutiveErrors.Store(0)
+	h.quarantined.Store(false)
+	r.logger.Infof("Route %s reloaded %s (validated against %d recent messages)", filter, h.route.Script, len(samples))
+	return nil
+}
+
+// validateScript runs a compiled script over sample messages in a scratch
+// Lua state; any load, transform or schema error rejects the script.
+func (r *Router) validateScript(proto *lua.FunctionProto, table string, decoder *payloadDecoder, samples []Message) error {
+	w, err := r.scratchWorker(proto, table, decoder)
+	if err != nil {
+		return err
+	}
+	defer w.state.Close()
+
+	for _, msg := range samples {
+		doc, _ := decoder.parse(msg.Payload)
+		if _, err := w.checkedTransform(msg, doc); err != nil {
+			return fmt.Errorf("message from %s: %w", msg.Topic, err)
+		}
+	}
+	return nil
+}
+
+// reload replaces the worker's Lua state with one running v
+func (w *worker) reload(v *scriptVersion) {
+	w.version = v.version
+	L, sch, err := newScriptState(w.ctx, v.proto, w.setup)
+	if err != nil {
+		w.logger.Errorf("Worker %d keeps its previous script: %v", w.id, err)
+		return
+	}
+	w.state.Close()
+	w.state = L
+	w.schema = sch
+	w.proto = v.proto
+	w.scriptHash = v.hash
+	w.transforms = 0
+}
+
+// recycle replaces the worker's Lua state with a fresh one running the same
+// script. gopher-lua memory is reclaimed by the Go garbage collector only once
+// nothing references it, so globals a script accumulates and stack space a
+// state has grown stay allocated for as long as the state lives.
+func (w *worker) recycle() {
+	w.transforms = 0
+	L, sch, err := newScriptState(w.ctx, w.proto, w.setup)
+	if err != nil {
+		w.logger.Errorf("Worker %d keeps its Lua state: %v", w.id, err)
+		return
+	}
+	w.state.Close()
+	w.state = L
+	w.schema = sch
+	if w.logger.Enabled(logger.DEBUG) {
+		w.logger.Debugf("Worker %d recycled its Lua state after %d messages", w.id, w.recycleAfter)
+	}
+}
+
+// watchScripts reloads routes whose script file has been modified
+func (r *Router) watchScripts() {
+	defer r.wg.Done()
+	ticker := time.NewTicker(r.watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, h := range r.routes {
+			cur := h.script.Load()
+			if cur == nil {
+				continue
+			}
+			info, err := os.Stat(h.route.Script)
+			if err != nil || !info.ModTime().After(cur.modTime) {
+				continue
+			}
+			if err := r.Reload(h.route.Filter); err != nil {
+				r.logger.Errorf("Reload of route %s failed: %v", h.route.Filter, err)
+				// Don't retry until the file changes again
+				h.script.CompareAndSwap(cur, &scriptVersion{proto: cur.proto, version: cur.version, modTime: info.ModTime(), hash: cur.hash})
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// versionScript returns a script that tags every row with version
+func versionScript(version string) string {
+	return `
+function transform(msg)
+  return {{columns = {version = ` + version + `}}}
+end
+`
+}
+
+func TestRouterReload(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "route.lua")
+	if err := os.WriteFile(scriptPath, []byte(versionScript("1")), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	storage := newMockStorage()
+	routes := []Route{{Filter: "sensors/+", Script: scriptPath, Workers: 2, Table: "versions"}}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	defer r.Close()
+
+	send := func() {
+		t.Helper()
+		if err := r.Dispatch(Message{Topic: "sensors/a", Payload: []byte(`{"v": 1}`), Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	lastVersion := func() interface{} {
+		storage.mu.Lock()
+		defer storage.mu.Unlock()
+		rows := storage.inserts["versions"]
+		return rows[len(rows)-1]["version"]
+	}
+
+	send()
+	if v := lastVersion(); v != 1.0 {
+		t.Fatalf("version = %v, want 1", v)
+	}
+
+	// A script that fails on recorded messages is rejected
+	os.WriteFile(scriptPath, []byte(`function transform(msg) error("broken") end`), 0644)
+	if err := r.Reload("sensors/+"); err == nil {
+		t.Fatal("Expected reload of broken script to fail")
+	}
+	send()
+	if v := lastVersion(); v != 1.0 {
+		t.Errorf("version after rejected reload = %v, want 1", v)
+	}
+
+	// A valid script is swapped into every worker
+	os.WriteFile(scriptPath, []byte(versionScript("2")), 0644)
+	if err := r.Reload("sensors/+"); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	for i := 0; i < 4; i++ {
+		send()
+		if v := lastVersion(); v != 2.0 {
+			t.Errorf("version after reload = %v, want 2", v)
+		}
+	}
+
+	if err := r.Reload("missing/#"); err == nil {
+		t.Error("Expected error for unknown route")
+	}
+}
+
+func TestRouterReloadRejectsPassthrough(t *testing.T) {
+	r, err := New(context.Background(), []Route{{Filter: "raw/#"}}, newMockStorage(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	defer r.Close()
+	if err := r.Reload("raw/#"); err == nil {
+		t.Error("Expected error reloading a route without a script")
+	}
+}
+
+func TestRouterScriptWatch(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "route.lua")
+	if err := os.WriteFile(scriptPath, []byte(versionScript("1")), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	storage := newMockStorage()
+	routes := []Route{{Filter: "sensors/+", Script: scriptPath, Table: "versions"}}
+	r, err := New(context.Background(), routes, storage, nil, WithScriptWatch(10*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	defer r.Close()
+
+	os.WriteFile(scriptPath, []byte(versionScript("2")), 0644)
+	future := time.Now().Add(time.Minute)
+	os.Chtimes(scriptPath, future, future)
+	time.Sleep(100 * time.Millisecond)
+
+	r.Dispatch(Message{Topic: "sensors/a", Payload: []byte(`{}`), Time: time.Now()})
+	time.Sleep(50 * time.Millisecond)
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+	rows := storage.inserts["versions"]
+	if len(rows) != 1 || rows[0]["version"] != 2.0 {
+		t.Errorf("Expected the watched script to be reloaded, got %v", rows)
+	}
+}
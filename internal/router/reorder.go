@@ -0,0 +1,140 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/sink"
+)
+
+// reorderer is a Storage stage that holds records for a window and writes
+// them sorted by their "time" column. It smooths out sources that upload
+// batches after connectivity gaps so inserts arrive roughly in time order.
+type reorderer struct {
+	window  time.Duration
+	limit   int // Most records held; more are refused until some are written
+	next    Storage
+	logger  *logger.Logger
+	mu      sync.Mutex
+	pending []heldRecord
+	now     func() time.Time
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+// maxHeldRecords bounds the records a reorderer holds, which grows without
+// one when storage is down and failed records are kept for retry
+const maxHeldRecords = 100000
+
+// heldRecord is a buffered record with its arrival and embedded times
+type heldRecord struct {
+	table   string
+	data    map[string]interface{}
+	arrived time.Time
+	at      time.Time
+}
+
+// newReorderer creates a reordering stage in front of next
+func newReorderer(window time.Duration, next Storage, log *logger.Logger) *reorderer {
+	return &reorderer{
+		window: window,
+		limit:  maxHeldRecords,
+		next:   next,
+		logger: log,
+		now:    time.Now,
+		stop:   make(chan struct{}),
+	}
+}
+
+// start runs the periodic release of held records
+func (r *reorderer) start(ctx context.Context) {
+	interval := r.window / 4
+	if interval < 10*time.Millisecond {
+		interval = 10 * time.Millisecond
+	}
+	r.stopped.Add(1)
+	go func() {
+		defer r.stopped.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-r.stop:
+				return
+			case <-ticker.C:
+				r.flush(ctx, false)
+			}
+		}
+	}()
+}
+
+// close stops the release loop and writes all held records
+func (r *reorderer) close(ctx context.Context) {
+	close(r.stop)
+	r.stopped.Wait()
+	r.flush(ctx, true)
+}
+
+// InsertIntoTable buffers a record until its hold window expires. Once the
+// buffer is full it refuses the record with errs.ErrQueueFull, so its
+// message is redelivered rather than held without bound.
+func (r *reorderer) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	now := r.now()
+	r.mu.Lock()
+	if len(r.pending) >= r.limit {
+		r.mu.Unlock()
+		return fmt.Errorf("%w: reorder buffer holds %d records", errs.ErrQueueFull, r.limit)
+	}
+	r.pending = append(r.pending, heldRecord{
+		table:   table,
+		data:    data,
+		arrived: now,
+		at:      sink.RecordTime(data, now),
+	})
+	r.mu.Unlock()
+	return nil
+}
+
+// flush writes records whose window has expired, together with any held
+// records that are older than them, in time order (all records when force is set)
+func (r *reorderer) flush(ctx context.Context, force bool) {
+	cutoff := r.now().Add(-r.window)
+
+	r.mu.Lock()
+	sort.SliceStable(r.pending, func(i, j int) bool {
+		return r.pending[i].at.Before(r.pending[j].at)
+	})
+
+	// Release up to the newest expired record so nothing older is left behind
+	release := 0
+	for i, h := range r.pending {
+		if force || !h.arrived.After(cutoff) {
+			release = i + 1
+		}
+	}
+	ready := r.pending[:release]
+	r.pending = append([]heldRecord(nil), r.pending[release:]...)
+	r.mu.Unlock()
+
+	for i, h := range ready {
+		err := r.next.InsertIntoTable(ctx, h.table, h.data)
+		if err == nil {
+			continue
+		}
+		if force || !redeliverable(err) {
+			r.logger.Errorf("Failed to write reordered row into %s, dropping it: %v", h.table, err)
+			continue
+		}
+		// Hold this and the remaining records until the next flush
+		r.logger.Errorf("Failed to write reordered row into %s, retrying at the next flush: %v", h.table, err)
+		r.mu.Lock()
+		r.pending = append(r.pending, ready[i:]...)
+		r.mu.Unlock()
+		return
+	}
+}
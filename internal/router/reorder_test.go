@@ -0,0 +1,115 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+func TestReordererSortsByTime(t *testing.T) {
+	storage := newMockStorage()
+	ro := newReorderer(time.Minute, storage, logger.New(logger.ERROR))
+	ctx := context.Background()
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ro.now = func() time.Time { return now }
+
+	base := now.Add(-time.Hour)
+	for _, offset := range []int{30, 10, 20} {
+		data := map[string]interface{}{"time": base.Add(time.Duration(offset) * time.Second), "seq": float64(offset)}
+		if err := ro.InsertIntoTable(ctx, "metrics", data); err != nil {
+			t.Fatalf("InsertIntoTable failed: %v", err)
+		}
+	}
+
+	// Nothing is released before the window expires
+	ro.flush(ctx, false)
+	if len(storage.inserts["metrics"]) != 0 {
+		t.Fatalf("Expected records to be held, got %d", len(storage.inserts["metrics"]))
+	}
+
+	// A late record older than the held ones arrives after the window expired for them
+	now = now.Add(61 * time.Second)
+	late := map[string]interface{}{"time": base.Add(5 * time.Second), "seq": 5.0}
+	fresh := map[string]interface{}{"time": now, "seq": 99.0}
+	ro.InsertIntoTable(ctx, "metrics", late)
+	ro.InsertIntoTable(ctx, "metrics", fresh)
+
+	ro.flush(ctx, false)
+	rows := storage.inserts["metrics"]
+	want := []float64{5, 10, 20, 30}
+	if len(rows) != len(want) {
+		t.Fatalf("Expected %d rows, got %d: %v", len(want), len(rows), rows)
+	}
+	for i, w := range want {
+		if rows[i]["seq"] != w {
+			t.Errorf("row %d seq = %v, want %v", i, rows[i]["seq"], w)
+		}
+	}
+
+	// Closing releases the rest
+	ro.close(ctx)
+	rows = storage.inserts["metrics"]
+	if len(rows) != 5 || rows[4]["seq"] != 99.0 {
+		t.Errorf("Expected fresh record after close, got %v", rows)
+	}
+}
+
+func TestReordererRetriesDuringOutage(t *testing.T) {
+	storage := &outageStorage{mockStorage: newMockStorage()}
+	storage.down.Store(true)
+	ro := newReorderer(time.Minute, storage, logger.New(logger.ERROR))
+	ctx := context.Background()
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ro.now = func() time.Time { return now }
+	for _, offset := range []int{20, 10} {
+		ro.InsertIntoTable(ctx, "metrics", map[string]interface{}{"time": now.Add(time.Duration(offset) * time.Second), "seq": float64(offset)})
+	}
+	now = now.Add(61 * time.Second)
+	ro.flush(ctx, false)
+	if len(storage.inserts["metrics"]) != 0 {
+		t.Fatal("Expected no rows while storage is down")
+	}
+
+	storage.down.Store(false)
+	ro.flush(ctx, false)
+	rows := storage.inserts["metrics"]
+	if len(rows) != 2 || rows[0]["seq"] != 10.0 || rows[1]["seq"] != 20.0 {
+		t.Errorf("Expected both held records written in order once storage is back, got %v", rows)
+	}
+}
+
+func TestReordererRefusesWhenFull(t *testing.T) {
+	storage := &outageStorage{mockStorage: newMockStorage()}
+	storage.down.Store(true)
+	ro := newReorderer(time.Minute, storage, logger.New(logger.ERROR))
+	ro.limit = 2
+	ctx := context.Background()
+
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	ro.now = func() time.Time { return now }
+	for i := 0; i < 2; i++ {
+		if err := ro.InsertIntoTable(ctx, "metrics", map[string]interface{}{"time": now, "seq": float64(i)}); err != nil {
+			t.Fatalf("InsertIntoTable failed: %v", err)
+		}
+	}
+
+	// Failed records are kept, so the buffer stays full during the outage
+	now = now.Add(61 * time.Second)
+	ro.flush(ctx, false)
+	err := ro.InsertIntoTable(ctx, "metrics", map[string]interface{}{"time": now, "seq": 2.0})
+	if !errors.Is(err, errs.ErrQueueFull) || !redeliverable(err) {
+		t.Fatalf("Expected a redeliverable queue-full error, got %v", err)
+	}
+
+	storage.down.Store(false)
+	ro.flush(ctx, false)
+	if err := ro.InsertIntoTable(ctx, "metrics", map[string]interface{}{"time": now, "seq": 2.0}); err != nil {
+		t.Errorf("Expected room once held records are written, got %v", err)
+	}
+}
@@ -0,0 +1,62 @@
+package router
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// Retained message policies. Brokers deliver a topic's retained message on
+// subscribe, so after a restart it describes the last known state rather
+// than a fresh reading.
+const (
+	RetainedProcess = "process" // Treat retained messages like any other (default)
+	RetainedSkip    = "skip"    // Drop retained messages
+	RetainedState   = "state"   // Store retained messages in the route's state table, bypassing the script
+)
+
+// defaultStateTable receives retained messages under the "state" policy
+const defaultStateTable = "iot_state"
+
+// validateRetained checks a route's retained message settings
+func validateRetained(route Route) error {
+	switch route.Retained {
+	case "", RetainedProcess, RetainedSkip, RetainedState:
+	default:
+		return fmt.Errorf("invalid retained policy %q: use process, skip or state", route.Retained)
+	}
+	if route.StateTable != "" && !validIdentifier.MatchString(route.StateTable) {
+		return fmt.Errorf("invalid state table name: %s", route.StateTable)
+	}
+	return nil
+}
+
+// handleRetained applies the route's retained policy to msg. It returns
+// false when the message should be processed normally.
+func (r *Router) handleRetained(h *routeHandler, msg Message) (bool, error) {
+	if !msg.Retain {
+		return false, nil
+	}
+	switch h.route.Retained {
+	case RetainedSkip:
+		h.retained.Add(1)
+		if r.logger.Enabled(logger.DEBUG) {
+			r.logger.Debugf("Route %s skipped retained message from %s", h.route.Filter, msg.Topic)
+		}
+		return true, nil
+	case RetainedState:
+		h.retained.Add(1)
+		table := h.route.StateTable
+		if table == "" {
+			table = defaultStateTable
+		}
+		doc, _ := h.decoder.parse(msg.Payload)
+		record := r.passthrough.record(msg, doc)
+		if err := r.passthrough.storage.InsertIntoTable(context.Background(), table, record); err != nil {
+			return true, fmt.Errorf("failed to store retained message in %s: %w", table, err)
+		}
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,60 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRouterRetainedPolicy(t *testing.T) {
+	storage := newMockStorage()
+	routes := []Route{
+		{Filter: "fresh/+", Table: "fresh"},
+		{Filter: "skip/+", Table: "skipped", Retained: RetainedSkip},
+		{Filter: "state/+", Table: "readings", Retained: RetainedState, StateTable: "sensor_state"},
+		{Filter: "default/+", Table: "other", Retained: RetainedState},
+	}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	for _, topic := range []string{"fresh/a", "skip/a", "state/a", "default/a"} {
+		for _, retain := range []bool{true, false} {
+			msg := Message{Topic: topic, Payload: []byte(`{"v": 1}`), Retain: retain, Time: time.Now()}
+			if err := r.Dispatch(msg); err != nil {
+				t.Fatalf("Dispatch(%s) failed: %v", topic, err)
+			}
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	r.Close()
+
+	want := map[string]int{"fresh": 2, "skipped": 1, "readings": 1, "sensor_state": 1, "other": 1, "iot_state": 1}
+	for table, n := range want {
+		if got := storage.count(table); got != n {
+			t.Errorf("%s rows = %d, want %d", table, got, n)
+		}
+	}
+	if rows := storage.inserts["sensor_state"]; len(rows) == 1 && rows[0]["retain"] != true {
+		t.Errorf("Expected state row to keep the retain flag, got %v", rows[0])
+	}
+
+	status := r.RouteStatus()
+	for i, n := range []int64{0, 1, 1, 1} {
+		if status[i].Retained != n {
+			t.Errorf("%s retained = %d, want %d", status[i].Filter, status[i].Retained, n)
+		}
+	}
+}
+
+func TestRouterRetainedValidation(t *testing.T) {
+	for _, route := range []Route{
+		{Filter: "a/+", Retained: "ignore"},
+		{Filter: "a/+", Retained: RetainedState, StateTable: "bad table"},
+	} {
+		if _, err := New(context.Background(), []Route{route}, newMockStorage(), nil); err == nil {
+			t.Errorf("Expected error for %+v", route)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package router
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// TopicRewrite normalizes topics before filtering and route matching.
+// StripPrefix is applied first; Match/Replace then rewrite matching topics.
+type TopicRewrite struct {
+	StripPrefix string // Topic levels removed from topics that start with them (e.g. "legacy/")
+	Match       string // MQTT topic filter selecting topics to rewrite (e.g. "plant/+/t")
+	Replace     string // Template for the new topic: {N} is the Nth level (1-based), {#} the levels matched by a trailing "#"
+}
+
+// WithTopicRewrites rewrites message topics in order before dispatch; every
+// matching rule applies to the result of the previous ones
+func WithTopicRewrites(rules []TopicRewrite) Option {
+	return func(r *Router) {
+		r.rewriteRules = rules
+	}
+}
+
+// RewriteSources returns the topic filters sources must subscribe to so that
+// topics the rules rewrite are received, in addition to the route filters
+func RewriteSources(rules []TopicRewrite) []string {
+	var filters []string
+	for _, rule := range rules {
+		switch {
+		case rule.StripPrefix != "" && rule.Match != "":
+			filters = append(filters, rule.StripPrefix+rule.Match)
+		case rule.StripPrefix != "":
+			filters = append(filters, rule.StripPrefix+"#")
+		case rule.Match != "":
+			filters = append(filters, rule.Match)
+		}
+	}
+	return filters
+}
+
+// templatePart is a literal string or a reference to topic levels
+type templatePart struct {
+	literal string
+	level   int  // 1-based topic level (0 = literal or remainder)
+	rest    bool // Levels matched by "#"
+}
+
+// compiledRewrite is a validated TopicRewrite
+type compiledRewrite struct {
+	strip    string
+	match    string
+	hashAt   int // Level index of a trailing "#" in match (-1 = none)
+	template []templatePart
+}
+
+// topicRewriter applies rewrite rules in order
+type topicRewriter struct {
+	rules []compiledRewrite
+}
+
+// newTopicRewriter validates rules (nil when there are none)
+func newTopicRewriter(rules []TopicRewrite) (*topicRewriter, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+	rw := &topicRewriter{}
+	for i, rule := range rules {
+		c, err := compileRewrite(rule)
+		if err != nil {
+			return nil, fmt.Errorf("topic rewrite %d: %w", i+1, err)
+		}
+		rw.rules = append(rw.rules, c)
+	}
+	return rw, nil
+}
+
+// compileRewrite validates a rule and parses its template
+func compileRewrite(rule TopicRewrite) (compiledRewrite, error) {
+	c := compiledRewrite{strip: rule.StripPrefix, match: rule.Match, hashAt: -1}
+	if rule.StripPrefix == "" && rule.Match == "" {
+		return c, fmt.Errorf("strip_prefix or match is required")
+	}
+	if rule.StripPrefix != "" && (!strings.HasSuffix(rule.StripPrefix, "/") || strings.ContainsAny(rule.StripPrefix, "+#")) {
+		return c, fmt.Errorf("strip_prefix %q must be whole topic levels ending with '/'", rule.StripPrefix)
+	}
+	if (rule.Match == "") != (rule.Replace == "") {
+		return c, fmt.Errorf("match and replace must be set together")
+	}
+	if rule.Match == "" {
+		return c, nil
+	}
+
+	if err := validateFilter(rule.Match); err != nil {
+		return c, fmt.Errorf("match: %w", err)
+	}
+	levels := strings.Split(rule.Match, "/")
+	if levels[len(levels)-1] == "#" {
+		c.hashAt = len(levels) - 1
+	}
+
+	tmpl := rule.Replace
+	for tmpl != "" {
+		open := strings.IndexByte(tmpl, '{')
+		if open < 0 {
+			c.template = append(c.template, templatePart{literal: tmpl})
+			break
+		}
+		if open > 0 {
+			c.template = append(c.template, templatePart{literal: tmpl[:open]})
+		}
+		end := strings.IndexByte(tmpl[open:], '}')
+		if end < 0 {
+			return c, fmt.Errorf("unclosed placeholder in %q", rule.Replace)
+		}
+		ref := tmpl[open+1 : open+end]
+		tmpl = tmpl[open+end+1:]
+
+		if ref == "#" {
+			if c.hashAt < 0 {
+				return c, fmt.Errorf("{#} requires match to end with '#'")
+			}
+			c.template = append(c.template, templatePart{rest: true})
+			continue
+		}
+		n, err := strconv.Atoi(ref)
+		if err != nil || n < 1 || (c.hashAt < 0 && n > len(levels)) || (c.hashAt >= 0 && n > c.hashAt) {
+			return c, fmt.Errorf("invalid placeholder {%s} in %q", ref, rule.Replace)
+		}
+		c.template = append(c.template, templatePart{level: n})
+	}
+	return c, nil
+}
+
+// rewrite returns the topic after applying every rule
+func (rw *topicRewriter) rewrite(topic string) string {
+	if rw == nil {
+		return topic
+	}
+	for _, rule := range rw.rules {
+		if rule.strip != "" {
+			topic = strings.TrimPrefix(topic, rule.strip)
+		}
+		if rule.match != "" && topicMatches(rule.match, topic) {
+			topic = rule.apply(topic)
+		}
+	}
+	return topic
+}
+
+// apply fills the template from a topic matching the rule
+func (c *compiledRewrite) apply(topic string) string {
+	levels := strings.Split(topic, "/")
+	var sb strings.Builder
+	emptyRest := false
+	for _, part := range c.template {
+		switch {
+		case part.rest:
+			if c.hashAt < len(levels) {
+				sb.WriteString(strings.Join(levels[c.hashAt:], "/"))
+			} else {
+				emptyRest = true
+			}
+		case part.level > 0:
+			sb.WriteString(levels[part.level-1])
+		default:
+			sb.WriteString(part.literal)
+		}
+	}
+	if emptyRest {
+		// A "#" that matched no levels leaves a dangling separator
+		return strings.TrimSuffix(sb.String(), "/")
+	}
+	return sb.String()
+}
@@ -0,0 +1,87 @@
+package router
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTopicRewriter(t *testing.T) {
+	rw, err := newTopicRewriter([]TopicRewrite{
+		{StripPrefix: "legacy/"},
+		{Match: "plant/+/t", Replace: "sensors/{2}/temperature"},
+		{Match: "gw/+/#", Replace: "devices/{2}/{#}"},
+	})
+	if err != nil {
+		t.Fatalf("newTopicRewriter failed: %v", err)
+	}
+
+	tests := []struct{ in, want string }{
+		{"legacy/plant/a1/t", "sensors/a1/temperature"},
+		{"plant/a1/t", "sensors/a1/temperature"},
+		{"plant/a1/h", "plant/a1/h"},
+		{"gw/7/ruuvi/aa/data", "devices/7/ruuvi/aa/data"},
+		{"gw/7", "devices/7"},
+		{"other/legacy/x", "other/legacy/x"},
+	}
+	for _, tt := range tests {
+		if got := rw.rewrite(tt.in); got != tt.want {
+			t.Errorf("rewrite(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+
+	for _, bad := range []TopicRewrite{
+		{},
+		{Match: "a/+"},
+		{Match: "a/+", Replace: "b/{3}"},
+		{Match: "a/+", Replace: "b/{#}"},
+		{Match: "a/#", Replace: "b/{2}"},
+		{Match: "a/+", Replace: "b/{1"},
+		{Match: "a/#/b", Replace: "b"},
+		{StripPrefix: "legacy"},
+		{StripPrefix: "+/"},
+	} {
+		if _, err := newTopicRewriter([]TopicRewrite{bad}); err == nil {
+			t.Errorf("Expected error for %+v", bad)
+		}
+	}
+}
+
+func TestRewriteSources(t *testing.T) {
+	got := RewriteSources([]TopicRewrite{
+		{StripPrefix: "legacy/"},
+		{Match: "plant/+/t", Replace: "sensors/{2}/temperature"},
+		{StripPrefix: "site1/", Match: "+/temp", Replace: "sensors/{1}/temperature"},
+	})
+	want := []string{"legacy/#", "plant/+/t", "site1/+/temp"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("RewriteSources = %v, want %v", got, want)
+	}
+}
+
+func TestRouterTopicRewrite(t *testing.T) {
+	storage := newMockStorage()
+	routes := []Route{{Filter: "sensors/+/temperature", Table: "temps"}}
+	rewrites := WithTopicRewrites([]TopicRewrite{{StripPrefix: "site1/"}, {Match: "+/temp", Replace: "sensors/{1}/temperature"}})
+	r, err := New(context.Background(), routes, storage, nil, rewrites)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	for _, topic := range []string{"site1/a/temp", "b/temp", "sensors/c/temperature"} {
+		if err := r.Dispatch(Message{Topic: topic, Payload: []byte(`{}`), Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch(%s) failed: %v", topic, err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	r.Close()
+
+	rows := storage.inserts["temps"]
+	if len(rows) != 3 {
+		t.Fatalf("Expected all topics to reach the route, got %d rows", len(rows))
+	}
+	if rows[0]["topic"] != "sensors/a/temperature" {
+		t.Errorf("Expected the rewritten topic to be stored, got %v", rows[0]["topic"])
+	}
+}
@@ -2,14 +2,19 @@ package router
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"os"
 	"regexp"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/marcgeld/hermod/internal/device"
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/gap"
 	"github.com/marcgeld/hermod/internal/logger"
+	hermodlua "github.com/marcgeld/hermod/internal/lua"
 	"github.com/marcgeld/hermod/internal/schema"
 	lua "github.com/yuin/gopher-lua"
 )
@@ -17,6 +22,7 @@ import (
 // Record represents a database record to be inserted
 type Record struct {
 	Table   string                 // Target table name
+	Sink    string                 // Named sink (see WithSinks; empty = route storage)
 	Columns map[string]interface{} // Column name -> value
 }
 
@@ -27,33 +33,166 @@ type Message struct {
 	QoS     byte
 	Retain  bool
 	Time    time.Time
+	Source  string // Where the message came from (e.g. "tcp://broker:1883"; empty = unknown)
+	Ack     func() // Acknowledges the message to its source once its records are written (nil = nothing to acknowledge)
+	Nack    func() // Called instead of Ack when the message is left unacknowledged for its source to redeliver (nil = nothing to do)
+
+	Properties *Properties // MQTT 5 properties (nil = none, e.g. MQTT 3.1.1)
 }
 
 // Route configuration for MQTT message routing
 type Route struct {
-	Filter    string // MQTT topic filter (e.g., "ruuvi/+", "p1ib/#")
-	Script    string // Path to Lua script (empty = passthrough)
-	Workers   int    // Number of worker goroutines
-	QueueSize int    // Buffered channel size
-	Table     string // Default table name
+	Filter     string        // MQTT topic filter (e.g., "ruuvi/+", "p1ib/#")
+	Script     string        // Path to Lua script (empty = passthrough)
+	Workers    int           // Number of worker goroutines
+	QueueSize  int           // Buffered channel size
+	Table      string        // Default table name (routes without a script: see PassthroughTable)
+	Downsample *Downsample   // Optional aggregation before storage (nil = disabled)
+	Mask       *Mask         // Optional column anonymization before storage (nil = disabled)
+	Reorder    time.Duration // Hold records this long and write them sorted by time (0 = disabled)
+	DeviceID   string        // Device-id expression for the device registry (e.g. "topic[2]")
+	GapAfter   time.Duration // Report devices silent for longer than this (0 = disabled; needs DeviceID)
+	Batch      *Batch        // Optional insert batching shared by the route's workers (nil = synchronous inserts)
+	Timestamp  *Timestamp    // Optional device timestamp parsing; record times are stored in UTC (nil = arrival time)
+
+	PayloadLimit *PayloadLimit // Overrides the router's payload limit for this route (nil = router default)
+
+	TopicFilter *TopicFilter // Drops matching messages in addition to the router's filter (nil = none)
+
+	Retained   string // Policy for retained messages: "process" (default), "skip" or "state"
+	StateTable string // Table for retained messages under the "state" policy (default: iot_state)
+
+	QuarantineAfter int // Divert the route to passthrough after this many consecutive script errors (0 = never)
+
+	LuaRecycle int // Replace each worker's Lua state with a fresh one after this many messages (0 = never)
+
+	PayloadFormat   string // How payloads are decoded into msg.data: json, cbor, msgpack, text or binary (empty = lenient JSON)
+	PayloadEncoding string // Character encoding payloads are transcoded from to UTF-8: utf-8, latin-1 or utf-16le (empty = utf-8)
+	PayloadSchema   string // JSON Schema file payloads must match before the transform runs (empty = no check)
+	RejectTable     string // Table rejected messages are stored in (empty = drop)
+
+	PassthroughTable string // Table a route without a script stores passthrough records in (empty = Table when set, else iot_raw)
+
+	MaxRecords int // Reject messages the transform turns into more records than this (0 = unlimited)
+
+	ProcessingTimeout time.Duration // Longest transform + insert of one message before it fails with errs.ErrTimeout (0 = no limit)
+
+	LatestKey string // Also upsert every record into "<table>_latest", keyed by this column (empty = disabled)
+
+	Tags map[string]string // Static columns added to every script record unless the script sets them (e.g. site = "plant-3")
+
+	Priority string // Priority class: "normal" (default) or "high" to be processed ahead of normal routes
+
+	UnknownColumns string // Columns the table schema doesn't declare: "reject" the record (default) or "drop" them
+
+	TableSuffix string // Write records into per-period tables by their time: "daily", "monthly" or "yearly" (empty = disabled)
+
+	Sink string // Named sink records that don't set one are written to (see WithSinks; empty = route storage)
+
+	ErrorBudget float64 // Error budget in percent of messages, overriding the router's (0 = router's; see WithErrorBudget)
 }
 
 // Router handles message routing and processing
 type Router struct {
-	routes      []*routeHandler
-	passthrough *passthroughHandler
-	logger      *logger.Logger
-	wg          sync.WaitGroup
-	ctx         context.Context
-	cancel      context.CancelFunc
+	routes       []*routeHandler
+	trie         *topicTrie // Route filters indexed by topic level
+	passthrough  *passthroughHandler
+	logger       *logger.Logger
+	luaSetup     []func(*lua.LState) // Applied to every worker Lua state before the script loads
+	devices      *device.Registry    // Optional device registry
+	gaps         *gap.Detector       // Optional device gap detection
+	onQuarantine QuarantineHandler   // Called when a route is quarantined
+	budget       ErrorBudget         // Error budget alerts (Percent 0 = disabled)
+	onBudget     ErrorBudgetHandler  // Called when a route goes over its error budget
+	onBatch      BatchAck            // Called for every batched write
+	watermarks   QueueWatermarks     // Route queue warning thresholds
+	wg           sync.WaitGroup      // Background goroutines other than workers
+	workers      sync.WaitGroup
+	closeQueues  sync.Once
+	ctx          context.Context
+	cancel       context.CancelFunc
+
+	watchInterval time.Duration                   // How often script files are checked for changes (0 = never)
+	scriptTimeout time.Duration                   // Longest single run of a route script (0 = DefaultScriptTimeout)
+	reloadMu      sync.Mutex                      // Serializes script reloads
+	payloadLimit  PayloadLimit                    // Default payload limit
+	oversize      *payloadGuard                   // Payload limit for unmatched messages (nil = unlimited)
+	topicFilter   TopicFilter                     // Messages dropped before routing
+	deny          *topicGuard                     // Applies topicFilter (nil = none)
+	floatNumbers  bool                            // Decode JSON numbers as float64 only
+	sinks         map[string]Storage              // Named sinks records can target
+	rewriteRules  []TopicRewrite                  // Topic normalization rules
+	rewriter      *topicRewriter                  // Applies rewriteRules (nil = none)
+	columnCase    string                          // Column name case policy (empty = preserve)
+	spoolDir      string                          // Directory queued messages are saved to on Close (empty = discard)
+	spoolKey      []byte                          // Encrypts the spool (nil = plain JSON lines)
+	provenance    *Provenance                     // Provenance columns added to script records (nil = none)
+	latestWriter  LatestWriter                    // Maintains <table>_latest for routes with a latest key (nil = none)
+	transformHook func()                          // Called before every script transform (nil = none)
+	tapOut        *tapOutput                      // Where route taps send messages
+	lane          *priorityLane                   // Holds normal routes back for high-priority ones (nil = no high-priority routes)
+	slowTransform time.Duration                   // Transforms slower than this are logged (0 = never)
+	inspector     SchemaInspector                 // Re-validates tables of routes paused for schema drift (nil = none)
+	driftInterval time.Duration                   // How often a paused route's table is re-validated
+	tableCreator  TableCreator                    // Creates period tables of routes with a table suffix (nil = they must exist)
+	publisher     atomic.Pointer[ScriptPublisher] // Where scripts' mqtt_publish calls go (nil = none yet)
 }
 
+// Option customizes a Router
+type Option func(*Router)
+
 // routeHandler manages workers for a single route
 type routeHandler struct {
-	route   Route
-	msgChan chan Message
-	workers []*worker
-	logger  *logger.Logger
+	route       Route
+	msgChan     chan Message
+	workers     []*worker
+	downsampler *downsampler // nil unless the route downsamples
+	reorderer   *reorderer   // nil unless the route reorders
+	batcher     *batcher     // nil unless the route batches inserts
+	logger      *logger.Logger
+
+	consecutiveErrors atomic.Int64      // Script errors since the last success
+	processed         atomic.Int64      // Successful transforms
+	failed            atomic.Int64      // Failed transforms
+	timeouts          atomic.Int64      // Messages over the processing timeout
+	quarantined       atomic.Bool       // Set when diverted to passthrough
+	onQuarantine      QuarantineHandler // Optional quarantine callback
+	budget            *errorBudget      // Rolling error rate (nil = no error budget)
+
+	script   atomic.Pointer[scriptVersion] // Script the workers should run
+	samples  sampleRing                    // Recent messages used to validate reloads
+	payload  *payloadGuard                 // Payload limit (nil = unlimited)
+	retained atomic.Int64                  // Retained messages skipped or stored as state
+	filter   *topicGuard                   // Route topic filter (nil = none)
+	shared   *sharedStore                  // Values shared by the route's workers
+	cache    *ttlCache                     // Values memoized by the route's workers
+	schema   *payloadValidator             // Payload JSON Schema (nil = none)
+	decoder  *payloadDecoder               // Decodes payloads in the route's format
+	records  *recordGuard                  // Cap on records per message (nil = unlimited)
+	tap      atomic.Pointer[tap]           // Mirrors the next messages (nil = not tapped)
+	tapOut   *tapOutput                    // Where tapped messages go
+	lane     *laneShare                    // Counts queued messages of a high-priority route (nil = normal)
+
+	warnDepth     int          // Queue depth that triggers a warning (0 = not monitored)
+	clearDepth    int          // Queue depth at which the warning clears
+	queueHigh     atomic.Bool  // Set while the queue is above its high-water mark
+	queueWarnings atomic.Int64 // Times the high-water mark was crossed
+
+	droppedColumns atomic.Int64 // Undeclared columns dropped under the "drop" unknown columns policy
+
+	publisher *atomic.Pointer[ScriptPublisher] // Publishes for mqtt_publish (nil = accept without publishing)
+	published atomic.Int64                     // Messages the script published
+
+	storedErrors atomic.Int64 // Failed on_stored calls
+	storedLog    logThrottle
+
+	timing transformTimer // Transform durations
+
+	drift         atomic.Pointer[schemaDrift] // Set while paused for schema drift
+	inspector     SchemaInspector             // Re-validates the drifted table (nil = none)
+	driftInterval time.Duration               // How often the drifted table is re-validated
+	ctx           context.Context             // Router lifetime
+	bg            *sync.WaitGroup             // Router background goroutines
 }
 
 // worker processes messages for a route
@@ -65,7 +204,43 @@ type worker struct {
 	storage Storage
 	logger  *logger.Logger
 	ctx     context.Context
-	table   string // Default table from route config
+	table   string   // Default table from route config
+	records []Record // Scratch slice reused across messages
+
+	deviceID *device.Expr     // Device-id expression (nil = not tracked)
+	devices  *device.Registry // Registry updated for every message
+	gaps     *gap.Detector    // Gap detection updated for every message (nil = disabled)
+	gapRoute string           // Route filter devices are tracked under
+	gapAfter time.Duration    // The route's gap_after
+
+	timestamps   *timestampParser   // Resolves device timestamps (nil = arrival time)
+	floatNumbers bool               // Decode JSON numbers as float64 only
+	sinks        map[string]Storage // Named sinks, wrapped in the route's stages
+	sink         string             // Sink records that don't set one go to (empty = storage)
+	columnCase   string             // Column name case policy (empty = preserve)
+	dropUnknown  bool               // Drop columns the table schema doesn't declare instead of failing the record
+	tags         map[string]string  // Route's static tag columns
+	provenance   *Provenance        // Provenance columns added to script records (nil = none)
+	script       string             // Script path written to hermod_script
+	scriptHash   string             // SHA-256 of the script the Lua state runs
+
+	handler     *routeHandler       // Owning route (nil in standalone tests)
+	validator   *payloadValidator   // Payload JSON Schema (nil = none)
+	decoder     *payloadDecoder     // Payload format (nil = lenient JSON)
+	maxRecords  *recordGuard        // Cap on records per message (nil = unlimited)
+	passthrough *passthroughHandler // Used while the route is quarantined
+
+	setup   []func(*lua.LState) // Applied to the Lua state when the script is reloaded
+	version int64               // Script version the Lua state runs
+	proto   *lua.FunctionProto  // Script the Lua state runs
+
+	timeout      time.Duration // Processing timeout per message (0 = no limit)
+	recycleAfter int           // Messages between Lua state replacements (0 = never)
+	transforms   int           // Messages transformed by the current Lua state
+	before       func()        // Called before every transform (nil = none)
+
+	lane  *laneShare    // Told when a high-priority message is processed (nil = normal route)
+	yield *priorityLane // Waited on before taking a message (nil = never)
 }
 
 // Storage interface for database operations
@@ -77,7 +252,7 @@ type Storage interface {
 var validIdentifier = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
 
 // New creates a new router with the given routes
-func New(ctx context.Context, routes []Route, storage Storage, log *logger.Logger) (*Router, error) {
+func New(ctx context.Context, routes []Route, storage Storage, log *logger.Logger, opts ...Option) (*Router, error) {
 	if log == nil {
 		log = logger.New(logger.INFO)
 	}
@@ -88,9 +263,61 @@ func New(ctx context.Context, routes []Route, storage Storage, log *logger.Logge
 		routes:      make([]*routeHandler, 0, len(routes)),
 		passthrough: newPassthroughHandler(storage, log),
 		logger:      log,
+		tapOut:      &tapOutput{},
 		ctx:         routeCtx,
 		cancel:      cancel,
 	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	if err := r.watermarks.Validate(); err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := r.payloadLimit.Validate(); err != nil {
+		cancel()
+		return nil, err
+	}
+	if err := r.budget.Validate(); err != nil {
+		cancel()
+		return nil, err
+	}
+	if r.scriptTimeout < 0 {
+		cancel()
+		return nil, fmt.Errorf("script timeout must not be negative")
+	}
+	r.ctx = withScriptTimeout(r.ctx, r.scriptTimeout)
+	if err := validateColumnCase(r.columnCase); err != nil {
+		cancel()
+		return nil, err
+	}
+	r.oversize = newPayloadGuard(r.payloadLimit, "passthrough", log)
+	deny, err := newTopicGuard(r.topicFilter, "Router", log)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	r.deny = deny
+	rewriter, err := newTopicRewriter(r.rewriteRules)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	r.rewriter = rewriter
+	for name := range r.sinks {
+		if name == "" {
+			cancel()
+			return nil, fmt.Errorf("sink name must not be empty")
+		}
+	}
+
+	// Give high-priority routes a lane ahead of the others
+	for _, route := range routes {
+		if route.Priority == PriorityHigh {
+			r.lane = newPriorityLane()
+			break
+		}
+	}
 
 	// Initialize route handlers
 	for _, route := range routes {
@@ -102,6 +329,24 @@ func New(ctx context.Context, routes []Route, storage Storage, log *logger.Logge
 		r.routes = append(r.routes, handler)
 	}
 
+	filters := make([]string, len(r.routes))
+	for i, handler := range r.routes {
+		filters[i] = handler.route.Filter
+	}
+	r.trie = newTopicTrie(filters)
+
+	if r.watchInterval > 0 {
+		r.wg.Add(1)
+		go r.watchScripts()
+	}
+
+	// Queue messages saved by the previous shutdown
+	if r.spoolDir != "" {
+		if err := r.restoreQueues(); err != nil {
+			r.logger.Errorf("Failed to restore queued messages: %v", err)
+		}
+	}
+
 	return r, nil
 }
 
@@ -114,31 +359,262 @@ func (r *Router) newRouteHandler(route Route, storage Storage) (*routeHandler, e
 	if route.QueueSize <= 0 {
 		route.QueueSize = 100
 	}
+	if route.Script == "" {
+		table, source := passthroughTable(route)
+		route.Table = table
+		r.logger.Infof("Route %s has no script: passthrough records go to %s (%s)", route.Filter, table, source)
+	}
 	if route.Table == "" {
 		route.Table = "iot_data"
 	}
 
+	if err := validateFilter(route.Filter); err != nil {
+		return nil, err
+	}
+
 	// Validate table name
 	if !validIdentifier.MatchString(route.Table) {
 		return nil, fmt.Errorf("invalid table name: %s", route.Table)
 	}
 
+	if route.ErrorBudget < 0 || route.ErrorBudget >= 100 {
+		return nil, fmt.Errorf("error budget percent must be between 0 and 100")
+	}
+	if _, ok := r.sinks[route.Sink]; route.Sink != "" && !ok {
+		return nil, fmt.Errorf("route %s: unknown sink %q", route.Filter, route.Sink)
+	}
+
 	handler := &routeHandler{
-		route:   route,
-		msgChan: make(chan Message, route.QueueSize),
-		workers: make([]*worker, route.Workers),
-		logger:  r.logger,
+		route:        route,
+		msgChan:      make(chan Message, route.QueueSize),
+		workers:      make([]*worker, route.Workers),
+		logger:       r.logger,
+		onQuarantine: r.onQuarantine,
+		budget:       newErrorBudget(r.budget, route.ErrorBudget, route.Filter, r.logger, r.onBudget),
+		shared:       newSharedStore(),
+		cache:        newTTLCache(),
+		tapOut:       r.tapOut,
+		publisher:    &r.publisher,
+
+		inspector:     r.inspector,
+		driftInterval: r.driftInterval,
+		ctx:           r.ctx,
+		bg:            &r.wg,
+	}
+	if handler.driftInterval <= 0 {
+		handler.driftInterval = defaultDriftInterval
+	}
+	handler.setWatermarks(r.watermarks)
+	handler.timing.threshold = r.slowTransform
+
+	if err := validatePriority(route.Priority); err != nil {
+		return nil, err
+	}
+	if route.Priority == PriorityHigh {
+		handler.lane = newLaneShare(r.lane)
+	}
+
+	if err := validateRetained(route); err != nil {
+		return nil, err
+	}
+	if err := validateUnknownColumns(route.UnknownColumns); err != nil {
+		return nil, err
+	}
+	if err := validateTags(route.Tags); err != nil {
+		return nil, err
+	}
+	if route.TopicFilter != nil {
+		filter, err := newTopicGuard(*route.TopicFilter, "Route "+route.Filter, r.logger)
+		if err != nil {
+			return nil, err
+		}
+		handler.filter = filter
+	}
+
+	// Resolve the payload limit
+	limit := r.payloadLimit
+	if route.PayloadLimit != nil {
+		if err := route.PayloadLimit.Validate(); err != nil {
+			return nil, err
+		}
+		limit = *route.PayloadLimit
+	}
+	handler.payload = newPayloadGuard(limit, "Route "+route.Filter, r.logger)
+
+	// Decode payloads in the route's format
+	if err := validatePayloadFormat(route.PayloadFormat); err != nil {
+		return nil, err
+	}
+	if route.PayloadSchema != "" && (route.PayloadFormat == FormatText || route.PayloadFormat == FormatBinary) {
+		return nil, fmt.Errorf("payload_schema requires a structured payload format, not %s", route.PayloadFormat)
+	}
+	if err := validatePayloadEncoding(route.PayloadEncoding, route.PayloadFormat); err != nil {
+		return nil, err
+	}
+	handler.decoder = newPayloadDecoder(route.PayloadFormat, r.floatNumbers, "Route "+route.Filter, r.logger)
+
+	// Rejected messages go to the reject table, skipping the route's stages
+	dlq, err := newDeadLetter(route, r.passthrough)
+	if err != nil {
+		return nil, err
+	}
+	validator, err := newPayloadValidator(route, dlq, r.logger)
+	if err != nil {
+		return nil, err
+	}
+	handler.schema = validator
+	if handler.records, err = newRecordGuard(route, dlq, r.logger); err != nil {
+		return nil, err
+	}
+
+	// Parse the device-id expression
+	var deviceID *device.Expr
+	if route.DeviceID != "" {
+		if r.devices == nil {
+			return nil, fmt.Errorf("device_id requires the device registry")
+		}
+		expr, err := device.ParseExpr(route.DeviceID)
+		if err != nil {
+			return nil, err
+		}
+		deviceID = expr
+	}
+	if route.GapAfter < 0 {
+		return nil, fmt.Errorf("gap_after must not be negative")
+	}
+	if route.GapAfter > 0 && deviceID == nil {
+		return nil, fmt.Errorf("gap_after requires device_id")
+	}
+
+	// Write records into per-period tables; <table>_latest keeps the base name
+	if err := validateTableSuffix(route.TableSuffix); err != nil {
+		return nil, err
+	}
+	if route.TableSuffix != "" {
+		storage = newTableSuffixer(route.TableSuffix, r.tableCreator, storage)
+	}
+
+	// Write records through to <table>_latest as they are stored
+	if route.LatestKey != "" {
+		if r.latestWriter == nil {
+			return nil, fmt.Errorf("latest_key requires a latest-table writer")
+		}
+		if !validIdentifier.MatchString(route.LatestKey) {
+			return nil, fmt.Errorf("invalid latest_key column: %s", route.LatestKey)
+		}
+		storage = &latestTable{key: route.LatestKey, writer: r.latestWriter, next: storage}
 	}
 
-	// Start workers
+	// Collect inserts into per-table batches written off the worker path
+	if route.Batch != nil {
+		if err := route.Batch.Validate(); err != nil {
+			return nil, err
+		}
+		handler.batcher = newBatcher(*route.Batch, route.Filter, storage, r.onBatch, r.logger)
+		handler.batcher.onDrift = handler.checkDrift
+		handler.batcher.start()
+		storage = handler.batcher
+	}
+
+	// Insert the aggregation stage in front of storage
+	if route.Downsample != nil {
+		if err := route.Downsample.Validate(); err != nil {
+			return nil, err
+		}
+		handler.downsampler = newDownsampler(*route.Downsample, storage, r.logger)
+		handler.downsampler.start(r.ctx)
+		storage = handler.downsampler
+	}
+
+	// Buffer and sort records ahead of aggregation and storage
+	if route.Reorder < 0 {
+		return nil, fmt.Errorf("reorder window must not be negative")
+	}
+	if route.Reorder > 0 {
+		handler.reorderer = newReorderer(route.Reorder, storage, r.logger)
+		handler.reorderer.start(r.ctx)
+		storage = handler.reorderer
+	}
+
+	// Mask columns before anything else sees the record
+	if route.Mask != nil {
+		if err := route.Mask.Validate(); err != nil {
+			return nil, err
+		}
+		storage = newMasker(*route.Mask, storage)
+	}
+
+	// Normalize record times to UTC ahead of every other stage
+	var timestamps *timestampParser
+	if route.Timestamp != nil {
+		if err := route.Timestamp.Validate(); err != nil {
+			return nil, err
+		}
+		timestamps = newTimestampParser(*route.Timestamp)
+		storage = &timeNormalizer{parser: timestamps, next: storage}
+	}
+	sinks := r.routeSinks(route, timestamps)
+
+	if route.LuaRecycle < 0 {
+		return nil, fmt.Errorf("lua_recycle must not be negative")
+	}
+	if route.ProcessingTimeout < 0 {
+		return nil, fmt.Errorf("processing_timeout must not be negative")
+	}
+
+	// Compile the script once; every worker runs the same prototype
+	proto, err := compileScript(route.Script)
+	if err != nil {
+		return nil, err
+	}
+	if proto != nil {
+		v := &scriptVersion{proto: proto, hash: hashScript(route.Script)}
+		if info, err := os.Stat(route.Script); err == nil {
+			v.modTime = info.ModTime()
+		}
+		handler.script.Store(v)
+	}
+
+	// Start workers; router setup runs after "shared" so WithLuaFunc can override it
+	setup := append([]func(*lua.LState){handler.shared.register, handler.cache.register, handler.registerStats, handler.registerPublish}, r.luaSetup...)
 	for i := 0; i < route.Workers; i++ {
-		w, err := newWorker(i, route.Script, route.Table, handler.msgChan, storage, r.ctx, r.logger)
+		w, err := newScriptWorker(i, proto, route.Table, handler.msgChan, storage, r.ctx, r.logger, setup...)
 		if err != nil {
 			return nil, fmt.Errorf("failed to create worker %d: %w", i, err)
 		}
+		w.deviceID = deviceID
+		w.devices = r.devices
+		if route.GapAfter > 0 {
+			w.gaps, w.gapRoute, w.gapAfter = r.gaps, route.Filter, route.GapAfter
+		}
+		w.timestamps = timestamps
+		w.floatNumbers = r.floatNumbers
+		w.columnCase = r.columnCase
+		w.dropUnknown = route.UnknownColumns == UnknownColumnsDrop
+		w.tags = route.Tags
+		w.provenance = r.provenance
+		w.script = route.Script
+		if v := handler.script.Load(); v != nil {
+			w.scriptHash = v.hash
+		}
+		w.sinks = sinks
+		w.sink = route.Sink
+		w.handler = handler
+		w.passthrough = r.passthrough
+		w.validator = validator
+		w.decoder = handler.decoder
+		w.maxRecords = handler.records
+		w.recycleAfter = route.LuaRecycle
+		w.timeout = route.ProcessingTimeout
+		w.before = r.transformHook
+		if handler.lane != nil {
+			w.lane = handler.lane
+		} else {
+			w.yield = r.lane
+		}
 		handler.workers[i] = w
-		r.wg.Add(1)
-		go w.run(&r.wg)
+		r.workers.Add(1)
+		go w.run(&r.workers)
 	}
 
 	r.logger.Infof("Route initialized: filter=%s, script=%s, workers=%d, queue=%d, table=%s",
@@ -147,8 +623,19 @@ func (r *Router) newRouteHandler(route Route, storage Storage) (*routeHandler, e
 	return handler, nil
 }
 
-// newWorker creates a new worker with its own Lua state
-func newWorker(id int, scriptPath string, defaultTable string, msgChan chan Message, storage Storage, ctx context.Context, log *logger.Logger) (*worker, error) {
+// newWorker compiles scriptPath and creates a worker running it.
+// setup functions run on the Lua state before the script is loaded.
+func newWorker(id int, scriptPath string, defaultTable string, msgChan chan Message, storage Storage, ctx context.Context, log *logger.Logger, setup ...func(*lua.LState)) (*worker, error) {
+	proto, err := compileScript(scriptPath)
+	if err != nil {
+		return nil, err
+	}
+	return newScriptWorker(id, proto, defaultTable, msgChan, storage, ctx, log, setup...)
+}
+
+// newScriptWorker creates a worker with its own Lua state running a
+// compiled script shared with the route's other workers (nil = passthrough).
+func newScriptWorker(id int, proto *lua.FunctionProto, defaultTable string, msgChan chan Message, storage Storage, ctx context.Context, log *logger.Logger, setup ...func(*lua.LState)) (*worker, error) {
 	w := &worker{
 		id:      id,
 		msgChan: msgChan,
@@ -159,34 +646,73 @@ func newWorker(id int, scriptPath string, defaultTable string, msgChan chan Mess
 	}
 
 	// Only create Lua state if script is provided
-	if scriptPath != "" {
-		L := lua.NewState()
-		if err := L.DoFile(scriptPath); err != nil {
-			L.Close()
-			return nil, fmt.Errorf("failed to load Lua script: %w", err)
-		}
-		w.state = L
-
-		// Load schema for validation (if exists)
-		s, err := loadSchemaFromState(L)
+	if proto != nil {
+		L, sch, err := newScriptState(ctx, proto, setup)
 		if err != nil {
-			L.Close()
-			return nil, fmt.Errorf("failed to load schema: %w", err)
+			return nil, err
 		}
-		w.schema = s
+		w.state = L
+		w.schema = sch
+		w.setup = setup
+		w.proto = proto
 	}
 
 	return w, nil
 }
 
+// newScriptState creates a Lua state, runs the compiled script in it and
+// reads the script's schema
+func newScriptState(ctx context.Context, proto *lua.FunctionProto, setup []func(*lua.LState)) (*lua.LState, *schema.Schema, error) {
+	L := newWorkerState()
+	registerBuiltins(L)
+	hermodlua.ApplyRegistered(L)
+	for _, fn := range setup {
+		fn(L)
+	}
+	if err := callScript(ctx, L, lua.P{Fn: L.NewFunctionFromProto(proto), NRet: lua.MultRet, Protect: true}); err != nil {
+		L.Close()
+		return nil, nil, fmt.Errorf("failed to load Lua script: %w", err)
+	}
+	if err := checkTransform(L, proto.SourceName); err != nil {
+		L.Close()
+		return nil, nil, err
+	}
+
+	// Load schema for validation (if exists)
+	s, err := loadSchemaFromState(L)
+	if err != nil {
+		L.Close()
+		return nil, nil, fmt.Errorf("failed to load schema: %w", err)
+	}
+	return L, s, nil
+}
+
 // run is the worker main loop
 func (w *worker) run(wg *sync.WaitGroup) {
 	defer wg.Done()
-	if w.state != nil {
-		defer w.state.Close()
-	}
+	defer func() {
+		// The state may have been replaced by a script reload
+		if w.state != nil {
+			w.state.Close()
+		}
+	}()
 
 	for {
+		// Stop before taking another message once the router closes, so
+		// queued messages stay in the channel for the spool
+		if w.ctx.Err() != nil {
+			return
+		}
+		if w.yield != nil {
+			select {
+			case <-w.ctx.Done():
+				return
+			case <-w.yield.cleared():
+			}
+		}
+		if !w.waitDrift() {
+			return
+		}
 		select {
 		case <-w.ctx.Done():
 			return
@@ -194,49 +720,136 @@ func (w *worker) run(wg *sync.WaitGroup) {
 			if !ok {
 				return
 			}
-			if err := w.process(msg); err != nil {
+			err := w.processAcked(msg)
+			if err != nil {
 				w.logger.Errorf("Worker %d failed to process message from %s: %v", w.id, msg.Topic, err)
 			}
+			if w.handler != nil {
+				w.handler.budget.observe(err != nil)
+			}
+			w.lane.done()
 		}
 	}
 }
 
 // process handles a single message
 func (w *worker) process(msg Message) error {
+	// Decode the payload once for every consumer below
+	doc := w.decode(msg)
+
+	// Use the device's own timestamp when the route reads one; gaps are
+	// measured in arrival time so device clocks can't open or hide them
+	arrived := msg.Time
+	if w.timestamps != nil {
+		msg.Time = w.timestamps.messageTime(msg, doc)
+	}
+
+	// Track the sending device
+	if w.deviceID != nil {
+		if id, ok := w.deviceID.EvalParsed(msg.Topic, doc.value); ok {
+			w.devices.Observe(id, msg.Topic, msg.Time)
+			if w.gaps != nil {
+				w.gaps.Observe(w.gapRoute, id, w.gapAfter, arrived)
+			}
+		}
+	}
+
+	// Reject payloads that don't match the route's schema
+	if ok, err := w.validator.check(msg, doc); !ok {
+		return err
+	}
+
+	// Quarantined routes bypass the script
+	if w.handler != nil && w.handler.quarantined.Load() {
+		return w.passthrough.handle(msg, doc)
+	}
+
 	// If no Lua script, passthrough
 	if w.state == nil {
-		record := buildPassthroughRecord(msg)
-		table := w.table
-		if table == "" || table == "iot_data" {
-			table = "iot_raw"
+		record := w.passthrough.record(msg, doc)
+		store, err := w.recordStorage(Record{Table: w.table})
+		if err != nil {
+			return err
 		}
-		return w.storage.InsertIntoTable(w.ctx, table, record)
+		err = store.InsertIntoTable(w.ctx, w.table, record)
+		w.handler.checkDrift(w.table, record, err)
+		return err
+	}
+
+	// Pick up a reloaded script before running the transform
+	if w.handler != nil {
+		if v := w.handler.script.Load(); v != nil && v.version != w.version {
+			w.reload(v)
+		}
+	}
+
+	// Start over with a fresh Lua state to release what the old one accumulated
+	if w.recycleAfter > 0 {
+		if w.transforms >= w.recycleAfter {
+			w.recycle()
+		}
+		w.transforms++
+	}
+
+	if w.before != nil {
+		w.before()
 	}
 
 	// Execute Lua transform
-	records, err := w.executeTransform(msg)
+	start := time.Now()
+	records, err := w.executeTransform(msg, doc)
+	w.timeTransform(msg, time.Since(start))
+	if w.handler != nil {
+		w.handler.tapped(msg, records, err)
+	}
 	if err != nil {
-		return fmt.Errorf("transform failed: %w", err)
+		if w.handler != nil {
+			w.handler.transformFailed(err)
+		}
+		return fmt.Errorf("%w: %w", errs.ErrTransform, err)
+	}
+	if w.handler != nil {
+		w.handler.transformSucceeded()
+		w.handler.samples.add(msg)
+	}
+
+	// Store nothing from a transform that produced too many records
+	if ok, err := w.maxRecords.check(msg, doc, len(records)); !ok {
+		return err
 	}
 
 	// Insert records into database
 	for _, rec := range records {
-		// Use default table if not specified
+		// Use default table and sink if not specified
 		table := rec.Table
 		if table == "" {
 			table = w.table
 		}
+		if rec.Sink == "" {
+			rec.Sink = w.sink
+		}
 
-		// Validate against schema if available
-		if w.schema != nil {
-			if tableSchema, ok := w.schema.Tables[table]; ok {
-				if err := tableSchema.ValidateRecord(rec.Columns); err != nil {
-					return fmt.Errorf("schema validation failed for table %s: %w", table, err)
-				}
-			}
+		// Add tags and apply the column case policy, then validate against schema if available
+		w.addTags(rec.Columns)
+		if err := w.applyColumnCase(&rec, table); err != nil {
+			return err
+		}
+		if err := w.validateRecord(table, rec.Columns); err != nil {
+			return err
 		}
+		w.addProvenance(rec.Columns, msg)
 
-		if err := w.storage.InsertIntoTable(w.ctx, table, rec.Columns); err != nil {
+		store, err := w.recordStorage(rec)
+		if err != nil {
+			return err
+		}
+		err = store.InsertIntoTable(w.ctx, table, rec.Columns)
+		w.stored(table, rec, err)
+		if err != nil {
+			w.handler.checkDrift(table, rec.Columns, err)
+			if rec.Sink != "" {
+				return fmt.Errorf("failed to insert into %s (sink %s): %w", table, rec.Sink, err)
+			}
 			return fmt.Errorf("failed to insert into %s: %w", table, err)
 		}
 	}
@@ -244,8 +857,25 @@ func (w *worker) process(msg Message) error {
 	return nil
 }
 
+// validateRecord checks a record against the script's schema for table, if declared
+func (w *worker) validateRecord(table string, columns map[string]interface{}) error {
+	if w.schema == nil {
+		return nil
+	}
+	if tableSchema, ok := w.schema.Tables[table]; ok {
+		if w.dropUnknown {
+			w.dropUndeclared(tableSchema, columns)
+		}
+		err := tableSchema.ValidateRecord(columns)
+		if err != nil && !(w.columnCase == ColumnCaseLowercase && declaredFolded(tableSchema, columns)) {
+			return fmt.Errorf("%w for table %s: %w", errs.ErrSchemaViolation, table, err)
+		}
+	}
+	return nil
+}
+
 // executeTransform runs the Lua transform function
-func (w *worker) executeTransform(msg Message) ([]Record, error) {
+func (w *worker) executeTransform(msg Message, doc parsedJSON) ([]Record, error) {
 	// Get transform function
 	fn := w.state.GetGlobal("transform")
 	if fn.Type() != lua.LTFunction {
@@ -253,21 +883,41 @@ func (w *worker) executeTransform(msg Message) ([]Record, error) {
 	}
 
 	// Build input message table
-	msgTable := w.state.NewTable()
+	msgTable := w.state.CreateTable(0, 9) // Presized: avoids rehashing as fields are added
 	msgTable.RawSetString("topic", lua.LString(msg.Topic))
 	msgTable.RawSetString("payload", lua.LString(string(msg.Payload)))
 	msgTable.RawSetString("ts", lua.LString(msg.Time.Format(time.RFC3339Nano)))
-
-	// Try to parse payload as JSON
-	var jsonData interface{}
-	if err := json.Unmarshal(msg.Payload, &jsonData); err == nil {
-		msgTable.RawSetString("json", jsonToLTable(w.state, jsonData))
+	msgTable.RawSetString("qos", lua.LNumber(msg.QoS))
+	msgTable.RawSetString("retain", lua.LBool(msg.Retain))
+	msgTable.RawSetString("properties", luaProperties(w.state, msg.Properties))
+
+	// Topic split on '/' (1-based, e.g. "ruuvi/abc" -> {"ruuvi", "abc"})
+	levels := w.state.CreateTable(strings.Count(msg.Topic, "/")+1, 0)
+	rest, more := msg.Topic, true
+	for i := 1; more; i++ {
+		var level string
+		level, rest, more = strings.Cut(rest, "/")
+		levels.RawSetInt(i, lua.LString(level))
+	}
+	msgTable.RawSetString("topic_levels", levels)
+
+	// Decoded payload in the route's format (nil when it can't be decoded);
+	// msg.json is the same document for JSON payloads
+	var data lua.LValue = lua.LNil
+	if w.decoder != nil {
+		data = w.decoder.data(w.state, msg.Payload, doc)
+	} else if doc.ok {
+		data = jsonToLTable(w.state, doc.value)
+	}
+	msgTable.RawSetString("data", data)
+	if w.decoder == nil || w.decoder.format == "" || w.decoder.format == FormatJSON {
+		msgTable.RawSetString("json", data)
 	} else {
 		msgTable.RawSetString("json", lua.LNil)
 	}
 
 	// Call transform function
-	if err := w.state.CallByParam(lua.P{
+	if err := callScript(w.ctx, w.state, lua.P{
 		Fn:      fn,
 		NRet:    1,
 		Protect: true,
@@ -287,9 +937,11 @@ func (w *worker) executeTransform(msg Message) ([]Record, error) {
 	return w.parseRecords(result.(*lua.LTable))
 }
 
-// parseRecords converts Lua table array to []Record
+// parseRecords converts Lua table array to []Record.
+// The returned slice is reused by the next call; Columns maps are not.
 func (w *worker) parseRecords(tbl *lua.LTable) ([]Record, error) {
-	var records []Record
+	records := w.records[:0]
+	defer func() { w.records = records[:0] }()
 
 	// Check if it's an array
 	maxN := tbl.MaxN()
@@ -316,6 +968,15 @@ func (w *worker) parseRecords(tbl *lua.LTable) ([]Record, error) {
 			}
 		}
 
+		// Extract the target sink
+		if sinkLV := recTable.RawGetString("sink"); sinkLV != lua.LNil {
+			sinkStr, ok := sinkLV.(lua.LString)
+			if !ok {
+				return nil, fmt.Errorf("record %d 'sink' must be a string", i)
+			}
+			rec.Sink = string(sinkStr)
+		}
+
 		// Extract columns
 		columnsLV := recTable.RawGetString("columns")
 		if columnsLV.Type() != lua.LTTable {
@@ -341,45 +1002,145 @@ func (w *worker) parseRecords(tbl *lua.LTable) ([]Record, error) {
 }
 
 // Dispatch routes an incoming message to the appropriate handler
-func (r *Router) Dispatch(msg Message) error {
+func (r *Router) Dispatch(msg Message) (err error) {
+	// A message not queued for a worker is done with once Dispatch returns
+	queued := false
+	if msg.Ack != nil {
+		defer func() {
+			if queued || r.ctx.Err() != nil {
+				return
+			}
+			if !redeliverable(err) {
+				msg.Ack()
+			} else if msg.Nack != nil {
+				msg.Nack()
+			}
+		}()
+	}
+
+	msg.Topic = r.rewriter.rewrite(msg.Topic)
+	if !r.deny.allow(msg) {
+		return nil
+	}
+
 	// Find first matching route
-	for _, handler := range r.routes {
-		if topicMatches(handler.route.Filter, msg.Topic) {
-			select {
-			case handler.msgChan <- msg:
+	if idx := r.trie.match(msg.Topic); idx >= 0 {
+		handler := r.routes[idx]
+		if !handler.filter.allow(msg) {
+			return nil
+		}
+		if !handler.payload.check(&msg) {
+			return nil
+		}
+		msg.Payload = transcode(handler.route.PayloadEncoding, msg.Payload)
+		if handled, err := r.handleRetained(handler, msg); handled {
+			return err
+		}
+		handler.lane.queued()
+		select {
+		case handler.msgChan <- msg:
+			queued = true
+			handler.checkQueue()
+			if r.logger.Enabled(logger.DEBUG) {
 				r.logger.Debugf("Message from %s dispatched to route %s", msg.Topic, handler.route.Filter)
-				return nil
-			case <-r.ctx.Done():
-				return fmt.Errorf("router context cancelled")
-			default:
-				return fmt.Errorf("route %s queue full", handler.route.Filter)
 			}
+			return nil
+		case <-r.ctx.Done():
+			handler.lane.done()
+			return fmt.Errorf("router context cancelled: %w", context.Canceled)
+		default:
+			handler.lane.done()
+			handler.checkQueue()
+			return fmt.Errorf("route %s: %w", handler.route.Filter, errs.ErrQueueFull)
 		}
 	}
 
 	// No route matched, use passthrough
-	r.logger.Debugf("No route matched for %s, using passthrough", msg.Topic)
-	return r.passthrough.handle(msg)
+	if !r.oversize.check(&msg) {
+		return nil
+	}
+	if r.logger.Enabled(logger.DEBUG) {
+		r.logger.Debugf("No route matched for %s, using passthrough", msg.Topic)
+	}
+	return r.passthrough.handle(msg, parseJSON(msg.Payload, r.floatNumbers))
+}
+
+// Drain closes the route queues and waits until the workers have processed
+// every queued message, for finite inputs such as replays. Dispatch must not
+// be called afterwards; Close still has to be called to flush the stages.
+func (r *Router) Drain() {
+	r.closeQueues.Do(func() {
+		for _, handler := range r.routes {
+			close(handler.msgChan)
+		}
+	})
+	r.workers.Wait()
 }
 
 // Close shuts down the router and all workers
 func (r *Router) Close() {
 	r.cancel()
-	
+
 	// Close all route channels
-	for _, handler := range r.routes {
-		close(handler.msgChan)
-	}
-	
+	r.closeQueues.Do(func() {
+		for _, handler := range r.routes {
+			close(handler.msgChan)
+		}
+	})
+
 	// Wait for all workers to finish
+	r.workers.Wait()
 	r.wg.Wait()
+
+	// Save messages the workers didn't get to
+	if r.spoolDir != "" {
+		if err := r.spoolQueues(); err != nil {
+			r.logger.Errorf("Failed to save queued messages: %v", err)
+		}
+	}
+
+	// Release held records, write any partially filled downsample buckets,
+	// then drain pending batches
+	for _, handler := range r.routes {
+		if handler.reorderer != nil {
+			handler.reorderer.close(context.Background())
+		}
+		if handler.downsampler != nil {
+			handler.downsampler.close(context.Background())
+		}
+		if handler.batcher != nil {
+			handler.batcher.close()
+		}
+	}
 	r.logger.Info("Router closed")
 }
 
+// decode decodes msg's payload in the route's format
+func (w *worker) decode(msg Message) parsedJSON {
+	if w.decoder == nil {
+		return parseJSON(msg.Payload, w.floatNumbers)
+	}
+	return w.decoder.decode(msg)
+}
+
+// passthroughTable resolves the table a route without a script stores its
+// passthrough records in, and which setting it came from: PassthroughTable,
+// then an explicitly set Table, then iot_raw like unmatched messages
+func passthroughTable(route Route) (table, source string) {
+	switch {
+	case route.PassthroughTable != "":
+		return route.PassthroughTable, "passthrough_table"
+	case route.Table != "":
+		return route.Table, "table"
+	}
+	return "iot_raw", "default"
+}
+
 // passthroughHandler handles messages that don't match any route
 type passthroughHandler struct {
-	storage Storage
-	logger  *logger.Logger
+	storage  Storage
+	logger   *logger.Logger
+	checksum bool // Add the payload's SHA-256 to passthrough records
 }
 
 func newPassthroughHandler(storage Storage, log *logger.Logger) *passthroughHandler {
@@ -389,17 +1150,31 @@ func newPassthroughHandler(storage Storage, log *logger.Logger) *passthroughHand
 	}
 }
 
-func (h *passthroughHandler) handle(msg Message) error {
-	record := buildPassthroughRecord(msg)
+func (h *passthroughHandler) handle(msg Message, doc parsedJSON) error {
+	record := h.record(msg, doc)
 	if err := h.storage.InsertIntoTable(context.Background(), "iot_raw", record); err != nil {
 		return fmt.Errorf("passthrough insert failed: %w", err)
 	}
-	h.logger.Debugf("Passthrough: stored message from %s", msg.Topic)
+	if h.logger.Enabled(logger.DEBUG) {
+		h.logger.Debugf("Passthrough: stored message from %s", msg.Topic)
+	}
 	return nil
 }
 
+// parsedJSON is a message payload decoded once and shared by the device
+// registry, passthrough records and the Lua transform
+type parsedJSON struct {
+	value interface{}
+	ok    bool // false when the payload isn't valid JSON
+}
+
 // buildPassthroughRecord creates the canonical passthrough record format
 func buildPassthroughRecord(msg Message) map[string]interface{} {
+	return passthroughRecord(msg, parseJSON(msg.Payload, false))
+}
+
+// passthroughRecord creates the passthrough record from an already parsed payload
+func passthroughRecord(msg Message, doc parsedJSON) map[string]interface{} {
 	record := map[string]interface{}{
 		"time":   msg.Time,
 		"topic":  msg.Topic,
@@ -409,15 +1184,15 @@ func buildPassthroughRecord(msg Message) map[string]interface{} {
 	}
 
 	// Add json field only if payload is valid JSON
-	var jsonData interface{}
-	if err := json.Unmarshal(msg.Payload, &jsonData); err == nil {
-		record["json"] = jsonData
+	if doc.ok {
+		record["json"] = doc.value
 	}
 
 	return record
 }
 
-// topicMatches returns true if a subscription filter matches a concrete topic
+// topicMatches returns true if a subscription filter matches a concrete topic.
+// Dispatch uses topicTrie; this is the reference implementation it must agree with.
 // Supports MQTT wildcards: '+' (single level) and '#' (multi level, only last)
 func topicMatches(filter, topic string) bool {
 	if filter == topic || filter == "#" {
@@ -451,13 +1226,13 @@ func topicMatches(filter, topic string) bool {
 func jsonToLTable(L *lua.LState, data interface{}) lua.LValue {
 	switch v := data.(type) {
 	case map[string]interface{}:
-		tbl := L.NewTable()
+		tbl := L.CreateTable(0, len(v))
 		for key, val := range v {
 			tbl.RawSetString(key, jsonToLTable(L, val))
 		}
 		return tbl
 	case []interface{}:
-		tbl := L.NewTable()
+		tbl := L.CreateTable(len(v), 0)
 		for i, val := range v {
 			tbl.RawSetInt(i+1, jsonToLTable(L, val))
 		}
@@ -466,6 +1241,8 @@ func jsonToLTable(L *lua.LState, data interface{}) lua.LValue {
 		return lua.LString(v)
 	case float64:
 		return lua.LNumber(v)
+	case int64:
+		return int64ToLua(v)
 	case bool:
 		return lua.LBool(v)
 	case nil:
@@ -2,6 +2,7 @@ package router
 
 import (
 	"context"
+	"sync"
 	"testing"
 	"time"
 )
@@ -87,6 +88,7 @@ func TestBuildPassthroughRecord(t *testing.T) {
 
 // mockStorage for testing
 type mockStorage struct {
+	mu      sync.Mutex
 	inserts map[string][]map[string]interface{}
 }
 
@@ -97,10 +99,19 @@ func newMockStorage() *mockStorage {
 }
 
 func (m *mockStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 	m.inserts[table] = append(m.inserts[table], data)
 	return nil
 }
 
+// count returns the number of rows inserted into table (safe while workers run)
+func (m *mockStorage) count(table string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.inserts[table])
+}
+
 func TestRouterDispatch(t *testing.T) {
 	ctx := context.Background()
 	storage := newMockStorage()
@@ -137,7 +148,7 @@ func TestRouterDispatch(t *testing.T) {
 	// Give worker time to process
 	time.Sleep(100 * time.Millisecond)
 
-	if len(storage.inserts["sensor_data"]) == 0 {
+	if storage.count("sensor_data") == 0 {
 		t.Error("Expected message to be inserted into sensor_data table")
 	}
 }
@@ -171,11 +182,42 @@ func TestRouterPassthrough(t *testing.T) {
 	// Give passthrough time to process
 	time.Sleep(100 * time.Millisecond)
 
-	if len(storage.inserts["iot_raw"]) == 0 {
+	if storage.count("iot_raw") == 0 {
 		t.Error("Expected message to be inserted into iot_raw table via passthrough")
 	}
 }
 
+func TestRouterPassthroughTable(t *testing.T) {
+	storage := newMockStorage()
+	routes := []Route{
+		{Filter: "default/+"},
+		{Filter: "explicit/+", Table: "iot_data"},
+		{Filter: "override/+", Table: "iot_data", PassthroughTable: "legacy_raw"},
+		{Filter: "scripted/+", Script: writeStoredScript(t), Table: "readings", PassthroughTable: "ignored"},
+	}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	for _, topic := range []string{"default/a", "explicit/a", "override/a", "scripted/a"} {
+		if err := r.Dispatch(Message{Topic: topic, Payload: []byte(`{"id": "a"}`), Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	r.Drain()
+
+	for table, want := range map[string]int{"iot_raw": 1, "iot_data": 1, "legacy_raw": 1, "readings": 1, "ignored": 0} {
+		if got := storage.count(table); got != want {
+			t.Errorf("Expected %d records in %s, got %d", want, table, got)
+		}
+	}
+
+	routes = []Route{{Filter: "bad/+", PassthroughTable: "raw-data"}}
+	if _, err := New(context.Background(), routes, storage, nil); err == nil {
+		t.Error("Expected an invalid passthrough_table to be rejected")
+	}
+}
+
 func TestValidIdentifier(t *testing.T) {
 	tests := []struct {
 		name       string
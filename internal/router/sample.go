@@ -0,0 +1,94 @@
+package router
+
+import (
+	"context"
+	"fmt"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// TransformSample runs msg through the route that would handle it, as
+// Dispatch does, but in a scratch Lua state and without storing anything.
+// It returns the route's filter and the records the message produced, with
+// default tables filled in; messages no route handles return an empty filter
+// and no records. Decode, schema and transform errors are returned as errors.
+// Each call starts with an empty shared table.
+func (r *Router) TransformSample(msg Message) (string, []Record, error) {
+	msg.Topic = r.rewriter.rewrite(msg.Topic)
+	idx := r.trie.match(msg.Topic)
+	if idx < 0 {
+		return "", nil, nil
+	}
+	h := r.routes[idx]
+	filter := h.route.Filter
+
+	msg.Payload = transcode(h.route.PayloadEncoding, msg.Payload)
+	doc, err := h.decoder.parse(msg.Payload)
+	if err != nil && h.decoder.format != "" {
+		return filter, nil, err
+	}
+	if err := h.schema.validate(doc); err != nil {
+		return filter, nil, fmt.Errorf("payload rejected by schema: %w", err)
+	}
+	if t := h.workers[0].timestamps; t != nil {
+		msg.Time = t.messageTime(msg, doc)
+	}
+
+	// Routes without a script store the passthrough record
+	v := h.script.Load()
+	if v == nil {
+		return filter, []Record{{Table: h.route.Table, Columns: r.passthrough.record(msg, doc)}}, nil
+	}
+
+	w, err := r.scratchWorker(v.proto, h.route.Table, h.decoder)
+	if err != nil {
+		return filter, nil, err
+	}
+	defer w.state.Close()
+	records, err := w.checkedTransform(msg, doc)
+	if err == nil && h.records != nil && len(records) > h.records.max {
+		return filter, nil, fmt.Errorf("transform returned %d records, limit %d", len(records), h.records.max)
+	}
+	return filter, records, err
+}
+
+// scratchWorker creates a worker with its own Lua state for running a script
+// outside the route's workers. The state gets its own shared table so the
+// route's is left alone; the caller closes the state.
+func (r *Router) scratchWorker(proto *lua.FunctionProto, table string, decoder *payloadDecoder) (*worker, error) {
+	idle := &routeHandler{}
+	setup := append([]func(*lua.LState){newSharedStore().register, newTTLCache().register, idle.registerStats, idle.registerPublish}, r.luaSetup...)
+	ctx := withScriptTimeout(context.Background(), r.scriptTimeout)
+	L, sch, err := newScriptState(ctx, proto, setup)
+	if err != nil {
+		return nil, err
+	}
+	return &worker{ctx: ctx, state: L, schema: sch, table: table, sinks: r.sinks, decoder: decoder, columnCase: r.columnCase}, nil
+}
+
+// checkedTransform runs the transform and checks every record the way
+// process does before storing it (default table, schema and sink), without
+// storing anything
+func (w *worker) checkedTransform(msg Message, doc parsedJSON) ([]Record, error) {
+	records, err := w.executeTransform(msg, doc)
+	if err != nil {
+		return nil, err
+	}
+	checked := make([]Record, 0, len(records))
+	for _, rec := range records {
+		if rec.Table == "" {
+			rec.Table = w.table
+		}
+		if err := w.applyColumnCase(&rec, rec.Table); err != nil {
+			return nil, err
+		}
+		if err := w.validateRecord(rec.Table, rec.Columns); err != nil {
+			return nil, err
+		}
+		if _, err := w.recordStorage(rec); err != nil {
+			return nil, err
+		}
+		checked = append(checked, rec)
+	}
+	return checked, nil
+}
@@ -0,0 +1,68 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTransformSample(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "count.lua")
+	scriptCode := `
+function transform(msg)
+  local n = shared.incr("seen")
+  if msg.json.fail then
+    error("boom")
+  end
+  return {{ columns = { time = msg.ts, value = msg.json.v, seen = n } }, { table = "audit", columns = { topic = msg.topic } }}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	storage := newMockStorage()
+	routes := []Route{
+		{Filter: "sensors/+", Script: scriptPath, Table: "readings"},
+		{Filter: "raw/+"},
+	}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	defer r.Close()
+
+	at := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 0; i < 2; i++ {
+		filter, records, err := r.TransformSample(Message{Topic: "sensors/a", Payload: []byte(`{"v": 2}`), Time: at})
+		if err != nil || filter != "sensors/+" || len(records) != 2 {
+			t.Fatalf("TransformSample = %q, %v, %v; want two records from sensors/+", filter, records, err)
+		}
+		// Every sample starts with an empty shared table
+		rec := records[0]
+		if rec.Table != "readings" || rec.Columns["value"] != 2.0 || rec.Columns["seen"] != 1.0 || rec.Columns["time"] != "2024-01-01T00:00:00Z" {
+			t.Errorf("record = %+v", rec)
+		}
+		if records[1].Table != "audit" {
+			t.Errorf("second record table = %s, want audit", records[1].Table)
+		}
+	}
+
+	if _, _, err := r.TransformSample(Message{Topic: "sensors/a", Payload: []byte(`{"fail": true}`), Time: at}); err == nil {
+		t.Error("Expected transform error")
+	}
+	if filter, records, err := r.TransformSample(Message{Topic: "raw/a", Payload: []byte(`{}`), Time: at}); err != nil || filter != "raw/+" || len(records) != 1 || records[0].Table != "iot_raw" {
+		t.Errorf("passthrough route = %q, %v, %v", filter, records, err)
+	}
+	if filter, records, err := r.TransformSample(Message{Topic: "other", Payload: []byte(`{}`), Time: at}); filter != "" || records != nil || err != nil {
+		t.Errorf("unrouted = %q, %v, %v; want nothing", filter, records, err)
+	}
+
+	// Nothing is stored
+	time.Sleep(20 * time.Millisecond)
+	if n := len(storage.inserts); n != 0 {
+		t.Errorf("Expected no inserts, got %v", storage.inserts)
+	}
+}
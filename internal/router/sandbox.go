@@ -0,0 +1,121 @@
+package router
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// Sandbox limits for route scripts
+const (
+	scriptCallDepth = 200      // Nested Lua calls before "stack overflow"
+	maxScriptString = 16 << 20 // Largest string string.rep may build (bytes)
+)
+
+// DefaultScriptTimeout bounds a single run of a route script: loading it,
+// or one call of transform or a hook. Runaway loops fail the message
+// instead of the worker.
+const DefaultScriptTimeout = 10 * time.Second
+
+// WithScriptTimeout sets how long a single run of a route script may take
+// (0 = DefaultScriptTimeout)
+func WithScriptTimeout(d time.Duration) Option {
+	return func(r *Router) {
+		r.scriptTimeout = d
+	}
+}
+
+// scriptTimeoutKey is the context key of the script time limit
+type scriptTimeoutKey struct{}
+
+// withScriptTimeout returns ctx carrying the script time limit d
+func withScriptTimeout(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, scriptTimeoutKey{}, d)
+}
+
+// scriptTimeoutFrom returns the script time limit carried by ctx, or
+// DefaultScriptTimeout
+func scriptTimeoutFrom(ctx context.Context) time.Duration {
+	if d, ok := ctx.Value(scriptTimeoutKey{}).(time.Duration); ok && d > 0 {
+		return d
+	}
+	return DefaultScriptTimeout
+}
+
+// sandboxLibs are the standard libraries opened for route scripts. io, debug,
+// package and channel are left out; os is reduced to its clock functions.
+var sandboxLibs = []struct {
+	name string
+	open lua.LGFunction
+}{
+	{lua.BaseLibName, lua.OpenBase},
+	{lua.TabLibName, lua.OpenTable},
+	{lua.StringLibName, lua.OpenString},
+	{lua.MathLibName, lua.OpenMath},
+	{lua.CoroutineLibName, lua.OpenCoroutine},
+	{lua.OsLibName, lua.OpenOs},
+}
+
+// sandboxRemoved are the globals and library functions that reach the file
+// system, the environment or the process
+var sandboxRemoved = map[string][]string{
+	"_G":     {"dofile", "loadfile", "require", "module"},
+	"string": {"dump"},
+	"os":     {"execute", "exit", "getenv", "remove", "rename", "setenv", "setlocale", "tmpname"},
+}
+
+// newWorkerState creates a sandboxed Lua state sized for running transforms
+func newWorkerState() *lua.LState {
+	L := lua.NewState(lua.Options{
+		RegistrySize:        workerRegistrySize,
+		RegistryMaxSize:     lua.RegistrySize,
+		CallStackSize:       scriptCallDepth,
+		MinimizeStackMemory: true,
+		SkipOpenLibs:        true,
+	})
+	for _, lib := range sandboxLibs {
+		L.Push(L.NewFunction(lib.open))
+		L.Push(lua.LString(lib.name))
+		L.Call(1, 0)
+	}
+	for lib, names := range sandboxRemoved {
+		tbl, ok := L.GetGlobal(lib).(*lua.LTable)
+		if !ok {
+			continue
+		}
+		for _, name := range names {
+			tbl.RawSetString(name, lua.LNil)
+		}
+	}
+	if str, ok := L.GetGlobal(lua.StringLibName).(*lua.LTable); ok {
+		str.RawSetString("rep", L.NewFunction(sandboxStringRep))
+	}
+	return L
+}
+
+// sandboxStringRep is string.rep refusing results over maxScriptString
+func sandboxStringRep(L *lua.LState) int {
+	s := L.CheckString(1)
+	n := L.CheckInt(2)
+	if n <= 0 {
+		L.Push(lua.LString(""))
+		return 1
+	}
+	if len(s) > 0 && n > maxScriptString/len(s) {
+		L.RaiseError("string.rep result too large (limit %d bytes)", maxScriptString)
+	}
+	L.Push(lua.LString(strings.Repeat(s, n)))
+	return 1
+}
+
+// callScript runs fn on L with the script time limit ctx carries; the call
+// also stops when ctx is done
+func callScript(ctx context.Context, L *lua.LState, p lua.P, args ...lua.LValue) error {
+	ctx, cancel := context.WithTimeout(ctx, scriptTimeoutFrom(ctx))
+	defer cancel()
+	L.SetContext(ctx)
+	defer L.RemoveContext()
+	return L.CallByParam(p, args...)
+}
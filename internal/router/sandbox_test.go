@@ -0,0 +1,204 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+)
+
+// Security regression suite for the route script sandbox. A failure here
+// means a library or helper gave scripts a way out of the sandbox; fix the
+// sandbox rather than the test.
+
+// sandboxWorker compiles script and returns a worker running it
+func sandboxWorker(t *testing.T, script string) (*worker, *mockStorage) {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "sandbox.lua")
+	if err := os.WriteFile(path, []byte(script), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+	proto, err := compileScript(path)
+	if err != nil {
+		t.Fatalf("compileScript failed: %v", err)
+	}
+	storage := newMockStorage()
+	w, err := newScriptWorker(0, proto, "probe", nil, storage, context.Background(), nil)
+	if err != nil {
+		t.Fatalf("newScriptWorker failed: %v", err)
+	}
+	t.Cleanup(func() { w.state.Close() })
+	return w, storage
+}
+
+// probeMessage is the message sandbox probes are run with
+var probeMessage = Message{Topic: "probe", Payload: []byte(`{"n": 1}`), Time: time.Now()}
+
+// probe evaluates a Lua expression in a sandboxed transform
+func probe(t *testing.T, expr string) interface{} {
+	t.Helper()
+	w, storage := sandboxWorker(t, `
+function transform(msg)
+  return {{columns = {value = `+expr+`}}}
+end
+`)
+	if err := w.process(probeMessage); err != nil {
+		t.Fatalf("%s: %v", expr, err)
+	}
+	return storage.inserts["probe"][0]["value"]
+}
+
+// expectScriptError runs a transform that must fail and checks the worker
+// still processes the next message
+func expectScriptError(t *testing.T, body, want string) {
+	t.Helper()
+	expectScriptErrorWithin(t, context.Background(), body, want)
+}
+
+// expectScriptErrorWithin is expectScriptError with the script time limit
+// carried by ctx
+func expectScriptErrorWithin(t *testing.T, ctx context.Context, body, want string) {
+	t.Helper()
+	w, storage := sandboxWorker(t, `
+local calls = 0
+
+function transform(msg)
+  calls = calls + 1
+  if calls == 1 then
+`+body+`
+  end
+  return {{columns = {ok = true}}}
+end
+`)
+	w.ctx = ctx
+	err := w.process(probeMessage)
+	if !errors.Is(err, errs.ErrTransform) || !strings.Contains(err.Error(), want) {
+		t.Fatalf("Expected transform error containing %q, got %v", want, err)
+	}
+	if err := w.process(probeMessage); err != nil {
+		t.Fatalf("Worker unusable after a sandbox violation: %v", err)
+	}
+	if len(storage.inserts["probe"]) != 1 {
+		t.Errorf("Expected only the second message to be stored, got %d rows", len(storage.inserts["probe"]))
+	}
+}
+
+func TestSandboxBlocksSystemAccess(t *testing.T) {
+	for _, expr := range []string{
+		"io", "debug", "package", "channel",
+		"require", "module", "dofile", "loadfile",
+		"os.execute", "os.exit", "os.getenv", "os.setenv", "os.remove", "os.rename",
+		"os.tmpname", "os.setlocale",
+		"string.dump",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			if got := probe(t, expr+" ~= nil"); got != false {
+				t.Errorf("%s is reachable from route scripts", expr)
+			}
+		})
+	}
+}
+
+func TestSandboxKeepsSafeLibraries(t *testing.T) {
+	for _, expr := range []string{
+		"os.time", "os.date", "os.clock", "os.difftime",
+		"string.format", "string.rep", "math.floor", "table.insert", "table.concat",
+		"pcall", "tostring", "loadstring", "coroutine.wrap",
+	} {
+		t.Run(expr, func(t *testing.T) {
+			if got := probe(t, expr+" ~= nil"); got != true {
+				t.Errorf("%s is missing from route scripts", expr)
+			}
+		})
+	}
+}
+
+func TestSandboxLoadstringStaysInside(t *testing.T) {
+	// Loaded chunks see the same restricted globals
+	for _, code := range []string{
+		`return io ~= nil or require ~= nil or os.execute ~= nil`,
+		`return getfenv(0).io ~= nil or _G.dofile ~= nil`,
+		`return rawget(_G, "debug") ~= nil`,
+		`local f = loadstring("return os.getenv") return f() ~= nil`,
+	} {
+		t.Run(code, func(t *testing.T) {
+			expr := `(function() local f = assert(loadstring([[` + code + `]])) return f() end)()`
+			if got := probe(t, expr); got != false {
+				t.Errorf("Loaded chunk escaped the sandbox: %s", code)
+			}
+		})
+	}
+
+	expectScriptError(t, `    assert(loadstring("io.open('/etc/passwd')"))()`, "io")
+	expectScriptError(t, `    assert(loadstring("os.execute('id')"))()`, "non-function")
+}
+
+func TestSandboxRunawayAllocations(t *testing.T) {
+	expectScriptError(t, `    local s = string.rep("x", 1e10)`, "too large")
+	expectScriptError(t, `    local s = ("x"):rep(1e10)`, "too large")
+	expectScriptError(t, `    local s = string.rep(string.rep("x", 1024 * 1024), 1024)`, "too large")
+
+	if got := probe(t, `#string.rep("ab", 3)`); got != 6.0 {
+		t.Errorf("string.rep within limits = %v, want 6", got)
+	}
+}
+
+func TestSandboxRunawayLoops(t *testing.T) {
+	ctx := withScriptTimeout(context.Background(), 50*time.Millisecond)
+	expectScriptErrorWithin(t, ctx, `    while true do end`, "deadline")
+	expectScriptErrorWithin(t, ctx, `    local t = {} while true do t[#t + 1] = 1 end`, "deadline")
+
+	// Loading a script that never finishes fails the route, not startup forever
+	path := filepath.Join(t.TempDir(), "hang.lua")
+	if err := os.WriteFile(path, []byte("while true do end\nfunction transform(msg) return {} end\n"), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+	proto, err := compileScript(path)
+	if err != nil {
+		t.Fatalf("compileScript failed: %v", err)
+	}
+	if _, err := newScriptWorker(0, proto, "probe", nil, newMockStorage(), ctx, nil); err == nil {
+		t.Error("Expected a script that never finishes loading to be rejected")
+	}
+
+	// The router passes its limit to every route script
+	start := time.Now()
+	routes := []Route{{Filter: "hang/#", Script: path}}
+	if _, err := New(context.Background(), routes, newMockStorage(), nil, WithScriptTimeout(50*time.Millisecond)); err == nil {
+		t.Error("Expected the router to reject a script that never finishes loading")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Expected the router's script timeout to apply, took %s", elapsed)
+	}
+	if _, err := New(context.Background(), nil, newMockStorage(), nil, WithScriptTimeout(-time.Second)); err == nil {
+		t.Error("Expected a negative script timeout rejected")
+	}
+}
+
+func TestSandboxDeepRecursion(t *testing.T) {
+	expectScriptError(t, `    local function f(n) return f(n + 1) + 1 end
+    f(1)`, "stack overflow")
+	expectScriptError(t, `    local function f(t) return setmetatable({}, {__index = function(_, k) return f(t)[k] end}) end
+    local x = f({}).boom`, "stack overflow")
+
+	// Recursion through pcall is cut off too; the deepest pcall reports it
+	w, storage := sandboxWorker(t, `
+function transform(msg)
+  local depth = 0
+  local function f() depth = depth + 1 pcall(f) end
+  f()
+  return {{columns = {depth = depth}}}
+end
+`)
+	if err := w.process(probeMessage); err != nil {
+		t.Fatalf("Recursion through pcall failed: %v", err)
+	}
+	if depth := storage.inserts["probe"][0]["depth"].(float64); depth > scriptCallDepth {
+		t.Errorf("Recursion through pcall reached depth %v, limit %d", depth, scriptCallDepth)
+	}
+}
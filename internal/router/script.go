@@ -0,0 +1,61 @@
+package router
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+
+	lua "github.com/yuin/gopher-lua"
+	"github.com/yuin/gopher-lua/parse"
+)
+
+// workerRegistrySize is the initial Lua data stack of a worker state. It
+// grows on demand up to lua.RegistrySize, the fixed size of a default state,
+// so routes with many workers don't pay for stack space transforms never use.
+const workerRegistrySize = 1024
+
+// compileScript parses and compiles a route script once so its prototype
+// can be shared by every worker of the route (nil for an empty path).
+// gopher-lua states are not safe for concurrent use, so each worker still
+// runs the prototype in its own state.
+func compileScript(path string) (*lua.FunctionProto, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Lua script: %w", err)
+	}
+	defer f.Close()
+
+	chunk, err := parse.Parse(bufio.NewReader(f), path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Lua script: %w", err)
+	}
+	proto, err := lua.Compile(chunk, path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load Lua script: %w", err)
+	}
+	return proto, nil
+}
+
+// checkTransform verifies a loaded script defines transform(msg), so a
+// missing or misdeclared entry point fails when the route is created or
+// reloaded instead of at the first message
+func checkTransform(L *lua.LState, path string) error {
+	fn, ok := L.GetGlobal("transform").(*lua.LFunction)
+	if !ok {
+		return fmt.Errorf("invalid Lua script %s: transform function not found", path)
+	}
+	if fn.IsG {
+		return nil
+	}
+	switch params := fn.Proto.NumParameters; {
+	case params > 1:
+		return fmt.Errorf("invalid Lua script %s: transform takes one argument (msg), declared with %d", path, params)
+	case params == 0 && fn.Proto.IsVarArg == 0:
+		return fmt.Errorf("invalid Lua script %s: transform must take the message argument: transform(msg)", path)
+	}
+	return nil
+}
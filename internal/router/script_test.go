@@ -0,0 +1,130 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+func TestCompileScriptSharedByWorkers(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "count.lua")
+	script := `
+local seen = 0
+
+function transform(msg)
+  seen = seen + 1
+  return {{columns = {seen = seen}}}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	proto, err := compileScript(scriptPath)
+	if err != nil {
+		t.Fatalf("compileScript failed: %v", err)
+	}
+
+	storage := newMockStorage()
+	ctx := context.Background()
+	var workers []*worker
+	for i := 0; i < 2; i++ {
+		w, err := newScriptWorker(i, proto, "counts", nil, storage, ctx, nil)
+		if err != nil {
+			t.Fatalf("newScriptWorker failed: %v", err)
+		}
+		defer w.state.Close()
+		workers = append(workers, w)
+	}
+
+	// Workers share the prototype but not the script's state
+	msg := Message{Topic: "a", Payload: []byte("{}"), Time: time.Now()}
+	workers[0].process(msg)
+	workers[0].process(msg)
+	workers[1].process(msg)
+
+	rows := storage.inserts["counts"]
+	want := []float64{1, 2, 1}
+	if len(rows) != len(want) {
+		t.Fatalf("Expected %d rows, got %d", len(want), len(rows))
+	}
+	for i, w := range want {
+		if rows[i]["seen"] != w {
+			t.Errorf("row %d seen = %v, want %v", i, rows[i]["seen"], w)
+		}
+	}
+}
+
+func TestCompileScriptErrors(t *testing.T) {
+	if proto, err := compileScript(""); proto != nil || err != nil {
+		t.Errorf("compileScript(\"\") = %v, %v; want nil, nil", proto, err)
+	}
+	if _, err := compileScript(filepath.Join(t.TempDir(), "missing.lua")); err == nil {
+		t.Error("Expected error for missing script")
+	}
+
+	scriptPath := filepath.Join(t.TempDir(), "broken.lua")
+	os.WriteFile(scriptPath, []byte("function transform(msg"), 0644)
+	if _, err := compileScript(scriptPath); err == nil {
+		t.Error("Expected error for syntax error")
+	}
+}
+
+func TestNewValidatesTransform(t *testing.T) {
+	tests := []struct {
+		script  string
+		wantErr string
+	}{
+		{`function transform(msg) return {} end`, ""},
+		{`function transform(...) return {} end`, ""},
+		{`schema = {}`, "transform function not found"},
+		{`transform = 42`, "transform function not found"},
+		{`function transform() return {} end`, "must take the message argument"},
+		{`function transform(msg, extra) return {} end`, "declared with 2"},
+	}
+	for _, tt := range tests {
+		scriptPath := filepath.Join(t.TempDir(), "route.lua")
+		if err := os.WriteFile(scriptPath, []byte(tt.script), 0644); err != nil {
+			t.Fatalf("failed to write test script: %v", err)
+		}
+		r, err := New(context.Background(), []Route{{Filter: "a/+", Script: scriptPath, Table: "a"}}, newMockStorage(), nil)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: unexpected error: %v", tt.script, err)
+			} else {
+				r.Close()
+			}
+			continue
+		}
+		if err == nil {
+			r.Close()
+			t.Errorf("%s: expected error containing %q", tt.script, tt.wantErr)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), scriptPath) {
+			t.Errorf("%s: error = %v, want %q and the script path", tt.script, err, tt.wantErr)
+		}
+	}
+}
+
+func TestWorkerStateGrowsRegistry(t *testing.T) {
+	L := newWorkerState()
+	defer L.Close()
+
+	// More stack slots than the initial registry: must grow instead of overflowing
+	if err := L.DoString(`
+local t = {}
+for i = 1, 3000 do t[i] = i end
+result = select("#", unpack(t))
+`); err != nil {
+		t.Fatalf("DoString failed: %v", err)
+	}
+	if L.GetGlobal("result") != lua.LNumber(3000) {
+		t.Errorf("result = %v, want 3000", L.GetGlobal("result"))
+	}
+}
@@ -0,0 +1,74 @@
+package router
+
+import (
+	"sync"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// sharedStore holds the values a route's workers share through the Lua
+// "shared" table. Each worker has its own Lua state, so only immutable
+// scalars (strings, numbers, booleans) are stored.
+type sharedStore struct {
+	mu     sync.Mutex
+	values map[string]lua.LValue
+}
+
+func newSharedStore() *sharedStore {
+	return &sharedStore{values: make(map[string]lua.LValue)}
+}
+
+// register exposes the store to a Lua state as the global table "shared":
+//
+//	shared.get(key)           -> value | nil
+//	shared.set(key, value)    -- nil removes the key
+//	shared.incr(key [, delta]) -> new value (missing keys start at 0)
+func (s *sharedStore) register(L *lua.LState) {
+	L.SetGlobal("shared", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"get": func(L *lua.LState) int {
+			key := L.CheckString(1)
+			s.mu.Lock()
+			v, ok := s.values[key]
+			s.mu.Unlock()
+			if !ok {
+				v = lua.LNil
+			}
+			L.Push(v)
+			return 1
+		},
+		"set": func(L *lua.LState) int {
+			key := L.CheckString(1)
+			v := L.Get(2)
+			switch v.Type() {
+			case lua.LTNil, lua.LTString, lua.LTNumber, lua.LTBool:
+			default:
+				L.ArgError(2, "shared values must be strings, numbers, booleans or nil")
+			}
+			s.mu.Lock()
+			if v == lua.LNil {
+				delete(s.values, key)
+			} else {
+				s.values[key] = v
+			}
+			s.mu.Unlock()
+			return 0
+		},
+		"incr": func(L *lua.LState) int {
+			key := L.CheckString(1)
+			delta := L.OptNumber(2, 1)
+			s.mu.Lock()
+			cur, ok := s.values[key]
+			n, isNum := cur.(lua.LNumber)
+			if ok && !isNum {
+				s.mu.Unlock()
+				L.RaiseError("shared value %q is not a number", key)
+				return 0
+			}
+			n += delta
+			s.values[key] = n
+			s.mu.Unlock()
+			L.Push(n)
+			return 1
+		},
+	}))
+}
@@ -0,0 +1,51 @@
+package router
+
+import (
+	"fmt"
+
+	"github.com/marcgeld/hermod/internal/errs"
+)
+
+// WithSinks registers named sinks that Lua records (sink = "name") and
+// routes (Route.Sink) can target instead of the route's storage. Records
+// sent to a sink are masked and time-normalized like the route's other
+// records but bypass reordering, downsampling and batching.
+func WithSinks(sinks map[string]Storage) Option {
+	return func(r *Router) {
+		r.sinks = sinks
+	}
+}
+
+// routeSinks wraps the router's sinks in the route's stateless stages
+func (r *Router) routeSinks(route Route, timestamps *timestampParser) map[string]Storage {
+	if len(r.sinks) == 0 {
+		return nil
+	}
+	sinks := make(map[string]Storage, len(r.sinks))
+	for name, s := range r.sinks {
+		if route.Mask != nil {
+			s = newMasker(*route.Mask, s)
+		}
+		if timestamps != nil {
+			s = &timeNormalizer{parser: timestamps, next: s}
+		}
+		sinks[name] = s
+	}
+	return sinks
+}
+
+// recordStorage returns the storage a record is written to: its sink, the
+// route's sink, or the route's storage
+func (w *worker) recordStorage(rec Record) (Storage, error) {
+	if rec.Sink == "" {
+		rec.Sink = w.sink
+	}
+	if rec.Sink == "" {
+		return w.storage, nil
+	}
+	s, ok := w.sinks[rec.Sink]
+	if !ok {
+		return nil, fmt.Errorf("%w: record for table %s targets unknown sink %q", errs.ErrTransform, rec.Table, rec.Sink)
+	}
+	return s, nil
+}
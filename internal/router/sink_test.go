@@ -0,0 +1,108 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWorkerRecordSinks(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "sinks.lua")
+	scriptCode := `
+function transform(msg)
+  if msg.json.bad then
+    return {{ table = "events", sink = "nowhere", columns = { v = 1 } }}
+  end
+  return {
+    { table = "aggregates", columns = { v = msg.json.v } },
+    { table = "events", sink = "stream", columns = { v = msg.json.v, mac = "AA:BB:CC" } }
+  }
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	storage := newMockStorage()
+	stream := newMockStorage()
+	routes := []Route{{
+		Filter: "sensors/+",
+		Script: scriptPath,
+		Table:  "aggregates",
+		Mask:   &Mask{Columns: []string{"mac"}, Strategy: "drop"},
+	}}
+	r, err := New(context.Background(), routes, storage, nil, WithSinks(map[string]Storage{"stream": stream}))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	for _, p := range []string{`{"v": 3}`, `{"bad": true}`} {
+		if err := r.Dispatch(Message{Topic: "sensors/a", Payload: []byte(p), Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	r.Close()
+
+	if storage.count("aggregates") != 1 || storage.count("events") != 0 {
+		t.Errorf("Expected only aggregates in route storage, got %v", storage.inserts)
+	}
+	rows := stream.inserts["events"]
+	if len(rows) != 1 || rows[0]["v"] != 3.0 {
+		t.Fatalf("Expected event in the stream sink, got %v", stream.inserts)
+	}
+	if _, ok := rows[0]["mac"]; ok {
+		t.Error("Expected the route's mask to apply to sink records")
+	}
+
+	if _, err := New(context.Background(), nil, storage, nil, WithSinks(map[string]Storage{"": stream})); err == nil {
+		t.Error("Expected error for an empty sink name")
+	}
+}
+
+func TestRouteSink(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "sink.lua")
+	scriptCode := `
+function transform(msg)
+  return {
+    { table = "readings", columns = { v = msg.json.v } },
+    { table = "audit", sink = "archive", columns = { v = msg.json.v } }
+  }
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	storage, stream, archive := newMockStorage(), newMockStorage(), newMockStorage()
+	routes := []Route{
+		{Filter: "sensors/+", Script: scriptPath, Sink: "stream"},
+		{Filter: "raw/+", Table: "raw", Sink: "stream"},
+	}
+	r, err := New(context.Background(), routes, storage, nil, WithSinks(map[string]Storage{"stream": stream, "archive": archive}))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	for _, topic := range []string{"sensors/a", "raw/a"} {
+		if err := r.Dispatch(Message{Topic: topic, Payload: []byte(`{"v": 3}`), Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	r.Drain()
+	r.Close()
+
+	// Records without a sink go to the route's, others keep their own
+	if len(storage.inserts) != 0 {
+		t.Errorf("Expected nothing in route storage, got %v", storage.inserts)
+	}
+	if stream.count("readings") != 1 || stream.count("raw") != 1 || archive.count("audit") != 1 {
+		t.Errorf("Unexpected sink rows: stream %v, archive %v", stream.inserts, archive.inserts)
+	}
+
+	routes = []Route{{Filter: "sensors/+", Sink: "missing"}}
+	if _, err := New(context.Background(), routes, storage, nil, WithSinks(map[string]Storage{"stream": stream})); err == nil {
+		t.Error("Expected error for an unknown route sink")
+	}
+}
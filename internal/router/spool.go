@@ -0,0 +1,210 @@
+package router
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/seal"
+)
+
+// spoolFile is the queue spool's name inside the spool directory
+const spoolFile = "queues.jsonl"
+
+// spoolEntry is a queued message saved across a restart (one JSON line)
+type spoolEntry struct {
+	Route   string    `json:"route"` // Filter of the route the message was queued for
+	Topic   string    `json:"topic"`
+	Payload []byte    `json:"payload"`
+	QoS     byte      `json:"qos"`
+	Retain  bool      `json:"retain"`
+	Time    time.Time `json:"time"`
+
+	Properties *Properties `json:"properties,omitempty"`
+}
+
+// WithQueueSpool saves messages still queued when the router closes to a file
+// in dir and queues them again when a router is created with the same dir
+func WithQueueSpool(dir string) Option {
+	return func(r *Router) {
+		r.spoolDir = dir
+	}
+}
+
+// WithSpoolKey encrypts the queue spool with an AES-256 key (see package
+// seal). Spools written without encryption are still restored.
+func WithSpoolKey(key []byte) Option {
+	return func(r *Router) {
+		r.spoolKey = key
+	}
+}
+
+// spoolQueues writes the messages left in closed route queues to the spool.
+// Workers must have stopped.
+func (r *Router) spoolQueues() error {
+	var entries []spoolEntry
+	var acks []func()
+	for _, h := range r.routes {
+		for msg := range h.msgChan {
+			if msg.Ack != nil {
+				acks = append(acks, msg.Ack)
+			}
+			entries = append(entries, spoolEntry{
+				Route:   h.route.Filter,
+				Topic:   msg.Topic,
+				Payload: msg.Payload,
+				QoS:     msg.QoS,
+				Retain:  msg.Retain,
+				Time:    msg.Time,
+
+				Properties: msg.Properties,
+			})
+		}
+	}
+	if len(entries) == 0 {
+		return nil
+	}
+
+	if err := os.MkdirAll(r.spoolDir, 0o755); err != nil {
+		return fmt.Errorf("failed to create spool directory: %w", err)
+	}
+	// Write a temporary file and rename it so a crash never leaves a partial spool
+	path := filepath.Join(r.spoolDir, spoolFile)
+	tmp, err := os.CreateTemp(r.spoolDir, spoolFile+".*")
+	if err != nil {
+		return fmt.Errorf("failed to create spool file: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	var out io.Writer = w
+	var sealed *seal.Writer
+	if r.spoolKey != nil {
+		if sealed, err = seal.NewWriter(w, r.spoolKey); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return fmt.Errorf("failed to write spool: %w", err)
+		}
+		out = sealed
+	}
+	enc := json.NewEncoder(out)
+	for _, e := range entries {
+		if err := enc.Encode(e); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return fmt.Errorf("failed to write spool: %w", err)
+		}
+	}
+	if sealed != nil {
+		if err := sealed.Close(); err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+			return fmt.Errorf("failed to write spool: %w", err)
+		}
+	}
+	if err := errors.Join(w.Flush(), tmp.Sync(), tmp.Close()); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write spool: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("failed to write spool: %w", err)
+	}
+	r.logger.Infof("Saved %d queued messages to %s", len(entries), path)
+
+	// The spool now holds the messages, so their sources can let go of them
+	for _, ack := range acks {
+		ack()
+	}
+	return nil
+}
+
+// restoreQueues queues the messages saved by spoolQueues again and removes
+// the spool. Messages for routes that no longer exist go to passthrough.
+func (r *Router) restoreQueues() error {
+	path := filepath.Join(r.spoolDir, spoolFile)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open spool: %w", err)
+	}
+	defer f.Close()
+
+	handlers := make(map[string]*routeHandler, len(r.routes))
+	for _, h := range r.routes {
+		handlers[h.route.Filter] = h
+	}
+
+	// A spool that can't be read is set aside, so the next shutdown's spool
+	// doesn't replace it and it can be restored by hand
+	in, err := seal.NewReader(f, r.spoolKey)
+	if err != nil {
+		return fmt.Errorf("failed to open spool %s (kept as %s): %w", path, setAside(path), err)
+	}
+
+	// Decode the whole spool before queueing anything, so a spool that is
+	// cut off or corrupt halfway isn't partly restored and then restored
+	// again by hand
+	var entries []spoolEntry
+	dec := json.NewDecoder(in)
+	for {
+		var e spoolEntry
+		err := dec.Decode(&e)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read spool %s (kept as %s): %w", path, setAside(path), err)
+		}
+		entries = append(entries, e)
+	}
+
+	restored, orphaned := 0, 0
+	for _, e := range entries {
+		msg := Message{Topic: e.Topic, Payload: e.Payload, QoS: e.QoS, Retain: e.Retain, Time: e.Time, Properties: e.Properties}
+		h, ok := handlers[e.Route]
+		if !ok {
+			if err := r.passthrough.handle(msg, parseJSON(msg.Payload, r.floatNumbers)); err != nil {
+				r.logger.Errorf("Failed to restore message from %s: %v", msg.Topic, err)
+			}
+			orphaned++
+			continue
+		}
+		// Workers are running, so a full queue drains while we wait
+		h.lane.queued()
+		select {
+		case h.msgChan <- msg:
+			restored++
+		case <-r.ctx.Done():
+			h.lane.done()
+			return fmt.Errorf("router context cancelled while restoring queues")
+		}
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove spool: %w", err)
+	}
+	r.logger.Infof("Restored %d queued messages from %s (%d for removed routes sent to passthrough)", restored+orphaned, path, orphaned)
+	return nil
+}
+
+// setAside renames the spool at path to path.invalid, or path.invalid.N when
+// an earlier one is still there, and returns the new name
+func setAside(path string) string {
+	aside := path + ".invalid"
+	for n := 1; ; n++ {
+		if _, err := os.Lstat(aside); errors.Is(err, os.ErrNotExist) {
+			break
+		}
+		aside = fmt.Sprintf("%s.invalid.%d", path, n)
+	}
+	if err := os.Rename(path, aside); err != nil {
+		return path
+	}
+	return aside
+}
@@ -0,0 +1,191 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/seal"
+)
+
+// blockingStorage holds every insert until the context is cancelled
+type blockingStorage struct{}
+
+func (blockingStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestRouterQueueSpool(t *testing.T) {
+	dir := t.TempDir()
+	routes := []Route{{Filter: "a/+", Table: "a"}, {Filter: "b/+", Table: "b"}}
+	r, err := New(context.Background(), routes, blockingStorage{}, nil, WithQueueSpool(dir))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	for _, topic := range []string{"a/1", "a/2", "a/3", "b/1", "b/2"} {
+		if err := r.Dispatch(Message{Topic: topic, Payload: []byte(`{"v": 1}`), QoS: 1, Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch(%s) failed: %v", topic, err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	r.Close()
+
+	// Each route's worker holds its first message; the rest were still queued
+	if _, err := os.Stat(filepath.Join(dir, spoolFile)); err != nil {
+		t.Fatalf("Expected a spool file: %v", err)
+	}
+
+	// Route b/+ no longer exists after the restart
+	storage := newMockStorage()
+	r, err = New(context.Background(), []Route{{Filter: "a/+", Table: "a"}}, storage, nil, WithQueueSpool(dir))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	r.Close()
+
+	if storage.count("a") != 2 {
+		t.Errorf("Expected 2 restored messages for a/+, got %d", storage.count("a"))
+	}
+	if storage.count("iot_raw") != 1 {
+		t.Errorf("Expected the message for the removed route in passthrough, got %d", storage.count("iot_raw"))
+	}
+	if rows := storage.inserts["a"]; len(rows) > 0 && (rows[0]["topic"] != "a/2" || rows[0]["qos"] != 1) {
+		t.Errorf("Expected the restored message to keep its topic and QoS, got %v", rows[0])
+	}
+	if _, err := os.Stat(filepath.Join(dir, spoolFile)); !os.IsNotExist(err) {
+		t.Errorf("Expected the spool to be removed after restoring, got %v", err)
+	}
+}
+
+func TestRouterQueueSpoolEncrypted(t *testing.T) {
+	dir := t.TempDir()
+	key := bytes.Repeat([]byte{7}, seal.KeySize)
+	routes := []Route{{Filter: "a/+", Table: "a"}}
+	r, err := New(context.Background(), routes, blockingStorage{}, nil, WithQueueSpool(dir), WithSpoolKey(key))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	for _, topic := range []string{"a/1", "a/2"} {
+		if err := r.Dispatch(Message{Topic: topic, Payload: []byte(`{"secret": 1}`), Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch(%s) failed: %v", topic, err)
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	r.Close()
+
+	raw, err := os.ReadFile(filepath.Join(dir, spoolFile))
+	if err != nil {
+		t.Fatalf("Expected a spool file: %v", err)
+	}
+	if bytes.Contains(raw, []byte("a/2")) {
+		t.Error("Expected the spool to be encrypted")
+	}
+
+	// Without the key the spool is set aside for a later start
+	path := filepath.Join(dir, spoolFile)
+	r, err = New(context.Background(), routes, newMockStorage(), logger.New(logger.ERROR), WithQueueSpool(dir))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	r.Close()
+	if _, err := os.Stat(path + ".invalid"); err != nil {
+		t.Fatalf("Expected the spool kept without the key: %v", err)
+	}
+	if err := os.Rename(path+".invalid", path); err != nil {
+		t.Fatal(err)
+	}
+
+	storage := newMockStorage()
+	r, err = New(context.Background(), routes, storage, nil, WithQueueSpool(dir), WithSpoolKey(key))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	r.Close()
+	if storage.count("a") != 1 {
+		t.Errorf("Expected 1 restored message, got %d", storage.count("a"))
+	}
+}
+
+func TestRouterQueueSpoolWrongKey(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, spoolFile)
+	key := bytes.Repeat([]byte{7}, seal.KeySize)
+	routes := []Route{{Filter: "a/+", Table: "a"}}
+
+	// spool starts a router with key, queues msgs behind a blocked worker and
+	// shuts it down, leaving them in the spool
+	spool := func(key []byte, msgs ...string) {
+		t.Helper()
+		r, err := New(context.Background(), routes, blockingStorage{}, logger.New(logger.ERROR), WithQueueSpool(dir), WithSpoolKey(key))
+		if err != nil {
+			t.Fatalf("Failed to create router: %v", err)
+		}
+		for _, topic := range msgs {
+			if err := r.Dispatch(Message{Topic: topic, Payload: []byte(`{}`), Time: time.Now()}); err != nil {
+				t.Fatalf("Dispatch(%s) failed: %v", topic, err)
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		r.Close()
+	}
+	spool(key, "a/1", "a/2")
+
+	// A restart with the wrong key sets the spool aside instead of
+	// overwriting it with its own, and so does the next one with the right
+	// key, without replacing the first
+	spool(bytes.Repeat([]byte{8}, seal.KeySize), "a/3", "a/4")
+	spool(key, "a/5", "a/6")
+	for _, name := range []string{path + ".invalid", path + ".invalid.1"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Fatalf("Expected the unreadable spool kept: %v", err)
+		}
+	}
+
+	// The first spool, put back in place of the latest, restores with the right key
+	if err := os.Rename(path+".invalid", path); err != nil {
+		t.Fatal(err)
+	}
+	storage := newMockStorage()
+	r, err := New(context.Background(), routes, storage, nil, WithQueueSpool(dir), WithSpoolKey(key))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	r.Close()
+	if storage.count("a") != 1 {
+		t.Errorf("Expected 1 restored message, got %d", storage.count("a"))
+	}
+}
+
+func TestRouterQueueSpoolCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, spoolFile)
+	spool := `{"route":"a/+","topic":"a/1","payload":"e30=","time":"2024-01-01T00:00:00Z"}
+{"route":"a/+","topic":"a/2","payload":"e30=","time":"2024-01-01T00:00:00Z"}
+{"route":"a/+","topic":"a/3","pay`
+	if err := os.WriteFile(path, []byte(spool), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is queued from a spool cut off halfway, and it is set aside whole
+	storage := newMockStorage()
+	r, err := New(context.Background(), []Route{{Filter: "a/+", Table: "a"}}, storage, logger.New(logger.ERROR), WithQueueSpool(dir))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	r.Close()
+	if storage.count("a") != 0 {
+		t.Errorf("Expected no messages restored from a corrupt spool, got %d", storage.count("a"))
+	}
+	if data, err := os.ReadFile(path + ".invalid"); err != nil || string(data) != spool {
+		t.Errorf("Expected the corrupt spool kept unchanged, got %v", err)
+	}
+}
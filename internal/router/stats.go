@@ -0,0 +1,36 @@
+package router
+
+import (
+	lua "github.com/yuin/gopher-lua"
+)
+
+// registerStats exposes the route's counters to a Lua state as stats(),
+// so scripts can adapt to load (e.g. widen an aggregation window while the
+// queue is backed up):
+//
+//	stats() -> {queue_length, queue_capacity, queue_high, processed, errors, workers,
+//	            transform_avg_ms, transform_max_ms, slow_transforms}
+//
+// processed and errors count transforms that succeeded and failed since the
+// route started; the message being transformed is not counted yet, nor timed.
+func (h *routeHandler) registerStats(L *lua.LState) {
+	L.SetGlobal("stats", L.NewFunction(func(L *lua.LState) int {
+		t := L.CreateTable(0, 9)
+		t.RawSetString("queue_length", lua.LNumber(len(h.msgChan)))
+		t.RawSetString("queue_capacity", lua.LNumber(cap(h.msgChan)))
+		t.RawSetString("queue_high", lua.LBool(h.queueHigh.Load()))
+		t.RawSetString("processed", lua.LNumber(h.processed.Load()))
+		t.RawSetString("errors", lua.LNumber(h.failed.Load()))
+		t.RawSetString("workers", lua.LNumber(len(h.workers)))
+		timing := h.timing.snapshot()
+		avg := 0.0
+		if timing.Count > 0 {
+			avg = timing.TotalMs / float64(timing.Count)
+		}
+		t.RawSetString("transform_avg_ms", lua.LNumber(avg))
+		t.RawSetString("transform_max_ms", lua.LNumber(timing.MaxMs))
+		t.RawSetString("slow_transforms", lua.LNumber(timing.Slow))
+		L.Push(t)
+		return 1
+	}))
+}
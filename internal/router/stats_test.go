@@ -0,0 +1,70 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestScriptStats(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "stats.lua")
+	scriptCode := `
+function transform(msg)
+  if msg.json.fail then error("boom") end
+  local s = stats()
+  return {{columns = {
+    queue_length = s.queue_length, queue_capacity = s.queue_capacity, queue_high = s.queue_high,
+    processed = s.processed, errors = s.errors, workers = s.workers
+  }}}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	storage := newMockStorage()
+	routes := []Route{{Filter: "sensors/+", Script: scriptPath, Workers: 1, QueueSize: 8, Table: "stats"}}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	defer r.Close()
+
+	for _, payload := range []string{`{}`, `{"fail": true}`, `{}`} {
+		if err := r.Dispatch(Message{Topic: "sensors/a", Payload: []byte(payload), Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+
+	storage.mu.Lock()
+	rows := storage.inserts["stats"]
+	storage.mu.Unlock()
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(rows))
+	}
+	if first := rows[0]; first["processed"] != 0.0 || first["errors"] != 0.0 || first["queue_capacity"] != 8.0 ||
+		first["workers"] != 1.0 || first["queue_high"] != false {
+		t.Errorf("Unexpected stats for the first message: %v", first)
+	}
+	// The current message isn't counted yet
+	if last := rows[1]; last["processed"] != 1.0 || last["errors"] != 1.0 || last["queue_length"] != 0.0 {
+		t.Errorf("Unexpected stats for the third message: %v", last)
+	}
+
+	status := r.RouteStatus()[0]
+	if status.Processed != 2 || status.Errors != 1 {
+		t.Errorf("RouteStatus processed=%d errors=%d, want 2 and 1", status.Processed, status.Errors)
+	}
+
+	// Samples run against an idle route
+	_, records, err := r.TransformSample(Message{Topic: "sensors/a", Payload: []byte(`{}`), Time: time.Now()})
+	if err != nil {
+		t.Fatalf("TransformSample failed: %v", err)
+	}
+	if records[0].Columns["processed"] != 0.0 || records[0].Columns["queue_capacity"] != 0.0 {
+		t.Errorf("Expected idle stats in samples, got %v", records[0].Columns)
+	}
+}
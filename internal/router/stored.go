@@ -0,0 +1,52 @@
+package router
+
+import (
+	"time"
+
+	lua "github.com/yuin/gopher-lua"
+)
+
+// stored calls the script's optional on_stored(record, ok, err) after the
+// insert of one of its records was attempted:
+//
+//	function on_stored(record, ok, err)
+//	  -- record.table, record.columns and record.sink as the transform returned them
+//	end
+//
+// ok is true and err nil when storage accepted the record; on batched routes
+// that means it was queued for the next batch. A failing hook is counted and
+// logged but doesn't fail the message, whose record is already stored.
+func (w *worker) stored(table string, rec Record, err error) {
+	fn := w.state.GetGlobal("on_stored")
+	if fn.Type() != lua.LTFunction {
+		return
+	}
+
+	record := w.state.CreateTable(0, 3)
+	record.RawSetString("table", lua.LString(table))
+	columns := w.state.CreateTable(0, len(rec.Columns))
+	for name, v := range rec.Columns {
+		if t, ok := v.(time.Time); ok {
+			v = t.Format(time.RFC3339Nano)
+		}
+		columns.RawSetString(name, jsonToLTable(w.state, v))
+	}
+	record.RawSetString("columns", columns)
+	if rec.Sink != "" {
+		record.RawSetString("sink", lua.LString(rec.Sink))
+	}
+	var ok, reason lua.LValue = lua.LTrue, lua.LNil
+	if err != nil {
+		ok, reason = lua.LFalse, lua.LString(err.Error())
+	}
+
+	if err := callScript(w.ctx, w.state, lua.P{Fn: fn, NRet: 0, Protect: true}, record, ok, reason); err != nil {
+		if w.handler == nil {
+			return
+		}
+		w.handler.storedErrors.Add(1)
+		if n, log := w.handler.storedLog.event(); log {
+			w.logger.Errorf("Route %s: %d on_stored errors (last for table %s: %v)", w.handler.route.Filter, n, table, err)
+		}
+	}
+}
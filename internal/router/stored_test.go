@@ -0,0 +1,85 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+func writeStoredScript(t *testing.T) string {
+	t.Helper()
+	scriptPath := filepath.Join(t.TempDir(), "stored.lua")
+	scriptCode := `
+function transform(msg)
+  return {{ columns = { id = msg.data.id, temp = msg.data.temp } }}
+end
+
+function on_stored(record, ok, err)
+  if record.columns.id == "boom" then error("hook failed") end
+  mqtt_publish("acks/" .. record.columns.id, { table = record.table, ok = ok, err = err or "" })
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+	return scriptPath
+}
+
+func TestRouterOnStored(t *testing.T) {
+	storage := newMockStorage()
+	routes := []Route{{Filter: "sensors/+", Script: writeStoredScript(t), Table: "readings"}}
+	r, err := New(context.Background(), routes, storage, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	publisher := &scriptRecorder{}
+	r.SetScriptPublisher(publisher)
+
+	for _, payload := range []string{`{"id": "a1", "temp": 21.5}`, `{"id": "boom"}`} {
+		if err := r.Dispatch(Message{Topic: "sensors/x", Payload: []byte(payload), Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	r.Drain()
+
+	if len(publisher.messages) != 1 {
+		t.Fatalf("Expected 1 acknowledgment, got %+v", publisher.messages)
+	}
+	if got := publisher.messages[0]; got.topic != "acks/a1" || got.payload != `{"err":"","ok":true,"table":"readings"}` {
+		t.Errorf("Unexpected acknowledgment %+v", got)
+	}
+	if storage.count("readings") != 2 {
+		t.Errorf("Expected a failing hook not to fail the message, got %v", storage.inserts)
+	}
+	if n := r.RouteStatus()[0].StoredErrors; n != 1 {
+		t.Errorf("Expected 1 hook error counted, got %d", n)
+	}
+}
+
+func TestRouterOnStoredFailure(t *testing.T) {
+	routes := []Route{{Filter: "sensors/+", Script: writeStoredScript(t), Table: "readings"}}
+	r, err := New(context.Background(), routes, unavailableStorage{}, logger.New(logger.ERROR))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	publisher := &scriptRecorder{}
+	r.SetScriptPublisher(publisher)
+
+	if err := r.Dispatch(Message{Topic: "sensors/x", Payload: []byte(`{"id": "a1"}`), Time: time.Now()}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	r.Drain()
+
+	if len(publisher.messages) != 1 {
+		t.Fatalf("Expected 1 acknowledgment, got %+v", publisher.messages)
+	}
+	got := publisher.messages[0].payload
+	if !strings.Contains(got, `"ok":false`) || !strings.Contains(got, "connection refused") {
+		t.Errorf("Expected the failed insert reported, got %s", got)
+	}
+}
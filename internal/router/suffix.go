@@ -0,0 +1,131 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+	"github.com/marcgeld/hermod/internal/sink"
+)
+
+// Table suffix periods: records go to "<table>_<period of their time>"
+const (
+	TableSuffixDaily   = "daily"   // <table>_2024_06_01
+	TableSuffixMonthly = "monthly" // <table>_2024_06
+	TableSuffixYearly  = "yearly"  // <table>_2024
+)
+
+// suffixLayouts are the time layouts of the period suffixes
+var suffixLayouts = map[string]string{
+	TableSuffixDaily:   "_2006_01_02",
+	TableSuffixMonthly: "_2006_01",
+	TableSuffixYearly:  "_2006",
+}
+
+// validateTableSuffix checks a route's table suffix period ("" = none)
+func validateTableSuffix(period string) error {
+	if _, ok := suffixLayouts[period]; ok || period == "" {
+		return nil
+	}
+	return fmt.Errorf("invalid table_suffix %q: use daily, monthly or yearly", period)
+}
+
+// TableCreator creates the period tables of routes with a table suffix
+type TableCreator interface {
+	// CreateTableLike creates table with the columns, defaults, constraints
+	// and indexes of like, unless it already exists
+	CreateTableLike(ctx context.Context, table, like string) error
+}
+
+// WithTableCreator sets how routes with a table suffix create their period
+// tables (without one, the tables must already exist)
+func WithTableCreator(c TableCreator) Option {
+	return func(r *Router) {
+		r.tableCreator = c
+	}
+}
+
+// tableSuffixer is a Storage stage that writes each record into the period
+// table of its "time" column (UTC; records without one use the current
+// time), creating period tables from the route's table the first time
+// they're written to
+type tableSuffixer struct {
+	layout  string
+	creator TableCreator // nil = period tables already exist
+	next    Storage
+	created sync.Map // Period tables known to exist
+}
+
+func newTableSuffixer(period string, creator TableCreator, next Storage) *tableSuffixer {
+	return &tableSuffixer{layout: suffixLayouts[period], creator: creator, next: next}
+}
+
+// table returns the period table of a record written to base
+func (s *tableSuffixer) table(base string, data map[string]interface{}, now time.Time) string {
+	return base + sink.RecordTime(data, now).UTC().Format(s.layout)
+}
+
+// ensure creates the period table from base unless it's known to exist
+func (s *tableSuffixer) ensure(ctx context.Context, table, base string) error {
+	if s.creator == nil {
+		return nil
+	}
+	if _, ok := s.created.Load(table); ok {
+		return nil
+	}
+	if err := s.creator.CreateTableLike(ctx, table, base); err != nil {
+		return fmt.Errorf("failed to create %s from %s: %w", table, base, err)
+	}
+	s.created.Store(table, true)
+	return nil
+}
+
+// forget lets a period table that vanished be created again
+func (s *tableSuffixer) forget(table string, err error) error {
+	if errors.Is(err, errs.ErrSchemaDrift) {
+		s.created.Delete(table)
+	}
+	return err
+}
+
+// InsertIntoTable stores the record in its period table
+func (s *tableSuffixer) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	period := s.table(table, data, time.Now())
+	if err := s.ensure(ctx, period, table); err != nil {
+		return err
+	}
+	return s.forget(period, s.next.InsertIntoTable(ctx, period, data))
+}
+
+// InsertBatch stores the rows with one batch per period table, in the order
+// the periods first appear, so rows spanning periods aren't written
+// atomically
+func (s *tableSuffixer) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error {
+	bs, ok := s.next.(sink.BatchStorage)
+	if !ok {
+		return sink.InsertEach(ctx, s, table, rows)
+	}
+
+	now := time.Now()
+	periods := make(map[string][]map[string]interface{})
+	var order []string
+	for _, data := range rows {
+		period := s.table(table, data, now)
+		if _, seen := periods[period]; !seen {
+			order = append(order, period)
+		}
+		periods[period] = append(periods[period], data)
+	}
+	for _, period := range order {
+		if err := s.ensure(ctx, period, table); err != nil {
+			return err
+		}
+		if err := bs.InsertBatch(ctx, period, periods[period]); err != nil {
+			return s.forget(period, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+)
+
+// recordingCreator records the period tables a tableSuffixer creates
+type recordingCreator struct {
+	mu      sync.Mutex
+	created []string
+}
+
+func (c *recordingCreator) CreateTableLike(ctx context.Context, table, like string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.created = append(c.created, table+" like "+like)
+	return nil
+}
+
+func TestTableSuffixerPeriods(t *testing.T) {
+	at := time.Date(2024, 6, 3, 23, 30, 0, 0, time.FixedZone("CEST", 2*3600))
+	for period, want := range map[string]string{
+		TableSuffixDaily:   "readings_2024_06_03",
+		TableSuffixMonthly: "readings_2024_06",
+		TableSuffixYearly:  "readings_2024",
+	} {
+		next := newMockStorage()
+		stage := newTableSuffixer(period, nil, next)
+		if err := stage.InsertIntoTable(context.Background(), "readings", map[string]interface{}{"time": at, "v": 1.0}); err != nil {
+			t.Fatalf("InsertIntoTable failed: %v", err)
+		}
+		if next.count(want) != 1 {
+			t.Errorf("%s: expected the record in %s, got %v", period, want, next.inserts)
+		}
+	}
+
+	// RFC 3339 strings count; records without a time use the current one
+	next := newMockStorage()
+	stage := newTableSuffixer(TableSuffixMonthly, nil, next)
+	stage.InsertIntoTable(context.Background(), "readings", map[string]interface{}{"time": "2023-12-31T23:00:00-02:00"})
+	stage.InsertIntoTable(context.Background(), "readings", map[string]interface{}{"v": 1.0})
+	if now := "readings" + time.Now().UTC().Format("_2006_01"); next.count("readings_2024_01") != 1 || next.count(now) != 1 {
+		t.Errorf("Unexpected tables %v", next.inserts)
+	}
+}
+
+func TestTableSuffixerCreatesTables(t *testing.T) {
+	creator := &recordingCreator{}
+	next := &batchStorage{mockStorage: newMockStorage()}
+	stage := newTableSuffixer(TableSuffixMonthly, creator, next)
+	may := time.Date(2024, 5, 31, 23, 59, 0, 0, time.UTC)
+	june := may.Add(2 * time.Minute)
+
+	rows := []map[string]interface{}{
+		{"time": may, "v": 1.0},
+		{"time": june, "v": 2.0},
+		{"time": may, "v": 3.0},
+	}
+	if err := stage.InsertBatch(context.Background(), "readings", rows); err != nil {
+		t.Fatalf("InsertBatch failed: %v", err)
+	}
+	if err := stage.InsertIntoTable(context.Background(), "readings", map[string]interface{}{"time": june}); err != nil {
+		t.Fatalf("InsertIntoTable failed: %v", err)
+	}
+	if len(next.batches) != 2 || next.count("readings_2024_05") != 2 || next.count("readings_2024_06") != 2 {
+		t.Errorf("batches=%v tables=%v, want one batch per period", next.batches, next.inserts)
+	}
+	if fmt.Sprint(creator.created) != "[readings_2024_05 like readings readings_2024_06 like readings]" {
+		t.Errorf("Expected each period table created once, got %v", creator.created)
+	}
+}
+
+// vanishingStorage fails inserts with schema drift while gone is set
+type vanishingStorage struct {
+	*mockStorage
+	gone bool
+}
+
+func (v *vanishingStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	if v.gone {
+		return fmt.Errorf("%w: relation \"%s\" does not exist", errs.ErrSchemaDrift, table)
+	}
+	return v.mockStorage.InsertIntoTable(ctx, table, data)
+}
+
+func TestTableSuffixerRecreatesDroppedTable(t *testing.T) {
+	creator := &recordingCreator{}
+	next := &vanishingStorage{mockStorage: newMockStorage(), gone: true}
+	stage := newTableSuffixer(TableSuffixYearly, creator, next)
+	row := map[string]interface{}{"time": time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	if err := stage.InsertIntoTable(context.Background(), "readings", row); err == nil {
+		t.Fatal("Expected the insert into a dropped table to fail")
+	}
+	next.gone = false
+	if err := stage.InsertIntoTable(context.Background(), "readings", row); err != nil {
+		t.Fatalf("InsertIntoTable failed: %v", err)
+	}
+	if len(creator.created) != 2 {
+		t.Errorf("Expected the dropped table created again, got %v", creator.created)
+	}
+}
+
+func TestRouterTableSuffix(t *testing.T) {
+	storage := newMockStorage()
+	routes := []Route{{Filter: "a/#", Table: "readings", TableSuffix: "weekly"}}
+	if _, err := New(context.Background(), routes, storage, nil); err == nil {
+		t.Error("Expected an invalid table_suffix to be rejected")
+	}
+
+	creator := &recordingCreator{}
+	routes[0].TableSuffix = TableSuffixMonthly
+	r, err := New(context.Background(), routes, storage, nil, WithTableCreator(creator))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	if err := r.Dispatch(Message{Topic: "a/b", Payload: []byte(`{"v": 1}`), Time: time.Now()}); err != nil {
+		t.Fatalf("Dispatch failed: %v", err)
+	}
+	r.Drain()
+	table := "readings" + time.Now().UTC().Format("_2006_01")
+	if storage.count(table) != 1 || len(creator.created) != 1 {
+		t.Errorf("Expected the record in %s, got %v (created %v)", table, storage.inserts, creator.created)
+	}
+}
@@ -0,0 +1,28 @@
+package router
+
+import (
+	"fmt"
+)
+
+// validateTags checks a route's static tags
+func validateTags(tags map[string]string) error {
+	for col := range tags {
+		if !validIdentifier.MatchString(col) {
+			return fmt.Errorf("invalid tag column: %s", col)
+		}
+		if isProvenanceColumn(col) {
+			return fmt.Errorf("tag column %s is reserved for provenance", col)
+		}
+	}
+	return nil
+}
+
+// addTags sets the route's static tags on a record. Columns the script
+// set keep their value, so a transform can override a tag per record.
+func (w *worker) addTags(columns map[string]interface{}) {
+	for col, v := range w.tags {
+		if _, ok := columns[col]; !ok {
+			columns[col] = v
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+)
+
+func TestWorkerTags(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "tags.lua")
+	scriptCode := `
+schema = {
+  tables = {
+    readings = { time = "timestamptz", value = "double precision", site = "text", line = "text" },
+    untagged = { time = "timestamptz", value = "double precision" }
+  }
+}
+
+function transform(msg)
+  return {{ table = msg.json.table, columns = { time = msg.ts, value = 1, line = msg.json.line } }}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+	storage := newMockStorage()
+	w, err := newWorker(1, scriptPath, "readings", nil, storage, context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to create worker: %v", err)
+	}
+	defer w.state.Close()
+	w.tags = map[string]string{"site": "plant-3", "line": "A"}
+
+	send := func(payload string) error {
+		return w.process(Message{Topic: "sensors/a", Payload: []byte(payload), Time: time.Now()})
+	}
+
+	if err := send(`{"table": "readings"}`); err != nil {
+		t.Fatalf("process failed: %v", err)
+	}
+	row := storage.inserts["readings"][0]
+	if row["site"] != "plant-3" || row["line"] != "A" {
+		t.Errorf("Expected tags on the record, got %v", row)
+	}
+
+	// Columns the script sets win over tags
+	if err := send(`{"table": "readings", "line": "B"}`); err != nil {
+		t.Fatalf("process failed: %v", err)
+	}
+	if row := storage.inserts["readings"][1]; row["line"] != "B" || row["site"] != "plant-3" {
+		t.Errorf("Expected the script's line to win, got %v", row)
+	}
+
+	// Tags are validated against the schema like script columns
+	if err := send(`{"table": "untagged"}`); !errors.Is(err, errs.ErrSchemaViolation) {
+		t.Errorf("Expected a schema violation for undeclared tag columns, got %v", err)
+	}
+}
+
+func TestValidateTags(t *testing.T) {
+	if err := validateTags(map[string]string{"site": "plant-3", "line_2": "A"}); err != nil {
+		t.Errorf("validateTags failed: %v", err)
+	}
+	for _, bad := range []map[string]string{{"site name": "x"}, {"": "x"}, {ProvenanceInstance: "x"}} {
+		if err := validateTags(bad); err == nil {
+			t.Errorf("Expected error for %v", bad)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package router
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// maxTapMessages caps how many messages one tap mirrors
+const maxTapMessages = 1000
+
+// TapPublisher publishes tapped messages to a debug topic
+type TapPublisher interface {
+	Publish(topic string, payload []byte) error
+}
+
+// TapEvent is one tapped message: the payload the script received and the
+// records it returned, or its error
+type TapEvent struct {
+	Route   string      `json:"route"`
+	Topic   string      `json:"topic"`
+	Time    time.Time   `json:"time"`
+	Payload string      `json:"payload"`
+	Records []TapRecord `json:"records,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// TapRecord is a record returned by the script, before tags, provenance
+// and the column case policy are applied
+type TapRecord struct {
+	Table   string                 `json:"table,omitempty"`
+	Sink    string                 `json:"sink,omitempty"`
+	Columns map[string]interface{} `json:"columns"`
+}
+
+// tap mirrors the next messages of a route to stdout or a topic
+type tap struct {
+	remaining atomic.Int64
+	topic     string // Debug topic (empty = stdout)
+}
+
+// tapOutput is where tapped messages go
+type tapOutput struct {
+	mu        sync.Mutex
+	stdout    io.Writer
+	publisher atomic.Pointer[TapPublisher]
+}
+
+// SetTapPublisher sets the publisher taps with a topic are sent through
+func (r *Router) SetTapPublisher(p TapPublisher) {
+	r.tapOut.publisher.Store(&p)
+}
+
+// Tap mirrors the next n messages of the route with filter, as JSON, to
+// topic or to stdout when topic is empty. A new tap replaces the route's
+// current one.
+func (r *Router) Tap(filter string, n int, topic string) error {
+	if n <= 0 || n > maxTapMessages {
+		return fmt.Errorf("invalid tap count %d: use 1 to %d", n, maxTapMessages)
+	}
+	if topic != "" && r.tapOut.publisher.Load() == nil {
+		return fmt.Errorf("no MQTT source to publish tapped messages to %s", topic)
+	}
+	for _, h := range r.routes {
+		if h.route.Filter != filter {
+			continue
+		}
+		t := &tap{topic: topic}
+		t.remaining.Store(int64(n))
+		h.tap.Store(t)
+		dest := topic
+		if dest == "" {
+			dest = "stdout"
+		}
+		r.logger.Infof("Route %s: tapping the next %d messages to %s", filter, n, dest)
+		return nil
+	}
+	return fmt.Errorf("route %s not found", filter)
+}
+
+// tapped mirrors a transformed message if the route is tapped
+func (h *routeHandler) tapped(msg Message, records []Record, err error) {
+	t := h.tap.Load()
+	if t == nil {
+		return
+	}
+	n := t.remaining.Add(-1)
+	if n < 0 {
+		return
+	}
+	if n == 0 {
+		h.tap.CompareAndSwap(t, nil)
+	}
+
+	event := TapEvent{Route: h.route.Filter, Topic: msg.Topic, Time: msg.Time, Payload: string(msg.Payload)}
+	for _, rec := range records {
+		event.Records = append(event.Records, TapRecord{Table: rec.Table, Sink: rec.Sink, Columns: rec.Columns})
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	data, mErr := json.Marshal(event)
+	if mErr != nil {
+		h.logger.Errorf("Route %s: failed to encode tapped message: %v", h.route.Filter, mErr)
+		return
+	}
+	if err := h.tapOut.write(t.topic, data); err != nil {
+		h.logger.Errorf("Route %s: failed to publish tapped message: %v", h.route.Filter, err)
+	}
+}
+
+// write sends a tapped message to topic, or stdout when topic is empty
+func (o *tapOutput) write(topic string, data []byte) error {
+	if topic == "" {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		w := o.stdout
+		if w == nil {
+			w = os.Stdout
+		}
+		_, err := fmt.Fprintf(w, "%s\n", data)
+		return err
+	}
+	p := o.publisher.Load()
+	if p == nil {
+		return fmt.Errorf("no publisher for %s", topic)
+	}
+	return (*p).Publish(topic, data)
+}
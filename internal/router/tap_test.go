@@ -0,0 +1,99 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingPublisher records published tap messages
+type recordingPublisher struct {
+	mu       sync.Mutex
+	messages map[string][][]byte
+}
+
+func (p *recordingPublisher) Publish(topic string, payload []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.messages == nil {
+		p.messages = make(map[string][][]byte)
+	}
+	p.messages[topic] = append(p.messages[topic], payload)
+	return nil
+}
+
+func TestRouterTap(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "route.lua")
+	scriptCode := `
+function transform(msg)
+  if msg.json.fail then error("bad reading") end
+  return {{ table = "readings", columns = { v = msg.json.v } }}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	routes := []Route{{Filter: "sensors/+", Script: scriptPath, Workers: 1}}
+	r, err := New(context.Background(), routes, newMockStorage(), nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	var stdout bytes.Buffer
+	r.tapOut.stdout = &stdout
+
+	if err := r.Tap("sensors/+", 2, "debug/sensors"); err == nil {
+		t.Error("Expected a topic tap without a publisher to be rejected")
+	}
+	if err := r.Tap("missing/#", 2, ""); err == nil {
+		t.Error("Expected an unknown route to be rejected")
+	}
+	if err := r.Tap("sensors/+", 0, ""); err == nil {
+		t.Error("Expected a zero count to be rejected")
+	}
+
+	dispatch := func(payload string) {
+		if err := r.Dispatch(Message{Topic: "sensors/a", Payload: []byte(payload), Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	dispatch(`{"v": 0}`) // before the tap
+	if err := r.Tap("sensors/+", 2, ""); err != nil {
+		t.Fatalf("Tap failed: %v", err)
+	}
+	dispatch(`{"v": 1}`)
+	dispatch(`{"fail": true}`)
+	dispatch(`{"v": 3}`) // after the tap ran out
+
+	pub := &recordingPublisher{}
+	r.SetTapPublisher(pub)
+	if err := r.Tap("sensors/+", 1, "debug/sensors"); err != nil {
+		t.Fatalf("Tap failed: %v", err)
+	}
+	dispatch(`{"v": 4}`)
+	r.Close()
+
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 tapped messages on stdout, got %q", stdout.String())
+	}
+	var ok, failed TapEvent
+	json.Unmarshal([]byte(lines[0]), &ok)
+	json.Unmarshal([]byte(lines[1]), &failed)
+	if ok.Route != "sensors/+" || ok.Payload != `{"v": 1}` || len(ok.Records) != 1 || ok.Records[0].Table != "readings" || ok.Records[0].Columns["v"] != 1.0 {
+		t.Errorf("Unexpected tapped message: %+v", ok)
+	}
+	if !strings.Contains(failed.Error, "bad reading") || len(failed.Records) != 0 {
+		t.Errorf("Expected the script error to be tapped, got %+v", failed)
+	}
+	if got := pub.messages["debug/sensors"]; len(got) != 1 || !strings.Contains(string(got[0]), `"payload":"{\"v\": 4}"`) {
+		t.Errorf("Unexpected published taps: %q", got)
+	}
+}
@@ -0,0 +1,34 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/marcgeld/hermod/internal/errs"
+)
+
+// processWithin processes msg within the route's processing timeout. The
+// transform and the inserts share the deadline, so one pathological
+// message fails with errs.ErrTimeout instead of holding the worker.
+func (w *worker) processWithin(msg Message) error {
+	if w.timeout <= 0 {
+		return w.process(msg)
+	}
+	parent := w.ctx
+	ctx, cancel := context.WithTimeout(parent, w.timeout)
+	w.ctx = ctx
+	defer func() {
+		cancel()
+		w.ctx = parent
+	}()
+
+	err := w.process(msg)
+	if err == nil || !errors.Is(ctx.Err(), context.DeadlineExceeded) || parent.Err() != nil {
+		return err
+	}
+	if w.handler != nil {
+		w.handler.timeouts.Add(1)
+	}
+	return fmt.Errorf("%w after %s on %s: %w", errs.ErrTimeout, w.timeout, msg.Topic, err)
+}
@@ -0,0 +1,80 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+)
+
+// stallingStorage blocks inserts into "slow" until the context is done
+type stallingStorage struct {
+	*mockStorage
+}
+
+func (s stallingStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	if table == "slow" {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return s.mockStorage.InsertIntoTable(ctx, table, data)
+}
+
+func TestWorkerProcessingTimeout(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "slow.lua")
+	scriptCode := `
+function transform(msg)
+  if msg.json.spin then while true do end end
+  return {{ table = msg.json.table, columns = { v = 1 } }}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+	storage := stallingStorage{newMockStorage()}
+	w, err := newWorker(1, scriptPath, "fast", nil, storage, context.Background(), nil)
+	if err != nil {
+		t.Fatalf("failed to create worker: %v", err)
+	}
+	defer w.state.Close()
+	w.timeout = 50 * time.Millisecond
+	w.handler = &routeHandler{}
+
+	send := func(payload string) error {
+		return w.processWithin(Message{Topic: "sensors/a", Payload: []byte(payload), Time: time.Now()})
+	}
+
+	for _, payload := range []string{`{"spin": true}`, `{"table": "slow"}`} {
+		start := time.Now()
+		err := send(payload)
+		if !errors.Is(err, errs.ErrTimeout) || !strings.Contains(err.Error(), "sensors/a") {
+			t.Errorf("%s: expected a timeout naming the topic, got %v", payload, err)
+		}
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("%s: timeout took %s", payload, elapsed)
+		}
+	}
+	if n := w.handler.timeouts.Load(); n != 2 {
+		t.Errorf("timeouts = %d, want 2", n)
+	}
+
+	// The worker keeps its context and handles the next message
+	if err := send(`{"table": "fast"}`); err != nil {
+		t.Fatalf("process after timeout failed: %v", err)
+	}
+	if storage.count("fast") != 1 {
+		t.Errorf("Expected one stored row, got %d", storage.count("fast"))
+	}
+}
+
+func TestRouterRejectsNegativeProcessingTimeout(t *testing.T) {
+	routes := []Route{{Filter: "a/#", ProcessingTimeout: -time.Second}}
+	if _, err := New(context.Background(), routes, newMockStorage(), nil); err == nil {
+		t.Error("Expected error for a negative processing_timeout")
+	}
+}
@@ -0,0 +1,143 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Timestamp configures how a route interprets device timestamps
+type Timestamp struct {
+	Field    string         // Dotted JSON path of the device timestamp (e.g. "ts", "meta.time"); empty = arrival time
+	Unit     string         // Unit of numeric epoch timestamps: "s" (default), "ms" or "us"
+	Location *time.Location // Zone of timestamps without a UTC offset (nil = UTC)
+}
+
+// timestampLayouts are the string formats accepted for device timestamps;
+// layouts without an offset are read in the route's Location
+var timestampLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+}
+
+// Validate checks the timestamp configuration
+func (t *Timestamp) Validate() error {
+	switch t.Unit {
+	case "", "s", "ms", "us":
+	default:
+		return fmt.Errorf("invalid timestamp unit %q: use s, ms or us", t.Unit)
+	}
+	if t.Field != "" {
+		for _, key := range strings.Split(t.Field, ".") {
+			if key == "" {
+				return fmt.Errorf("invalid timestamp field %q", t.Field)
+			}
+		}
+	}
+	return nil
+}
+
+// timestampParser resolves device timestamps for a route
+type timestampParser struct {
+	path []string
+	unit time.Duration
+	loc  *time.Location
+}
+
+// newTimestampParser creates a parser for cfg
+func newTimestampParser(cfg Timestamp) *timestampParser {
+	p := &timestampParser{unit: time.Second, loc: cfg.Location}
+	if cfg.Field != "" {
+		p.path = strings.Split(cfg.Field, ".")
+	}
+	switch cfg.Unit {
+	case "ms":
+		p.unit = time.Millisecond
+	case "us":
+		p.unit = time.Microsecond
+	}
+	if p.loc == nil {
+		p.loc = time.UTC
+	}
+	return p
+}
+
+// messageTime returns the device timestamp of a message in UTC, falling back
+// to the arrival time when the field is missing or unparseable
+func (p *timestampParser) messageTime(msg Message, doc parsedJSON) time.Time {
+	if p.path != nil && doc.ok {
+		v := doc.value
+		for _, key := range p.path {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				v = nil
+				break
+			}
+			v = m[key]
+		}
+		if t, ok := p.parse(v); ok {
+			return t
+		}
+	}
+	return msg.Time.UTC()
+}
+
+// parse converts an epoch number, numeric string or formatted time to UTC
+func (p *timestampParser) parse(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t.UTC(), true
+	case float64:
+		return p.epoch(t), true
+	case int64:
+		return p.epoch(float64(t)), true
+	case int:
+		return p.epoch(float64(t)), true
+	case string:
+		s := strings.TrimSpace(t)
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return p.epoch(f), true
+		}
+		for _, layout := range timestampLayouts {
+			if parsed, err := time.ParseInLocation(layout, s, p.loc); err == nil {
+				return parsed.UTC(), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// epoch converts a number of units since the Unix epoch to UTC
+func (p *timestampParser) epoch(n float64) time.Time {
+	return time.Unix(0, int64(n*float64(p.unit))).UTC()
+}
+
+// timeNormalizer is a Storage stage that stores the "time" column as a UTC
+// timestamp, so records from devices in different zones line up
+type timeNormalizer struct {
+	parser *timestampParser
+	next   Storage
+}
+
+// InsertIntoTable normalizes the record's time and forwards it
+func (n *timeNormalizer) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	v, ok := data["time"]
+	if !ok || v == nil {
+		return n.next.InsertIntoTable(ctx, table, data)
+	}
+	t, ok := n.parser.parse(v)
+	if !ok {
+		return n.next.InsertIntoTable(ctx, table, data)
+	}
+
+	normalized := make(map[string]interface{}, len(data))
+	for k, v := range data {
+		normalized[k] = v
+	}
+	normalized["time"] = t
+	return n.next.InsertIntoTable(ctx, table, normalized)
+}
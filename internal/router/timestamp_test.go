@@ -0,0 +1,92 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestTimestampValidate(t *testing.T) {
+	if err := (&Timestamp{Unit: "ns"}).Validate(); err == nil {
+		t.Error("Expected error for unknown unit")
+	}
+	if err := (&Timestamp{Field: "meta..ts"}).Validate(); err == nil {
+		t.Error("Expected error for empty path element")
+	}
+	if err := (&Timestamp{Field: "meta.ts", Unit: "us"}).Validate(); err != nil {
+		t.Errorf("Expected valid timestamp, got %v", err)
+	}
+}
+
+func TestTimestampParse(t *testing.T) {
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+	want := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		cfg  Timestamp
+		v    interface{}
+	}{
+		{"seconds", Timestamp{}, float64(want.Unix())},
+		{"milliseconds", Timestamp{Unit: "ms"}, float64(want.UnixMilli())},
+		{"microseconds string", Timestamp{Unit: "us"}, "1717236000000000"},
+		{"RFC 3339 with offset", Timestamp{Location: stockholm}, "2024-06-01T12:00:00+02:00"},
+		{"local time in zone", Timestamp{Location: stockholm}, "2024-06-01 12:00:00"},
+		{"time value", Timestamp{}, want.In(stockholm)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := newTimestampParser(tt.cfg).parse(tt.v)
+			if !ok || !got.Equal(want) || got.Location() != time.UTC {
+				t.Errorf("parse(%v) = %v, %v; want %v UTC", tt.v, got, ok, want)
+			}
+		})
+	}
+
+	if _, ok := newTimestampParser(Timestamp{}).parse("yesterday"); ok {
+		t.Error("Expected unparseable timestamp to be rejected")
+	}
+}
+
+func TestRouterTimestamp(t *testing.T) {
+	stockholm, err := time.LoadLocation("Europe/Stockholm")
+	if err != nil {
+		t.Skipf("time zone data unavailable: %v", err)
+	}
+
+	storage := newMockStorage()
+	routes := []Route{{Filter: "sensors/+", Table: "readings", Timestamp: &Timestamp{Field: "meta.ts", Unit: "ms", Location: stockholm}}}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	arrival := time.Date(2024, 6, 1, 12, 5, 0, 0, stockholm)
+	payloads := []string{
+		`{"meta": {"ts": 1717236000000}}`,
+		`{"meta": {"ts": "2024-06-01 12:00:00"}}`,
+		`{"meta": {}}`,
+	}
+	for _, p := range payloads {
+		if err := r.Dispatch(Message{Topic: "sensors/a", Payload: []byte(p), Time: arrival}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	r.Close()
+
+	rows := storage.inserts["readings"]
+	if len(rows) != 3 {
+		t.Fatalf("Expected 3 rows, got %d", len(rows))
+	}
+	device := time.Date(2024, 6, 1, 10, 0, 0, 0, time.UTC)
+	for i, want := range []time.Time{device, device, arrival.UTC()} {
+		got, ok := rows[i]["time"].(time.Time)
+		if !ok || !got.Equal(want) || got.Location() != time.UTC {
+			t.Errorf("row %d time = %v, want %v UTC", i, rows[i]["time"], want)
+		}
+	}
+}
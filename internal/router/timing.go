@@ -0,0 +1,111 @@
+package router
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// transformBuckets are the upper bounds of the transform duration histogram
+var transformBuckets = [...]time.Duration{
+	100 * time.Microsecond,
+	250 * time.Microsecond,
+	500 * time.Microsecond,
+	time.Millisecond,
+	2500 * time.Microsecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	25 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	250 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// TransformTiming summarizes how long a route's transforms took
+type TransformTiming struct {
+	Count   int64          `json:"count"`    // Transforms timed
+	TotalMs float64        `json:"total_ms"` // Time spent in them
+	MaxMs   float64        `json:"max_ms"`   // Longest one
+	Slow    int64          `json:"slow"`     // Transforms over the slow threshold
+	Buckets []TimingBucket `json:"buckets"`  // Cumulative histogram; Count includes slower ones
+}
+
+// TimingBucket counts the transforms that took at most LeMs milliseconds
+type TimingBucket struct {
+	LeMs  float64 `json:"le_ms"`
+	Count int64   `json:"count"`
+}
+
+// WithSlowTransform logs transforms that take longer than threshold, with
+// the route, script and topic (at most once a minute per route)
+func WithSlowTransform(threshold time.Duration) Option {
+	return func(r *Router) {
+		r.slowTransform = threshold
+	}
+}
+
+// transformTimer measures a route's transforms; the zero value records
+// durations without logging slow ones
+type transformTimer struct {
+	buckets [len(transformBuckets) + 1]atomic.Int64 // Last one: slower than every bound
+	total   atomic.Int64                            // Nanoseconds
+	max     atomic.Int64                            // Nanoseconds
+	slow    atomic.Int64
+
+	threshold time.Duration // Slow transform threshold (0 = not logged)
+	log       logThrottle
+}
+
+// observe records one transform of d and reports whether it was slow
+func (t *transformTimer) observe(d time.Duration) bool {
+	i := 0
+	for i < len(transformBuckets) && d > transformBuckets[i] {
+		i++
+	}
+	t.buckets[i].Add(1)
+	t.total.Add(int64(d))
+	for {
+		m := t.max.Load()
+		if int64(d) <= m || t.max.CompareAndSwap(m, int64(d)) {
+			break
+		}
+	}
+	if t.threshold <= 0 || d <= t.threshold {
+		return false
+	}
+	t.slow.Add(1)
+	return true
+}
+
+// snapshot returns the timings recorded so far
+func (t *transformTimer) snapshot() TransformTiming {
+	s := TransformTiming{
+		TotalMs: millis(time.Duration(t.total.Load())),
+		MaxMs:   millis(time.Duration(t.max.Load())),
+		Slow:    t.slow.Load(),
+		Buckets: make([]TimingBucket, len(transformBuckets)),
+	}
+	for i, bound := range transformBuckets {
+		s.Count += t.buckets[i].Load()
+		s.Buckets[i] = TimingBucket{LeMs: millis(bound), Count: s.Count}
+	}
+	s.Count += t.buckets[len(transformBuckets)].Load()
+	return s
+}
+
+// millis converts d to fractional milliseconds
+func millis(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// timeTransform records how long the transform of msg took and logs it when slow
+func (w *worker) timeTransform(msg Message, d time.Duration) {
+	if w.handler == nil || !w.handler.timing.observe(d) {
+		return
+	}
+	if n, ok := w.handler.timing.log.event(); ok {
+		w.logger.Warnf("Route %s: %d slow transforms over %s (last %s by script %s for topic %s)",
+			w.handler.route.Filter, n, w.handler.timing.threshold, d.Round(time.Microsecond), w.script, msg.Topic)
+	}
+}
@@ -0,0 +1,72 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTransformTimer(t *testing.T) {
+	timer := &transformTimer{threshold: 10 * time.Millisecond}
+	for _, d := range []time.Duration{50 * time.Microsecond, 3 * time.Millisecond, 20 * time.Millisecond, 2 * time.Second} {
+		timer.observe(d)
+	}
+	s := timer.snapshot()
+	if s.Count != 4 || s.Slow != 2 || s.MaxMs != 2000 {
+		t.Errorf("snapshot = %+v, want 4 transforms, 2 slow, max 2000ms", s)
+	}
+	want := map[float64]int64{0.1: 1, 2.5: 1, 5: 2, 25: 3, 1000: 3}
+	for _, b := range s.Buckets {
+		if n, ok := want[b.LeMs]; ok && b.Count != n {
+			t.Errorf("bucket le %gms = %d, want %d", b.LeMs, b.Count, n)
+		}
+	}
+	if s.TotalMs < 2023 || s.TotalMs > 2024 {
+		t.Errorf("total = %gms, want 2023.05", s.TotalMs)
+	}
+}
+
+func TestRouterSlowTransform(t *testing.T) {
+	scriptPath := filepath.Join(t.TempDir(), "slow.lua")
+	scriptCode := `
+function transform(msg)
+  local s = stats()
+  if msg.json.slow then
+    local x = 0
+    for i = 1, 1000000 do x = x + i end
+  end
+  return {{columns = {avg = s.transform_avg_ms, max = s.transform_max_ms, slow = s.slow_transforms}}}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+
+	storage := newMockStorage()
+	routes := []Route{{Filter: "sensors/+", Script: scriptPath, Workers: 1, Table: "timing"}}
+	r, err := New(context.Background(), routes, storage, nil, WithSlowTransform(time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+	for _, payload := range []string{`{"slow": true}`, `{}`} {
+		if err := r.Dispatch(Message{Topic: "sensors/a", Payload: []byte(payload), Time: time.Now()}); err != nil {
+			t.Fatalf("Dispatch failed: %v", err)
+		}
+	}
+	r.Drain()
+	status := r.RouteStatus()[0]
+	r.Close()
+
+	if status.Transform.Count != 2 || status.Transform.Slow != 1 || status.Transform.MaxMs <= 1 {
+		t.Errorf("Transform timing = %+v, want 2 transforms, 1 slow", status.Transform)
+	}
+	rows := storage.inserts["timing"]
+	if len(rows) != 2 {
+		t.Fatalf("Expected 2 rows, got %d", len(rows))
+	}
+	if rows[1]["slow"] != 1.0 || rows[1]["max"].(float64) <= 1 || rows[1]["avg"] != rows[1]["max"] {
+		t.Errorf("Expected the slow transform in stats(), got %v", rows[1])
+	}
+}
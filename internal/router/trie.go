@@ -0,0 +1,124 @@
+package router
+
+import (
+	"fmt"
+	"strings"
+)
+
+// topicTrie indexes route filters by topic level so a topic is matched
+// against all routes in a single walk instead of comparing every filter.
+// Match returns the first route in configuration order, like a linear scan.
+type topicTrie struct {
+	root *trieNode
+}
+
+// trieNode is one topic level of the trie
+type trieNode struct {
+	children map[string]*trieNode
+	plus     *trieNode // "+" single-level wildcard
+	end      int       // Lowest route index whose filter ends here (-1 = none)
+	hash     int       // Lowest route index with a "#" filter ending here (-1 = none)
+}
+
+// validateFilter checks an MQTT topic filter. Malformed filters are
+// rejected when routes load, since the matcher would silently never match them.
+// Empty levels ("a//b", "/a", "a/") are valid and match empty topic levels.
+func validateFilter(filter string) error {
+	if filter == "" {
+		return fmt.Errorf("empty topic filter")
+	}
+	levels := strings.Split(filter, "/")
+	for i, level := range levels {
+		n := i + 1
+		switch {
+		case level == "#":
+			if i != len(levels)-1 {
+				return fmt.Errorf("invalid topic filter %q: '#' must be the last level, found at level %d of %d", filter, n, len(levels))
+			}
+		case level == "+":
+		case level == "+#" || level == "#+":
+			return fmt.Errorf("invalid topic filter %q: level %d %q combines wildcards (did you mean \"+/#\"?)", filter, n, level)
+		case strings.ContainsAny(level, "+#"):
+			return fmt.Errorf("invalid topic filter %q: level %d %q mixes a wildcard with text; '+' and '#' must be a whole level", filter, n, level)
+		}
+	}
+	return nil
+}
+
+// newTrieNode creates an empty node
+func newTrieNode() *trieNode {
+	return &trieNode{end: -1, hash: -1}
+}
+
+// newTopicTrie builds a trie from filters; index i refers to filters[i]
+func newTopicTrie(filters []string) *topicTrie {
+	t := &topicTrie{root: newTrieNode()}
+	for i, f := range filters {
+		t.insert(f, i)
+	}
+	return t
+}
+
+// insert adds a filter. Filters with "#" anywhere but the last level never match and are skipped;
+// validateFilter rejects them before routes are built.
+func (t *topicTrie) insert(filter string, idx int) {
+	levels := strings.Split(filter, "/")
+	node := t.root
+	for i, level := range levels {
+		switch level {
+		case "#":
+			if i != len(levels)-1 {
+				return
+			}
+			node.hash = minIndex(node.hash, idx)
+			return
+		case "+":
+			if node.plus == nil {
+				node.plus = newTrieNode()
+			}
+			node = node.plus
+		default:
+			if node.children == nil {
+				node.children = make(map[string]*trieNode)
+			}
+			child, ok := node.children[level]
+			if !ok {
+				child = newTrieNode()
+				node.children[level] = child
+			}
+			node = child
+		}
+	}
+	node.end = minIndex(node.end, idx)
+}
+
+// match returns the lowest route index matching topic, or -1
+func (t *topicTrie) match(topic string) int {
+	return t.root.match(topic, true)
+}
+
+// match walks the remaining topic levels; more is false once all levels are consumed
+func (n *trieNode) match(rest string, more bool) int {
+	// "#" matches the parent level and everything below it
+	best := n.hash
+	if !more {
+		return minIndex(best, n.end)
+	}
+
+	level, next, hasNext := strings.Cut(rest, "/")
+	if child, ok := n.children[level]; ok {
+		best = minIndex(best, child.match(next, hasNext))
+	}
+	if n.plus != nil {
+		best = minIndex(best, n.plus.match(next, hasNext))
+	}
+	return best
+}
+
+// minIndex returns the smaller valid route index (-1 = none)
+func minIndex(a, b int) int {
+	if a < 0 || (b >= 0 && b < a) {
+		return b
+	}
+	return a
+}
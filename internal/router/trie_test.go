@@ -0,0 +1,163 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// linearMatch is the scan Dispatch used before the trie
+func linearMatch(filters []string, topic string) int {
+	for i, f := range filters {
+		if topicMatches(f, topic) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestTopicTrieFirstMatch(t *testing.T) {
+	filters := []string{
+		"ruuvi/+",
+		"devices/+/telemetry",
+		"devices/#",
+		"ruuvi/abc",
+		"p1ib/#",
+		"a/#/b", // invalid: never matches
+		"#",
+	}
+	trie := newTopicTrie(filters)
+
+	tests := []struct {
+		topic string
+		want  int
+	}{
+		{"ruuvi/abc", 0}, // earlier wildcard route wins over later exact route
+		{"devices/x/telemetry", 1},
+		{"devices/x/status", 2},
+		{"devices", 2}, // "#" also matches the parent level
+		{"p1ib", 4},
+		{"p1ib/meter/1", 4},
+		{"a/x/b", 6},
+		{"other", 6},
+		{"ruuvi//x", 6},
+	}
+	for _, tt := range tests {
+		if got := trie.match(tt.topic); got != tt.want {
+			t.Errorf("match(%q) = %d, want %d", tt.topic, got, tt.want)
+		}
+	}
+
+	if got := newTopicTrie([]string{"ruuvi/+"}).match("p1ib/x"); got != -1 {
+		t.Errorf("match without matching route = %d, want -1", got)
+	}
+
+	// Empty levels are ordinary levels
+	empty := newTopicTrie([]string{"/foo/#", "foo//bar", "a/+"})
+	for topic, want := range map[string]int{"/foo/bar": 0, "/foo": 0, "foo/bar": -1, "foo//bar": 1, "foo/x/bar": -1, "a/": 2} {
+		if got := empty.match(topic); got != want {
+			t.Errorf("match(%q) = %d, want %d", topic, got, want)
+		}
+	}
+}
+
+func TestTopicTrieAgreesWithLinearScan(t *testing.T) {
+	filters := []string{
+		"a/b/c", "a/+/c", "a/#", "+/b/#", "+/+", "+", "a//c", "a/+/+/d", "b/#", "a/b",
+	}
+	topics := []string{
+		"a", "b", "a/b", "a/b/c", "a/x/c", "a//c", "a/b/c/d", "x/b", "x/b/y", "b", "b/c/d", "", "/", "x",
+	}
+
+	// Check every suffix of the filter list so each filter gets to be "first"
+	for start := range filters {
+		fs := filters[start:]
+		trie := newTopicTrie(fs)
+		for _, topic := range topics {
+			if got, want := trie.match(topic), linearMatch(fs, topic); got != want {
+				t.Errorf("filters %v, topic %q: trie = %d, linear = %d", fs, topic, got, want)
+			}
+		}
+	}
+}
+
+func TestValidateFilter(t *testing.T) {
+	for _, filter := range []string{"#", "+", "ruuvi/+", "p1ib/#", "ruuvi/+/#", "+/+/telemetry", "$SYS/#", "/foo/#", "foo//bar", "a/"} {
+		if err := validateFilter(filter); err != nil {
+			t.Errorf("validateFilter(%q) = %v, want nil", filter, err)
+		}
+	}
+
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		{"", "empty topic filter"},
+		{"a/#/b", "'#' must be the last level, found at level 2 of 3"},
+		{"#/a", "found at level 1 of 2"},
+		{"ruuvi/+#", `level 2 "+#" combines wildcards (did you mean "+/#"?)`},
+		{"ruuvi/#+", `level 2 "#+" combines wildcards`},
+		{"ruuvi/sensor+", `level 2 "sensor+" mixes a wildcard with text`},
+		{"ruuvi#", `level 1 "ruuvi#" mixes a wildcard with text`},
+	}
+	for _, tt := range tests {
+		err := validateFilter(tt.filter)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("validateFilter(%q) = %v, want error containing %q", tt.filter, err, tt.want)
+		}
+	}
+}
+
+func TestRouterRejectsMalformedFilters(t *testing.T) {
+	for _, filter := range []string{"a/#/b", "ruuvi/+#"} {
+		r, err := New(context.Background(), []Route{{Filter: filter}}, newMockStorage(), nil)
+		if err == nil {
+			r.Close()
+			t.Errorf("Expected route %q to be rejected", filter)
+			continue
+		}
+		if !strings.Contains(err.Error(), "invalid topic filter") {
+			t.Errorf("route %q: unexpected error %v", filter, err)
+		}
+	}
+
+	deny := TopicFilter{Deny: []string{"debug/#/x"}}
+	if _, err := New(context.Background(), nil, newMockStorage(), nil, WithTopicFilter(deny)); err == nil {
+		t.Error("Expected malformed deny filter to be rejected")
+	}
+}
+
+// benchmarkFilters returns n distinct route filters of mixed shapes
+func benchmarkFilters(n int) []string {
+	filters := make([]string, n)
+	for i := range filters {
+		switch i % 3 {
+		case 0:
+			filters[i] = fmt.Sprintf("site%d/+/telemetry", i)
+		case 1:
+			filters[i] = fmt.Sprintf("site%d/devices/#", i)
+		default:
+			filters[i] = fmt.Sprintf("site%d/meter/power", i)
+		}
+	}
+	return filters
+}
+
+func BenchmarkRouteMatchLinear(b *testing.B) {
+	filters := benchmarkFilters(150)
+	topic := "site148/meter/power"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		linearMatch(filters, topic)
+	}
+}
+
+func BenchmarkRouteMatchTrie(b *testing.B) {
+	trie := newTopicTrie(benchmarkFilters(150))
+	topic := "site148/meter/power"
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		trie.match(topic)
+	}
+}
@@ -0,0 +1,65 @@
+package router
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"github.com/marcgeld/hermod/internal/jsonschema"
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// payloadValidator checks payloads against a route's JSON Schema before the
+// transform runs, and counts (and optionally stores) the ones that fail
+type payloadValidator struct {
+	schema *jsonschema.Schema
+	name   string // Route filter, for log messages
+	dlq    *deadLetter
+	logger *logger.Logger
+	count  atomic.Int64 // Rejected payloads since startup
+	log    logThrottle
+}
+
+// newPayloadValidator loads the route's schema (nil when the route has none)
+func newPayloadValidator(route Route, dlq *deadLetter, log *logger.Logger) (*payloadValidator, error) {
+	if route.PayloadSchema == "" {
+		return nil, nil
+	}
+	s, err := jsonschema.Load(route.PayloadSchema)
+	if err != nil {
+		return nil, err
+	}
+	return &payloadValidator{schema: s, name: "Route " + route.Filter, dlq: dlq, logger: log}, nil
+}
+
+// check validates the decoded payload. It returns false when the message
+// was rejected; the error reports a failure to store the rejected payload.
+func (v *payloadValidator) check(msg Message, doc parsedJSON) (bool, error) {
+	verr := v.validate(doc)
+	if verr == nil {
+		return true, nil
+	}
+	v.count.Add(1)
+	if n, ok := v.log.event(); ok {
+		v.logger.Errorf("%s: rejected %d payloads failing its schema (last from %s: %v)", v.name, n, msg.Topic, verr)
+	}
+	return false, v.dlq.store(msg, doc, verr)
+}
+
+// validate checks the decoded payload against the schema (nil without one)
+func (v *payloadValidator) validate(doc parsedJSON) error {
+	if v == nil {
+		return nil
+	}
+	if !doc.ok {
+		return fmt.Errorf("payload could not be decoded")
+	}
+	return v.schema.Validate(doc.value)
+}
+
+// invalid returns the number of rejected payloads (0 without a schema)
+func (v *payloadValidator) invalid() int64 {
+	if v == nil {
+		return 0
+	}
+	return v.count.Load()
+}
@@ -0,0 +1,84 @@
+package router
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouterPayloadSchema(t *testing.T) {
+	tmpDir := t.TempDir()
+	schemaPath := filepath.Join(tmpDir, "reading.json")
+	schemaDoc := `{"type": "object", "required": ["temperature"], "properties": {"temperature": {"type": "number"}}}`
+	if err := os.WriteFile(schemaPath, []byte(schemaDoc), 0644); err != nil {
+		t.Fatalf("failed to write schema: %v", err)
+	}
+	scriptPath := filepath.Join(tmpDir, "reading.lua")
+	scriptCode := `
+function transform(msg)
+  return {{ table = "readings", columns = { time = msg.ts, temperature = msg.json.temperature * 1 } }}
+end
+`
+	if err := os.WriteFile(scriptPath, []byte(scriptCode), 0644); err != nil {
+		t.Fatalf("failed to write script: %v", err)
+	}
+
+	storage := newMockStorage()
+	routes := []Route{
+		{Filter: "dlq/+", Script: scriptPath, PayloadSchema: schemaPath, RejectTable: "rejected"},
+		{Filter: "drop/+", Script: scriptPath, PayloadSchema: schemaPath},
+	}
+	r, err := New(context.Background(), routes, storage, nil)
+	if err != nil {
+		t.Fatalf("Failed to create router: %v", err)
+	}
+
+	payloads := []string{`{"temperature": 21.5}`, `{"temperature": "hot"}`, `{}`, `not json`}
+	for _, topic := range []string{"dlq/a", "drop/a"} {
+		for _, p := range payloads {
+			if err := r.Dispatch(Message{Topic: topic, Payload: []byte(p), Time: time.Now()}); err != nil {
+				t.Fatalf("Dispatch failed: %v", err)
+			}
+		}
+	}
+	time.Sleep(50 * time.Millisecond)
+	r.Close()
+
+	if got := storage.count("readings"); got != 2 {
+		t.Errorf("readings rows = %d, want 2", got)
+	}
+	rows := storage.inserts["rejected"]
+	if len(rows) != 3 {
+		t.Fatalf("rejected rows = %d, want 3", len(rows))
+	}
+	if e, _ := rows[0]["error"].(string); !strings.Contains(e, "/temperature: expected number, got string") {
+		t.Errorf("rejected error = %q, want the schema violation", e)
+	}
+	if rows[0]["raw"] != `{"temperature": "hot"}` || rows[0]["topic"] != "dlq/a" {
+		t.Errorf("Expected the original message in the rejected row, got %v", rows[0])
+	}
+	for _, s := range r.RouteStatus() {
+		if s.Invalid != 3 {
+			t.Errorf("%s invalid = %d, want 3", s.Filter, s.Invalid)
+		}
+	}
+}
+
+func TestRouterPayloadSchemaValidation(t *testing.T) {
+	badSchema := filepath.Join(t.TempDir(), "bad.json")
+	os.WriteFile(badSchema, []byte(`{"type": "float"}`), 0644)
+
+	for _, route := range []Route{
+		{Filter: "a/+", RejectTable: "rejected"},
+		{Filter: "a/+", PayloadSchema: badSchema},
+		{Filter: "a/+", PayloadSchema: filepath.Join(t.TempDir(), "missing.json")},
+		{Filter: "a/+", PayloadSchema: badSchema, RejectTable: "bad table"},
+	} {
+		if _, err := New(context.Background(), []Route{route}, newMockStorage(), nil); err == nil {
+			t.Errorf("Expected error for %+v", route)
+		}
+	}
+}
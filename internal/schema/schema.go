@@ -3,25 +3,80 @@ package schema
 import (
 	"fmt"
 	"regexp"
+	"runtime"
 	"sort"
 	"strings"
+	"sync"
 
 	lua "github.com/yuin/gopher-lua"
 )
 
 // TableSchema represents a database table schema
 type TableSchema struct {
-	Name    string
-	Columns map[string]string // column name -> SQL type
+	Name       string
+	Columns    map[string]string // column name -> SQL type
+	Hypertable *Hypertable       // TimescaleDB hypertable settings (nil = plain table)
+	Retention  *Retention        // Row expiry (nil = rows are kept)
 }
 
+// Hypertable holds the TimescaleDB settings of a table declared in
+// schema.hypertables (e.g., readings = {time = "time", chunk_interval = "1 day",
+// partition = "sensor_id", partitions = 4})
+type Hypertable struct {
+	TimeColumn    string // Partitioning time column (default: "time")
+	ChunkInterval string // Chunk width as a PostgreSQL interval (empty = TimescaleDB default)
+	Partition     string // Column hashed into space partitions (empty = time only)
+	Partitions    int    // Number of space partitions (default: 4)
+}
+
+// Retention holds the expiry of a table declared in schema.retention
+// (e.g., diagnostics = "3 days" or diagnostics = {after = "3 days", column = "seen_at"})
+type Retention struct {
+	After  string // Age, as a PostgreSQL interval, after which rows are deleted
+	Column string // Timestamp column the age is measured by (default: the hypertable time column, or "time")
+}
+
+// defaultPartitions is the space partition count when none is declared
+const defaultPartitions = 4
+
 // Schema represents the complete schema from a Lua script
 type Schema struct {
 	Tables map[string]*TableSchema
 }
 
-// validIdentifier ensures table/column names are safe for SQL
-var validIdentifier = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+var (
+	// validIdentifier ensures table/column names are safe for SQL
+	validIdentifier = regexp.MustCompile(`^[A-Za-z0-9_]+$`)
+	// validInterval ensures chunk intervals are plain PostgreSQL interval literals
+	validInterval = regexp.MustCompile(`^[0-9]+ ?(microseconds?|milliseconds?|seconds?|minutes?|hours?|days?|weeks?|months?|years?)$`)
+)
+
+// LoadFromLuaScripts loads the schemas of several scripts concurrently, each
+// in its own Lua state. Schemas are returned in the order of scriptPaths; on
+// failure the error of the first failing script is returned.
+func LoadFromLuaScripts(scriptPaths []string) ([]*Schema, error) {
+	schemas := make([]*Schema, len(scriptPaths))
+	errs := make([]error, len(scriptPaths))
+	sem := make(chan struct{}, runtime.GOMAXPROCS(0))
+	var wg sync.WaitGroup
+	for i, path := range scriptPaths {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			schemas[i], errs[i] = LoadFromLuaScript(path)
+		}()
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return nil, fmt.Errorf("failed to load schema from %s: %w", scriptPaths[i], err)
+		}
+	}
+	return schemas, nil
+}
 
 // LoadFromLuaScript loads schema definitions from a Lua script file
 func LoadFromLuaScript(scriptPath string) (*Schema, error) {
@@ -106,9 +161,151 @@ func LoadFromLuaScript(scriptPath string) (*Schema, error) {
 		schema.Tables[tableNameStr] = tableSchema
 	})
 
+	if err := loadHypertables(schemaTable, schema); err != nil {
+		return nil, err
+	}
+	if err := loadRetention(schemaTable, schema); err != nil {
+		return nil, err
+	}
+
 	return schema, nil
 }
 
+// loadHypertables reads schema.hypertables into the tables it names
+func loadHypertables(schemaTable *lua.LTable, schema *Schema) error {
+	hypertablesLV := schemaTable.RawGetString("hypertables")
+	if hypertablesLV.Type() == lua.LTNil {
+		return nil
+	}
+	hypertables, ok := hypertablesLV.(*lua.LTable)
+	if !ok {
+		return fmt.Errorf("schema.hypertables must be a table")
+	}
+
+	var err error
+	hypertables.ForEach(func(key, value lua.LValue) {
+		if err != nil {
+			return
+		}
+		name := key.String()
+		table, ok := schema.Tables[name]
+		if !ok {
+			err = fmt.Errorf("hypertable '%s' is not declared in schema.tables", name)
+			return
+		}
+		settings, ok := value.(*lua.LTable)
+		if !ok {
+			err = fmt.Errorf("schema.hypertables.%s must be a table", name)
+			return
+		}
+		h := &Hypertable{
+			TimeColumn:    lua.LVAsString(settings.RawGetString("time")),
+			ChunkInterval: lua.LVAsString(settings.RawGetString("chunk_interval")),
+			Partition:     lua.LVAsString(settings.RawGetString("partition")),
+			Partitions:    int(lua.LVAsNumber(settings.RawGetString("partitions"))),
+		}
+		if err = h.validate(table); err != nil {
+			err = fmt.Errorf("hypertable '%s': %w", name, err)
+			return
+		}
+		table.Hypertable = h
+	})
+	return err
+}
+
+// loadRetention reads schema.retention into the tables it names
+func loadRetention(schemaTable *lua.LTable, schema *Schema) error {
+	retentionLV := schemaTable.RawGetString("retention")
+	if retentionLV.Type() == lua.LTNil {
+		return nil
+	}
+	retention, ok := retentionLV.(*lua.LTable)
+	if !ok {
+		return fmt.Errorf("schema.retention must be a table")
+	}
+
+	var err error
+	retention.ForEach(func(key, value lua.LValue) {
+		if err != nil {
+			return
+		}
+		name := key.String()
+		table, ok := schema.Tables[name]
+		if !ok {
+			err = fmt.Errorf("retention of '%s': table is not declared in schema.tables", name)
+			return
+		}
+		r := &Retention{}
+		switch v := value.(type) {
+		case lua.LString:
+			r.After = string(v)
+		case *lua.LTable:
+			r.After = lua.LVAsString(v.RawGetString("after"))
+			r.Column = lua.LVAsString(v.RawGetString("column"))
+		default:
+			err = fmt.Errorf("schema.retention.%s must be an interval or a table", name)
+			return
+		}
+		if err = r.validate(table); err != nil {
+			err = fmt.Errorf("retention of '%s': %w", name, err)
+			return
+		}
+		table.Retention = r
+	})
+	return err
+}
+
+// validate applies defaults and checks the expiry against the table's columns
+func (r *Retention) validate(t *TableSchema) error {
+	if !validInterval.MatchString(r.After) {
+		return fmt.Errorf("invalid interval '%s' (e.g., \"3 days\", \"12 hours\")", r.After)
+	}
+	if t.Hypertable != nil {
+		// TimescaleDB drops whole chunks by the hypertable's time column
+		if r.Column != "" && r.Column != t.Hypertable.TimeColumn {
+			return fmt.Errorf("column '%s' differs from the hypertable time column '%s'", r.Column, t.Hypertable.TimeColumn)
+		}
+		r.Column = t.Hypertable.TimeColumn
+		return nil
+	}
+	if r.Column == "" {
+		r.Column = "time"
+	}
+	if _, ok := t.Columns[r.Column]; !ok {
+		return fmt.Errorf("column '%s' is not declared", r.Column)
+	}
+	return nil
+}
+
+// validate applies defaults and checks the settings against the table's columns
+func (h *Hypertable) validate(t *TableSchema) error {
+	if h.TimeColumn == "" {
+		h.TimeColumn = "time"
+	}
+	if _, ok := t.Columns[h.TimeColumn]; !ok {
+		return fmt.Errorf("time column '%s' is not declared", h.TimeColumn)
+	}
+	if h.ChunkInterval != "" && !validInterval.MatchString(h.ChunkInterval) {
+		return fmt.Errorf("invalid chunk_interval '%s' (e.g., \"1 day\", \"6 hours\")", h.ChunkInterval)
+	}
+	if h.Partitions < 0 {
+		return fmt.Errorf("partitions must not be negative")
+	}
+	if h.Partition == "" {
+		if h.Partitions > 0 {
+			return fmt.Errorf("partitions requires a partition column")
+		}
+		return nil
+	}
+	if _, ok := t.Columns[h.Partition]; !ok {
+		return fmt.Errorf("partition column '%s' is not declared", h.Partition)
+	}
+	if h.Partitions == 0 {
+		h.Partitions = defaultPartitions
+	}
+	return nil
+}
+
 // GenerateSQL generates CREATE TABLE statements for the schema
 func (s *Schema) GenerateSQL() string {
 	if len(s.Tables) == 0 {
@@ -126,18 +323,31 @@ func (s *Schema) GenerateSQL() string {
 
 	for _, tableName := range tableNames {
 		table := s.Tables[tableName]
-		sb.WriteString(table.GenerateCreateTable())
+		sb.WriteString(table.GenerateCreateTable(true))
 		sb.WriteString("\n\n")
+		if hypertable := table.GenerateHypertable(); hypertable != "" {
+			sb.WriteString(hypertable)
+			sb.WriteString("\n\n")
+		}
+		if policy := table.GenerateRetentionPolicy(); policy != "" {
+			sb.WriteString(policy)
+			sb.WriteString("\n\n")
+		}
 	}
 
 	return strings.TrimSpace(sb.String())
 }
 
-// GenerateCreateTable generates a CREATE TABLE statement for this table
-func (t *TableSchema) GenerateCreateTable() string {
+// GenerateCreateTable generates a CREATE TABLE statement for this table,
+// with IF NOT EXISTS when ifNotExists is set
+func (t *TableSchema) GenerateCreateTable(ifNotExists bool) string {
 	var sb strings.Builder
 
-	sb.WriteString(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (\n", t.Name))
+	create := "CREATE TABLE"
+	if ifNotExists {
+		create += " IF NOT EXISTS"
+	}
+	sb.WriteString(fmt.Sprintf("%s %s (\n", create, t.Name))
 
 	// Sort column names for deterministic output
 	colNames := make([]string, 0, len(t.Columns))
@@ -160,6 +370,66 @@ func (t *TableSchema) GenerateCreateTable() string {
 	return sb.String()
 }
 
+// GenerateDropTable generates a DROP TABLE statement for this table
+func (t *TableSchema) GenerateDropTable() string {
+	return fmt.Sprintf("DROP TABLE IF EXISTS %s;", t.Name)
+}
+
+// GenerateLatestTable generates the CREATE TABLE statement for the
+// "<table>_latest" companion holding the newest row per key. It has the
+// table's columns with key as the primary key, and is never a hypertable.
+func (t *TableSchema) GenerateLatestTable(key string, ifNotExists bool) (string, error) {
+	if _, ok := t.Columns[key]; !ok {
+		return "", fmt.Errorf("latest key %s is not a column of table %s", key, t.Name)
+	}
+	latest := &TableSchema{Name: t.Name + "_latest", Columns: t.Columns}
+	sql := latest.GenerateCreateTable(ifNotExists)
+	return strings.TrimSuffix(sql, "\n);") + fmt.Sprintf(",\n  PRIMARY KEY (%s)\n);", key), nil
+}
+
+// GenerateHypertable generates the TimescaleDB statements converting this
+// table into a hypertable ("" for plain tables). Every statement is
+// idempotent; set_chunk_time_interval also retunes existing hypertables,
+// with the new interval applying to chunks created from then on.
+func (t *TableSchema) GenerateHypertable() string {
+	h := t.Hypertable
+	if h == nil {
+		return ""
+	}
+
+	var stmts []string
+	if h.ChunkInterval != "" {
+		stmts = append(stmts,
+			fmt.Sprintf("SELECT create_hypertable('%s', '%s', chunk_time_interval => INTERVAL '%s', if_not_exists => TRUE);", t.Name, h.TimeColumn, h.ChunkInterval),
+			fmt.Sprintf("SELECT set_chunk_time_interval('%s', INTERVAL '%s');", t.Name, h.ChunkInterval))
+	} else {
+		stmts = append(stmts, fmt.Sprintf("SELECT create_hypertable('%s', '%s', if_not_exists => TRUE);", t.Name, h.TimeColumn))
+	}
+	if h.Partition != "" {
+		stmts = append(stmts, fmt.Sprintf("SELECT add_dimension('%s', '%s', number_partitions => %d, if_not_exists => TRUE);", t.Name, h.Partition, h.Partitions))
+	}
+	return strings.Join(stmts, "\n")
+}
+
+// GenerateRetentionPolicy generates the TimescaleDB retention policy of a
+// hypertable with an expiry ("" otherwise; plain tables are expired by
+// running GenerateExpiry periodically)
+func (t *TableSchema) GenerateRetentionPolicy() string {
+	if t.Hypertable == nil || t.Retention == nil {
+		return ""
+	}
+	return fmt.Sprintf("SELECT add_retention_policy('%s', INTERVAL '%s', if_not_exists => TRUE);", t.Name, t.Retention.After)
+}
+
+// GenerateExpiry generates the DELETE statement removing expired rows from
+// a plain table with an expiry ("" otherwise)
+func (t *TableSchema) GenerateExpiry() string {
+	if t.Hypertable != nil || t.Retention == nil {
+		return ""
+	}
+	return fmt.Sprintf("DELETE FROM %s WHERE %s < now() - INTERVAL '%s';", t.Name, t.Retention.Column, t.Retention.After)
+}
+
 // Merge combines multiple schemas into one
 func Merge(schemas ...*Schema) *Schema {
 	merged := &Schema{
@@ -179,12 +449,30 @@ func Merge(schemas ...*Schema) *Schema {
 						existing.Columns[colName] = colType
 					}
 				}
+				// The first script declaring a hypertable decides its settings
+				if existing.Hypertable == nil && tableSchema.Hypertable != nil {
+					h := *tableSchema.Hypertable
+					existing.Hypertable = &h
+				}
+				// Likewise for its expiry
+				if existing.Retention == nil && tableSchema.Retention != nil {
+					r := *tableSchema.Retention
+					existing.Retention = &r
+				}
 			} else {
 				// Deep copy the table schema
 				newTable := &TableSchema{
 					Name:    tableSchema.Name,
 					Columns: make(map[string]string),
 				}
+				if tableSchema.Hypertable != nil {
+					h := *tableSchema.Hypertable
+					newTable.Hypertable = &h
+				}
+				if tableSchema.Retention != nil {
+					r := *tableSchema.Retention
+					newTable.Retention = &r
+				}
 				for colName, colType := range tableSchema.Columns {
 					newTable.Columns[colName] = colType
 				}
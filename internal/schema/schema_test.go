@@ -1,6 +1,7 @@
 package schema
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -100,6 +101,38 @@ schema = {
 	}
 }
 
+func TestLoadFromLuaScripts(t *testing.T) {
+	tmpDir := t.TempDir()
+	var paths []string
+	for i := 0; i < 20; i++ {
+		path := filepath.Join(tmpDir, fmt.Sprintf("route%d.lua", i))
+		code := fmt.Sprintf(`schema = { tables = { t%d = { columns = { v = "DOUBLE PRECISION" } } } }`, i)
+		if err := os.WriteFile(path, []byte(code), 0644); err != nil {
+			t.Fatalf("failed to write test script: %v", err)
+		}
+		paths = append(paths, path)
+	}
+
+	schemas, err := LoadFromLuaScripts(paths)
+	if err != nil {
+		t.Fatalf("LoadFromLuaScripts() error = %v", err)
+	}
+	for i, s := range schemas {
+		if _, ok := s.Tables[fmt.Sprintf("t%d", i)]; !ok || len(s.Tables) != 1 {
+			t.Errorf("schema %d out of order: %v", i, s.Tables)
+		}
+	}
+
+	bad := filepath.Join(tmpDir, "bad.lua")
+	if err := os.WriteFile(bad, []byte("schema = {"), 0644); err != nil {
+		t.Fatalf("failed to write test script: %v", err)
+	}
+	_, err = LoadFromLuaScripts(append(paths, bad))
+	if err == nil || !strings.Contains(err.Error(), "bad.lua") {
+		t.Errorf("Expected an error naming bad.lua, got %v", err)
+	}
+}
+
 func TestGenerateSQL(t *testing.T) {
 	schema := &Schema{
 		Tables: map[string]*TableSchema{
@@ -316,11 +349,14 @@ func TestGenerateCreateTable(t *testing.T) {
 		},
 	}
 
-	sql := table.GenerateCreateTable()
+	sql := table.GenerateCreateTable(true)
 
 	if !strings.Contains(sql, "CREATE TABLE IF NOT EXISTS test_table") {
 		t.Error("SQL should contain CREATE TABLE statement")
 	}
+	if plain := table.GenerateCreateTable(false); !strings.HasPrefix(plain, "CREATE TABLE test_table (") {
+		t.Errorf("Expected plain CREATE TABLE without ifNotExists, got %q", plain)
+	}
 
 	if !strings.Contains(sql, "id bigint") {
 		t.Error("SQL should contain id column")
@@ -339,3 +375,259 @@ func TestGenerateCreateTable(t *testing.T) {
 		t.Error("SQL should end with );")
 	}
 }
+
+func TestGenerateLatestTable(t *testing.T) {
+	table := &TableSchema{
+		Name:    "readings",
+		Columns: map[string]string{"time": "timestamptz", "device": "text", "temp": "double precision"},
+	}
+	sql, err := table.GenerateLatestTable("device", true)
+	if err != nil {
+		t.Fatalf("GenerateLatestTable failed: %v", err)
+	}
+	want := "CREATE TABLE IF NOT EXISTS readings_latest (\n  device text,\n  temp double precision,\n  time timestamptz,\n  PRIMARY KEY (device)\n);"
+	if sql != want {
+		t.Errorf("sql =\n%s\nwant\n%s", sql, want)
+	}
+
+	if _, err := table.GenerateLatestTable("sensor", true); err == nil {
+		t.Error("Expected error for an undeclared key column")
+	}
+}
+
+func TestGenerateDropTable(t *testing.T) {
+	table := &TableSchema{Name: "readings"}
+	if got := table.GenerateDropTable(); got != "DROP TABLE IF EXISTS readings;" {
+		t.Errorf("GenerateDropTable() = %q", got)
+	}
+}
+
+func TestLoadHypertables(t *testing.T) {
+	tests := []struct {
+		name    string
+		decl    string
+		want    *Hypertable
+		wantErr bool
+	}{
+		{
+			name: "defaults",
+			decl: `readings = {}`,
+			want: &Hypertable{TimeColumn: "time"},
+		},
+		{
+			name: "chunk interval and space partitioning",
+			decl: `readings = { time = "time", chunk_interval = "6 hours", partition = "sensor_id" }`,
+			want: &Hypertable{TimeColumn: "time", ChunkInterval: "6 hours", Partition: "sensor_id", Partitions: 4},
+		},
+		{
+			name: "explicit partitions",
+			decl: `readings = { partition = "sensor_id", partitions = 8 }`,
+			want: &Hypertable{TimeColumn: "time", Partition: "sensor_id", Partitions: 8},
+		},
+		{
+			name:    "undeclared table",
+			decl:    `other = {}`,
+			wantErr: true,
+		},
+		{
+			name:    "undeclared time column",
+			decl:    `readings = { time = "ts" }`,
+			wantErr: true,
+		},
+		{
+			name:    "undeclared partition column",
+			decl:    `readings = { partition = "device" }`,
+			wantErr: true,
+		},
+		{
+			name:    "partitions without partition column",
+			decl:    `readings = { partitions = 4 }`,
+			wantErr: true,
+		},
+		{
+			name:    "unsafe chunk interval",
+			decl:    `readings = { chunk_interval = "1 day'); DROP TABLE readings; --" }`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := `
+schema = {
+  tables = {
+    readings = { time = "timestamptz", sensor_id = "text", value = "double precision" }
+  },
+  hypertables = { ` + tt.decl + ` }
+}
+`
+			scriptPath := filepath.Join(t.TempDir(), "test.lua")
+			if err := os.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+				t.Fatalf("failed to write test script: %v", err)
+			}
+
+			s, err := LoadFromLuaScript(scriptPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadFromLuaScript() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := s.Tables["readings"].Hypertable
+			if got == nil || *got != *tt.want {
+				t.Errorf("Hypertable = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateHypertable(t *testing.T) {
+	table := &TableSchema{
+		Name:    "readings",
+		Columns: map[string]string{"time": "timestamptz", "sensor_id": "text"},
+	}
+	if sql := table.GenerateHypertable(); sql != "" {
+		t.Errorf("Expected no statements for a plain table, got %q", sql)
+	}
+
+	table.Hypertable = &Hypertable{TimeColumn: "time"}
+	want := "SELECT create_hypertable('readings', 'time', if_not_exists => TRUE);"
+	if sql := table.GenerateHypertable(); sql != want {
+		t.Errorf("GenerateHypertable() = %q, want %q", sql, want)
+	}
+
+	table.Hypertable = &Hypertable{TimeColumn: "time", ChunkInterval: "1 day", Partition: "sensor_id", Partitions: 4}
+	want = "SELECT create_hypertable('readings', 'time', chunk_time_interval => INTERVAL '1 day', if_not_exists => TRUE);\n" +
+		"SELECT set_chunk_time_interval('readings', INTERVAL '1 day');\n" +
+		"SELECT add_dimension('readings', 'sensor_id', number_partitions => 4, if_not_exists => TRUE);"
+	if sql := table.GenerateHypertable(); sql != want {
+		t.Errorf("GenerateHypertable() = %q, want %q", sql, want)
+	}
+
+	s := &Schema{Tables: map[string]*TableSchema{"readings": table}}
+	if !strings.Contains(s.GenerateSQL(), "add_dimension('readings'") {
+		t.Error("GenerateSQL() should include hypertable statements")
+	}
+	if merged := Merge(s); merged.Tables["readings"].Hypertable == nil {
+		t.Error("Merge() should keep hypertable settings")
+	}
+}
+
+func TestLoadRetention(t *testing.T) {
+	tests := []struct {
+		name       string
+		hypertable string
+		decl       string
+		want       *Retention
+		wantErr    bool
+	}{
+		{
+			name: "interval only",
+			decl: `diag = "3 days"`,
+			want: &Retention{After: "3 days", Column: "time"},
+		},
+		{
+			name: "custom column",
+			decl: `diag = { after = "12 hours", column = "seen_at" }`,
+			want: &Retention{After: "12 hours", Column: "seen_at"},
+		},
+		{
+			name:       "hypertable time column",
+			hypertable: `diag = { time = "seen_at" }`,
+			decl:       `diag = "7 days"`,
+			want:       &Retention{After: "7 days", Column: "seen_at"},
+		},
+		{
+			name:       "column other than the hypertable time column",
+			hypertable: `diag = {}`,
+			decl:       `diag = { after = "7 days", column = "seen_at" }`,
+			wantErr:    true,
+		},
+		{
+			name:    "undeclared table",
+			decl:    `other = "3 days"`,
+			wantErr: true,
+		},
+		{
+			name:    "undeclared column",
+			decl:    `diag = { after = "3 days", column = "ts" }`,
+			wantErr: true,
+		},
+		{
+			name:    "unsafe interval",
+			decl:    `diag = "3 days'; DROP TABLE diag; --"`,
+			wantErr: true,
+		},
+		{
+			name:    "wrong type",
+			decl:    `diag = 3`,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := `
+schema = {
+  tables = {
+    diag = { time = "timestamptz", seen_at = "timestamptz", message = "text" }
+  },
+  hypertables = { ` + tt.hypertable + ` },
+  retention = { ` + tt.decl + ` }
+}
+`
+			scriptPath := filepath.Join(t.TempDir(), "test.lua")
+			if err := os.WriteFile(scriptPath, []byte(script), 0644); err != nil {
+				t.Fatalf("failed to write test script: %v", err)
+			}
+
+			s, err := LoadFromLuaScript(scriptPath)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LoadFromLuaScript() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			got := s.Tables["diag"].Retention
+			if got == nil || *got != *tt.want {
+				t.Errorf("Retention = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGenerateRetention(t *testing.T) {
+	table := &TableSchema{
+		Name:    "diag",
+		Columns: map[string]string{"time": "timestamptz"},
+	}
+	if table.GenerateExpiry() != "" || table.GenerateRetentionPolicy() != "" {
+		t.Error("Expected no statements for a table without retention")
+	}
+
+	table.Retention = &Retention{After: "3 days", Column: "time"}
+	want := "DELETE FROM diag WHERE time < now() - INTERVAL '3 days';"
+	if sql := table.GenerateExpiry(); sql != want {
+		t.Errorf("GenerateExpiry() = %q, want %q", sql, want)
+	}
+	if sql := table.GenerateRetentionPolicy(); sql != "" {
+		t.Errorf("Expected no retention policy for a plain table, got %q", sql)
+	}
+
+	table.Hypertable = &Hypertable{TimeColumn: "time"}
+	want = "SELECT add_retention_policy('diag', INTERVAL '3 days', if_not_exists => TRUE);"
+	if sql := table.GenerateRetentionPolicy(); sql != want {
+		t.Errorf("GenerateRetentionPolicy() = %q, want %q", sql, want)
+	}
+	if sql := table.GenerateExpiry(); sql != "" {
+		t.Errorf("Expected hypertables to be expired by their policy, got %q", sql)
+	}
+
+	s := &Schema{Tables: map[string]*TableSchema{"diag": table}}
+	if !strings.Contains(s.GenerateSQL(), "add_retention_policy('diag'") {
+		t.Error("GenerateSQL() should include the retention policy")
+	}
+	if merged := Merge(s); merged.Tables["diag"].Retention == nil {
+		t.Error("Merge() should keep retention settings")
+	}
+}
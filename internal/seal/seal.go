@@ -0,0 +1,244 @@
+// Package seal encrypts the files Hermod buffers telemetry in on disk (queue
+// spool, payload archive) with AES-256-GCM, so a gateway's disk doesn't give
+// away the data without the key.
+//
+// A sealed stream starts with a magic line and a random 16-byte stream ID,
+// followed by frames of a 4-byte big-endian length, a random 12-byte nonce
+// and the GCM ciphertext. Each frame is authenticated together with the
+// stream ID and its position in the stream, so frames that are modified,
+// reordered, dropped or spliced in from another stream fail to open; only
+// whole frames cut off the end go unnoticed. Streams may be concatenated (a
+// file appended to after a restart), so a magic line and stream ID may
+// appear again between frames.
+package seal
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// KeySize is the key length in bytes (AES-256)
+const KeySize = 32
+
+// frameSize is how much plaintext is buffered before a frame is sealed
+const frameSize = 64 << 10
+
+// maxFrame bounds the ciphertext length read from a frame header
+const maxFrame = frameSize + 1024
+
+// magic starts every sealed stream. Read as a frame length it exceeds
+// maxFrame, so it can't be mistaken for a frame header.
+var magic = []byte("HERMODSEAL2\n")
+
+// idSize is the length of the stream ID following the magic line
+const idSize = 16
+
+// ErrNoKey is returned when reading a sealed stream without a key
+var ErrNoKey = errors.New("file is encrypted and no key is configured")
+
+// ParseKey decodes a key given as 64 hex digits
+func ParseKey(s string) ([]byte, error) {
+	key, err := hex.DecodeString(strings.TrimSpace(s))
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	if len(key) != KeySize {
+		return nil, fmt.Errorf("invalid key: %d bytes, want %d (64 hex digits)", len(key), KeySize)
+	}
+	return key, nil
+}
+
+// LoadKey reads the key from file, or from the environment variable env
+// when file is empty. It returns no key when both are empty.
+func LoadKey(file, env string) ([]byte, error) {
+	switch {
+	case file != "":
+		data, err := os.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read key file: %w", err)
+		}
+		return ParseKey(string(data))
+	case env != "":
+		s, ok := os.LookupEnv(env)
+		if !ok {
+			return nil, fmt.Errorf("key variable %s is not set", env)
+		}
+		return ParseKey(s)
+	}
+	return nil, nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %w", err)
+	}
+	return cipher.NewGCM(block)
+}
+
+// Writer seals what is written to it into frames on the underlying writer.
+// Data is buffered until a frame fills up or Flush is called.
+type Writer struct {
+	w      io.Writer
+	aead   cipher.AEAD
+	buf    []byte
+	id     []byte // Stream ID (nil until the header is written)
+	frames uint64 // Frames sealed so far
+}
+
+// NewWriter returns a Writer sealing into w with key
+func NewWriter(w io.Writer, key []byte) (*Writer, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{w: w, aead: aead, buf: make([]byte, 0, frameSize)}, nil
+}
+
+// Write buffers p, sealing a frame whenever the buffer fills up
+func (w *Writer) Write(p []byte) (int, error) {
+	n := 0
+	for len(p) > 0 {
+		chunk := min(len(p), frameSize-len(w.buf))
+		w.buf = append(w.buf, p[:chunk]...)
+		p = p[chunk:]
+		n += chunk
+		if len(w.buf) == frameSize {
+			if err := w.Flush(); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// Flush seals the buffered data into a frame
+func (w *Writer) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	if w.id == nil {
+		id := make([]byte, idSize)
+		if _, err := rand.Read(id); err != nil {
+			return fmt.Errorf("failed to generate stream ID: %w", err)
+		}
+		if _, err := w.w.Write(append(bytes.Clone(magic), id...)); err != nil {
+			return err
+		}
+		w.id = id
+	}
+	frame := make([]byte, 4+w.aead.NonceSize(), 4+w.aead.NonceSize()+len(w.buf)+w.aead.Overhead())
+	nonce := frame[4:]
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	frame = w.aead.Seal(frame, nonce, w.buf, frameAD(w.id, w.frames))
+	binary.BigEndian.PutUint32(frame, uint32(len(frame)-4))
+	if _, err := w.w.Write(frame); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	w.frames++
+	return nil
+}
+
+// frameAD returns the additional data a frame is authenticated with: the
+// stream ID and the frame's index in the stream
+func frameAD(id []byte, index uint64) []byte {
+	return binary.BigEndian.AppendUint64(bytes.Clone(id), index)
+}
+
+// Close flushes the buffered data. It doesn't close the underlying writer.
+func (w *Writer) Close() error {
+	return w.Flush()
+}
+
+// NewReader returns a reader of r's plaintext. A stream that isn't sealed
+// (written before encryption was enabled) is read as is; a sealed one
+// needs key.
+func NewReader(r io.Reader, key []byte) (io.Reader, error) {
+	br := bufio.NewReader(r)
+	head, err := br.Peek(len(magic))
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if !bytes.Equal(head, magic) {
+		return br, nil
+	}
+	if key == nil {
+		return nil, ErrNoKey
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	return &reader{r: br, aead: aead}, nil
+}
+
+// reader opens the frames of a sealed stream
+type reader struct {
+	r      *bufio.Reader
+	aead   cipher.AEAD
+	buf    []byte // Opened plaintext not read yet
+	id     []byte // ID of the current stream
+	frames uint64 // Frames opened in the current stream
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next opens the next frame into buf, or reads the header of the next
+// stream
+func (r *reader) next() error {
+	head, err := r.r.Peek(len(magic))
+	if bytes.Equal(head, magic) {
+		header := make([]byte, len(magic)+idSize)
+		if _, err := io.ReadFull(r.r, header); err != nil {
+			return fmt.Errorf("truncated stream header: %w", io.ErrUnexpectedEOF)
+		}
+		r.id, r.frames = header[len(magic):], 0
+		return nil
+	}
+	if len(head) == 0 && errors.Is(err, io.EOF) {
+		return io.EOF
+	}
+
+	var size [4]byte
+	if _, err := io.ReadFull(r.r, size[:]); err != nil {
+		return fmt.Errorf("truncated frame: %w", io.ErrUnexpectedEOF)
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n < uint32(r.aead.NonceSize()+r.aead.Overhead()) || n > maxFrame {
+		return fmt.Errorf("invalid frame length %d", n)
+	}
+	frame := make([]byte, n)
+	if _, err := io.ReadFull(r.r, frame); err != nil {
+		return fmt.Errorf("truncated frame: %w", io.ErrUnexpectedEOF)
+	}
+	nonce, ciphertext := frame[:r.aead.NonceSize()], frame[r.aead.NonceSize():]
+	plain, err := r.aead.Open(ciphertext[:0], nonce, ciphertext, frameAD(r.id, r.frames))
+	if err != nil {
+		return fmt.Errorf("failed to decrypt frame %d (wrong key, damaged file, or frames missing, reordered or spliced): %w", r.frames, err)
+	}
+	r.buf = plain
+	r.frames++
+	return nil
+}
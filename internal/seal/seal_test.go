@@ -0,0 +1,166 @@
+package seal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var testKey = bytes.Repeat([]byte{7}, KeySize)
+
+func seal(t *testing.T, buf *bytes.Buffer, parts ...string) {
+	t.Helper()
+	w, err := NewWriter(buf, testKey)
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	for _, p := range parts {
+		if _, err := w.Write([]byte(p)); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+		if err := w.Flush(); err != nil {
+			t.Fatalf("Flush() error = %v", err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+}
+
+func open(r io.Reader, key []byte) (string, error) {
+	pr, err := NewReader(r, key)
+	if err != nil {
+		return "", err
+	}
+	data, err := io.ReadAll(pr)
+	return string(data), err
+}
+
+func TestRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	big := strings.Repeat("x", 3*frameSize+17)
+	seal(t, &buf, "hello ", "world\n", big)
+	// Appending after a restart starts another sealed stream
+	seal(t, &buf, "again\n")
+
+	if bytes.Contains(buf.Bytes(), []byte("hello")) {
+		t.Fatal("Expected the plaintext not to appear in the sealed stream")
+	}
+	got, err := open(&buf, testKey)
+	if err != nil {
+		t.Fatalf("read error = %v", err)
+	}
+	if want := "hello world\n" + big + "again\n"; got != want {
+		t.Errorf("Expected %d bytes back, got %d", len(want), len(got))
+	}
+}
+
+func TestReaderPlaintext(t *testing.T) {
+	got, err := open(strings.NewReader("{\"a\":1}\n"), testKey)
+	if err != nil || got != "{\"a\":1}\n" {
+		t.Errorf("Expected plaintext read as is, got %q, %v", got, err)
+	}
+	if got, err := open(strings.NewReader(""), nil); err != nil || got != "" {
+		t.Errorf("Expected empty stream, got %q, %v", got, err)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	var buf bytes.Buffer
+	seal(t, &buf, "secret telemetry")
+	sealed := buf.Bytes()
+
+	if _, err := open(bytes.NewReader(sealed), nil); !errors.Is(err, ErrNoKey) {
+		t.Errorf("Expected ErrNoKey without a key, got %v", err)
+	}
+	if _, err := open(bytes.NewReader(sealed), bytes.Repeat([]byte{8}, KeySize)); err == nil {
+		t.Error("Expected an error with the wrong key")
+	}
+	damaged := bytes.Clone(sealed)
+	damaged[len(damaged)-1] ^= 1
+	if _, err := open(bytes.NewReader(damaged), testKey); err == nil {
+		t.Error("Expected an error for a damaged frame")
+	}
+	if _, err := open(bytes.NewReader(sealed[:len(sealed)-3]), testKey); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Errorf("Expected a truncated frame error, got %v", err)
+	}
+}
+
+// splitFrames splits a single sealed stream into its header and frames
+func splitFrames(t *testing.T, sealed []byte) ([]byte, [][]byte) {
+	t.Helper()
+	header, rest := sealed[:len(magic)+idSize], sealed[len(magic)+idSize:]
+	var frames [][]byte
+	for len(rest) > 0 {
+		n := 4 + int(binary.BigEndian.Uint32(rest))
+		frames = append(frames, rest[:n])
+		rest = rest[n:]
+	}
+	return header, frames
+}
+
+func TestReaderFrameOrder(t *testing.T) {
+	var a, b bytes.Buffer
+	seal(t, &a, "one ", "two ", "three")
+	seal(t, &b, "other")
+	header, frames := splitFrames(t, a.Bytes())
+	_, other := splitFrames(t, b.Bytes())
+	if len(frames) != 3 {
+		t.Fatalf("Expected 3 frames, got %d", len(frames))
+	}
+
+	tests := []struct {
+		name   string
+		frames [][]byte
+	}{
+		{"reordered", [][]byte{frames[0], frames[2], frames[1]}},
+		{"dropped", [][]byte{frames[0], frames[2]}},
+		{"replayed", [][]byte{frames[0], frames[0], frames[1]}},
+		{"spliced", [][]byte{frames[0], other[0], frames[2]}},
+	}
+	for _, tt := range tests {
+		stream := bytes.Clone(header)
+		for _, f := range tt.frames {
+			stream = append(stream, f...)
+		}
+		if got, err := open(bytes.NewReader(stream), testKey); err == nil {
+			t.Errorf("%s frames: expected an error, read %q", tt.name, got)
+		}
+	}
+
+	// A frame moved under another stream's header fails too
+	spliced := append(bytes.Clone(b.Bytes()[:len(magic)+idSize]), frames[0]...)
+	if _, err := open(bytes.NewReader(spliced), testKey); err == nil {
+		t.Error("Expected an error for a frame under another stream's header")
+	}
+}
+
+func TestLoadKey(t *testing.T) {
+	hexKey := strings.Repeat("07", KeySize)
+	path := filepath.Join(t.TempDir(), "disk.key")
+	if err := os.WriteFile(path, []byte(hexKey+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if key, err := LoadKey(path, ""); err != nil || !bytes.Equal(key, testKey) {
+		t.Errorf("LoadKey(file) = %x, %v", key, err)
+	}
+
+	t.Setenv("HERMOD_TEST_KEY", hexKey)
+	if key, err := LoadKey("", "HERMOD_TEST_KEY"); err != nil || !bytes.Equal(key, testKey) {
+		t.Errorf("LoadKey(env) = %x, %v", key, err)
+	}
+	if _, err := LoadKey("", "HERMOD_TEST_UNSET"); err == nil {
+		t.Error("Expected an error for an unset variable")
+	}
+	if key, err := LoadKey("", ""); key != nil || err != nil {
+		t.Errorf("Expected no key when unconfigured, got %x, %v", key, err)
+	}
+	if _, err := ParseKey("abcd"); err == nil {
+		t.Error("Expected an error for a short key")
+	}
+}
@@ -0,0 +1,81 @@
+// Package selftest runs deployment checks (broker round trip, database
+// ping, script loading, transforms) and reports each as passed, failed or
+// skipped, so a rollout can be gated on "hermod selftest".
+package selftest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// Check statuses
+const (
+	Pass = "PASS"
+	Fail = "FAIL"
+	Skip = "SKIP"
+)
+
+// Check is one named check. Run returns a short detail for the report, or
+// an error; errors wrapping ErrSkipped mark the check as skipped.
+type Check struct {
+	Name string
+	Run  func(ctx context.Context) (string, error)
+}
+
+// ErrSkipped marks checks that don't apply (e.g. no broker configured)
+var ErrSkipped = errors.New("skipped")
+
+// Skipped returns an ErrSkipped error with reason
+func Skipped(reason string) error {
+	return fmt.Errorf("%w: %s", ErrSkipped, reason)
+}
+
+// Result is the outcome of a check
+type Result struct {
+	Name     string
+	Status   string
+	Detail   string
+	Duration time.Duration
+}
+
+// Run runs checks in order, each with its own timeout
+func Run(ctx context.Context, checks []Check, timeout time.Duration) []Result {
+	results := make([]Result, 0, len(checks))
+	for _, c := range checks {
+		cctx, cancel := context.WithTimeout(ctx, timeout)
+		start := time.Now()
+		detail, err := c.Run(cctx)
+		cancel()
+
+		res := Result{Name: c.Name, Status: Pass, Detail: detail, Duration: time.Since(start)}
+		switch {
+		case errors.Is(err, ErrSkipped):
+			res.Status, res.Detail = Skip, err.Error()
+		case err != nil:
+			res.Status, res.Detail = Fail, err.Error()
+		}
+		results = append(results, res)
+	}
+	return results
+}
+
+// Report writes one line per result and a summary to w, and reports
+// whether no check failed
+func Report(w io.Writer, results []Result) bool {
+	failed := 0
+	for _, r := range results {
+		if r.Status == Fail {
+			failed++
+		}
+		line := fmt.Sprintf("%s %s (%s)", r.Status, r.Name, r.Duration.Round(time.Millisecond))
+		if r.Detail != "" {
+			line += ": " + r.Detail
+		}
+		fmt.Fprintln(w, line)
+	}
+	fmt.Fprintf(w, "%d checks, %d failed\n", len(results), failed)
+	return failed == 0
+}
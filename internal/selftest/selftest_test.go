@@ -0,0 +1,44 @@
+package selftest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRun(t *testing.T) {
+	checks := []Check{
+		{Name: "ok", Run: func(ctx context.Context) (string, error) { return "fine", nil }},
+		{Name: "broken", Run: func(ctx context.Context) (string, error) { return "", errors.New("boom") }},
+		{Name: "absent", Run: func(ctx context.Context) (string, error) { return "", Skipped("not configured") }},
+		{Name: "slow", Run: func(ctx context.Context) (string, error) {
+			<-ctx.Done()
+			return "", ctx.Err()
+		}},
+	}
+	results := Run(context.Background(), checks, 20*time.Millisecond)
+
+	want := []string{Pass, Fail, Skip, Fail}
+	for i, r := range results {
+		if r.Status != want[i] {
+			t.Errorf("%s: status %s, want %s", r.Name, r.Status, want[i])
+		}
+	}
+	if results[1].Detail != "boom" || !strings.Contains(results[2].Detail, "not configured") {
+		t.Errorf("Unexpected details: %+v", results)
+	}
+
+	var out bytes.Buffer
+	if Report(&out, results) {
+		t.Error("Expected the report to fail")
+	}
+	if !strings.Contains(out.String(), "PASS ok") || !strings.Contains(out.String(), "4 checks, 2 failed") {
+		t.Errorf("Unexpected report:\n%s", out.String())
+	}
+	if !Report(&out, results[:1]) {
+		t.Error("Expected a passing report")
+	}
+}
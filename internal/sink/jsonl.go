@@ -0,0 +1,78 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/errs"
+)
+
+// Sink types accepted by [[sinks]] type
+const (
+	TypePostgres = "postgres"
+	TypeJSONL    = "jsonl"
+)
+
+// Types returns the supported sink types in sorted order
+func Types() []string {
+	return []string{TypeJSONL, TypePostgres}
+}
+
+// JSONLines appends records to a file as newline-delimited JSON, one object
+// per record with the target table under "table"
+type JSONLines struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// NewJSONLines opens (or creates) path for appending
+func NewJSONLines(path string) (*JSONLines, error) {
+	if path == "" {
+		return nil, fmt.Errorf("jsonl sink path is required")
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create sink directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open sink file: %w", err)
+	}
+	return &JSONLines{file: f}, nil
+}
+
+// InsertIntoTable writes one record as a JSON line
+func (j *JSONLines) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	obj := make(map[string]interface{}, len(data)+1)
+	for k, v := range data {
+		if t, ok := v.(time.Time); ok {
+			v = t.UTC().Format(time.RFC3339Nano)
+		}
+		obj[k] = v
+	}
+	obj["table"] = table
+	line, err := json.Marshal(obj)
+	if err != nil {
+		return fmt.Errorf("failed to encode record: %w", err)
+	}
+	line = append(line, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	// One write per record, so processes tailing the file never see partial lines
+	if _, err := j.file.Write(line); err != nil {
+		return fmt.Errorf("%w: failed to write record: %w", errs.ErrStorageUnavailable, err)
+	}
+	return nil
+}
+
+// Close closes the file
+func (j *JSONLines) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.file.Close()
+}
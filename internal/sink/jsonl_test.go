@@ -0,0 +1,50 @@
+package sink
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJSONLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events", "raw.jsonl")
+	j, err := NewJSONLines(path)
+	if err != nil {
+		t.Fatalf("NewJSONLines failed: %v", err)
+	}
+
+	at := time.Date(2024, 1, 1, 13, 0, 0, 0, time.FixedZone("CET", 3600))
+	if err := j.InsertIntoTable(context.Background(), "events", map[string]interface{}{"time": at, "v": 1.5}); err != nil {
+		t.Fatalf("InsertIntoTable failed: %v", err)
+	}
+	if err := j.InsertIntoTable(context.Background(), "events", map[string]interface{}{"v": 2}); err != nil {
+		t.Fatalf("InsertIntoTable failed: %v", err)
+	}
+	if err := j.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("failed to read sink file: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d: %s", len(lines), data)
+	}
+	var first map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &first); err != nil {
+		t.Fatalf("invalid JSON line: %v", err)
+	}
+	if first["table"] != "events" || first["time"] != "2024-01-01T12:00:00Z" || first["v"] != 1.5 {
+		t.Errorf("Unexpected record: %v", first)
+	}
+
+	if _, err := NewJSONLines(""); err == nil {
+		t.Error("Expected error for empty path")
+	}
+}
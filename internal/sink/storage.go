@@ -0,0 +1,42 @@
+package sink
+
+import (
+	"context"
+	"time"
+)
+
+// Storage is the downstream sink a stage forwards records to
+type Storage interface {
+	InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error
+}
+
+// BatchStorage is implemented by sinks that insert several rows in one round trip
+type BatchStorage interface {
+	InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error
+}
+
+// InsertEach inserts rows one by one through s, stopping at the first
+// error. Stages use it for InsertBatch when the next sink doesn't batch,
+// passing themselves as s so every row still goes through the stage.
+func InsertEach(ctx context.Context, s Storage, table string, rows []map[string]interface{}) error {
+	for _, data := range rows {
+		if err := s.InsertIntoTable(ctx, table, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RecordTime returns the record's "time" column, falling back to fallback
+// when it has none or it can't be parsed
+func RecordTime(data map[string]interface{}, fallback time.Time) time.Time {
+	switch v := data["time"].(type) {
+	case time.Time:
+		return v
+	case string:
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t
+		}
+	}
+	return fallback
+}
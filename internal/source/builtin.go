@@ -0,0 +1,205 @@
+package source
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/coap"
+	"github.com/marcgeld/hermod/internal/config"
+	"github.com/marcgeld/hermod/internal/listener"
+	"github.com/marcgeld/hermod/internal/mqtt"
+	"github.com/marcgeld/hermod/internal/nats"
+	"github.com/marcgeld/hermod/internal/outage"
+	"github.com/marcgeld/hermod/internal/tail"
+)
+
+func init() {
+	Register("mqtt", newMQTTSources)
+	Register("nats", newNATSSources)
+	Register("listener", newListenerSources)
+	Register("tail", newTailSources)
+	Register("coap", newCoAPSources)
+}
+
+// newMQTTSources connects to the MQTT broker when one is configured, and to
+// every [[mqtt.brokers]] entry. The [mqtt] client comes last so it is the
+// one alerts and taps publish through.
+func newMQTTSources(p Params) ([]Source, error) {
+	var sources []Source
+	for _, b := range p.Config.MQTT.Brokers {
+		mc, _ := p.Config.MQTT.NamedBroker(b.Name)
+		client, err := newMQTTClient(mc, outage.MQTT+":"+b.Name, p.BrokerFilters[b.Name], p)
+		if err != nil {
+			closeAll(sources)
+			return nil, fmt.Errorf("broker %s: %w", b.Name, err)
+		}
+		sources = append(sources, client)
+	}
+	if p.Config.MQTT.Broker != "" {
+		client, err := newMQTTClient(p.Config.MQTT, outage.MQTT, p.Filters, p)
+		if err != nil {
+			closeAll(sources)
+			return nil, err
+		}
+		sources = append(sources, client)
+	}
+	return sources, nil
+}
+
+// newMQTTClient creates a client for mc subscribing to filters, reporting
+// its outages as component
+func newMQTTClient(mc config.MQTTConfig, component string, filters []string, p Params) (*mqtt.Client, error) {
+	var lazy []string
+	for _, f := range p.Lazy {
+		if slices.Contains(filters, f) {
+			lazy = append(lazy, f)
+		}
+	}
+	cfg := mqtt.Config{
+		Broker:    mc.Broker,
+		ClientID:  mc.ClientID,
+		Suffix:    mc.Suffix,
+		Username:  mc.Username,
+		Password:  mc.Password,
+		QoS:       mc.QoS,
+		Filters:   filters,
+		Lazy:      lazy,
+		Logger:    p.Logger,
+		ManualAck: mc.ManualAck,
+
+		PersistentSession: mc.PersistentSession(),
+
+		CACert:             mc.CACert,
+		ClientCert:         mc.ClientCert,
+		ClientKey:          mc.ClientKey,
+		ClientKeyPassword:  mc.ClientKeyPassword,
+		InsecureSkipVerify: mc.InsecureSkipVerify,
+		ServerName:         mc.ServerName,
+
+		Headers: mc.Headers,
+		Token:   mc.Token,
+
+		Standby: mc.Standby,
+		Order:   mc.BrokerOrder,
+
+		WillTopic:   mc.WillTopic,
+		WillPayload: mc.WillPayload,
+		WillQoS:     mc.WillQoS,
+		WillRetain:  mc.WillRetain,
+	}
+	cfg.Reconnect.Jitter = mc.ReconnectJitter
+	var err error
+	if mc.ReconnectInitial != "" {
+		if cfg.Reconnect.Initial, err = time.ParseDuration(mc.ReconnectInitial); err != nil {
+			return nil, fmt.Errorf("invalid mqtt reconnect_initial: %w", err)
+		}
+	}
+	if mc.ReconnectMax != "" {
+		if cfg.Reconnect.Max, err = time.ParseDuration(mc.ReconnectMax); err != nil {
+			return nil, fmt.Errorf("invalid mqtt reconnect_max: %w", err)
+		}
+	}
+	if p.Outages != nil {
+		cfg.OnConnectionLost = func(err error) { p.Outages.Down(component, err) }
+		cfg.OnConnect = func() { p.Outages.Up(component) }
+	}
+	return mqtt.New(cfg)
+}
+
+// newNATSSources connects to the NATS server when one is configured
+func newNATSSources(p Params) ([]Source, error) {
+	nc := p.Config.NATS
+	if nc.URL == "" {
+		return nil, nil
+	}
+	client, err := nats.New(nats.Config{
+		URL:      nc.URL,
+		Name:     nc.Name,
+		Username: nc.Username,
+		Password: nc.Password,
+		Token:    nc.Token,
+		Filters:  p.Filters,
+		Logger:   p.Logger,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []Source{client}, nil
+}
+
+// newListenerSources binds one raw listener per [[listeners]] entry
+func newListenerSources(p Params) ([]Source, error) {
+	sources := make([]Source, 0, len(p.Config.Listeners))
+	for _, lc := range p.Config.Listeners {
+		l, err := listener.New(listener.Config{
+			Protocol:     lc.Protocol,
+			Address:      lc.Address,
+			Framing:      lc.Framing,
+			Topic:        lc.Topic,
+			MaxFrameSize: lc.MaxFrameSize,
+			Logger:       p.Logger,
+		})
+		if err != nil {
+			closeAll(sources)
+			return nil, fmt.Errorf("%s listener on %s: %w", lc.Protocol, lc.Address, err)
+		}
+		sources = append(sources, l)
+	}
+	return sources, nil
+}
+
+// newTailSources creates one tailer per [[tail]] entry
+func newTailSources(p Params) ([]Source, error) {
+	sources := make([]Source, 0, len(p.Config.Tail))
+	for _, tc := range p.Config.Tail {
+		var pollInterval time.Duration
+		if tc.PollInterval != "" {
+			d, err := time.ParseDuration(tc.PollInterval)
+			if err != nil {
+				closeAll(sources)
+				return nil, fmt.Errorf("invalid poll_interval for %s: %w", tc.Path, err)
+			}
+			pollInterval = d
+		}
+		t, err := tail.New(tail.Config{
+			Path:         tc.Path,
+			Topic:        tc.Topic,
+			FromStart:    tc.FromStart,
+			PollInterval: pollInterval,
+			MaxLineSize:  tc.MaxLineSize,
+			Logger:       p.Logger,
+		})
+		if err != nil {
+			closeAll(sources)
+			return nil, fmt.Errorf("tail %s: %w", tc.Path, err)
+		}
+		sources = append(sources, t)
+	}
+	return sources, nil
+}
+
+// newCoAPSources binds the CoAP server when an address is configured
+func newCoAPSources(p Params) ([]Source, error) {
+	cc := p.Config.CoAP
+	if cc.Address == "" {
+		return nil, nil
+	}
+	server, err := coap.New(coap.Config{
+		Address:        cc.Address,
+		TopicPrefix:    cc.TopicPrefix,
+		MaxMessageSize: cc.MaxMessageSize,
+		Logger:         p.Logger,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return []Source{server}, nil
+}
+
+// closeAll closes the given sources
+func closeAll(sources []Source) {
+	for _, s := range sources {
+		s.Close()
+	}
+}
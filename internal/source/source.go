@@ -0,0 +1,122 @@
+package source
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/marcgeld/hermod/internal/config"
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/router"
+)
+
+// Source produces messages for the router (MQTT, NATS, raw sockets, files, ...).
+type Source interface {
+	// Start begins delivering messages to dispatch. It returns once the source
+	// is running; delivery stops when ctx is cancelled or Close is called.
+	// dispatch returns why a message wasn't accepted (already logged), for
+	// sources that answer each request (e.g. CoAP).
+	Start(ctx context.Context, dispatch func(router.Message) error) error
+	// Close stops the source and releases its resources.
+	Close()
+}
+
+// Pauser is implemented by sources that can hold off their lazy filters
+// (Params.Lazy) one by one: Start leaves them unsubscribed until Resume, and
+// Pause unsubscribes one again. Both ignore filters that aren't lazy.
+// Sources without it subscribe to them as usual.
+type Pauser interface {
+	Pause(filter string)
+	Resume(filter string) error
+}
+
+// Redeliverer is implemented by sources whose broker redelivers messages
+// left unacknowledged only when the session resumes (MQTT with manual_ack).
+// Withheld counts those messages; Redeliver resumes the session once they
+// can be stored.
+type Redeliverer interface {
+	Withheld() int
+	Redeliver()
+}
+
+// Params holds everything a Factory needs to build its sources
+type Params struct {
+	Config  *config.Config
+	Filters []string // Topic filters the router is interested in (apart from BrokerFilters)
+	Lazy    []string // Filters to subscribe to only while storage is healthy (see Pauser)
+	Logger  *logger.Logger
+	Outages Outages // Told when connection-based sources go down and recover (optional)
+
+	BrokerFilters map[string][]string // Filters of routes reading from a [[mqtt.brokers]] entry, by name (not in Filters)
+}
+
+// Outages is notified when a source loses and regains its connection
+type Outages interface {
+	Down(component string, err error)
+	Up(component string)
+}
+
+// Factory builds the sources of one kind from the configuration.
+// It returns no sources when its configuration section is absent.
+type Factory func(p Params) ([]Source, error)
+
+var (
+	mu        sync.RWMutex
+	factories = make(map[string]Factory)
+)
+
+// Register makes a source factory available under the given name.
+// It panics if the name is already registered.
+func Register(name string, f Factory) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, exists := factories[name]; exists {
+		panic(fmt.Sprintf("source %q already registered", name))
+	}
+	factories[name] = f
+}
+
+// unregister removes a source factory (for tests)
+func unregister(name string) {
+	mu.Lock()
+	defer mu.Unlock()
+	delete(factories, name)
+}
+
+// Names returns the registered source names in sorted order
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	names := make([]string, 0, len(factories))
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Build runs every registered factory and returns all configured sources.
+// If any factory fails, sources built so far are closed.
+func Build(p Params) ([]Source, error) {
+	if p.Logger == nil {
+		p.Logger = logger.New(logger.INFO)
+	}
+
+	var sources []Source
+	for _, name := range Names() {
+		mu.RLock()
+		f := factories[name]
+		mu.RUnlock()
+
+		built, err := f(p)
+		if err != nil {
+			for _, s := range sources {
+				s.Close()
+			}
+			return nil, fmt.Errorf("failed to build %s source: %w", name, err)
+		}
+		sources = append(sources, built...)
+	}
+	return sources, nil
+}
@@ -0,0 +1,112 @@
+package source
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/marcgeld/hermod/internal/config"
+	"github.com/marcgeld/hermod/internal/router"
+)
+
+// fakeSource records lifecycle calls for testing
+type fakeSource struct {
+	started bool
+	closed  bool
+}
+
+func (f *fakeSource) Start(ctx context.Context, dispatch func(router.Message) error) error {
+	f.started = true
+	dispatch(router.Message{Topic: "fake/topic"})
+	return nil
+}
+
+func (f *fakeSource) Close() {
+	f.closed = true
+}
+
+func TestBuiltinSourcesRegistered(t *testing.T) {
+	names := Names()
+	for _, want := range []string{"coap", "listener", "mqtt", "nats", "tail"} {
+		found := false
+		for _, n := range names {
+			if n == want {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("Expected built-in source %q to be registered, got %v", want, names)
+		}
+	}
+}
+
+func TestBuildEmptyConfig(t *testing.T) {
+	sources, err := Build(Params{Config: &config.Config{}})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if len(sources) != 0 {
+		t.Errorf("Expected no sources for empty config, got %d", len(sources))
+	}
+}
+
+func TestRegisterAndBuild(t *testing.T) {
+	fake := &fakeSource{}
+	var filters []string
+	t.Cleanup(func() { unregister("test_fake") })
+	Register("test_fake", func(p Params) ([]Source, error) {
+		filters = p.Filters
+		return []Source{fake}, nil
+	})
+
+	sources, err := Build(Params{Config: &config.Config{}, Filters: []string{"a/#"}})
+	if err != nil {
+		t.Fatalf("Build failed: %v", err)
+	}
+	if len(filters) != 1 || filters[0] != "a/#" {
+		t.Errorf("Expected filters to be passed to factory, got %v", filters)
+	}
+	if len(sources) != 1 {
+		t.Fatalf("Expected 1 source, got %d", len(sources))
+	}
+
+	var got []string
+	if err := sources[0].Start(context.Background(), func(msg router.Message) error {
+		got = append(got, msg.Topic)
+		return nil
+	}); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if !fake.started || len(got) != 1 || got[0] != "fake/topic" {
+		t.Errorf("Expected source to start and dispatch, got %v", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic on duplicate registration")
+		}
+	}()
+	Register("test_fake", nil)
+}
+
+func TestBuildClosesOnError(t *testing.T) {
+	// Factories run in sorted order, so "test_a" builds before "test_b" fails
+	first := &fakeSource{}
+	t.Cleanup(func() {
+		unregister("test_a")
+		unregister("test_b")
+	})
+	Register("test_a", func(p Params) ([]Source, error) {
+		return []Source{first}, nil
+	})
+	Register("test_b", func(p Params) ([]Source, error) {
+		return nil, errors.New("boom")
+	})
+
+	if _, err := Build(Params{Config: &config.Config{}}); err == nil {
+		t.Fatal("Expected Build to fail")
+	}
+	if !first.closed {
+		t.Error("Expected already built sources to be closed on failure")
+	}
+}
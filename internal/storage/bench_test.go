@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// newDryRunStorage creates a dry-run storage that discards its SQL log
+func newDryRunStorage(b *testing.B) *Storage {
+	b.Helper()
+	log := logger.New(logger.INFO)
+	log.SetOutput(io.Discard)
+	s, err := New(context.Background(), Config{TableName: "bench", DryRun: true, Logger: log})
+	if err != nil {
+		b.Fatalf("New() error = %v", err)
+	}
+	return s
+}
+
+func BenchmarkInsertIntoTableDryRun(b *testing.B) {
+	s := newDryRunStorage(b)
+	ctx := context.Background()
+	record := map[string]interface{}{
+		"time":        time.Now(),
+		"device":      "c4:7c:8d:6a:11:22",
+		"temperature": 21.5,
+		"humidity":    40.25,
+		"pressure":    1013.2,
+		"battery":     2.95,
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := s.InsertIntoTable(ctx, "ruuvi_data", record); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkInsertIntoTableDryRunJSON(b *testing.B) {
+	s := newDryRunStorage(b)
+	ctx := context.Background()
+	record := map[string]interface{}{
+		"time":  time.Now(),
+		"topic": "legacy/device",
+		"raw":   `{"a":1,"b":[1,2,3]}`,
+		"json":  map[string]interface{}{"a": 1.0, "b": []interface{}{1.0, 2.0, 3.0}},
+	}
+
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if err := s.InsertIntoTable(ctx, "iot_raw", record); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -3,12 +3,18 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net"
 	"regexp"
 	"sort"
 	"strings"
 
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/marcgeld/hermod/internal/errs"
 	"github.com/marcgeld/hermod/internal/logger"
 )
 
@@ -18,6 +24,7 @@ type Storage struct {
 	tableName string
 	dryRun    bool
 	logger    *logger.Logger
+	types     typeLog // Column types logged per table
 }
 
 // Config holds storage configuration
@@ -85,13 +92,135 @@ func (s *Storage) Insert(ctx context.Context, data map[string]interface{}) error
 
 // InsertIntoTable inserts a record into a specified table
 func (s *Storage) InsertIntoTable(ctx context.Context, tableName string, data map[string]interface{}) error {
+	query, keys, values, err := buildInsert(tableName, data)
+	if err != nil {
+		return err
+	}
+	s.types.observe(s.logger, tableName, keys, data)
+
+	// In dry-run mode, just log the SQL
+	if s.dryRun {
+		s.logger.Infof("SQL (dry-run): %s", query)
+		s.logger.Debugf("SQL Values: %v", values)
+		return nil
+	}
+
+	_, err = s.pool.Exec(ctx, query, values...)
+	if err != nil {
+		return classify(fmt.Errorf("failed to insert record: %w", err))
+	}
+
+	return nil
+}
+
+// InsertBatch inserts several records into a table in a single round trip.
+// The batch runs in one transaction: either every row is inserted or none is.
+func (s *Storage) InsertBatch(ctx context.Context, tableName string, rows []map[string]interface{}) error {
+	batch := &pgx.Batch{}
+	for _, data := range rows {
+		query, keys, values, err := buildInsert(tableName, data)
+		if err != nil {
+			return err
+		}
+		s.types.observe(s.logger, tableName, keys, data)
+		if s.dryRun {
+			s.logger.Infof("SQL (dry-run): %s", query)
+			s.logger.Debugf("SQL Values: %v", values)
+			continue
+		}
+		batch.Queue(query, values...)
+	}
+	if s.dryRun || batch.Len() == 0 {
+		return nil
+	}
+
+	tx, err := s.pool.Begin(ctx)
+	if err != nil {
+		return classify(fmt.Errorf("failed to begin batch: %w", err))
+	}
+	defer tx.Rollback(ctx)
+
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return classify(fmt.Errorf("failed to insert batch: %w", err))
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return classify(fmt.Errorf("failed to commit batch: %w", err))
+	}
+	return nil
+}
+
+// UpsertLatest writes a record into tableName's "<tableName>_latest"
+// companion, replacing the row with the same key unless that row is newer
+// (by its "time" column, when the record has one)
+func (s *Storage) UpsertLatest(ctx context.Context, tableName, key string, data map[string]interface{}) error {
+	query, values, err := buildUpsertLatest(tableName, key, data)
+	if err != nil {
+		return err
+	}
+
+	if s.dryRun {
+		s.logger.Infof("SQL (dry-run): %s", query)
+		s.logger.Debugf("SQL Values: %v", values)
+		return nil
+	}
+
+	if _, err := s.pool.Exec(ctx, query, values...); err != nil {
+		return classify(fmt.Errorf("failed to upsert latest record: %w", err))
+	}
+	return nil
+}
+
+// classify marks errors reaching the database, as opposed to errors the
+// database reported for the statement, as errs.ErrStorageUnavailable.
+// Connection exceptions (class 08), insufficient resources (53) and
+// operator intervention such as a shutdown (57P) count as unreachable,
+// as do failed connection attempts and network errors. Undefined tables
+// (42P01) and columns (42703) are errs.ErrSchemaDrift. Anything else, such
+// as a value pgx can't encode or a processing deadline, is returned as is.
+func classify(err error) error {
+	var pgErr *pgconn.PgError
+	switch {
+	case errors.As(err, &pgErr):
+		code := pgErr.Code
+		if code == "42P01" || code == "42703" {
+			return fmt.Errorf("%w: %w", errs.ErrSchemaDrift, err)
+		}
+		if !strings.HasPrefix(code, "08") && !strings.HasPrefix(code, "53") && !strings.HasPrefix(code, "57P") {
+			return err
+		}
+	case !unreachable(err):
+		return err
+	}
+	return fmt.Errorf("%w: %w", errs.ErrStorageUnavailable, err)
+}
+
+// unreachable reports whether err is a connection failure: a failed
+// connection attempt, an error before the statement was sent, a network
+// error or a connection lost mid-statement. A connection attempt counts
+// even when a deadline cut it short; other cancellations and deadlines
+// don't.
+func unreachable(err error) bool {
+	var connectErr *pgconn.ConnectError
+	if errors.As(err, &connectErr) {
+		return true
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+	var netErr *net.OpError
+	return pgconn.SafeToRetry(err) || errors.As(err, &netErr) || errors.Is(err, io.ErrUnexpectedEOF)
+}
+
+// buildInsert validates a record and builds its INSERT statement, returning
+// it with the record's columns in the sorted order the values follow
+func buildInsert(tableName string, data map[string]interface{}) (string, []string, []interface{}, error) {
 	if len(data) == 0 {
-		return fmt.Errorf("empty data provided")
+		return "", nil, nil, fmt.Errorf("empty data provided")
 	}
 
 	// Validate table name to prevent SQL injection
 	if !validTableName.MatchString(tableName) {
-		return fmt.Errorf("invalid table name '%s': must contain only alphanumeric characters and underscores", tableName)
+		return "", nil, nil, fmt.Errorf("invalid table name '%s': must contain only alphanumeric characters and underscores", tableName)
 	}
 
 	// Sort keys to ensure consistent column ordering
@@ -99,7 +228,7 @@ func (s *Storage) InsertIntoTable(ctx context.Context, tableName string, data ma
 	for key := range data {
 		// Validate column name to prevent SQL injection
 		if !validColumnName.MatchString(key) {
-			return fmt.Errorf("invalid column name '%s': must contain only alphanumeric characters and underscores", key)
+			return "", nil, nil, fmt.Errorf("invalid column name '%s': must contain only alphanumeric characters and underscores", key)
 		}
 		keys = append(keys, key)
 	}
@@ -113,14 +242,14 @@ func (s *Storage) InsertIntoTable(ctx context.Context, tableName string, data ma
 	for i, key := range keys {
 		columns = append(columns, key)
 		placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
-		
+
 		value := data[key]
 		// Convert complex types to JSON
 		switch v := value.(type) {
 		case map[string]interface{}, []interface{}:
 			jsonData, err := json.Marshal(v)
 			if err != nil {
-				return fmt.Errorf("failed to marshal %s to JSON: %w", key, err)
+				return "", nil, nil, fmt.Errorf("failed to marshal %s to JSON: %w", key, err)
 			}
 			values = append(values, jsonData)
 		default:
@@ -134,20 +263,155 @@ func (s *Storage) InsertIntoTable(ctx context.Context, tableName string, data ma
 		strings.Join(columns, ", "),
 		strings.Join(placeholders, ", "),
 	)
+	return query, keys, values, nil
+}
 
-	// In dry-run mode, just log the SQL
+// CreateTableLikeSQL returns the statement creating table with the columns,
+// defaults, constraints and indexes of like, unless it already exists
+func CreateTableLikeSQL(table, like string) (string, error) {
+	for _, name := range []string{table, like} {
+		if !validTableName.MatchString(name) {
+			return "", fmt.Errorf("invalid table name '%s': must contain only alphanumeric characters and underscores", name)
+		}
+	}
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (LIKE %s INCLUDING ALL)", table, like), nil
+}
+
+// buildUpsertLatest builds the INSERT ... ON CONFLICT statement for a
+// "<tableName>_latest" table keyed by key
+func buildUpsertLatest(tableName, key string, data map[string]interface{}) (string, []interface{}, error) {
+	if !validColumnName.MatchString(key) {
+		return "", nil, fmt.Errorf("invalid key column '%s': must contain only alphanumeric characters and underscores", key)
+	}
+	if _, ok := data[key]; !ok {
+		return "", nil, fmt.Errorf("record has no key column '%s'", key)
+	}
+	latest := tableName + "_latest"
+	insert, _, values, err := buildInsert(latest, data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	keys := make([]string, 0, len(data))
+	for col := range data {
+		if col != key {
+			keys = append(keys, col)
+		}
+	}
+	sort.Strings(keys)
+
+	var sb strings.Builder
+	sb.WriteString(insert)
+	fmt.Fprintf(&sb, " ON CONFLICT (%s) DO ", key)
+	if len(keys) == 0 {
+		sb.WriteString("NOTHING")
+		return sb.String(), values, nil
+	}
+	sb.WriteString("UPDATE SET ")
+	for i, col := range keys {
+		if i > 0 {
+			sb.WriteString(", ")
+		}
+		fmt.Fprintf(&sb, "%s = EXCLUDED.%s", col, col)
+	}
+	if _, ok := data["time"]; ok {
+		fmt.Fprintf(&sb, " WHERE %s.time <= EXCLUDED.time", latest)
+	}
+	return sb.String(), values, nil
+}
+
+// Ping checks that the database is reachable
+func (s *Storage) Ping(ctx context.Context) error {
+	if s.dryRun {
+		return nil
+	}
+	if err := s.pool.Ping(ctx); err != nil {
+		return classify(fmt.Errorf("failed to ping database: %w", err))
+	}
+	return nil
+}
+
+// Exec runs a statement with arguments (logged instead of executed in dry-run mode)
+func (s *Storage) Exec(ctx context.Context, query string, args ...interface{}) error {
 	if s.dryRun {
 		s.logger.Infof("SQL (dry-run): %s", query)
-		s.logger.Debugf("SQL Values: %v", values)
+		s.logger.Debugf("SQL Values: %v", args)
 		return nil
 	}
+	if _, err := s.pool.Exec(ctx, query, args...); err != nil {
+		return classify(fmt.Errorf("failed to execute statement: %w", err))
+	}
+	return nil
+}
 
-	_, err := s.pool.Exec(ctx, query, values...)
+// QueryRows runs a read-only query and returns the column names and rows
+func (s *Storage) QueryRows(ctx context.Context, query string) ([]string, []map[string]interface{}, error) {
+	if s.pool == nil {
+		return nil, nil, fmt.Errorf("no database connection (dry-run mode)")
+	}
+
+	rows, err := s.pool.Query(ctx, query)
 	if err != nil {
-		return fmt.Errorf("failed to insert record: %w", err)
+		return nil, nil, fmt.Errorf("failed to run query: %w", err)
 	}
+	defer rows.Close()
 
-	return nil
+	fields := rows.FieldDescriptions()
+	columns := make([]string, len(fields))
+	for i, f := range fields {
+		columns[i] = f.Name
+	}
+
+	var result []map[string]interface{}
+	for rows.Next() {
+		values, err := rows.Values()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read row: %w", err)
+		}
+		row := make(map[string]interface{}, len(columns))
+		for i, col := range columns {
+			row[col] = values[i]
+		}
+		result = append(result, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, fmt.Errorf("failed to read rows: %w", err)
+	}
+	return columns, result, nil
+}
+
+// TableColumns returns the columns of table as resolved by the search path,
+// or nil when the table doesn't exist
+func (s *Storage) TableColumns(ctx context.Context, table string) ([]string, error) {
+	if s.pool == nil {
+		return nil, fmt.Errorf("no database connection (dry-run mode)")
+	}
+	var exists bool
+	if err := s.pool.QueryRow(ctx, "SELECT to_regclass($1) IS NOT NULL", table).Scan(&exists); err != nil {
+		return nil, classify(fmt.Errorf("failed to look up table %s: %w", table, err))
+	}
+	if !exists {
+		return nil, nil
+	}
+
+	rows, err := s.pool.Query(ctx,
+		"SELECT attname FROM pg_attribute WHERE attrelid = to_regclass($1) AND attnum > 0 AND NOT attisdropped", table)
+	if err != nil {
+		return nil, classify(fmt.Errorf("failed to read columns of %s: %w", table, err))
+	}
+	defer rows.Close()
+	columns := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("failed to read columns of %s: %w", table, err)
+		}
+		columns = append(columns, name)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, classify(fmt.Errorf("failed to read columns of %s: %w", table, err))
+	}
+	return columns, nil
 }
 
 // Close closes the database connection pool
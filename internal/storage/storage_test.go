@@ -1,7 +1,17 @@
 package storage
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"syscall"
 	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/marcgeld/hermod/internal/errs"
 )
 
 func TestValidTableName(t *testing.T) {
@@ -248,3 +258,89 @@ func TestTableNameValidationInNew(t *testing.T) {
 		}
 	}
 }
+
+func TestClassify(t *testing.T) {
+	// The error pgx returns for a value a script produced in the wrong type
+	_, encodeErr := pgtype.NewMap().Encode(pgtype.Float8OID, pgtype.BinaryFormatCode, "warm", nil)
+	if encodeErr == nil {
+		t.Fatal("Expected encoding a string as float8 to fail")
+	}
+
+	tests := []struct {
+		err         error
+		unavailable bool
+		drift       bool
+	}{
+		{&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}, true, false},
+		{&net.OpError{Op: "read", Net: "tcp", Err: syscall.ECONNRESET}, true, false},
+		{io.ErrUnexpectedEOF, true, false},
+		{encodeErr, false, false},
+		{errors.New("cannot use string as float64"), false, false},
+		{context.DeadlineExceeded, false, false},
+		{fmt.Errorf("timeout: %w", context.DeadlineExceeded), false, false},
+		{&pgconn.PgError{Code: "08006"}, true, false},  // connection_failure
+		{&pgconn.PgError{Code: "57P01"}, true, false},  // admin_shutdown
+		{&pgconn.PgError{Code: "53300"}, true, false},  // too_many_connections
+		{&pgconn.PgError{Code: "23505"}, false, false}, // unique_violation
+		{&pgconn.PgError{Code: "42P01"}, false, true},  // undefined_table
+		{&pgconn.PgError{Code: "42703"}, false, true},  // undefined_column
+		{context.Canceled, false, false},
+	}
+	for _, tt := range tests {
+		err := classify(fmt.Errorf("failed to insert record: %w", tt.err))
+		if got := errors.Is(err, errs.ErrStorageUnavailable); got != tt.unavailable {
+			t.Errorf("classify(%v) unavailable = %v, want %v", tt.err, got, tt.unavailable)
+		}
+		if got := errors.Is(err, errs.ErrSchemaDrift); got != tt.drift {
+			t.Errorf("classify(%v) drift = %v, want %v", tt.err, got, tt.drift)
+		}
+	}
+}
+
+func TestBuildUpsertLatest(t *testing.T) {
+	data := map[string]interface{}{"time": "2025-01-01T00:00:00Z", "device": "a1", "temp": 21.5}
+	query, values, err := buildUpsertLatest("readings", "device", data)
+	if err != nil {
+		t.Fatalf("buildUpsertLatest failed: %v", err)
+	}
+	want := "INSERT INTO readings_latest (device, temp, time) VALUES ($1, $2, $3)" +
+		" ON CONFLICT (device) DO UPDATE SET temp = EXCLUDED.temp, time = EXCLUDED.time" +
+		" WHERE readings_latest.time <= EXCLUDED.time"
+	if query != want {
+		t.Errorf("query =\n%s\nwant\n%s", query, want)
+	}
+	if len(values) != 3 || values[0] != "a1" {
+		t.Errorf("Unexpected values %v", values)
+	}
+
+	// Without a time column every write wins
+	query, _, _ = buildUpsertLatest("readings", "device", map[string]interface{}{"device": "a1", "temp": 1.0})
+	if want := "INSERT INTO readings_latest (device, temp) VALUES ($1, $2) ON CONFLICT (device) DO UPDATE SET temp = EXCLUDED.temp"; query != want {
+		t.Errorf("query = %s, want %s", query, want)
+	}
+	query, _, _ = buildUpsertLatest("readings", "device", map[string]interface{}{"device": "a1"})
+	if want := "INSERT INTO readings_latest (device) VALUES ($1) ON CONFLICT (device) DO NOTHING"; query != want {
+		t.Errorf("query = %s, want %s", query, want)
+	}
+
+	for _, key := range []string{"missing", "bad key"} {
+		if _, _, err := buildUpsertLatest("readings", key, data); err == nil {
+			t.Errorf("Expected error for key %q", key)
+		}
+	}
+}
+
+func TestCreateTableLikeSQL(t *testing.T) {
+	query, err := CreateTableLikeSQL("readings_2024_06", "readings")
+	if err != nil {
+		t.Fatalf("CreateTableLikeSQL failed: %v", err)
+	}
+	if want := "CREATE TABLE IF NOT EXISTS readings_2024_06 (LIKE readings INCLUDING ALL)"; query != want {
+		t.Errorf("query = %s, want %s", query, want)
+	}
+	for _, names := range [][2]string{{"bad table", "readings"}, {"readings_2024", "readings; DROP"}} {
+		if _, err := CreateTableLikeSQL(names[0], names[1]); err == nil {
+			t.Errorf("Expected error for %v", names)
+		}
+	}
+}
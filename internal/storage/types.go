@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+// maxTypeSignatures bounds how many column type combinations are logged per
+// table, so records with optional columns don't flood the log
+const maxTypeSignatures = 8
+
+// typeLog logs the Go type each column of a table is inserted as, on the
+// first insert into the table and again for every new combination of
+// columns and types (such as after a script reload), so a mismatch like a
+// string sent to a double precision column shows up in the log next to
+// pgx's error instead of having to be reconstructed from it
+type typeLog struct {
+	done sync.Map // Tables whose maxTypeSignatures were logged, checked before mu
+	mu   sync.Mutex
+	seen map[string]map[string]bool // Table -> logged signatures
+}
+
+// observe logs the types of data's columns, keys in sorted order, unless
+// this combination was logged for table
+func (t *typeLog) observe(log *logger.Logger, table string, keys []string, data map[string]interface{}) {
+	if !log.Enabled(logger.INFO) {
+		return
+	}
+	if _, ok := t.done.Load(table); ok {
+		return
+	}
+	sig := typeSignature(keys, data)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.seen == nil {
+		t.seen = make(map[string]map[string]bool)
+	}
+	seen := t.seen[table]
+	if seen[sig] || len(seen) >= maxTypeSignatures {
+		return
+	}
+	if seen == nil {
+		seen = make(map[string]bool)
+		t.seen[table] = seen
+		log.Infof("First insert into %s: %s", table, sig)
+	} else {
+		log.Infof("New column types for %s: %s", table, sig)
+	}
+	seen[sig] = true
+	if len(seen) == maxTypeSignatures {
+		log.Infof("Logged %d column type combinations for %s; not logging more", maxTypeSignatures, table)
+		t.done.Store(table, true)
+	}
+}
+
+// typeSignature describes data's columns as "column=type" in the order of
+// keys, using the types the values are sent to PostgreSQL as
+func typeSignature(keys []string, data map[string]interface{}) string {
+	var b strings.Builder
+	for i, key := range keys {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(key)
+		b.WriteByte('=')
+		switch v := data[key].(type) {
+		case nil:
+			b.WriteString("null")
+		case map[string]interface{}, []interface{}:
+			b.WriteString("json")
+		default:
+			fmt.Fprintf(&b, "%T", v)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,61 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+)
+
+func TestTypeSignature(t *testing.T) {
+	keys := []string{"gap", "id", "meta", "raw", "time", "value"}
+	got := typeSignature(keys, map[string]interface{}{
+		"value": 21.5,
+		"time":  time.Time{},
+		"id":    int64(7),
+		"raw":   "x",
+		"meta":  map[string]interface{}{"a": 1},
+		"gap":   nil,
+	})
+	want := "gap=null, id=int64, meta=json, raw=string, time=time.Time, value=float64"
+	if got != want {
+		t.Errorf("typeSignature() = %q, want %q", got, want)
+	}
+}
+
+func TestTypeLogOnFirstInsert(t *testing.T) {
+	var buf bytes.Buffer
+	log := logger.New(logger.INFO)
+	log.SetOutput(&buf)
+	s, err := New(context.Background(), Config{TableName: "readings", DryRun: true, Logger: log})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	ctx := context.Background()
+
+	s.InsertIntoTable(ctx, "readings", map[string]interface{}{"value": 21.5})
+	s.InsertIntoTable(ctx, "readings", map[string]interface{}{"value": 22.0})
+	s.InsertBatch(ctx, "readings", []map[string]interface{}{{"value": "21.5"}})
+	if n := strings.Count(buf.String(), "First insert into readings: value=float64"); n != 1 {
+		t.Errorf("Expected one first-insert line, got %d:\n%s", n, buf.String())
+	}
+	if !strings.Contains(buf.String(), "New column types for readings: value=string") {
+		t.Errorf("Expected new column types logged:\n%s", buf.String())
+	}
+
+	// Logging stops after maxTypeSignatures combinations per table
+	buf.Reset()
+	for i := 0; i < 2*maxTypeSignatures; i++ {
+		s.InsertIntoTable(ctx, "readings", map[string]interface{}{fmt.Sprintf("c%d", i): 1.0})
+	}
+	if n := strings.Count(buf.String(), "New column types for readings"); n != maxTypeSignatures-2 {
+		t.Errorf("Expected %d new column type lines, got %d", maxTypeSignatures-2, n)
+	}
+	if _, ok := s.types.done.Load("readings"); !ok {
+		t.Error("Expected readings marked done once its combinations were logged")
+	}
+}
@@ -0,0 +1,239 @@
+package tail
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/logger"
+	"github.com/marcgeld/hermod/internal/router"
+)
+
+// defaultPollInterval is how often the file is checked for new data and rotation.
+const defaultPollInterval = 500 * time.Millisecond
+
+// defaultMaxLineSize bounds a single line when none is configured.
+const defaultMaxLineSize = 1 << 20
+
+// Config holds file tail configuration.
+type Config struct {
+	Path         string        // File to follow
+	Topic        string        // Synthetic topic for produced messages (default: "file/<basename>")
+	FromStart    bool          // Read existing content instead of starting at the end
+	PollInterval time.Duration // How often to poll for new data (default: 500ms)
+	MaxLineSize  int           // Longer lines are dropped with a warning (default: 1 MiB)
+	Logger       *logger.Logger
+}
+
+// Tailer follows a file, handling truncation and rotation, and emits each line.
+type Tailer struct {
+	cfg    Config
+	topic  string
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	logger *logger.Logger
+}
+
+// New creates a new file tailer.
+func New(cfg Config) (*Tailer, error) {
+	log := cfg.Logger
+	if log == nil {
+		log = logger.New(logger.INFO)
+	}
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("tail path is required")
+	}
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = defaultPollInterval
+	}
+	if cfg.MaxLineSize <= 0 {
+		cfg.MaxLineSize = defaultMaxLineSize
+	}
+
+	topic := cfg.Topic
+	if topic == "" {
+		base := filepath.Base(cfg.Path)
+		topic = "file/" + strings.TrimSuffix(base, filepath.Ext(base))
+	}
+
+	return &Tailer{
+		cfg:    cfg,
+		topic:  topic,
+		logger: log,
+	}, nil
+}
+
+// Topic returns the synthetic topic used for produced messages.
+func (t *Tailer) Topic() string {
+	return t.topic
+}
+
+// Start begins following the file in the background, dispatching every line as a message.
+func (t *Tailer) Start(ctx context.Context, dispatch func(router.Message) error) error {
+	ctx, t.cancel = context.WithCancel(ctx)
+	t.wg.Add(1)
+	go t.run(ctx, dispatch)
+	t.logger.Infof("Tailing file %s (topic=%s)", t.cfg.Path, t.topic)
+	return nil
+}
+
+// Close stops the tailer and waits for it to finish.
+func (t *Tailer) Close() {
+	if t.cancel != nil {
+		t.cancel()
+	}
+	t.wg.Wait()
+	t.logger.Infof("Stopped tailing file %s", t.cfg.Path)
+}
+
+// run is the tailer main loop.
+func (t *Tailer) run(ctx context.Context, dispatch func(router.Message) error) {
+	defer t.wg.Done()
+
+	var (
+		f       *os.File
+		info    os.FileInfo
+		reader  *bufio.Reader
+		offset  int64
+		partial []byte
+		drop    bool
+		first   = true
+	)
+	defer func() {
+		if f != nil {
+			f.Close()
+		}
+	}()
+
+	ticker := time.NewTicker(t.cfg.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		if f == nil {
+			var err error
+			f, info, err = t.open(first)
+			if err != nil {
+				if !errors.Is(err, os.ErrNotExist) {
+					t.logger.Errorf("Failed to open %s: %v", t.cfg.Path, err)
+				} else {
+					// A file created after startup holds only new lines
+					first = false
+				}
+			} else {
+				offset, _ = f.Seek(0, io.SeekCurrent)
+				reader = bufio.NewReader(f)
+				partial, drop = nil, false
+				first = false
+			}
+		}
+
+		if f != nil {
+			n, rest, dropping := t.readLines(reader, partial, drop, dispatch)
+			offset += n
+			partial, drop = rest, dropping
+
+			// Check for truncation or rotation once we've caught up
+			if cur, err := os.Stat(t.cfg.Path); err == nil {
+				switch {
+				case !os.SameFile(info, cur):
+					t.logger.Infof("File %s rotated, reopening", t.cfg.Path)
+					// Drain anything written to the old file before the rotation
+					n, rest, _ := t.readLines(reader, partial, drop, dispatch)
+					offset += n
+					t.flush(rest, dispatch)
+					f.Close()
+					f = nil
+					continue
+				case cur.Size() < offset:
+					t.logger.Infof("File %s truncated, reading from start", t.cfg.Path)
+					if _, err := f.Seek(0, io.SeekStart); err == nil {
+						reader.Reset(f)
+						offset = 0
+						partial, drop = nil, false
+					}
+				}
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// open opens the configured file. If it existed at startup, the first open
+// moves to the end unless FromStart is set; files created later and
+// reopened (rotated) files start at 0.
+func (t *Tailer) open(first bool) (*os.File, os.FileInfo, error) {
+	f, err := os.Open(t.cfg.Path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	if first && !t.cfg.FromStart {
+		if _, err := f.Seek(0, io.SeekEnd); err != nil {
+			f.Close()
+			return nil, nil, err
+		}
+	}
+	return f, info, nil
+}
+
+// readLines reads all complete lines currently available and dispatches them.
+// It returns the number of bytes consumed, any trailing partial line and
+// whether the rest of that line is being dropped. A line growing past
+// MaxLineSize is dropped with a warning instead of being buffered, and drop
+// continues dropping a line found too long by an earlier call.
+func (t *Tailer) readLines(r *bufio.Reader, partial []byte, drop bool, dispatch func(router.Message) error) (int64, []byte, bool) {
+	var n int64
+	for {
+		chunk, err := r.ReadSlice('\n')
+		n += int64(len(chunk))
+		if !drop {
+			if len(partial)+len(bytes.TrimRight(chunk, "\r\n")) > t.cfg.MaxLineSize {
+				t.logger.Warnf("Dropping line in %s longer than %d bytes", t.cfg.Path, t.cfg.MaxLineSize)
+				partial, drop = nil, true
+			} else {
+				partial = append(partial, chunk...)
+			}
+		}
+		if errors.Is(err, bufio.ErrBufferFull) {
+			continue
+		}
+		if err != nil {
+			return n, partial, drop
+		}
+		if !drop {
+			t.flush(partial, dispatch)
+		}
+		partial, drop = nil, false
+	}
+}
+
+// flush dispatches a single line, stripping the line terminator.
+func (t *Tailer) flush(line []byte, dispatch func(router.Message) error) {
+	line = bytes.TrimRight(line, "\r\n")
+	if len(line) == 0 {
+		return
+	}
+	dispatch(router.Message{
+		Topic:   t.topic,
+		Payload: line,
+		Time:    time.Now().UTC(),
+		Source:  "file://" + t.cfg.Path,
+	})
+}
@@ -0,0 +1,199 @@
+package tail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/router"
+)
+
+// collector gathers handler calls for assertions
+type collector struct {
+	mu    sync.Mutex
+	lines []string
+	topic string
+}
+
+func (c *collector) dispatch(msg router.Message) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.topic = msg.Topic
+	c.lines = append(c.lines, string(msg.Payload))
+	return nil
+}
+
+func (c *collector) waitFor(t *testing.T, n int) []string {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		if len(c.lines) >= n {
+			lines := append([]string(nil), c.lines...)
+			c.mu.Unlock()
+			return lines
+		}
+		c.mu.Unlock()
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("Timed out waiting for %d lines, got %v", n, c.lines)
+	return nil
+}
+
+func appendFile(t *testing.T, path, data string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatalf("failed to write file: %v", err)
+	}
+}
+
+func TestDefaultTopic(t *testing.T) {
+	tl, err := New(Config{Path: "/var/log/gateway.ndjson"})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	if tl.Topic() != "file/gateway" {
+		t.Errorf("Expected topic 'file/gateway', got %q", tl.Topic())
+	}
+
+	if _, err := New(Config{}); err == nil {
+		t.Error("Expected error for empty path")
+	}
+}
+
+func TestTailFromStartAndAppend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+	appendFile(t, path, "line1\nline2\n")
+
+	tl, err := New(Config{Path: path, Topic: "logs/data", FromStart: true, PollInterval: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+	c := &collector{}
+	tl.Start(context.Background(), c.dispatch)
+	defer tl.Close()
+
+	c.waitFor(t, 2)
+
+	// Partial line is held until its newline arrives
+	appendFile(t, path, "line3")
+	time.Sleep(50 * time.Millisecond)
+	appendFile(t, path, "-end\n")
+
+	lines := c.waitFor(t, 3)
+	want := []string{"line1", "line2", "line3-end"}
+	for i := range want {
+		if lines[i] != want[i] {
+			t.Errorf("Line %d = %q, want %q", i, lines[i], want[i])
+		}
+	}
+	if c.topic != "logs/data" {
+		t.Errorf("Expected topic 'logs/data', got %q", c.topic)
+	}
+}
+
+func TestTailSkipsExistingContent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+	appendFile(t, path, "old\n")
+
+	tl, _ := New(Config{Path: path, PollInterval: 10 * time.Millisecond})
+	c := &collector{}
+	tl.Start(context.Background(), c.dispatch)
+	defer tl.Close()
+
+	time.Sleep(50 * time.Millisecond)
+	appendFile(t, path, "new\n")
+
+	lines := c.waitFor(t, 1)
+	if lines[0] != "new" {
+		t.Errorf("Expected 'new', got %q", lines[0])
+	}
+}
+
+func TestTailRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "data.log")
+	appendFile(t, path, "before\n")
+
+	tl, _ := New(Config{Path: path, FromStart: true, PollInterval: 10 * time.Millisecond})
+	c := &collector{}
+	tl.Start(context.Background(), c.dispatch)
+	defer tl.Close()
+
+	c.waitFor(t, 1)
+
+	if err := os.Rename(path, filepath.Join(dir, "data.log.1")); err != nil {
+		t.Fatalf("rename failed: %v", err)
+	}
+	appendFile(t, path, "after\n")
+
+	lines := c.waitFor(t, 2)
+	if lines[1] != "after" {
+		t.Errorf("Expected 'after' from rotated file, got %q", lines[1])
+	}
+}
+
+func TestTailTruncation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+	appendFile(t, path, "a fairly long first line\n")
+
+	tl, _ := New(Config{Path: path, FromStart: true, PollInterval: 10 * time.Millisecond})
+	c := &collector{}
+	tl.Start(context.Background(), c.dispatch)
+	defer tl.Close()
+
+	c.waitFor(t, 1)
+
+	if err := os.WriteFile(path, []byte("short\n"), 0644); err != nil {
+		t.Fatalf("truncate failed: %v", err)
+	}
+
+	lines := c.waitFor(t, 2)
+	if lines[1] != "short" {
+		t.Errorf("Expected 'short' after truncation, got %q", lines[1])
+	}
+}
+
+func TestTailDropsLongLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+	appendFile(t, path, "ok\n"+strings.Repeat("x", 10000))
+
+	tl, _ := New(Config{Path: path, FromStart: true, MaxLineSize: 100, PollInterval: 10 * time.Millisecond})
+	c := &collector{}
+	tl.Start(context.Background(), c.dispatch)
+	defer tl.Close()
+
+	c.waitFor(t, 1)
+	appendFile(t, path, strings.Repeat("y", 100)+"\nnext\n")
+
+	lines := c.waitFor(t, 2)
+	if lines[1] != "next" {
+		t.Errorf("Expected the long line to be dropped, got %q", lines[1])
+	}
+}
+
+func TestTailFileCreatedAfterStart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.log")
+
+	tl, _ := New(Config{Path: path, PollInterval: 10 * time.Millisecond})
+	c := &collector{}
+	tl.Start(context.Background(), c.dispatch)
+	defer tl.Close()
+
+	time.Sleep(30 * time.Millisecond)
+	appendFile(t, path, "first\n")
+
+	lines := c.waitFor(t, 1)
+	if lines[0] != "first" {
+		t.Errorf("Expected 'first' from a file created after start, got %q", lines[0])
+	}
+}
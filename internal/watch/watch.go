@@ -0,0 +1,129 @@
+// Package watch broadcasts stored records to live subscribers, such as
+// the admin API's /tail WebSocket, so transformed output can be watched
+// while commissioning without polling the database.
+package watch
+
+import (
+	"context"
+	"maps"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/marcgeld/hermod/internal/sink"
+)
+
+// bufferSize is how many records a subscriber may lag behind before
+// records are dropped for it
+const bufferSize = 64
+
+// Record is a stored record as sent to subscribers
+type Record struct {
+	Table   string                 `json:"table"`
+	Time    time.Time              `json:"time"` // When it was stored
+	Columns map[string]interface{} `json:"columns"`
+}
+
+// Hub fans stored records out to subscribers
+type Hub struct {
+	mu   sync.RWMutex
+	subs map[*subscriber]struct{}
+	now  func() time.Time
+}
+
+// subscriber receives the records of one table
+type subscriber struct {
+	table   string
+	every   int64 // Send one record in this many
+	seen    atomic.Int64
+	dropped atomic.Int64
+	ch      chan Record
+}
+
+// New creates a hub without subscribers
+func New() *Hub {
+	return &Hub{subs: make(map[*subscriber]struct{}), now: time.Now}
+}
+
+// Subscribe returns a channel receiving one in every records stored into
+// table, and a function ending the subscription (which closes the channel).
+// Records are dropped while the subscriber lags behind.
+func (h *Hub) Subscribe(table string, every int) (<-chan Record, func()) {
+	if every < 1 {
+		every = 1
+	}
+	sub := &subscriber{table: table, every: int64(every), ch: make(chan Record, bufferSize)}
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	h.mu.Unlock()
+
+	var once sync.Once
+	return sub.ch, func() {
+		once.Do(func() {
+			h.mu.Lock()
+			delete(h.subs, sub)
+			h.mu.Unlock()
+			close(sub.ch)
+		})
+	}
+}
+
+// Storage returns a stage in front of next that broadcasts every record
+// next stores
+func (h *Hub) Storage(next sink.Storage) *Stage {
+	return &Stage{hub: h, next: next}
+}
+
+// publish sends a stored record to the table's subscribers
+func (h *Hub) publish(table string, data map[string]interface{}) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if len(h.subs) == 0 {
+		return
+	}
+	var rec *Record
+	for sub := range h.subs {
+		if sub.table != table || (sub.seen.Add(1)-1)%sub.every != 0 {
+			continue
+		}
+		if rec == nil {
+			// Copied, as the caller may reuse the map
+			rec = &Record{Table: table, Time: h.now().UTC(), Columns: maps.Clone(data)}
+		}
+		select {
+		case sub.ch <- *rec:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Stage is the broadcasting storage stage returned by Hub.Storage
+type Stage struct {
+	hub  *Hub
+	next sink.Storage
+}
+
+// InsertIntoTable stores the record and broadcasts it
+func (s *Stage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	if err := s.next.InsertIntoTable(ctx, table, data); err != nil {
+		return err
+	}
+	s.hub.publish(table, data)
+	return nil
+}
+
+// InsertBatch stores the rows and broadcasts them
+func (s *Stage) InsertBatch(ctx context.Context, table string, rows []map[string]interface{}) error {
+	bs, ok := s.next.(sink.BatchStorage)
+	if !ok {
+		return sink.InsertEach(ctx, s, table, rows)
+	}
+	if err := bs.InsertBatch(ctx, table, rows); err != nil {
+		return err
+	}
+	for _, data := range rows {
+		s.hub.publish(table, data)
+	}
+	return nil
+}
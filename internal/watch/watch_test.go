@@ -0,0 +1,74 @@
+package watch
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type memStorage struct {
+	fail bool
+}
+
+func (m *memStorage) InsertIntoTable(ctx context.Context, table string, data map[string]interface{}) error {
+	if m.fail {
+		return errors.New("insert failed")
+	}
+	return nil
+}
+
+func TestHubBroadcastsStoredRecords(t *testing.T) {
+	hub := New()
+	next := &memStorage{}
+	stage := hub.Storage(next)
+	ctx := context.Background()
+
+	all, stopAll := hub.Subscribe("readings", 1)
+	sampled, stopSampled := hub.Subscribe("readings", 2)
+	defer stopSampled()
+
+	for i := 0; i < 4; i++ {
+		stage.InsertIntoTable(ctx, "readings", map[string]interface{}{"i": i})
+	}
+	stage.InsertIntoTable(ctx, "other", map[string]interface{}{"i": 9})
+	next.fail = true
+	stage.InsertIntoTable(ctx, "readings", map[string]interface{}{"i": 5})
+
+	if len(all) != 4 || len(sampled) != 2 {
+		t.Fatalf("all=%d sampled=%d, want 4 and 2", len(all), len(sampled))
+	}
+	first := <-sampled
+	second := <-sampled
+	if first.Columns["i"] != 0 || second.Columns["i"] != 2 || first.Table != "readings" {
+		t.Errorf("Unexpected sampled records: %+v %+v", first, second)
+	}
+
+	stopAll()
+	stopAll() // Idempotent
+	for range all {
+	}
+	next.fail = false
+	stage.InsertIntoTable(ctx, "readings", map[string]interface{}{"i": 6}) // No panic on the closed channel
+}
+
+func TestHubDropsForSlowSubscribers(t *testing.T) {
+	hub := New()
+	ch, stop := hub.Subscribe("readings", 0)
+	defer stop()
+	stage := hub.Storage(&memStorage{})
+	rows := make([]map[string]interface{}, bufferSize+10)
+	for i := range rows {
+		rows[i] = map[string]interface{}{"i": i}
+	}
+	if err := stage.InsertBatch(context.Background(), "readings", rows); err != nil {
+		t.Fatalf("InsertBatch failed: %v", err)
+	}
+	if len(ch) != bufferSize {
+		t.Errorf("Expected a full buffer, got %d", len(ch))
+	}
+	for sub := range hub.subs {
+		if sub.dropped.Load() != 10 {
+			t.Errorf("dropped = %d, want 10", sub.dropped.Load())
+		}
+	}
+}